@@ -0,0 +1,194 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// adminPolicy is a limit policy in the response of the admin API's
+// /policies endpoint.
+type adminPolicy struct {
+	Resource     string       `json:"resource"`
+	Action       string       `json:"action"`
+	PolicyHeader string       `json:"policy_header,omitempty"`
+	Limits       []adminLimit `json:"limits"`
+}
+
+// adminLimit is a limit of an adminPolicy. Period is a duration such as
+// "1m0s".
+type adminLimit struct {
+	Per           LimitPer `json:"per"`
+	Unlimited     bool     `json:"unlimited,omitempty"`
+	MaxRequests   uint64   `json:"max_requests,omitempty"`
+	Period        string   `json:"period,omitempty"`
+	GraceRequests uint64   `json:"grace_requests,omitempty"`
+	Pool          string   `json:"pool,omitempty"`
+}
+
+// adminQuota is a quota in the response of the admin API's /quotas endpoint.
+type adminQuota struct {
+	MaxRequests uint64    `json:"max_requests"`
+	Remaining   uint64    `json:"remaining"`
+	GraceUsed   uint64    `json:"grace_used"`
+	ResetsAt    time.Time `json:"resets_at"`
+}
+
+// adminShadowMode is the request and response of the admin API's /shadow
+// endpoint.
+type adminShadowMode struct {
+	Enabled bool `json:"enabled"`
+}
+
+// adminError is the response of the admin API when a request fails.
+type adminError struct {
+	Error string `json:"error"`
+}
+
+// AdminHandler returns an http.Handler that provides an admin API for
+// inspecting and managing the Limiter. Every request is first handled by
+// auth, which wraps the API and must only pass requests that are authorized
+// to use it to the wrapped handler. Since the API can reset quotas and stop
+// the limits from being enforced, auth is required, and an
+// ErrInvalidParameter is returned if it is nil.
+//
+// The paths of the endpoints are relative to the path that the handler is
+// mounted at, such as via http.StripPrefix, and their responses are JSON:
+//   - GET /policies: Lists the limit policies and their limits.
+//   - GET /quotas?resource=&action=&ip=&auth_token=: Returns the quotas that
+//     a request with the IP address and auth token would use, by their
+//     LimitPer, as with ReadOnlyLimiter.Quotas.
+//   - POST /quotas/reset?resource=&action=&per=&id=: Resets a quota, as with
+//     Limiter.ResetQuota.
+//   - GET /shadow: Returns whether the Limiter is in shadow mode, such as
+//     {"enabled": false}.
+//   - PUT /shadow: Sets whether the Limiter is in shadow mode, from a body
+//     such as {"enabled": true}, as with Limiter.SetShadowMode.
+func (l *Limiter) AdminHandler(auth func(http.Handler) http.Handler) (http.Handler, error) {
+	const op = "rate.(Limiter).AdminHandler"
+	if auth == nil {
+		return nil, fmt.Errorf("%s: missing auth: %w", op, ErrInvalidParameter)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/policies", adminMethod(http.MethodGet, l.adminPolicies))
+	mux.Handle("/quotas", adminMethod(http.MethodGet, l.adminQuotas))
+	mux.Handle("/quotas/reset", adminMethod(http.MethodPost, l.adminResetQuota))
+	mux.HandleFunc("/shadow", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeAdminJSON(w, http.StatusOK, adminShadowMode{Enabled: l.ShadowMode()})
+		case http.MethodPut:
+			var m adminShadowMode
+			if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+				writeAdminError(w, fmt.Errorf("invalid body: %s: %w", err, ErrInvalidParameter))
+				return
+			}
+			l.SetShadowMode(m.Enabled)
+			writeAdminJSON(w, http.StatusOK, m)
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			writeAdminJSON(w, http.StatusMethodNotAllowed, adminError{Error: "method not allowed"})
+		}
+	})
+	return auth(mux), nil
+}
+
+// adminMethod returns a handler that calls fn for requests with the method,
+// and responds with a 405 Method Not Allowed to others.
+func adminMethod(method string, fn http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeAdminJSON(w, http.StatusMethodNotAllowed, adminError{Error: "method not allowed"})
+			return
+		}
+		fn(w, r)
+	})
+}
+
+func (l *Limiter) adminPolicies(w http.ResponseWriter, _ *http.Request) {
+	policies := l.policies.Load()
+	resp := make([]adminPolicy, 0, len(policies.m))
+	for _, p := range policies.m {
+		ap := adminPolicy{Resource: p.resource, Action: p.action, PolicyHeader: p.policy}
+		for _, per := range requiredLimitPer {
+			switch ll := p.m[per].(type) {
+			case *Unlimited:
+				ap.Limits = append(ap.Limits, adminLimit{Per: per, Unlimited: true})
+			case *Limited:
+				ap.Limits = append(ap.Limits, adminLimit{
+					Per:           per,
+					MaxRequests:   ll.MaxRequests,
+					Period:        ll.Period.String(),
+					GraceRequests: ll.GraceRequests,
+					Pool:          ll.Pool,
+				})
+			}
+		}
+		resp = append(resp, ap)
+	}
+	sort.Slice(resp, func(i, j int) bool {
+		if resp[i].Resource != resp[j].Resource {
+			return resp[i].Resource < resp[j].Resource
+		}
+		return resp[i].Action < resp[j].Action
+	})
+	writeAdminJSON(w, http.StatusOK, resp)
+}
+
+func (l *Limiter) adminQuotas(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	quotas, err := l.ReadOnly().Quotas(q.Get("resource"), q.Get("action"), q.Get("ip"), q.Get("auth_token"))
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	resp := make(map[LimitPer]adminQuota, len(quotas))
+	for per, quota := range quotas {
+		resp[per] = adminQuota{
+			MaxRequests: quota.MaxRequests(),
+			Remaining:   quota.Remaining(),
+			GraceUsed:   quota.GraceUsed(),
+			ResetsAt:    quota.Expiration(),
+		}
+	}
+	writeAdminJSON(w, http.StatusOK, resp)
+}
+
+func (l *Limiter) adminResetQuota(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if err := l.ResetQuota(q.Get("resource"), q.Get("action"), LimitPer(q.Get("per")), q.Get("id")); err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeAdminError writes err as the response, with a status code for the
+// error.
+func writeAdminError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrLimitPolicyNotFound), errors.Is(err, ErrLimitNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrInvalidParameter), errors.Is(err, ErrInvalidLimitPer), errors.Is(err, ErrInvalidIPAddress):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrNotSupported):
+		status = http.StatusNotImplemented
+	}
+	writeAdminJSON(w, status, adminError{Error: err.Error()})
+}
+
+// writeAdminJSON writes v as the JSON response with the status code.
+func writeAdminJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
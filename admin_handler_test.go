@@ -0,0 +1,138 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterAdminHandler(t *testing.T) {
+	c := newFakeClock()
+	l, err := NewLimiter(
+		[]Limit{
+			&Limited{Resource: "resource", Action: "action", Per: LimitPerTotal, MaxRequests: 100, Period: time.Minute},
+			&Limited{Resource: "resource", Action: "action", Per: LimitPerIPAddress, MaxRequests: 2, Period: time.Minute, GraceRequests: 1},
+			&Unlimited{Resource: "resource", Action: "action", Per: LimitPerAuthToken},
+			&Unlimited{Resource: "other", Action: "action", Per: LimitPerTotal},
+			&Unlimited{Resource: "other", Action: "action", Per: LimitPerIPAddress},
+			&Limited{Resource: "other", Action: "action", Per: LimitPerAuthToken, MaxRequests: 10, Period: time.Hour},
+		},
+		10,
+		WithClock(c),
+	)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	_, err = l.AdminHandler(nil)
+	assert.ErrorIs(t, err, ErrInvalidParameter)
+
+	h, err := l.AdminHandler(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer admin" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	require.NoError(t, err)
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer admin")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		_, _, err := l.Allow("resource", "action", "127.0.0.1", "")
+		require.NoError(t, err)
+	}
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/policies", nil))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("Policies", func(t *testing.T) {
+		w := serve(http.MethodGet, "/policies", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.JSONEq(t, `[
+			{"resource": "other", "action": "action", "policy_header": "10;w=3600;comment=\"auth-token\"", "limits": [
+				{"per": "total", "unlimited": true},
+				{"per": "ip-address", "unlimited": true},
+				{"per": "auth-token", "max_requests": 10, "period": "1h0m0s"}
+			]},
+			{"resource": "resource", "action": "action", "policy_header": "100;w=60;comment=\"total\", 2;w=60;comment=\"ip-address\"", "limits": [
+				{"per": "total", "max_requests": 100, "period": "1m0s"},
+				{"per": "ip-address", "max_requests": 2, "period": "1m0s", "grace_requests": 1},
+				{"per": "auth-token", "unlimited": true}
+			]}
+		]`, w.Body.String())
+	})
+
+	t.Run("Quotas", func(t *testing.T) {
+		w := serve(http.MethodGet, "/quotas?resource=resource&action=action&ip=127.0.0.1", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{
+			"total": {"max_requests": 100, "remaining": 98, "grace_used": 0, "resets_at": "2023-01-01T00:01:00Z"},
+			"ip-address": {"max_requests": 2, "remaining": 0, "grace_used": 0, "resets_at": "2023-01-01T00:01:00Z"}
+		}`, w.Body.String())
+
+		w = serve(http.MethodGet, "/quotas?resource=missing&action=action", "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Contains(t, w.Body.String(), ErrLimitPolicyNotFound.Error())
+	})
+
+	t.Run("ResetQuota", func(t *testing.T) {
+		w := serve(http.MethodPost, "/quotas/reset?resource=resource&action=action&per=ip-address&id=127.0.0.1", "")
+		require.Equal(t, http.StatusNoContent, w.Code)
+		quotas, err := l.ReadOnly().Quotas("resource", "action", "127.0.0.1", "")
+		require.NoError(t, err)
+		assert.Equal(t, uint64(2), quotas[LimitPerIPAddress].Remaining())
+
+		w = serve(http.MethodPost, "/quotas/reset?resource=resource&action=action&per=unknown", "")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		w = serve(http.MethodPost, "/quotas/reset?resource=resource&action=action&per=auth-token", "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		w = serve(http.MethodGet, "/quotas/reset", "")
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+		assert.Equal(t, http.MethodPost, w.Header().Get("Allow"))
+	})
+
+	t.Run("ShadowMode", func(t *testing.T) {
+		w := serve(http.MethodGet, "/shadow", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"enabled": false}`, w.Body.String())
+
+		w = serve(http.MethodPut, "/shadow", `{"enabled": true}`)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"enabled": true}`, w.Body.String())
+		assert.True(t, l.ShadowMode())
+
+		w = serve(http.MethodPut, "/shadow", `{`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.True(t, l.ShadowMode())
+
+		w = serve(http.MethodDelete, "/shadow", "")
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+		assert.Equal(t, "GET, PUT", w.Header().Get("Allow"))
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		w := serve(http.MethodGet, "/unknown", "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
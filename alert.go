@@ -0,0 +1,225 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// denialAlertSlots is the number of slots that the interval of a
+// DenialAlerter is divided into when calculating the rolling denial rate.
+const denialAlertSlots = 10
+
+// DenialAlert is passed to an AlertFunc when the rate of denied requests for
+// a resource and action has exceeded the threshold of a DenialAlerter for its
+// configured duration.
+type DenialAlert struct {
+	Resource string
+	Action   string
+
+	// Rate is the fraction of requests that were denied during the most
+	// recent interval.
+	Rate float64
+	// Since is when the rate first exceeded the threshold.
+	Since time.Time
+}
+
+// AlertFunc is called by a DenialAlerter when an alert is raised. It is called
+// in its own go routine, so it may block, for example to send a webhook.
+type AlertFunc func(DenialAlert)
+
+// DenialAlerter tracks the rate of denied requests for each resource and
+// action over a rolling interval, and raises an alert when the rate exceeds a
+// threshold for a sustained duration. An alert is raised once each time the
+// threshold is exceeded for the duration. Once the rate drops to or below the
+// threshold, a new alert can be raised. The rate is only considered once a
+// minimum number of requests have been made during the interval.
+//
+// A DenialAlerter can be passed to NewLimiter via WithDenialAlerter, in which
+// case requests are recorded for the resource and action of the limit policy
+// that they matched, or requests can be recorded directly via Record.
+type DenialAlerter struct {
+	interval    time.Duration
+	slotWidth   time.Duration
+	threshold   float64
+	duration    time.Duration
+	minRequests uint64
+	maxPolicies int
+	fn          AlertFunc
+	clock       Clock
+	// start is when the DenialAlerter was created. Slots are identified by
+	// the time elapsed since start, rather than the wall clock time, so that
+	// changes to the wall clock do not affect which slot is used.
+	start time.Time
+
+	// mu is only held for writing while a resource and action is added to
+	// policies. The requests for each resource and action are recorded while
+	// holding the lock of its denialRate.
+	mu       sync.RWMutex
+	policies map[policyKey]*denialRate
+}
+
+// denialRate tracks the requests for a single resource and action.
+type denialRate struct {
+	mu    sync.Mutex
+	slots [denialAlertSlots]denialSlot
+
+	// exceededSince is when the rate first exceeded the threshold, or the
+	// zero time if it is not currently exceeded.
+	exceededSince time.Time
+	alerted       bool
+}
+
+// denialSlot counts the requests during a single slot of the interval. The
+// epoch identifies the slot, so that stale counts can be discarded.
+type denialSlot struct {
+	epoch   int64
+	allowed uint64
+	denied  uint64
+}
+
+// NewDenialAlerter creates a DenialAlerter that calls fn when the fraction of
+// requests that were denied during the preceding interval exceeds threshold
+// for at least duration. The threshold must be in the range [0, 1).
+//
+// Supported options are:
+//   - WithClock: Provides the Clock used to get the current time. The default
+//     is to use the system time.
+//   - WithAlertMinRequests: Sets the minimum number of requests for a
+//     resource and action during the interval for an alert to be raised. The
+//     default is DefaultAlertMinRequests.
+//   - WithAlertMaxPolicies: Sets the maximum number of resources and actions
+//     that are tracked. The default is DefaultAlertMaxPolicies.
+func NewDenialAlerter(interval time.Duration, threshold float64, duration time.Duration, fn AlertFunc, o ...Option) (*DenialAlerter, error) {
+	const op = "rate.NewDenialAlerter"
+
+	switch {
+	case interval < denialAlertSlots:
+		return nil, fmt.Errorf("%s: interval must be at least %dns: %w", op, denialAlertSlots, ErrInvalidParameter)
+	case threshold < 0 || threshold >= 1:
+		return nil, fmt.Errorf("%s: threshold must be at least zero and less than one: %w", op, ErrInvalidParameter)
+	case duration < 0:
+		return nil, fmt.Errorf("%s: duration must not be negative: %w", op, ErrInvalidParameter)
+	case fn == nil:
+		return nil, fmt.Errorf("%s: missing alert func: %w", op, ErrInvalidParameter)
+	}
+
+	opts := getOpts(o...)
+	if opts.withAlertMaxPolicies <= 0 {
+		return nil, fmt.Errorf("%s: max policies must be greater than zero: %w", op, ErrInvalidParameter)
+	}
+
+	return &DenialAlerter{
+		interval:    interval,
+		slotWidth:   interval / denialAlertSlots,
+		threshold:   threshold,
+		duration:    duration,
+		minRequests: opts.withAlertMinRequests,
+		maxPolicies: opts.withAlertMaxPolicies,
+		fn:          fn,
+		clock:       opts.withClock,
+		start:       opts.withClock.Now(),
+		policies:    make(map[policyKey]*denialRate),
+	}, nil
+}
+
+// Record records whether a request for the resource and action was allowed,
+// and raises an alert if needed. If the DenialAlerter is already tracking its
+// max number of resources and actions, requests for a resource and action
+// that it is not tracking are ignored.
+func (a *DenialAlerter) Record(resource, action string, allowed bool) {
+	r, ok := a.rateFor(limitPolicyKey(resource, action))
+	if !ok {
+		return
+	}
+
+	now := a.clock.Now()
+	epoch := int64(now.Sub(a.start) / a.slotWidth)
+	if epoch < 0 {
+		epoch = 0
+	}
+
+	r.mu.Lock()
+	slot := &r.slots[epoch%denialAlertSlots]
+	if slot.epoch != epoch {
+		*slot = denialSlot{epoch: epoch}
+	}
+	switch {
+	case allowed:
+		slot.allowed++
+	default:
+		slot.denied++
+	}
+
+	rate, requests := r.rate(epoch)
+	var alert *DenialAlert
+	switch {
+	case requests < a.minRequests, rate <= a.threshold:
+		r.exceededSince = time.Time{}
+		r.alerted = false
+	case r.exceededSince.IsZero():
+		r.exceededSince = now
+		fallthrough
+	default:
+		if !r.alerted && now.Sub(r.exceededSince) >= a.duration {
+			r.alerted = true
+			alert = &DenialAlert{
+				Resource: resource,
+				Action:   action,
+				Rate:     rate,
+				Since:    r.exceededSince,
+			}
+		}
+	}
+	r.mu.Unlock()
+
+	if alert != nil {
+		go a.fn(*alert)
+	}
+}
+
+// rateFor returns the denialRate for the resource and action with the
+// provided key, adding it if it does not exist. False is returned if it does
+// not exist and the DenialAlerter is already tracking its max number of
+// resources and actions.
+func (a *DenialAlerter) rateFor(key policyKey) (*denialRate, bool) {
+	a.mu.RLock()
+	r, ok := a.policies[key]
+	a.mu.RUnlock()
+	if ok {
+		return r, true
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if r, ok := a.policies[key]; ok {
+		return r, true
+	}
+	if len(a.policies) >= a.maxPolicies {
+		return nil, false
+	}
+	r = &denialRate{}
+	a.policies[key] = r
+	return r, true
+}
+
+// rate returns the fraction of requests that were denied in the slots that
+// are within the interval ending at the provided epoch, along with the number
+// of requests in those slots.
+func (r *denialRate) rate(epoch int64) (float64, uint64) {
+	var allowed, denied uint64
+	for _, s := range r.slots {
+		if epoch-s.epoch >= denialAlertSlots {
+			continue
+		}
+		allowed += s.allowed
+		denied += s.denied
+	}
+	if allowed+denied == 0 {
+		return 0, 0
+	}
+	return float64(denied) / float64(allowed+denied), allowed + denied
+}
@@ -0,0 +1,190 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDenialAlerter(t *testing.T) {
+	fn := func(DenialAlert) {}
+	cases := []struct {
+		name      string
+		interval  time.Duration
+		threshold float64
+		duration  time.Duration
+		fn        AlertFunc
+		expectErr error
+	}{
+		{"valid", time.Minute, 0.5, time.Minute, fn, nil},
+		{"zero-duration", time.Minute, 0, 0, fn, nil},
+		{"invalid-interval", 0, 0.5, time.Minute, fn, ErrInvalidParameter},
+		{"negative-threshold", time.Minute, -0.1, time.Minute, fn, ErrInvalidParameter},
+		{"threshold-one", time.Minute, 1, time.Minute, fn, ErrInvalidParameter},
+		{"negative-duration", time.Minute, 0.5, -time.Minute, fn, ErrInvalidParameter},
+		{"missing-func", time.Minute, 0.5, time.Minute, nil, ErrInvalidParameter},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a, err := NewDenialAlerter(tc.interval, tc.threshold, tc.duration, tc.fn)
+			if tc.expectErr != nil {
+				require.ErrorIs(t, err, tc.expectErr)
+				assert.Nil(t, a)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotNil(t, a)
+		})
+	}
+}
+
+func TestDenialAlerter(t *testing.T) {
+	c := newFakeClock()
+	alerts := make(chan DenialAlert, 10)
+	a, err := NewDenialAlerter(time.Minute, 0.5, 30*time.Second, func(alert DenialAlert) {
+		alerts <- alert
+	}, WithClock(c), WithAlertMinRequests(1))
+	require.NoError(t, err)
+
+	// Below the threshold.
+	a.Record("resource", "action", true)
+	a.Record("resource", "action", false)
+	assert.Empty(t, alerts)
+
+	// Above the threshold, but not for long enough.
+	a.Record("resource", "action", false)
+	since := c.Now()
+	c.Advance(20 * time.Second)
+	a.Record("resource", "action", false)
+	assert.Empty(t, alerts)
+
+	// Other policies are tracked separately.
+	c.Advance(10 * time.Second)
+	a.Record("other", "action", true)
+	assert.Empty(t, alerts)
+
+	// Sustained above the threshold.
+	a.Record("resource", "action", false)
+	select {
+	case alert := <-alerts:
+		assert.Equal(t, "resource", alert.Resource)
+		assert.Equal(t, "action", alert.Action)
+		assert.Equal(t, since, alert.Since)
+		assert.InDelta(t, 0.8, alert.Rate, 0.001)
+	case <-time.After(time.Second):
+		require.FailNow(t, "timed out waiting for alert")
+	}
+
+	// Only one alert is raised while the rate remains above the threshold.
+	c.Advance(30 * time.Second)
+	a.Record("resource", "action", false)
+	time.Sleep(10 * time.Millisecond)
+	assert.Empty(t, alerts)
+
+	// Once the old denials fall out of the interval and the rate drops, the
+	// alert is cleared.
+	c.Advance(2 * time.Minute)
+	a.Record("resource", "action", true)
+
+	// It can then be raised again.
+	a.Record("resource", "action", false)
+	since = c.Now()
+	a.Record("resource", "action", false)
+	c.Advance(30 * time.Second)
+	a.Record("resource", "action", false)
+	select {
+	case alert := <-alerts:
+		assert.Equal(t, since, alert.Since)
+	case <-time.After(time.Second):
+		require.FailNow(t, "timed out waiting for alert")
+	}
+}
+
+func TestDenialAlerterClockBeforeStart(t *testing.T) {
+	c := newFakeClock()
+	alerts := make(chan DenialAlert, 10)
+	a, err := NewDenialAlerter(time.Minute, 0.5, 0, func(alert DenialAlert) {
+		alerts <- alert
+	}, WithClock(c), WithAlertMinRequests(1))
+	require.NoError(t, err)
+
+	// A Clock without a monotonic reading can move backwards. Requests are
+	// then recorded in the first slot.
+	c.Advance(-time.Hour)
+	require.NotPanics(t, func() { a.Record("resource", "action", false) })
+	select {
+	case alert := <-alerts:
+		assert.Equal(t, 1.0, alert.Rate)
+	case <-time.After(time.Second):
+		require.FailNow(t, "timed out waiting for alert")
+	}
+}
+
+func TestNewDenialAlerterMaxPolicies(t *testing.T) {
+	a, err := NewDenialAlerter(time.Minute, 0.5, 0, func(DenialAlert) {}, WithAlertMaxPolicies(0))
+	require.ErrorIs(t, err, ErrInvalidParameter)
+	assert.Nil(t, a)
+}
+
+func TestDenialAlerterMinRequests(t *testing.T) {
+	c := newFakeClock()
+	alerts := make(chan DenialAlert, 10)
+	a, err := NewDenialAlerter(time.Minute, 0.5, 0, func(alert DenialAlert) {
+		alerts <- alert
+	}, WithClock(c), WithAlertMinRequests(3))
+	require.NoError(t, err)
+
+	// The rate is not considered until enough requests have been made
+	// during the interval.
+	a.Record("resource", "action", false)
+	a.Record("resource", "action", false)
+	time.Sleep(10 * time.Millisecond)
+	assert.Empty(t, alerts)
+
+	a.Record("resource", "action", false)
+	select {
+	case alert := <-alerts:
+		assert.Equal(t, 1.0, alert.Rate)
+	case <-time.After(time.Second):
+		require.FailNow(t, "timed out waiting for alert")
+	}
+
+	// Requests that have left the interval are not counted.
+	c.Advance(2 * time.Minute)
+	a.Record("resource", "action", false)
+	a.Record("resource", "action", false)
+	time.Sleep(10 * time.Millisecond)
+	assert.Empty(t, alerts)
+}
+
+func TestDenialAlerterMaxPolicies(t *testing.T) {
+	alerts := make(chan DenialAlert, 10)
+	a, err := NewDenialAlerter(time.Minute, 0.5, 0, func(alert DenialAlert) {
+		alerts <- alert
+	}, WithAlertMinRequests(1), WithAlertMaxPolicies(1))
+	require.NoError(t, err)
+
+	// Requests for other resources and actions are ignored once the max
+	// number of them are tracked.
+	a.Record("resource", "action", true)
+	a.Record("other", "action", false)
+	time.Sleep(10 * time.Millisecond)
+	assert.Empty(t, alerts)
+	a.mu.RLock()
+	assert.Len(t, a.policies, 1)
+	a.mu.RUnlock()
+
+	a.Record("resource", "action", false)
+	a.Record("resource", "action", false)
+	select {
+	case alert := <-alerts:
+		assert.Equal(t, "resource", alert.Resource)
+	case <-time.After(time.Second):
+		require.FailNow(t, "timed out waiting for alert")
+	}
+}
@@ -0,0 +1,24 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import "context"
+
+// bypassKey is the context key used by WithBypass.
+type bypassKey struct{}
+
+// WithBypass returns a copy of ctx that indicates that requests made with it
+// should not be limited. It is intended for trusted internal calls, such as
+// service-to-service requests that have been authenticated with a mesh
+// identity, and is honored by the middleware in the ratehttp package. It
+// should only be set after the caller has been authenticated.
+func WithBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassKey{}, true)
+}
+
+// IsBypassed reports whether ctx was created by WithBypass.
+func IsBypassed(ctx context.Context) bool {
+	b, _ := ctx.Value(bypassKey{}).(bool)
+	return b
+}
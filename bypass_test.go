@@ -0,0 +1,23 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithBypass(t *testing.T) {
+	ctx := context.Background()
+	assert.False(t, IsBypassed(ctx))
+
+	bypassed := WithBypass(ctx)
+	assert.True(t, IsBypassed(bypassed))
+	assert.False(t, IsBypassed(ctx))
+
+	type otherKey struct{}
+	assert.True(t, IsBypassed(context.WithValue(bypassed, otherKey{}, "value")))
+}
@@ -0,0 +1,62 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"fmt"
+	"time"
+)
+
+// RateClass is a named rate that is used by each Limited with a Class of its
+// name, so that the limits of many policies can be tuned by changing a single
+// RateClass. RateClasses are provided via WithRateClasses and can be replaced
+// via Limiter.ReloadClasses.
+type RateClass struct {
+	MaxRequests uint64
+	Period      time.Duration
+
+	// GraceRequests is an optional number of requests that are allowed in
+	// each window after MaxRequests have been used, which allows for bursts
+	// of requests. See Limited.GraceRequests.
+	GraceRequests uint64
+}
+
+// validate checks if c is valid. RateClass is invalid if MaxRequests is zero
+// or if Period is less than or equal to zero.
+func (c RateClass) validate() error {
+	switch {
+	case c.MaxRequests == 0:
+		return fmt.Errorf("%w: max requests must be greater than zero", ErrInvalidLimit)
+	case c.Period <= 0:
+		return fmt.Errorf("%w: period must be greater than zero", ErrInvalidLimit)
+	}
+	return nil
+}
+
+// resolveClasses returns the limits with the MaxRequests, Period, and
+// GraceRequests of each Limited with a Class set to those of its RateClass.
+// The provided limits are not modified. A Limited with a Class must reference
+// one of the classes and must not set MaxRequests, MaxRequestsExpr, Period, or
+// GraceRequests itself, otherwise ErrInvalidLimit is returned.
+func resolveClasses(limits []Limit, classes map[string]RateClass) ([]Limit, error) {
+	resolved := make([]Limit, 0, len(limits))
+	for _, l := range limits {
+		ll, ok := l.(*Limited)
+		if !ok || ll.Class == "" {
+			resolved = append(resolved, l)
+			continue
+		}
+		c, ok := classes[ll.Class]
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("%w: unknown rate class %q", ErrInvalidLimit, ll.Class)
+		case ll.MaxRequests != 0, ll.MaxRequestsExpr != "", ll.Period != 0, ll.GraceRequests != 0:
+			return nil, fmt.Errorf("%w: limit with rate class %q cannot set max requests, period, or grace requests", ErrInvalidLimit, ll.Class)
+		}
+		r := *ll
+		r.MaxRequests, r.Period, r.GraceRequests = c.MaxRequests, c.Period, c.GraceRequests
+		resolved = append(resolved, &r)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,214 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateClass_validate(t *testing.T) {
+	cases := []struct {
+		name    string
+		class   RateClass
+		wantErr error
+	}{
+		{"Valid", RateClass{MaxRequests: 10, Period: time.Minute, GraceRequests: 5}, nil},
+		{"ZeroMaxRequests", RateClass{Period: time.Minute}, ErrInvalidLimit},
+		{"ZeroPeriod", RateClass{MaxRequests: 10}, ErrInvalidLimit},
+		{"NegativePeriod", RateClass{MaxRequests: 10, Period: -time.Minute}, ErrInvalidLimit},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.class.validate()
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestResolveClasses(t *testing.T) {
+	classes := map[string]RateClass{
+		"standard": {MaxRequests: 10, Period: time.Minute, GraceRequests: 2},
+	}
+
+	cases := []struct {
+		name    string
+		limits  []Limit
+		want    []Limit
+		wantErr error
+	}{
+		{
+			"NoClass",
+			[]Limit{
+				&Limited{Resource: "r", Action: "a", Per: LimitPerTotal, MaxRequests: 5, Period: time.Second},
+				&Unlimited{Resource: "r", Action: "a", Per: LimitPerIPAddress},
+			},
+			[]Limit{
+				&Limited{Resource: "r", Action: "a", Per: LimitPerTotal, MaxRequests: 5, Period: time.Second},
+				&Unlimited{Resource: "r", Action: "a", Per: LimitPerIPAddress},
+			},
+			nil,
+		},
+		{
+			"Class",
+			[]Limit{
+				&Limited{Resource: "r", Action: "a", Per: LimitPerTotal, Class: "standard", Jitter: 0.1},
+			},
+			[]Limit{
+				&Limited{Resource: "r", Action: "a", Per: LimitPerTotal, Class: "standard", Jitter: 0.1, MaxRequests: 10, Period: time.Minute, GraceRequests: 2},
+			},
+			nil,
+		},
+		{
+			"UnknownClass",
+			[]Limit{
+				&Limited{Resource: "r", Action: "a", Per: LimitPerTotal, Class: "premium"},
+			},
+			nil,
+			ErrInvalidLimit,
+		},
+		{
+			"ClassWithMaxRequests",
+			[]Limit{
+				&Limited{Resource: "r", Action: "a", Per: LimitPerTotal, Class: "standard", MaxRequests: 5},
+			},
+			nil,
+			ErrInvalidLimit,
+		},
+		{
+			"ClassWithMaxRequestsExpr",
+			[]Limit{
+				&Limited{Resource: "r", Action: "a", Per: LimitPerTotal, Class: "standard", MaxRequestsExpr: "10"},
+			},
+			nil,
+			ErrInvalidLimit,
+		},
+		{
+			"ClassWithPeriod",
+			[]Limit{
+				&Limited{Resource: "r", Action: "a", Per: LimitPerTotal, Class: "standard", Period: time.Second},
+			},
+			nil,
+			ErrInvalidLimit,
+		},
+		{
+			"ClassWithGraceRequests",
+			[]Limit{
+				&Limited{Resource: "r", Action: "a", Per: LimitPerTotal, Class: "standard", GraceRequests: 1},
+			},
+			nil,
+			ErrInvalidLimit,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := resolveClasses(tc.limits, classes)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	t.Run("DoesNotModifyLimits", func(t *testing.T) {
+		l := &Limited{Resource: "r", Action: "a", Per: LimitPerTotal, Class: "standard"}
+		_, err := resolveClasses([]Limit{l}, classes)
+		require.NoError(t, err)
+		assert.Zero(t, l.MaxRequests)
+		assert.Zero(t, l.Period)
+	})
+}
+
+func TestLimiterRateClasses(t *testing.T) {
+	limits := []Limit{
+		&Limited{Resource: "target", Action: "read", Per: LimitPerTotal, Class: "standard"},
+		&Limited{Resource: "session", Action: "read", Per: LimitPerTotal, Class: "standard"},
+		&Limited{Resource: "session", Action: "write", Per: LimitPerTotal, MaxRequests: 1, Period: time.Minute},
+	}
+	classes := map[string]RateClass{
+		"standard": {MaxRequests: 2, Period: time.Minute},
+	}
+
+	t.Run("MissingClasses", func(t *testing.T) {
+		_, err := NewLimiter(limits, 10)
+		assert.ErrorIs(t, err, ErrInvalidLimit)
+	})
+	t.Run("InvalidClass", func(t *testing.T) {
+		_, err := NewLimiter(limits, 10, WithRateClasses(map[string]RateClass{"standard": {}}))
+		assert.ErrorIs(t, err, ErrInvalidLimit)
+	})
+
+	maxRequests := func(t *testing.T, l *Limiter, resource, action string) uint64 {
+		t.Helper()
+		_, ll, ok := l.policies.Load().limited(resource, action, LimitPerTotal)
+		require.True(t, ok)
+		return ll.MaxRequests
+	}
+
+	l, err := NewLimiter(limits, 10, WithRateClasses(classes))
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	for i := 0; i < 2; i++ {
+		allowed, _, err := l.Allow("target", "read", "127.0.0.1", "token")
+		require.NoError(t, err)
+		assert.True(t, allowed)
+	}
+	allowed, _, err := l.Allow("target", "read", "127.0.0.1", "token")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	// Modifying the provided classes does not change the Limiter.
+	classes["standard"] = RateClass{MaxRequests: 100, Period: time.Minute}
+	assert.Equal(t, uint64(2), maxRequests(t, l, "target", "read"))
+
+	t.Run("ReloadClasses", func(t *testing.T) {
+		require.NoError(t, l.ReloadClasses(map[string]RateClass{
+			"standard": {MaxRequests: 5, Period: time.Minute},
+		}))
+		assert.Equal(t, uint64(5), maxRequests(t, l, "target", "read"))
+		assert.Equal(t, uint64(5), maxRequests(t, l, "session", "read"))
+		assert.Equal(t, uint64(1), maxRequests(t, l, "session", "write"))
+	})
+	t.Run("ReloadClassesMissingClass", func(t *testing.T) {
+		err := l.ReloadClasses(map[string]RateClass{
+			"premium": {MaxRequests: 5, Period: time.Minute},
+		})
+		assert.ErrorIs(t, err, ErrInvalidLimit)
+		assert.Equal(t, uint64(5), maxRequests(t, l, "target", "read"))
+	})
+	t.Run("ReloadClassesInvalidClass", func(t *testing.T) {
+		err := l.ReloadClasses(map[string]RateClass{
+			"standard": {Period: time.Minute},
+		})
+		assert.ErrorIs(t, err, ErrInvalidLimit)
+	})
+	t.Run("ReloadClassesLongerPeriod", func(t *testing.T) {
+		require.NoError(t, l.ReloadClasses(map[string]RateClass{
+			"standard": {MaxRequests: 5, Period: time.Hour},
+		}))
+		assert.Equal(t, time.Hour, l.Config().MaxPeriod)
+	})
+	t.Run("ReloadUsesClasses", func(t *testing.T) {
+		require.NoError(t, l.Reload(limits[:2]))
+		assert.Equal(t, uint64(5), maxRequests(t, l, "target", "read"))
+
+		// The reloaded limits are used by later calls to ReloadClasses.
+		require.NoError(t, l.ReloadClasses(map[string]RateClass{
+			"standard": {MaxRequests: 7, Period: time.Minute},
+		}))
+		assert.Equal(t, uint64(7), maxRequests(t, l, "session", "read"))
+		_, _, ok := l.policies.Load().limited("session", "write", LimitPerTotal)
+		assert.False(t, ok)
+	})
+}
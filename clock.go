@@ -0,0 +1,27 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import "time"
+
+// Clock provides the current time to a Limiter. It can be provided via
+// WithClock to control the passage of time, for example in tests.
+//
+// Quotas expire based on the elapsed time between the times returned by Now.
+// Like time.Now, Now should return times that include a monotonic clock
+// reading, so that the wall clock being changed, such as by NTP, does not
+// cause quotas to expire early or late.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After waits for the duration to elapse and then sends the current time
+	// on the returned channel.
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is a Clock that uses the system time.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
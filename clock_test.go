@@ -0,0 +1,97 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a Clock whose time only changes when it is advanced.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward, notifying any waiters whose time has
+// been reached.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = waiters
+}
+
+// Waiters returns the number of pending calls to After.
+func (c *fakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+func TestRealClock(t *testing.T) {
+	assert.WithinDuration(t, time.Now(), realClock{}.Now(), time.Second)
+	select {
+	case <-realClock{}.After(time.Millisecond):
+	case <-time.After(time.Second):
+		t.Fatal("After did not fire")
+	}
+}
+
+func TestQuotaClock(t *testing.T) {
+	c := newFakeClock()
+	q := &Quota{clock: c}
+	q.reset(&Limited{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerTotal,
+		MaxRequests: 10,
+		Period:      time.Minute,
+	})
+	assert.Equal(t, c.Now().Add(time.Minute), q.Expiration())
+	assert.Equal(t, time.Minute, q.ResetsIn())
+	assert.False(t, q.Expired())
+
+	c.Advance(time.Minute + time.Nanosecond)
+	assert.True(t, q.Expired())
+}
@@ -0,0 +1,55 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"hash/fnv"
+	"strconv"
+)
+
+// cohortPrefix is the prefix of the identities returned by CohortID, so that
+// they cannot collide with an IP address or typical auth token.
+const cohortPrefix = "cohort:"
+
+// CohortID returns the identity of the cohort, out of n cohorts, that the
+// provided IP address or auth token belongs to. A Limiter created with
+// WithCohorts uses it in place of the identity for quotas, so it can be used
+// to get the id expected by methods such as RekeyQuota and ExtendQuota.
+//
+// Identities are assigned to cohorts using a consistent hash, so increasing
+// the number of cohorts from m to n only moves about (n-m)/n of the
+// identities to a new cohort.
+func CohortID(id string, n int) string {
+	if n <= 0 {
+		n = 1
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(id))
+	return cohortPrefix + strconv.Itoa(jumpHash(h.Sum64(), n))
+}
+
+// jumpHash returns the bucket, out of n buckets, for the key using the jump
+// consistent hash algorithm described in "A Fast, Minimal Memory, Consistent
+// Hash Algorithm" by Lamping and Veach.
+func jumpHash(key uint64, n int) int {
+	var b, j int64 = -1, 0
+	for j < int64(n) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// cohort returns the identity used for the quotas of the provided id for the
+// LimitPer, which is the id's cohort if the Limiter was created with
+// WithCohorts for the LimitPer, or the id otherwise. An empty id is returned
+// as is.
+func (l *Limiter) cohort(per LimitPer, id string) string {
+	n, ok := l.cohorts[per]
+	if !ok || id == "" {
+		return id
+	}
+	return CohortID(id, n)
+}
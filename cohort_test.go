@@ -0,0 +1,92 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCohortID(t *testing.T) {
+	assert.Equal(t, CohortID("token", 8), CohortID("token", 8))
+	assert.True(t, strings.HasPrefix(CohortID("token", 8), cohortPrefix))
+	assert.Equal(t, cohortPrefix+"0", CohortID("token", 1))
+	assert.Equal(t, cohortPrefix+"0", CohortID("token", 0))
+
+	// Each cohort is used, and growing the number of cohorts only moves
+	// identities to the new cohorts.
+	const ids = 1000
+	seen := make(map[string]int)
+	moved := 0
+	for i := 0; i < ids; i++ {
+		id := fmt.Sprintf("token-%d", i)
+		before, after := CohortID(id, 8), CohortID(id, 10)
+		seen[before]++
+		if before != after {
+			moved++
+			assert.Contains(t, []string{cohortPrefix + "8", cohortPrefix + "9"}, after)
+		}
+	}
+	assert.Len(t, seen, 8)
+	assert.InDelta(t, ids/5, moved, ids/10)
+}
+
+func TestLimiterCohorts(t *testing.T) {
+	limits := []Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+		&Unlimited{
+			Resource: "resource",
+			Action:   "action",
+			Per:      LimitPerIPAddress,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerAuthToken,
+			MaxRequests: 2,
+			Period:      time.Minute,
+		},
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		l, err := NewLimiter(limits, 10, WithCohorts(LimitPerAuthToken, 1))
+		require.NoError(t, err)
+		defer l.Shutdown()
+
+		// Every auth token is in the same cohort, so they share a quota.
+		for _, token := range []string{"a", "b"} {
+			allowed, q, err := l.Allow("resource", "action", "127.0.0.1", token)
+			require.NoError(t, err)
+			require.True(t, allowed)
+			require.NotNil(t, q)
+		}
+		allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "c")
+		require.NoError(t, err)
+		assert.False(t, allowed)
+
+		quotas, err := l.ReadOnly().Quotas("resource", "action", "127.0.0.1", "d")
+		require.NoError(t, err)
+		assert.Equal(t, uint64(0), quotas[LimitPerAuthToken].Remaining())
+		assert.NotNil(t, l.quotaFetcher.lookup(CohortID("a", 1), limits[2].(*Limited)))
+		assert.Nil(t, l.quotaFetcher.lookup("a", limits[2].(*Limited)))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := NewLimiter(limits, 10, WithCohorts(LimitPerTotal, 2))
+		assert.ErrorIs(t, err, ErrInvalidLimitPer)
+		_, err = NewLimiter(limits, 10, WithCohorts(LimitPerIPAddress, 0))
+		assert.ErrorIs(t, err, ErrInvalidParameter)
+	})
+}
@@ -0,0 +1,47 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CheckCoverage checks that each resource and action in the registry, which
+// maps each resource of a service to its actions, has a limit policy in the
+// limits, either for the resource and action or using a Wildcard. This can be
+// used when a service starts, or in its tests, so that requests for a resource
+// and action without a limit policy do not fail with ErrLimitPolicyNotFound.
+// If any resources and actions do not have a limit policy, an *ErrCoverage is
+// returned that reports each of them, sorted by resource and action. If the
+// limits are not valid, the error returned by NewLimiter for the limits is
+// returned.
+func CheckCoverage(limits []Limit, registry map[string][]string) error {
+	const op = "rate.CheckCoverage"
+
+	policies, err := newLimitPolicies(limits, false)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	var gaps []CoverageGap
+	for resource, actions := range registry {
+		for _, action := range actions {
+			if _, ok := policies.lookup(resource, action); !ok {
+				gaps = append(gaps, CoverageGap{Resource: resource, Action: action})
+			}
+		}
+	}
+	if len(gaps) == 0 {
+		return nil
+	}
+
+	sort.Slice(gaps, func(i, j int) bool {
+		if gaps[i].Resource != gaps[j].Resource {
+			return gaps[i].Resource < gaps[j].Resource
+		}
+		return gaps[i].Action < gaps[j].Action
+	})
+	return fmt.Errorf("%s: %w", op, &ErrCoverage{Gaps: gaps})
+}
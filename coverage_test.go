@@ -0,0 +1,150 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckCoverage(t *testing.T) {
+	limit := func(resource, action string) Limit {
+		return &Limited{
+			Resource:    resource,
+			Action:      action,
+			Per:         LimitPerTotal,
+			MaxRequests: 10,
+			Period:      time.Minute,
+		}
+	}
+	registry := map[string][]string{
+		"target":  {"read", "list"},
+		"session": {"read", "list", "cancel"},
+		"host":    {"read"},
+	}
+
+	cases := []struct {
+		name     string
+		limits   []Limit
+		wantGaps []CoverageGap
+	}{
+		{
+			"covered",
+			[]Limit{
+				limit("target", "read"),
+				limit("target", "list"),
+				limit("session", "read"),
+				limit("session", "list"),
+				limit("session", "cancel"),
+				limit("host", "read"),
+			},
+			nil,
+		},
+		{
+			"gaps",
+			[]Limit{
+				limit("target", "read"),
+				limit("session", "read"),
+				limit("other", "list"),
+			},
+			[]CoverageGap{
+				{Resource: "host", Action: "read"},
+				{Resource: "session", Action: "cancel"},
+				{Resource: "session", Action: "list"},
+				{Resource: "target", Action: "list"},
+			},
+		},
+		{
+			"resource-wildcard",
+			[]Limit{
+				limit("session", Wildcard),
+				limit(Wildcard, "read"),
+			},
+			[]CoverageGap{
+				{Resource: "target", Action: "list"},
+			},
+		},
+		{
+			"wildcard",
+			[]Limit{
+				limit(Wildcard, Wildcard),
+			},
+			nil,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := CheckCoverage(tc.limits, registry)
+			if tc.wantGaps == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, ErrLimitPolicyNotFound)
+			var coverageErr *ErrCoverage
+			require.True(t, errors.As(err, &coverageErr))
+			assert.Equal(t, tc.wantGaps, coverageErr.Gaps)
+		})
+	}
+
+	err := CheckCoverage([]Limit{limit("target", "read"), limit("target", "read")}, registry)
+	assert.ErrorIs(t, err, ErrDuplicateLimit)
+}
+
+func TestErrCoverage(t *testing.T) {
+	err := &ErrCoverage{Gaps: []CoverageGap{
+		{Resource: "host", Action: "read"},
+		{Resource: "target", Action: "list"},
+	}}
+	assert.Equal(t, `missing limit policies for "host" "read", "target" "list"`, err.Error())
+}
+
+func TestLimiterWildcard(t *testing.T) {
+	limited := func(resource, action string, maxRequests uint64) Limit {
+		return &Limited{
+			Resource:    resource,
+			Action:      action,
+			Per:         LimitPerTotal,
+			MaxRequests: maxRequests,
+			Period:      time.Minute,
+		}
+	}
+	l, err := NewLimiter([]Limit{
+		limited("target", "read", 1),
+		limited("target", Wildcard, 2),
+		limited(Wildcard, "read", 3),
+		limited(Wildcard, Wildcard, 4),
+	}, 10)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	cases := []struct {
+		resource string
+		action   string
+		wantMax  uint64
+	}{
+		{"target", "read", 1},
+		{"target", "list", 2},
+		{"session", "read", 3},
+		{"session", "list", 4},
+	}
+	for _, tc := range cases {
+		allowed, quota, err := l.Allow(tc.resource, tc.action, "127.0.0.1", "")
+		require.NoError(t, err)
+		assert.True(t, allowed)
+		assert.Equal(t, tc.wantMax, quota.MaxRequests())
+		v, ok := l.PolicyHeaderValue(tc.resource, tc.action)
+		assert.True(t, ok)
+		assert.Contains(t, v, fmt.Sprintf("%d;w=60", tc.wantMax))
+	}
+
+	// Requests that use the same wildcard limit share its quota.
+	_, quota, err := l.Allow("host", "list", "127.0.0.1", "")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), quota.Remaining())
+}
@@ -0,0 +1,122 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// denialCache stores the quotas that have been exhausted, so that requests
+// that use them can be denied without fetching the quota from the
+// quotaFetcher until the quota resets. This avoids contending for the
+// quotaFetcher's locks when clients retry requests that will be denied.
+type denialCache struct {
+	// minResetsIn is the minimum time until an exhausted quota resets for
+	// its denial to be cached.
+	minResetsIn time.Duration
+	maxSize     int
+	clock       Clock
+
+	// denials maps the key of each exhausted quota to a *denial.
+	denials sync.Map
+	size    atomic.Int64
+	// pruneMu is held while expired denials are removed, so that only one
+	// goroutine prunes at a time.
+	pruneMu sync.Mutex
+}
+
+// denial is a cached denial for an exhausted quota.
+type denial struct {
+	until time.Time
+	// quota is a copy of the exhausted quota, which is returned for requests
+	// that are denied via the cache.
+	quota *Quota
+}
+
+func newDenialCache(minResetsIn time.Duration, maxSize int, clock Clock) *denialCache {
+	return &denialCache{
+		minResetsIn: minResetsIn,
+		maxSize:     maxSize,
+		clock:       clock,
+	}
+}
+
+// lookup returns the exhausted quota for the key if requests using it
+// should be denied.
+func (c *denialCache) lookup(key string) (*Quota, bool) {
+	v, ok := c.denials.Load(key)
+	if !ok {
+		return nil, false
+	}
+	d := v.(*denial)
+	if c.clock.Now().After(d.until) {
+		c.removeExpired(key, d)
+		return nil, false
+	}
+	return d.quota, true
+}
+
+// add caches the denial of requests using q, if q has been exhausted and does
+// not reset for at least minResetsIn. If the cache is full, expired denials
+// are removed, and the denial is not cached if it is still full.
+func (c *denialCache) add(key string, q *Quota) {
+	if q.remainingWithGrace() > 0 {
+		return
+	}
+	now := c.clock.Now()
+	until := q.Expiration()
+	if until.Sub(now) < c.minResetsIn {
+		return
+	}
+	if c.size.Load() >= int64(c.maxSize) {
+		c.prune(now)
+		if c.size.Load() >= int64(c.maxSize) {
+			return
+		}
+	}
+	d := &denial{until: until, quota: q.clone()}
+	if _, loaded := c.denials.Swap(key, d); !loaded {
+		c.size.Add(1)
+	}
+}
+
+// remove removes the denial for the key, so that requests using its quota
+// are checked against the quota again.
+func (c *denialCache) remove(key string) {
+	if _, loaded := c.denials.LoadAndDelete(key); loaded {
+		c.size.Add(-1)
+	}
+}
+
+// removeExpired removes the expired denial d for the key, unless it has
+// already been replaced by a new denial.
+func (c *denialCache) removeExpired(key string, d *denial) {
+	if c.denials.CompareAndDelete(key, d) {
+		c.size.Add(-1)
+	}
+}
+
+// prune removes the denials that have expired.
+func (c *denialCache) prune(now time.Time) {
+	if !c.pruneMu.TryLock() {
+		return
+	}
+	defer c.pruneMu.Unlock()
+	c.denials.Range(func(k, v any) bool {
+		if d := v.(*denial); now.After(d.until) {
+			c.removeExpired(k.(string), d)
+		}
+		return true
+	})
+}
+
+// clear removes all of the denials.
+func (c *denialCache) clear() {
+	c.denials.Range(func(k, _ any) bool {
+		c.remove(k.(string))
+		return true
+	})
+}
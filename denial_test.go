@@ -0,0 +1,207 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDenialCache(t *testing.T) {
+	limit := &Limited{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerIPAddress,
+		MaxRequests: 2,
+		Period:      time.Minute,
+	}
+	newQuota := func(c Clock, used uint64) *Quota {
+		q := &Quota{clock: c}
+		q.reset(limit)
+		q.consumeN(used)
+		return q
+	}
+
+	t.Run("add", func(t *testing.T) {
+		c := newFakeClock()
+		d := newDenialCache(30*time.Second, 10, c)
+
+		// Quotas that have not been exhausted are not cached.
+		d.add("remaining", newQuota(c, 1))
+		_, ok := d.lookup("remaining")
+		assert.False(t, ok)
+
+		q := newQuota(c, 2)
+		d.add("exhausted", q)
+		got, ok := d.lookup("exhausted")
+		require.True(t, ok)
+		assert.NotSame(t, q, got)
+		assert.Equal(t, uint64(0), got.Remaining())
+		assert.Equal(t, q.Expiration(), got.Expiration())
+
+		// The cached quota is not modified when the quota is.
+		q.refund(2)
+		assert.Equal(t, uint64(0), got.Remaining())
+
+		// Quotas that reset soon are not cached.
+		soon := newQuota(c, 2)
+		c.Advance(40 * time.Second)
+		d.add("soon", soon)
+		_, ok = d.lookup("soon")
+		assert.False(t, ok)
+	})
+
+	t.Run("expires", func(t *testing.T) {
+		c := newFakeClock()
+		d := newDenialCache(0, 10, c)
+		d.add("key", newQuota(c, 2))
+		_, ok := d.lookup("key")
+		require.True(t, ok)
+		assert.Equal(t, int64(1), d.size.Load())
+
+		c.Advance(time.Minute)
+		_, ok = d.lookup("key")
+		require.True(t, ok)
+		c.Advance(time.Nanosecond)
+		_, ok = d.lookup("key")
+		assert.False(t, ok)
+		assert.Equal(t, int64(0), d.size.Load())
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		c := newFakeClock()
+		d := newDenialCache(0, 10, c)
+		d.add("key", newQuota(c, 2))
+		d.add("other", newQuota(c, 2))
+		d.remove("key")
+		d.remove("missing")
+		_, ok := d.lookup("key")
+		assert.False(t, ok)
+		_, ok = d.lookup("other")
+		assert.True(t, ok)
+		assert.Equal(t, int64(1), d.size.Load())
+
+		d.clear()
+		_, ok = d.lookup("other")
+		assert.False(t, ok)
+		assert.Equal(t, int64(0), d.size.Load())
+	})
+
+	t.Run("full", func(t *testing.T) {
+		c := newFakeClock()
+		d := newDenialCache(0, 2, c)
+		d.add("first", newQuota(c, 2))
+		c.Advance(30 * time.Second)
+		d.add("second", newQuota(c, 2))
+		d.add("third", newQuota(c, 2))
+		_, ok := d.lookup("third")
+		assert.False(t, ok)
+
+		// Adding the same key again does not require more space.
+		d.add("second", newQuota(c, 2))
+		assert.Equal(t, int64(2), d.size.Load())
+
+		// Expired denials are pruned to make space.
+		c.Advance(30*time.Second + time.Nanosecond)
+		d.add("third", newQuota(c, 2))
+		_, ok = d.lookup("third")
+		assert.True(t, ok)
+		_, ok = d.lookup("first")
+		assert.False(t, ok)
+		assert.Equal(t, int64(2), d.size.Load())
+	})
+}
+
+func TestLimiterDenialCache(t *testing.T) {
+	limits := []Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			MaxRequests: 2,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerAuthToken,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewLimiter(limits, 10, WithDenialCache(-time.Second, 10))
+		assert.ErrorIs(t, err, ErrInvalidParameter)
+		_, err = NewLimiter(limits, 10, WithDenialCache(time.Second, -1))
+		assert.ErrorIs(t, err, ErrInvalidParameter)
+	})
+
+	c := newFakeClock()
+	l, err := NewLimiter(limits, 10, WithClock(c), WithDenialCache(30*time.Second, 10))
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	allow := func(t *testing.T, n uint64) (bool, *Quota) {
+		t.Helper()
+		allowed, q, err := l.AllowN("resource", "action", "127.0.0.1", "token", n)
+		require.NoError(t, err)
+		return allowed, q
+	}
+
+	allowed, _ := allow(t, 1)
+	require.True(t, allowed)
+
+	// Requests that are denied without exhausting the quota are not cached.
+	allowed, _ = allow(t, 2)
+	require.False(t, allowed)
+	_, ok := l.denials.lookup(quotaKey(limits[1].(*Limited), "127.0.0.1"))
+	assert.False(t, ok)
+
+	allowed, _ = allow(t, 1)
+	require.True(t, allowed)
+	allowed, q := allow(t, 1)
+	require.False(t, allowed)
+	require.NotNil(t, q)
+	_, ok = l.denials.lookup(quotaKey(limits[1].(*Limited), "127.0.0.1"))
+	assert.True(t, ok)
+
+	// Later requests are denied using the cached quota.
+	allowed, cached := allow(t, 1)
+	assert.False(t, allowed)
+	assert.NotSame(t, q, cached)
+	assert.Equal(t, q.Expiration(), cached.Expiration())
+	assert.Equal(t, uint64(0), cached.Remaining())
+
+	// Refunding the quota removes the denial.
+	require.NoError(t, l.Refund("resource", "action", "127.0.0.1", "token", 1))
+	allowed, _ = allow(t, 1)
+	assert.True(t, allowed)
+
+	allowed, _ = allow(t, 1)
+	require.False(t, allowed)
+
+	// Reloading the limits removes the denials.
+	require.NoError(t, l.Reload(limits))
+	assert.Equal(t, int64(0), l.denials.size.Load())
+
+	allowed, _ = allow(t, 1)
+	require.False(t, allowed)
+	assert.Equal(t, int64(1), l.denials.size.Load())
+
+	// The denial expires when the quota resets.
+	c.Advance(time.Minute + time.Nanosecond)
+	allowed, _ = allow(t, 1)
+	assert.True(t, allowed)
+}
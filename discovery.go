@@ -0,0 +1,166 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DiscoveryDocument describes the limits of a Limiter to its clients, so that
+// they can pace their requests without first being denied. It is rendered by
+// Limiter.DiscoveryDocument, and can be served at a well-known URL using
+// Limiter.DiscoveryHandler.
+type DiscoveryDocument struct {
+	Policies []DiscoveryPolicy `json:"policies"`
+}
+
+// DiscoveryPolicy is a limit policy in a DiscoveryDocument.
+type DiscoveryPolicy struct {
+	Resource string           `json:"resource"`
+	Action   string           `json:"action"`
+	Limits   []DiscoveryLimit `json:"limits"`
+	// Overrides are the limits of the policy during the windows of each of
+	// the Limiter's Profiles that has a policy for the same resource and
+	// action.
+	Overrides []DiscoveryOverride `json:"overrides,omitempty"`
+}
+
+// DiscoveryLimit is a limit of a DiscoveryPolicy. Window is the limit's
+// Period in seconds, as in the rate limit policy header.
+type DiscoveryLimit struct {
+	Per           LimitPer `json:"per"`
+	Unlimited     bool     `json:"unlimited,omitempty"`
+	MaxRequests   uint64   `json:"max_requests,omitempty"`
+	Window        int64    `json:"window,omitempty"`
+	GraceRequests uint64   `json:"grace_requests,omitempty"`
+}
+
+// DiscoveryOverride is the limits of a DiscoveryPolicy during the windows of
+// the Profile with the given name.
+type DiscoveryOverride struct {
+	Profile  string                   `json:"profile"`
+	Schedule []DiscoveryProfileWindow `json:"schedule"`
+	Limits   []DiscoveryLimit         `json:"limits"`
+}
+
+// DiscoveryProfileWindow is a ProfileWindow of a DiscoveryOverride. Start is
+// the time since midnight in the window's Location, and Duration is how long
+// the window lasts, both in seconds.
+type DiscoveryProfileWindow struct {
+	Days     []string `json:"days,omitempty"`
+	Start    int64    `json:"start"`
+	Duration int64    `json:"duration"`
+	Location string   `json:"location"`
+}
+
+// DiscoveryDocument renders the Limiter's current limit policies, as returned
+// by Policies, as a DiscoveryDocument. Each policy includes the limits that
+// replace its own during the windows of the Limiter's Profiles, if any.
+func (l *Limiter) DiscoveryDocument() DiscoveryDocument {
+	policies := l.Policies()
+	doc := DiscoveryDocument{Policies: make([]DiscoveryPolicy, 0, len(policies))}
+	overrides := l.discoveryOverrides()
+	for _, p := range policies {
+		doc.Policies = append(doc.Policies, DiscoveryPolicy{
+			Resource:  p.Resource,
+			Action:    p.Action,
+			Limits:    discoveryLimits(p.Limits),
+			Overrides: overrides[limitPolicyKey(p.Resource, p.Action)],
+		})
+	}
+	return doc
+}
+
+// DiscoveryHandler returns an http.Handler that serves the Limiter's
+// DiscoveryDocument as JSON, such as at a well-known URL. The document is
+// rendered for each request, so it reflects limits that have been reloaded.
+func (l *Limiter) DiscoveryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(l.DiscoveryDocument()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		_, _ = w.Write(buf.Bytes())
+	})
+}
+
+// discoveryOverrides returns the overrides of each policy that is replaced
+// by one of the Limiter's Profiles, by its policyKey.
+func (l *Limiter) discoveryOverrides() map[policyKey][]DiscoveryOverride {
+	if l.profiles == nil {
+		return nil
+	}
+
+	l.reloadMu.Lock()
+	classes := l.classes
+	l.reloadMu.Unlock()
+
+	overrides := make(map[policyKey][]DiscoveryOverride)
+	for _, profile := range l.profiles.profiles {
+		policies, err := l.newPolicies(profile.Limits, classes)
+		if err != nil {
+			// The profile cannot be used with the current rate classes, so
+			// it does not override any policies.
+			continue
+		}
+		schedule := discoverySchedule(profile.Schedule)
+		for _, p := range policies.export() {
+			key := limitPolicyKey(p.Resource, p.Action)
+			overrides[key] = append(overrides[key], DiscoveryOverride{
+				Profile:  profile.Name,
+				Schedule: schedule,
+				Limits:   discoveryLimits(p.Limits),
+			})
+		}
+	}
+	return overrides
+}
+
+// discoveryLimits returns the limits as DiscoveryLimits.
+func discoveryLimits(limits []Limit) []DiscoveryLimit {
+	dl := make([]DiscoveryLimit, 0, len(limits))
+	for _, l := range limits {
+		switch ll := l.(type) {
+		case *Unlimited:
+			dl = append(dl, DiscoveryLimit{Per: ll.Per, Unlimited: true})
+		case *Limited:
+			dl = append(dl, DiscoveryLimit{
+				Per:           ll.Per,
+				MaxRequests:   ll.MaxRequests,
+				Window:        int64(ll.Period / time.Second),
+				GraceRequests: ll.GraceRequests,
+			})
+		}
+	}
+	return dl
+}
+
+// discoverySchedule returns the windows as DiscoveryProfileWindows.
+func discoverySchedule(windows []ProfileWindow) []DiscoveryProfileWindow {
+	dw := make([]DiscoveryProfileWindow, 0, len(windows))
+	for _, w := range windows {
+		loc := w.Location
+		if loc == nil {
+			loc = time.UTC
+		}
+		var days []string
+		for _, d := range w.Days {
+			days = append(days, d.String())
+		}
+		dw = append(dw, DiscoveryProfileWindow{
+			Days:     days,
+			Start:    int64(w.Start / time.Second),
+			Duration: int64(w.Duration / time.Second),
+			Location: loc.String(),
+		})
+	}
+	return dw
+}
@@ -0,0 +1,95 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterPolicies(t *testing.T) {
+	l, err := NewLimiter(
+		[]Limit{
+			&Limited{Resource: "b", Action: "action", Per: LimitPerIPAddress, Class: "standard"},
+			&Unlimited{Resource: "b", Action: "action", Per: LimitPerTotal},
+			&Limited{Resource: "a", Action: "action", Per: LimitPerTotal, MaxRequests: 100, Period: time.Minute},
+		},
+		10,
+		WithRateClasses(map[string]RateClass{"standard": {MaxRequests: 10, Period: time.Hour}}),
+	)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	policies := l.Policies()
+	assert.Equal(t, []Policy{
+		{
+			Resource: "a",
+			Action:   "action",
+			Limits: []Limit{
+				&Limited{Resource: "a", Action: "action", Per: LimitPerTotal, MaxRequests: 100, Period: time.Minute},
+			},
+		},
+		{
+			Resource: "b",
+			Action:   "action",
+			Limits: []Limit{
+				&Unlimited{Resource: "b", Action: "action", Per: LimitPerTotal},
+				&Limited{Resource: "b", Action: "action", Per: LimitPerIPAddress, MaxRequests: 10, Period: time.Hour, Class: "standard"},
+			},
+		},
+	}, policies)
+
+	// The returned limits are copies.
+	policies[0].Limits[0].(*Limited).MaxRequests = 1
+	assert.Equal(t, uint64(100), l.ReadOnly().Policies()[0].Limits[0].(*Limited).MaxRequests)
+}
+
+func TestLimiterDiscoveryHandler(t *testing.T) {
+	c := newFakeClock()
+	l, err := NewLimiter(
+		[]Limit{
+			&Limited{Resource: "resource", Action: "action", Per: LimitPerTotal, MaxRequests: 100, Period: time.Minute},
+			&Limited{Resource: "resource", Action: "action", Per: LimitPerIPAddress, MaxRequests: 10, Period: time.Minute, GraceRequests: 2},
+			&Unlimited{Resource: "resource", Action: "action", Per: LimitPerAuthToken},
+			&Unlimited{Resource: "other", Action: "action", Per: LimitPerTotal},
+		},
+		10,
+		WithClock(c),
+		WithProfiles(Profile{
+			Name: "maintenance",
+			Limits: []Limit{
+				&Limited{Resource: "resource", Action: "action", Per: LimitPerTotal, MaxRequests: 10, Period: time.Minute},
+			},
+			Schedule: []ProfileWindow{{Days: []time.Weekday{time.Saturday}, Start: 22 * time.Hour, Duration: 2 * time.Hour}},
+		}),
+	)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	w := httptest.NewRecorder()
+	l.ReadOnly().DiscoveryHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/rate-limits", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"policies": [
+		{"resource": "other", "action": "action", "limits": [
+			{"per": "total", "unlimited": true}
+		]},
+		{"resource": "resource", "action": "action", "limits": [
+			{"per": "total", "max_requests": 100, "window": 60},
+			{"per": "ip-address", "max_requests": 10, "window": 60, "grace_requests": 2},
+			{"per": "auth-token", "unlimited": true}
+		], "overrides": [
+			{"profile": "maintenance", "schedule": [
+				{"days": ["Saturday"], "start": 79200, "duration": 7200, "location": "UTC"}
+			], "limits": [
+				{"per": "total", "max_requests": 10, "window": 60}
+			]}
+		]}
+	]}`, w.Body.String())
+}
@@ -0,0 +1,127 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DurableFileErrorHook is called each time a snapshot could not be written to
+// the file provided via WithDurableFile, such as when its disk is full. The
+// snapshot is written again on the next interval. It is called synchronously
+// by the go routine that writes the snapshots.
+type DurableFileErrorHook func(err error)
+
+// durableFile periodically writes a snapshot of a Limiter's quotas to a file,
+// so that they can be restored when the process restarts.
+type durableFile struct {
+	path      string
+	interval  time.Duration
+	limiter   *Limiter
+	errorHook DurableFileErrorHook
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// newDurableFile restores the Limiter's quotas from the file at path, if it
+// exists, and then starts writing snapshots to it every interval. Errors
+// writing the snapshots are reported to errorHook, if it is not nil.
+func newDurableFile(l *Limiter, path string, interval time.Duration, errorHook DurableFileErrorHook) (*durableFile, error) {
+	const op = "rate.newDurableFile"
+
+	if interval <= 0 {
+		return nil, fmt.Errorf("%s: interval must be greater than zero: %w", op, ErrInvalidParameter)
+	}
+
+	f, err := os.Open(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		// nothing to restore
+	case err != nil:
+		return nil, fmt.Errorf("%s: %w", op, err)
+	default:
+		err := l.Restore(f)
+		f.Close()
+		var full *ErrLimiterFull
+		if err != nil && !errors.As(err, &full) {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	d := &durableFile{
+		path:      path,
+		interval:  interval,
+		limiter:   l,
+		errorHook: errorHook,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go d.run()
+	return d, nil
+}
+
+// run writes a snapshot every d.interval until the durableFile is stopped.
+// Errors are reported to the errorHook, and the snapshot is written again on
+// the next interval, and when the durableFile is stopped.
+func (d *durableFile) run() {
+	defer close(d.done)
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-d.limiter.clock.After(d.interval):
+			if err := d.write(); err != nil && d.errorHook != nil {
+				d.errorHook(err)
+			}
+		}
+	}
+}
+
+// write atomically replaces the file with a new snapshot by writing it to a
+// temporary file in the same directory and renaming it.
+func (d *durableFile) write() error {
+	const op = "rate.(durableFile).write"
+
+	tmp, err := os.CreateTemp(filepath.Dir(d.path), filepath.Base(d.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := d.limiter.Snapshot(tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := os.Rename(tmp.Name(), d.path); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// shutdown stops writing snapshots periodically and then writes a final
+// snapshot. Only the first call writes a snapshot.
+func (d *durableFile) shutdown() error {
+	var err error
+	d.stopOnce.Do(func() {
+		close(d.stop)
+		<-d.done
+		err = d.write()
+	})
+	return err
+}
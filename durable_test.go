@@ -0,0 +1,126 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterDurableFile(t *testing.T) {
+	t.Run("restart", func(t *testing.T) {
+		c := newFakeClock()
+		path := filepath.Join(t.TempDir(), "quotas.json")
+
+		l, err := NewLimiter(snapshotTestLimits(), 10, WithClock(c), WithDurableFile(path, time.Hour))
+		require.NoError(t, err)
+		for i := 0; i < 3; i++ {
+			_, _, err := l.Allow("resource", "action", "127.0.0.1", "")
+			require.NoError(t, err)
+		}
+		require.NoError(t, l.Shutdown())
+		require.NoError(t, l.Shutdown(), "shutdown should be idempotent")
+		require.FileExists(t, path)
+
+		c.Advance(10 * time.Second)
+
+		l, err = NewLimiter(snapshotTestLimits(), 10, WithClock(c), WithDurableFile(path, time.Hour))
+		require.NoError(t, err)
+		t.Cleanup(func() { l.Shutdown() })
+
+		_, quota, err := l.Allow("resource", "action", "127.0.0.1", "")
+		require.NoError(t, err)
+		assert.Equal(t, uint64(6), quota.Remaining())
+		assert.Equal(t, 50*time.Second, quota.ResetsIn())
+	})
+
+	t.Run("periodic", func(t *testing.T) {
+		c := newFakeClock()
+		path := filepath.Join(t.TempDir(), "quotas.json")
+
+		l, err := NewLimiter(snapshotTestLimits(), 10, WithClock(c), WithDurableFile(path, 10*time.Second))
+		require.NoError(t, err)
+		t.Cleanup(func() { l.Shutdown() })
+
+		_, _, err = l.Allow("resource", "action", "127.0.0.1", "")
+		require.NoError(t, err)
+		assert.NoFileExists(t, path)
+
+		// Wait for both the delete and durable file go routines.
+		require.Eventually(t, func() bool { return c.Waiters() == 2 }, time.Second, time.Millisecond)
+		c.Advance(10 * time.Second)
+
+		var snap Snapshot
+		require.Eventually(t, func() bool {
+			b, err := os.ReadFile(path)
+			return err == nil && json.Unmarshal(b, &snap) == nil
+		}, time.Second, time.Millisecond)
+		assert.Len(t, snap.Quotas, 2)
+
+		matches, err := filepath.Glob(path + ".*.tmp")
+		require.NoError(t, err)
+		assert.Empty(t, matches)
+	})
+
+	t.Run("error-hook", func(t *testing.T) {
+		c := newFakeClock()
+		dir := filepath.Join(t.TempDir(), "missing")
+		path := filepath.Join(dir, "quotas.json")
+
+		errs := make(chan error, 1)
+		l, err := NewLimiter(snapshotTestLimits(), 10, WithClock(c), WithDurableFile(path, 10*time.Second),
+			WithDurableFileErrorHook(func(err error) { errs <- err }))
+		require.NoError(t, err)
+		t.Cleanup(func() { l.Shutdown() })
+
+		// The snapshot cannot be written, since the directory does not
+		// exist, which is reported to the hook rather than being ignored.
+		require.Eventually(t, func() bool { return c.Waiters() == 2 }, time.Second, time.Millisecond)
+		c.Advance(10 * time.Second)
+		select {
+		case err := <-errs:
+			assert.ErrorIs(t, err, os.ErrNotExist)
+		case <-time.After(time.Second):
+			require.FailNow(t, "timed out waiting for the error hook")
+		}
+	})
+
+	t.Run("invalid-interval", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "quotas.json")
+		_, err := NewLimiter(snapshotTestLimits(), 10, WithDurableFile(path, 0))
+		assert.ErrorIs(t, err, ErrInvalidParameter)
+	})
+
+	t.Run("invalid-file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "quotas.json")
+		require.NoError(t, os.WriteFile(path, []byte("not a snapshot"), 0o600))
+		_, err := NewLimiter(snapshotTestLimits(), 10, WithDurableFile(path, time.Hour))
+		assert.ErrorIs(t, err, ErrInvalidSnapshot)
+	})
+
+	t.Run("full", func(t *testing.T) {
+		c := newFakeClock()
+		path := filepath.Join(t.TempDir(), "quotas.json")
+
+		l, err := NewLimiter(snapshotTestLimits(), 10, WithClock(c), WithDurableFile(path, time.Hour))
+		require.NoError(t, err)
+		for _, ip := range []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"} {
+			_, _, err := l.Allow("resource", "action", ip, "")
+			require.NoError(t, err)
+		}
+		require.NoError(t, l.Shutdown())
+
+		// Quotas are restored until the Limiter is full.
+		l, err = NewLimiter(snapshotTestLimits(), 2, WithClock(c), WithDurableFile(path, time.Hour))
+		require.NoError(t, err)
+		t.Cleanup(func() { l.Shutdown() })
+		assert.Equal(t, 2, l.quotaFetcher.stats().usage)
+	})
+}
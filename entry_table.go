@@ -0,0 +1,198 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import "hash/maphash"
+
+// minEntryTableSize is the smallest number of slots in an entryTable.
+const minEntryTableSize = 8
+
+// deletedEntry marks a slot of an entryTable whose entry has been deleted, so
+// that lookups continue to probe past it.
+var deletedEntry = &entry{}
+
+// entryTable is an open addressing hash table of entries, keyed by their key.
+// Each slot holds the 64-bit hash of its entry's key along with the entry, so
+// that most probes are resolved by comparing hashes, and the keys are not
+// stored a second time as they would be by a map[string]*entry. Collisions
+// are resolved by linear probing, which keeps the slots that are probed for a
+// key adjacent in memory.
+//
+// Deleted entries are replaced by deletedEntry rather than moving the entries
+// that follow them, so that entries can be deleted while iterating over the
+// table using each. The deleted slots are reclaimed when the table is
+// rehashed.
+//
+// An entryTable is not safe for concurrent use, and is guarded by the store's
+// lock.
+type entryTable struct {
+	seed  maphash.Seed
+	slots []entrySlot
+	// n is the number of entries in the table, and used is the number of
+	// slots that are not empty, including the deleted slots.
+	n    int
+	used int
+}
+
+// entrySlot is a slot of an entryTable. A slot with a nil entry is empty.
+type entrySlot struct {
+	hash uint64
+	e    *entry
+}
+
+// newEntryTable returns an entryTable with enough slots to hold hint entries
+// without being rehashed.
+func newEntryTable(hint int) *entryTable {
+	return &entryTable{
+		seed:  maphash.MakeSeed(),
+		slots: make([]entrySlot, entryTableSize(hint)),
+	}
+}
+
+// entryTableSize returns the number of slots needed to hold n entries while
+// remaining at most three quarters full, which is a power of two so that
+// slots can be indexed by masking a hash.
+func entryTableSize(n int) int {
+	size := minEntryTableSize
+	for size*3/4 < n {
+		size *= 2
+	}
+	return size
+}
+
+// len returns the number of entries in the table.
+func (t *entryTable) len() int {
+	return t.n
+}
+
+// capacity returns the number of slots in the table.
+func (t *entryTable) capacity() int {
+	return len(t.slots)
+}
+
+// get returns the entry with the key, or nil if there is none.
+func (t *entryTable) get(key string) *entry {
+	h := maphash.String(t.seed, key)
+	mask := uint64(len(t.slots) - 1)
+	for i := h & mask; ; i = (i + 1) & mask {
+		s := t.slots[i]
+		switch {
+		case s.e == nil:
+			return nil
+		case s.hash == h && s.e != deletedEntry && s.e.key == key:
+			return s.e
+		}
+	}
+}
+
+// set adds the entry to the table using its key, replacing any entry with
+// the same key. The table is rehashed first if it would otherwise become more
+// than three quarters full.
+func (t *entryTable) set(e *entry) {
+	if (t.used+1)*4 > len(t.slots)*3 {
+		t.rehash(t.n + 1)
+	}
+
+	h := maphash.String(t.seed, e.key)
+	mask := uint64(len(t.slots) - 1)
+	// free is the first deleted slot that was probed, which is reused if the
+	// key is not already in the table.
+	free := -1
+	for i := h & mask; ; i = (i + 1) & mask {
+		s := &t.slots[i]
+		switch {
+		case s.e == nil:
+			if free >= 0 {
+				s = &t.slots[free]
+			} else {
+				t.used++
+			}
+			s.hash, s.e = h, e
+			t.n++
+			return
+		case s.e == deletedEntry:
+			if free < 0 {
+				free = int(i)
+			}
+		case s.hash == h && s.e.key == e.key:
+			s.e = e
+			return
+		}
+	}
+}
+
+// delete removes the entry with the key from the table, and reports whether
+// there was one.
+func (t *entryTable) delete(key string) bool {
+	h := maphash.String(t.seed, key)
+	mask := uint64(len(t.slots) - 1)
+	for i := h & mask; ; i = (i + 1) & mask {
+		s := &t.slots[i]
+		switch {
+		case s.e == nil:
+			return false
+		case s.hash == h && s.e != deletedEntry && s.e.key == key:
+			s.e = deletedEntry
+			t.n--
+			return true
+		}
+	}
+}
+
+// each calls fn for each entry in the table. Entries may be deleted from the
+// table by fn, but must not be added to it.
+func (t *entryTable) each(fn func(e *entry)) {
+	for i := range t.slots {
+		if e := t.slots[i].e; e != nil && e != deletedEntry {
+			fn(e)
+		}
+	}
+}
+
+// rehash moves the entries to new slots, which reclaims the deleted slots.
+// The number of slots is doubled until n entries would fill at most half of
+// them, so that a table whose entries are repeatedly deleted and added is not
+// rehashed on every addition.
+func (t *entryTable) rehash(n int) {
+	size := len(t.slots)
+	for n*2 > size {
+		size *= 2
+	}
+	t.resize(size)
+}
+
+// shrink moves the entries to fewer slots, with enough slots to hold the
+// entries while remaining at most three eighths full, but no fewer than the
+// slots needed to hold hint entries. This allows the memory used by a table
+// that has become mostly empty to be released.
+func (t *entryTable) shrink(hint int) {
+	size := entryTableSize(t.n * 2)
+	if minSize := entryTableSize(hint); size < minSize {
+		size = minSize
+	}
+	if size < len(t.slots) {
+		t.resize(size)
+	}
+}
+
+// resize moves the entries to the given number of slots, which must be a
+// power of two that is enough to hold them.
+func (t *entryTable) resize(size int) {
+	slots := t.slots
+	t.slots = make([]entrySlot, size)
+	t.n, t.used = 0, 0
+	mask := uint64(size - 1)
+	for _, s := range slots {
+		if s.e == nil || s.e == deletedEntry {
+			continue
+		}
+		i := s.hash & mask
+		for t.slots[i].e != nil {
+			i = (i + 1) & mask
+		}
+		t.slots[i] = s
+		t.n++
+		t.used++
+	}
+}
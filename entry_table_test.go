@@ -0,0 +1,101 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntryTable(t *testing.T) {
+	tbl := newEntryTable(0)
+	assert.Equal(t, minEntryTableSize, tbl.capacity())
+
+	const n = 100
+	entries := make([]*entry, n)
+	for i := range entries {
+		entries[i] = &entry{key: strconv.Itoa(i)}
+		tbl.set(entries[i])
+	}
+	require.Equal(t, n, tbl.len())
+	assert.LessOrEqual(t, n*4, tbl.capacity()*3)
+	for _, e := range entries {
+		assert.Same(t, e, tbl.get(e.key))
+	}
+	assert.Nil(t, tbl.get("missing"))
+
+	// Setting an entry with the same key replaces the previous entry.
+	replaced := &entry{key: "0"}
+	tbl.set(replaced)
+	assert.Equal(t, n, tbl.len())
+	assert.Same(t, replaced, tbl.get("0"))
+	entries[0] = replaced
+
+	// Entries can be deleted while iterating.
+	var seen int
+	tbl.each(func(e *entry) {
+		seen++
+		if i, _ := strconv.Atoi(e.key); i%2 == 0 {
+			assert.True(t, tbl.delete(e.key))
+		}
+	})
+	assert.Equal(t, n, seen)
+	assert.Equal(t, n/2, tbl.len())
+	assert.False(t, tbl.delete("0"))
+	for i, e := range entries {
+		if i%2 == 0 {
+			assert.Nil(t, tbl.get(e.key))
+			continue
+		}
+		assert.Same(t, e, tbl.get(e.key))
+	}
+}
+
+func TestEntryTableChurn(t *testing.T) {
+	tbl := newEntryTable(6)
+	capacity := tbl.capacity()
+
+	// Repeatedly deleting and adding entries reuses the deleted slots, and
+	// rehashing to reclaim them does not grow the table.
+	for i := 0; i < 1000; i++ {
+		tbl.set(&entry{key: strconv.Itoa(i)})
+		if i >= 3 {
+			require.True(t, tbl.delete(strconv.Itoa(i-3)))
+		}
+	}
+	assert.Equal(t, 3, tbl.len())
+	assert.Equal(t, capacity, tbl.capacity())
+	for i := 997; i < 1000; i++ {
+		assert.NotNil(t, tbl.get(strconv.Itoa(i)))
+	}
+}
+
+func TestEntryTableShrink(t *testing.T) {
+	tbl := newEntryTable(0)
+	for i := 0; i < 1000; i++ {
+		tbl.set(&entry{key: strconv.Itoa(i)})
+	}
+	for i := 10; i < 1000; i++ {
+		require.True(t, tbl.delete(strconv.Itoa(i)))
+	}
+	tbl.shrink(0)
+	assert.Equal(t, entryTableSize(20), tbl.capacity())
+	assert.Equal(t, 10, tbl.len())
+	for i := 0; i < 10; i++ {
+		assert.NotNil(t, tbl.get(strconv.Itoa(i)))
+	}
+
+	// The table is not shrunk below the size needed for the hint.
+	tbl.shrink(100)
+	assert.Equal(t, entryTableSize(20), tbl.capacity())
+	tbl = newEntryTable(100)
+	tbl.shrink(0)
+	assert.Equal(t, entryTableSize(0), tbl.capacity())
+	tbl = newEntryTable(100)
+	tbl.shrink(100)
+	assert.Equal(t, entryTableSize(100), tbl.capacity())
+}
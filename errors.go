@@ -5,22 +5,137 @@ package rate
 
 import (
 	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 )
 
 // ErrLimiterFull is returned by Limiter.Allow when the limiter cannot store
-// any additional quotas.
+// any additional quotas. It should be checked using errors.As.
 type ErrLimiterFull struct {
+	// RetryIn is how long until the limiter expects to have space for new
+	// quotas, which is when its next quotas are expected to be removed.
 	RetryIn time.Duration
+	// RetryAt is when the limiter expects to have space for new quotas.
+	// Requests that are denied while the limiter remains full have the same
+	// RetryAt, so their RetryIn decreases as RetryAt approaches, rather than
+	// each of them being told to wait for the same duration.
+	RetryAt time.Time
 }
 
 func (l *ErrLimiterFull) Error() string {
 	return "limiter full"
 }
 
+// RetryAfter returns RetryIn, so that ErrLimiterFull can be handled in the
+// same way as ErrRateLimited.
+func (l *ErrLimiterFull) RetryAfter() time.Duration {
+	return l.RetryIn
+}
+
+// ErrRateLimited is returned by Limiter.AllowErr when a request is denied
+// because a quota has been exhausted, so that callers that do not use HTTP,
+// such as gRPC services and job runners, can map denials to their own errors.
+// It wraps ErrDenied, and its details should be read using errors.As.
+//
+// To avoid allocating an error for each denied request, callers can opt in to
+// reusing ErrRateLimited values by calling Release once they are done with
+// one. An ErrRateLimited that is not released is never reused, so it can be
+// kept, wrapped, or shared like any other error.
+type ErrRateLimited struct {
+	Resource string
+	Action   string
+	// Quota is the exhausted quota that caused the request to be denied.
+	Quota *Quota
+
+	retryAfter time.Duration
+}
+
+// errRateLimitedPool is used to reuse the errors returned by
+// newErrRateLimited.
+var errRateLimitedPool = sync.Pool{
+	New: func() any { return new(ErrRateLimited) },
+}
+
+// newErrRateLimited returns an ErrRateLimited for a request that was denied
+// because of the provided quota, which may be nil.
+func newErrRateLimited(resource, action string, quota *Quota) *ErrRateLimited {
+	e := errRateLimitedPool.Get().(*ErrRateLimited)
+	e.Resource, e.Action, e.Quota = resource, action, quota
+	if quota != nil {
+		e.retryAfter = quota.ResetsIn()
+	}
+	return e
+}
+
+func (e *ErrRateLimited) Error() string {
+	return fmt.Sprintf("rate limited: %q %q, retry after %s", e.Resource, e.Action, e.retryAfter)
+}
+
+// Unwrap returns ErrDenied, so that the error can be checked using errors.Is.
+func (e *ErrRateLimited) Unwrap() error {
+	return ErrDenied
+}
+
+// RetryAfter returns how long the caller should wait before retrying the
+// request, which is how long the exhausted quota had until it reset when the
+// request was denied.
+func (e *ErrRateLimited) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// Release returns e to a pool, so that it can be reused for a later denied
+// request. Calling Release is optional. It must only be called by the only
+// holder of e, once it is done with it: after Release, e and any error that
+// wraps it, such as one created by fmt.Errorf with %w or found by errors.As,
+// may be modified by a later denied request. So an error that is kept, such
+// as in a log buffer, returned to a caller, or wrapped, must not be released.
+func (e *ErrRateLimited) Release() {
+	*e = ErrRateLimited{}
+	errRateLimitedPool.Put(e)
+}
+
+// ErrCoverage is returned by CheckCoverage when resources and actions do not
+// have a limit policy.
+type ErrCoverage struct {
+	// Gaps are the resources and actions without a limit policy.
+	Gaps []CoverageGap
+}
+
+// CoverageGap is a resource and action without a limit policy.
+type CoverageGap struct {
+	Resource string
+	Action   string
+}
+
+func (e *ErrCoverage) Error() string {
+	var b strings.Builder
+	b.WriteString("missing limit policies for")
+	for i, g := range e.Gaps {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, " %q %q", g.Resource, g.Action)
+	}
+	return b.String()
+}
+
+// Unwrap returns ErrLimitPolicyNotFound, so that the error can be checked
+// using errors.Is.
+func (e *ErrCoverage) Unwrap() error {
+	return ErrLimitPolicyNotFound
+}
+
+// The following errors are comparable sentinel errors, which are returned
+// as is or wrapped, and should be checked using errors.Is. The errors
+// returned by a Limiter for requests, such as by Allow, Refund, and Charge,
+// are preallocated when they wrap one of these errors, so that requests that
+// fail do not allocate. ErrLimiterFull, ErrRateLimited, and ErrCoverage carry
+// additional details, and should be checked using errors.As.
 var (
-	// ErrLimitNotFound is returned by Limiter.Allow when a limit could not be
-	// found for a given resource+action.
+	// ErrLimitNotFound is returned when a limit policy does not have a limit
+	// for a given LimitPer.
 	ErrLimitNotFound = errors.New("limit not found")
 	// ErrInvalidParameter represents an invalid parameter error.
 	ErrInvalidParameter = errors.New("invalid parameter")
@@ -51,4 +166,83 @@ var (
 	// ErrAllUnlimited is returned by NewLimiter when all of the provided Limits
 	// are Unlimited.
 	ErrAllUnlimited = errors.New("all limits are Unlimited")
+	// ErrEmptyIdentity is returned by Limiter.Allow when a request does not
+	// have an IP address or auth token, and the corresponding limit is
+	// configured with EmptyIdentityDeny.
+	ErrEmptyIdentity = errors.New("empty identity")
+	// ErrInvalidIPAddress is returned by Limiter.Allow when the provided IP
+	// address is not valid and the Limiter was created with
+	// WithStrictIPAddress.
+	ErrInvalidIPAddress = errors.New("invalid ip address")
+	// ErrInvalidSnapshot is returned by Limiter.Restore when the snapshot
+	// cannot be decoded.
+	ErrInvalidSnapshot = errors.New("invalid snapshot")
+	// ErrUnsupportedSnapshotVersion is returned by Limiter.Restore when the
+	// snapshot was written using a newer version of the snapshot format.
+	ErrUnsupportedSnapshotVersion = errors.New("unsupported snapshot version")
+	// ErrClockSkew is returned by Limiter.Restore when a snapshot was created
+	// by a node whose clock is ahead of the Limiter's clock by more than the
+	// max clock skew.
+	ErrClockSkew = errors.New("clock skew exceeds max clock skew")
+	// ErrDenied is wrapped by ErrRateLimited, which is returned by
+	// Limiter.AllowErr when a request is denied.
+	ErrDenied = errors.New("rate limited")
+	// ErrNotSupported is returned by Limiter.Refund and Limiter.Charge when
+	// the Limiter uses a Store, which only supports checking and consuming
+	// quotas via Allow.
+	ErrNotSupported = errors.New("not supported")
+	// ErrStoreUnavailable is returned instead of calling a Limiter's Store
+	// while its StoreBreaker is open. It is handled using the Limiter's
+	// StoreFailureMode, like the errors returned by the Store.
+	ErrStoreUnavailable = errors.New("store unavailable")
 )
+
+// sentinelErrors are the comparable sentinel errors that wrapOp caches the
+// wrapped errors for.
+var sentinelErrors = map[error]bool{
+	ErrLimitNotFound:              true,
+	ErrInvalidParameter:           true,
+	ErrEmptyLimits:                true,
+	ErrInvalidLimit:               true,
+	ErrInvalidLimitPer:            true,
+	ErrDuplicateLimit:             true,
+	ErrInvalidNumberBuckets:       true,
+	ErrInvalidMaxSize:             true,
+	ErrStopped:                    true,
+	ErrInvalidLimitPolicy:         true,
+	ErrLimitPolicyNotFound:        true,
+	ErrAllUnlimited:               true,
+	ErrEmptyIdentity:              true,
+	ErrInvalidIPAddress:           true,
+	ErrInvalidSnapshot:            true,
+	ErrUnsupportedSnapshotVersion: true,
+	ErrClockSkew:                  true,
+	ErrDenied:                     true,
+	ErrNotSupported:               true,
+	ErrStoreUnavailable:           true,
+}
+
+// opError is the key of an error cached by wrapOp.
+type opError struct {
+	op  string
+	err error
+}
+
+// opErrors caches the errors returned by wrapOp.
+var opErrors sync.Map
+
+// wrapOp wraps err with the name of the operation that returned it, like
+// fmt.Errorf("%s: %w", op, err). When err is one of the sentinelErrors, the
+// wrapped error is only created once for each operation, so that it does not
+// allocate in hot paths.
+func wrapOp(op string, err error) error {
+	if !sentinelErrors[err] {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	k := opError{op: op, err: err}
+	if v, ok := opErrors.Load(k); ok {
+		return v.(error)
+	}
+	v, _ := opErrors.LoadOrStore(k, fmt.Errorf("%s: %w", op, err))
+	return v.(error)
+}
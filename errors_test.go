@@ -0,0 +1,76 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrapOp(t *testing.T) {
+	t.Run("sentinel", func(t *testing.T) {
+		err := wrapOp("rate.test", ErrLimitPolicyNotFound)
+		assert.EqualError(t, err, "rate.test: limit policy not found")
+		assert.ErrorIs(t, err, ErrLimitPolicyNotFound)
+		assert.Same(t, err, wrapOp("rate.test", ErrLimitPolicyNotFound))
+		assert.NotSame(t, err, wrapOp("rate.other", ErrLimitPolicyNotFound))
+
+		allocs := testing.AllocsPerRun(100, func() {
+			_ = wrapOp("rate.test", ErrLimitPolicyNotFound)
+		})
+		assert.Zero(t, allocs)
+	})
+	t.Run("not-sentinel", func(t *testing.T) {
+		full := &ErrLimiterFull{}
+		err := wrapOp("rate.test", full)
+		assert.EqualError(t, err, "rate.test: limiter full")
+		var target *ErrLimiterFull
+		require.ErrorAs(t, err, &target)
+		assert.Same(t, full, target)
+
+		other := errors.New("other")
+		err = wrapOp("rate.test", other)
+		assert.ErrorIs(t, err, other)
+		assert.NotSame(t, err, wrapOp("rate.test", other))
+	})
+}
+
+func TestLimiterRequestErrorsPreallocated(t *testing.T) {
+	l, err := NewLimiter([]Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 10,
+			Period:      time.Minute,
+		},
+	}, 1)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	_, _, err = l.Allow("unknown", "action", "127.0.0.1", "token")
+	require.ErrorIs(t, err, ErrLimitPolicyNotFound)
+	err = l.Refund("unknown", "action", "127.0.0.1", "token", 1)
+	require.ErrorIs(t, err, ErrLimitPolicyNotFound)
+	assert.Same(t, err, l.Refund("unknown", "action", "127.0.0.1", "token", 1))
+	err = l.Charge("unknown", "action", "127.0.0.1", "token", 1)
+	require.ErrorIs(t, err, ErrLimitPolicyNotFound)
+	assert.Same(t, err, l.Charge("unknown", "action", "127.0.0.1", "token", 1))
+}
+
+func TestErrRateLimited(t *testing.T) {
+	e := newErrRateLimited("resource", "action", nil)
+	assert.Equal(t, "resource", e.Resource)
+	assert.Equal(t, "action", e.Action)
+	assert.Nil(t, e.Quota)
+	assert.Zero(t, e.RetryAfter())
+	assert.ErrorIs(t, e, ErrDenied)
+
+	e.Release()
+	assert.Zero(t, *e)
+}
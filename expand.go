@@ -0,0 +1,139 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ResourceLimits are the limits for the actions of a resource that are
+// provided to ExpandLimits. The Resource and Action of each of the limits are
+// ignored.
+type ResourceLimits struct {
+	// Limits are the limits for each of the resource's actions. They take
+	// precedence over the default limits with the same LimitPer.
+	Limits []Limit
+	// Actions are the limits for specific actions of the resource. They take
+	// precedence over the resource's Limits with the same LimitPer.
+	Actions map[string][]Limit
+}
+
+// ExpandLimits returns the limits for each action of each resource in the
+// taxonomy, which maps each resource to its actions, so that services with
+// many resources and actions do not need to build each limit by hand. For each
+// action, a limit is created for each LimitPer using the most specific of the
+// limits provided for that LimitPer by the action's entry in the Actions of
+// its resource's ResourceLimits, the resource's Limits, or the defaults. Every
+// action must have a limit for each LimitPer, and resources and actions must
+// be in the taxonomy, otherwise the returned error wraps ErrInvalidLimitPolicy
+// for each of them. The limits are returned sorted by resource and action.
+func ExpandLimits(taxonomy map[string][]string, defaults []Limit, resources map[string]ResourceLimits) ([]Limit, error) {
+	const op = "rate.ExpandLimits"
+
+	var errs []error
+	defaultPer, err := limitsByPer(defaults)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("defaults: %w", err))
+	}
+	for resource, rl := range resources {
+		actions, ok := taxonomy[resource]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown resource %q: %w", resource, ErrInvalidLimitPolicy))
+			continue
+		}
+		for action := range rl.Actions {
+			if !contains(actions, action) {
+				errs = append(errs, fmt.Errorf("unknown action %q for resource %q: %w", action, resource, ErrInvalidLimitPolicy))
+			}
+		}
+	}
+
+	resourceNames := make([]string, 0, len(taxonomy))
+	for resource := range taxonomy {
+		resourceNames = append(resourceNames, resource)
+	}
+	sort.Strings(resourceNames)
+
+	var limits []Limit
+	for _, resource := range resourceNames {
+		rl := resources[resource]
+		resourcePer, err := limitsByPer(rl.Limits)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("resource %q: %w", resource, err))
+		}
+
+		actions := append([]string(nil), taxonomy[resource]...)
+		sort.Strings(actions)
+		for i, action := range actions {
+			if i > 0 && actions[i-1] == action {
+				continue
+			}
+			actionPer, err := limitsByPer(rl.Actions[action])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("resource %q action %q: %w", resource, action, err))
+			}
+			for _, per := range requiredLimitPer {
+				l, ok := actionPer[per]
+				if !ok {
+					l, ok = resourcePer[per]
+				}
+				if !ok {
+					l, ok = defaultPer[per]
+				}
+				if !ok {
+					errs = append(errs, fmt.Errorf("missing limit for %q for resource %q action %q: %w", per, resource, action, ErrInvalidLimitPolicy))
+					continue
+				}
+				limits = append(limits, limitFor(l, resource, action))
+			}
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := newLimitPolicies(limits, true); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return limits, nil
+}
+
+// limitsByPer returns the limits by their LimitPer. If more than one limit has
+// the same LimitPer, ErrDuplicateLimit is returned.
+func limitsByPer(limits []Limit) (map[LimitPer]Limit, error) {
+	m := make(map[LimitPer]Limit, len(limits))
+	for _, l := range limits {
+		if _, ok := m[l.GetPer()]; ok {
+			return nil, fmt.Errorf("%q: %w", l.GetPer(), ErrDuplicateLimit)
+		}
+		m[l.GetPer()] = l
+	}
+	return m, nil
+}
+
+// limitFor returns a copy of the limit for the provided resource and action.
+func limitFor(l Limit, resource, action string) Limit {
+	switch ll := l.(type) {
+	case *Limited:
+		c := *ll
+		c.Resource, c.Action = resource, action
+		return &c
+	case *Unlimited:
+		c := *ll
+		c.Resource, c.Action = resource, action
+		return &c
+	}
+	return l
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandLimits(t *testing.T) {
+	taxonomy := map[string][]string{
+		"target":  {"read", "list", "authorize-session"},
+		"session": {"list", "read"},
+	}
+	defaults := []Limit{
+		&Limited{Per: LimitPerTotal, MaxRequests: 1000, Period: time.Minute},
+		&Limited{Per: LimitPerIPAddress, MaxRequests: 100, Period: time.Minute},
+		&Limited{Per: LimitPerAuthToken, MaxRequests: 100, Period: time.Minute},
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		got, err := ExpandLimits(taxonomy, defaults, map[string]ResourceLimits{
+			"target": {
+				Limits: []Limit{
+					&Limited{Per: LimitPerAuthToken, MaxRequests: 50, Period: time.Minute},
+				},
+				Actions: map[string][]Limit{
+					"authorize-session": {
+						&Unlimited{Per: LimitPerIPAddress},
+						&Limited{Per: LimitPerAuthToken, MaxRequests: 5, Period: time.Minute},
+					},
+				},
+			},
+		})
+		require.NoError(t, err)
+
+		want := []Limit{
+			&Limited{Resource: "session", Action: "list", Per: LimitPerTotal, MaxRequests: 1000, Period: time.Minute},
+			&Limited{Resource: "session", Action: "list", Per: LimitPerIPAddress, MaxRequests: 100, Period: time.Minute},
+			&Limited{Resource: "session", Action: "list", Per: LimitPerAuthToken, MaxRequests: 100, Period: time.Minute},
+			&Limited{Resource: "session", Action: "read", Per: LimitPerTotal, MaxRequests: 1000, Period: time.Minute},
+			&Limited{Resource: "session", Action: "read", Per: LimitPerIPAddress, MaxRequests: 100, Period: time.Minute},
+			&Limited{Resource: "session", Action: "read", Per: LimitPerAuthToken, MaxRequests: 100, Period: time.Minute},
+			&Limited{Resource: "target", Action: "authorize-session", Per: LimitPerTotal, MaxRequests: 1000, Period: time.Minute},
+			&Unlimited{Resource: "target", Action: "authorize-session", Per: LimitPerIPAddress},
+			&Limited{Resource: "target", Action: "authorize-session", Per: LimitPerAuthToken, MaxRequests: 5, Period: time.Minute},
+			&Limited{Resource: "target", Action: "list", Per: LimitPerTotal, MaxRequests: 1000, Period: time.Minute},
+			&Limited{Resource: "target", Action: "list", Per: LimitPerIPAddress, MaxRequests: 100, Period: time.Minute},
+			&Limited{Resource: "target", Action: "list", Per: LimitPerAuthToken, MaxRequests: 50, Period: time.Minute},
+			&Limited{Resource: "target", Action: "read", Per: LimitPerTotal, MaxRequests: 1000, Period: time.Minute},
+			&Limited{Resource: "target", Action: "read", Per: LimitPerIPAddress, MaxRequests: 100, Period: time.Minute},
+			&Limited{Resource: "target", Action: "read", Per: LimitPerAuthToken, MaxRequests: 50, Period: time.Minute},
+		}
+		assert.Equal(t, want, got)
+
+		// The templates are not modified.
+		assert.Empty(t, defaults[0].GetResource())
+
+		l, err := NewLimiter(got, 10, WithStrictPolicies(true))
+		require.NoError(t, err)
+		require.NoError(t, l.Shutdown())
+	})
+
+	cases := []struct {
+		name      string
+		defaults  []Limit
+		resources map[string]ResourceLimits
+		wantErr   error
+	}{
+		{
+			"incomplete",
+			defaults[:2],
+			map[string]ResourceLimits{
+				"target": {Limits: defaults[2:]},
+			},
+			ErrInvalidLimitPolicy,
+		},
+		{
+			"unknown-resource",
+			defaults,
+			map[string]ResourceLimits{
+				"host": {Limits: defaults[2:]},
+			},
+			ErrInvalidLimitPolicy,
+		},
+		{
+			"unknown-action",
+			defaults,
+			map[string]ResourceLimits{
+				"target": {Actions: map[string][]Limit{"delete": defaults[2:]}},
+			},
+			ErrInvalidLimitPolicy,
+		},
+		{
+			"duplicate",
+			append(defaults, &Limited{Per: LimitPerTotal, MaxRequests: 1, Period: time.Minute}),
+			nil,
+			ErrDuplicateLimit,
+		},
+		{
+			"invalid",
+			append(defaults[1:], &Limited{Per: LimitPerTotal, Period: time.Minute}),
+			nil,
+			ErrInvalidLimit,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ExpandLimits(taxonomy, tc.defaults, tc.resources)
+			assert.ErrorIs(t, err, tc.wantErr)
+		})
+	}
+}
@@ -6,6 +6,7 @@ package rate
 import (
 	"context"
 	"fmt"
+	"runtime"
 	"sync"
 	"time"
 
@@ -13,21 +14,54 @@ import (
 )
 
 // bucketSizeThreshold is used to determine when a bucket should get
-// reallocated to release some memory to get garbage collected. While not
-// officially documented, and therefore subject to change, a map will grow once
-// it requires more than 8 keys, so that is used as the threshold when deciding
-// to re-allocate a bucket's entries map.
-const bucketSizeThreshold = 8
+// reallocated to release some memory to get garbage collected. An entryTable
+// grows once it holds more than three quarters of its minimum number of slots,
+// so that is used as the threshold when deciding to re-allocate a bucket's
+// entries table.
+const bucketSizeThreshold = minEntryTableSize * 3 / 4
 
+// itemsShrinkFactor is used to determine when the items table should get
+// reallocated after quotas are removed from the store. Once fewer than one in
+// itemsShrinkFactor of its slots hold a quota, it is replaced with a table
+// that is at most three eighths full, so that it is not reallocated again
+// until it has grown or shrunk considerably.
+const itemsShrinkFactor = 8
+
+// entry is a quota stored in the expirableStore. It uses 48 bytes on 64-bit
+// platforms, which is a size class of the Go allocator, so the bucket and
+// windows are int32s rather than ints. The id is the end of the key, rather
+// than a separate string, so it does not keep the memory of the string it was
+// provided in reachable.
 type entry struct {
 	key   string
+	id    string
 	value *Quota
 
-	bucket int
+	bucket int32
+	// windows is the number of consecutive windows that have elapsed for
+	// this entry. It is used when warm-up is enabled.
+	windows int32
+}
+
+// warmUpHistory records the number of windows for an entry that was removed
+// from the store, so that an identity that continues to make requests is not
+// treated as new.
+type warmUpHistory struct {
+	windows int
+	staleAt time.Time
+	// seq identifies the record in the store's warmUpOrder.
+	seq uint64
+}
+
+// warmUpRecord is the key of a warmUpHistory in the order that they were
+// recorded.
+type warmUpRecord struct {
+	key string
+	seq uint64
 }
 
 type bucket struct {
-	entries map[string]*entry
+	entries *entryTable
 
 	expiresAt time.Time
 }
@@ -36,19 +70,64 @@ type bucket struct {
 // - the purpose and use of "buckets"
 type expirableStore struct {
 	maxSize int
+	maxTTL  time.Duration
 
-	items map[string]*entry
+	items *entryTable
+	// itemsHint is the number of entries that the items table is allocated
+	// to hold, which it is not shrunk below.
+	itemsHint int
 
-	buckets            []bucket
-	bucketTTL          time.Duration
+	buckets   []bucket
+	bucketTTL time.Duration
+	// bucketHint is the number of entries that each bucket's entries table
+	// is allocated to hold.
+	bucketHint int
+	// nextCleanup is when the delete go routine is next expected to empty
+	// an expired bucket.
+	nextCleanup        time.Time
 	numberBuckets      int
 	nextBucketToExpire int
-	capacityMetric     metric.Gauge
-	usageMetric        metric.Gauge
+	// cachedRetryAt is the time computed by retryAt before it is limited to
+	// now, which is reused while the store is full and its buckets do not
+	// change. The zero time indicates that it must be computed again.
+	cachedRetryAt  time.Time
+	capacityMetric metric.Gauge
+	usageMetric    metric.Gauge
+	// gaugePublishInterval is how often the capacity and usage metrics are
+	// published. If zero, the usage metric is set each time it changes.
+	gaugePublishInterval time.Duration
+
+	warmUpFraction float64
+	warmUpWindows  int
+	warmUpHistory  map[string]warmUpHistory
+	// warmUpOrder is the keys of warmUpHistory in the order that they were
+	// recorded, so that the oldest records can be removed without scanning
+	// warmUpHistory. It may contain records that have since been removed
+	// from or replaced in warmUpHistory, which are identified by their seq.
+	warmUpOrder []warmUpRecord
+	warmUpSeq   uint64
+
+	clock Clock
+
+	cleanupBatchSize int
+
+	usageSink UsageSink
+
+	eventHook StoreEventHook
+	// full is true once the store has reached its max size, until quotas
+	// are removed from it.
+	full bool
 
 	mu sync.Mutex
 
-	pool sync.Pool
+	// pool is used to reuse entries once they are removed from the store.
+	// Quotas are not reused, since callers of fetch may still have a
+	// reference to a Quota after its entry has been removed. If slab is not
+	// nil, entries are allocated and reused using it instead.
+	pool           sync.Pool
+	slab           *entrySlab
+	poolHitMetric  metric.Counter
+	poolMissMetric metric.Counter
 
 	cancelFunc context.CancelFunc
 	ctx        context.Context
@@ -66,178 +145,892 @@ func newExpirableStore(maxSize int, maxEntryTTL time.Duration, o ...Option) (*ex
 		return nil, fmt.Errorf("%s: max entry ttl must be greater than zero: %w", op, ErrInvalidParameter)
 	case opts.withNumberBuckets <= 0:
 		return nil, fmt.Errorf("%s: number of buckets must be greater than zero: %w", op, ErrInvalidNumberBuckets)
+	case opts.withCleanupBatchSize <= 0:
+		return nil, fmt.Errorf("%s: cleanup batch size must be greater than zero: %w", op, ErrInvalidParameter)
+	case opts.withWarmUpWindows < 0:
+		return nil, fmt.Errorf("%s: warm-up windows must not be negative: %w", op, ErrInvalidParameter)
+	case opts.withWarmUpWindows > 0 && (opts.withWarmUpFraction <= 0 || opts.withWarmUpFraction > 1):
+		return nil, fmt.Errorf("%s: warm-up fraction must be greater than zero and at most one: %w", op, ErrInvalidParameter)
+	case opts.withGaugePublishInterval < 0:
+		return nil, fmt.Errorf("%s: gauge publish interval must not be negative: %w", op, ErrInvalidParameter)
+	case opts.withEntrySlabSize < 0:
+		return nil, fmt.Errorf("%s: entry slab size must not be negative: %w", op, ErrInvalidParameter)
+	case !opts.withPreallocation.IsValid():
+		return nil, fmt.Errorf("%s: invalid preallocation: %w", op, ErrInvalidParameter)
 	}
 
-	var bucketTTL time.Duration
-	switch opts.withNumberBuckets {
-	case 1:
-		bucketTTL = maxEntryTTL
-	default:
-		bucketTTL = maxEntryTTL / time.Duration(opts.withNumberBuckets-1)
+	bucketTTL := bucketTTLFor(maxEntryTTL, opts.withNumberBuckets)
+
+	itemsHint, bucketHint := maxSize, 0
+	switch opts.withPreallocation {
+	case PreallocateNone:
+		itemsHint = 0
+	case PreallocateBuckets:
+		bucketHint = maxSize / opts.withNumberBuckets
 	}
 
 	buckets := make([]bucket, opts.withNumberBuckets)
 	for i := 0; i < opts.withNumberBuckets; i++ {
 		buckets[i] = bucket{
-			entries: make(map[string]*entry),
+			entries: newEntryTable(bucketHint),
 		}
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	s := &expirableStore{
-		maxSize:       maxSize,
-		items:         make(map[string]*entry, maxSize),
-		buckets:       buckets,
-		bucketTTL:     bucketTTL,
-		numberBuckets: opts.withNumberBuckets,
-		pool: sync.Pool{
-			New: func() any {
-				return &entry{
-					value: &Quota{},
-				}
-			},
-		},
+		maxSize:        maxSize,
+		maxTTL:         maxEntryTTL,
+		items:          newEntryTable(itemsHint),
+		itemsHint:      itemsHint,
+		buckets:        buckets,
+		bucketHint:     bucketHint,
+		bucketTTL:      bucketTTL,
+		nextCleanup:    opts.withClock.Now().Add(bucketTTL),
+		numberBuckets:  opts.withNumberBuckets,
 		cancelFunc:     cancel,
 		ctx:            ctx,
 		capacityMetric: opts.withQuotaStorageCapacityMetric,
 		usageMetric:    opts.withQuotaStorageUsageMetric,
+		warmUpFraction: opts.withWarmUpFraction,
+		warmUpWindows:  opts.withWarmUpWindows,
+		clock:          opts.withClock,
+
+		cleanupBatchSize: opts.withCleanupBatchSize,
+		poolHitMetric:    opts.withQuotaPoolHitMetric,
+		poolMissMetric:   opts.withQuotaPoolMissMetric,
+		usageSink:        opts.withUsageSink,
+		eventHook:        opts.withStoreEventHook,
+
+		gaugePublishInterval: opts.withGaugePublishInterval,
+	}
+	if s.warmUpWindows > 0 {
+		s.warmUpHistory = make(map[string]warmUpHistory)
+	}
+	if opts.withEntrySlabSize > 0 {
+		s.slab = newEntrySlab(opts.withEntrySlabSize)
 	}
 	s.capacityMetric.Set(float64(maxSize))
 	s.usageMetric.Set(float64(0))
 
 	go s.deleteExpired()
+	if s.gaugePublishInterval > 0 {
+		go s.publishGauges()
+	}
 	return s, nil
 }
 
+// bucketTTLFor returns the duration covered by each bucket of a store with the
+// provided max entry ttl and number of buckets.
+func bucketTTLFor(maxEntryTTL time.Duration, numberBuckets int) time.Duration {
+	if numberBuckets == 1 {
+		return maxEntryTTL
+	}
+	return maxEntryTTL / time.Duration(numberBuckets-1)
+}
+
 func (s *expirableStore) shutdown() error {
 	s.cancelFunc()
 	return nil
 }
 
+func (s *expirableStore) maxEntryTTL() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maxTTL
+}
+
+// resize changes the max entry ttl of the store, and the bucketTTL derived
+// from it, and then moves each entry to the bucket for its expiration using
+// the new bucketTTL. Entries that would expire after the new max entry ttl
+// from now are expired at that time instead, so that no entry outlives the
+// store's buckets. The delete go routine uses the new bucketTTL once it next
+// empties a bucket.
+func (s *expirableStore) resize(maxEntryTTL time.Duration) error {
+	const op = "rate.(expirableStore).resize"
+
+	select {
+	case <-s.ctx.Done():
+		return ErrStopped
+	default:
+		// continue
+	}
+	if maxEntryTTL <= 0 {
+		return fmt.Errorf("%s: max entry ttl must be greater than zero: %w", op, ErrInvalidParameter)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if maxEntryTTL == s.maxTTL {
+		return nil
+	}
+	s.maxTTL = maxEntryTTL
+	s.bucketTTL = bucketTTLFor(maxEntryTTL, s.numberBuckets)
+	for i := range s.buckets {
+		s.buckets[i] = bucket{entries: newEntryTable(s.bucketHint)}
+	}
+	s.cachedRetryAt = time.Time{}
+
+	now := s.clock.Now()
+	maxExpiresAt := now.Add(maxEntryTTL)
+	s.items.each(func(e *entry) {
+		expiresAt := e.value.expiration()
+		if expiresAt.After(maxExpiresAt) {
+			expiresAt = maxExpiresAt
+			e.value.extendTo(expiresAt)
+		}
+		ttl := expiresAt.Sub(now)
+		if ttl < 0 {
+			ttl = 0
+		}
+		s.addToBucketIn(e, ttl)
+	})
+	return nil
+}
+
 func (s *expirableStore) deleteExpired() {
-	ticker := time.NewTicker(s.bucketTTL)
-	defer ticker.Stop()
+	s.mu.Lock()
+	wait := s.bucketTTL
+	s.mu.Unlock()
 	for {
 		select {
 		case <-s.ctx.Done():
 			return
-		case <-ticker.C:
-			s.emptyExpiredBucket()
+		case <-s.clock.After(wait):
+			wait = s.emptyExpiredBucket()
 		}
 	}
 }
 
-// TODO: document this
-func (s *expirableStore) fetch(id string, limit *Limited) (*Quota, error) {
+// publishGauges sets the capacity and usage metrics every
+// s.gaugePublishInterval until the store is shutdown.
+func (s *expirableStore) publishGauges() {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.clock.After(s.gaugePublishInterval):
+			s.mu.Lock()
+			usage := s.items.len()
+			s.mu.Unlock()
+			s.capacityMetric.Set(float64(s.maxSize))
+			s.usageMetric.Set(float64(usage))
+		}
+	}
+}
+
+// fetch gets the Quota for the provided id and Limit, creating it if needed.
+// If ctx is done before the Quota is fetched, its error is returned. Since
+// the store is in memory, ctx is only checked before acquiring the lock.
+func (s *expirableStore) fetch(ctx context.Context, id string, limit *Limited) (*Quota, error) {
 	select {
 	case <-s.ctx.Done():
 		return nil, ErrStopped
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	default:
 		// continue
 	}
 
+	return s.fetchKey(quotaKey(limit, id), id, limit)
+}
+
+// fetchKey gets the Quota for the provided key, creating it using the
+// provided Limit if needed.
+func (s *expirableStore) fetchKey(key, id string, limit *Limited) (*Quota, error) {
+	// The usage is exported after the lock is released.
+	var ended []UsageRecord
+	defer func() { exportUsage(s.usageSink, ended) }()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	key := join(limit.Resource, limit.Action, string(limit.Per), id)
-
-	e, ok := s.items[key]
+	e := s.items.get(key)
 	switch {
-	case !ok:
-		e = s.pool.Get().(*entry)
+	case e == nil:
+		// Checking whether the store is full before getting an entry avoids
+		// allocating a Quota that would be discarded.
+		if s.items.len() >= s.maxSize {
+			return nil, s.fullError()
+		}
+		e = s.newEntry()
 		e.key = key
+		e.id = keyID(key, id)
 		e.value.reset(limit)
 		if err := s.add(e); err != nil {
-			s.pool.Put(e)
+			s.putEntry(e)
 			return nil, err
 		}
+		e.windows = int32(s.previousWindows(key))
+		s.warmUp(e)
 	case e.value.Expired():
+		ended = s.appendUsage(ended, e)
 		s.removeFromBucket(e)
 		e.value.reset(limit)
 		s.addToBucket(e)
+		e.windows++
+		s.warmUp(e)
 	}
 
-	s.usageMetric.Set(float64(len(s.items)))
+	s.updateUsage()
 
 	return e.value, nil
 }
 
+// lookup gets the Quota for the provided id and Limit without creating it. If
+// there is no Quota, nil is returned.
+func (s *expirableStore) lookup(id string, limit *Limited) *Quota {
+	key := quotaKey(limit, id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.items.get(key)
+	if e == nil {
+		return nil
+	}
+	return e.value
+}
+
+func (s *expirableStore) stats() storeStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := storeStats{
+		capacity:      s.maxSize,
+		usage:         s.items.len(),
+		bucketEntries: make([]int, len(s.buckets)),
+	}
+	for i, b := range s.buckets {
+		st.bucketEntries[i] = b.entries.len()
+	}
+	return st
+}
+
+// snapshot returns the state of each quota in the store that has not
+// expired.
+func (s *expirableStore) snapshot() []SnapshotQuota {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	quotas := make([]SnapshotQuota, 0, s.items.len())
+	s.items.each(func(e *entry) {
+		if e.value.Expired() {
+			return
+		}
+		quotas = append(quotas, e.value.snapshot(e.id))
+	})
+	return quotas
+}
+
+// restore adds a quota for the provided limit using the state from a
+// snapshot. If a quota already exists for the id, it is not modified.
+func (s *expirableStore) restore(limit *Limited, sq SnapshotQuota) error {
+	select {
+	case <-s.ctx.Done():
+		return ErrStopped
+	default:
+		// continue
+	}
+
+	key := quotaKey(limit, sq.ID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.items.get(key) != nil {
+		return nil
+	}
+
+	e := s.newEntry()
+	e.key = key
+	e.id = keyID(key, sq.ID)
+	e.value.restore(limit, sq)
+	if err := s.addIn(e, e.value.ResetsIn()); err != nil {
+		s.putEntry(e)
+		return err
+	}
+	// Restored quotas belong to existing clients, so they are not warmed up.
+	e.windows = int32(s.warmUpWindows)
+	s.updateUsage()
+	return nil
+}
+
+// rekey moves the Quota for the provided Limit from oldID to newID. If both
+// have a Quota that has not expired, the Quota that expires last is kept and
+// is updated to include the requests used by the other.
+func (s *expirableStore) rekey(limit *Limited, oldID, newID string) error {
+	select {
+	case <-s.ctx.Done():
+		return ErrStopped
+	default:
+		// continue
+	}
+
+	oldKey := quotaKey(limit, oldID)
+	newKey := quotaKey(limit, newID)
+
+	// The usage is exported after the lock is released.
+	var ended []UsageRecord
+	defer func() { exportUsage(s.usageSink, ended) }()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	from := s.items.get(oldKey)
+	if from == nil {
+		return nil
+	}
+	if from.value.Expired() {
+		ended = s.appendUsage(ended, from)
+		s.removeEntry(from)
+		s.updateUsage()
+		return nil
+	}
+
+	to := s.items.get(newKey)
+	switch {
+	case to != nil && !to.value.Expired() && !from.value.expiration().After(to.value.expiration()):
+		to.value.merge(from.value)
+		s.removeEntry(from)
+	default:
+		if to != nil {
+			if to.value.Expired() {
+				ended = s.appendUsage(ended, to)
+			} else {
+				from.value.merge(to.value)
+			}
+			s.removeEntry(to)
+		}
+		s.items.delete(oldKey)
+		s.removeFromBucket(from)
+		from.key = newKey
+		from.id = keyID(newKey, newID)
+		s.items.set(from)
+		s.buckets[from.bucket].entries.set(from)
+	}
+
+	s.updateUsage()
+	return nil
+}
+
+// extend delays the expiration of the Quota for the provided id and Limit by
+// d, up to the store's max TTL from now, and moves it to the bucket for its
+// new expiration so that it is not removed when its previous bucket is
+// emptied. If there is no Quota, or it has expired, nothing is done.
+func (s *expirableStore) extend(id string, limit *Limited, d time.Duration) error {
+	select {
+	case <-s.ctx.Done():
+		return ErrStopped
+	default:
+		// continue
+	}
+
+	key := quotaKey(limit, id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.items.get(key)
+	if e == nil || e.value.Expired() {
+		return nil
+	}
+	now := s.clock.Now()
+	expiresAt := e.value.expiration().Add(d)
+	if maxExpiresAt := now.Add(s.maxTTL); expiresAt.After(maxExpiresAt) {
+		expiresAt = maxExpiresAt
+	}
+	e.value.extendTo(expiresAt)
+	s.removeFromBucket(e)
+	s.addToBucketIn(e, expiresAt.Sub(now))
+	return nil
+}
+
+// shorten ends the window of each Quota early whose limit's Period has been
+// shortened in limits, which are keyed by quotaKey with an empty id, and
+// moves it to the bucket for its new expiration so that it is removed
+// promptly.
+func (s *expirableStore) shorten(limits map[string]*Limited) error {
+	select {
+	case <-s.ctx.Done():
+		return ErrStopped
+	default:
+		// continue
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	s.items.each(func(e *entry) {
+		expiresAt, ok := e.value.shorten(limits)
+		if !ok {
+			return
+		}
+		ttl := expiresAt.Sub(now)
+		if ttl < 0 {
+			ttl = 0
+		}
+		s.removeFromBucket(e)
+		s.addToBucketIn(e, ttl)
+	})
+	return nil
+}
+
 // add attempts to add an entry to the store. If the store has reached its
 // max capacity, ErrLimiterFull is returned.
 //
 // add should always be called by a function that first acquires a lock
 func (s *expirableStore) add(e *entry) error {
-	const op = "rate.(expirableStore).add"
+	return s.addIn(e, e.value.limit.Period+e.value.jitter)
+}
+
+// addIn is like add, but the entry is added to the bucket that expires after
+// the provided ttl.
+//
+// addIn should always be called by a function that first acquires a lock
+func (s *expirableStore) addIn(e *entry, ttl time.Duration) error {
+	const op = "rate.(expirableStore).addIn"
 	if s.mu.TryLock() {
 		panic(fmt.Sprintf("%s: called without lock", op))
 	}
-	if _, ok := s.items[e.key]; !ok && len(s.items) >= s.maxSize {
-		// This is hopefully a reasonable estimate of when space will free up.
-		// However, it might not be accurate:
-		// 1. This is really an upper-bound on when the delete go routine
-		// should run again. So space may free up sooner if the routine runs at
-		// an earlier time.
-		// 2. When the delete go routine runs, it is possible that it does not
-		// have any quotas to delete. In which case clients would need to wait
-		// longer until there is a bucket that has quotas that have expired.
-		return &ErrLimiterFull{RetryIn: s.bucketTTL}
-	}
-	s.items[e.key] = e
-	s.addToBucket(e)
+	if s.items.get(e.key) == nil && s.items.len() >= s.maxSize {
+		return s.fullError()
+	}
+	s.items.set(e)
+	s.addToBucketIn(e, ttl)
 	return nil
 }
 
+// fullError records that the store is full, and returns an ErrLimiterFull
+// with when space is expected to become available.
+//
+// fullError should always be called by a function that first acquires a lock
+func (s *expirableStore) fullError() error {
+	const op = "rate.(expirableStore).fullError"
+	if s.mu.TryLock() {
+		panic(fmt.Sprintf("%s: called without lock", op))
+	}
+	// This is hopefully a reasonable estimate of when space will free up.
+	// However, it might not be accurate:
+	// 1. This is really an upper-bound on when the delete go routine
+	// should run again. So space may free up sooner if the routine runs at
+	// an earlier time.
+	// 2. When the delete go routine runs, it is possible that it does not
+	// have any quotas to delete. In which case clients would need to wait
+	// longer until there is a bucket that has quotas that have expired.
+	if !s.full {
+		s.full = true
+		s.event(StoreEvent{Type: StoreEventFull})
+	}
+	now := s.clock.Now()
+	retryAt := s.retryAt(now)
+	return &ErrLimiterFull{RetryIn: retryAt.Sub(now), RetryAt: retryAt}
+}
+
 // addToBucket adds the entry to a bucket based on the entry's expiration time.
 //
 // addToBucket should always be called by a function that first acquires a lock
 func (s *expirableStore) addToBucket(e *entry) {
-	const op = "rate.(expirableStore).addToBucket"
+	s.addToBucketIn(e, e.value.limit.Period+e.value.jitter)
+}
+
+// addToBucketIn adds the entry to the bucket that expires after the provided
+// ttl.
+//
+// addToBucketIn should always be called by a function that first acquires a lock
+func (s *expirableStore) addToBucketIn(e *entry, ttl time.Duration) {
+	const op = "rate.(expirableStore).addToBucketIn"
+	if s.mu.TryLock() {
+		panic(fmt.Sprintf("%s: called without lock", op))
+	}
+	e.bucket = int32((int(ttl/s.bucketTTL) + s.nextBucketToExpire) % s.numberBuckets)
+	s.buckets[e.bucket].entries.set(e)
+	s.cachedRetryAt = time.Time{}
+	if expiresAt := e.value.expiration(); s.buckets[e.bucket].expiresAt.Before(expiresAt) {
+		s.buckets[e.bucket].expiresAt = expiresAt
+	}
+}
+
+// retryAt returns when space is expected to become available in the store,
+// which is when the delete go routine is expected to empty the next bucket
+// that has entries. Since the go routine empties one bucket every
+// s.bucketTTL, starting at s.nextCleanup, and does not empty a bucket until
+// all of its entries have expired, this is the later of when the bucket's
+// turn comes and when its entries expire. If the time has already passed,
+// now is returned.
+//
+// Since finding the next bucket that has entries can require checking each
+// bucket, the result is cached until an entry is added to or removed from a
+// bucket, or a bucket is emptied, so that a full store can reject requests
+// cheaply.
+//
+// retryAt should always be called by a function that first acquires a lock
+func (s *expirableStore) retryAt(now time.Time) time.Time {
+	const op = "rate.(expirableStore).retryAt"
 	if s.mu.TryLock() {
 		panic(fmt.Sprintf("%s: called without lock", op))
 	}
-	e.bucket = (int(e.value.limit.Period/s.bucketTTL) + s.nextBucketToExpire) % s.numberBuckets
-	s.buckets[e.bucket].entries[e.key] = e
-	if s.buckets[e.bucket].expiresAt.Before(e.value.expiresAt) {
-		s.buckets[e.bucket].expiresAt = e.value.expiresAt
+
+	at := s.cachedRetryAt
+	if at.IsZero() {
+		at = s.nextCleanup
+		for i := 0; i < s.numberBuckets; i++ {
+			b := s.buckets[(s.nextBucketToExpire+i)%s.numberBuckets]
+			if b.entries.len() > 0 {
+				if b.expiresAt.After(at) {
+					at = b.expiresAt
+				}
+				break
+			}
+			at = at.Add(s.bucketTTL)
+		}
+		s.cachedRetryAt = at
 	}
+	if at.Before(now) {
+		return now
+	}
+	return at
 }
 
-// emptyExpiredBuckets is called via a go routine. It should run approximately
+// emptyExpiredBucket is called via a go routine. It should run approximately
 // once every s.bucketTTL to delete all of the items in the next expired bucket.
-func (s *expirableStore) emptyExpiredBucket() {
+// It returns how long to wait before it should be called again. If the next
+// bucket has not yet expired, nothing is deleted and the time until the bucket
+// expires is returned. Otherwise the bucket is emptied and s.bucketTTL is
+// returned.
+//
+// To avoid holding the lock for the entire time it takes to empty a large
+// bucket, the bucket's entries are swapped out for a new table while holding the
+// lock, and then removed from the store in batches of s.cleanupBatchSize,
+// releasing the lock between each batch.
+func (s *expirableStore) emptyExpiredBucket() time.Duration {
 	s.mu.Lock()
 
 	toExpire := s.nextBucketToExpire
+	// Check to see if this has run early and there is still some time before
+	// the bucket expires, in which case the caller should wait until the
+	// bucket has expired before trying again.
+	now := s.clock.Now()
+	if timeToExpire := s.buckets[toExpire].expiresAt.Sub(now); timeToExpire > 0 {
+		s.nextCleanup = now.Add(timeToExpire)
+		s.cachedRetryAt = time.Time{}
+		s.mu.Unlock()
+		return timeToExpire
+	}
 	s.nextBucketToExpire = (s.nextBucketToExpire + 1) % s.numberBuckets
+	s.nextCleanup = now.Add(s.bucketTTL)
+	s.cachedRetryAt = time.Time{}
+	// The bucketTTL is read while holding the lock, since it is changed when
+	// the store is resized.
+	bucketTTL := s.bucketTTL
+
+	// Small buckets are emptied in place. This avoids allocating a new table
+	// when the existing one has not grown beyond the initial size.
+	expired := s.buckets[toExpire].entries
+	if expired.len() <= s.bucketThreshold() {
+		var ended []UsageRecord
+		expired.each(func(delEnt *entry) {
+			ended = s.appendUsage(ended, delEnt)
+			s.recordWindows(delEnt)
+			s.removeEntry(delEnt)
+		})
+		s.updateUsage()
+		s.mu.Unlock()
+		exportUsage(s.usageSink, ended)
+		return bucketTTL
+	}
+
+	// Replacing the table also allows the memory used by the old table to be
+	// released, since deleting the items will not reduce its capacity.
+	s.buckets[toExpire].entries = newEntryTable(s.bucketHint)
+	s.event(StoreEvent{
+		Type:    StoreEventBucketReallocated,
+		Bucket:  toExpire,
+		Entries: expired.len(),
+	})
+	s.mu.Unlock()
 
-	timeToExpire := time.Until(s.buckets[toExpire].expiresAt)
-	// Just in case, check to see if this has run early and there is still some
-	// time before the bucket expires. in which case wait until the bucket has
-	// expired before deleting.
-	if timeToExpire > 0 {
+	// The expired table is no longer reachable by other go routines, so it
+	// can be read without holding the lock.
+	entries := make([]*entry, 0, expired.len())
+	expired.each(func(e *entry) {
+		entries = append(entries, e)
+	})
+	for len(entries) > 0 {
+		n := s.cleanupBatchSize
+		if n > len(entries) {
+			n = len(entries)
+		}
+		s.mu.Lock()
+		ended := s.removeOrphaned(entries[:n])
+		s.updateUsage()
 		s.mu.Unlock()
-		time.Sleep(timeToExpire)
+		exportUsage(s.usageSink, ended)
+		entries = entries[n:]
+		runtime.Gosched()
+	}
+	return bucketTTL
+}
+
+// compact removes the expired entries from each bucket, rather than waiting
+// for the bucket to be emptied by the delete go routine. If a bucket's entries
+// table has grown beyond bucketSizeThreshold and entries were removed from it,
+// the table is reallocated so that its memory can be released. The lock is
+// acquired for one bucket at a time.
+func (s *expirableStore) compact() error {
+	select {
+	case <-s.ctx.Done():
+		return ErrStopped
+	default:
+		// continue
+	}
+
+	for i := 0; i < s.numberBuckets; i++ {
 		s.mu.Lock()
+		ended := s.compactBucket(i)
+		s.updateUsage()
+		s.mu.Unlock()
+		exportUsage(s.usageSink, ended)
+		runtime.Gosched()
 	}
-	defer s.mu.Unlock()
+	return nil
+}
+
+// compactBucket removes the expired entries from the bucket at index i, and
+// reallocates the bucket's entries table if it was oversized. The usage of the
+// removed entries is returned if there is a usage sink.
+//
+// compactBucket should always be called by a function that first acquires a lock
+func (s *expirableStore) compactBucket(i int) []UsageRecord {
+	const op = "rate.(expirableStore).compactBucket"
+	if s.mu.TryLock() {
+		panic(fmt.Sprintf("%s: called without lock", op))
+	}
+	entries := s.buckets[i].entries
+	before := entries.len()
+
+	var ended []UsageRecord
+	entries.each(func(e *entry) {
+		if !e.value.Expired() {
+			return
+		}
+		ended = s.appendUsage(ended, e)
+		s.recordWindows(e)
+		s.removeEntry(e)
+	})
+
+	if before > s.bucketThreshold() && entries.len() < before {
+		hint := entries.len()
+		if hint < s.bucketHint {
+			hint = s.bucketHint
+		}
+		remaining := newEntryTable(hint)
+		entries.each(remaining.set)
+		s.buckets[i].entries = remaining
+		s.event(StoreEvent{
+			Type:    StoreEventBucketReallocated,
+			Bucket:  i,
+			Entries: before,
+		})
+	}
+	return ended
+}
 
-	// Get the length of the map prior to deleting entries. While we cannot
-	// get the true capacity of the map, it must be at least this length,
-	// and deleting the items will not reduce its capacity. So this length
-	// will be used to determine if we should re-allocate the map to allow
-	// some memory to be released.
-	entryCount := len(s.buckets[toExpire].entries)
-	for _, delEnt := range s.buckets[toExpire].entries {
-		s.removeEntry(delEnt)
+// bucketThreshold returns the number of entries beyond which a bucket's
+// entries table is reallocated when entries are removed from it, which is
+// bucketSizeThreshold unless buckets are allocated to hold more entries.
+func (s *expirableStore) bucketThreshold() int {
+	if s.bucketHint > bucketSizeThreshold {
+		return s.bucketHint
 	}
+	return bucketSizeThreshold
+}
 
-	// Only re-allocate if the map grew beyond the initial size.
-	if entryCount > bucketSizeThreshold {
-		s.buckets[toExpire] = bucket{
-			entries: make(map[string]*entry),
+// removeOrphaned removes entries that were in an expired bucket from the
+// store. While the lock was released, an entry may have been fetched, and
+// therefore reset and added to a new bucket. Such entries are not removed. The
+// usage of the removed entries is returned if there is a usage sink.
+//
+// removeOrphaned should always be called by a function that first acquires a lock
+func (s *expirableStore) removeOrphaned(entries []*entry) []UsageRecord {
+	const op = "rate.(expirableStore).removeOrphaned"
+	if s.mu.TryLock() {
+		panic(fmt.Sprintf("%s: called without lock", op))
+	}
+	var ended []UsageRecord
+	for _, e := range entries {
+		if s.buckets[e.bucket].entries.get(e.key) != nil {
+			continue
 		}
+		if s.items.get(e.key) != e {
+			continue
+		}
+		ended = s.appendUsage(ended, e)
+		s.recordWindows(e)
+		s.items.delete(e.key)
+		s.putEntry(e)
+	}
+	return ended
+}
+
+// updateUsage sets the usage metric, unless it is published periodically,
+// and records that the store is no longer full if quotas have been removed.
+//
+// updateUsage should always be called by a function that first acquires a lock
+func (s *expirableStore) updateUsage() {
+	const op = "rate.(expirableStore).updateUsage"
+	if s.mu.TryLock() {
+		panic(fmt.Sprintf("%s: called without lock", op))
+	}
+	if s.gaugePublishInterval == 0 {
+		s.usageMetric.Set(float64(s.items.len()))
+	}
+	if s.full && s.items.len() < s.maxSize {
+		s.full = false
+		s.event(StoreEvent{Type: StoreEventNotFull})
+	}
+	s.shrinkItems()
+}
+
+// shrinkItems reallocates the items table if it is mostly empty and larger
+// than the size it was allocated with, so that a store that once held many
+// more quotas than it does now releases the memory used to look them up.
+//
+// shrinkItems should always be called by a function that first acquires a lock
+func (s *expirableStore) shrinkItems() {
+	const op = "rate.(expirableStore).shrinkItems"
+	if s.mu.TryLock() {
+		panic(fmt.Sprintf("%s: called without lock", op))
+	}
+	capacity := s.items.capacity()
+	if capacity <= entryTableSize(s.itemsHint) || s.items.len()*itemsShrinkFactor >= capacity {
+		return
+	}
+	s.items.shrink(s.itemsHint)
+	if s.items.capacity() < capacity {
+		s.event(StoreEvent{Type: StoreEventItemsReallocated})
+	}
+}
+
+// event calls the event hook, if there is one, after setting the time, usage,
+// and capacity of the event.
+//
+// event should always be called by a function that first acquires a lock
+func (s *expirableStore) event(e StoreEvent) {
+	if s.eventHook == nil {
+		return
+	}
+	e.Time = s.clock.Now()
+	e.Usage = s.items.len()
+	e.Capacity = s.maxSize
+	s.eventHook(e)
+}
+
+// appendUsage appends the usage of the entry's current window to records if
+// there is a usage sink.
+func (s *expirableStore) appendUsage(records []UsageRecord, e *entry) []UsageRecord {
+	if s.usageSink == nil {
+		return records
+	}
+	return append(records, e.value.usage(e.id))
+}
+
+// warmUp sets the fraction of MaxRequests available to the entry's quota
+// based on the number of windows that have elapsed for the entry. Only quotas
+// for IP addresses and auth tokens are warmed up.
+//
+// warmUp should always be called by a function that first acquires a lock
+func (s *expirableStore) warmUp(e *entry) {
+	const op = "rate.(expirableStore).warmUp"
+	if s.mu.TryLock() {
+		panic(fmt.Sprintf("%s: called without lock", op))
+	}
+	if s.warmUpWindows <= 0 || e.value.limit.Per == LimitPerTotal || int(e.windows) >= s.warmUpWindows {
+		return
+	}
+	f := s.warmUpFraction + (1-s.warmUpFraction)*float64(e.windows)/float64(s.warmUpWindows)
+	e.value.setWarmUp(f)
+}
+
+// previousWindows returns the number of windows recorded for the key when
+// it was last removed from the store. If the key was not recorded, or the
+// record is stale, zero is returned.
+//
+// previousWindows should always be called by a function that first acquires a lock
+func (s *expirableStore) previousWindows(key string) int {
+	const op = "rate.(expirableStore).previousWindows"
+	if s.mu.TryLock() {
+		panic(fmt.Sprintf("%s: called without lock", op))
+	}
+	if s.warmUpHistory == nil {
+		return 0
+	}
+	h, ok := s.warmUpHistory[key]
+	if !ok {
+		return 0
+	}
+	delete(s.warmUpHistory, key)
+	if s.clock.Now().After(h.staleAt) {
+		return 0
+	}
+	return h.windows + 1
+}
+
+// recordWindows records the number of windows for an entry that is about to
+// be removed from the store. An entry's record becomes stale if a new quota
+// is not requested within one period of the entry's expiration. The number of
+// records is limited to the max size of the store; once it is reached, the
+// oldest record is removed. Stale records are removed from the oldest until
+// one that is not stale is found, so each record is only visited once when it
+// is removed.
+//
+// recordWindows should always be called by a function that first acquires a lock
+func (s *expirableStore) recordWindows(e *entry) {
+	const op = "rate.(expirableStore).recordWindows"
+	if s.mu.TryLock() {
+		panic(fmt.Sprintf("%s: called without lock", op))
+	}
+	if s.warmUpHistory == nil || e.value.limit.Per == LimitPerTotal {
+		return
+	}
+	now := s.clock.Now()
+	for len(s.warmUpOrder) > 0 {
+		r := s.warmUpOrder[0]
+		if h, ok := s.warmUpHistory[r.key]; ok && h.seq == r.seq && !now.After(h.staleAt) {
+			break
+		}
+		s.popWarmUpRecord()
+	}
+	for len(s.warmUpOrder) >= s.maxSize {
+		s.popWarmUpRecord()
+	}
+
+	s.warmUpSeq++
+	s.warmUpHistory[e.key] = warmUpHistory{
+		windows: int(e.windows),
+		staleAt: e.value.expiration().Add(e.value.limit.Period),
+		seq:     s.warmUpSeq,
+	}
+	s.warmUpOrder = append(s.warmUpOrder, warmUpRecord{key: e.key, seq: s.warmUpSeq})
+}
+
+// popWarmUpRecord removes the oldest record in warmUpOrder, along with its
+// warmUpHistory if it has not since been removed or replaced.
+//
+// popWarmUpRecord should always be called by a function that first acquires a lock
+func (s *expirableStore) popWarmUpRecord() {
+	const op = "rate.(expirableStore).popWarmUpRecord"
+	if s.mu.TryLock() {
+		panic(fmt.Sprintf("%s: called without lock", op))
+	}
+	r := s.warmUpOrder[0]
+	// The record is cleared so that its key can be garbage collected.
+	s.warmUpOrder[0] = warmUpRecord{}
+	s.warmUpOrder = s.warmUpOrder[1:]
+	if h, ok := s.warmUpHistory[r.key]; ok && h.seq == r.seq {
+		delete(s.warmUpHistory, r.key)
 	}
-	s.usageMetric.Set(float64(len(s.items)))
 }
 
 // removeEntry removes the entry from the store and adds the entry back to
@@ -249,8 +1042,51 @@ func (s *expirableStore) removeEntry(e *entry) {
 	if s.mu.TryLock() {
 		panic(fmt.Sprintf("%s: called without lock", op))
 	}
-	delete(s.items, e.key)
+	s.items.delete(e.key)
 	s.removeFromBucket(e)
+	s.putEntry(e)
+}
+
+// newEntry returns an entry from the sync pool, or allocates a new entry if
+// the pool is empty. If the store uses an entrySlab, the entry is returned
+// from it instead. The entry is given a new Quota.
+//
+// newEntry should always be called by a function that first acquires a lock
+func (s *expirableStore) newEntry() *entry {
+	var e *entry
+	var ok bool
+	switch {
+	case s.slab != nil:
+		e, ok = s.slab.get()
+	default:
+		e, ok = s.pool.Get().(*entry)
+	}
+	switch {
+	case ok:
+		s.poolHitMetric.Add(1)
+	default:
+		s.poolMissMetric.Add(1)
+		if e == nil {
+			e = &entry{}
+		}
+	}
+	e.value = &Quota{clock: s.clock}
+	return e
+}
+
+// putEntry adds the entry back to the sync pool, or the store's entrySlab.
+// The entry's Quota is not reused.
+//
+// putEntry should always be called by a function that first acquires a lock
+func (s *expirableStore) putEntry(e *entry) {
+	e.key = ""
+	e.id = ""
+	e.value = nil
+	e.windows = 0
+	if s.slab != nil {
+		s.slab.put(e)
+		return
+	}
 	s.pool.Put(e)
 }
 
@@ -262,7 +1098,8 @@ func (s *expirableStore) removeFromBucket(e *entry) {
 	if s.mu.TryLock() {
 		panic(fmt.Sprintf("%s: called without lock", op))
 	}
-	delete(s.buckets[e.bucket].entries, e.key)
+	s.buckets[e.bucket].entries.delete(e.key)
+	s.cachedRetryAt = time.Time{}
 }
 
 // ensure expirableStore can be used as a quotaFetcher
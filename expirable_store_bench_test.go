@@ -4,7 +4,11 @@
 package rate
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -40,9 +44,9 @@ func Benchmark_expirableStore(b *testing.B) {
 	}
 }
 
-// Benchmark_bucket shows how much memory is allocated when creating a map
-// of buckets at the bucketSizeThreshold. This is mainly to detect if
-// something changes with how go allocates maps.
+// Benchmark_bucket shows how much memory is allocated when creating the
+// entries table of a bucket at the bucketSizeThreshold. This is mainly to
+// detect if the table grows earlier than expected.
 func Benchmark_bucket(b *testing.B) {
 	cases := []int{
 		bucketSizeThreshold,
@@ -54,9 +58,108 @@ func Benchmark_bucket(b *testing.B) {
 			b.ReportAllocs()
 			for i := 0; i < b.N; i++ {
 				benchBucket = bucket{
-					entries: make(map[string]*entry, bc),
+					entries: newEntryTable(bc),
 				}
 			}
 		})
 	}
 }
+
+// Benchmark_expirableStoreFill reports on the memory allocated to fill a store
+// of different max sizes with quotas, with and without an entrySlab. It is
+// used to compare the number of allocations, each of which is an object that
+// the garbage collector must track.
+func Benchmark_expirableStoreFill(b *testing.B) {
+	limit := &Limited{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerIPAddress,
+		MaxRequests: 10,
+		Period:      time.Minute,
+	}
+	sizes := []int{2048, 32768}
+	slabs := []int{0, 1024}
+	for _, size := range sizes {
+		ids := make([]string, size)
+		for i := range ids {
+			ids[i] = strconv.Itoa(i)
+		}
+		for _, slab := range slabs {
+			b.Run(fmt.Sprintf("%d/slab-%d", size, slab), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					var err error
+					benchStore, err = newExpirableStore(size, time.Minute, WithEntrySlabSize(slab))
+					if err != nil {
+						b.Fatal(err)
+					}
+					for _, id := range ids {
+						if _, err := benchStore.fetch(context.Background(), id, limit); err != nil {
+							b.Fatal(err)
+						}
+					}
+					benchStore.shutdown()
+				}
+			})
+		}
+	}
+}
+
+// Benchmark_expirableStoreChurn reports on fetching quotas for new ids from
+// many goroutines while the store is at capacity, and its quotas are
+// constantly expiring and being removed by the delete go routine. This is
+// the regime where ErrLimiterFull is returned for most fetches, while
+// buckets are reallocated as they are emptied. The fraction of fetches that
+// returned ErrLimiterFull is reported as full/op.
+func Benchmark_expirableStoreChurn(b *testing.B) {
+	limit := &Limited{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerIPAddress,
+		MaxRequests: 10,
+		Period:      10 * time.Millisecond,
+	}
+	cases := []struct {
+		size    int
+		buckets int
+	}{
+		{2048, 16},
+		{32768, 16},
+		{32768, DefaultNumberBuckets},
+	}
+	for _, bc := range cases {
+		b.Run(fmt.Sprintf("%d/buckets-%d", bc.size, bc.buckets), func(b *testing.B) {
+			s, err := newExpirableStore(bc.size, limit.Period, WithNumberBuckets(bc.buckets))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer s.shutdown()
+			for i := 0; i < bc.size; i++ {
+				if _, err := s.fetch(context.Background(), strconv.Itoa(i), limit); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			var next, full atomic.Int64
+			next.Store(int64(bc.size))
+			b.SetParallelism(8)
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				var errFull *ErrLimiterFull
+				for pb.Next() {
+					_, err := s.fetch(context.Background(), strconv.FormatInt(next.Add(1), 10), limit)
+					switch {
+					case err == nil:
+					case errors.As(err, &errFull):
+						full.Add(1)
+					default:
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.ReportMetric(float64(full.Load())/float64(b.N), "full/op")
+		})
+	}
+}
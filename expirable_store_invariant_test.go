@@ -0,0 +1,106 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test_storeInvariants randomly interleaves requests, the passage of time, and
+// the deletion of expired quotas using a fake clock, and checks that the
+// store's invariants hold after each step.
+func Test_storeInvariants(t *testing.T) {
+	seed := time.Now().UnixNano()
+	t.Logf("seed: %d", seed)
+	r := rand.New(rand.NewSource(seed))
+
+	periods := []time.Duration{10 * time.Minute, 30 * time.Minute, time.Hour}
+	limits := make([]Limit, 0, len(periods)*len(requiredLimitPer))
+	resources := make([]string, 0, len(periods))
+	for i, period := range periods {
+		res := fmt.Sprintf("resource-%d", i)
+		resources = append(resources, res)
+		for _, per := range requiredLimitPer {
+			limits = append(limits, &Limited{
+				Resource:    res,
+				Action:      "action",
+				Per:         per,
+				MaxRequests: uint64(r.Intn(10) + 1),
+				Period:      period,
+			})
+		}
+	}
+
+	const maxSize = 50
+	c := newFakeClock()
+	l, err := NewLimiter(limits, maxSize, WithClock(c), WithNumberBuckets(r.Intn(20)+1))
+	require.NoError(t, err)
+	defer l.Shutdown()
+	s := l.quotaFetcher.(*expirableStore)
+
+	for i := 0; i < 5000; i++ {
+		switch op := r.Intn(10); {
+		case op < 7:
+			res := resources[r.Intn(len(resources))]
+			ip := fmt.Sprintf("ip-%d", r.Intn(20))
+			token := fmt.Sprintf("token-%d", r.Intn(20))
+			_, _, err := l.Allow(res, "action", ip, token)
+			if err != nil {
+				var full *ErrLimiterFull
+				require.ErrorAs(t, err, &full)
+			}
+		case op < 9:
+			c.Advance(time.Duration(r.Int63n(int64(s.bucketTTL))))
+		default:
+			// Advance to when the next bucket expires, as the delete go
+			// routine would, then delete it.
+			s.mu.Lock()
+			next := s.buckets[s.nextBucketToExpire].expiresAt
+			s.mu.Unlock()
+			if d := next.Sub(c.Now()); d > 0 {
+				c.Advance(d)
+			}
+			s.emptyExpiredBucket()
+		}
+
+		checkStoreInvariants(t, s)
+	}
+}
+
+func checkStoreInvariants(t *testing.T, s *expirableStore) {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	require.LessOrEqual(t, s.items.len(), s.maxSize, "items exceed max size")
+
+	var inBuckets int
+	for i, b := range s.buckets {
+		inBuckets += b.entries.len()
+		b.entries.each(func(e *entry) {
+			require.Same(t, e, b.entries.get(e.key), "bucket entry not found by key")
+			require.Equal(t, i, int(e.bucket), "entry in wrong bucket")
+			require.Same(t, e, s.items.get(e.key), "bucket entry not in items")
+			require.False(t, b.expiresAt.Before(e.value.Expiration()), "bucket expires before entry")
+		})
+	}
+	require.LessOrEqual(t, inBuckets, s.items.len(), "more bucket entries than items")
+
+	s.items.each(func(e *entry) {
+		require.Same(t, e, s.items.get(e.key), "item not found by key")
+		if s.buckets[e.bucket].entries.get(e.key) == nil {
+			// The entry's bucket is being emptied, so it must have expired.
+			require.True(t, e.value.Expired(), "item not in a bucket has not expired")
+		}
+		e.value.mu.RLock()
+		used, maxRequests := e.value.used, e.value.limit.MaxRequests
+		e.value.mu.RUnlock()
+		require.LessOrEqual(t, used, maxRequests, "used exceeds max requests")
+	})
+}
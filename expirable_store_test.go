@@ -4,8 +4,10 @@
 package rate
 
 import (
+	"context"
 	"fmt"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -119,12 +121,13 @@ func Test_storeCapacity(t *testing.T) {
 
 	var i int
 	for ; i < maxSize; i++ {
-		_, err := s.fetch(fmt.Sprintf("id-%d", i), limit)
+		_, err := s.fetch(context.Background(), fmt.Sprintf("id-%d", i), limit)
 		require.NoError(t, err)
 	}
 
-	_, err = s.fetch(fmt.Sprintf("id-%d", maxSize), limit)
+	_, err = s.fetch(context.Background(), fmt.Sprintf("id-%d", maxSize), limit)
 	require.EqualError(t, err, (&ErrLimiterFull{}).Error())
+
 }
 
 func Test_storeDeleteExpired(t *testing.T) {
@@ -155,15 +158,15 @@ func Test_storeDeleteExpired(t *testing.T) {
 	}
 
 	for _, id := range ids {
-		_, err := s.fetch(id, short)
+		_, err := s.fetch(context.Background(), id, short)
 		require.NoError(t, err)
 
-		_, err = s.fetch(id, long)
+		_, err = s.fetch(context.Background(), id, long)
 		require.NoError(t, err)
 	}
 
 	s.mu.Lock()
-	got := len(s.items)
+	got := s.items.len()
 	s.mu.Unlock()
 	require.Equal(t, 10, got)
 
@@ -171,7 +174,7 @@ func Test_storeDeleteExpired(t *testing.T) {
 	time.Sleep(short.Period * 2)
 
 	s.mu.Lock()
-	got = len(s.items)
+	got = s.items.len()
 	s.mu.Unlock()
 	require.Equal(t, 5, got)
 }
@@ -198,13 +201,13 @@ func Test_ResetBucketSize(t *testing.T) {
 	}
 
 	for _, id := range ids {
-		_, err := s.fetch(id, limit)
+		_, err := s.fetch(context.Background(), id, limit)
 		require.NoError(t, err)
 	}
 
 	s.mu.Lock()
-	got := len(s.items)
-	gotBucketSize := len(s.buckets[0].entries)
+	got := s.items.len()
+	gotBucketSize := s.buckets[0].entries.len()
 	require.Equal(t, 1, len(s.buckets))
 	initialBucketPtr := reflect.ValueOf(s.buckets[0].entries).Pointer()
 	s.mu.Unlock()
@@ -215,13 +218,13 @@ func Test_ResetBucketSize(t *testing.T) {
 	time.Sleep(maxPeriod * 2)
 
 	s.mu.Lock()
-	got = len(s.items)
-	gotBucketSize = len(s.buckets[0].entries)
+	got = s.items.len()
+	gotBucketSize = s.buckets[0].entries.len()
 	newBucketPtr := reflect.ValueOf(s.buckets[0].entries).Pointer()
 	s.mu.Unlock()
 	require.Equal(t, 0, got)
 	require.Equal(t, 0, gotBucketSize)
-	// Check that we have a pointer to a new map, since it should have allocated
+	// Check that we have a pointer to a new table, since it should have allocated
 	// a new one to reduce the capacity.
 	require.NotEqual(t, initialBucketPtr, newBucketPtr)
 }
@@ -243,7 +246,7 @@ func Test_storeFetchExpired(t *testing.T) {
 	}
 	id := "id"
 
-	q, err := s.fetch(id, limit)
+	q, err := s.fetch(context.Background(), id, limit)
 	require.NoError(t, err)
 	assert.Equal(t, uint64(10), q.Remaining())
 	// Consume a quota so that remaining is now 9
@@ -253,8 +256,1022 @@ func Test_storeFetchExpired(t *testing.T) {
 	// Wait for the quota to expire
 	time.Sleep(q.ResetsIn())
 
-	q, err = s.fetch(id, limit)
+	q, err = s.fetch(context.Background(), id, limit)
 	require.NoError(t, err)
 	// Ensure quota has reset.
 	assert.Equal(t, uint64(10), q.Remaining())
 }
+
+func Test_storeFetchCanceled(t *testing.T) {
+	s, err := newExpirableStore(10, time.Minute)
+	require.NoError(t, err)
+	defer s.shutdown()
+
+	limit := &Limited{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerTotal,
+		MaxRequests: 10,
+		Period:      time.Minute,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	q, err := s.fetch(ctx, "id", limit)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, q)
+
+	s.mu.Lock()
+	assert.Zero(t, s.items.len())
+	s.mu.Unlock()
+}
+
+func Test_storeWarmUp(t *testing.T) {
+	s, err := newExpirableStore(20, time.Minute, WithWarmUp(0.5, 2))
+	require.NoError(t, err)
+	defer s.shutdown()
+
+	ipLimit := &Limited{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerIPAddress,
+		MaxRequests: 10,
+		Period:      time.Millisecond,
+	}
+	totalLimit := &Limited{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerTotal,
+		MaxRequests: 10,
+		Period:      time.Millisecond,
+	}
+
+	q, err := s.fetch(context.Background(), "total", totalLimit)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), q.MaxRequests())
+
+	for _, want := range []uint64{5, 7, 10, 10} {
+		q, err = s.fetch(context.Background(), "127.0.0.1", ipLimit)
+		require.NoError(t, err)
+		assert.Equal(t, want, q.MaxRequests())
+		assert.Equal(t, want, q.Remaining())
+		time.Sleep(q.ResetsIn())
+	}
+
+	// An identity that is removed from the store and returns within a
+	// period should not be treated as new.
+	s.mu.Lock()
+	e := s.items.get(quotaKey(ipLimit, "127.0.0.1"))
+	e.windows = 0
+	s.recordWindows(e)
+	s.removeEntry(e)
+	s.mu.Unlock()
+
+	q, err = s.fetch(context.Background(), "127.0.0.1", ipLimit)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(7), q.MaxRequests())
+}
+
+func Test_storeWarmUpHistory(t *testing.T) {
+	c := newFakeClock()
+	s, err := newExpirableStore(2, time.Minute, WithWarmUp(0.5, 2), WithClock(c))
+	require.NoError(t, err)
+	defer s.shutdown()
+
+	limit := &Limited{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerIPAddress,
+		MaxRequests: 10,
+		Period:      time.Minute,
+	}
+	record := func(key string, windows int32) {
+		q := &Quota{clock: c}
+		q.reset(limit)
+		s.mu.Lock()
+		s.recordWindows(&entry{key: key, value: q, windows: windows})
+		s.mu.Unlock()
+	}
+	previous := func(key string) int {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.previousWindows(key)
+	}
+
+	// Once there are max size records, the oldest is removed.
+	record("1", 1)
+	record("2", 2)
+	record("3", 3)
+	assert.Zero(t, previous("1"))
+	assert.Equal(t, 3, previous("2"))
+	assert.Equal(t, 4, previous("3"))
+
+	// A record that is replaced is not removed in place of the new record.
+	record("1", 1)
+	record("1", 2)
+	record("2", 2)
+	assert.Equal(t, 3, previous("1"))
+	assert.Equal(t, 3, previous("2"))
+
+	// Stale records are removed, and the order does not grow beyond max
+	// size.
+	record("1", 1)
+	record("2", 2)
+	c.Advance(3 * time.Minute)
+	record("3", 3)
+	s.mu.Lock()
+	assert.Len(t, s.warmUpHistory, 1)
+	assert.Len(t, s.warmUpOrder, 1)
+	s.mu.Unlock()
+	assert.Equal(t, 4, previous("3"))
+}
+
+func Test_storeWarmUpInvalid(t *testing.T) {
+	_, err := newExpirableStore(20, time.Minute, WithWarmUp(0, 2))
+	assert.ErrorIs(t, err, ErrInvalidParameter)
+	_, err = newExpirableStore(20, time.Minute, WithWarmUp(1.5, 2))
+	assert.ErrorIs(t, err, ErrInvalidParameter)
+	_, err = newExpirableStore(20, time.Minute, WithWarmUp(0.5, -1))
+	assert.ErrorIs(t, err, ErrInvalidParameter)
+}
+
+func Test_storeDeleteExpiredFakeClock(t *testing.T) {
+	c := newFakeClock()
+	maxPeriod := time.Minute
+	numberBuckets := 7
+	s, err := newExpirableStore(20, maxPeriod, WithNumberBuckets(numberBuckets), WithClock(c))
+	require.NoError(t, err)
+	defer s.shutdown()
+
+	limit := &Limited{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerTotal,
+		MaxRequests: 10,
+		Period:      maxPeriod,
+	}
+	for i := 0; i < 5; i++ {
+		_, err := s.fetch(context.Background(), fmt.Sprintf("id-%d", i), limit)
+		require.NoError(t, err)
+	}
+
+	itemCount := func() int {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.items.len()
+	}
+	waitForDelete := func() {
+		require.Eventually(t, func() bool { return c.Waiters() == 1 }, time.Second, time.Millisecond)
+	}
+
+	// Advance one bucket at a time until just before the quotas expire. They
+	// should not be deleted, even though the buckets are being emptied.
+	waitForDelete()
+	for i := 0; i < numberBuckets-1; i++ {
+		c.Advance(s.bucketTTL)
+		waitForDelete()
+		require.Equal(t, 5, itemCount())
+	}
+
+	// Once the quotas expire, the bucket containing them should be emptied.
+	c.Advance(s.bucketTTL)
+	require.Eventually(t, func() bool { return itemCount() == 0 }, time.Second, time.Millisecond)
+}
+
+func Test_storeEmptyExpiredBucketEarly(t *testing.T) {
+	c := newFakeClock()
+	s, err := newExpirableStore(20, time.Minute, WithNumberBuckets(1), WithClock(c))
+	require.NoError(t, err)
+	defer s.shutdown()
+
+	limit := &Limited{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerTotal,
+		MaxRequests: 10,
+		Period:      time.Minute,
+	}
+	_, err = s.fetch(context.Background(), "id", limit)
+	require.NoError(t, err)
+
+	// Running before the bucket has expired should not delete anything, or
+	// move on to the next bucket.
+	c.Advance(time.Second)
+	assert.Equal(t, time.Minute-time.Second, s.emptyExpiredBucket())
+	s.mu.Lock()
+	assert.Equal(t, 1, s.items.len())
+	s.mu.Unlock()
+
+	c.Advance(time.Minute)
+	assert.Equal(t, s.bucketTTL, s.emptyExpiredBucket())
+	s.mu.Lock()
+	assert.Equal(t, 0, s.items.len())
+	s.mu.Unlock()
+}
+
+func Test_storeCleanupBatchSize(t *testing.T) {
+	c := newFakeClock()
+	s, err := newExpirableStore(20, time.Minute, WithNumberBuckets(1), WithClock(c), WithCleanupBatchSize(3))
+	require.NoError(t, err)
+	defer s.shutdown()
+
+	limit := &Limited{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerTotal,
+		MaxRequests: 10,
+		Period:      time.Minute,
+	}
+	fetchAll := func() {
+		for i := 0; i < bucketSizeThreshold+4; i++ {
+			_, err := s.fetch(context.Background(), fmt.Sprintf("id-%d", i), limit)
+			require.NoError(t, err)
+		}
+	}
+
+	fetchAll()
+	s.mu.Lock()
+	initialBucketPtr := reflect.ValueOf(s.buckets[0].entries).Pointer()
+	s.mu.Unlock()
+
+	c.Advance(time.Minute * 2)
+	s.emptyExpiredBucket()
+	s.mu.Lock()
+	assert.Equal(t, 0, s.items.len())
+	assert.Equal(t, 0, s.buckets[0].entries.len())
+	assert.NotEqual(t, initialBucketPtr, reflect.ValueOf(s.buckets[0].entries).Pointer())
+	s.mu.Unlock()
+
+	// An entry that is fetched after its bucket was swapped out, but before
+	// it is removed, should not be removed.
+	fetchAll()
+	s.mu.Lock()
+	expired := make([]*entry, 0, s.buckets[0].entries.len())
+	s.buckets[0].entries.each(func(e *entry) {
+		expired = append(expired, e)
+	})
+	s.buckets[0].entries = newEntryTable(0)
+	s.mu.Unlock()
+
+	c.Advance(time.Minute * 2)
+	_, err = s.fetch(context.Background(), "id-0", limit)
+	require.NoError(t, err)
+
+	s.mu.Lock()
+	s.removeOrphaned(expired)
+	assert.Equal(t, 1, s.items.len())
+	assert.NotNil(t, s.items.get(quotaKey(limit, "id-0")))
+	s.mu.Unlock()
+}
+
+func Test_storeCleanupBatchSizeInvalid(t *testing.T) {
+	_, err := newExpirableStore(20, time.Minute, WithCleanupBatchSize(0))
+	assert.ErrorIs(t, err, ErrInvalidParameter)
+	_, err = newExpirableStore(20, time.Minute, WithCleanupBatchSize(-1))
+	assert.ErrorIs(t, err, ErrInvalidParameter)
+}
+
+func Test_storeStaleQuota(t *testing.T) {
+	c := newFakeClock()
+	hits, misses := &testCounter{}, &testCounter{}
+	s, err := newExpirableStore(20, time.Minute, WithClock(c), WithQuotaPoolHitMetric(hits), WithQuotaPoolMissMetric(misses))
+	require.NoError(t, err)
+	defer s.shutdown()
+
+	limit := &Limited{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerIPAddress,
+		MaxRequests: 10,
+		Period:      time.Minute,
+	}
+
+	stale, err := s.fetch(context.Background(), "127.0.0.1", limit)
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), hits.v)
+	assert.Equal(t, float64(1), misses.v)
+
+	s.mu.Lock()
+	s.removeEntry(s.items.get(quotaKey(limit, "127.0.0.1")))
+	s.mu.Unlock()
+
+	q, err := s.fetch(context.Background(), "127.0.0.2", limit)
+	require.NoError(t, err)
+	// The sync pool may drop entries, so it is not guaranteed to be a hit.
+	assert.Equal(t, float64(2), hits.v+misses.v)
+
+	// Using the stale quota must not affect the quota that reused its entry.
+	require.NotSame(t, stale, q)
+	stale.Consume()
+	assert.Equal(t, uint64(10), q.Remaining())
+}
+
+func Test_storeRekey(t *testing.T) {
+	limit := &Limited{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerAuthToken,
+		MaxRequests: 10,
+		Period:      time.Minute,
+	}
+
+	consume := func(t *testing.T, s *expirableStore, id string, n int) *Quota {
+		t.Helper()
+		q, err := s.fetch(context.Background(), id, limit)
+		require.NoError(t, err)
+		for i := 0; i < n; i++ {
+			q.Consume()
+		}
+		return q
+	}
+
+	t.Run("move", func(t *testing.T) {
+		s, err := newExpirableStore(20, time.Minute, WithClock(newFakeClock()))
+		require.NoError(t, err)
+		defer s.shutdown()
+
+		old := consume(t, s, "old", 3)
+		require.NoError(t, s.rekey(limit, "old", "new"))
+
+		s.mu.Lock()
+		assert.Nil(t, s.items.get(quotaKey(limit, "old")))
+		require.NotNil(t, s.items.get(quotaKey(limit, "new")))
+		e := s.items.get(quotaKey(limit, "new"))
+		assert.NotNil(t, s.buckets[e.bucket].entries.get(quotaKey(limit, "new")))
+		assert.Nil(t, s.buckets[e.bucket].entries.get(quotaKey(limit, "old")))
+		s.mu.Unlock()
+
+		q := consume(t, s, "new", 0)
+		assert.Same(t, old, q)
+		assert.Equal(t, uint64(7), q.Remaining())
+	})
+	t.Run("merge", func(t *testing.T) {
+		c := newFakeClock()
+		s, err := newExpirableStore(20, time.Minute, WithClock(c))
+		require.NoError(t, err)
+		defer s.shutdown()
+
+		consume(t, s, "old", 3)
+		c.Advance(time.Second)
+		newer := consume(t, s, "new", 2)
+		require.NoError(t, s.rekey(limit, "old", "new"))
+
+		s.mu.Lock()
+		assert.Equal(t, 1, s.items.len())
+		s.mu.Unlock()
+
+		q := consume(t, s, "new", 0)
+		assert.Same(t, newer, q)
+		assert.Equal(t, uint64(5), q.Remaining())
+	})
+	t.Run("merge-old-expires-last", func(t *testing.T) {
+		c := newFakeClock()
+		s, err := newExpirableStore(20, time.Minute, WithClock(c))
+		require.NoError(t, err)
+		defer s.shutdown()
+
+		consume(t, s, "new", 2)
+		c.Advance(time.Second)
+		old := consume(t, s, "old", 3)
+		require.NoError(t, s.rekey(limit, "old", "new"))
+
+		q := consume(t, s, "new", 0)
+		assert.Same(t, old, q)
+		assert.Equal(t, uint64(5), q.Remaining())
+	})
+	t.Run("expired", func(t *testing.T) {
+		c := newFakeClock()
+		s, err := newExpirableStore(20, time.Minute, WithClock(c))
+		require.NoError(t, err)
+		defer s.shutdown()
+
+		consume(t, s, "old", 3)
+		c.Advance(2 * time.Minute)
+		require.NoError(t, s.rekey(limit, "old", "new"))
+
+		s.mu.Lock()
+		assert.Zero(t, s.items.len())
+		s.mu.Unlock()
+	})
+	t.Run("missing", func(t *testing.T) {
+		s, err := newExpirableStore(20, time.Minute)
+		require.NoError(t, err)
+		defer s.shutdown()
+
+		require.NoError(t, s.rekey(limit, "old", "new"))
+	})
+	t.Run("stopped", func(t *testing.T) {
+		s, err := newExpirableStore(20, time.Minute)
+		require.NoError(t, err)
+		s.shutdown()
+
+		require.ErrorIs(t, s.rekey(limit, "old", "new"), ErrStopped)
+	})
+}
+
+func Test_storeUsageSink(t *testing.T) {
+	limit := &Limited{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerIPAddress,
+		MaxRequests: 10,
+		Period:      time.Minute,
+	}
+
+	t.Run("fetch-expired", func(t *testing.T) {
+		c := newFakeClock()
+		ch := make(chan UsageRecord, 10)
+		s, err := newExpirableStore(20, time.Minute, WithClock(c), WithUsageSink(ChannelUsageSink(ch)))
+		require.NoError(t, err)
+		defer s.shutdown()
+
+		start := c.Now()
+		q, err := s.fetch(context.Background(), "127.0.0.1", limit)
+		require.NoError(t, err)
+		q.Consume()
+		q.Consume()
+
+		// Fetching before the window ends does not export anything.
+		_, err = s.fetch(context.Background(), "127.0.0.1", limit)
+		require.NoError(t, err)
+		assert.Empty(t, ch)
+
+		// The window is exported exactly once, either by the fetch or by the
+		// removal of the expired quota.
+		c.Advance(time.Minute + time.Second)
+		_, err = s.fetch(context.Background(), "127.0.0.1", limit)
+		require.NoError(t, err)
+
+		select {
+		case got := <-ch:
+			assert.Equal(t, UsageRecord{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerIPAddress,
+				ID:          "127.0.0.1",
+				Used:        2,
+				WindowStart: start,
+				WindowEnd:   start.Add(time.Minute),
+			}, got)
+		case <-time.After(time.Second):
+			require.FailNow(t, "timed out waiting for usage record")
+		}
+		time.Sleep(10 * time.Millisecond)
+		assert.Empty(t, ch)
+	})
+	t.Run("delete-expired", func(t *testing.T) {
+		c := newFakeClock()
+		ch := make(chan UsageRecord, 20)
+		s, err := newExpirableStore(20, time.Minute, WithClock(c), WithUsageSink(ChannelUsageSink(ch)))
+		require.NoError(t, err)
+		defer s.shutdown()
+
+		for i := 0; i < 10; i++ {
+			q, err := s.fetch(context.Background(), fmt.Sprintf("127.0.0.%d", i), limit)
+			require.NoError(t, err)
+			q.Consume()
+		}
+
+		// Advance one bucket at a time until the bucket containing the quotas
+		// has been emptied.
+		for i := 0; i <= s.numberBuckets; i++ {
+			require.Eventually(t, func() bool { return c.Waiters() == 1 }, time.Second, time.Millisecond)
+			c.Advance(s.bucketTTL)
+		}
+
+		ids := make(map[string]uint64)
+		for i := 0; i < 10; i++ {
+			select {
+			case r := <-ch:
+				ids[r.ID] = r.Used
+			case <-time.After(time.Second):
+				require.FailNow(t, "timed out waiting for usage record")
+			}
+		}
+		for i := 0; i < 10; i++ {
+			assert.Equal(t, uint64(1), ids[fmt.Sprintf("127.0.0.%d", i)])
+		}
+	})
+}
+
+func Test_storeEventHook(t *testing.T) {
+	limit := &Limited{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerIPAddress,
+		MaxRequests: 10,
+		Period:      time.Minute,
+	}
+
+	t.Run("full", func(t *testing.T) {
+		c := newFakeClock()
+		events := make(chan StoreEvent, 10)
+		s, err := newExpirableStore(2, time.Minute, WithClock(c), WithNumberBuckets(2), WithStoreEventHook(func(e StoreEvent) {
+			events <- e
+		}))
+		require.NoError(t, err)
+		defer s.shutdown()
+
+		for i := 0; i < 2; i++ {
+			_, err := s.fetch(context.Background(), fmt.Sprintf("127.0.0.%d", i), limit)
+			require.NoError(t, err)
+		}
+		assert.Empty(t, events)
+
+		// Only the first failure results in an event.
+		for i := 0; i < 2; i++ {
+			_, err = s.fetch(context.Background(), "127.0.0.3", limit)
+			require.ErrorAs(t, err, new(*ErrLimiterFull))
+		}
+		require.Len(t, events, 1)
+		assert.Equal(t, StoreEvent{Type: StoreEventFull, Time: c.Now(), Usage: 2, Capacity: 2}, <-events)
+
+		// Once the quotas are removed, the store is no longer full.
+		require.Eventually(t, func() bool { return c.Waiters() == 1 }, time.Second, time.Millisecond)
+		c.Advance(time.Minute)
+		require.Eventually(t, func() bool { return c.Waiters() == 1 }, time.Second, time.Millisecond)
+		c.Advance(time.Minute)
+		select {
+		case e := <-events:
+			assert.Equal(t, StoreEventNotFull, e.Type)
+			assert.Equal(t, 0, e.Usage)
+			assert.Equal(t, 2, e.Capacity)
+		case <-time.After(time.Second):
+			require.FailNow(t, "timed out waiting for event")
+		}
+	})
+	t.Run("bucket-reallocated", func(t *testing.T) {
+		c := newFakeClock()
+		events := make(chan StoreEvent, 10)
+		s, err := newExpirableStore(20, time.Minute, WithClock(c), WithNumberBuckets(2), WithStoreEventHook(func(e StoreEvent) {
+			events <- e
+		}))
+		require.NoError(t, err)
+		defer s.shutdown()
+
+		n := bucketSizeThreshold + 1
+		for i := 0; i < n; i++ {
+			_, err := s.fetch(context.Background(), fmt.Sprintf("127.0.0.%d", i), limit)
+			require.NoError(t, err)
+		}
+
+		require.Eventually(t, func() bool { return c.Waiters() == 1 }, time.Second, time.Millisecond)
+		c.Advance(time.Minute)
+		require.Eventually(t, func() bool { return c.Waiters() == 1 }, time.Second, time.Millisecond)
+		c.Advance(time.Minute)
+		select {
+		case e := <-events:
+			assert.Equal(t, StoreEventBucketReallocated, e.Type)
+			assert.Equal(t, 1, e.Bucket)
+			assert.Equal(t, n, e.Entries)
+			assert.Equal(t, 20, e.Capacity)
+		case <-time.After(time.Second):
+			require.FailNow(t, "timed out waiting for event")
+		}
+	})
+}
+
+func Test_storeCompact(t *testing.T) {
+	short := &Limited{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerIPAddress,
+		MaxRequests: 10,
+		Period:      10 * time.Second,
+	}
+	long := &Limited{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerAuthToken,
+		MaxRequests: 10,
+		Period:      time.Minute,
+	}
+
+	c := newFakeClock()
+	events := make(chan StoreEvent, 10)
+	s, err := newExpirableStore(100, time.Minute, WithClock(c), WithStoreEventHook(func(e StoreEvent) {
+		events <- e
+	}))
+	require.NoError(t, err)
+	defer s.shutdown()
+
+	n := bucketSizeThreshold + 1
+	for i := 0; i < n; i++ {
+		_, err := s.fetch(context.Background(), fmt.Sprintf("127.0.0.%d", i), short)
+		require.NoError(t, err)
+	}
+	_, err = s.fetch(context.Background(), "token", long)
+	require.NoError(t, err)
+	shortBucket := int(s.items.get(quotaKey(short, "127.0.0.0")).bucket)
+
+	// The short quotas expire long before the delete go routine reaches
+	// their bucket.
+	require.Eventually(t, func() bool { return c.Waiters() == 1 }, time.Second, time.Millisecond)
+	c.Advance(11 * time.Second)
+	require.Eventually(t, func() bool { return c.Waiters() == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, n+1, s.stats().usage)
+
+	require.NoError(t, s.compact())
+	st := s.stats()
+	assert.Equal(t, 1, st.usage)
+	assert.Equal(t, 0, st.bucketEntries[shortBucket])
+	select {
+	case e := <-events:
+		assert.Equal(t, StoreEventBucketReallocated, e.Type)
+		assert.Equal(t, shortBucket, e.Bucket)
+		assert.Equal(t, n, e.Entries)
+	default:
+		require.FailNow(t, "expected bucket reallocated event")
+	}
+
+	// Compacting again does not reallocate any buckets.
+	require.NoError(t, s.compact())
+	assert.Empty(t, events)
+
+	require.NoError(t, s.shutdown())
+	assert.ErrorIs(t, s.compact(), ErrStopped)
+}
+
+// syncGauge is a metric.Gauge that can be read while it is being set by
+// another go routine.
+type syncGauge struct {
+	mu sync.Mutex
+	v  float64
+	n  int
+}
+
+func (g *syncGauge) Set(f float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.v = f
+	g.n++
+}
+
+func (g *syncGauge) get() (float64, int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.v, g.n
+}
+
+func Test_storeGaugePublishInterval(t *testing.T) {
+	c := newFakeClock()
+	capacity, usage := &syncGauge{}, &syncGauge{}
+	s, err := newExpirableStore(20, time.Hour,
+		WithClock(c),
+		WithGaugePublishInterval(time.Second),
+		WithQuotaStorageCapacityMetric(capacity),
+		WithQuotaStorageUsageMetric(usage),
+	)
+	require.NoError(t, err)
+	defer s.shutdown()
+
+	limit := &Limited{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerIPAddress,
+		MaxRequests: 10,
+		Period:      time.Minute,
+	}
+	for i := 0; i < 3; i++ {
+		_, err := s.fetch(context.Background(), fmt.Sprintf("127.0.0.%d", i), limit)
+		require.NoError(t, err)
+	}
+
+	// The usage is not set by fetch.
+	v, n := usage.get()
+	assert.Equal(t, float64(0), v)
+	assert.Equal(t, 1, n)
+
+	// Both the cleanup and publish go routines are waiting.
+	require.Eventually(t, func() bool { return c.Waiters() == 2 }, time.Second, time.Millisecond)
+	c.Advance(time.Second)
+	require.Eventually(t, func() bool {
+		v, _ := usage.get()
+		return v == 3
+	}, time.Second, time.Millisecond)
+	v, n = capacity.get()
+	assert.Equal(t, float64(20), v)
+	assert.Equal(t, 2, n)
+}
+
+func Test_storeGaugePublishIntervalInvalid(t *testing.T) {
+	_, err := newExpirableStore(20, time.Minute, WithGaugePublishInterval(-time.Second))
+	require.ErrorIs(t, err, ErrInvalidParameter)
+}
+
+func Test_storeExtend(t *testing.T) {
+	limit := &Limited{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerIPAddress,
+		MaxRequests: 10,
+		Period:      time.Minute,
+	}
+
+	c := newFakeClock()
+	start := c.Now()
+	s, err := newExpirableStore(10, 2*time.Minute, WithClock(c), WithNumberBuckets(5))
+	require.NoError(t, err)
+	defer s.shutdown()
+
+	q, err := s.fetch(context.Background(), "id", limit)
+	require.NoError(t, err)
+	key := quotaKey(limit, "id")
+	s.mu.Lock()
+	before := s.items.get(key).bucket
+	s.mu.Unlock()
+
+	require.NoError(t, s.extend("id", limit, time.Minute))
+	assert.Equal(t, start.Add(2*time.Minute), q.Expiration())
+
+	// The entry is moved to the bucket for its new expiration.
+	s.mu.Lock()
+	e := s.items.get(key)
+	assert.NotEqual(t, before, e.bucket)
+	assert.Nil(t, s.buckets[before].entries.get(key))
+	assert.NotNil(t, s.buckets[e.bucket].entries.get(key))
+	assert.False(t, s.buckets[e.bucket].expiresAt.Before(q.expiration()))
+	s.mu.Unlock()
+
+	// The expiration is limited to the max TTL from now.
+	c.Advance(10 * time.Second)
+	require.NoError(t, s.extend("id", limit, time.Hour))
+	assert.Equal(t, c.Now().Add(2*time.Minute), q.Expiration())
+
+	// Quotas that do not exist are not created.
+	require.NoError(t, s.extend("missing", limit, time.Minute))
+	assert.Nil(t, s.lookup("missing", limit))
+
+	// Expired quotas are not extended.
+	c.Advance(2*time.Minute + time.Nanosecond)
+	expiresAt := q.Expiration()
+	require.NoError(t, s.extend("id", limit, time.Minute))
+	assert.Equal(t, expiresAt, q.Expiration())
+
+	s.shutdown()
+	assert.ErrorIs(t, s.extend("id", limit, time.Minute), ErrStopped)
+}
+
+func Test_storeShorten(t *testing.T) {
+	limit := &Limited{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerIPAddress,
+		MaxRequests: 10,
+		Period:      time.Minute,
+	}
+	shorter := *limit
+	shorter.Period = 20 * time.Second
+
+	c := newFakeClock()
+	start := c.Now()
+	s, err := newExpirableStore(10, 2*time.Minute, WithClock(c), WithNumberBuckets(6))
+	require.NoError(t, err)
+	defer s.shutdown()
+
+	q, err := s.fetch(context.Background(), "id", limit)
+	require.NoError(t, err)
+	q.Consume()
+	key := quotaKey(limit, "id")
+	s.mu.Lock()
+	before := s.items.get(key).bucket
+	s.mu.Unlock()
+
+	// A longer period does not change the quota.
+	longer := *limit
+	longer.Period = 2 * time.Minute
+	require.NoError(t, s.shorten(map[string]*Limited{quotaKey(&longer, ""): &longer}))
+	assert.Equal(t, start.Add(time.Minute), q.Expiration())
+
+	// A shorter period ends the window when it would have ended with the
+	// new period, and moves the entry to the bucket for its new expiration.
+	c.Advance(10 * time.Second)
+	require.NoError(t, s.shorten(map[string]*Limited{quotaKey(&shorter, ""): &shorter}))
+	assert.Equal(t, start.Add(20*time.Second), q.Expiration())
+	assert.Equal(t, uint64(9), q.Remaining())
+	s.mu.Lock()
+	e := s.items.get(key)
+	assert.NotEqual(t, before, e.bucket)
+	assert.Nil(t, s.buckets[before].entries.get(key))
+	assert.NotNil(t, s.buckets[e.bucket].entries.get(key))
+	s.mu.Unlock()
+
+	// Once the window ends, the quota is reset using the new limit.
+	c.Advance(10*time.Second + time.Nanosecond)
+	q, err = s.fetch(context.Background(), "id", &shorter)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), q.Remaining())
+	assert.Equal(t, c.Now().Add(20*time.Second), q.Expiration())
+
+	s.shutdown()
+	assert.ErrorIs(t, s.shorten(nil), ErrStopped)
+}
+
+func Test_storeResize(t *testing.T) {
+	limit := func(period time.Duration) *Limited {
+		return &Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			MaxRequests: 10,
+			Period:      period,
+		}
+	}
+
+	c := newFakeClock()
+	start := c.Now()
+	s, err := newExpirableStore(10, time.Minute, WithClock(c), WithNumberBuckets(5))
+	require.NoError(t, err)
+	defer s.shutdown()
+
+	short, err := s.fetch(context.Background(), "short", limit(time.Minute))
+	require.NoError(t, err)
+
+	// Growing the store moves entries to the bucket for their expiration
+	// using the new bucket TTL.
+	require.NoError(t, s.resize(4*time.Minute))
+	assert.Equal(t, 4*time.Minute, s.maxEntryTTL())
+	long, err := s.fetch(context.Background(), "long", limit(4*time.Minute))
+	require.NoError(t, err)
+	s.mu.Lock()
+	assert.Equal(t, time.Minute, s.bucketTTL)
+	assert.Equal(t, int32(1), s.items.get(quotaKey(limit(time.Minute), "short")).bucket)
+	assert.Equal(t, int32(4), s.items.get(quotaKey(limit(4*time.Minute), "long")).bucket)
+	s.mu.Unlock()
+	assert.Equal(t, start.Add(time.Minute), short.Expiration())
+
+	// Shrinking the store expires entries that would outlive it.
+	c.Advance(30 * time.Second)
+	require.NoError(t, s.resize(time.Minute))
+	s.mu.Lock()
+	assert.Equal(t, 15*time.Second, s.bucketTTL)
+	s.items.each(func(e *entry) {
+		assert.NotNil(t, s.buckets[e.bucket].entries.get(e.key))
+		assert.False(t, s.buckets[e.bucket].expiresAt.Before(e.value.expiration()))
+	})
+	s.mu.Unlock()
+	assert.Equal(t, start.Add(time.Minute), short.Expiration())
+	assert.Equal(t, c.Now().Add(time.Minute), long.Expiration())
+
+	require.ErrorIs(t, s.resize(0), ErrInvalidParameter)
+	s.shutdown()
+	assert.ErrorIs(t, s.resize(time.Minute), ErrStopped)
+}
+
+func Test_storeFullRetryAt(t *testing.T) {
+	c := newFakeClock()
+	start := c.Now()
+	s, err := newExpirableStore(1, time.Minute, WithClock(c), WithNumberBuckets(2))
+	require.NoError(t, err)
+	defer s.shutdown()
+
+	limit := &Limited{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerIPAddress,
+		MaxRequests: 10,
+		Period:      time.Minute,
+	}
+	_, err = s.fetch(context.Background(), "id-0", limit)
+	require.NoError(t, err)
+
+	// The quota is in the second bucket, which is emptied by the delete go
+	// routine after the first bucket, two bucket TTLs from now.
+	wantRetryAt := start.Add(2 * time.Minute)
+
+	_, err = s.fetch(context.Background(), "id-1", limit)
+	var full *ErrLimiterFull
+	require.ErrorAs(t, err, &full)
+	assert.Equal(t, wantRetryAt, full.RetryAt)
+	assert.Equal(t, 2*time.Minute, full.RetryIn)
+	assert.Equal(t, 2*time.Minute, full.RetryAfter())
+
+	c.Advance(10 * time.Second)
+	_, err = s.fetch(context.Background(), "id-1", limit)
+	require.ErrorAs(t, err, &full)
+	assert.Equal(t, wantRetryAt, full.RetryAt)
+	assert.Equal(t, 110*time.Second, full.RetryIn)
+
+	// Replacing the quota with one in another bucket changes when space is
+	// expected to become available, rather than reusing the previous result.
+	s.mu.Lock()
+	s.removeEntry(s.items.get(quotaKey(limit, "id-0")))
+	s.mu.Unlock()
+	short := *limit
+	short.Period = 30 * time.Second
+	_, err = s.fetch(context.Background(), "id-2", &short)
+	require.NoError(t, err)
+	_, err = s.fetch(context.Background(), "id-1", limit)
+	require.ErrorAs(t, err, &full)
+	assert.Equal(t, start.Add(time.Minute), full.RetryAt)
+}
+
+func Test_storePreallocation(t *testing.T) {
+	cases := []struct {
+		name           string
+		preallocation  Preallocation
+		itemsCapacity  int
+		bucketCapacity int
+	}{
+		{"items", PreallocateItems, entryTableSize(4096), minEntryTableSize},
+		{"none", PreallocateNone, minEntryTableSize, minEntryTableSize},
+		{"buckets", PreallocateBuckets, entryTableSize(4096), entryTableSize(256)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newFakeClock()
+			s, err := newExpirableStore(4096, time.Minute,
+				WithNumberBuckets(16),
+				WithPreallocation(tc.preallocation),
+				WithClock(c),
+			)
+			require.NoError(t, err)
+			defer s.shutdown()
+
+			s.mu.Lock()
+			assert.Equal(t, tc.itemsCapacity, s.items.capacity())
+			for _, b := range s.buckets {
+				assert.Equal(t, tc.bucketCapacity, b.entries.capacity())
+			}
+			s.mu.Unlock()
+
+			limit := &Limited{Resource: "resource", Action: "action", Per: LimitPerIPAddress, MaxRequests: 10, Period: time.Minute}
+			for i := 0; i < 100; i++ {
+				_, err := s.fetch(context.Background(), fmt.Sprintf("id-%d", i), limit)
+				require.NoError(t, err)
+			}
+			checkStoreInvariants(t, s)
+
+			// An emptied bucket is given a table of the same initial size.
+			c.Advance(2 * time.Minute)
+			for i := 0; i < s.numberBuckets; i++ {
+				s.emptyExpiredBucket()
+			}
+			s.mu.Lock()
+			assert.Zero(t, s.items.len())
+			for _, b := range s.buckets {
+				assert.Equal(t, tc.bucketCapacity, b.entries.capacity())
+			}
+			s.mu.Unlock()
+		})
+	}
+
+	_, err := newExpirableStore(10, time.Minute, WithPreallocation(Preallocation(-1)))
+	assert.ErrorIs(t, err, ErrInvalidParameter)
+}
+
+func Test_storeShrinkItems(t *testing.T) {
+	cases := []struct {
+		name          string
+		preallocation Preallocation
+		want          int
+	}{
+		{"none", PreallocateNone, minEntryTableSize},
+		{"items", PreallocateItems, entryTableSize(2048)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var events []StoreEvent
+			c := newFakeClock()
+			s, err := newExpirableStore(2048, time.Minute,
+				WithNumberBuckets(4),
+				WithPreallocation(tc.preallocation),
+				WithStoreEventHook(func(e StoreEvent) {
+					if e.Type == StoreEventItemsReallocated {
+						events = append(events, e)
+					}
+				}),
+				WithClock(c),
+			)
+			require.NoError(t, err)
+			defer s.shutdown()
+
+			limit := &Limited{Resource: "resource", Action: "action", Per: LimitPerIPAddress, MaxRequests: 10, Period: time.Minute}
+			for i := 0; i < 2048; i++ {
+				_, err := s.fetch(context.Background(), fmt.Sprintf("id-%d", i), limit)
+				require.NoError(t, err)
+			}
+			s.mu.Lock()
+			grown := s.items.capacity()
+			s.mu.Unlock()
+
+			c.Advance(2 * time.Minute)
+			for i := 0; i < s.numberBuckets; i++ {
+				s.emptyExpiredBucket()
+			}
+			s.mu.Lock()
+			assert.Zero(t, s.items.len())
+			assert.Equal(t, tc.want, s.items.capacity())
+			s.mu.Unlock()
+			if tc.want < grown {
+				assert.NotEmpty(t, events)
+			} else {
+				assert.Empty(t, events)
+			}
+
+			// The store continues to work after the table is shrunk.
+			_, err = s.fetch(context.Background(), "id-0", limit)
+			require.NoError(t, err)
+			checkStoreInvariants(t, s)
+		})
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+// GraceRequest describes a request that was allowed using the GraceRequests
+// of a Limited limit, after the quota's MaxRequests had been used.
+type GraceRequest struct {
+	Resource string
+	Action   string
+	Per      LimitPer
+	// ID is the IP address or auth token for LimitPerIPAddress and
+	// LimitPerAuthToken, and "total" for LimitPerTotal.
+	ID string
+
+	// GraceUsed is the number of grace requests that have been used by the
+	// quota during the current window, including this request, and
+	// GraceRemaining is the number that remain.
+	GraceUsed      uint64
+	GraceRemaining uint64
+}
+
+// GraceHook is called for each quota that a request used grace requests from.
+// It is called synchronously by Allow, without holding any of the Limiter's
+// locks, so a GraceHook that blocks will delay the request.
+type GraceHook func(GraceRequest)
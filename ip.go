@@ -0,0 +1,27 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import "net/netip"
+
+// canonicalIP returns the canonical text representation of the provided IP
+// address, so that the same address cannot be used to obtain multiple quotas
+// by varying its representation. IPv4-mapped IPv6 addresses are converted to
+// IPv4, zones are removed, and IPv6 addresses are formatted as described in
+// RFC 5952. If ip is not a valid IP address, it is returned unmodified along
+// with false. If ip is already canonical, it is returned without allocating.
+func canonicalIP(ip string) (string, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip, false
+	}
+	addr = addr.Unmap().WithZone("")
+	// buf is large enough for the longest canonical IPv6 address, so that
+	// formatting it does not allocate.
+	var buf [len("ffff:ffff:ffff:ffff:ffff:ffff:255.255.255.255")]byte
+	if b := addr.AppendTo(buf[:0]); string(b) == ip {
+		return ip, true
+	}
+	return addr.String(), true
+}
@@ -0,0 +1,46 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_canonicalIP(t *testing.T) {
+	cases := []struct {
+		in     string
+		want   string
+		wantOk bool
+	}{
+		{"127.0.0.1", "127.0.0.1", true},
+		{"::ffff:1.2.3.4", "1.2.3.4", true},
+		{"::FFFF:1.2.3.4", "1.2.3.4", true},
+		{"2001:DB8::1", "2001:db8::1", true},
+		{"2001:0db8:0000:0000:0000:0000:0000:0001", "2001:db8::1", true},
+		{"fe80::1%eth0", "fe80::1", true},
+		{"", "", false},
+		{"ip1", "ip1", false},
+		{"1.2.3.4:80", "1.2.3.4:80", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.in, func(t *testing.T) {
+			got, ok := canonicalIP(tc.in)
+			assert.Equal(t, tc.wantOk, ok)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func Test_canonicalIPAllocs(t *testing.T) {
+	// An IP address that is already canonical is returned without
+	// allocating.
+	for _, ip := range []string{"127.0.0.1", "2001:db8::1", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"} {
+		allocs := testing.AllocsPerRun(100, func() {
+			canonicalIP(ip)
+		})
+		assert.Zero(t, allocs, ip)
+	}
+}
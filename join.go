@@ -5,6 +5,7 @@ package rate
 
 import (
 	"bytes"
+	"strconv"
 	"sync"
 )
 
@@ -33,3 +34,26 @@ func join(parts ...string) string {
 	}
 	return b.String()
 }
+
+// quotaKey returns the key used to store the Quota for the provided limit and
+// id. The lengths of the resource and action are included in the key so that
+// a resource or action containing the separator cannot result in the same key
+// as a different resource and action. The per does not contain the separator,
+// so the id can be any string.
+//
+// The quotas for limits in a Pool are keyed by the pool, rather than by the
+// resource and action, so that they are shared. Since the keys for other
+// quotas start with a length, they cannot be the same as the key of a pool.
+func quotaKey(l *Limited, id string) string {
+	if l.Pool != "" {
+		return join("pool", strconv.Itoa(len(l.Pool)), l.Pool, string(l.Per), id)
+	}
+	return join(strconv.Itoa(len(l.Resource)), l.Resource, strconv.Itoa(len(l.Action)), l.Action, string(l.Per), id)
+}
+
+// keyID returns the id at the end of a key returned by quotaKey for the id.
+// The returned string shares the key's memory, so that storing it along with
+// the key does not also keep the memory of the provided id reachable.
+func keyID(key, id string) string {
+	return key[len(key)-len(id):]
+}
@@ -43,3 +43,45 @@ func Test_getKey(t *testing.T) {
 		})
 	}
 }
+
+func Test_quotaKey(t *testing.T) {
+	cases := []struct {
+		name  string
+		limit *Limited
+		id    string
+		want  string
+	}{
+		{
+			"simple",
+			&Limited{Resource: "resource", Action: "action", Per: LimitPerIPAddress},
+			"127.0.0.1",
+			"8:resource:6:action:ip-address:127.0.0.1",
+		},
+		{
+			"pool",
+			&Limited{Resource: "resource", Action: "action", Per: LimitPerAuthToken, Pool: "writes"},
+			"token",
+			"pool:6:writes:auth-token:token",
+		},
+		{
+			"separatorInResource",
+			&Limited{Resource: "a:b", Action: "c", Per: LimitPerTotal},
+			"total",
+			"3:a:b:1:c:total:total",
+		},
+		{
+			"separatorInAction",
+			&Limited{Resource: "a", Action: "b:c", Per: LimitPerTotal},
+			"total",
+			"1:a:3:b:c:total:total",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := quotaKey(tc.limit, tc.id)
+			assert.Equal(t, tc.want, got)
+			assert.Equal(t, tc.id, keyID(got, tc.id))
+		})
+	}
+}
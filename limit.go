@@ -5,6 +5,7 @@ package rate
 
 import (
 	"fmt"
+	"math/rand"
 	"time"
 )
 
@@ -33,6 +34,64 @@ const (
 	LimitPerTotal LimitPer = "total"
 )
 
+// Wildcard can be used as the Resource or Action of a limit, so that the
+// limit applies to requests for any resource or action that does not have its
+// own limits. The limits for a resource and any action are used before the
+// limits for any resource and an action. Since the quotas for a limit are
+// keyed by its Resource and Action, requests for all of the resources or
+// actions that use a wildcard limit share its quotas.
+const Wildcard = "*"
+
+// EmptyIdentity determines how a Limited limit for IP addresses or auth
+// tokens handles requests that do not have an IP address or auth token.
+type EmptyIdentity int
+
+const (
+	// EmptyIdentityShared indicates that all requests with an empty identity
+	// share a single quota. This is the default.
+	EmptyIdentityShared EmptyIdentity = iota
+	// EmptyIdentitySkip indicates that the limit is not applied to requests
+	// with an empty identity.
+	EmptyIdentitySkip
+	// EmptyIdentityDeny indicates that requests with an empty identity are
+	// not allowed.
+	EmptyIdentityDeny
+)
+
+// IsValid checks if the given EmptyIdentity is valid.
+func (e EmptyIdentity) IsValid() bool {
+	switch e {
+	case EmptyIdentityShared, EmptyIdentitySkip, EmptyIdentityDeny:
+		return true
+	}
+	return false
+}
+
+// WindowAnchor determines when the window for a Quota of a Limited limit
+// starts.
+type WindowAnchor int
+
+const (
+	// WindowAnchorFirstRequest indicates that a quota's window starts with
+	// the first request after the quota is created or has expired. This is
+	// the default.
+	WindowAnchorFirstRequest WindowAnchor = iota
+	// WindowAnchorEpoch indicates that windows are aligned to multiples of
+	// the limit's Period since the Unix epoch, so that all quotas for the
+	// limit reset at the same predictable times. For example, with a Period of
+	// one hour, quotas reset at the start of each hour.
+	WindowAnchorEpoch
+)
+
+// IsValid checks if the given WindowAnchor is valid.
+func (a WindowAnchor) IsValid() bool {
+	switch a {
+	case WindowAnchorFirstRequest, WindowAnchorEpoch:
+		return true
+	}
+	return false
+}
+
 // Limit defines the number of requests that can be made to perform an action
 // against a resource in a time period, allocated per IP address, auth token,
 // or in total. A Limit is either Limited or Unlimited.
@@ -56,6 +115,75 @@ type Limited struct {
 
 	MaxRequests uint64
 	Period      time.Duration
+
+	// MaxRequestsExpr is an optional arithmetic expression, such as
+	// "per_node_rps * nodes", that is evaluated using the variables provided
+	// via WithTemplateVariables when the limits are loaded to determine
+	// MaxRequests, which must not be set when MaxRequestsExpr is set. This
+	// allows the same limits to be used by deployments of different sizes.
+	// The operators +, -, *, and / and parentheses are supported, and the
+	// result is rounded down.
+	MaxRequestsExpr string
+
+	// Jitter is an optional fraction of Period, in the range [0, 1), used to
+	// randomly extend the expiration of each Quota for this limit. This can
+	// be used to spread out the time at which quotas reset when many are
+	// created at the same time. For example, a Jitter of 0.1 with a Period of
+	// one minute will result in quotas that expire between one minute and one
+	// minute and six seconds after they are reset.
+	Jitter float64
+
+	// EmptyIdentity determines how requests without an IP address or auth
+	// token are handled. It is ignored for LimitPerTotal.
+	EmptyIdentity EmptyIdentity
+
+	// GraceRequests is an optional number of requests that are allowed in
+	// each window after MaxRequests have been used. Requests that are allowed
+	// using grace requests are flagged via the grace HTTP header and the
+	// Limiter's GraceHook, so that well-behaved clients can slow down before
+	// their requests are denied.
+	GraceRequests uint64
+
+	// Anchor determines when the window for each Quota starts. Jitter cannot
+	// be used with WindowAnchorEpoch.
+	Anchor WindowAnchor
+
+	// Location is an optional time zone used to align the windows of a limit
+	// with WindowAnchorEpoch to calendar days, so that quotas reset at
+	// midnight in the time zone. It can only be used when Period is a whole
+	// number of days, in which case windows are aligned to multiples of that
+	// number of days since January 1st, 1970. Since days can be shorter or
+	// longer than 24 hours due to daylight saving time, the length of a window
+	// can differ from Period.
+	Location *time.Location
+
+	// Fallback is an optional LimitPer of another limit in the same policy
+	// that requests without an identity for this limit are limited by
+	// instead. Requests that do have an identity for this limit are not
+	// limited by the Fallback limit, or by any limit that it falls back to.
+	// For example, if the LimitPerAuthToken limit has a Fallback of
+	// LimitPerIPAddress, and the LimitPerIPAddress limit has a Fallback of
+	// LimitPerTotal, then requests with an auth token are only limited by
+	// auth token, requests with only an IP address are only limited by IP
+	// address, and requests with neither are only limited by the total.
+	// EmptyIdentity is ignored when Fallback is set. A LimitPerTotal limit
+	// cannot have a Fallback, since every request has a total identity.
+	Fallback LimitPer
+
+	// Pool is an optional name of a quota pool that is shared by the limits
+	// with the same Pool and Per, so that requests for related resources and
+	// actions draw from the same quotas. For example, the limits for each
+	// "write" action could use the same Pool so that each auth token can make
+	// a total of 100 write requests per minute. The limits in a Pool must have
+	// the same MaxRequests, Period, Jitter, GraceRequests, Anchor, and
+	// Location.
+	Pool string
+
+	// Class is an optional name of a RateClass that provides the
+	// MaxRequests, Period, and GraceRequests of this limit, which must
+	// not be set, along with MaxRequestsExpr, when Class is set. Changing the RateClass via
+	// Limiter.ReloadClasses changes every limit that uses it.
+	Class string
 }
 
 func (l *Limited) GetResource() string { return l.Resource }
@@ -63,7 +191,11 @@ func (l *Limited) GetAction() string   { return l.Action }
 func (l *Limited) GetPer() LimitPer    { return l.Per }
 
 // validate checks if l is valid. Limited is invalid if Per is invalid or if
-// MaxRequests is zero or if Period is less than or equal to zero.
+// MaxRequests is zero or if Period is less than or equal to zero or if
+// Jitter is not in the range [0, 1) or if EmptyIdentity is invalid or if
+// Anchor is invalid or is WindowAnchorEpoch with a non-zero Jitter or if
+// Location is set without WindowAnchorEpoch and a Period of whole days or if
+// Fallback is invalid, is its own Per, or is set for LimitPerTotal.
 func (l *Limited) validate() error {
 	switch {
 	case !l.Per.IsValid():
@@ -72,11 +204,113 @@ func (l *Limited) validate() error {
 		return fmt.Errorf("%w: max requests must be greater than zero", ErrInvalidLimit)
 	case l.Period <= 0:
 		return fmt.Errorf("%w: period must be greater than zero", ErrInvalidLimit)
+	case l.Jitter < 0 || l.Jitter >= 1:
+		return fmt.Errorf("%w: jitter must be at least zero and less than one", ErrInvalidLimit)
+	case !l.EmptyIdentity.IsValid():
+		return fmt.Errorf("%w: invalid empty identity", ErrInvalidLimit)
+	case !l.Anchor.IsValid():
+		return fmt.Errorf("%w: invalid window anchor", ErrInvalidLimit)
+	case l.Anchor == WindowAnchorEpoch && l.Jitter != 0:
+		return fmt.Errorf("%w: jitter cannot be used with an epoch window anchor", ErrInvalidLimit)
+	case l.Location != nil && l.Anchor != WindowAnchorEpoch:
+		return fmt.Errorf("%w: location can only be used with an epoch window anchor", ErrInvalidLimit)
+	case l.Location != nil && l.Period%day != 0:
+		return fmt.Errorf("%w: period must be a whole number of days to use a location", ErrInvalidLimit)
+	case l.Fallback != "" && !l.Fallback.IsValid():
+		return fmt.Errorf("%w: invalid fallback", ErrInvalidLimit)
+	case l.Fallback != "" && l.Fallback == l.Per:
+		return fmt.Errorf("%w: limit cannot fall back to itself", ErrInvalidLimit)
+	case l.Fallback != "" && l.Per == LimitPerTotal:
+		return fmt.Errorf("%w: total limit cannot have a fallback", ErrInvalidLimit)
 	}
 
 	return nil
 }
 
+// sameWindow checks if the quotas for l and o have the same MaxRequests and
+// windows, so that they can share quotas.
+func (l *Limited) sameWindow(o *Limited) bool {
+	return l.MaxRequests == o.MaxRequests &&
+		l.Period == o.Period &&
+		l.Jitter == o.Jitter &&
+		l.GraceRequests == o.GraceRequests &&
+		l.Anchor == o.Anchor &&
+		(l.Location == nil) == (o.Location == nil) &&
+		(l.Location == nil || l.Location.String() == o.Location.String())
+}
+
+// day is the length of a calendar day without a daylight saving time
+// transition.
+const day = 24 * time.Hour
+
+// maxDaylightSavingShift is the largest amount of time that a day can be
+// longer than 24 hours due to daylight saving time.
+const maxDaylightSavingShift = 2 * time.Hour
+
+// maxPeriod returns the longest amount of time a Quota for this limit can
+// exist before expiring, including any jitter.
+func (l *Limited) maxPeriod() time.Duration {
+	if l.Location != nil {
+		return l.Period + maxDaylightSavingShift
+	}
+	return l.Period + time.Duration(float64(l.Period)*l.Jitter)
+}
+
+// jitter returns a random duration to extend a Quota's expiration by.
+func (l *Limited) jitter() time.Duration {
+	if l.Jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Float64() * l.Jitter * float64(l.Period))
+}
+
+// expiration returns when a window that starts at now ends, given the jitter
+// for the window.
+func (l *Limited) expiration(now time.Time, jitter time.Duration) time.Time {
+	switch {
+	case l.Anchor == WindowAnchorEpoch && l.Location != nil:
+		// Count the calendar days since the Unix epoch in the location, and
+		// end the window at midnight on the first day of the next window.
+		y, m, d := now.In(l.Location).Date()
+		days := int64(time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Sub(time.Unix(0, 0).UTC()) / day)
+		n := int64(l.Period / day)
+		start := days - days%n
+		if start > days {
+			start -= n
+		}
+		end := time.Date(1970, 1, 1+int(start+n), 0, 0, 0, 0, l.Location)
+		// Adding the duration to now keeps any monotonic clock reading.
+		return now.Add(end.Sub(now))
+	case l.Anchor == WindowAnchorEpoch:
+		// The offset is subtracted from now, rather than truncating now,
+		// so that any monotonic clock reading is kept.
+		offset := time.Duration(now.UnixNano() % int64(l.Period))
+		if offset < 0 {
+			offset += l.Period
+		}
+		return now.Add(l.Period - offset)
+	default:
+		return now.Add(l.Period + jitter)
+	}
+}
+
+// AnonymousAuthenticatedLimits returns the limits for a resource and action
+// that limit requests differently depending on whether they have an auth
+// token. Requests with an auth token are only limited by the authenticated
+// limit for their auth token, and requests without an auth token are only
+// limited by the anonymous limit for their IP address. The Resource, Action,
+// Per, and Fallback of the provided limits are set by
+// AnonymousAuthenticatedLimits. A LimitPerTotal limit for the resource and
+// action can be added to the returned limits to also limit all requests in
+// total.
+func AnonymousAuthenticatedLimits(resource, action string, anonymous, authenticated Limited) []Limit {
+	anonymous.Resource, anonymous.Action = resource, action
+	anonymous.Per, anonymous.Fallback = LimitPerIPAddress, ""
+	authenticated.Resource, authenticated.Action = resource, action
+	authenticated.Per, authenticated.Fallback = LimitPerAuthToken, LimitPerIPAddress
+	return []Limit{&anonymous, &authenticated}
+}
+
 // Unlimited is a Limit that allows an unlimited number of requests.
 type Unlimited struct {
 	Action   string
@@ -6,8 +6,10 @@ package rate
 import (
 	"testing"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestValidLimitPer(t *testing.T) {
@@ -165,6 +167,177 @@ func TestValidLimit(t *testing.T) {
 			},
 			ErrInvalidLimit,
 		},
+		{
+			"Valid_Jitter",
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 10,
+				Period:      time.Minute,
+				Jitter:      0.5,
+			},
+			nil,
+		},
+		{
+			"Invalid_NegativeJitter",
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 10,
+				Period:      time.Minute,
+				Jitter:      -0.1,
+			},
+			ErrInvalidLimit,
+		},
+		{
+			"Invalid_EmptyIdentity",
+			&Limited{
+				Resource:      "resource",
+				Action:        "action",
+				Per:           LimitPerTotal,
+				MaxRequests:   10,
+				Period:        time.Minute,
+				EmptyIdentity: EmptyIdentity(-1),
+			},
+			ErrInvalidLimit,
+		},
+		{
+			"Valid_AnchorEpoch",
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 10,
+				Period:      time.Minute,
+				Anchor:      WindowAnchorEpoch,
+			},
+			nil,
+		},
+		{
+			"Invalid_Anchor",
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 10,
+				Period:      time.Minute,
+				Anchor:      WindowAnchor(-1),
+			},
+			ErrInvalidLimit,
+		},
+		{
+			"Invalid_AnchorEpochJitter",
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 10,
+				Period:      time.Minute,
+				Jitter:      0.1,
+				Anchor:      WindowAnchorEpoch,
+			},
+			ErrInvalidLimit,
+		},
+		{
+			"Valid_Location",
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 10,
+				Period:      7 * 24 * time.Hour,
+				Anchor:      WindowAnchorEpoch,
+				Location:    time.UTC,
+			},
+			nil,
+		},
+		{
+			"Invalid_LocationWithoutAnchor",
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 10,
+				Period:      24 * time.Hour,
+				Location:    time.UTC,
+			},
+			ErrInvalidLimit,
+		},
+		{
+			"Invalid_LocationPartialDay",
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 10,
+				Period:      36 * time.Hour,
+				Anchor:      WindowAnchorEpoch,
+				Location:    time.UTC,
+			},
+			ErrInvalidLimit,
+		},
+		{
+			"Valid_Fallback",
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerAuthToken,
+				MaxRequests: 10,
+				Period:      time.Minute,
+				Fallback:    LimitPerIPAddress,
+			},
+			nil,
+		},
+		{
+			"Invalid_Fallback",
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerAuthToken,
+				MaxRequests: 10,
+				Period:      time.Minute,
+				Fallback:    "invalid",
+			},
+			ErrInvalidLimit,
+		},
+		{
+			"Invalid_FallbackSelf",
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerIPAddress,
+				MaxRequests: 10,
+				Period:      time.Minute,
+				Fallback:    LimitPerIPAddress,
+			},
+			ErrInvalidLimit,
+		},
+		{
+			"Invalid_FallbackTotal",
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 10,
+				Period:      time.Minute,
+				Fallback:    LimitPerIPAddress,
+			},
+			ErrInvalidLimit,
+		},
+		{
+			"Invalid_JitterOne",
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 10,
+				Period:      time.Minute,
+				Jitter:      1,
+			},
+			ErrInvalidLimit,
+		},
 	}
 
 	for _, tc := range cases {
@@ -174,3 +347,133 @@ func TestValidLimit(t *testing.T) {
 		})
 	}
 }
+
+func TestLimitedExpiration(t *testing.T) {
+	start := time.Date(2023, 1, 1, 10, 20, 30, 0, time.UTC)
+
+	cases := []struct {
+		name   string
+		period time.Duration
+		anchor WindowAnchor
+		jitter time.Duration
+		now    time.Time
+		want   time.Time
+	}{
+		{"first-request", time.Hour, WindowAnchorFirstRequest, 0, start, start.Add(time.Hour)},
+		{"first-request-jitter", time.Hour, WindowAnchorFirstRequest, time.Minute, start, start.Add(time.Hour + time.Minute)},
+		{"epoch-hour", time.Hour, WindowAnchorEpoch, 0, start, time.Date(2023, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{"epoch-day", 24 * time.Hour, WindowAnchorEpoch, 0, start, time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"epoch-boundary", time.Hour, WindowAnchorEpoch, 0, start.Truncate(time.Hour), start.Truncate(time.Hour).Add(time.Hour)},
+		{"epoch-before-unix", time.Hour, WindowAnchorEpoch, 0, time.Date(1969, 12, 31, 23, 30, 0, 0, time.UTC), time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := &Limited{Period: tc.period, Anchor: tc.anchor}
+			assert.True(t, tc.want.Equal(l.expiration(tc.now, tc.jitter)), "got %s", l.expiration(tc.now, tc.jitter))
+		})
+	}
+}
+
+func TestLimitedExpirationLocation(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	plusTen := time.FixedZone("UTC+10", 10*60*60)
+
+	cases := []struct {
+		name     string
+		period   time.Duration
+		location *time.Location
+		now      time.Time
+		want     time.Time
+	}{
+		{
+			"fixed-zone",
+			24 * time.Hour,
+			plusTen,
+			time.Date(2023, 1, 1, 20, 0, 0, 0, time.UTC),
+			time.Date(2023, 1, 3, 0, 0, 0, 0, plusTen),
+		},
+		{
+			"midnight",
+			24 * time.Hour,
+			newYork,
+			time.Date(2023, 3, 11, 22, 0, 0, 0, newYork),
+			time.Date(2023, 3, 12, 0, 0, 0, 0, newYork),
+		},
+		{
+			"short-day",
+			24 * time.Hour,
+			newYork,
+			time.Date(2023, 3, 12, 1, 0, 0, 0, newYork),
+			time.Date(2023, 3, 13, 0, 0, 0, 0, newYork),
+		},
+		{
+			"long-day",
+			24 * time.Hour,
+			newYork,
+			time.Date(2023, 11, 5, 0, 30, 0, 0, newYork),
+			time.Date(2023, 11, 6, 0, 0, 0, 0, newYork),
+		},
+		{
+			// January 1st, 1970 was a Thursday, so weekly windows start on
+			// Thursdays.
+			"week",
+			7 * 24 * time.Hour,
+			newYork,
+			time.Date(2023, 1, 1, 12, 0, 0, 0, newYork),
+			time.Date(2023, 1, 5, 0, 0, 0, 0, newYork),
+		},
+		{
+			"before-unix",
+			7 * 24 * time.Hour,
+			time.UTC,
+			time.Date(1969, 12, 31, 12, 0, 0, 0, time.UTC),
+			time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := &Limited{Period: tc.period, Anchor: WindowAnchorEpoch, Location: tc.location}
+			got := l.expiration(tc.now, 0)
+			assert.True(t, tc.want.Equal(got), "want %s, got %s", tc.want, got)
+			assert.LessOrEqual(t, got.Sub(tc.now), l.maxPeriod())
+		})
+	}
+}
+
+func TestAnonymousAuthenticatedLimits(t *testing.T) {
+	got := AnonymousAuthenticatedLimits("resource", "action",
+		Limited{MaxRequests: 10, Period: time.Minute, Per: LimitPerTotal, Fallback: LimitPerTotal},
+		Limited{MaxRequests: 100, Period: time.Minute, Resource: "other"},
+	)
+	assert.Equal(t, []Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			MaxRequests: 10,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerAuthToken,
+			MaxRequests: 100,
+			Period:      time.Minute,
+			Fallback:    LimitPerIPAddress,
+		},
+	}, got)
+}
+
+func TestLimitedSameWindow(t *testing.T) {
+	l := &Limited{MaxRequests: 10, Period: 24 * time.Hour, Anchor: WindowAnchorEpoch}
+	assert.True(t, l.sameWindow(&Limited{MaxRequests: 10, Period: 24 * time.Hour, Anchor: WindowAnchorEpoch, Resource: "other"}))
+	assert.False(t, l.sameWindow(&Limited{MaxRequests: 11, Period: 24 * time.Hour, Anchor: WindowAnchorEpoch}))
+	assert.False(t, l.sameWindow(&Limited{MaxRequests: 10, Period: time.Hour, Anchor: WindowAnchorEpoch}))
+	assert.False(t, l.sameWindow(&Limited{MaxRequests: 10, Period: 24 * time.Hour}))
+	assert.False(t, l.sameWindow(&Limited{MaxRequests: 10, Period: 24 * time.Hour, Anchor: WindowAnchorEpoch, GraceRequests: 1}))
+	assert.False(t, l.sameWindow(&Limited{MaxRequests: 10, Period: 24 * time.Hour, Anchor: WindowAnchorEpoch, Location: time.UTC}))
+
+	l.Location = time.UTC
+	assert.True(t, l.sameWindow(&Limited{MaxRequests: 10, Period: 24 * time.Hour, Anchor: WindowAnchorEpoch, Location: time.UTC}))
+}
@@ -4,36 +4,202 @@
 package rate
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"math"
 	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-rate/metric"
 )
 
 type quotaFetcher interface {
 	// fetch will get a Quota for the provided key.
 	// If no quota is found, a new one will be created using the provided Limit.
-	fetch(key string, limit *Limited) (*Quota, error)
+	// If ctx is done before the Quota is fetched, its error is returned.
+	fetch(ctx context.Context, key string, limit *Limited) (*Quota, error)
 	// shutdown stops a quotaFetcher.
 	shutdown() error
+	// maxEntryTTL returns the longest period that a Quota can be stored for.
+	maxEntryTTL() time.Duration
+	// resize changes the longest period that a Quota can be stored for,
+	// moving each Quota to the bucket for its expiration.
+	resize(maxEntryTTL time.Duration) error
+	// rekey moves the Quota for the provided Limit from oldID to newID. If a
+	// Quota already exists for newID, the two are merged.
+	rekey(limit *Limited, oldID, newID string) error
+	// stats returns the current capacity and usage.
+	stats() storeStats
+	// snapshot returns the state of each quota that has not expired.
+	snapshot() []SnapshotQuota
+	// restore adds a Quota for the provided Limit using the state from a
+	// snapshot, unless a Quota already exists.
+	restore(limit *Limited, sq SnapshotQuota) error
+	// compact removes expired Quotas and releases unused memory.
+	compact() error
+	// lookup gets the Quota for the provided id and Limit, without creating
+	// it. If there is no Quota, nil is returned.
+	lookup(id string, limit *Limited) *Quota
+	// extend delays the expiration of the Quota for the provided id and
+	// Limit by d, moving it to the bucket for its new expiration.
+	extend(id string, limit *Limited, d time.Duration) error
+	// shorten ends the window of each Quota early whose limit's Period has
+	// been shortened in limits, which are keyed by quotaKey with an empty id.
+	shorten(limits map[string]*Limited) error
+}
+
+// storeStats reports the capacity and usage of a quotaFetcher.
+type storeStats struct {
+	capacity int
+	usage    int
+	// bucketEntries is the number of entries in each bucket.
+	bucketEntries []int
 }
 
 // Limiter is used to determine if a request for a given resource and action
 // should be allowed.
 // TODO: expand this doc
 type Limiter struct {
-	policies     *limitPolicies
+	// policies is replaced, rather than modified, when the limits are
+	// reloaded so that it can be read without acquiring a lock.
+	policies atomic.Pointer[limitPolicies]
+
+	// allowed and denied count the requests checked via Allow.
+	allowed atomic.Uint64
+	denied  atomic.Uint64
+
 	policyHeader string
 	usageHeader  string
+	graceHeader  string
+
+	projectedExhaustion bool
+	resetFormat         ResetFormat
+	usageDimension      bool
+	legacyHeaders       bool
+	riskMultiplier      RiskMultiplier
+	clock               Clock
+	policyTotals        bool
+	strictIPAddress     bool
+	strictPolicies      bool
+	authTokenNormalizer AuthTokenNormalizer
+	// cohorts is the number of cohorts that the identities for each
+	// LimitPer are assigned to, if WithCohorts is used for the LimitPer.
+	cohorts       map[LimitPer]int
+	usageSink     UsageSink
+	denialAlerter *DenialAlerter
+	maxClockSkew  time.Duration
+	graceHook     GraceHook
+	// tracer is used to call the TraceHook, if the Limiter has one.
+	tracer *tracer
+	// globalMultiplier is the multiplier set via SetGlobalMultiplier. It is
+	// nil until SetGlobalMultiplier is first called.
+	globalMultiplier     atomic.Pointer[float64]
+	globalMultiplierHook GlobalMultiplierHook
+	// rollouts are the Rollouts of limit policies whose limits are being
+	// gradually enforced, with their Start set.
+	rollouts             map[policyKey]Rollout
+	unenforcedDenialHook UnenforcedDenialHook
+	// shadow is true if the Limiter is in shadow mode, in which none of
+	// the limits are enforced.
+	shadow atomic.Bool
+	// stopped is true once the Limiter is shut down. It is checked before
+	// the quotas that are not stored in the quotaFetcher are consumed,
+	// since they are not stopped along with it.
+	stopped atomic.Bool
+
+	unknownPolicy       UnknownPolicyBehavior
+	unknownPolicyMetric metric.Counter
+	// defaultPolicy is the resource and action of the policy used for
+	// requests without a policy, when using UnknownPolicyUseDefault.
+	defaultPolicy *policyKey
+	// denials is used to deny requests for exhausted quotas without
+	// fetching them. It is nil unless WithDenialCache is used.
+	denials *denialCache
+	// stores are used to check and consume the quotas for each LimitPer
+	// that has one instead of the quotaFetcher. It is nil unless WithStore
+	// or WithStorePer is used.
+	stores map[LimitPer]*limiterStore
+	// storeFailureMode is how requests are handled when a Store returns an
+	// error.
+	storeFailureMode StoreFailureMode
 
-	mu sync.RWMutex
+	// reloadMu is held while the limits or rate classes are reloaded, so
+	// that limits and classes are replaced together.
+	reloadMu sync.Mutex
+	// limits are the limits that the policies were created from, before
+	// their rate classes were resolved.
+	limits  []Limit
+	classes map[string]RateClass
+	// variables are used to evaluate the MaxRequestsExpr of limits.
+	variables map[string]float64
 
 	quotaFetcher quotaFetcher
+	durable      *durableFile
+	wal          *writeAheadLog
+	// profiles switches the limits to those of the active Profile, if
+	// WithProfiles is used.
+	profiles *profileSwitcher
+
+	// config is the configuration that the Limiter was created with. It is
+	// not modified after creation, so the BucketTTL and MaxPeriod, which
+	// change when the limits are reloaded, are read from the quotaFetcher.
+	config Config
+}
+
+// Config describes the effective configuration of a Limiter.
+type Config struct {
+	// MaxSize is the max number of quotas that can be stored, and MaxSizePer
+	// is the max number of quotas that can be stored for each LimitPer that
+	// was provided via WithMaxSizePer.
+	MaxSize    int
+	MaxSizePer map[LimitPer]int
+
+	// NumberBuckets is the number of buckets used to expire quotas, and
+	// BucketTTL is the duration covered by each bucket.
+	NumberBuckets int
+	BucketTTL     time.Duration
+
+	// MaxPeriod is the longest Period, including jitter, of the Limiter's
+	// current limits. It, and the BucketTTL derived from it, change when the
+	// limits are reloaded.
+	MaxPeriod time.Duration
+
+	// PolicyHeader, UsageHeader, and GraceHeader are the names of the rate
+	// limit policy, usage, and grace HTTP headers.
+	PolicyHeader string
+	UsageHeader  string
+	GraceHeader  string
+}
+
+// Clock returns the Clock used by the Limiter, which is provided via
+// WithClock.
+func (l *Limiter) Clock() Clock {
+	return l.clock
+}
+
+// Config returns the effective configuration of the Limiter.
+func (l *Limiter) Config() Config {
+	c := l.config
+	c.MaxPeriod = l.quotaFetcher.maxEntryTTL()
+	c.BucketTTL = bucketTTLFor(c.MaxPeriod, c.NumberBuckets)
+	c.MaxSizePer = make(map[LimitPer]int, len(l.config.MaxSizePer))
+	for per, size := range l.config.MaxSizePer {
+		c.MaxSizePer[per] = size
+	}
+	return c
 }
 
 // NewLimiter will create a Limiter with the provided limits and max size. The
 // limits must each be unique, where uniqueness is determined by the
-// combination of "resource", "action", and "per". The maxSize must be greater
+// combination of "resource", "action", and "per". The limits for a resource and
+// action do not need to include a limit for each LimitPer. Requests are not
+// limited for a LimitPer without a limit, as if its limit were Unlimited,
+// unless the Limiter was created with WithStrictPolicies. The Resource or
+// Action of limits can be Wildcard to limit requests for resources and
+// actions that do not have their own limits. The maxSize must be greater
 // than zero. This size is the number of individual quotas that can be stored
 // in memory at any given time. Once this size is reached, requests that would
 // result in a new quota being inserted will not be allowed. Requests that
@@ -51,12 +217,147 @@ type Limiter struct {
 //     header via SetPolicyHeader. This defaults to "RateLimit-Policy".
 //   - WithUsageHeader: Sets the HTTP Header key to use when setting the usage
 //     header via SetUsageHeader. This defaults to "RateLimit".
+//   - WithGraceHeader: Sets the HTTP Header key to use when setting the grace
+//     header via SetUsageHeader. This defaults to "RateLimit-Grace".
+//   - WithGraceHook: Provides a function that is called when a request is
+//     allowed using the GraceRequests of a limit. The default is to only
+//     report grace requests via the grace header.
 //   - WithQuotaStorageCapacityMetric: Provides a gauge metric to report the
 //     total number of Quotas that can be stored by the Limiter. The default is
 //     to not report this metric.
 //   - WithQuotaStorageUsageMetric: Provides a gauge metric to report the
 //     current number of Quotas that are being stored by the Limiter. The
 //     default is to not report this metric.
+//   - WithProjectedExhaustion: Includes an "exhaust" parameter in the usage
+//     header with the number of seconds until the quota is projected to be
+//     exhausted. The default is to not include this parameter.
+//   - WithResetFormat: Sets how the reset parameter of the usage header
+//     reports when a quota resets, such as ResetEpochSeconds. The default is
+//     ResetDeltaSeconds, the number of seconds until the quota resets.
+//   - WithUsageDimension: Includes a comment parameter in the usage header
+//     with the LimitPer of the quota, such as comment="ip-address". The
+//     default is to not include this parameter.
+//   - WithLegacyHeaders: Also sets the X-RateLimit-Limit,
+//     X-RateLimit-Remaining, and X-RateLimit-Reset headers via
+//     SetUsageHeader. The default is to only set the draft headers.
+//   - WithWarmUp: Reduces the MaxRequests for new IP addresses and auth
+//     tokens, increasing to the full MaxRequests over a number of windows.
+//     The default is to not reduce MaxRequests for new identities.
+//   - WithRiskMultiplier: Provides a function that is called for each quota
+//     checked by Allow to adjust the effective MaxRequests for an identity.
+//     The default is to not adjust MaxRequests.
+//   - WithClock: Provides the Clock used to determine when quotas expire. The
+//     default is to use the system time.
+//   - WithCleanupBatchSize: Sets the maximum number of expired quotas that are
+//     deleted at a time while holding the lock, releasing the lock between
+//     batches. This must be greater than zero, and defaults to
+//     DefaultCleanupBatchSize.
+//   - WithQuotaPoolHitMetric: Provides a counter metric to report the number
+//     of times that storing a new Quota reused memory from a removed Quota.
+//     The default is to not report this metric.
+//   - WithQuotaPoolMissMetric: Provides a counter metric to report the number
+//     of times that storing a new Quota required allocating new memory. The
+//     default is to not report this metric.
+//   - WithEntrySlabSize: Allocates the entries used to store quotas in
+//     arrays of the provided size, reusing the entries of removed quotas via
+//     a free list, to reduce garbage collection overhead for a large max
+//     size. The default is to allocate each entry individually.
+//   - WithPreallocation: Sets how much memory is allocated up front to store
+//     quotas, such as PreallocateNone to allocate it as quotas are added.
+//     The default is PreallocateItems, which allocates enough memory to
+//     index maxSize quotas.
+//   - WithMaxSizePer: Stores the quotas for a LimitPer separately, with its
+//     own max size instead of maxSize. The default is to store the quotas for
+//     all LimitPers together.
+//   - WithPolicyTotalQuotas: Sets whether the quotas for LimitPerTotal limits
+//     without a Pool are stored with their limit policy rather than with the
+//     other quotas, in which case they do not count towards maxSize. The
+//     default is to store them with their limit policy.
+//   - WithStrictIPAddress: Rejects requests with an invalid IP address. The
+//     default is to use IP addresses that are not valid as is.
+//   - WithStrictPolicies: Requires the limits for each resource and action to
+//     include a limit for each LimitPer. The default is to allow limits for
+//     only some LimitPers.
+//   - WithAuthTokenNormalizer: Provides a function that normalizes auth
+//     tokens, such as mapping them to a principal ID, prior to them being used
+//     for quotas. The default is to use auth tokens as is.
+//   - WithUsageSink: Provides a UsageSink that receives the number of requests
+//     used by each quota once its window ends, such as for usage-based
+//     billing. The default is to not export usage.
+//   - WithDenialAlerter: Provides a DenialAlerter that is used to raise alerts
+//     when the rate of denied requests is sustained above a threshold.
+//   - WithStoreEventHook: Provides a function that is called when the store
+//     used to hold quotas becomes full or not full, or reallocates a bucket.
+//   - WithGaugePublishInterval: Publishes the quota storage capacity and usage
+//     metrics periodically rather than as part of Allow. The default is to set
+//     the usage metric as part of Allow.
+//   - WithDurableFile: Persists quotas to a file so that they are restored when
+//     the process restarts. The default is to only store quotas in memory.
+//   - WithDurableFileErrorHook: Provides a function that is called when a
+//     snapshot could not be written to the file provided via WithDurableFile.
+//     The default is to ignore the errors until the Limiter is shutdown.
+//   - WithWriteAheadLog: Appends each request for quotas with long periods to
+//     a log so that they are restored when the process restarts. The default
+//     is to only store quotas in memory.
+//   - WithUnknownPolicyBehavior: Sets how requests for a resource and action
+//     without a limit policy are handled. The default is UnknownPolicyDeny.
+//   - WithUnknownPolicyMetric: Provides a counter metric to report the number
+//     of requests for a resource and action without a limit policy. The
+//     default is to not report this metric.
+//   - WithDefaultPolicy: Provides the resource and action of the limit policy
+//     used with UnknownPolicyUseDefault, which must be in the limits.
+//   - WithMaxClockSkew: Adjusts the quotas restored from a snapshot created by
+//     a node with a clock that is ahead of the Limiter's clock. The default is
+//     to restore snapshots as is.
+//   - WithRateClasses: Provides the RateClasses referenced by the Class of
+//     limits. The default is to have no rate classes.
+//   - WithTemplateVariables: Provides the variables used to evaluate the
+//     MaxRequestsExpr of limits. The default is to have no variables.
+//   - WithDenialCache: Caches the denial of requests for exhausted quotas so
+//     that later requests are denied without fetching the quotas. The default
+//     is to not cache denials.
+//   - WithStore: Provides a Store that is used to check and consume quotas
+//     instead of storing them in memory. The default is to store quotas in
+//     memory.
+//   - WithStorePer: Provides a Store that is used to check and consume the
+//     quotas for a LimitPer, instead of the Store provided via WithStore or
+//     storing them in memory.
+//   - WithStoreFailureMode: Sets how requests are handled when the Store
+//     returns an error, such as when its backend is unavailable. The default
+//     is StoreFailClosed.
+//   - WithStoreBreaker: Calls the Store using a circuit breaker, which stops
+//     calling it once too many of the calls return an error. The default is
+//     to not use a circuit breaker.
+//   - WithStoreBreakerStateMetric: Provides a metric that records the state
+//     of the circuit breaker each time it changes.
+//   - WithStoreBreakerOpenMetric: Provides a metric that records the number
+//     of times that the circuit breaker opens.
+//   - WithStoreFallbackDropMetric: Provides a metric that records the number
+//     of times that requests consumed while the Store was failing are not
+//     resynced, when using StoreFallbackLocal.
+//   - WithTraceHook: Provides a function that is called with a TraceEvent
+//     describing how each request was evaluated. The default is to not trace
+//     requests.
+//   - WithTraceSampling: Only traces one in every n requests when using
+//     WithTraceHook. The default is to trace each request.
+//   - WithRollout: Gradually enforces the limits of a limit policy, denying
+//     an increasing percentage of over-limit requests. The default is to
+//     deny every over-limit request.
+//   - WithUnenforcedDenialHook: Provides a function that is called when an
+//     over-limit request is allowed due to a Rollout. The default is to not
+//     report such requests.
+//   - WithGlobalMultiplierHook: Provides a function that is called when the
+//     multiplier set via SetGlobalMultiplier changes. The default is to not
+//     report changes.
+//   - WithProfiles: Provides Profiles whose limits replace the limits during
+//     scheduled windows, such as a nightly maintenance window. The default is
+//     to always use the limits.
+//   - WithProfileHook: Provides a function that is called when the Limiter
+//     switches to or from the limits of a Profile. The default is to not
+//     report profile switches.
+//   - WithCohorts: Assigns the IP addresses or auth tokens of requests to a
+//     number of cohorts, and limits each cohort rather than each identity.
+//     The default is to limit each identity.
 func NewLimiter(limits []Limit, maxSize int, o ...Option) (*Limiter, error) {
 	const op = "rate.NewLimiter"
 
@@ -69,21 +370,189 @@ func NewLimiter(limits []Limit, maxSize int, o ...Option) (*Limiter, error) {
 
 	opts := getOpts(o...)
 
-	policies, err := newLimitPolicies(limits)
+	for name, c := range opts.withRateClasses {
+		if err := c.validate(); err != nil {
+			return nil, fmt.Errorf("%s: rate class %q: %w", op, name, err)
+		}
+	}
+	resolved, err := resolveClasses(limits, opts.withRateClasses)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	for per, n := range opts.withCohorts {
+		switch {
+		case per != LimitPerIPAddress && per != LimitPerAuthToken:
+			return nil, fmt.Errorf("%s: cohorts for %q: %w", op, per, ErrInvalidLimitPer)
+		case n <= 0:
+			return nil, fmt.Errorf("%s: number of cohorts must be greater than zero: %w", op, ErrInvalidParameter)
+		}
+	}
+	names := make(map[string]bool, len(opts.withProfiles))
+	for _, p := range opts.withProfiles {
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("%s: duplicate profile %q: %w", op, p.Name, ErrInvalidParameter)
+		}
+		names[p.Name] = true
+	}
+	var rollouts map[policyKey]Rollout
+	if len(opts.withRollouts) > 0 {
+		rollouts = make(map[policyKey]Rollout, len(opts.withRollouts))
+	}
+	for k, r := range opts.withRollouts {
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("%s: rollout for %q %q: %w", op, k.resource, k.action, err)
+		}
+		if r.Start.IsZero() {
+			r.Start = opts.withClock.Now()
+		}
+		rollouts[k] = r
+	}
+	resolved, err = resolveTemplates(resolved, opts.withTemplateVariables)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	policies, err := newLimitPolicies(resolved, opts.withStrictPolicies)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	s, err := newExpirableStore(maxSize, policies.maxPeriod, o...)
+	for per := range opts.withMaxSizePer {
+		if !per.IsValid() {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidLimitPer)
+		}
+	}
+	if opts.withMaxClockSkew < 0 {
+		return nil, fmt.Errorf("%s: max clock skew must not be negative: %w", op, ErrInvalidParameter)
+	}
+	if opts.withDenialCacheMinResetsIn < 0 || opts.withDenialCacheMaxSize < 0 {
+		return nil, fmt.Errorf("%s: denial cache min resets in and max size must not be negative: %w", op, ErrInvalidParameter)
+	}
+	if !opts.withResetFormat.IsValid() {
+		return nil, fmt.Errorf("%s: invalid reset format: %w", op, ErrInvalidParameter)
+	}
+	switch {
+	case !opts.withUnknownPolicyBehavior.IsValid():
+		return nil, fmt.Errorf("%s: invalid unknown policy behavior: %w", op, ErrInvalidParameter)
+	case opts.withUnknownPolicyBehavior == UnknownPolicyUseDefault && opts.withDefaultPolicy == nil:
+		return nil, fmt.Errorf("%s: missing default policy: %w", op, ErrInvalidParameter)
+	}
+	if err := checkDefaultPolicy(policies, opts.withUnknownPolicyBehavior, opts.withDefaultPolicy); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if !opts.withStoreFailureMode.IsValid() {
+		return nil, fmt.Errorf("%s: invalid store failure mode: %w", op, ErrInvalidParameter)
+	}
+	if opts.withStoreBreaker != nil {
+		if err := opts.withStoreBreaker.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	for per, store := range opts.withStorePer {
+		switch {
+		case !per.IsValid():
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidLimitPer)
+		case store == nil:
+			return nil, fmt.Errorf("%s: missing store for %q: %w", op, per, ErrInvalidParameter)
+		}
+	}
+
+	var s quotaFetcher
+	switch {
+	case len(opts.withMaxSizePer) > 0:
+		s, err = newPerStore(maxSize, policies.maxPeriod, opts.withMaxSizePer, o...)
+	default:
+		s, err = newExpirableStore(maxSize, policies.maxPeriod, o...)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
+	maxSizePer := make(map[LimitPer]int, len(opts.withMaxSizePer))
+	for per, size := range opts.withMaxSizePer {
+		maxSizePer[per] = size
+	}
+
 	l := &Limiter{
-		policies:     policies,
 		quotaFetcher: s,
-		policyHeader: opts.withPolicyHeader,
-		usageHeader:  opts.withUsageHeader,
+		config: Config{
+			MaxSize:       maxSize,
+			MaxSizePer:    maxSizePer,
+			NumberBuckets: opts.withNumberBuckets,
+			BucketTTL:     bucketTTLFor(policies.maxPeriod, opts.withNumberBuckets),
+			MaxPeriod:     policies.maxPeriod,
+			PolicyHeader:  http.CanonicalHeaderKey(opts.withPolicyHeader),
+			UsageHeader:   http.CanonicalHeaderKey(opts.withUsageHeader),
+			GraceHeader:   http.CanonicalHeaderKey(opts.withGraceHeader),
+		},
+		policyHeader: http.CanonicalHeaderKey(opts.withPolicyHeader),
+		usageHeader:  http.CanonicalHeaderKey(opts.withUsageHeader),
+		graceHeader:  http.CanonicalHeaderKey(opts.withGraceHeader),
+
+		projectedExhaustion:  opts.withProjectedExhaustion,
+		resetFormat:          opts.withResetFormat,
+		usageDimension:       opts.withUsageDimension,
+		legacyHeaders:        opts.withLegacyHeaders,
+		riskMultiplier:       opts.withRiskMultiplier,
+		clock:                opts.withClock,
+		policyTotals:         opts.withPolicyTotalQuotas,
+		strictIPAddress:      opts.withStrictIPAddress,
+		strictPolicies:       opts.withStrictPolicies,
+		authTokenNormalizer:  opts.withAuthTokenNormalizer,
+		cohorts:              opts.withCohorts,
+		usageSink:            opts.withUsageSink,
+		denialAlerter:        opts.withDenialAlerter,
+		maxClockSkew:         opts.withMaxClockSkew,
+		graceHook:            opts.withGraceHook,
+		globalMultiplierHook: opts.withGlobalMultiplierHook,
+		rollouts:             rollouts,
+		unenforcedDenialHook: opts.withUnenforcedDenialHook,
+		unknownPolicy:        opts.withUnknownPolicyBehavior,
+		unknownPolicyMetric:  opts.withUnknownPolicyMetric,
+		defaultPolicy:        opts.withDefaultPolicy,
+		limits:               append([]Limit(nil), limits...),
+		classes:              opts.withRateClasses,
+		variables:            opts.withTemplateVariables,
+		stores:               newLimiterStores(opts, s, maxSize),
+		storeFailureMode:     opts.withStoreFailureMode,
+	}
+	l.policies.Store(policies)
+	if opts.withTraceHook != nil {
+		l.tracer = newTracer(opts.withTraceHook, opts.withTraceSampling)
+	}
+	if opts.withDenialCacheMaxSize > 0 {
+		l.denials = newDenialCache(opts.withDenialCacheMinResetsIn, opts.withDenialCacheMaxSize, opts.withClock)
+	}
+
+	if len(opts.withProfiles) > 0 {
+		for _, p := range opts.withProfiles {
+			if _, err := l.newPolicies(p.Limits, l.classes); err != nil {
+				_ = s.shutdown()
+				return nil, fmt.Errorf("%s: profile %q: %w", op, p.Name, err)
+			}
+		}
+		l.profiles, err = newProfileSwitcher(l, opts.withProfiles, opts.withProfileHook)
+		if err != nil {
+			_ = s.shutdown()
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if opts.withDurableFile != "" {
+		l.durable, err = newDurableFile(l, opts.withDurableFile, opts.withDurableFileInterval, opts.withDurableFileErrorHook)
+		if err != nil {
+			_ = s.shutdown()
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	if opts.withWriteAheadLog != "" {
+		l.wal, err = newWriteAheadLog(l, opts.withWriteAheadLog, opts.withWriteAheadLogMinPeriod, opts.withWriteAheadLogCompact)
+		if err != nil {
+			_ = l.Shutdown()
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
 	}
 
 	return l, nil
@@ -92,30 +561,225 @@ func NewLimiter(limits []Limit, maxSize int, o ...Option) (*Limiter, error) {
 // SetPolicyHeader sets the rate limit policy HTTP header for the provided
 // resource and action.
 func (l *Limiter) SetPolicyHeader(resource, action string, header http.Header) error {
-	pol, err := l.policies.get(resource, action)
+	pol, err := l.policies.Load().get(resource, action)
 	if err != nil {
 		return err
 	}
-	p := pol.httpHeaderValue()
-	if p == "" {
+	if pol.policyHeader == nil {
 		return nil
 	}
 
-	header.Set(l.policyHeader, pol.httpHeaderValue())
+	header[l.policyHeader] = pol.policyHeader
 	return nil
 }
 
+// SetHeaders sets both the rate limit policy HTTP header for the provided
+// resource and action, and the rate limit usage HTTP header using the
+// provided Quota. It is equivalent to calling SetPolicyHeader and
+// SetUsageHeader, but only looks up the policy once. If there is no policy
+// for the resource and action, neither header is set.
+func (l *Limiter) SetHeaders(resource, action string, quota *Quota, header http.Header) error {
+	pol, err := l.policies.Load().get(resource, action)
+	if err != nil {
+		return err
+	}
+	if pol.policyHeader != nil {
+		header[l.policyHeader] = pol.policyHeader
+	}
+	l.SetUsageHeader(quota, header)
+	return nil
+}
+
+// PolicyHeaderValue returns the value of the rate limit policy HTTP header for
+// the provided resource and action. The returned bool is false if there is no
+// limit policy for the resource and action, or if all of its limits are
+// Unlimited. Unlike SetPolicyHeader, an error is not returned when a policy is
+// not found, making it suitable for use in middleware hot paths.
+func (l *Limiter) PolicyHeaderValue(resource, action string) (string, bool) {
+	pol, ok := l.policies.Load().lookup(resource, action)
+	if !ok || pol.policy == "" {
+		return "", false
+	}
+	return pol.policy, true
+}
+
+// Policies returns the Limiter's current limit policies, sorted by resource
+// and then action. The returned policies are copies, so modifying them does
+// not affect the Limiter. If the limits are reloaded, or a Profile starts or
+// ends, the returned policies are not updated.
+func (l *Limiter) Policies() []Policy {
+	return l.policies.Load().export()
+}
+
 // SetUsageHeader sets the rate limit usage HTTP header using the provided
-// Quota.
+// Quota. If grace requests have been used from the Quota, the grace HTTP
+// header is also set to the number of grace requests that remain. If the
+// Limiter was created with WithLegacyHeaders, the X-RateLimit-Limit,
+// X-RateLimit-Remaining, and X-RateLimit-Reset headers are also set.
 func (l *Limiter) SetUsageHeader(quota *Quota, header http.Header) {
 	if quota == nil {
 		return
 	}
 
-	header.Set(
-		l.usageHeader,
-		fmt.Sprintf("limit=%d, remaining=%d, reset=%.0f", quota.MaxRequests(), quota.Remaining(), math.Ceil(quota.ResetsIn().Seconds())),
-	)
+	// The values of the usage header and any legacy headers are appended to
+	// a single buffer, and converted to a single string that each value is a
+	// substring of, so that only two allocations are needed regardless of
+	// the number of headers.
+	var buf [usageHeaderBufSize]byte
+	st := quota.headerState()
+	b := l.appendUsageHeader(buf[:0], quota, st)
+	if !l.legacyHeaders {
+		header[l.usageHeader] = []string{string(b)}
+	} else {
+		var ends [4]int
+		ends[0] = len(b)
+		b = strconv.AppendUint(b, st.maxRequests, 10)
+		ends[1] = len(b)
+		b = strconv.AppendUint(b, st.remaining, 10)
+		ends[2] = len(b)
+		b = l.appendReset(b, st)
+		ends[3] = len(b)
+
+		str, values := string(b), make([]string, 4)
+		start := 0
+		for i, end := range ends {
+			values[i] = str[start:end]
+			start = end
+		}
+		// The slices are capped so that appending to one of the headers
+		// does not overwrite the value of the next.
+		header[l.usageHeader] = values[0:1:1]
+		header[legacyLimitHeader] = values[1:2:2]
+		header[legacyRemainingHeader] = values[2:3:3]
+		header[legacyResetHeader] = values[3:4:4]
+	}
+	if st.graceUsed > 0 {
+		header[l.graceHeader] = []string{strconv.FormatUint(st.remainingWithGrace, 10)}
+	}
+}
+
+// The legacy rate limit HTTP headers set by SetUsageHeader when the Limiter
+// was created with WithLegacyHeaders. They are in their canonical form so
+// that they can be set without canonicalizing them for each response.
+const (
+	legacyLimitHeader     = "X-Ratelimit-Limit"
+	legacyRemainingHeader = "X-Ratelimit-Remaining"
+	legacyResetHeader     = "X-Ratelimit-Reset"
+)
+
+// retryAfterHeader is the Retry-After HTTP header set by SetDeniedHeaders.
+const retryAfterHeader = "Retry-After"
+
+// SetDeniedHeaders sets the HTTP headers for a request that was denied with
+// err, such as by AllowErr, so that each way a request can be denied results
+// in a consistent set of headers:
+//   - ErrRateLimited: The Retry-After header is set to the number of seconds
+//     until the exhausted quota resets, and the rate limit policy and usage
+//     headers are set as with SetHeaders, using the exhausted quota.
+//   - ErrLimiterFull: The Retry-After header is set to the number of seconds
+//     until the Limiter expects to have space for new quotas, which is at
+//     least one. Since no quota was consulted, the rate limit usage header is
+//     not set.
+//
+// No headers are set for any other error, including a nil error, since the
+// request was not denied because of a limit.
+func (l *Limiter) SetDeniedHeaders(err error, header http.Header) {
+	var limited *ErrRateLimited
+	var full *ErrLimiterFull
+	switch {
+	case errors.As(err, &limited):
+		if limited.Quota == nil {
+			return
+		}
+		retry := ceilSeconds(limited.RetryAfter())
+		if retry < 0 {
+			retry = 0
+		}
+		header[retryAfterHeader] = []string{strconv.FormatInt(retry, 10)}
+		_ = l.SetHeaders(limited.Resource, limited.Action, limited.Quota, header)
+	case errors.As(err, &full):
+		retry := ceilSeconds(full.RetryIn)
+		if retry < 1 {
+			// Space is expected to be available imminently, but clients
+			// should still wait before retrying.
+			retry = 1
+		}
+		header[retryAfterHeader] = []string{strconv.FormatInt(retry, 10)}
+	}
+}
+
+// usageHeaderBufSize is large enough to hold most usage header values without
+// needing to grow the buffer.
+const usageHeaderBufSize = 96
+
+// AppendUsageHeader appends the value of the rate limit usage HTTP header for
+// the provided Quota to dst and returns the extended buffer. This can be used
+// in place of SetUsageHeader to avoid allocations by reusing a buffer. If the
+// quota is nil, dst is returned unmodified.
+func (l *Limiter) AppendUsageHeader(dst []byte, quota *Quota) []byte {
+	if quota == nil {
+		return dst
+	}
+	return l.appendUsageHeader(dst, quota, quota.headerState())
+}
+
+// appendUsageHeader appends the value of the rate limit usage HTTP header for
+// the quota with the provided state to dst.
+func (l *Limiter) appendUsageHeader(dst []byte, quota *Quota, st headerState) []byte {
+	dst = append(dst, "limit="...)
+	dst = strconv.AppendUint(dst, st.maxRequests, 10)
+	dst = append(dst, ", remaining="...)
+	dst = strconv.AppendUint(dst, st.remaining, 10)
+	dst = append(dst, ", reset="...)
+	if l.resetFormat == ResetIMFFixdate {
+		dst = append(dst, '"')
+		dst = l.appendReset(dst, st)
+		dst = append(dst, '"')
+	} else {
+		dst = l.appendReset(dst, st)
+	}
+	if l.projectedExhaustion {
+		if e := quota.ProjectedExhaustion(); !e.IsZero() {
+			dst = append(dst, ", exhaust="...)
+			dst = strconv.AppendInt(dst, ceilSeconds(e.Sub(quota.now())), 10)
+		}
+	}
+	if l.usageDimension {
+		dst = append(dst, `, comment="`...)
+		dst = append(dst, st.per...)
+		dst = append(dst, '"')
+	}
+	return dst
+}
+
+// appendReset appends when the quota with the provided state resets to dst,
+// using the Limiter's ResetFormat.
+func (l *Limiter) appendReset(dst []byte, st headerState) []byte {
+	switch l.resetFormat {
+	case ResetEpochSeconds:
+		return strconv.AppendInt(dst, ceilTime(st.expiresAt.Round(0)).Unix(), 10)
+	case ResetIMFFixdate:
+		return ceilTime(st.expiresAt.Round(0)).UTC().AppendFormat(dst, http.TimeFormat)
+	default:
+		return strconv.AppendInt(dst, ceilSeconds(st.expiresAt.Sub(st.now)), 10)
+	}
+}
+
+// ceilSeconds returns the number of seconds in d, rounded up.
+func ceilSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return int64(d / time.Second)
+	}
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+// ceilTime returns t rounded up to the next whole second, so that a reset
+// time is never reported before the quota actually resets.
+func ceilTime(t time.Time) time.Time {
+	if r := t.Truncate(time.Second); !r.Equal(t) {
+		return r.Add(time.Second)
+	}
+	return t
 }
 
 // Allow checks if a request for the given resource and action should be allowed.
@@ -125,13 +789,121 @@ func (l *Limiter) SetUsageHeader(quota *Quota, header http.Header) {
 //     The error returned in this case will be a ErrLimiterFull with a provided
 //     RetryIn duration. Callers should use this time as an estimation of when
 //     the limiter should no longer be full.
-//   - There is no corresponding limit for the resource and action.
+//   - There is no corresponding limit for the resource and action, and the
+//     Limiter was created with UnknownPolicyDeny, which is the default. The
+//     error returned in this case will be ErrLimitPolicyNotFound.
+//   - The IP address or auth token is empty and the corresponding limit is
+//     configured with EmptyIdentityDeny. The error returned in this case will
+//     be ErrEmptyIdentity.
+//   - The IP address is not valid and the Limiter was created with
+//     WithStrictIPAddress. The error returned in this case will be
+//     ErrInvalidIPAddress.
+//
+// IP addresses are canonicalized prior to being used, so that different
+// representations of the same address share the same quota. If the Limiter
+// was created with WithAuthTokenNormalizer, the auth token is normalized
+// prior to being used.
+//
+// If a quota has been exhausted, but its limit has GraceRequests that have not
+// been used, the request is allowed. The grace header is then set by
+// SetUsageHeader, and the Limiter's GraceHook is called, if it has one.
 //
 // If all of the limits for the given resource and action are Unlimited, the
 // action will be allowed, but the quota returned will be nil.
 func (l *Limiter) Allow(resource, action, ip, authToken string) (allowed bool, quota *Quota, err error) {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
+	return l.AllowN(resource, action, ip, authToken, 1)
+}
+
+// AllowContext is like Allow, but if ctx is done before the request's quotas
+// have been fetched, the request is not allowed and ctx's error is returned.
+func (l *Limiter) AllowContext(ctx context.Context, resource, action, ip, authToken string) (allowed bool, quota *Quota, err error) {
+	return l.AllowNContext(ctx, resource, action, ip, authToken, 1)
+}
+
+// AllowErr is like Allow, but returns an *ErrRateLimited when the request is
+// denied because a quota has been exhausted, rather than reporting it via a
+// boolean, so that the request is allowed if and only if the returned error is
+// nil. Any other error returned by Allow is returned as is.
+func (l *Limiter) AllowErr(resource, action, ip, authToken string) (*Quota, error) {
+	return l.AllowNErr(resource, action, ip, authToken, 1)
+}
+
+// AllowNErr is like AllowErr, but the request costs n requests from each of
+// the associated quotas, as with AllowN.
+func (l *Limiter) AllowNErr(resource, action, ip, authToken string, n uint64) (*Quota, error) {
+	return l.allowNErr(context.Background(), resource, action, ip, authToken, n)
+}
+
+// allowNErr is like AllowNErr, but uses AllowNContext with ctx.
+func (l *Limiter) allowNErr(ctx context.Context, resource, action, ip, authToken string, n uint64) (*Quota, error) {
+	allowed, quota, err := l.AllowNContext(ctx, resource, action, ip, authToken, n)
+	switch {
+	case err != nil:
+		return quota, err
+	case !allowed:
+		return quota, newErrRateLimited(resource, action, quota)
+	}
+	return quota, nil
+}
+
+// AllowN is like Allow, but the request costs n requests from each of the
+// associated quotas. The request is not allowed unless each quota has at
+// least n remaining requests. A cost of zero checks that the quotas have not
+// been exhausted without consuming from them.
+func (l *Limiter) AllowN(resource, action, ip, authToken string, n uint64) (allowed bool, quota *Quota, err error) {
+	return l.AllowNContext(context.Background(), resource, action, ip, authToken, n)
+}
+
+// AllowNContext is like AllowN, but if ctx is done before the request's
+// quotas have been fetched, the request is not allowed and ctx's error is
+// returned. The ctx is passed to the store used to fetch the quotas, so that
+// a store that is not in memory can stop waiting once ctx's deadline passes.
+func (l *Limiter) AllowNContext(ctx context.Context, resource, action, ip, authToken string, n uint64) (allowed bool, quota *Quota, err error) {
+	policies := l.policies.Load()
+	// policy is the limit policy that the request matched, if any.
+	var policy *limitPolicy
+	// unknown is true if there is no policy for the resource and action.
+	var unknown bool
+	// tr records the evaluation of the request, if it is traced.
+	var tr *trace
+	// unenforced are the quotas that the request exceeded, but that were
+	// not enforced due to the policy's Rollout or shadow mode.
+	var unenforced []UnenforcedDenial
+	if l.tracer != nil && l.tracer.sample() {
+		tr = &trace{event: TraceEvent{Resource: resource, Action: action, Cost: n, Start: l.clock.Now()}}
+	}
+
+	defer func() {
+		switch {
+		case allowed:
+			l.allowed.Add(1)
+		default:
+			l.denied.Add(1)
+		}
+		if l.denialAlerter != nil && policy != nil && !unknown {
+			// The request is recorded for the policy that it matched,
+			// rather than its resource and action, so that requests
+			// matching a Wildcard policy are tracked together.
+			l.denialAlerter.Record(policy.resource, policy.action, allowed)
+		}
+		if tr != nil {
+			l.tracer.hook(tr.finish(l.clock.Now(), allowed, err))
+		}
+		if allowed && l.unenforcedDenialHook != nil {
+			for _, d := range unenforced {
+				l.unenforcedDenialHook(d)
+			}
+		}
+	}()
+
+	ip, authToken, err = l.normalizeIdentity(ip, authToken)
+	if err != nil {
+		allowed = false
+		return
+	}
+	if tr != nil {
+		tr.event.IP, tr.event.AuthToken = ip, authToken
+	}
 
 	allowOrder := []LimitPer{
 		LimitPerTotal,
@@ -140,71 +912,751 @@ func (l *Limiter) Allow(resource, action, ip, authToken string) (allowed bool, q
 	}
 
 	quotas := make(map[LimitPer]*Quota, len(allowOrder))
+	// grace is true if any of the quotas will use grace requests.
+	var grace bool
 	keys := map[LimitPer]string{
 		LimitPerTotal:     string(LimitPerTotal),
 		LimitPerIPAddress: ip,
 		LimitPerAuthToken: authToken,
 	}
 
-	allowed = true
-	for per, id := range keys {
-		var limit Limit
-		var policy *limitPolicy
-		policy, err = l.policies.get(resource, action)
-		if err != nil {
-			allowed = false
+	policy, ok := policies.lookup(resource, action)
+	if !ok {
+		unknown = true
+		l.unknownPolicyMetric.Add(1)
+		if l.unknownPolicy == UnknownPolicyAllow {
+			allowed = true
 			return
 		}
-
-		limit, err = policy.limit(per)
-		if err != nil {
+		if policy, ok = l.unknownPolicyDefault(policies); !ok {
 			allowed = false
+			err = ErrLimitPolicyNotFound
 			return
 		}
+	}
+	skip := policy.fallbackSkips(keys)
+
+	// enforce is false if the policy's Rollout does not enforce its limits
+	// for this request, or if the Limiter is in shadow mode. Denials are only
+	// cached once the Rollout is complete, since a cached denial would be
+	// enforced for later requests.
+	enforce, percent := true, float64(100)
+	switch r, ok := l.rollouts[limitPolicyKey(policy.resource, policy.action)]; {
+	case l.shadow.Load():
+		enforce, percent = false, 0
+	case ok:
+		percent = r.percentAt(l.clock.Now())
+		enforce = enforcePercent(percent)
+	}
+
+	// storeChecks are the keys and limits of the quotas to check via each
+	// of the Limiter's Stores, if it has any.
+	var storeChecks []storeCheck
+
+	allowed = true
+	for per, id := range keys {
+		if skip[per] {
+			continue
+		}
+		limit, ok := policy.m[per]
+		if !ok {
+			// Requests are not limited for a LimitPer without a limit.
+			continue
+		}
 
 		switch ll := limit.(type) {
 		case *Unlimited:
 			continue
 		case *Limited:
+			if id == "" && per != LimitPerTotal {
+				switch ll.EmptyIdentity {
+				case EmptyIdentitySkip:
+					continue
+				case EmptyIdentityDeny:
+					allowed = false
+					err = ErrEmptyIdentity
+					return
+				}
+			}
+
+			// key is the key of the quota, which is only needed when
+			// using a denial cache or Store, or tracing the request.
+			var key string
+			if l.denials != nil || l.stores != nil || tr != nil {
+				key = quotaKey(ll, id)
+			}
+			if l.denials != nil && enforce {
+				if q, ok := l.denials.lookup(key); ok {
+					if tr != nil {
+						i := tr.add(per, id, key, ll, nil)
+						tr.event.Dimensions[i].Denied = true
+						tr.event.Dimensions[i].Cached = true
+					}
+					allowed = false
+					quota = q
+					return
+				}
+			}
+
+			if s, ok := l.stores[per]; ok {
+				if tr != nil {
+					tr.add(per, id, key, ll, nil)
+				}
+				storeChecks = addStoreCheck(storeChecks, s, Key{Name: key, Per: per, ID: id}, ll)
+				continue
+			}
+
 			var q *Quota
-			q, err = l.quotaFetcher.fetch(id, ll)
-			if err != nil {
-				allowed = false
-				return
+			switch {
+			case l.usesPolicyTotal(ll):
+				// There is only one quota for the total, so it can be
+				// stored with the policy rather than in the quotaFetcher.
+				if l.stopped.Load() {
+					allowed = false
+					err = ErrStopped
+					return
+				}
+				q = policy.totalQuota(ll, l.clock, l.usageSink)
+			default:
+				q, err = l.quotaFetcher.fetch(ctx, id, ll)
+				if err != nil {
+					allowed = false
+					return
+				}
+			}
+			if m, ok := l.quotaMultiplier(per, id); ok {
+				q.setRisk(m)
+			}
+			// traced is the index of the quota's trace dimension.
+			var traced int
+			if tr != nil {
+				traced = tr.add(per, id, key, ll, q)
 			}
 
-			if q.Remaining() <= 0 {
-				allowed = false
-				quota = q
-				return
+			if remaining := q.Remaining(); remaining <= 0 || remaining < n {
+				if remaining = q.remainingWithGrace(); remaining <= 0 || remaining < n {
+					if !enforce {
+						unenforced = append(unenforced, UnenforcedDenial{Resource: resource, Action: action, Per: per, ID: id, Percent: percent})
+						continue
+					}
+					if tr != nil {
+						tr.event.Dimensions[traced].Denied = true
+					}
+					if l.denials != nil && percent >= 100 {
+						l.denials.add(key, q)
+					}
+					allowed = false
+					quota = q
+					return
+				}
+				grace = true
 			}
 
 			quotas[per] = q
 		}
 	}
 
+	// The quotas in the Stores are checked and consumed after the quotas
+	// stored in the Limiter are checked, but before they are consumed, so
+	// that the quotas stored in the Limiter are not consumed if a Store
+	// denies the request. Each Store consumes its quotas separately, so a
+	// request that is denied by one Store may have consumed from the quotas
+	// in another.
+	sortStoreChecks(storeChecks)
+	for _, c := range storeChecks {
+		var q *Quota
+		allowed, q, err = l.checkAndConsume(ctx, c.store, c.keys, c.limits, n, percent >= 100)
+		if !allowed && err == nil && !enforce && q != nil {
+			// The Store does not consume any of the quotas if one is
+			// exceeded, so the request is allowed without consuming them.
+			per := q.limit.Per
+			unenforced = append(unenforced, UnenforcedDenial{Resource: resource, Action: action, Per: per, ID: keys[per], Percent: percent})
+			allowed = true
+		}
+		switch {
+		case !allowed || err != nil:
+			quota = q
+			return
+		case quota == nil, q != nil && q.Remaining() < quota.Remaining():
+			quota = q
+		}
+	}
+
 	for _, per := range allowOrder {
 		q, ok := quotas[per]
 		if !ok {
 			// we may not have a quota if the corresponding limit is Unlimited.
 			continue
 		}
-		q.Consume()
-		if quota == nil || q.Remaining() < quota.Remaining() {
+		q.consumeN(n)
+		if l.wal != nil {
+			l.wal.consume(q, keys[per], n)
+		}
+		switch {
+		case quota == nil:
+			quota = q
+		case q.Remaining() < quota.Remaining():
 			quota = q
+		case grace && q.Remaining() == quota.Remaining() && q.remainingWithGrace() < quota.remainingWithGrace():
+			// Prefer the quota that is using grace requests, so that the
+			// grace header is set.
+			quota = q
+		}
+		if grace && l.graceHook != nil && q.GraceUsed() > 0 {
+			l.graceHook(q.graceRequest(keys[per]))
 		}
 	}
 
 	return
 }
 
+// usesPolicyTotal checks if the quota for the limit is stored with its policy,
+// rather than in the quotaFetcher.
+func (l *Limiter) usesPolicyTotal(ll *Limited) bool {
+	return l.policyTotals && ll.Per == LimitPerTotal && ll.Pool == ""
+}
+
+// unknownPolicyDefault returns the policy used for requests for a resource
+// and action without a policy when using UnknownPolicyUseDefault. The returned
+// bool is false if the Limiter does not use UnknownPolicyUseDefault.
+func (l *Limiter) unknownPolicyDefault(policies *limitPolicies) (*limitPolicy, bool) {
+	if l.unknownPolicy != UnknownPolicyUseDefault || l.defaultPolicy == nil {
+		return nil, false
+	}
+	return policies.lookup(l.defaultPolicy.resource, l.defaultPolicy.action)
+}
+
+// policyFor returns the policy for the resource and action, using the
+// Limiter's UnknownPolicyBehavior if there is no such policy. If requests
+// without a policy are allowed, a nil policy is returned.
+func (l *Limiter) policyFor(resource, action string) (*limitPolicy, error) {
+	policies := l.policies.Load()
+	if policy, ok := policies.lookup(resource, action); ok {
+		return policy, nil
+	}
+	if l.unknownPolicy == UnknownPolicyAllow {
+		return nil, nil
+	}
+	if policy, ok := l.unknownPolicyDefault(policies); ok {
+		return policy, nil
+	}
+	return nil, ErrLimitPolicyNotFound
+}
+
+// checkDefaultPolicy checks that the policies include the default policy, if
+// it is used for requests without a policy.
+func checkDefaultPolicy(policies *limitPolicies, b UnknownPolicyBehavior, defaultPolicy *policyKey) error {
+	if b != UnknownPolicyUseDefault || defaultPolicy == nil {
+		return nil
+	}
+	if _, ok := policies.lookup(defaultPolicy.resource, defaultPolicy.action); !ok {
+		return fmt.Errorf("default policy: %w", ErrLimitPolicyNotFound)
+	}
+	return nil
+}
+
+// normalizeIdentity canonicalizes the IP address, and normalizes the auth
+// token if the Limiter has an AuthTokenNormalizer. Each is then replaced by
+// its cohort if the Limiter was created with WithCohorts. If the IP address is
+// not valid and the Limiter was created with WithStrictIPAddress,
+// ErrInvalidIPAddress is returned.
+func (l *Limiter) normalizeIdentity(ip, authToken string) (string, string, error) {
+	if ip != "" {
+		var ok bool
+		ip, ok = canonicalIP(ip)
+		if !ok && l.strictIPAddress {
+			return "", "", ErrInvalidIPAddress
+		}
+	}
+	if l.authTokenNormalizer != nil && authToken != "" {
+		authToken = l.authTokenNormalizer(authToken)
+	}
+	return l.cohort(LimitPerIPAddress, ip), l.cohort(LimitPerAuthToken, authToken), nil
+}
+
+// Refund returns n requests to each of the quotas for the given resource and
+// action that a request with the same IP address and auth token would use,
+// such as when a request that was allowed could not be processed due to a
+// server error. Quotas are not created by Refund, and a quota cannot be
+// refunded more requests than have been used during its current window.
+// Since the quotas are looked up again, a quota that has been reset since the
+// request was allowed will be refunded for its new window.
+func (l *Limiter) Refund(resource, action, ip, authToken string, n uint64) error {
+	const op = "rate.(Limiter).Refund"
+
+	if l.stores != nil {
+		return wrapOp(op, ErrNotSupported)
+	}
+
+	policy, err := l.policyFor(resource, action)
+	if err != nil {
+		return wrapOp(op, err)
+	}
+	if policy == nil {
+		return nil
+	}
+	ip, authToken, err = l.normalizeIdentity(ip, authToken)
+	if err != nil {
+		return wrapOp(op, err)
+	}
+	keys := map[LimitPer]string{
+		LimitPerTotal:     string(LimitPerTotal),
+		LimitPerIPAddress: ip,
+		LimitPerAuthToken: authToken,
+	}
+	skip := policy.fallbackSkips(keys)
+
+	for per, id := range keys {
+		if skip[per] {
+			continue
+		}
+		ll, ok := policy.m[per].(*Limited)
+		if !ok {
+			continue
+		}
+		if id == "" && per != LimitPerTotal && ll.EmptyIdentity != EmptyIdentityShared {
+			continue
+		}
+
+		var q *Quota
+		switch {
+		case l.usesPolicyTotal(ll):
+			q = policy.total.Load()
+		default:
+			q = l.quotaFetcher.lookup(id, ll)
+		}
+		if q == nil || q.Expired() {
+			continue
+		}
+		q.refund(n)
+		if l.wal != nil {
+			l.wal.refund(q, id, n)
+		}
+		if l.denials != nil {
+			l.denials.remove(quotaKey(ll, id))
+		}
+	}
+	return nil
+}
+
+// Charge consumes n requests from each of the quotas for the given resource
+// and action that a request with the same IP address and auth token would use,
+// without checking if the quotas have remaining requests. This allows requests
+// that have already been allowed to be charged for additional requests once
+// their cost is known, such as after the response has been written. Charging
+// an exhausted quota will cause subsequent requests to be denied until the
+// quota is reset. Quotas are created if they do not exist, or reset if they
+// have expired, so a request that was allowed in a window that has since ended
+// is charged in the new window.
+func (l *Limiter) Charge(resource, action, ip, authToken string, n uint64) error {
+	const op = "rate.(Limiter).Charge"
+
+	if l.stores != nil {
+		return wrapOp(op, ErrNotSupported)
+	}
+
+	policy, err := l.policyFor(resource, action)
+	if err != nil {
+		return wrapOp(op, err)
+	}
+	if policy == nil {
+		return nil
+	}
+	ip, authToken, err = l.normalizeIdentity(ip, authToken)
+	if err != nil {
+		return wrapOp(op, err)
+	}
+	keys := map[LimitPer]string{
+		LimitPerTotal:     string(LimitPerTotal),
+		LimitPerIPAddress: ip,
+		LimitPerAuthToken: authToken,
+	}
+	skip := policy.fallbackSkips(keys)
+
+	for per, id := range keys {
+		if skip[per] {
+			continue
+		}
+		ll, ok := policy.m[per].(*Limited)
+		if !ok {
+			continue
+		}
+		if id == "" && per != LimitPerTotal && ll.EmptyIdentity != EmptyIdentityShared {
+			continue
+		}
+
+		var q *Quota
+		switch {
+		case l.usesPolicyTotal(ll):
+			q = policy.totalQuota(ll, l.clock, l.usageSink)
+		default:
+			q, err = l.quotaFetcher.fetch(context.Background(), id, ll)
+			if err != nil {
+				return wrapOp(op, err)
+			}
+		}
+		q.consumeN(n)
+		if l.wal != nil {
+			l.wal.consume(q, id, n)
+		}
+	}
+	return nil
+}
+
+// Reload replaces the Limiter's limits with the provided limits. The limits
+// must meet the same requirements as the limits provided to NewLimiter. If the
+// largest Period of the limits changes, the Limiter's quota storage is resized
+// to match it, and quotas that would outlive the new largest Period expire
+// once it has elapsed. Existing quotas continue to use
+// the limit they were created with until they expire, except that if a
+// limit's Period is shortened, the current window of its quotas ends when it
+// would have if it had started with the new Period, so that the shorter
+// Period takes effect promptly. Quotas stored by a Store are not changed.
+//
+// If the Limiter was created with WithProfiles, the limits replace the
+// default limits that are used when no Profile is active. While a Profile is
+// active, its limits continue to be used until it ends.
+func (l *Limiter) Reload(limits []Limit) error {
+	const op = "rate.(Limiter).Reload"
+
+	switch {
+	case len(limits) <= 0:
+		return fmt.Errorf("%s: %w", op, ErrEmptyLimits)
+	case allUnlimited(limits):
+		return fmt.Errorf("%s: %w", op, ErrAllUnlimited)
+	}
+
+	l.reloadMu.Lock()
+	defer l.reloadMu.Unlock()
+	if l.profiles != nil && l.profiles.active != "" {
+		// The limits are used once the active profile ends.
+		if _, err := l.newPolicies(limits, l.classes); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		l.profiles.base = append([]Limit(nil), limits...)
+		return nil
+	}
+	if err := l.reload(limits, l.classes); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if l.profiles != nil {
+		l.profiles.base = l.limits
+	}
+	return nil
+}
+
+// ReloadClasses replaces the Limiter's RateClasses with the provided classes,
+// which updates each limit that uses one of the classes. Every class used by
+// the Limiter's limits must be provided. As with Reload, the quota storage is
+// resized if the largest Period changes, and existing quotas continue to use
+// the limit they were created with until they expire, or until their window
+// ends early due to a shortened Period.
+func (l *Limiter) ReloadClasses(classes map[string]RateClass) error {
+	const op = "rate.(Limiter).ReloadClasses"
+
+	c := make(map[string]RateClass, len(classes))
+	for name, class := range classes {
+		if err := class.validate(); err != nil {
+			return fmt.Errorf("%s: rate class %q: %w", op, name, err)
+		}
+		c[name] = class
+	}
+
+	l.reloadMu.Lock()
+	defer l.reloadMu.Unlock()
+	if l.profiles != nil {
+		// The classes must also be usable by the limits that are not in
+		// use, since they are used once a profile starts or ends.
+		if _, err := l.newPolicies(l.profiles.base, c); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		for _, p := range l.profiles.profiles {
+			if _, err := l.newPolicies(p.Limits, c); err != nil {
+				return fmt.Errorf("%s: profile %q: %w", op, p.Name, err)
+			}
+		}
+	}
+	if err := l.reload(l.limits, c); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// reload replaces the Limiter's limits and rate classes.
+//
+// reload should always be called by a function that first acquires a lock.
+func (l *Limiter) reload(limits []Limit, classes map[string]RateClass) error {
+	const op = "rate.(Limiter).reload"
+	if l.reloadMu.TryLock() {
+		panic(fmt.Sprintf("%s: called without lock", op))
+	}
+
+	policies, err := l.newPolicies(limits, classes)
+	if err != nil {
+		return err
+	}
+	policies.inheritTotals(l.policies.Load())
+	limited := policies.limitedByKey()
+	policies.shortenTotals(limited)
+	if len(l.stores) < len(requiredLimitPer) {
+		if err := l.quotaFetcher.shorten(limited); err != nil {
+			return err
+		}
+	}
+	// The quotas are shortened before the store is resized, since resizing
+	// to a shorter max period may end their windows early, after which the
+	// start of their windows is no longer known.
+	if err := l.quotaFetcher.resize(policies.maxPeriod); err != nil {
+		return err
+	}
+
+	l.policies.Store(policies)
+	if l.denials != nil {
+		l.denials.clear()
+	}
+	l.limits = append([]Limit(nil), limits...)
+	l.classes = classes
+	return nil
+}
+
+// newPolicies creates the policies for the limits and rate classes, without
+// using them, so that it can also be used to check limits that will be used
+// later.
+func (l *Limiter) newPolicies(limits []Limit, classes map[string]RateClass) (*limitPolicies, error) {
+	resolved, err := resolveClasses(limits, classes)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err = resolveTemplates(resolved, l.variables)
+	if err != nil {
+		return nil, err
+	}
+	policies, err := newLimitPolicies(resolved, l.strictPolicies)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDefaultPolicy(policies, l.unknownPolicy, l.defaultPolicy); err != nil {
+		return nil, err
+	}
+	if m := l.globalMultiplier.Load(); m != nil {
+		policies.setMultiplier(*m)
+	}
+	return policies, nil
+}
+
+// RekeyQuota moves the quotas for oldID to newID for each limit with the
+// provided LimitPer, so that changing the IP address or auth token that is
+// used to identify a client, such as when a token is rotated, does not result
+// in the client receiving new quotas. If quotas already exist for newID, the
+// requests used by both are combined, and the quota that expires last is
+// kept. LimitPerTotal cannot be rekeyed.
+//
+// The ids are used as is, so they should match the values that are used for
+// quotas by Allow, after any normalization.
+func (l *Limiter) RekeyQuota(per LimitPer, oldID, newID string) error {
+	const op = "rate.(Limiter).RekeyQuota"
+
+	switch {
+	case !per.IsValid(), per == LimitPerTotal:
+		return fmt.Errorf("%s: %w", op, ErrInvalidLimitPer)
+	case oldID == "", newID == "":
+		return fmt.Errorf("%s: %w", op, ErrEmptyIdentity)
+	case oldID == newID:
+		return nil
+	}
+
+	for _, policy := range l.policies.Load().m {
+		ll, ok := policy.m[per].(*Limited)
+		if !ok {
+			continue
+		}
+		if err := l.quotaFetcher.rekey(ll, oldID, newID); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	if l.denials != nil {
+		// The denials for oldID no longer apply, since its quotas
+		// have been moved to newID.
+		l.denials.clear()
+	}
+	return nil
+}
+
+// ExtendQuota delays the reset of the quotas for the IP address or auth token
+// with the provided id by d, for each resource and action, so that an
+// exhausted quota remains exhausted for longer. This can be used to penalize
+// an abusive client without a separate mechanism to ban it. A quota's reset
+// is not delayed beyond the Limiter's max period from now, and quotas that do
+// not exist or have expired are not modified. LimitPerTotal cannot be
+// extended.
+//
+// The id is used as is, so it should match the value that is used for quotas
+// by Allow, after any normalization. An ErrNotSupported is returned if the
+// Limiter uses a Store for the LimitPer.
+func (l *Limiter) ExtendQuota(per LimitPer, id string, d time.Duration) error {
+	const op = "rate.(Limiter).ExtendQuota"
+
+	switch {
+	case l.stores[per] != nil:
+		return fmt.Errorf("%s: %w", op, ErrNotSupported)
+	case !per.IsValid(), per == LimitPerTotal:
+		return fmt.Errorf("%s: %w", op, ErrInvalidLimitPer)
+	case id == "":
+		return fmt.Errorf("%s: %w", op, ErrEmptyIdentity)
+	case d <= 0:
+		return fmt.Errorf("%s: duration must be greater than zero: %w", op, ErrInvalidParameter)
+	}
+
+	// extended are the keys of the quotas that have been extended, since the
+	// limits with the same Pool share a quota.
+	extended := make(map[string]bool)
+	for _, policy := range l.policies.Load().m {
+		ll, ok := policy.m[per].(*Limited)
+		if !ok {
+			continue
+		}
+		key := quotaKey(ll, id)
+		if extended[key] {
+			continue
+		}
+		extended[key] = true
+		if err := l.quotaFetcher.extend(id, ll, d); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if l.denials != nil {
+			// The cached denial has the quota's previous expiration.
+			l.denials.remove(key)
+		}
+	}
+	return nil
+}
+
+// ResetQuota resets the quota for the resource, action, and LimitPer with the
+// provided id, so that it has all of its requests available for a new window,
+// such as when an operator clears the quota of a client that was limited in
+// error. The id is the IP address or auth token for LimitPerIPAddress and
+// LimitPerAuthToken, and is ignored for LimitPerTotal. If the quota does not
+// exist, ResetQuota does nothing.
+//
+// An ErrLimitNotFound is returned if there is no Limited limit for the
+// LimitPer, and an ErrNotSupported is returned if the Limiter uses a Store for
+// the LimitPer.
+func (l *Limiter) ResetQuota(resource, action string, per LimitPer, id string) error {
+	const op = "rate.(Limiter).ResetQuota"
+
+	switch {
+	case l.stores[per] != nil:
+		return fmt.Errorf("%s: %w", op, ErrNotSupported)
+	case !per.IsValid():
+		return fmt.Errorf("%s: %w", op, ErrInvalidLimitPer)
+	}
+	policy, ll, ok := l.policies.Load().limited(resource, action, per)
+	if !ok {
+		return fmt.Errorf("%s: %w", op, ErrLimitNotFound)
+	}
+	if per == LimitPerTotal {
+		id = string(LimitPerTotal)
+	}
+
+	switch {
+	case l.usesPolicyTotal(ll):
+		// The total quota is shared without a lock, so it is replaced
+		// rather than reset.
+		policy.resetTotal(ll, l.clock)
+	default:
+		q := l.quotaFetcher.lookup(id, ll)
+		if q == nil {
+			return nil
+		}
+		q.reset(ll)
+	}
+	if l.denials != nil {
+		l.denials.remove(quotaKey(ll, id))
+	}
+	return nil
+}
+
+// QuotaFor returns the current state of the quota for the resource, action,
+// and LimitPer with the provided id, without consuming from or creating it,
+// such as when support tooling needs to know how many requests a client has
+// left. The id is the IP address or auth token for LimitPerIPAddress and
+// LimitPerAuthToken, and is ignored for LimitPerTotal. As with ExtendQuota,
+// the id is used as is, so it should match the value that is used for quotas
+// by Allow, after any normalization.
+//
+// False is returned if there is no Limited limit for the resource, action,
+// and LimitPer, or if the quota does not exist or has expired. When the
+// Limiter uses a Store, only the quotas stored in the Limiter are returned.
+func (l *Limiter) QuotaFor(resource, action string, per LimitPer, id string) (QuotaSnapshot, bool) {
+	policy, ll, ok := l.policies.Load().limited(resource, action, per)
+	if !ok {
+		return QuotaSnapshot{}, false
+	}
+	if per == LimitPerTotal {
+		id = string(LimitPerTotal)
+	}
+
+	var q *Quota
+	switch {
+	case l.usesPolicyTotal(ll):
+		q = policy.total.Load()
+	default:
+		q = l.quotaFetcher.lookup(id, ll)
+	}
+	if q == nil || q.Expired() {
+		return QuotaSnapshot{}, false
+	}
+	return q.quotaSnapshot(id), true
+}
+
+// Compact removes all of the expired quotas from the Limiter, rather than
+// waiting for them to be removed in the background, and releases memory that
+// was allocated to store quotas that have been removed. This can be used to
+// reclaim memory after a spike in traffic. The quotas are removed a portion at
+// a time, so that requests can continue to be checked while compacting.
+func (l *Limiter) Compact() error {
+	const op = "rate.(Limiter).Compact"
+	if err := l.quotaFetcher.compact(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
 // Shutdown stops a Limiter. After calling this, any future calls to Allow
-// will result in ErrStopped being returned.
+// will result in ErrStopped being returned. If the Limiter was created with
+// WithDurableFile, a final snapshot is written to the file first. Likewise, if
+// the Limiter was created with WithWriteAheadLog, the log is compacted first.
 func (l *Limiter) Shutdown() error {
-	l.mu.RLock()
-	defer l.mu.RUnlock()
-
-	return l.quotaFetcher.shutdown()
+	const op = "rate.(Limiter).Shutdown"
+	l.stopped.Store(true)
+	var errs []error
+	if l.profiles != nil {
+		l.profiles.shutdown()
+	}
+	if l.durable != nil {
+		if err := l.durable.shutdown(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if l.wal != nil {
+		if err := l.wal.shutdown(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, s := range l.stores {
+		if s.fallback != nil {
+			// A Store that is shared by multiple LimitPers is shut
+			// down once for each of them, which is harmless.
+			s.fallback.shutdown()
+		}
+	}
+	if err := l.quotaFetcher.shutdown(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
 }
 
 func allUnlimited(limits []Limit) bool {
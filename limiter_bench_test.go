@@ -5,6 +5,11 @@ package rate
 
 import (
 	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -56,12 +61,8 @@ func BenchmarkAllow(b *testing.B) {
 	}
 
 	// pre-allocate into the sync pool
-	p := make([]any, ss.maxSize)
 	for i := 0; i < ss.maxSize; i++ {
-		p[i] = ss.pool.Get()
-	}
-	for _, e := range p {
-		ss.pool.Put(e)
+		ss.pool.Put(&entry{})
 	}
 
 	b.ResetTimer()
@@ -74,3 +75,338 @@ func BenchmarkAllow(b *testing.B) {
 		}
 	}
 }
+
+// BenchmarkAllowHotKey reports on the throughput of Allow when many
+// goroutines are making requests for the same IP address and auth token.
+func BenchmarkAllowHotKey(b *testing.B) {
+	limits := []Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: math.MaxUint64,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			MaxRequests: math.MaxUint64,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerAuthToken,
+			MaxRequests: math.MaxUint64,
+			Period:      time.Minute,
+		},
+	}
+
+	l, err := NewLimiter(limits, 3)
+	if err != nil {
+		b.Fatalf("unexpected error: %q", err)
+	}
+	defer l.Shutdown()
+
+	// run at least 100 goroutines
+	b.SetParallelism(100)
+	b.ResetTimer()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, _, err := l.Allow("resource", "action", "127.0.0.1", "token"); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// BenchmarkPolicyLookup compares loading the limit policies via an
+// atomic.Pointer with loading them while holding a sync.RWMutex read lock
+// when many goroutines are performing lookups.
+func BenchmarkPolicyLookup(b *testing.B) {
+	policies, err := newLimitPolicies([]Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerAuthToken,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+	}, false)
+	if err != nil {
+		b.Fatalf("unexpected error: %q", err)
+	}
+
+	b.Run("RWMutex", func(b *testing.B) {
+		var mu sync.RWMutex
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				mu.RLock()
+				_, err := policies.get("resource", "action")
+				mu.RUnlock()
+				if err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+	b.Run("AtomicPointer", func(b *testing.B) {
+		var p atomic.Pointer[limitPolicies]
+		p.Store(policies)
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := p.Load().get("resource", "action"); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+}
+
+func BenchmarkUsageHeader(b *testing.B) {
+	l, err := NewLimiter([]Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerAuthToken,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+	}, 3)
+	if err != nil {
+		b.Fatalf("unexpected error: %q", err)
+	}
+	defer l.Shutdown()
+
+	_, q, err := l.Allow("resource", "action", "127.0.0.1", "token")
+	if err != nil {
+		b.Fatalf("unexpected error: %q", err)
+	}
+
+	b.Run("SetUsageHeader", func(b *testing.B) {
+		h := make(http.Header)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			l.SetUsageHeader(q, h)
+		}
+	})
+	b.Run("AppendUsageHeader", func(b *testing.B) {
+		buf := make([]byte, 0, 128)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf = l.AppendUsageHeader(buf[:0], q)
+		}
+	})
+	b.Run("SetDeniedHeaders", func(b *testing.B) {
+		err := newErrRateLimited("resource", "action", q)
+		h := make(http.Header)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			l.SetDeniedHeaders(err, h)
+		}
+	})
+
+	options := []struct {
+		name string
+		opt  Option
+	}{
+		{"ResetEpochSeconds", WithResetFormat(ResetEpochSeconds)},
+		{"ResetIMFFixdate", WithResetFormat(ResetIMFFixdate)},
+		{"UsageDimension", WithUsageDimension(true)},
+		{"LegacyHeaders", WithLegacyHeaders(true)},
+	}
+	for _, o := range options {
+		b.Run("SetUsageHeader/"+o.name, func(b *testing.B) {
+			l, err := NewLimiter(l.limits, 3, o.opt)
+			if err != nil {
+				b.Fatalf("unexpected error: %q", err)
+			}
+			defer l.Shutdown()
+
+			h := make(http.Header)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				l.SetUsageHeader(q, h)
+			}
+		})
+	}
+}
+
+func BenchmarkPolicyHeader(b *testing.B) {
+	l, err := NewLimiter([]Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerAuthToken,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+	}, 3)
+	if err != nil {
+		b.Fatalf("unexpected error: %q", err)
+	}
+	defer l.Shutdown()
+
+	b.Run("SetPolicyHeader", func(b *testing.B) {
+		h := make(http.Header)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := l.SetPolicyHeader("resource", "action", h); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("SetHeaders", func(b *testing.B) {
+		_, q, err := l.Allow("resource", "action", "127.0.0.1", "token")
+		if err != nil {
+			b.Fatal(err)
+		}
+		h := make(http.Header)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := l.SetHeaders("resource", "action", q, h); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("PolicyHeaderValue", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, ok := l.PolicyHeaderValue("resource", "action"); !ok {
+				b.Fatal("policy not found")
+			}
+		}
+	})
+}
+
+// BenchmarkAllowParallel reports on the throughput of Allow when requests are
+// made concurrently, across varying numbers of goroutines, numbers of unique
+// IP addresses and auth tokens, and store sizes. This is used to detect
+// regressions in lock contention within the Limiter and its store.
+//
+// The performance budget for these benchmarks is:
+//   - Allow should make no more than 3 allocations when all of the quotas
+//     already exist.
+//   - ns/op should not increase by more than 10% between releases, as
+//     reported by benchstat when comparing the output of "make bench-parallel".
+//   - ns/op should not increase by more than 4x when increasing the
+//     parallelism from 1 to 64 goroutines per CPU.
+func BenchmarkAllowParallel(b *testing.B) {
+	limits := []Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: math.MaxUint64,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			MaxRequests: math.MaxUint64,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerAuthToken,
+			MaxRequests: math.MaxUint64,
+			Period:      time.Minute,
+		},
+	}
+
+	for _, parallelism := range []int{1, 8, 64} {
+		for _, keys := range []int{1, 1024, 65536} {
+			// ips are valid IP addresses, so that the cost of
+			// canonicalizing them is included.
+			ids, ips := make([]string, keys), make([]string, keys)
+			for i := range ids {
+				ids[i] = strconv.Itoa(i)
+				ips[i] = fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+			}
+			// one quota for the total, and one for each IP address and auth token
+			minSize := 1 + 2*keys
+			for _, storeSize := range []int{minSize, 4 * minSize} {
+				name := fmt.Sprintf("parallelism=%d/keys=%d/size=%d", parallelism, keys, storeSize)
+				b.Run(name, func(b *testing.B) {
+					l, err := NewLimiter(limits, storeSize)
+					if err != nil {
+						b.Fatalf("unexpected error: %q", err)
+					}
+					defer l.Shutdown()
+
+					// create the quotas prior to measuring
+					for i, id := range ids {
+						if _, _, err := l.Allow("resource", "action", ips[i], id); err != nil {
+							b.Fatalf("unexpected error: %q", err)
+						}
+					}
+
+					var next uint64
+					b.SetParallelism(parallelism)
+					b.ResetTimer()
+					b.ReportAllocs()
+					b.RunParallel(func(pb *testing.PB) {
+						for pb.Next() {
+							i := atomic.AddUint64(&next, 1) % uint64(len(ids))
+							if _, _, err := l.Allow("resource", "action", ips[i], ids[i]); err != nil {
+								b.Error(err)
+								return
+							}
+						}
+					})
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// FuzzQuotaKey ensures that different limits and ids never result in the
+// same quota key.
+func FuzzQuotaKey(f *testing.F) {
+	f.Add("resource", "action", "127.0.0.1", "resource", "action", "127.0.0.2")
+	f.Add("a:b", "c", "id", "a", "b:c", "id")
+	f.Add("a", "b", "c:d", "a:b", "c", "d")
+	f.Add("", "", "", ":", ":", ":")
+	f.Add("\xff\xfe", "action", "\x00", "\xff", "\xfeaction", "\x00")
+
+	f.Fuzz(func(t *testing.T, r1, a1, id1, r2, a2, id2 string) {
+		for _, per := range requiredLimitPer {
+			l1 := &Limited{Resource: r1, Action: a1, Per: per}
+			l2 := &Limited{Resource: r2, Action: a2, Per: per}
+			same := r1 == r2 && a1 == a2 && id1 == id2
+			if got := quotaKey(l1, id1) == quotaKey(l2, id2); got != same {
+				t.Fatalf("quotaKey collision: (%q, %q, %q) and (%q, %q, %q)", r1, a1, id1, r2, a2, id2)
+			}
+		}
+	})
+}
+
+// FuzzAllow ensures that Allow does not panic or return an unexpected error
+// when provided with hostile resources, actions, IP addresses, and auth
+// tokens.
+func FuzzAllow(f *testing.F) {
+	f.Add("resource", "action", "127.0.0.1", "token")
+	f.Add("a:b", "c", "::1", "a:b:c")
+	f.Add("\xff\xfe", "\x00", "\xc0", "\xed\xa0\x80")
+	f.Add(strings.Repeat("r", 1<<16), strings.Repeat(":", 1<<10), strings.Repeat("1", 1<<12), strings.Repeat("t", 1<<16))
+
+	f.Fuzz(func(t *testing.T, resource, action, ip, authToken string) {
+		if resource == "" || action == "" {
+			t.Skip()
+		}
+		limits := make([]Limit, 0, len(requiredLimitPer))
+		for _, per := range requiredLimitPer {
+			limits = append(limits, &Limited{
+				Resource:    resource,
+				Action:      action,
+				Per:         per,
+				MaxRequests: 1,
+				Period:      time.Minute,
+			})
+		}
+		l, err := NewLimiter(limits, 3)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer l.Shutdown()
+
+		allowed, q, err := l.Allow(resource, action, ip, authToken)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !allowed || q == nil {
+			t.Fatalf("expected first request to be allowed")
+		}
+
+		allowed, _, err = l.Allow(resource, action, ip, authToken)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if allowed {
+			t.Fatalf("expected second request to be denied")
+		}
+
+		_, _, err = l.Allow(resource+":", action, ip, authToken)
+		if !errors.Is(err, ErrLimitPolicyNotFound) {
+			t.Fatalf("expected ErrLimitPolicyNotFound, got: %v", err)
+		}
+	})
+}
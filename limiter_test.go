@@ -4,7 +4,11 @@
 package rate
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"sync"
 	"testing"
 	"time"
 
@@ -51,8 +55,8 @@ func TestNewLimiter(t *testing.T) {
 			[]Option{},
 			nil,
 			&limitPolicies{
-				m: map[string]*limitPolicy{
-					"resource:action": {
+				m: map[policyKey]*limitPolicy{
+					{"resource", "action"}: {
 						resource: "resource",
 						action:   "action",
 						m: map[LimitPer]Limit{
@@ -78,7 +82,9 @@ func TestNewLimiter(t *testing.T) {
 								Period:      time.Minute,
 							},
 						},
-						policy: `100;w=60;comment="total", 100;w=60;comment="ip-address", 100;w=60;comment="auth-token"`,
+						policy:       `100;w=60;comment="total", 100;w=60;comment="ip-address", 100;w=60;comment="auth-token"`,
+						policyHeader: []string{`100;w=60;comment="total", 100;w=60;comment="ip-address", 100;w=60;comment="auth-token"`},
+						multiplier:   1,
 					},
 				},
 				maxPeriod: time.Minute,
@@ -134,8 +140,8 @@ func TestNewLimiter(t *testing.T) {
 			[]Option{},
 			nil,
 			&limitPolicies{
-				m: map[string]*limitPolicy{
-					"resource1:action": {
+				m: map[policyKey]*limitPolicy{
+					{"resource1", "action"}: {
 						resource: "resource1",
 						action:   "action",
 						m: map[LimitPer]Limit{
@@ -161,9 +167,11 @@ func TestNewLimiter(t *testing.T) {
 								Period:      time.Minute,
 							},
 						},
-						policy: `100;w=60;comment="total", 100;w=60;comment="ip-address", 100;w=60;comment="auth-token"`,
+						policy:       `100;w=60;comment="total", 100;w=60;comment="ip-address", 100;w=60;comment="auth-token"`,
+						policyHeader: []string{`100;w=60;comment="total", 100;w=60;comment="ip-address", 100;w=60;comment="auth-token"`},
+						multiplier:   1,
 					},
-					"resource2:action": {
+					{"resource2", "action"}: {
 						resource: "resource2",
 						action:   "action",
 						m: map[LimitPer]Limit{
@@ -189,14 +197,16 @@ func TestNewLimiter(t *testing.T) {
 								Period:      time.Minute,
 							},
 						},
-						policy: `100;w=60;comment="total", 100;w=60;comment="ip-address", 100;w=60;comment="auth-token"`,
+						policy:       `100;w=60;comment="total", 100;w=60;comment="ip-address", 100;w=60;comment="auth-token"`,
+						policyHeader: []string{`100;w=60;comment="total", 100;w=60;comment="ip-address", 100;w=60;comment="auth-token"`},
+						multiplier:   1,
 					},
 				},
 				maxPeriod: time.Minute,
 			},
 		},
 		{
-			"OneLimit",
+			"OneLimitStrict",
 			10,
 			[]Limit{
 				&Limited{
@@ -207,12 +217,12 @@ func TestNewLimiter(t *testing.T) {
 					Period:      time.Minute,
 				},
 			},
-			[]Option{},
+			[]Option{WithStrictPolicies(true)},
 			ErrInvalidLimitPolicy,
 			nil,
 		},
 		{
-			"MultipleLimits",
+			"MultipleLimitsStrict",
 			10,
 			[]Limit{
 				&Limited{
@@ -230,7 +240,7 @@ func TestNewLimiter(t *testing.T) {
 					Period:      time.Minute,
 				},
 			},
-			[]Option{},
+			[]Option{WithStrictPolicies(true)},
 			ErrInvalidLimitPolicy,
 			nil,
 		},
@@ -377,7 +387,7 @@ func TestNewLimiter(t *testing.T) {
 
 			require.NoError(t, err)
 			require.NotNil(t, l)
-			assert.Equal(t, l.policies, tc.expectPolicies)
+			assert.Equal(t, l.policies.Load(), tc.expectPolicies)
 		})
 	}
 }
@@ -727,7 +737,7 @@ func TestLimiterAllow(t *testing.T) {
 					Period:      time.Minute,
 				},
 			},
-			[]Option{},
+			[]Option{WithPolicyTotalQuotas(false)},
 			[]allowTestRequest{
 				{
 					resource:      "resource1",
@@ -767,7 +777,7 @@ func TestLimiterAllow(t *testing.T) {
 					resource:      "resource3",
 					action:        "action3",
 					expectAllowed: false,
-					expectErr:     &ErrLimiterFull{RetryIn: (time.Minute / time.Duration(DefaultNumberBuckets-1))},
+					expectErr:     &ErrLimiterFull{RetryIn: time.Minute},
 					expectQuota:   nil,
 				},
 				// However, requests for quotas already in the store should
@@ -1005,7 +1015,8 @@ func TestLimiterAllow(t *testing.T) {
 					if want, ok := r.expectErr.(*ErrLimiterFull); ok {
 						got, ok := err.(*ErrLimiterFull)
 						assert.True(t, ok, "did not get an ErrLimiterFull error")
-						assert.Equal(t, want.RetryIn, got.RetryIn)
+						assert.InDelta(t, want.RetryIn, got.RetryIn, float64(time.Second))
+						assert.WithinDuration(t, time.Now().Add(want.RetryIn), got.RetryAt, time.Second)
 					}
 					continue
 				}
@@ -1200,10 +1211,16 @@ func TestSetPolicyHeader(t *testing.T) {
 			err = l.SetPolicyHeader(tc.resource, tc.action, h)
 			if tc.expectErr != nil {
 				require.ErrorIs(t, err, tc.expectErr)
+				require.ErrorIs(t, l.SetHeaders(tc.resource, tc.action, nil, h), tc.expectErr)
 				return
 			}
 			got := h.Get(tc.expectHeader)
 			assert.Equal(t, tc.expectHeaderValue, got)
+
+			// SetHeaders sets the same policy header.
+			h = make(http.Header)
+			require.NoError(t, l.SetHeaders(tc.resource, tc.action, nil, h))
+			assert.Equal(t, tc.expectHeaderValue, h.Get(tc.expectHeader))
 		})
 	}
 }
@@ -1230,7 +1247,7 @@ func TestSetUsageHeader(t *testing.T) {
 					Period:      time.Minute,
 				},
 				used:      10,
-				expiresAt: time.Now().Add(time.Minute),
+				expiresAt: quotaTime(time.Now().Add(time.Minute)),
 			},
 			nil,
 			DefaultUsageHeader,
@@ -1248,12 +1265,102 @@ func TestSetUsageHeader(t *testing.T) {
 					Period:      time.Minute,
 				},
 				used:      10,
-				expiresAt: time.Now().Add(time.Minute),
+				expiresAt: quotaTime(time.Now().Add(time.Minute)),
 			},
 			nil,
 			"Usage-Header",
 			`limit=50, remaining=40, reset=60`,
 		},
+		{
+			"ProjectedExhaustion",
+			[]Option{WithProjectedExhaustion(true)},
+			&Quota{
+				limit: &Limited{
+					Resource:    "resource",
+					Action:      "action",
+					Per:         LimitPerTotal,
+					MaxRequests: 50,
+					Period:      time.Minute,
+				},
+				used:      40,
+				expiresAt: quotaTime(time.Now().Add(30 * time.Second)),
+			},
+			nil,
+			DefaultUsageHeader,
+			`limit=50, remaining=10, reset=30, exhaust=8`,
+		},
+		{
+			"ProjectedExhaustionAfterReset",
+			[]Option{WithProjectedExhaustion(true)},
+			&Quota{
+				limit: &Limited{
+					Resource:    "resource",
+					Action:      "action",
+					Per:         LimitPerTotal,
+					MaxRequests: 50,
+					Period:      time.Minute,
+				},
+				used:      10,
+				expiresAt: quotaTime(time.Now().Add(30 * time.Second)),
+			},
+			nil,
+			DefaultUsageHeader,
+			`limit=50, remaining=40, reset=30`,
+		},
+		{
+			"ResetEpochSeconds",
+			[]Option{WithResetFormat(ResetEpochSeconds)},
+			&Quota{
+				limit: &Limited{
+					Resource:    "resource",
+					Action:      "action",
+					Per:         LimitPerTotal,
+					MaxRequests: 50,
+					Period:      time.Minute,
+				},
+				used:      10,
+				expiresAt: quotaTime(time.Date(2023, 1, 1, 0, 0, 30, int(500*time.Millisecond), time.UTC)),
+			},
+			nil,
+			DefaultUsageHeader,
+			`limit=50, remaining=40, reset=1672531231`,
+		},
+		{
+			"ResetIMFFixdate",
+			[]Option{WithResetFormat(ResetIMFFixdate)},
+			&Quota{
+				limit: &Limited{
+					Resource:    "resource",
+					Action:      "action",
+					Per:         LimitPerTotal,
+					MaxRequests: 50,
+					Period:      time.Minute,
+				},
+				used:      10,
+				expiresAt: quotaTime(time.Date(2023, 1, 1, 0, 0, 30, int(500*time.Millisecond), time.UTC)),
+			},
+			nil,
+			DefaultUsageHeader,
+			`limit=50, remaining=40, reset="Sun, 01 Jan 2023 00:00:31 GMT"`,
+		},
+		{
+			"UsageDimension",
+			[]Option{WithUsageDimension(true)},
+			&Quota{
+				limit: &Limited{
+					Resource:    "resource",
+					Action:      "action",
+					Per:         LimitPerAuthToken,
+					MaxRequests: 50,
+					Period:      time.Minute,
+				},
+				used:      10,
+				expiresAt: quotaTime(time.Now().Add(time.Minute)),
+			},
+			nil,
+			DefaultUsageHeader,
+			`limit=50, remaining=40, reset=60, comment="auth-token"`,
+		},
 		{
 			"NilQuota",
 			[]Option{},
@@ -1433,7 +1540,7 @@ func TestLimiterQuotaUsageMetric(t *testing.T) {
 					},
 				},
 			},
-			3, // one quota per ip, authtoken, and in total
+			2, // one quota per ip and authtoken, since the total is stored with its policy
 		},
 		{
 			"MultipleIPAddress",
@@ -1518,7 +1625,7 @@ func TestLimiterQuotaUsageMetric(t *testing.T) {
 					},
 				},
 			},
-			7, // one per ip (3). one per auth token (3). plus one in total
+			6, // one per ip (3). one per auth token (3)
 		},
 		{
 			"MultipleAuthTokens",
@@ -1583,7 +1690,7 @@ func TestLimiterQuotaUsageMetric(t *testing.T) {
 					},
 				},
 			},
-			4, // one per token (2), plus one IP, and one total
+			3, // one per token (2), plus one IP
 		},
 	}
 
@@ -1612,3 +1719,2199 @@ func TestLimiterQuotaUsageMetric(t *testing.T) {
 		})
 	}
 }
+
+func TestLimiterRiskMultiplier(t *testing.T) {
+	risk := func(per LimitPer, id string) float64 {
+		if per == LimitPerIPAddress && id == "10.0.0.1" {
+			return 0.5
+		}
+		return 1
+	}
+	l, err := NewLimiter(
+		[]Limit{
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 100,
+				Period:      time.Minute,
+			},
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerIPAddress,
+				MaxRequests: 4,
+				Period:      time.Minute,
+			},
+			&Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      LimitPerAuthToken,
+			},
+		},
+		10,
+		WithRiskMultiplier(risk),
+	)
+	require.NoError(t, err)
+
+	cases := []struct {
+		ip          string
+		wantAllowed int
+	}{
+		{"10.0.0.1", 2},
+		{"127.0.0.1", 4},
+	}
+	for _, tc := range cases {
+		t.Run(tc.ip, func(t *testing.T) {
+			var allowed int
+			for i := 0; i < 6; i++ {
+				ok, _, err := l.Allow("resource", "action", tc.ip, "")
+				require.NoError(t, err)
+				if ok {
+					allowed++
+				}
+			}
+			assert.Equal(t, tc.wantAllowed, allowed)
+		})
+	}
+}
+
+func TestLimiterReload(t *testing.T) {
+	limits := func(resource string, period time.Duration) []Limit {
+		return []Limit{
+			&Limited{
+				Resource:    resource,
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 100,
+				Period:      period,
+			},
+			&Limited{
+				Resource:    resource,
+				Action:      "action",
+				Per:         LimitPerIPAddress,
+				MaxRequests: 50,
+				Period:      period,
+			},
+			&Limited{
+				Resource:    resource,
+				Action:      "action",
+				Per:         LimitPerAuthToken,
+				MaxRequests: 25,
+				Period:      period,
+			},
+		}
+	}
+
+	cases := []struct {
+		name      string
+		limits    []Limit
+		expectErr error
+	}{
+		{
+			"Valid",
+			limits("resource2", time.Second),
+			nil,
+		},
+		{
+			"Empty",
+			[]Limit{},
+			ErrEmptyLimits,
+		},
+		{
+			"LongerPeriod",
+			limits("resource2", time.Hour),
+			nil,
+		},
+		{
+			"InvalidPolicy",
+			limits("resource2", time.Minute)[:2],
+			ErrInvalidLimitPolicy,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			l, err := NewLimiter(limits("resource", time.Minute), 10, WithStrictPolicies(true))
+			require.NoError(t, err)
+			defer l.Shutdown()
+
+			err = l.Reload(tc.limits)
+			if tc.expectErr != nil {
+				require.ErrorIs(t, err, tc.expectErr)
+				// the existing limits should still be in use
+				allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "token")
+				require.NoError(t, err)
+				assert.True(t, allowed)
+				return
+			}
+			require.NoError(t, err)
+
+			_, _, err = l.Allow("resource", "action", "127.0.0.1", "token")
+			require.ErrorIs(t, err, ErrLimitPolicyNotFound)
+			allowed, _, err := l.Allow("resource2", "action", "127.0.0.1", "token")
+			require.NoError(t, err)
+			assert.True(t, allowed)
+		})
+	}
+}
+
+func TestLimiterReloadShortenedPeriod(t *testing.T) {
+	limits := func(period time.Duration) []Limit {
+		return []Limit{
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 100,
+				Period:      period,
+			},
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerIPAddress,
+				MaxRequests: 1,
+				Period:      period,
+			},
+			&Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      LimitPerAuthToken,
+			},
+		}
+	}
+
+	c := newFakeClock()
+	start := c.Now()
+	l, err := NewLimiter(limits(time.Hour), 10, WithClock(c))
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "")
+	require.NoError(t, err)
+	require.True(t, allowed)
+	allowed, _, err = l.Allow("resource", "action", "127.0.0.1", "")
+	require.NoError(t, err)
+	require.False(t, allowed)
+
+	c.Advance(time.Minute)
+	require.NoError(t, l.Reload(limits(2*time.Minute)))
+
+	quotas, err := l.ReadOnly().Quotas("resource", "action", "127.0.0.1", "")
+	require.NoError(t, err)
+	assert.Equal(t, start.Add(2*time.Minute), quotas[LimitPerIPAddress].Expiration())
+	assert.Equal(t, start.Add(2*time.Minute), quotas[LimitPerTotal].Expiration())
+
+	// The quota is still used until its shortened window ends.
+	allowed, _, err = l.Allow("resource", "action", "127.0.0.1", "")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	c.Advance(time.Minute + time.Nanosecond)
+	allowed, q, err := l.Allow("resource", "action", "127.0.0.1", "")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, c.Now().Add(2*time.Minute), q.Expiration())
+}
+
+func TestLimiterReloadMaxPeriod(t *testing.T) {
+	limits := func(resource string, period time.Duration) []Limit {
+		return []Limit{
+			&Limited{
+				Resource:    resource,
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 100,
+				Period:      period,
+			},
+			&Limited{
+				Resource:    resource,
+				Action:      "action",
+				Per:         LimitPerIPAddress,
+				MaxRequests: 1,
+				Period:      period,
+			},
+			&Unlimited{
+				Resource: resource,
+				Action:   "action",
+				Per:      LimitPerAuthToken,
+			},
+		}
+	}
+
+	c := newFakeClock()
+	l, err := NewLimiter(limits("resource", time.Minute), 10, WithClock(c), WithNumberBuckets(5))
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	// A longer period resizes the quota storage.
+	require.NoError(t, l.Reload(limits("resource", time.Hour)))
+	assert.Equal(t, time.Hour, l.Config().MaxPeriod)
+	assert.Equal(t, 15*time.Minute, l.Config().BucketTTL)
+
+	allowed, q, err := l.Allow("resource", "action", "127.0.0.1", "")
+	require.NoError(t, err)
+	require.True(t, allowed)
+	assert.Equal(t, c.Now().Add(time.Hour), q.Expiration())
+
+	// A shorter period resizes it again, and quotas that would outlive the
+	// new max period expire once it has elapsed.
+	require.NoError(t, l.Reload(limits("resource2", time.Minute)))
+	assert.Equal(t, time.Minute, l.Config().MaxPeriod)
+	assert.Equal(t, 15*time.Second, l.Config().BucketTTL)
+	assert.Equal(t, c.Now().Add(time.Minute), q.Expiration())
+}
+
+func TestLimiterInvalidResetFormat(t *testing.T) {
+	limits := []Limit{
+		&Limited{Resource: "resource", Action: "action", Per: LimitPerTotal, MaxRequests: 10, Period: time.Minute},
+	}
+	_, err := NewLimiter(limits, 10, WithResetFormat(ResetFormat(10)))
+	assert.ErrorIs(t, err, ErrInvalidParameter)
+}
+
+func TestSetUsageHeaderLegacy(t *testing.T) {
+	limits := []Limit{
+		&Limited{Resource: "resource", Action: "action", Per: LimitPerTotal, MaxRequests: 100, Period: time.Minute},
+	}
+	q := &Quota{
+		limit:     &Limited{Resource: "resource", Action: "action", Per: LimitPerTotal, MaxRequests: 50, Period: time.Minute},
+		used:      10,
+		expiresAt: quotaTime(time.Date(2023, 1, 1, 0, 0, 30, int(500*time.Millisecond), time.UTC)),
+	}
+
+	cases := []struct {
+		name      string
+		opts      []Option
+		wantReset string
+	}{
+		{"epoch", []Option{WithResetFormat(ResetEpochSeconds)}, "1672531231"},
+		{"imf-fixdate", []Option{WithResetFormat(ResetIMFFixdate)}, "Sun, 01 Jan 2023 00:00:31 GMT"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			l, err := NewLimiter(limits, 10, append(tc.opts, WithLegacyHeaders(true))...)
+			require.NoError(t, err)
+			defer l.Shutdown()
+
+			h := make(http.Header)
+			l.SetUsageHeader(q, h)
+			assert.Len(t, h, 4)
+			assert.Equal(t, "50", h.Get("X-RateLimit-Limit"))
+			assert.Equal(t, "40", h.Get("X-RateLimit-Remaining"))
+			assert.Equal(t, tc.wantReset, h.Get("X-RateLimit-Reset"))
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		l, err := NewLimiter(limits, 10)
+		require.NoError(t, err)
+		defer l.Shutdown()
+
+		h := make(http.Header)
+		l.SetUsageHeader(q, h)
+		assert.Len(t, h, 1)
+	})
+}
+
+func TestAppendUsageHeader(t *testing.T) {
+	l, err := NewLimiter(
+		[]Limit{
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 100,
+				Period:      time.Minute,
+			},
+			&Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      LimitPerIPAddress,
+			},
+			&Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      LimitPerAuthToken,
+			},
+		},
+		10,
+	)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	q := &Quota{
+		limit: &Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 50,
+			Period:      time.Minute,
+		},
+		used:      10,
+		expiresAt: quotaTime(time.Now().Add(time.Minute)),
+	}
+
+	buf := []byte("prefix;")
+	buf = l.AppendUsageHeader(buf, q)
+	assert.Equal(t, `prefix;limit=50, remaining=40, reset=60`, string(buf))
+
+	buf = l.AppendUsageHeader(buf[:0], nil)
+	assert.Empty(t, buf)
+}
+
+func TestCeilSeconds(t *testing.T) {
+	cases := []struct {
+		in   time.Duration
+		want int64
+	}{
+		{0, 0},
+		{time.Nanosecond, 1},
+		{time.Second, 1},
+		{time.Second + time.Nanosecond, 2},
+		{-time.Second / 2, 0},
+		{-time.Second * 3 / 2, -1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.in.String(), func(t *testing.T) {
+			assert.Equal(t, tc.want, ceilSeconds(tc.in))
+		})
+	}
+}
+
+func TestPolicyHeaderValue(t *testing.T) {
+	l, err := NewLimiter(
+		[]Limit{
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 100,
+				Period:      time.Minute,
+			},
+			&Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      LimitPerIPAddress,
+			},
+			&Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      LimitPerAuthToken,
+			},
+			&Unlimited{
+				Resource: "unlimited",
+				Action:   "action",
+				Per:      LimitPerTotal,
+			},
+			&Unlimited{
+				Resource: "unlimited",
+				Action:   "action",
+				Per:      LimitPerIPAddress,
+			},
+			&Unlimited{
+				Resource: "unlimited",
+				Action:   "action",
+				Per:      LimitPerAuthToken,
+			},
+		},
+		10,
+	)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	cases := []struct {
+		resource string
+		want     string
+		wantOk   bool
+	}{
+		{"resource", `100;w=60;comment="total"`, true},
+		{"unlimited", "", false},
+		{"missing", "", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.resource, func(t *testing.T) {
+			got, ok := l.PolicyHeaderValue(tc.resource, "action")
+			assert.Equal(t, tc.wantOk, ok)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestLimiterPolicyTotalQuotas(t *testing.T) {
+	limits := func(totalMax uint64) []Limit {
+		return []Limit{
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: totalMax,
+				Period:      time.Minute,
+			},
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerIPAddress,
+				MaxRequests: 100,
+				Period:      time.Minute,
+			},
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerAuthToken,
+				MaxRequests: 100,
+				Period:      time.Minute,
+			},
+		}
+	}
+
+	c := newFakeClock()
+	// The total quota should not need space in the store.
+	l, err := NewLimiter(limits(3), 2, WithClock(c), WithPolicyTotalQuotas(true))
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	for i := 0; i < 3; i++ {
+		allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "token")
+		require.NoError(t, err)
+		assert.True(t, allowed)
+	}
+	allowed, q, err := l.Allow("resource", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, LimitPerTotal, q.limit.Per)
+
+	// Reloading should keep the existing total quota.
+	require.NoError(t, l.Reload(limits(5)))
+	allowed, _, err = l.Allow("resource", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	// Once expired, the quota is reset using the reloaded limit.
+	c.Advance(time.Minute + time.Second)
+	allowed, q, err = l.Allow("resource", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, uint64(5), q.MaxRequests())
+
+	s := l.quotaFetcher.(*expirableStore)
+	s.mu.Lock()
+	assert.Equal(t, 2, s.items.len())
+	s.mu.Unlock()
+}
+
+func TestLimiterPolicyTotalQuotasConcurrent(t *testing.T) {
+	l, err := NewLimiter([]Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 1000,
+			Period:      time.Minute,
+		},
+		&Unlimited{
+			Resource: "resource",
+			Action:   "action",
+			Per:      LimitPerIPAddress,
+		},
+		&Unlimited{
+			Resource: "resource",
+			Action:   "action",
+			Per:      LimitPerAuthToken,
+		},
+	}, 10)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	// The total quota is stored with its policy by default, and each of the
+	// requests that share it is counted.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if _, _, err := l.Allow("resource", "action", "127.0.0.1", "token"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	q, ok := l.QuotaFor("resource", "action", LimitPerTotal, "")
+	require.True(t, ok)
+	assert.Equal(t, uint64(500), q.Remaining)
+	assert.Zero(t, l.quotaFetcher.stats().usage)
+
+	// Resetting the total quota replaces it, rather than modifying the
+	// quota that may be in use by other requests.
+	_, prev, err := l.Allow("resource", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+	require.NoError(t, l.ResetQuota("resource", "action", LimitPerTotal, ""))
+	assert.Equal(t, uint64(499), prev.Remaining())
+	q, ok = l.QuotaFor("resource", "action", LimitPerTotal, "")
+	require.True(t, ok)
+	assert.Equal(t, uint64(1000), q.Remaining)
+
+	// The total quota is not stored in the quotaFetcher, but is not consumed
+	// from once the Limiter is shut down.
+	require.NoError(t, l.Shutdown())
+	allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "token")
+	assert.ErrorIs(t, err, ErrStopped)
+	assert.False(t, allowed)
+}
+
+func TestLimiterEmptyIdentity(t *testing.T) {
+	cases := []struct {
+		name          string
+		emptyIdentity EmptyIdentity
+		expectAllowed []bool
+		expectErr     error
+	}{
+		{
+			"Shared",
+			EmptyIdentityShared,
+			[]bool{true, true, false},
+			nil,
+		},
+		{
+			"Skip",
+			EmptyIdentitySkip,
+			[]bool{true, true, true},
+			nil,
+		},
+		{
+			"Deny",
+			EmptyIdentityDeny,
+			[]bool{false, false, false},
+			ErrEmptyIdentity,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			l, err := NewLimiter(
+				[]Limit{
+					&Limited{
+						Resource:    "resource",
+						Action:      "action",
+						Per:         LimitPerTotal,
+						MaxRequests: 100,
+						Period:      time.Minute,
+					},
+					&Limited{
+						Resource:    "resource",
+						Action:      "action",
+						Per:         LimitPerIPAddress,
+						MaxRequests: 100,
+						Period:      time.Minute,
+					},
+					&Limited{
+						Resource:      "resource",
+						Action:        "action",
+						Per:           LimitPerAuthToken,
+						MaxRequests:   2,
+						Period:        time.Minute,
+						EmptyIdentity: tc.emptyIdentity,
+					},
+				},
+				10,
+			)
+			require.NoError(t, err)
+			defer l.Shutdown()
+
+			for _, want := range tc.expectAllowed {
+				allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "")
+				if tc.expectErr != nil {
+					require.ErrorIs(t, err, tc.expectErr)
+				} else {
+					require.NoError(t, err)
+				}
+				assert.Equal(t, want, allowed)
+			}
+
+			report, err := l.Simulate(nil, []Request{
+				{Resource: "resource", Action: "action", IP: "127.0.0.1"},
+				{Resource: "resource", Action: "action", IP: "127.0.0.1"},
+				{Resource: "resource", Action: "action", IP: "127.0.0.1"},
+			})
+			require.NoError(t, err)
+			var wantAllowed uint64
+			for _, a := range tc.expectAllowed {
+				if a {
+					wantAllowed++
+				}
+			}
+			assert.Equal(t, wantAllowed, report.Allowed)
+		})
+	}
+}
+
+func TestLimiterCanonicalIP(t *testing.T) {
+	cases := []struct {
+		name          string
+		opts          []Option
+		ips           []string
+		expectAllowed []bool
+		expectErr     error
+	}{
+		{
+			"ipv4-mapped",
+			nil,
+			[]string{"1.2.3.4", "::ffff:1.2.3.4", "::FFFF:1.2.3.4"},
+			[]bool{true, true, false},
+			nil,
+		},
+		{
+			"ipv6-representations",
+			nil,
+			[]string{"2001:db8::1", "2001:DB8::1", "2001:0db8:0:0:0:0:0:1", "fe80::1%eth0", "fe80::1"},
+			[]bool{true, true, false, true, true},
+			nil,
+		},
+		{
+			"invalid",
+			nil,
+			[]string{"ip1", "ip1", "ip1"},
+			[]bool{true, true, false},
+			nil,
+		},
+		{
+			"invalid-strict",
+			[]Option{WithStrictIPAddress(true)},
+			[]string{"ip1"},
+			[]bool{false},
+			ErrInvalidIPAddress,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			l, err := NewLimiter(
+				[]Limit{
+					&Limited{
+						Resource:    "resource",
+						Action:      "action",
+						Per:         LimitPerTotal,
+						MaxRequests: 100,
+						Period:      time.Minute,
+					},
+					&Limited{
+						Resource:    "resource",
+						Action:      "action",
+						Per:         LimitPerIPAddress,
+						MaxRequests: 2,
+						Period:      time.Minute,
+					},
+					&Unlimited{
+						Resource: "resource",
+						Action:   "action",
+						Per:      LimitPerAuthToken,
+					},
+				},
+				10,
+				tc.opts...,
+			)
+			require.NoError(t, err)
+			defer l.Shutdown()
+
+			for i, ip := range tc.ips {
+				allowed, _, err := l.Allow("resource", "action", ip, "")
+				if tc.expectErr != nil {
+					require.ErrorIs(t, err, tc.expectErr)
+				} else if tc.expectAllowed[i] {
+					require.NoError(t, err)
+				}
+				assert.Equal(t, tc.expectAllowed[i], allowed, ip)
+			}
+		})
+	}
+}
+
+func TestLimiterAuthTokenNormalizer(t *testing.T) {
+	principals := map[string]string{
+		"old-token": "principal",
+		"new-token": "principal",
+	}
+	l, err := NewLimiter(
+		[]Limit{
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 100,
+				Period:      time.Minute,
+			},
+			&Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      LimitPerIPAddress,
+			},
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerAuthToken,
+				MaxRequests: 2,
+				Period:      time.Minute,
+			},
+		},
+		10,
+		WithAuthTokenNormalizer(func(authToken string) string {
+			return principals[NormalizeBearerToken(authToken)]
+		}),
+	)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	allowed, quota, err := l.Allow("resource", "action", "127.0.0.1", "Bearer old-token")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, uint64(1), quota.Remaining())
+
+	allowed, _, err = l.Allow("resource", "action", "127.0.0.1", "Bearer new-token==")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	allowed, _, err = l.Allow("resource", "action", "127.0.0.1", "new-token")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	report, err := l.Simulate(nil, []Request{
+		{Resource: "resource", Action: "action", IP: "127.0.0.1", AuthToken: "old-token"},
+		{Resource: "resource", Action: "action", IP: "127.0.0.1", AuthToken: "Bearer new-token"},
+		{Resource: "resource", Action: "action", IP: "127.0.0.1", AuthToken: "new-token"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), report.Allowed)
+	assert.Equal(t, uint64(1), report.Denied)
+}
+
+func TestLimiterRekeyQuota(t *testing.T) {
+	newLimiter := func(t *testing.T) *Limiter {
+		t.Helper()
+		l, err := NewLimiter(
+			[]Limit{
+				&Limited{
+					Resource:    "resource",
+					Action:      "action",
+					Per:         LimitPerTotal,
+					MaxRequests: 100,
+					Period:      time.Minute,
+				},
+				&Limited{
+					Resource:    "resource",
+					Action:      "action",
+					Per:         LimitPerIPAddress,
+					MaxRequests: 100,
+					Period:      time.Minute,
+				},
+				&Limited{
+					Resource:    "resource",
+					Action:      "action",
+					Per:         LimitPerAuthToken,
+					MaxRequests: 3,
+					Period:      time.Minute,
+				},
+				&Limited{
+					Resource:    "other",
+					Action:      "action",
+					Per:         LimitPerTotal,
+					MaxRequests: 100,
+					Period:      time.Minute,
+				},
+				&Limited{
+					Resource:    "other",
+					Action:      "action",
+					Per:         LimitPerIPAddress,
+					MaxRequests: 100,
+					Period:      time.Minute,
+				},
+				&Unlimited{
+					Resource: "other",
+					Action:   "action",
+					Per:      LimitPerAuthToken,
+				},
+			},
+			10,
+		)
+		require.NoError(t, err)
+		return l
+	}
+
+	t.Run("rekey", func(t *testing.T) {
+		l := newLimiter(t)
+		defer l.Shutdown()
+
+		for i := 0; i < 2; i++ {
+			allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "old-token")
+			require.NoError(t, err)
+			require.True(t, allowed)
+		}
+		allowed, _, err := l.Allow("other", "action", "127.0.0.1", "old-token")
+		require.NoError(t, err)
+		require.True(t, allowed)
+
+		require.NoError(t, l.RekeyQuota(LimitPerAuthToken, "old-token", "new-token"))
+
+		allowed, quota, err := l.Allow("resource", "action", "127.0.0.1", "new-token")
+		require.NoError(t, err)
+		assert.True(t, allowed)
+		assert.Equal(t, uint64(0), quota.Remaining())
+
+		allowed, _, err = l.Allow("resource", "action", "127.0.0.1", "new-token")
+		require.NoError(t, err)
+		assert.False(t, allowed)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		l := newLimiter(t)
+		defer l.Shutdown()
+
+		require.ErrorIs(t, l.RekeyQuota(LimitPerTotal, "old", "new"), ErrInvalidLimitPer)
+		require.ErrorIs(t, l.RekeyQuota(LimitPer("invalid"), "old", "new"), ErrInvalidLimitPer)
+		require.ErrorIs(t, l.RekeyQuota(LimitPerIPAddress, "", "new"), ErrEmptyIdentity)
+		require.ErrorIs(t, l.RekeyQuota(LimitPerIPAddress, "old", ""), ErrEmptyIdentity)
+		require.NoError(t, l.RekeyQuota(LimitPerIPAddress, "old", "old"))
+	})
+	t.Run("stopped", func(t *testing.T) {
+		l := newLimiter(t)
+		l.Shutdown()
+
+		require.ErrorIs(t, l.RekeyQuota(LimitPerIPAddress, "old", "new"), ErrStopped)
+	})
+}
+
+func TestLimiterExtendQuota(t *testing.T) {
+	newLimiter := func(t *testing.T, c Clock, opt ...Option) *Limiter {
+		t.Helper()
+		l, err := NewLimiter(
+			[]Limit{
+				&Limited{
+					Resource:    "resource",
+					Action:      "action",
+					Per:         LimitPerTotal,
+					MaxRequests: 100,
+					Period:      time.Minute,
+				},
+				&Limited{
+					Resource:    "resource",
+					Action:      "action",
+					Per:         LimitPerIPAddress,
+					MaxRequests: 1,
+					Period:      time.Minute,
+				},
+				&Limited{
+					Resource:    "resource",
+					Action:      "other",
+					Per:         LimitPerIPAddress,
+					MaxRequests: 1,
+					Period:      2 * time.Minute,
+				},
+			},
+			10,
+			append([]Option{WithClock(c)}, opt...)...,
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { l.Shutdown() })
+		return l
+	}
+
+	cases := []struct {
+		name string
+		opts []Option
+	}{
+		{"default", nil},
+		{"denial-cache", []Option{WithDenialCache(time.Second, 10)}},
+		{"max-size-per", []Option{WithMaxSizePer(LimitPerIPAddress, 5)}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newFakeClock()
+			l := newLimiter(t, c, tc.opts...)
+			for i := 0; i < 2; i++ {
+				_, _, err := l.Allow("resource", "action", "127.0.0.1", "")
+				require.NoError(t, err)
+			}
+
+			require.NoError(t, l.ExtendQuota(LimitPerIPAddress, "127.0.0.1", 30*time.Second))
+
+			// The quota remains exhausted after it would have reset.
+			c.Advance(time.Minute + time.Second)
+			allowed, quota, err := l.Allow("resource", "action", "127.0.0.1", "")
+			require.NoError(t, err)
+			assert.False(t, allowed)
+			assert.Equal(t, 29*time.Second, quota.ResetsIn())
+
+			c.Advance(30 * time.Second)
+			allowed, _, err = l.Allow("resource", "action", "127.0.0.1", "")
+			require.NoError(t, err)
+			assert.True(t, allowed)
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		l := newLimiter(t, newFakeClock())
+		assert.ErrorIs(t, l.ExtendQuota(LimitPerTotal, "total", time.Minute), ErrInvalidLimitPer)
+		assert.ErrorIs(t, l.ExtendQuota(LimitPer("invalid"), "id", time.Minute), ErrInvalidLimitPer)
+		assert.ErrorIs(t, l.ExtendQuota(LimitPerIPAddress, "", time.Minute), ErrEmptyIdentity)
+		assert.ErrorIs(t, l.ExtendQuota(LimitPerIPAddress, "id", 0), ErrInvalidParameter)
+		assert.NoError(t, l.ExtendQuota(LimitPerAuthToken, "id", time.Minute))
+	})
+	t.Run("store", func(t *testing.T) {
+		c := newFakeClock()
+		l := newLimiter(t, c, WithStore(newTestStore(c)))
+		assert.ErrorIs(t, l.ExtendQuota(LimitPerIPAddress, "id", time.Minute), ErrNotSupported)
+	})
+	t.Run("stopped", func(t *testing.T) {
+		l := newLimiter(t, newFakeClock())
+		_, _, err := l.Allow("resource", "action", "127.0.0.1", "")
+		require.NoError(t, err)
+		l.Shutdown()
+		assert.ErrorIs(t, l.ExtendQuota(LimitPerIPAddress, "127.0.0.1", time.Minute), ErrStopped)
+	})
+}
+
+func TestLimiterResetQuota(t *testing.T) {
+	newLimiter := func(t *testing.T, opt ...Option) *Limiter {
+		t.Helper()
+		l, err := NewLimiter(
+			[]Limit{
+				&Limited{
+					Resource:    "resource",
+					Action:      "action",
+					Per:         LimitPerTotal,
+					MaxRequests: 3,
+					Period:      time.Minute,
+				},
+				&Limited{
+					Resource:    "resource",
+					Action:      "action",
+					Per:         LimitPerIPAddress,
+					MaxRequests: 2,
+					Period:      time.Minute,
+				},
+				&Unlimited{
+					Resource: "resource",
+					Action:   "action",
+					Per:      LimitPerAuthToken,
+				},
+			},
+			10,
+			opt...,
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { l.Shutdown() })
+		return l
+	}
+
+	cases := []struct {
+		name string
+		opts []Option
+	}{
+		{"default", nil},
+		{"policy-totals", []Option{WithPolicyTotalQuotas(true)}},
+		{"denial-cache", []Option{WithDenialCache(time.Second, 10)}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := newLimiter(t, tc.opts...)
+			for i := 0; i < 2; i++ {
+				allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "")
+				require.NoError(t, err)
+				require.True(t, allowed)
+			}
+			allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "")
+			require.NoError(t, err)
+			require.False(t, allowed)
+
+			require.NoError(t, l.ResetQuota("resource", "action", LimitPerIPAddress, "127.0.0.1"))
+			allowed, quota, err := l.Allow("resource", "action", "127.0.0.1", "")
+			require.NoError(t, err)
+			assert.True(t, allowed)
+			// The total quota was not reset, and has one request remaining.
+			assert.Equal(t, uint64(0), quota.Remaining())
+
+			require.NoError(t, l.ResetQuota("resource", "action", LimitPerTotal, ""))
+			allowed, quota, err = l.Allow("resource", "action", "127.0.0.1", "")
+			require.NoError(t, err)
+			assert.True(t, allowed)
+			assert.Equal(t, uint64(0), quota.Remaining())
+			allowed, _, err = l.Allow("resource", "action", "127.0.0.2", "")
+			require.NoError(t, err)
+			assert.True(t, allowed)
+		})
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		l := newLimiter(t)
+		require.NoError(t, l.ResetQuota("resource", "action", LimitPerIPAddress, "127.0.0.1"))
+	})
+	t.Run("invalid", func(t *testing.T) {
+		l := newLimiter(t)
+		assert.ErrorIs(t, l.ResetQuota("resource", "action", LimitPer("invalid"), "id"), ErrInvalidLimitPer)
+		assert.ErrorIs(t, l.ResetQuota("resource", "action", LimitPerAuthToken, "id"), ErrLimitNotFound)
+		assert.ErrorIs(t, l.ResetQuota("unknown", "action", LimitPerIPAddress, "id"), ErrLimitNotFound)
+	})
+	t.Run("store", func(t *testing.T) {
+		l := newLimiter(t, WithStore(newTestStore(newFakeClock())))
+		assert.ErrorIs(t, l.ResetQuota("resource", "action", LimitPerIPAddress, "id"), ErrNotSupported)
+	})
+}
+
+func TestLimiterQuotaFor(t *testing.T) {
+	c := newFakeClock()
+	l, err := NewLimiter(
+		[]Limit{
+			&Limited{Resource: "resource", Action: "action", Per: LimitPerTotal, MaxRequests: 10, Period: time.Minute},
+			&Limited{Resource: "resource", Action: "action", Per: LimitPerIPAddress, MaxRequests: 2, Period: time.Minute, GraceRequests: 1},
+			&Unlimited{Resource: "resource", Action: "action", Per: LimitPerAuthToken},
+		},
+		10,
+		WithClock(c),
+	)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	_, ok := l.QuotaFor("resource", "action", LimitPerIPAddress, "127.0.0.1")
+	assert.False(t, ok)
+
+	for i := 0; i < 3; i++ {
+		allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "")
+		require.NoError(t, err)
+		require.True(t, allowed)
+	}
+
+	s, ok := l.QuotaFor("resource", "action", LimitPerIPAddress, "127.0.0.1")
+	require.True(t, ok)
+	assert.Equal(t, QuotaSnapshot{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerIPAddress,
+		ID:          "127.0.0.1",
+		MaxRequests: 2,
+		Remaining:   0,
+		GraceUsed:   1,
+		ExpiresAt:   c.Now().Add(time.Minute),
+	}, s)
+
+	// Looking up a quota does not consume from it.
+	s, ok = l.ReadOnly().QuotaFor("resource", "action", LimitPerTotal, "ignored")
+	require.True(t, ok)
+	assert.Equal(t, "total", s.ID)
+	assert.Equal(t, uint64(7), s.Remaining)
+	assert.Equal(t, uint64(0), s.GraceUsed)
+
+	_, ok = l.QuotaFor("resource", "action", LimitPerAuthToken, "token")
+	assert.False(t, ok)
+	_, ok = l.QuotaFor("missing", "action", LimitPerIPAddress, "127.0.0.1")
+	assert.False(t, ok)
+
+	c.Advance(2 * time.Minute)
+	_, ok = l.QuotaFor("resource", "action", LimitPerIPAddress, "127.0.0.1")
+	assert.False(t, ok)
+}
+
+func TestLimiterUsageSink(t *testing.T) {
+	c := newFakeClock()
+	ch := make(chan UsageRecord, 10)
+	l, err := NewLimiter(
+		[]Limit{
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 100,
+				Period:      time.Minute,
+			},
+			&Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      LimitPerIPAddress,
+			},
+			&Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      LimitPerAuthToken,
+			},
+		},
+		10,
+		WithClock(c),
+		WithPolicyTotalQuotas(true),
+		WithUsageSink(ChannelUsageSink(ch)),
+	)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	start := c.Now()
+	for i := 0; i < 3; i++ {
+		allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "token")
+		require.NoError(t, err)
+		require.True(t, allowed)
+	}
+	assert.Empty(t, ch)
+
+	c.Advance(time.Minute + time.Second)
+	allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+	require.True(t, allowed)
+
+	require.Len(t, ch, 1)
+	assert.Equal(t, UsageRecord{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerTotal,
+		ID:          "total",
+		Used:        3,
+		WindowStart: start,
+		WindowEnd:   start.Add(time.Minute),
+	}, <-ch)
+}
+
+func TestLimiterDenialAlerter(t *testing.T) {
+	alerts := make(chan DenialAlert, 10)
+	a, err := NewDenialAlerter(time.Minute, 0.5, 0, func(alert DenialAlert) {
+		alerts <- alert
+	}, WithAlertMinRequests(3))
+	require.NoError(t, err)
+
+	l, err := NewLimiter(
+		[]Limit{
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 1,
+				Period:      time.Minute,
+			},
+			&Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      LimitPerIPAddress,
+			},
+			&Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      LimitPerAuthToken,
+			},
+		},
+		10,
+		WithDenialAlerter(a),
+	)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	_, _, err = l.Allow("missing", "action", "127.0.0.1", "token")
+	require.ErrorIs(t, err, ErrLimitPolicyNotFound)
+
+	for _, want := range []bool{true, false, false} {
+		allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "token")
+		require.NoError(t, err)
+		require.Equal(t, want, allowed)
+	}
+
+	select {
+	case alert := <-alerts:
+		assert.Equal(t, "resource", alert.Resource)
+		assert.InDelta(t, 2.0/3.0, alert.Rate, 0.001)
+	case <-time.After(time.Second):
+		require.FailNow(t, "timed out waiting for alert")
+	}
+
+	a.mu.RLock()
+	assert.NotContains(t, a.policies, limitPolicyKey("missing", "action"))
+	a.mu.RUnlock()
+}
+
+func TestLimiterDenialAlerterWildcard(t *testing.T) {
+	alerts := make(chan DenialAlert, 10)
+	a, err := NewDenialAlerter(time.Minute, 0.5, 0, func(alert DenialAlert) {
+		alerts <- alert
+	}, WithAlertMinRequests(3))
+	require.NoError(t, err)
+
+	l, err := NewLimiter(
+		[]Limit{
+			&Limited{
+				Resource:    Wildcard,
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 1,
+				Period:      time.Minute,
+			},
+			&Unlimited{
+				Resource: Wildcard,
+				Action:   "action",
+				Per:      LimitPerIPAddress,
+			},
+			&Unlimited{
+				Resource: Wildcard,
+				Action:   "action",
+				Per:      LimitPerAuthToken,
+			},
+		},
+		10,
+		WithDenialAlerter(a),
+	)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	// The requests are recorded for the policy that they matched, so that
+	// they are tracked together rather than by each resource.
+	for _, resource := range []string{"resource1", "resource2", "resource3"} {
+		_, _, err := l.Allow(resource, "action", "127.0.0.1", "token")
+		require.NoError(t, err)
+	}
+	select {
+	case alert := <-alerts:
+		assert.Equal(t, Wildcard, alert.Resource)
+		assert.Equal(t, "action", alert.Action)
+		assert.InDelta(t, 2.0/3.0, alert.Rate, 0.001)
+	case <-time.After(time.Second):
+		require.FailNow(t, "timed out waiting for alert")
+	}
+	a.mu.RLock()
+	assert.Len(t, a.policies, 1)
+	a.mu.RUnlock()
+}
+
+func TestLimiterAllowN(t *testing.T) {
+	l, err := NewLimiter(
+		[]Limit{
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 100,
+				Period:      time.Minute,
+			},
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerIPAddress,
+				MaxRequests: 10,
+				Period:      time.Minute,
+			},
+			&Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      LimitPerAuthToken,
+			},
+		},
+		10,
+	)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	cases := []struct {
+		n             uint64
+		wantAllowed   bool
+		wantRemaining uint64
+	}{
+		{4, true, 6},
+		{0, true, 6},
+		{7, false, 6},
+		{6, true, 0},
+		{0, false, 0},
+		{1, false, 0},
+	}
+	for _, tc := range cases {
+		allowed, quota, err := l.AllowN("resource", "action", "127.0.0.1", "token", tc.n)
+		require.NoError(t, err)
+		assert.Equal(t, tc.wantAllowed, allowed, tc.n)
+		assert.Equal(t, tc.wantRemaining, quota.Remaining(), tc.n)
+	}
+}
+
+func TestLimiterSetHeaders(t *testing.T) {
+	l, err := NewLimiter(
+		[]Limit{
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 100,
+				Period:      time.Minute,
+			},
+			&Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      LimitPerIPAddress,
+			},
+			&Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      LimitPerAuthToken,
+			},
+		},
+		10,
+	)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	_, q, err := l.Allow("resource", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+
+	want := make(http.Header)
+	require.NoError(t, l.SetPolicyHeader("resource", "action", want))
+	l.SetUsageHeader(q, want)
+
+	got := make(http.Header)
+	require.NoError(t, l.SetHeaders("resource", "action", q, got))
+	assert.Equal(t, want, got)
+	assert.Len(t, got, 2)
+
+	got = make(http.Header)
+	require.ErrorIs(t, l.SetHeaders("missing", "action", q, got), ErrLimitPolicyNotFound)
+	assert.Empty(t, got)
+}
+
+func TestLimiterSetDeniedHeaders(t *testing.T) {
+	c := newFakeClock()
+	l, err := NewLimiter(
+		[]Limit{
+			&Limited{Resource: "resource", Action: "action", Per: LimitPerTotal, MaxRequests: 100, Period: time.Minute},
+			&Limited{Resource: "resource", Action: "action", Per: LimitPerIPAddress, MaxRequests: 1, Period: time.Minute},
+			&Unlimited{Resource: "resource", Action: "action", Per: LimitPerAuthToken},
+		},
+		10,
+		WithClock(c),
+	)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	t.Run("rate-limited", func(t *testing.T) {
+		_, err := l.AllowErr("resource", "action", "127.0.0.1", "")
+		require.NoError(t, err)
+		c.Advance(10*time.Second + time.Millisecond)
+		_, err = l.AllowErr("resource", "action", "127.0.0.1", "")
+		require.ErrorIs(t, err, ErrDenied)
+
+		h := make(http.Header)
+		l.SetDeniedHeaders(err, h)
+		assert.Len(t, h, 3)
+		assert.Equal(t, "50", h.Get("Retry-After"))
+		assert.Equal(t, `100;w=60;comment="total", 1;w=60;comment="ip-address"`, h.Get(DefaultPolicyHeader))
+		assert.Equal(t, "limit=1, remaining=0, reset=50", h.Get(DefaultUsageHeader))
+	})
+	t.Run("limiter-full", func(t *testing.T) {
+		h := make(http.Header)
+		l.SetDeniedHeaders(fmt.Errorf("wrapped: %w", &ErrLimiterFull{RetryIn: 1500 * time.Millisecond}), h)
+		assert.Equal(t, http.Header{"Retry-After": []string{"2"}}, h)
+
+		h = make(http.Header)
+		l.SetDeniedHeaders(&ErrLimiterFull{}, h)
+		assert.Equal(t, http.Header{"Retry-After": []string{"1"}}, h)
+	})
+	t.Run("other", func(t *testing.T) {
+		for _, err := range []error{nil, ErrLimitPolicyNotFound, ErrEmptyIdentity, &ErrRateLimited{}} {
+			h := make(http.Header)
+			l.SetDeniedHeaders(err, h)
+			assert.Empty(t, h)
+		}
+	})
+}
+
+func TestLimiterClock(t *testing.T) {
+	c := newFakeClock()
+	l, err := NewLimiter([]Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 10,
+			Period:      time.Minute,
+		},
+	}, 10, WithClock(c))
+	require.NoError(t, err)
+	defer l.Shutdown()
+	assert.Same(t, c, l.Clock())
+}
+
+func TestLimiterConfig(t *testing.T) {
+	limits := []Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			MaxRequests: 100,
+			Period:      time.Hour,
+			Jitter:      0.5,
+		},
+		&Unlimited{
+			Resource: "resource",
+			Action:   "action",
+			Per:      LimitPerAuthToken,
+		},
+	}
+
+	t.Run("default", func(t *testing.T) {
+		l, err := NewLimiter(limits, 10)
+		require.NoError(t, err)
+		defer l.Shutdown()
+
+		assert.Equal(t, Config{
+			MaxSize:       10,
+			MaxSizePer:    map[LimitPer]int{},
+			NumberBuckets: DefaultNumberBuckets,
+			BucketTTL:     90 * time.Minute / time.Duration(DefaultNumberBuckets-1),
+			MaxPeriod:     90 * time.Minute,
+			PolicyHeader:  http.CanonicalHeaderKey(DefaultPolicyHeader),
+			UsageHeader:   http.CanonicalHeaderKey(DefaultUsageHeader),
+			GraceHeader:   http.CanonicalHeaderKey(DefaultGraceHeader),
+		}, l.Config())
+	})
+	t.Run("options", func(t *testing.T) {
+		l, err := NewLimiter(limits, 10,
+			WithNumberBuckets(1),
+			WithMaxSizePer(LimitPerTotal, 5),
+			WithPolicyHeader("x-policy"),
+			WithUsageHeader("x-usage"),
+		)
+		require.NoError(t, err)
+		defer l.Shutdown()
+
+		c := l.Config()
+		assert.Equal(t, Config{
+			MaxSize:       10,
+			MaxSizePer:    map[LimitPer]int{LimitPerTotal: 5},
+			NumberBuckets: 1,
+			BucketTTL:     90 * time.Minute,
+			MaxPeriod:     90 * time.Minute,
+			PolicyHeader:  "X-Policy",
+			UsageHeader:   "X-Usage",
+			GraceHeader:   http.CanonicalHeaderKey(DefaultGraceHeader),
+		}, c)
+
+		// Modifying the returned config does not modify the limiter.
+		c.MaxSizePer[LimitPerTotal] = 100
+		assert.Equal(t, 5, l.Config().MaxSizePer[LimitPerTotal])
+	})
+}
+
+func TestLimiterCompact(t *testing.T) {
+	c := newFakeClock()
+	l, err := NewLimiter(
+		[]Limit{
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerIPAddress,
+				MaxRequests: 10,
+				Period:      time.Second,
+			},
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 100,
+				Period:      time.Minute,
+			},
+			&Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      LimitPerAuthToken,
+			},
+		},
+		10,
+		WithClock(c),
+		WithMaxSizePer(LimitPerIPAddress, 10),
+		WithPolicyTotalQuotas(false),
+	)
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		_, _, err := l.Allow("resource", "action", fmt.Sprintf("127.0.0.%d", i), "")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 6, l.quotaFetcher.stats().usage)
+
+	c.Advance(2 * time.Second)
+	require.NoError(t, l.Compact())
+	assert.Equal(t, 1, l.quotaFetcher.stats().usage)
+
+	require.NoError(t, l.Shutdown())
+	assert.ErrorIs(t, l.Compact(), ErrStopped)
+}
+
+func TestLimiterWindowAnchorEpoch(t *testing.T) {
+	c := newFakeClock()
+	c.Advance(90 * time.Second)
+
+	l, err := NewLimiter(
+		[]Limit{
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 10,
+				Period:      time.Minute,
+				Anchor:      WindowAnchorEpoch,
+			},
+			&Unlimited{Resource: "resource", Action: "action", Per: LimitPerIPAddress},
+			&Unlimited{Resource: "resource", Action: "action", Per: LimitPerAuthToken},
+		},
+		10,
+		WithClock(c),
+	)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	// The quota resets at the next minute, rather than a minute after the
+	// first request.
+	_, quota, err := l.Allow("resource", "action", "127.0.0.1", "")
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, quota.ResetsIn())
+	assert.Equal(t, time.Date(2023, 1, 1, 0, 2, 0, 0, time.UTC), quota.Expiration())
+
+	c.Advance(45 * time.Second)
+	_, quota, err = l.Allow("resource", "action", "127.0.0.1", "")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(9), quota.Remaining())
+	assert.Equal(t, 45*time.Second, quota.ResetsIn())
+}
+
+func TestLimiterGraceRequests(t *testing.T) {
+	var graced []GraceRequest
+	l, err := NewLimiter(
+		[]Limit{
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 100,
+				Period:      time.Minute,
+			},
+			&Limited{
+				Resource:      "resource",
+				Action:        "action",
+				Per:           LimitPerIPAddress,
+				MaxRequests:   2,
+				Period:        time.Minute,
+				GraceRequests: 2,
+			},
+			&Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      LimitPerAuthToken,
+			},
+		},
+		10,
+		WithGraceHook(func(r GraceRequest) { graced = append(graced, r) }),
+	)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	cases := []struct {
+		wantAllowed   bool
+		wantRemaining string
+		wantGrace     string
+	}{
+		{true, "remaining=1", ""},
+		{true, "remaining=0", ""},
+		{true, "remaining=0", "1"},
+		{true, "remaining=0", "0"},
+		{false, "remaining=0", "0"},
+	}
+	for i, tc := range cases {
+		allowed, quota, err := l.Allow("resource", "action", "127.0.0.1", "")
+		require.NoError(t, err)
+		assert.Equal(t, tc.wantAllowed, allowed, "request %d", i)
+
+		header := make(http.Header)
+		l.SetUsageHeader(quota, header)
+		assert.Equal(t, tc.wantGrace, header.Get(DefaultGraceHeader), "request %d", i)
+		assert.Contains(t, header.Get(DefaultUsageHeader), tc.wantRemaining)
+	}
+
+	assert.Equal(t, []GraceRequest{
+		{Resource: "resource", Action: "action", Per: LimitPerIPAddress, ID: "127.0.0.1", GraceUsed: 1, GraceRemaining: 1},
+		{Resource: "resource", Action: "action", Per: LimitPerIPAddress, ID: "127.0.0.1", GraceUsed: 2, GraceRemaining: 0},
+	}, graced)
+
+	// AllowN can use the remaining requests and grace requests together.
+	allowed, quota, err := l.AllowN("resource", "action", "127.0.0.2", "", 3)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, uint64(1), quota.GraceUsed())
+	allowed, _, err = l.AllowN("resource", "action", "127.0.0.2", "", 2)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+}
+
+func TestLimiterRefund(t *testing.T) {
+	limits := []Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			MaxRequests: 3,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:      "resource",
+			Action:        "action",
+			Per:           LimitPerAuthToken,
+			MaxRequests:   3,
+			Period:        time.Minute,
+			EmptyIdentity: EmptyIdentitySkip,
+		},
+	}
+
+	for _, policyTotals := range []bool{false, true} {
+		t.Run(fmt.Sprintf("policy-totals-%t", policyTotals), func(t *testing.T) {
+			l, err := NewLimiter(limits, 10, WithPolicyTotalQuotas(policyTotals))
+			require.NoError(t, err)
+			defer l.Shutdown()
+
+			for i := 0; i < 3; i++ {
+				allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "")
+				require.NoError(t, err)
+				require.True(t, allowed)
+			}
+
+			require.NoError(t, l.Refund("resource", "action", "127.0.0.1", "", 2))
+			allowed, quota, err := l.Allow("resource", "action", "127.0.0.1", "")
+			require.NoError(t, err)
+			assert.True(t, allowed)
+			assert.Equal(t, uint64(1), quota.Remaining())
+
+			// A quota cannot be refunded more than it has used.
+			require.NoError(t, l.Refund("resource", "action", "127.0.0.1", "", 10))
+			_, quota, err = l.Allow("resource", "action", "127.0.0.1", "")
+			require.NoError(t, err)
+			assert.Equal(t, uint64(2), quota.Remaining())
+
+			// Refunding an identity without a quota does not create one.
+			usage := l.quotaFetcher.stats().usage
+			require.NoError(t, l.Refund("resource", "action", "127.0.0.2", "token", 1))
+			assert.Equal(t, usage, l.quotaFetcher.stats().usage)
+
+			err = l.Refund("missing", "action", "127.0.0.1", "", 1)
+			assert.ErrorIs(t, err, ErrLimitPolicyNotFound)
+		})
+	}
+}
+
+func TestLimiterCharge(t *testing.T) {
+	limits := []Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			MaxRequests: 5,
+			Period:      time.Minute,
+		},
+		&Unlimited{
+			Resource: "resource",
+			Action:   "action",
+			Per:      LimitPerAuthToken,
+		},
+	}
+
+	for _, policyTotals := range []bool{false, true} {
+		t.Run(fmt.Sprintf("policy-totals-%t", policyTotals), func(t *testing.T) {
+			l, err := NewLimiter(limits, 10, WithPolicyTotalQuotas(policyTotals))
+			require.NoError(t, err)
+			defer l.Shutdown()
+
+			allowed, quota, err := l.Allow("resource", "action", "127.0.0.1", "")
+			require.NoError(t, err)
+			require.True(t, allowed)
+			assert.Equal(t, uint64(4), quota.Remaining())
+
+			require.NoError(t, l.Charge("resource", "action", "127.0.0.1", "", 3))
+			assert.Equal(t, uint64(1), quota.Remaining())
+
+			// Charging does not check the remaining requests, so a quota can
+			// be exhausted by a charge.
+			require.NoError(t, l.Charge("resource", "action", "127.0.0.1", "", 3))
+			assert.Equal(t, uint64(0), quota.Remaining())
+			allowed, _, err = l.Allow("resource", "action", "127.0.0.1", "")
+			require.NoError(t, err)
+			assert.False(t, allowed)
+
+			// Charging an identity without a quota creates one.
+			require.NoError(t, l.Charge("resource", "action", "127.0.0.2", "", 2))
+			_, quota, err = l.Allow("resource", "action", "127.0.0.2", "")
+			require.NoError(t, err)
+			assert.Equal(t, uint64(2), quota.Remaining())
+
+			err = l.Charge("missing", "action", "127.0.0.1", "", 1)
+			assert.ErrorIs(t, err, ErrLimitPolicyNotFound)
+		})
+	}
+}
+
+func TestLimiterFallback(t *testing.T) {
+	limits := []Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 1,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			MaxRequests: 2,
+			Period:      time.Minute,
+			Fallback:    LimitPerTotal,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerAuthToken,
+			MaxRequests: 3,
+			Period:      time.Minute,
+			Fallback:    LimitPerIPAddress,
+		},
+	}
+	l, err := NewLimiter(limits, 10)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	allow := func(ip, authToken string, want uint64) {
+		t.Helper()
+		var allowed uint64
+		for i := 0; i < 5; i++ {
+			ok, _, err := l.Allow("resource", "action", ip, authToken)
+			require.NoError(t, err)
+			if ok {
+				allowed++
+			}
+		}
+		assert.Equal(t, want, allowed)
+	}
+
+	// Authenticated requests are only limited by auth token, so they do not
+	// use the IP address or total quotas.
+	allow("127.0.0.1", "token", 3)
+	// Anonymous requests are only limited by IP address.
+	allow("127.0.0.1", "", 2)
+	allow("127.0.0.2", "", 2)
+	// Requests without an identity are limited by the total.
+	allow("", "", 1)
+
+	report, err := l.Simulate(nil, []Request{
+		{Resource: "resource", Action: "action", IP: "127.0.0.1", AuthToken: "token"},
+		{Resource: "resource", Action: "action", IP: "127.0.0.1", AuthToken: "other"},
+		{Resource: "resource", Action: "action", IP: "127.0.0.1"},
+		{Resource: "resource", Action: "action", IP: "127.0.0.1"},
+		{Resource: "resource", Action: "action", IP: "127.0.0.1"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), report.Allowed)
+	assert.Equal(t, uint64(1), report.Denied)
+}
+
+func TestLimiterPartialPolicy(t *testing.T) {
+	limits := []Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			MaxRequests: 2,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "other",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 1,
+			Period:      time.Minute,
+		},
+	}
+	l, err := NewLimiter(limits, 10)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	// Only the IP address limit applies, so requests are not limited by auth
+	// token or in total.
+	for _, ip := range []string{"127.0.0.1", "127.0.0.2"} {
+		for i, want := range []bool{true, true, false} {
+			allowed, quota, err := l.Allow("resource", "action", ip, fmt.Sprintf("token-%d", i))
+			require.NoError(t, err)
+			assert.Equal(t, want, allowed)
+			require.NotNil(t, quota)
+			assert.Equal(t, uint64(2), quota.MaxRequests())
+		}
+	}
+	require.NoError(t, l.Refund("resource", "action", "127.0.0.1", "", 1))
+	require.NoError(t, l.Charge("resource", "action", "127.0.0.1", "", 1))
+	require.NoError(t, l.RekeyQuota(LimitPerAuthToken, "old", "new"))
+
+	allowed, _, err := l.Allow("other", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	allowed, _, err = l.Allow("other", "action", "127.0.0.2", "")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	header := http.Header{}
+	require.NoError(t, l.SetPolicyHeader("resource", "action", header))
+	assert.Equal(t, `2;w=60;comment="ip-address"`, header.Get(DefaultPolicyHeader))
+}
+
+func TestLimiterAnonymousAuthenticated(t *testing.T) {
+	limits := AnonymousAuthenticatedLimits("resource", "action",
+		Limited{MaxRequests: 1, Period: time.Minute},
+		Limited{MaxRequests: 3, Period: time.Minute},
+	)
+	limits = append(limits, &Limited{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerTotal,
+		MaxRequests: 5,
+		Period:      time.Minute,
+	})
+	l, err := NewLimiter(limits, 10)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	cases := []struct {
+		ip            string
+		authToken     string
+		wantAllowed   bool
+		wantMax       uint64
+		wantRemaining uint64
+	}{
+		{"127.0.0.1", "", true, 1, 0},
+		{"127.0.0.1", "", false, 1, 0},
+		// Authenticated requests from the same IP address are not limited by
+		// the anonymous limit.
+		{"127.0.0.1", "token", true, 3, 2},
+		{"127.0.0.1", "token", true, 3, 1},
+		{"127.0.0.2", "token", true, 3, 0},
+		// The total limit applies to all requests.
+		{"127.0.0.3", "other", true, 5, 0},
+		{"127.0.0.3", "other", false, 5, 0},
+	}
+	for i, tc := range cases {
+		allowed, quota, err := l.Allow("resource", "action", tc.ip, tc.authToken)
+		require.NoError(t, err)
+		assert.Equal(t, tc.wantAllowed, allowed, "request %d", i)
+		assert.Equal(t, tc.wantMax, quota.MaxRequests(), "request %d", i)
+		assert.Equal(t, tc.wantRemaining, quota.Remaining(), "request %d", i)
+	}
+}
+
+func TestLimiterUnknownPolicyBehavior(t *testing.T) {
+	limits := []Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 2,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "default",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			MaxRequests: 1,
+			Period:      time.Minute,
+		},
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewLimiter(limits, 10, WithUnknownPolicyBehavior(UnknownPolicyBehavior(10)))
+		assert.ErrorIs(t, err, ErrInvalidParameter)
+		_, err = NewLimiter(limits, 10, WithUnknownPolicyBehavior(UnknownPolicyUseDefault))
+		assert.ErrorIs(t, err, ErrInvalidParameter)
+		_, err = NewLimiter(limits, 10, WithUnknownPolicyBehavior(UnknownPolicyUseDefault), WithDefaultPolicy("missing", "action"))
+		assert.ErrorIs(t, err, ErrLimitPolicyNotFound)
+	})
+
+	cases := []struct {
+		name        string
+		behavior    UnknownPolicyBehavior
+		wantAllowed []bool
+		wantErr     error
+		wantQuota   bool
+	}{
+		{"deny", UnknownPolicyDeny, []bool{false, false}, ErrLimitPolicyNotFound, false},
+		{"allow", UnknownPolicyAllow, []bool{true, true}, nil, false},
+		{"use-default", UnknownPolicyUseDefault, []bool{true, false}, nil, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			unknown := &testCounter{}
+			l, err := NewLimiter(limits, 10,
+				WithUnknownPolicyBehavior(tc.behavior),
+				WithUnknownPolicyMetric(unknown),
+				WithDefaultPolicy("default", "action"),
+			)
+			require.NoError(t, err)
+			defer l.Shutdown()
+
+			for i, want := range tc.wantAllowed {
+				allowed, quota, err := l.Allow("other", "action", "127.0.0.1", "")
+				assert.Equal(t, want, allowed, "request %d", i)
+				if tc.wantErr != nil {
+					assert.ErrorIs(t, err, tc.wantErr)
+				} else {
+					assert.NoError(t, err)
+				}
+				assert.Equal(t, tc.wantQuota, quota != nil)
+			}
+			assert.Equal(t, float64(len(tc.wantAllowed)), unknown.v)
+
+			// Requests with a policy are not affected.
+			allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "")
+			require.NoError(t, err)
+			assert.True(t, allowed)
+			assert.Equal(t, float64(len(tc.wantAllowed)), unknown.v)
+
+			err = l.Refund("other", "action", "127.0.0.1", "", 1)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			err = l.Charge("other", "action", "127.0.0.1", "", 1)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			report, err := l.Simulate(nil, []Request{
+				{Resource: "other", Action: "action", IP: "127.0.0.1"},
+				{Resource: "other", Action: "action", IP: "127.0.0.1"},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, uint64(2), report.NotFound)
+			var wantAllowed uint64
+			for _, a := range tc.wantAllowed {
+				if a {
+					wantAllowed++
+				}
+			}
+			assert.Equal(t, wantAllowed, report.Allowed)
+		})
+	}
+
+	t.Run("reload", func(t *testing.T) {
+		l, err := NewLimiter(limits, 10,
+			WithUnknownPolicyBehavior(UnknownPolicyUseDefault),
+			WithDefaultPolicy("default", "action"),
+		)
+		require.NoError(t, err)
+		defer l.Shutdown()
+		err = l.Reload(limits[:1])
+		assert.ErrorIs(t, err, ErrLimitPolicyNotFound)
+	})
+}
+
+func TestLimiterPool(t *testing.T) {
+	write := func(resource string, maxRequests uint64) Limit {
+		return &Limited{
+			Resource:    resource,
+			Action:      "write",
+			Per:         LimitPerAuthToken,
+			MaxRequests: maxRequests,
+			Period:      time.Minute,
+			Pool:        "writes",
+		}
+	}
+	limits := []Limit{
+		write("target", 3),
+		write("session", 3),
+		&Limited{
+			Resource:    "target",
+			Action:      "read",
+			Per:         LimitPerAuthToken,
+			MaxRequests: 3,
+			Period:      time.Minute,
+		},
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewLimiter(append(limits[:1:1], write("session", 4)), 10)
+		assert.ErrorIs(t, err, ErrInvalidLimit)
+	})
+
+	for _, policyTotals := range []bool{false, true} {
+		t.Run(fmt.Sprintf("policy-totals-%t", policyTotals), func(t *testing.T) {
+			total := func(resource string) Limit {
+				return &Limited{
+					Resource:    resource,
+					Action:      "write",
+					Per:         LimitPerTotal,
+					MaxRequests: 4,
+					Period:      time.Minute,
+					Pool:        "writes",
+				}
+			}
+			l, err := NewLimiter(append(limits, total("target"), total("session")), 10, WithPolicyTotalQuotas(policyTotals))
+			require.NoError(t, err)
+			defer l.Shutdown()
+
+			cases := []struct {
+				resource    string
+				action      string
+				authToken   string
+				wantAllowed bool
+			}{
+				{"target", "write", "token", true},
+				{"session", "write", "token", true},
+				{"target", "write", "token", true},
+				// The pool for the token is exhausted.
+				{"session", "write", "token", false},
+				// Other actions are not in the pool.
+				{"target", "read", "token", true},
+				{"target", "write", "other", true},
+				// The pool for the total is exhausted.
+				{"session", "write", "another", false},
+			}
+			for i, tc := range cases {
+				allowed, _, err := l.Allow(tc.resource, tc.action, "127.0.0.1", tc.authToken)
+				require.NoError(t, err)
+				assert.Equal(t, tc.wantAllowed, allowed, "request %d", i)
+			}
+		})
+	}
+}
+
+func TestLimiterAllowErr(t *testing.T) {
+	limits := []Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			MaxRequests: 2,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerAuthToken,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+	}
+	c := newFakeClock()
+	l, err := NewLimiter(limits, 10, WithClock(c))
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	q, err := l.AllowErr("resource", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+	require.NotNil(t, q)
+	assert.Equal(t, uint64(1), q.Remaining())
+
+	q, err = l.AllowNErr("resource", "action", "127.0.0.1", "token", 2)
+	var limited *ErrRateLimited
+	require.ErrorAs(t, err, &limited)
+	assert.Equal(t, "resource", limited.Resource)
+	assert.Equal(t, "action", limited.Action)
+	assert.Same(t, q, limited.Quota)
+	assert.Equal(t, time.Minute, limited.RetryAfter())
+	assert.EqualError(t, err, `rate limited: "resource" "action", retry after 1m0s`)
+	assert.ErrorIs(t, err, ErrDenied)
+
+	// RetryAfter does not change as time passes.
+	c.Advance(10 * time.Second)
+	assert.Equal(t, time.Minute, limited.RetryAfter())
+
+	limited.Release()
+	assert.Zero(t, *limited)
+
+	t.Run("retained", func(t *testing.T) {
+		// An error that is kept rather than released is never reused by
+		// later denied requests, including after other errors are
+		// released.
+		_, err := l.AllowNErr("resource", "action", "127.0.0.1", "token", 2)
+		wrapped := fmt.Errorf("wrapped: %w", err)
+		var retained *ErrRateLimited
+		require.ErrorAs(t, wrapped, &retained)
+		want := *retained
+		msg := wrapped.Error()
+		for i := 0; i < 100; i++ {
+			_, err := l.AllowNErr("resource", "action", "127.0.0.1", "token", 2)
+			var limited *ErrRateLimited
+			require.ErrorAs(t, err, &limited)
+			assert.NotSame(t, retained, limited)
+			limited.Release()
+		}
+		assert.Equal(t, want, *retained)
+		assert.Equal(t, msg, wrapped.Error())
+	})
+
+	_, err = l.AllowErr("resource", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+
+	_, err = l.AllowErr("unknown", "action", "127.0.0.1", "token")
+	assert.ErrorIs(t, err, ErrLimitPolicyNotFound)
+	assert.False(t, errors.As(err, &limited))
+}
+
+func TestLimiterAllowContext(t *testing.T) {
+	limits := []Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			MaxRequests: 2,
+			Period:      time.Minute,
+		},
+	}
+	l, err := NewLimiter(limits, 10, WithClock(newFakeClock()))
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	allowed, q, err := l.AllowContext(context.Background(), "resource", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, uint64(1), q.Remaining())
+
+	// A request whose context is done is not allowed, and does not consume
+	// from its quotas.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	allowed, _, err = l.AllowNContext(ctx, "resource", "action", "127.0.0.1", "token", 1)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, allowed)
+
+	allowed, q, err = l.AllowContext(context.Background(), "resource", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, uint64(0), q.Remaining())
+}
+
+func TestErrLimiterFullRetryAfter(t *testing.T) {
+	var err error = &ErrLimiterFull{RetryIn: time.Second}
+	var retry interface{ RetryAfter() time.Duration }
+	require.ErrorAs(t, err, &retry)
+	assert.Equal(t, time.Second, retry.RetryAfter())
+}
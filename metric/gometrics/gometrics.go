@@ -0,0 +1,105 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package gometrics provides implementations of the metric interfaces that
+// publish metrics using github.com/hashicorp/go-metrics, or its predecessor
+// github.com/armon/go-metrics. To avoid a dependency on either module, the
+// adapters accept a Sink, which is satisfied by their *metrics.Metrics and
+// MetricSink types.
+package gometrics
+
+import (
+	"github.com/hashicorp/go-rate"
+	"github.com/hashicorp/go-rate/metric"
+)
+
+// Sink is the subset of the go-metrics API that is used to publish metrics.
+// It is implemented by *metrics.Metrics and metrics.MetricSink.
+type Sink interface {
+	SetGauge(key []string, val float32)
+	IncrCounter(key []string, val float32)
+}
+
+// SinkFuncs implements Sink using functions. It can be used to publish
+// metrics using the go-metrics global functions:
+//
+//	gometrics.SinkFuncs{
+//		SetGaugeFunc:    metrics.SetGauge,
+//		IncrCounterFunc: metrics.IncrCounter,
+//	}
+type SinkFuncs struct {
+	SetGaugeFunc    func(key []string, val float32)
+	IncrCounterFunc func(key []string, val float32)
+}
+
+// SetGauge calls SetGaugeFunc, if it is not nil.
+func (s SinkFuncs) SetGauge(key []string, val float32) {
+	if s.SetGaugeFunc != nil {
+		s.SetGaugeFunc(key, val)
+	}
+}
+
+// IncrCounter calls IncrCounterFunc, if it is not nil.
+func (s SinkFuncs) IncrCounter(key []string, val float32) {
+	if s.IncrCounterFunc != nil {
+		s.IncrCounterFunc(key, val)
+	}
+}
+
+// Gauge returns a metric.Gauge that publishes its value to the sink using
+// the provided key.
+func Gauge(s Sink, key ...string) metric.Gauge {
+	return &gauge{s: s, key: key}
+}
+
+// Counter returns a metric.Counter that publishes its increments to the sink
+// using the provided key.
+func Counter(s Sink, key ...string) metric.Counter {
+	return &counter{s: s, key: key}
+}
+
+// LimiterOptions returns the options for rate.NewLimiter that publish all of
+// the Limiter's metrics to the sink. The key of each metric is the prefix
+// followed by:
+//   - "quota", "storage", "capacity" for WithQuotaStorageCapacityMetric.
+//   - "quota", "storage", "usage" for WithQuotaStorageUsageMetric.
+//   - "quota", "pool", "hit" for WithQuotaPoolHitMetric.
+//   - "quota", "pool", "miss" for WithQuotaPoolMissMetric.
+func LimiterOptions(s Sink, prefix ...string) []rate.Option {
+	key := func(k ...string) []string {
+		return append(prefix[:len(prefix):len(prefix)], k...)
+	}
+	return []rate.Option{
+		rate.WithQuotaStorageCapacityMetric(Gauge(s, key("quota", "storage", "capacity")...)),
+		rate.WithQuotaStorageUsageMetric(Gauge(s, key("quota", "storage", "usage")...)),
+		rate.WithQuotaPoolHitMetric(Counter(s, key("quota", "pool", "hit")...)),
+		rate.WithQuotaPoolMissMetric(Counter(s, key("quota", "pool", "miss")...)),
+	}
+}
+
+type gauge struct {
+	s   Sink
+	key []string
+}
+
+// Set publishes the value of the gauge.
+func (g *gauge) Set(v float64) {
+	g.s.SetGauge(g.key, float32(v))
+}
+
+type counter struct {
+	s   Sink
+	key []string
+}
+
+// Add publishes an increment of the counter.
+func (c *counter) Add(v float64) {
+	c.s.IncrCounter(c.key, float32(v))
+}
+
+// ensure the types implement the expected interfaces
+var (
+	_ metric.Gauge   = (*gauge)(nil)
+	_ metric.Counter = (*counter)(nil)
+	_ Sink           = SinkFuncs{}
+)
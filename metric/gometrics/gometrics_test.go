@@ -0,0 +1,104 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package gometrics
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-rate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSink records the latest gauge values and the counter totals.
+type testSink struct {
+	mu       sync.Mutex
+	gauges   map[string]float32
+	counters map[string]float32
+}
+
+func newTestSink() *testSink {
+	return &testSink{
+		gauges:   make(map[string]float32),
+		counters: make(map[string]float32),
+	}
+}
+
+func (s *testSink) SetGauge(key []string, val float32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gauges[strings.Join(key, ".")] = val
+}
+
+func (s *testSink) IncrCounter(key []string, val float32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters[strings.Join(key, ".")] += val
+}
+
+func TestGaugeCounter(t *testing.T) {
+	s := newTestSink()
+	Gauge(s, "a", "b").Set(1.5)
+	Counter(s, "c").Add(1)
+	Counter(s, "c").Add(2)
+
+	assert.Equal(t, map[string]float32{"a.b": 1.5}, s.gauges)
+	assert.Equal(t, map[string]float32{"c": 3}, s.counters)
+}
+
+func TestSinkFuncs(t *testing.T) {
+	s := newTestSink()
+	f := SinkFuncs{SetGaugeFunc: s.SetGauge, IncrCounterFunc: s.IncrCounter}
+	f.SetGauge([]string{"g"}, 1)
+	f.IncrCounter([]string{"c"}, 2)
+	assert.Equal(t, map[string]float32{"g": 1}, s.gauges)
+	assert.Equal(t, map[string]float32{"c": 2}, s.counters)
+
+	// Missing functions are ignored.
+	SinkFuncs{}.SetGauge([]string{"g"}, 1)
+	SinkFuncs{}.IncrCounter([]string{"c"}, 1)
+}
+
+func TestLimiterOptions(t *testing.T) {
+	s := newTestSink()
+	l, err := rate.NewLimiter(
+		[]rate.Limit{
+			&rate.Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      rate.LimitPerTotal,
+			},
+			&rate.Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         rate.LimitPerIPAddress,
+				MaxRequests: 100,
+				Period:      time.Minute,
+			},
+			&rate.Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      rate.LimitPerAuthToken,
+			},
+		},
+		10,
+		LimiterOptions(s, "service", "ratelimit")...,
+	)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	_, _, err = l.Allow("resource", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	assert.Equal(t, map[string]float32{
+		"service.ratelimit.quota.storage.capacity": 10,
+		"service.ratelimit.quota.storage.usage":    1,
+	}, s.gauges)
+	assert.Equal(t, float32(1), s.counters["service.ratelimit.quota.pool.hit"]+s.counters["service.ratelimit.quota.pool.miss"])
+}
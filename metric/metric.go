@@ -9,3 +9,8 @@ package metric
 type Gauge interface {
 	Set(float64)
 }
+
+// Counter is a metric that can only increase over time.
+type Counter interface {
+	Add(float64)
+}
@@ -0,0 +1,63 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package statsd
+
+// Option provides a way to pass optional arguments.
+type Option func(*options)
+
+func getOpts(opt ...Option) options {
+	opts := getDefaultOptions()
+	for _, o := range opt {
+		o(&opts)
+	}
+	return opts
+}
+
+type options struct {
+	withPrefix     string
+	withTags       []string
+	withDogStatsD  bool
+	withSampleRate float64
+}
+
+func getDefaultOptions() options {
+	return options{
+		withSampleRate: 1,
+	}
+}
+
+// WithPrefix is used to provide a prefix that is added to the name of each
+// metric, such as "myservice.". The prefix is used as is, so it should
+// include any separator.
+func WithPrefix(p string) Option {
+	return func(o *options) {
+		o.withPrefix = p
+	}
+}
+
+// WithDogStatsD is used to send metrics using the DogStatsD format, which
+// supports tags. Tags are ignored unless this option is used.
+func WithDogStatsD(b bool) Option {
+	return func(o *options) {
+		o.withDogStatsD = b
+	}
+}
+
+// WithTags is used to provide tags, in the form "key:value", that are added
+// to every metric. Tags are only sent when using WithDogStatsD.
+func WithTags(tags ...string) Option {
+	return func(o *options) {
+		o.withTags = append(o.withTags, tags...)
+	}
+}
+
+// WithSampleRate is used to provide the rate, between zero and one, at which
+// counter updates are sent. Updates that are sent include the sample rate, so
+// that the server can scale the values accordingly. Gauges are not sampled.
+// The default is 1, which sends every update.
+func WithSampleRate(r float64) Option {
+	return func(o *options) {
+		o.withSampleRate = r
+	}
+}
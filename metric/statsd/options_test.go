@@ -0,0 +1,33 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package statsd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_getOpts(t *testing.T) {
+	t.Parallel()
+	t.Run("default", func(t *testing.T) {
+		assert.Equal(t, options{withSampleRate: 1}, getOpts())
+	})
+	t.Run("WithPrefix", func(t *testing.T) {
+		opts := getOpts(WithPrefix("svc."))
+		assert.Equal(t, "svc.", opts.withPrefix)
+	})
+	t.Run("WithDogStatsD", func(t *testing.T) {
+		opts := getOpts(WithDogStatsD(true))
+		assert.True(t, opts.withDogStatsD)
+	})
+	t.Run("WithTags", func(t *testing.T) {
+		opts := getOpts(WithTags("a:1"), WithTags("b:2", "c:3"))
+		assert.Equal(t, []string{"a:1", "b:2", "c:3"}, opts.withTags)
+	})
+	t.Run("WithSampleRate", func(t *testing.T) {
+		opts := getOpts(WithSampleRate(0.5))
+		assert.Equal(t, 0.5, opts.withSampleRate)
+	})
+}
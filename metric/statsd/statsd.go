@@ -0,0 +1,179 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package statsd provides implementations of the metric interfaces that send
+// metrics to a statsd or DogStatsD server.
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-rate/metric"
+)
+
+// ErrInvalidParameter represents an invalid parameter error.
+var ErrInvalidParameter = errors.New("invalid parameter")
+
+// Client sends metrics to a statsd server. Each metric update is sent as a
+// separate packet.
+type Client struct {
+	w          io.Writer
+	prefix     string
+	tags       []string
+	dogStatsD  bool
+	sampleRate float64
+
+	mu  sync.Mutex
+	buf []byte
+	// rand is used for sampling. It is not safe for concurrent use, so it is
+	// protected by mu.
+	rand *rand.Rand
+}
+
+// New creates a Client that sends metrics over UDP to the statsd server at
+// addr, such as "127.0.0.1:8125".
+//
+// Supported options are:
+//   - WithPrefix: A prefix that is added to the name of each metric.
+//   - WithDogStatsD: Send metrics using the DogStatsD format.
+//   - WithTags: Tags that are added to each metric when using DogStatsD.
+//   - WithSampleRate: The rate at which counter updates are sent.
+func New(addr string, opt ...Option) (*Client, error) {
+	const op = "statsd.New"
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	c, err := NewWithWriter(conn, opt...)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return c, nil
+}
+
+// NewWithWriter creates a Client that writes each metric update to w. It
+// supports the same options as New.
+func NewWithWriter(w io.Writer, opt ...Option) (*Client, error) {
+	const op = "statsd.NewWithWriter"
+
+	opts := getOpts(opt...)
+	switch {
+	case w == nil:
+		return nil, fmt.Errorf("%s: missing writer: %w", op, ErrInvalidParameter)
+	case opts.withSampleRate <= 0 || opts.withSampleRate > 1:
+		return nil, fmt.Errorf("%s: sample rate must be greater than zero and at most one: %w", op, ErrInvalidParameter)
+	}
+
+	return &Client{
+		w:          w,
+		prefix:     opts.withPrefix,
+		tags:       opts.withTags,
+		dogStatsD:  opts.withDogStatsD,
+		sampleRate: opts.withSampleRate,
+		rand:       rand.New(rand.NewSource(rand.Int63())),
+	}, nil
+}
+
+// Close closes the underlying writer if it is an io.Closer.
+func (c *Client) Close() error {
+	if cl, ok := c.w.(io.Closer); ok {
+		return cl.Close()
+	}
+	return nil
+}
+
+// Gauge returns a metric.Gauge that sends its value to the server using the
+// provided name. The tags are added to the Client's tags when using
+// DogStatsD.
+func (c *Client) Gauge(name string, tags ...string) metric.Gauge {
+	return &gauge{c: c, name: name, tags: c.joinTags(tags)}
+}
+
+// Counter returns a metric.Counter that sends its increments to the server
+// using the provided name. The tags are added to the Client's tags when using
+// DogStatsD.
+func (c *Client) Counter(name string, tags ...string) metric.Counter {
+	return &counter{c: c, name: name, tags: c.joinTags(tags)}
+}
+
+// joinTags returns the Client's tags combined with the provided tags, joined
+// as they are sent to the server.
+func (c *Client) joinTags(tags []string) string {
+	if !c.dogStatsD {
+		return ""
+	}
+	all := make([]string, 0, len(c.tags)+len(tags))
+	all = append(all, c.tags...)
+	all = append(all, tags...)
+	return strings.Join(all, ",")
+}
+
+// send writes a single metric update. Errors are ignored, since metrics are
+// sent on a best-effort basis.
+func (c *Client) send(name, typ, tags string, v float64, sampled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if sampled && c.sampleRate < 1 && c.rand.Float64() >= c.sampleRate {
+		return
+	}
+
+	b := c.buf[:0]
+	b = append(b, c.prefix...)
+	b = append(b, name...)
+	b = append(b, ':')
+	b = strconv.AppendFloat(b, v, 'f', -1, 64)
+	b = append(b, '|')
+	b = append(b, typ...)
+	if sampled && c.sampleRate < 1 {
+		b = append(b, "|@"...)
+		b = strconv.AppendFloat(b, c.sampleRate, 'f', -1, 64)
+	}
+	if tags != "" {
+		b = append(b, "|#"...)
+		b = append(b, tags...)
+	}
+	c.buf = b
+	_, _ = c.w.Write(b)
+}
+
+type gauge struct {
+	c    *Client
+	name string
+	tags string
+}
+
+// Set sends the value of the gauge. Since statsd treats a gauge value with a
+// sign as a change to its current value, the gauge is first set to zero when
+// v is negative.
+func (g *gauge) Set(v float64) {
+	if v < 0 {
+		g.c.send(g.name, "g", g.tags, 0, false)
+	}
+	g.c.send(g.name, "g", g.tags, v, false)
+}
+
+type counter struct {
+	c    *Client
+	name string
+	tags string
+}
+
+// Add sends an increment of the counter, subject to the sample rate.
+func (c *counter) Add(v float64) {
+	c.c.send(c.name, "c", c.tags, v, true)
+}
+
+// ensure gauge and counter implement the metric interfaces
+var (
+	_ metric.Gauge   = (*gauge)(nil)
+	_ metric.Counter = (*counter)(nil)
+)
@@ -0,0 +1,140 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package statsd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// packetWriter records each write as a separate packet.
+type packetWriter struct {
+	packets []string
+}
+
+func (w *packetWriter) Write(b []byte) (int, error) {
+	w.packets = append(w.packets, string(b))
+	return len(b), nil
+}
+
+func TestNewWithWriter(t *testing.T) {
+	cases := []struct {
+		name      string
+		opts      []Option
+		expectErr error
+	}{
+		{"default", nil, nil},
+		{"sample-rate", []Option{WithSampleRate(0.5)}, nil},
+		{"sample-rate-zero", []Option{WithSampleRate(0)}, ErrInvalidParameter},
+		{"sample-rate-over-one", []Option{WithSampleRate(1.5)}, ErrInvalidParameter},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := NewWithWriter(&packetWriter{}, tc.opts...)
+			if tc.expectErr != nil {
+				require.ErrorIs(t, err, tc.expectErr)
+				assert.Nil(t, c)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotNil(t, c)
+		})
+	}
+
+	_, err := NewWithWriter(nil)
+	require.ErrorIs(t, err, ErrInvalidParameter)
+}
+
+func TestClient(t *testing.T) {
+	cases := []struct {
+		name string
+		opts []Option
+		want []string
+	}{
+		{
+			"statsd",
+			[]Option{WithPrefix("svc."), WithTags("env:test")},
+			[]string{
+				"svc.capacity:100|g",
+				"svc.usage:0|g",
+				"svc.usage:-1.5|g",
+				"svc.hits:1|c",
+			},
+		},
+		{
+			"dogstatsd",
+			[]Option{WithDogStatsD(true), WithTags("env:test")},
+			[]string{
+				"capacity:100|g|#env:test,store:a",
+				"usage:0|g|#env:test",
+				"usage:-1.5|g|#env:test",
+				"hits:1|c|#env:test",
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := &packetWriter{}
+			c, err := NewWithWriter(w, tc.opts...)
+			require.NoError(t, err)
+
+			c.Gauge("capacity", "store:a").Set(100)
+			c.Gauge("usage").Set(-1.5)
+			c.Counter("hits").Add(1)
+			assert.Equal(t, tc.want, w.packets)
+		})
+	}
+}
+
+func TestClientSampleRate(t *testing.T) {
+	w := &packetWriter{}
+	c, err := NewWithWriter(w, WithSampleRate(0.25))
+	require.NoError(t, err)
+
+	counter := c.Counter("hits")
+	gauge := c.Gauge("usage")
+	for i := 0; i < 1000; i++ {
+		counter.Add(1)
+		gauge.Set(1)
+	}
+
+	var counts, gauges int
+	for _, p := range w.packets {
+		switch p {
+		case "hits:1|c|@0.25":
+			counts++
+		case "usage:1|g":
+			gauges++
+		default:
+			require.FailNow(t, "unexpected packet", p)
+		}
+	}
+	assert.Equal(t, 1000, gauges)
+	assert.InDelta(t, 250, counts, 100)
+}
+
+func TestNew(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	c, err := New(conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer c.Close()
+
+	c.Counter("hits").Add(2)
+
+	buf := make([]byte, 64)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hits:2|c", string(buf[:n]))
+
+	_, err = New("invalid address")
+	require.Error(t, err)
+}
@@ -0,0 +1,86 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+)
+
+// openMetricsContentType is the content type of the OpenMetrics text format.
+const openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// MetricsHandler returns an http.Handler that exposes the Limiter's metrics in
+// the OpenMetrics text format. It can be used in place of the metric options
+// when a Prometheus client library is not available. The following metrics
+// are exposed:
+//   - rate_limiter_quota_storage_capacity: The max number of quotas that can
+//     be stored.
+//   - rate_limiter_quota_storage_usage: The number of quotas that are stored.
+//   - rate_limiter_requests_total: The number of requests checked via Allow,
+//     with a result label of "allowed" or "denied".
+//   - rate_limiter_bucket_entries: The number of quotas in each expiration
+//     bucket, with a bucket label.
+func (l *Limiter) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		var buf bytes.Buffer
+		l.writeOpenMetrics(&buf)
+		w.Header().Set("Content-Type", openMetricsContentType)
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		_, _ = w.Write(buf.Bytes())
+	})
+}
+
+// writeOpenMetrics writes the Limiter's metrics to buf in the OpenMetrics
+// text format.
+func (l *Limiter) writeOpenMetrics(buf *bytes.Buffer) {
+	st := l.quotaFetcher.stats()
+
+	writeMetricFamily(buf, "rate_limiter_quota_storage_capacity", "gauge", "The max number of quotas that can be stored.")
+	writeSample(buf, "rate_limiter_quota_storage_capacity", "", "", uint64(st.capacity))
+
+	writeMetricFamily(buf, "rate_limiter_quota_storage_usage", "gauge", "The number of quotas that are stored.")
+	writeSample(buf, "rate_limiter_quota_storage_usage", "", "", uint64(st.usage))
+
+	writeMetricFamily(buf, "rate_limiter_requests", "counter", "The number of requests checked by the limiter.")
+	writeSample(buf, "rate_limiter_requests_total", "result", "allowed", l.allowed.Load())
+	writeSample(buf, "rate_limiter_requests_total", "result", "denied", l.denied.Load())
+
+	writeMetricFamily(buf, "rate_limiter_bucket_entries", "gauge", "The number of quotas in each expiration bucket.")
+	for i, n := range st.bucketEntries {
+		writeSample(buf, "rate_limiter_bucket_entries", "bucket", strconv.Itoa(i), uint64(n))
+	}
+
+	buf.WriteString("# EOF\n")
+}
+
+// writeMetricFamily writes the TYPE and HELP lines for a metric family.
+func writeMetricFamily(buf *bytes.Buffer, name, typ, help string) {
+	buf.WriteString("# TYPE ")
+	buf.WriteString(name)
+	buf.WriteByte(' ')
+	buf.WriteString(typ)
+	buf.WriteString("\n# HELP ")
+	buf.WriteString(name)
+	buf.WriteByte(' ')
+	buf.WriteString(help)
+	buf.WriteByte('\n')
+}
+
+// writeSample writes a single sample, with an optional label. The label value
+// must not need to be escaped.
+func writeSample(buf *bytes.Buffer, name, label, value string, v uint64) {
+	buf.WriteString(name)
+	if label != "" {
+		buf.WriteByte('{')
+		buf.WriteString(label)
+		buf.WriteString(`="`)
+		buf.WriteString(value)
+		buf.WriteString(`"}`)
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.FormatUint(v, 10))
+	buf.WriteByte('\n')
+}
@@ -0,0 +1,118 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterMetricsHandler(t *testing.T) {
+	l, err := NewLimiter(
+		[]Limit{
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 100,
+				Period:      time.Minute,
+			},
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerIPAddress,
+				MaxRequests: 1,
+				Period:      time.Minute,
+			},
+			&Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      LimitPerAuthToken,
+			},
+		},
+		10,
+		WithNumberBuckets(2),
+	)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	for _, ip := range []string{"127.0.0.1", "127.0.0.1", "127.0.0.2"} {
+		_, _, err := l.Allow("resource", "action", ip, "token")
+		require.NoError(t, err)
+	}
+
+	w := httptest.NewRecorder()
+	l.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/openmetrics-text; version=1.0.0; charset=utf-8", w.Header().Get("Content-Type"))
+
+	want := strings.Join([]string{
+		"# TYPE rate_limiter_quota_storage_capacity gauge",
+		"# HELP rate_limiter_quota_storage_capacity The max number of quotas that can be stored.",
+		"rate_limiter_quota_storage_capacity 10",
+		"# TYPE rate_limiter_quota_storage_usage gauge",
+		"# HELP rate_limiter_quota_storage_usage The number of quotas that are stored.",
+		"rate_limiter_quota_storage_usage 2",
+		"# TYPE rate_limiter_requests counter",
+		"# HELP rate_limiter_requests The number of requests checked by the limiter.",
+		`rate_limiter_requests_total{result="allowed"} 2`,
+		`rate_limiter_requests_total{result="denied"} 1`,
+		"# TYPE rate_limiter_bucket_entries gauge",
+		"# HELP rate_limiter_bucket_entries The number of quotas in each expiration bucket.",
+		`rate_limiter_bucket_entries{bucket="0"} 0`,
+		`rate_limiter_bucket_entries{bucket="1"} 2`,
+		"# EOF",
+		"",
+	}, "\n")
+	assert.Equal(t, want, w.Body.String())
+}
+
+func TestLimiterMetricsHandlerPerStore(t *testing.T) {
+	l, err := NewLimiter(
+		[]Limit{
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 100,
+				Period:      time.Minute,
+			},
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerIPAddress,
+				MaxRequests: 100,
+				Period:      time.Minute,
+			},
+			&Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      LimitPerAuthToken,
+			},
+		},
+		10,
+		WithMaxSizePer(LimitPerTotal, 5),
+		WithPolicyTotalQuotas(false),
+	)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	_, _, err = l.Allow("resource", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+
+	st := l.quotaFetcher.stats()
+	assert.Equal(t, 15, st.capacity)
+	assert.Equal(t, 2, st.usage)
+	var entries int
+	for _, n := range st.bucketEntries {
+		entries += n
+	}
+	assert.Equal(t, 2, entries)
+}
@@ -0,0 +1,82 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"fmt"
+	"math"
+)
+
+// GlobalMultiplierHook is called with the previous and new multiplier each
+// time the global multiplier of a Limiter is changed via SetGlobalMultiplier.
+// It is called synchronously by SetGlobalMultiplier, after the new multiplier
+// is in use.
+type GlobalMultiplierHook func(previous, current float64)
+
+// SetGlobalMultiplier sets a multiplier that is applied to the MaxRequests of
+// every limit, such as 0.5 to halve the number of requests that are allowed
+// during an incident, or 1 to restore the limits. It is applied to existing
+// quotas the next time they are checked by Allow, in addition to any
+// RiskMultiplier, and to the policy headers. As with reloading the limits,
+// any cached denials are removed. The multiplier must not be negative. As
+// with a RiskMultiplier, it is not applied to the quotas in a Store.
+func (l *Limiter) SetGlobalMultiplier(f float64) error {
+	const op = "rate.(Limiter).SetGlobalMultiplier"
+
+	if f < 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Errorf("%s: multiplier must be a non-negative number: %w", op, ErrInvalidParameter)
+	}
+
+	l.reloadMu.Lock()
+	previous := l.GlobalMultiplier()
+	prev := l.globalMultiplier.Swap(&f)
+	if err := l.reload(l.limits, l.classes); err != nil {
+		l.globalMultiplier.Store(prev)
+		l.reloadMu.Unlock()
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	l.reloadMu.Unlock()
+
+	if l.globalMultiplierHook != nil {
+		l.globalMultiplierHook(previous, f)
+	}
+	return nil
+}
+
+// GlobalMultiplier returns the multiplier set via SetGlobalMultiplier, which
+// is 1 if it has not been set.
+func (l *Limiter) GlobalMultiplier() float64 {
+	if m := l.globalMultiplier.Load(); m != nil {
+		return *m
+	}
+	return 1
+}
+
+// quotaMultiplier returns the multiplier applied to the MaxRequests of the
+// quota for the LimitPer and id, which is the product of the RiskMultiplier
+// and the global multiplier. The returned bool is false if neither is used,
+// in which case the quota's MaxRequests is not adjusted.
+func (l *Limiter) quotaMultiplier(per LimitPer, id string) (float64, bool) {
+	g := l.globalMultiplier.Load()
+	switch {
+	case l.riskMultiplier == nil && g == nil:
+		return 0, false
+	case l.riskMultiplier == nil:
+		return *g, true
+	case g == nil:
+		return l.risk(per, id), true
+	}
+	return l.risk(per, id) * *g, true
+}
+
+// risk returns the multiplier from the RiskMultiplier for the LimitPer and
+// id. A multiplier that is not a positive, finite number is replaced by 1, so
+// that it cannot result in an undefined MaxRequests.
+func (l *Limiter) risk(per LimitPer, id string) float64 {
+	m := l.riskMultiplier(per, id)
+	if m <= 0 || math.IsNaN(m) || math.IsInf(m, 0) {
+		return 1
+	}
+	return m
+}
@@ -0,0 +1,159 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterSetGlobalMultiplier(t *testing.T) {
+	limits := []Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			MaxRequests: 10,
+			Period:      time.Minute,
+		},
+		&Unlimited{
+			Resource: "resource",
+			Action:   "action",
+			Per:      LimitPerAuthToken,
+		},
+	}
+
+	type change struct{ previous, current float64 }
+	var changes []change
+	l, err := NewLimiter(limits, 10,
+		WithDenialCache(time.Second, 10),
+		WithGlobalMultiplierHook(func(previous, current float64) {
+			changes = append(changes, change{previous, current})
+		}),
+	)
+	require.NoError(t, err)
+	defer l.Shutdown()
+	assert.Equal(t, float64(1), l.GlobalMultiplier())
+
+	for i := 0; i < 5; i++ {
+		allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "")
+		require.NoError(t, err)
+		require.True(t, allowed)
+	}
+
+	// Halving the limits applies to the existing quota, which has used all
+	// of its requests.
+	require.NoError(t, l.SetGlobalMultiplier(0.5))
+	assert.Equal(t, 0.5, l.GlobalMultiplier())
+	allowed, q, err := l.Allow("resource", "action", "127.0.0.1", "")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, uint64(5), q.MaxRequests())
+
+	h := http.Header{}
+	require.NoError(t, l.SetPolicyHeader("resource", "action", h))
+	assert.Equal(t, `50;w=60;comment="total", 5;w=60;comment="ip-address"`, h.Get(DefaultPolicyHeader))
+
+	// Restoring the limits removes the cached denial.
+	require.NoError(t, l.SetGlobalMultiplier(1))
+	allowed, q, err = l.Allow("resource", "action", "127.0.0.1", "")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, uint64(4), q.Remaining())
+	h = http.Header{}
+	require.NoError(t, l.SetPolicyHeader("resource", "action", h))
+	assert.Equal(t, `100;w=60;comment="total", 10;w=60;comment="ip-address"`, h.Get(DefaultPolicyHeader))
+
+	// The multiplier is kept when the limits are reloaded.
+	require.NoError(t, l.SetGlobalMultiplier(2))
+	require.NoError(t, l.Reload(limits))
+	v, ok := l.PolicyHeaderValue("resource", "action")
+	require.True(t, ok)
+	assert.Equal(t, `200;w=60;comment="total", 20;w=60;comment="ip-address"`, v)
+
+	assert.Equal(t, []change{{1, 0.5}, {0.5, 1}, {1, 2}}, changes)
+
+	require.ErrorIs(t, l.SetGlobalMultiplier(-1), ErrInvalidParameter)
+	assert.Equal(t, float64(2), l.GlobalMultiplier())
+}
+
+func TestLimiterQuotaMultiplier(t *testing.T) {
+	limits := []Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+	}
+
+	l, err := NewLimiter(limits, 10)
+	require.NoError(t, err)
+	defer l.Shutdown()
+	_, ok := l.quotaMultiplier(LimitPerTotal, "total")
+	assert.False(t, ok)
+	require.NoError(t, l.SetGlobalMultiplier(0.5))
+	m, ok := l.quotaMultiplier(LimitPerTotal, "total")
+	assert.True(t, ok)
+	assert.Equal(t, 0.5, m)
+
+	l, err = NewLimiter(limits, 10, WithRiskMultiplier(func(LimitPer, string) float64 { return 0.5 }))
+	require.NoError(t, err)
+	defer l.Shutdown()
+	m, ok = l.quotaMultiplier(LimitPerTotal, "total")
+	assert.True(t, ok)
+	assert.Equal(t, 0.5, m)
+	require.NoError(t, l.SetGlobalMultiplier(0.5))
+	m, ok = l.quotaMultiplier(LimitPerTotal, "total")
+	assert.True(t, ok)
+	assert.Equal(t, 0.25, m)
+}
+
+func TestLimiterRiskMultiplierInvalid(t *testing.T) {
+	limits := []Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			MaxRequests: 2,
+			Period:      time.Minute,
+		},
+	}
+
+	for _, m := range []float64{math.NaN(), math.Inf(1), math.Inf(-1), 0, -2} {
+		t.Run(fmt.Sprint(m), func(t *testing.T) {
+			l, err := NewLimiter(limits, 10, WithRiskMultiplier(func(LimitPer, string) float64 { return m }))
+			require.NoError(t, err)
+			defer l.Shutdown()
+
+			got, ok := l.quotaMultiplier(LimitPerIPAddress, "127.0.0.1")
+			assert.True(t, ok)
+			assert.Equal(t, float64(1), got)
+
+			allowed, q, err := l.Allow("resource", "action", "127.0.0.1", "token")
+			require.NoError(t, err)
+			assert.True(t, allowed)
+			assert.Equal(t, uint64(2), q.MaxRequests())
+			assert.Equal(t, uint64(1), q.Remaining())
+		})
+	}
+
+	assert.Equal(t, uint64(10), scaleMaxRequests(10, math.NaN()))
+	assert.Equal(t, uint64(math.MaxUint64), scaleMaxRequests(10, math.Inf(1)))
+	assert.Equal(t, uint64(0), scaleMaxRequests(10, math.Inf(-1)))
+}
@@ -3,24 +3,73 @@
 
 package rate
 
-import "net/http"
+import (
+	"context"
+	"net/http"
+)
 
 type nopLimiter struct{}
 
 // SetPolicyHeader is a noop.
 func (*nopLimiter) SetPolicyHeader(_, _ string, _ http.Header) error { return nil }
 
+// SetHeaders is a noop.
+func (*nopLimiter) SetHeaders(_, _ string, _ *Quota, _ http.Header) error { return nil }
+
 // SetUsageHeader is a noop.
 func (*nopLimiter) SetUsageHeader(_ *Quota, _ http.Header) { return }
 
+// SetDeniedHeaders is a noop.
+func (*nopLimiter) SetDeniedHeaders(_ error, _ http.Header) { return }
+
+// AppendUsageHeader is a noop, and returns dst unmodified.
+func (*nopLimiter) AppendUsageHeader(dst []byte, _ *Quota) []byte { return dst }
+
 // Allow will always allow.
 func (*nopLimiter) Allow(_, _, _, _ string) (bool, *Quota, error) {
 	return true, nil, nil
 }
 
+// AllowN will always allow.
+func (*nopLimiter) AllowN(_, _, _, _ string, _ uint64) (bool, *Quota, error) {
+	return true, nil, nil
+}
+
+// AllowContext will always allow.
+func (*nopLimiter) AllowContext(_ context.Context, _, _, _, _ string) (bool, *Quota, error) {
+	return true, nil, nil
+}
+
+// AllowNContext will always allow.
+func (*nopLimiter) AllowNContext(_ context.Context, _, _, _, _ string, _ uint64) (bool, *Quota, error) {
+	return true, nil, nil
+}
+
+// AllowErr will always allow.
+func (*nopLimiter) AllowErr(_, _, _, _ string) (*Quota, error) {
+	return nil, nil
+}
+
+// AllowNErr will always allow.
+func (*nopLimiter) AllowNErr(_, _, _, _ string, _ uint64) (*Quota, error) {
+	return nil, nil
+}
+
+// Throttle will always allow without waiting.
+func (*nopLimiter) Throttle(_ context.Context, _, _, _ string) error { return nil }
+
+// Refund is a noop.
+func (*nopLimiter) Refund(_, _, _, _ string, _ uint64) error { return nil }
+
+// Charge is a noop.
+func (*nopLimiter) Charge(_, _, _, _ string, _ uint64) error { return nil }
+
 // Shutdown is a noop.
 func (*nopLimiter) Shutdown() error { return nil }
 
+// Clock returns a Clock that uses the system time.
+func (*nopLimiter) Clock() Clock { return realClock{} }
+
 // NopLimiter can be used in the place of a Limiter when no limits need to be
 // enforced, but a Limiter is expected.
 var NopLimiter *nopLimiter
@@ -28,8 +77,20 @@ var NopLimiter *nopLimiter
 type limiter interface {
 	SetPolicyHeader(string, string, http.Header) error
 	SetUsageHeader(*Quota, http.Header)
+	SetHeaders(string, string, *Quota, http.Header) error
+	SetDeniedHeaders(error, http.Header)
+	AppendUsageHeader([]byte, *Quota) []byte
 	Allow(string, string, string, string) (bool, *Quota, error)
+	AllowN(string, string, string, string, uint64) (bool, *Quota, error)
+	AllowContext(context.Context, string, string, string, string) (bool, *Quota, error)
+	AllowNContext(context.Context, string, string, string, string, uint64) (bool, *Quota, error)
+	AllowErr(string, string, string, string) (*Quota, error)
+	AllowNErr(string, string, string, string, uint64) (*Quota, error)
+	Throttle(context.Context, string, string, string) error
+	Refund(string, string, string, string, uint64) error
+	Charge(string, string, string, string, uint64) error
 	Shutdown() error
+	Clock() Clock
 }
 
 // Ensure that both NopLimiter and Limiter match the same interface.
@@ -4,8 +4,10 @@
 package rate_test
 
 import (
+	"context"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/go-rate"
 	"github.com/stretchr/testify/assert"
@@ -42,6 +44,21 @@ func TestUnlimiterAllow(t *testing.T) {
 			require.NoError(t, err)
 			assert.Nil(t, q)
 			assert.True(t, a)
+
+			a, q, err = rate.NopLimiter.AllowN(tc.res, tc.action, tc.ip, tc.authtoken, 10)
+			require.NoError(t, err)
+			assert.Nil(t, q)
+			assert.True(t, a)
+
+			a, q, err = rate.NopLimiter.AllowContext(context.Background(), tc.res, tc.action, tc.ip, tc.authtoken)
+			require.NoError(t, err)
+			assert.Nil(t, q)
+			assert.True(t, a)
+
+			a, q, err = rate.NopLimiter.AllowNContext(context.Background(), tc.res, tc.action, tc.ip, tc.authtoken, 10)
+			require.NoError(t, err)
+			assert.Nil(t, q)
+			assert.True(t, a)
 		})
 	}
 }
@@ -98,7 +115,52 @@ func TestUnlimitedSetUsageHeader(t *testing.T) {
 	}
 }
 
+func TestUnlimitedAppendUsageHeader(t *testing.T) {
+	buf := []byte("prefix")
+	got := rate.NopLimiter.AppendUsageHeader(buf, &rate.Quota{})
+	assert.Equal(t, "prefix", string(got))
+}
+
 func TestUnlimitedShutdown(t *testing.T) {
 	err := rate.NopLimiter.Shutdown()
 	assert.NoError(t, err)
 }
+
+func TestUnlimitedSetHeaders(t *testing.T) {
+	h := make(http.Header)
+	require.NoError(t, rate.NopLimiter.SetHeaders("res", "action", nil, h))
+	assert.Empty(t, h)
+}
+
+func TestNopLimiterRefund(t *testing.T) {
+	assert.NoError(t, rate.NopLimiter.Refund("resource", "action", "127.0.0.1", "", 1))
+}
+
+func TestNopLimiterCharge(t *testing.T) {
+	assert.NoError(t, rate.NopLimiter.Charge("resource", "action", "127.0.0.1", "", 1))
+}
+
+func TestNopLimiterAllowErr(t *testing.T) {
+	q, err := rate.NopLimiter.AllowErr("resource", "action", "127.0.0.1", "")
+	assert.NoError(t, err)
+	assert.Nil(t, q)
+
+	q, err = rate.NopLimiter.AllowNErr("resource", "action", "127.0.0.1", "", 10)
+	assert.NoError(t, err)
+	assert.Nil(t, q)
+}
+
+func TestNopLimiterThrottle(t *testing.T) {
+	assert.NoError(t, rate.NopLimiter.Throttle(context.Background(), "resource", "action", "tenant"))
+}
+
+func TestNopLimiterSetDeniedHeaders(t *testing.T) {
+	h := make(http.Header)
+	rate.NopLimiter.SetDeniedHeaders(&rate.ErrLimiterFull{}, h)
+	assert.Empty(t, h)
+}
+
+func TestNopLimiterClock(t *testing.T) {
+	now := rate.NopLimiter.Clock().Now()
+	assert.WithinDuration(t, time.Now(), now, time.Second)
+}
@@ -3,7 +3,11 @@
 
 package rate
 
-import "github.com/hashicorp/go-rate/metric"
+import (
+	"time"
+
+	"github.com/hashicorp/go-rate/metric"
+)
 
 const (
 	// DefaultNumberBuckets is the default number of buckets created for the quota store.
@@ -14,6 +18,23 @@ const (
 
 	// DefaultUsageHeader is the default HTTP header for reporting quota usage.
 	DefaultUsageHeader = "RateLimit"
+
+	// DefaultGraceHeader is the default HTTP header for reporting that a
+	// request was allowed using grace requests.
+	DefaultGraceHeader = "RateLimit-Grace"
+
+	// DefaultCleanupBatchSize is the default maximum number of expired quotas
+	// that are deleted at a time while holding the quota store's lock.
+	DefaultCleanupBatchSize = 1024
+
+	// DefaultAlertMinRequests is the default minimum number of requests for
+	// a resource and action during the interval of a DenialAlerter for its
+	// denial rate to raise an alert.
+	DefaultAlertMinRequests = 10
+
+	// DefaultAlertMaxPolicies is the default maximum number of resources and
+	// actions that a DenialAlerter tracks.
+	DefaultAlertMaxPolicies = 1024
 )
 
 // nilGauge is a gauge that does nothing.
@@ -21,6 +42,75 @@ type nilGauge struct{}
 
 func (n *nilGauge) Set(_ float64) {}
 
+// nilCounter is a counter that does nothing.
+type nilCounter struct{}
+
+func (n *nilCounter) Add(_ float64) {}
+
+// RiskMultiplier returns a multiplier that is applied to the MaxRequests of
+// the limit for the given LimitPer and identity. The identity is the IP
+// address or auth token for LimitPerIPAddress and LimitPerAuthToken, and
+// "total" for LimitPerTotal. A multiplier less than one reduces the number of
+// requests that are allowed, while a multiplier greater than one increases it.
+// A multiplier that is not a positive, finite number is ignored, and 1 is used
+// instead.
+type RiskMultiplier func(per LimitPer, id string) float64
+
+// ResetFormat determines how the reset parameter of the rate limit usage
+// header reports when a quota resets.
+type ResetFormat int
+
+const (
+	// ResetDeltaSeconds reports the number of seconds until the quota
+	// resets, such as reset=30. This is the default.
+	ResetDeltaSeconds ResetFormat = iota
+	// ResetEpochSeconds reports the Unix time in seconds at which the quota
+	// resets, such as reset=1672531230, for clients that expect an absolute
+	// reset time.
+	ResetEpochSeconds
+	// ResetIMFFixdate reports the time at which the quota resets as a quoted
+	// IMF-fixdate, such as reset="Sun, 01 Jan 2023 00:00:30 GMT", as used by
+	// earlier versions of the rate limit headers draft.
+	ResetIMFFixdate
+)
+
+// IsValid checks if the given ResetFormat is valid.
+func (f ResetFormat) IsValid() bool {
+	switch f {
+	case ResetDeltaSeconds, ResetEpochSeconds, ResetIMFFixdate:
+		return true
+	}
+	return false
+}
+
+// Preallocation determines how much memory the Limiter allocates up front to
+// store quotas, rather than as quotas are added.
+type Preallocation int
+
+const (
+	// PreallocateItems allocates enough memory to index maxSize quotas when
+	// the Limiter is created, while the quotas in each bucket are indexed
+	// using memory that is allocated as needed. This is the default.
+	PreallocateItems Preallocation = iota
+	// PreallocateNone allocates the memory used to index quotas as needed,
+	// which reduces the memory used by a Limiter with a large maxSize that
+	// stores few quotas, at the cost of growing the index as quotas are added.
+	PreallocateNone
+	// PreallocateBuckets is like PreallocateItems, but also allocates enough
+	// memory in each bucket to index maxSize divided by the number of buckets
+	// quotas, for quotas that are spread evenly across the buckets.
+	PreallocateBuckets
+)
+
+// IsValid checks if the given Preallocation is valid.
+func (p Preallocation) IsValid() bool {
+	switch p {
+	case PreallocateItems, PreallocateNone, PreallocateBuckets:
+		return true
+	}
+	return false
+}
+
 // Option provides a way to pass optional arguments.
 type Option func(*options)
 
@@ -36,8 +126,63 @@ type options struct {
 	withNumberBuckets              int
 	withPolicyHeader               string
 	withUsageHeader                string
+	withGraceHeader                string
+	withGraceHook                  GraceHook
 	withQuotaStorageCapacityMetric metric.Gauge
 	withQuotaStorageUsageMetric    metric.Gauge
+	withProjectedExhaustion        bool
+	withResetFormat                ResetFormat
+	withUsageDimension             bool
+	withLegacyHeaders              bool
+	withEntrySlabSize              int
+	withPreallocation              Preallocation
+	withWarmUpFraction             float64
+	withWarmUpWindows              int
+	withRiskMultiplier             RiskMultiplier
+	withClock                      Clock
+	withCleanupBatchSize           int
+	withQuotaPoolHitMetric         metric.Counter
+	withQuotaPoolMissMetric        metric.Counter
+	withMaxSizePer                 map[LimitPer]int
+	withPolicyTotalQuotas          bool
+	withStrictIPAddress            bool
+	withStrictPolicies             bool
+	withAuthTokenNormalizer        AuthTokenNormalizer
+	withUsageSink                  UsageSink
+	withDenialAlerter              *DenialAlerter
+	withAlertMinRequests           uint64
+	withAlertMaxPolicies           int
+	withStoreEventHook             StoreEventHook
+	withGaugePublishInterval       time.Duration
+	withDurableFile                string
+	withDurableFileInterval        time.Duration
+	withDurableFileErrorHook       DurableFileErrorHook
+	withWriteAheadLog              string
+	withWriteAheadLogMinPeriod     time.Duration
+	withWriteAheadLogCompact       time.Duration
+	withMaxClockSkew               time.Duration
+	withUnknownPolicyBehavior      UnknownPolicyBehavior
+	withUnknownPolicyMetric        metric.Counter
+	withDefaultPolicy              *policyKey
+	withRateClasses                map[string]RateClass
+	withTemplateVariables          map[string]float64
+	withDenialCacheMinResetsIn     time.Duration
+	withDenialCacheMaxSize         int
+	withStore                      Store
+	withStorePer                   map[LimitPer]Store
+	withStoreFailureMode           StoreFailureMode
+	withStoreBreaker               *StoreBreaker
+	withStoreBreakerStateMetric    metric.Gauge
+	withStoreBreakerOpenMetric     metric.Counter
+	withStoreFallbackDropMetric    metric.Counter
+	withTraceHook                  TraceHook
+	withTraceSampling              uint64
+	withRollouts                   map[policyKey]Rollout
+	withUnenforcedDenialHook       UnenforcedDenialHook
+	withCohorts                    map[LimitPer]int
+	withGlobalMultiplierHook       GlobalMultiplierHook
+	withProfiles                   []Profile
+	withProfileHook                ProfileHook
 }
 
 func getDefaultOptions() options {
@@ -45,8 +190,20 @@ func getDefaultOptions() options {
 		withNumberBuckets:              DefaultNumberBuckets,
 		withPolicyHeader:               DefaultPolicyHeader,
 		withUsageHeader:                DefaultUsageHeader,
+		withGraceHeader:                DefaultGraceHeader,
 		withQuotaStorageCapacityMetric: &nilGauge{},
 		withQuotaStorageUsageMetric:    &nilGauge{},
+		withClock:                      realClock{},
+		withCleanupBatchSize:           DefaultCleanupBatchSize,
+		withQuotaPoolHitMetric:         &nilCounter{},
+		withQuotaPoolMissMetric:        &nilCounter{},
+		withUnknownPolicyMetric:        &nilCounter{},
+		withStoreBreakerStateMetric:    &nilGauge{},
+		withStoreBreakerOpenMetric:     &nilCounter{},
+		withStoreFallbackDropMetric:    &nilCounter{},
+		withPolicyTotalQuotas:          true,
+		withAlertMinRequests:           DefaultAlertMinRequests,
+		withAlertMaxPolicies:           DefaultAlertMaxPolicies,
 	}
 }
 
@@ -73,6 +230,41 @@ func WithUsageHeader(h string) Option {
 	}
 }
 
+// WithGraceHeader is used to set the header key used by the Limiter for
+// reporting that a request was allowed using grace requests.
+func WithGraceHeader(h string) Option {
+	return func(o *options) {
+		o.withGraceHeader = h
+	}
+}
+
+// WithGraceHook is used to provide a function that is called each time a
+// request is allowed using the GraceRequests of a limit.
+func WithGraceHook(fn GraceHook) Option {
+	return func(o *options) {
+		o.withGraceHook = fn
+	}
+}
+
+// WithTraceHook is used to provide a function that is called with a
+// TraceEvent describing how each request was evaluated by Allow, including
+// the remaining requests of each quota before and after the request. Since
+// tracing adds overhead to each request, it is intended for debugging, and
+// can be limited to a sample of requests using WithTraceSampling.
+func WithTraceHook(fn TraceHook) Option {
+	return func(o *options) {
+		o.withTraceHook = fn
+	}
+}
+
+// WithTraceSampling is used to only trace one in every n requests when using
+// WithTraceHook. By default, each request is traced.
+func WithTraceSampling(n uint64) Option {
+	return func(o *options) {
+		o.withTraceSampling = n
+	}
+}
+
 // WithQuotaStorageCapacityMetric is used to provide a metric that will record
 // the total capacity available to the Limiter for storing Quotas.
 func WithQuotaStorageCapacityMetric(g metric.Gauge) Option {
@@ -98,3 +290,528 @@ func WithQuotaStorageUsageMetric(g metric.Gauge) Option {
 		}
 	}
 }
+
+// WithProjectedExhaustion is used to include the number of seconds until the
+// quota is projected to be exhausted in the usage header.
+func WithProjectedExhaustion(b bool) Option {
+	return func(o *options) {
+		o.withProjectedExhaustion = b
+	}
+}
+
+// WithResetFormat is used to set how the reset parameter of the usage header
+// reports when a quota resets. By default, ResetDeltaSeconds is used.
+func WithResetFormat(f ResetFormat) Option {
+	return func(o *options) {
+		o.withResetFormat = f
+	}
+}
+
+// WithUsageDimension is used to include the LimitPer of the quota in the
+// usage header as a comment, such as comment="auth-token", so that clients
+// can tell which dimension produced the reported usage.
+func WithUsageDimension(b bool) Option {
+	return func(o *options) {
+		o.withUsageDimension = b
+	}
+}
+
+// WithLegacyHeaders is used to also set the de facto X-RateLimit-Limit,
+// X-RateLimit-Remaining, and X-RateLimit-Reset headers alongside the usage
+// header, for clients that do not understand the draft headers. The
+// X-RateLimit-Reset header uses the ResetFormat provided by WithResetFormat,
+// without quoting an IMF-fixdate.
+func WithLegacyHeaders(b bool) Option {
+	return func(o *options) {
+		o.withLegacyHeaders = b
+	}
+}
+
+// WithEntrySlabSize is used to allocate the entries that the Limiter uses to
+// store quotas n at a time, and to reuse the entries of removed quotas via a
+// free list rather than a sync.Pool. This reduces the number of objects that
+// the garbage collector must scan for a Limiter with a large max size, at the
+// cost of never releasing the memory used by the entries. By default, each
+// entry is allocated individually.
+func WithEntrySlabSize(n int) Option {
+	return func(o *options) {
+		o.withEntrySlabSize = n
+	}
+}
+
+// WithPreallocation is used to set how much memory the Limiter allocates up
+// front to store quotas. By default, PreallocateItems is used.
+func WithPreallocation(p Preallocation) Option {
+	return func(o *options) {
+		o.withPreallocation = p
+	}
+}
+
+// WithWarmUp is used to limit the number of requests that can be made by a
+// new IP address or auth token. The first quota for an identity will allow
+// the given fraction of the limit's MaxRequests, increasing linearly over the
+// given number of windows until the full MaxRequests is allowed. An identity
+// is no longer considered new once it has made requests in the given number
+// of consecutive windows.
+func WithWarmUp(fraction float64, windows int) Option {
+	return func(o *options) {
+		o.withWarmUpFraction = fraction
+		o.withWarmUpWindows = windows
+	}
+}
+
+// WithRiskMultiplier is used to provide a function that can adjust the
+// effective MaxRequests for an identity each time a request is checked via
+// Allow.
+func WithRiskMultiplier(fn RiskMultiplier) Option {
+	return func(o *options) {
+		o.withRiskMultiplier = fn
+	}
+}
+
+// WithClock is used to provide the Clock used by the Limiter to determine
+// when quotas expire. This defaults to using the system time.
+func WithClock(c Clock) Option {
+	return func(o *options) {
+		switch {
+		case c == nil:
+			o.withClock = realClock{}
+		default:
+			o.withClock = c
+		}
+	}
+}
+
+// WithCleanupBatchSize is used to set the maximum number of expired quotas
+// that are deleted at a time while holding the store's lock. Between batches
+// the lock is released and the go routine yields to allow other requests to
+// proceed. This must be greater than zero, and defaults to
+// DefaultCleanupBatchSize.
+func WithCleanupBatchSize(n int) Option {
+	return func(o *options) {
+		o.withCleanupBatchSize = n
+	}
+}
+
+// WithQuotaPoolHitMetric is used to provide a metric that will record the
+// number of times that storing a new Quota was able to reuse memory from a
+// previously removed Quota.
+func WithQuotaPoolHitMetric(c metric.Counter) Option {
+	return func(o *options) {
+		switch {
+		case c == nil:
+			o.withQuotaPoolHitMetric = &nilCounter{}
+		default:
+			o.withQuotaPoolHitMetric = c
+		}
+	}
+}
+
+// WithQuotaPoolMissMetric is used to provide a metric that will record the
+// number of times that storing a new Quota required allocating new memory.
+func WithQuotaPoolMissMetric(c metric.Counter) Option {
+	return func(o *options) {
+		switch {
+		case c == nil:
+			o.withQuotaPoolMissMetric = &nilCounter{}
+		default:
+			o.withQuotaPoolMissMetric = c
+		}
+	}
+}
+
+// WithMaxSizePer is used to store the quotas for the given LimitPer separately
+// from the quotas for other LimitPers, with its own max size.
+func WithMaxSizePer(per LimitPer, maxSize int) Option {
+	return func(o *options) {
+		if o.withMaxSizePer == nil {
+			o.withMaxSizePer = make(map[LimitPer]int, len(requiredLimitPer))
+		}
+		o.withMaxSizePer[per] = maxSize
+	}
+}
+
+// WithPolicyTotalQuotas is used to set whether the quota for each
+// LimitPerTotal limit without a Pool is stored with its limit policy, rather
+// than with the quotas for IP addresses and auth tokens. Since there is only
+// one such quota per policy, this avoids looking it up in the store for every
+// request, and its counters are updated atomically without a lock. These
+// quotas do not count towards the Limiter's max size. The default is true.
+func WithPolicyTotalQuotas(b bool) Option {
+	return func(o *options) {
+		o.withPolicyTotalQuotas = b
+	}
+}
+
+// WithStrictIPAddress is used to reject requests with an IP address that is
+// not valid. By default, IP addresses that are not valid are used as is.
+func WithStrictIPAddress(b bool) Option {
+	return func(o *options) {
+		o.withStrictIPAddress = b
+	}
+}
+
+// WithStrictPolicies is used to require that the limits for each resource
+// and action include a limit for each LimitPer. By default, a resource and
+// action can have limits for only some LimitPers, in which case requests are
+// not limited for the others.
+func WithStrictPolicies(b bool) Option {
+	return func(o *options) {
+		o.withStrictPolicies = b
+	}
+}
+
+// WithAuthTokenNormalizer is used to provide a function that normalizes auth
+// tokens before they are used to key quotas. See NormalizeBearerToken for a
+// normalizer that removes the Bearer prefix and padding.
+func WithAuthTokenNormalizer(fn AuthTokenNormalizer) Option {
+	return func(o *options) {
+		o.withAuthTokenNormalizer = fn
+	}
+}
+
+// WithUsageSink is used to provide a UsageSink that receives a UsageRecord
+// each time a quota's window ends. Windows that have not ended when the
+// Limiter is shutdown are not exported.
+func WithUsageSink(sink UsageSink) Option {
+	return func(o *options) {
+		o.withUsageSink = sink
+	}
+}
+
+// WithDenialAlerter is used to provide a DenialAlerter that records whether
+// each request checked via Allow was allowed.
+func WithDenialAlerter(a *DenialAlerter) Option {
+	return func(o *options) {
+		o.withDenialAlerter = a
+	}
+}
+
+// WithAlertMinRequests is used to set the minimum number of requests for a
+// resource and action during the interval of a DenialAlerter for its denial
+// rate to raise an alert, so that a few denied requests for a rarely used
+// resource and action do not raise an alert.
+func WithAlertMinRequests(n uint64) Option {
+	return func(o *options) {
+		o.withAlertMinRequests = n
+	}
+}
+
+// WithAlertMaxPolicies is used to set the maximum number of resources and
+// actions that a DenialAlerter tracks. Requests for other resources and
+// actions are not recorded once it is tracking this many.
+func WithAlertMaxPolicies(n int) Option {
+	return func(o *options) {
+		o.withAlertMaxPolicies = n
+	}
+}
+
+// WithStoreEventHook is used to provide a function that is called when the
+// internal state of the store used to hold quotas changes, such as when it
+// becomes full. This can be used to correlate changes in memory usage or
+// latency with the limiter.
+func WithStoreEventHook(fn StoreEventHook) Option {
+	return func(o *options) {
+		o.withStoreEventHook = fn
+	}
+}
+
+// WithGaugePublishInterval is used to publish the quota storage capacity and
+// usage metrics periodically from a dedicated go routine, rather than setting
+// the usage metric each time it changes while checking a request. This keeps
+// the latency of the metric sink out of the request path. By default, the
+// metrics are not published periodically.
+func WithGaugePublishInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.withGaugePublishInterval = d
+	}
+}
+
+// WithDurableFile is used to persist quotas to the file at path, so that they
+// survive the process restarting. This is intended for limits with long
+// periods, such as daily or monthly quotas, on a single node. If the file
+// exists when the Limiter is created, its quotas are restored. A snapshot of
+// the quotas is then written to the file every interval, and when the Limiter
+// is shutdown. The interval must be greater than zero.
+//
+// Since only snapshots are written, the requests consumed since the last
+// snapshot are lost if the process exits without the Limiter being shutdown,
+// such as when it crashes, so up to one interval of requests may be allowed
+// again once it restarts. To not lose any requests for limits with long
+// periods, use WithWriteAheadLog instead, which records each request. Errors
+// writing the periodic snapshots are returned by Shutdown only for the final
+// snapshot; use WithDurableFileErrorHook to be notified of the others.
+func WithDurableFile(path string, interval time.Duration) Option {
+	return func(o *options) {
+		o.withDurableFile = path
+		o.withDurableFileInterval = interval
+	}
+}
+
+// WithDurableFileErrorHook is used to provide a function that is called each
+// time a periodic snapshot could not be written to the file provided via
+// WithDurableFile, so that failures such as a full disk or an invalid path
+// can be detected before the Limiter is shutdown.
+func WithDurableFileErrorHook(fn DurableFileErrorHook) Option {
+	return func(o *options) {
+		o.withDurableFileErrorHook = fn
+	}
+}
+
+// WithWriteAheadLog is used to persist quotas for limits with a Period of at
+// least minPeriod to an append-only log at path. A record is appended to the
+// log each time requests are consumed from such a quota, so unlike
+// WithDurableFile, requests are not lost if the process exits between
+// snapshots. If the log exists when the Limiter is created, it is replayed to
+// restore the quotas. The log is compacted every compactInterval, and when
+// the Limiter is shutdown, by replacing it with the current state of each
+// quota. The compactInterval must be greater than zero.
+func WithWriteAheadLog(path string, minPeriod, compactInterval time.Duration) Option {
+	return func(o *options) {
+		o.withWriteAheadLog = path
+		o.withWriteAheadLogMinPeriod = minPeriod
+		o.withWriteAheadLogCompact = compactInterval
+	}
+}
+
+// WithMaxClockSkew is used to tolerate differences between the clock of the
+// Limiter and the clock of another node when restoring a snapshot that was
+// created by that node. A snapshot whose CreatedAt is after the current time
+// must have been created by a node with a clock that is ahead, so the
+// expiration of each of its quotas is moved back by the difference. This
+// prevents quotas from being extended by a clock that is ahead. If the
+// difference is larger than d, Restore returns ErrClockSkew. A snapshot
+// created by a node with a clock that is behind cannot be distinguished from
+// an older snapshot, so it is restored as is. By default, snapshots are
+// restored as is.
+func WithMaxClockSkew(d time.Duration) Option {
+	return func(o *options) {
+		o.withMaxClockSkew = d
+	}
+}
+
+// WithUnknownPolicyBehavior is used to set how requests for a resource and
+// action without a limit policy are handled. By default, UnknownPolicyDeny is
+// used.
+func WithUnknownPolicyBehavior(b UnknownPolicyBehavior) Option {
+	return func(o *options) {
+		o.withUnknownPolicyBehavior = b
+	}
+}
+
+// WithUnknownPolicyMetric is used to provide a metric that will record the
+// number of requests for a resource and action without a limit policy.
+func WithUnknownPolicyMetric(c metric.Counter) Option {
+	return func(o *options) {
+		switch {
+		case c == nil:
+			o.withUnknownPolicyMetric = &nilCounter{}
+		default:
+			o.withUnknownPolicyMetric = c
+		}
+	}
+}
+
+// WithDefaultPolicy is used to provide the resource and action of the limit
+// policy that is used for requests for a resource and action without a limit
+// policy when using UnknownPolicyUseDefault.
+func WithDefaultPolicy(resource, action string) Option {
+	return func(o *options) {
+		o.withDefaultPolicy = &policyKey{resource: resource, action: action}
+	}
+}
+
+// WithRateClasses is used to provide the RateClasses that are referenced by
+// the Class of limits. The classes are copied, and can later be replaced via
+// Limiter.ReloadClasses.
+func WithRateClasses(classes map[string]RateClass) Option {
+	return func(o *options) {
+		o.withRateClasses = make(map[string]RateClass, len(classes))
+		for name, c := range classes {
+			o.withRateClasses[name] = c
+		}
+	}
+}
+
+// WithTemplateVariables is used to provide the variables, such as the number
+// of nodes in a deployment, that are used to evaluate the MaxRequestsExpr of
+// limits. The variables are copied, and are used for the limits provided to
+// both NewLimiter and Limiter.Reload.
+func WithTemplateVariables(vars map[string]float64) Option {
+	return func(o *options) {
+		o.withTemplateVariables = make(map[string]float64, len(vars))
+		for name, v := range vars {
+			o.withTemplateVariables[name] = v
+		}
+	}
+}
+
+// WithDenialCache is used to cache the denial of requests for exhausted
+// quotas that will not reset for at least minResetsIn, so that later requests
+// using the same quotas are denied without fetching them from the store until
+// the quotas reset. This reduces contention for the store during retry storms.
+// Up to maxSize denials are cached. A denial is removed if its quota is
+// refunded, and all denials are removed when the limits are reloaded or
+// quotas are rekeyed or restored. A maxSize of zero disables the cache, which
+// is the default.
+func WithDenialCache(minResetsIn time.Duration, maxSize int) Option {
+	return func(o *options) {
+		o.withDenialCacheMinResetsIn = minResetsIn
+		o.withDenialCacheMaxSize = maxSize
+	}
+}
+
+// WithStore is used to provide a Store that is used to check and consume
+// quotas, rather than storing them in the Limiter, such as to share quotas
+// between Limiters. The Limiter's max size does not apply to the quotas in the
+// Store. Refund and Charge are not supported when using a Store. Warm-up and
+// risk multipliers are not applied to the quotas in the Store, and they are not
+// included in snapshots, the write-ahead log, or usage records.
+func WithStore(s Store) Option {
+	return func(o *options) {
+		o.withStore = s
+	}
+}
+
+// WithStorePer is used to provide a Store that is used to check and consume
+// the quotas for the given LimitPer, rather than the Store provided via
+// WithStore, or storing them in the Limiter. This allows each LimitPer to use
+// a different Store, such as storing the quotas for the total in memory while
+// sharing the quotas for IP addresses between Limiters. Each Store checks and
+// consumes its quotas for a request separately, so a request that is denied
+// by one Store may have consumed from the quotas in another. As with
+// WithStore, Refund and Charge are not supported when using a Store.
+func WithStorePer(per LimitPer, s Store) Option {
+	return func(o *options) {
+		if o.withStorePer == nil {
+			o.withStorePer = make(map[LimitPer]Store, len(requiredLimitPer))
+		}
+		o.withStorePer[per] = s
+	}
+}
+
+// WithStoreFailureMode is used to set how requests are handled when the Store
+// provided via WithStore returns an error. By default, StoreFailClosed is
+// used.
+func WithStoreFailureMode(m StoreFailureMode) Option {
+	return func(o *options) {
+		o.withStoreFailureMode = m
+	}
+}
+
+// WithStoreBreaker is used to call the Stores provided via WithStore and
+// WithStorePer using a circuit breaker, so that requests are not delayed by
+// calls to a Store that is failing. Each Store has its own breaker. While a
+// breaker is open, requests are handled using the StoreFailureMode. By
+// default, there is no circuit breaker.
+func WithStoreBreaker(b StoreBreaker) Option {
+	return func(o *options) {
+		o.withStoreBreaker = &b
+	}
+}
+
+// WithStoreBreakerStateMetric is used to provide a metric that will record
+// the BreakerState of the StoreBreaker each time that it changes. If more
+// than one Store is used, it records the state of the breaker that changed
+// most recently.
+func WithStoreBreakerStateMetric(g metric.Gauge) Option {
+	return func(o *options) {
+		switch {
+		case g == nil:
+			o.withStoreBreakerStateMetric = &nilGauge{}
+		default:
+			o.withStoreBreakerStateMetric = g
+		}
+	}
+}
+
+// WithStoreBreakerOpenMetric is used to provide a metric that will record the
+// number of times that the StoreBreaker opens.
+func WithStoreBreakerOpenMetric(c metric.Counter) Option {
+	return func(o *options) {
+		switch {
+		case c == nil:
+			o.withStoreBreakerOpenMetric = &nilCounter{}
+		default:
+			o.withStoreBreakerOpenMetric = c
+		}
+	}
+}
+
+// WithStoreFallbackDropMetric is used to provide a metric that will record the
+// number of times that requests consumed from a quota while the Store was
+// failing are not recorded to be resynced, when using StoreFallbackLocal,
+// because the usage of the Limiter's max size quotas is already recorded.
+func WithStoreFallbackDropMetric(c metric.Counter) Option {
+	return func(o *options) {
+		switch {
+		case c == nil:
+			o.withStoreFallbackDropMetric = &nilCounter{}
+		default:
+			o.withStoreFallbackDropMetric = c
+		}
+	}
+}
+
+// WithRollout is used to gradually enforce the limits of the limit policy for
+// the resource and action using the Rollout, such as when the policy's limits
+// are made stricter. Over-limit requests that are not denied are reported to
+// the function provided via WithUnenforcedDenialHook.
+func WithRollout(resource, action string, r Rollout) Option {
+	return func(o *options) {
+		if o.withRollouts == nil {
+			o.withRollouts = make(map[policyKey]Rollout)
+		}
+		o.withRollouts[limitPolicyKey(resource, action)] = r
+	}
+}
+
+// WithUnenforcedDenialHook is used to provide a function that is called each
+// time an over-limit request is allowed since the Rollout of its limit policy
+// did not enforce its limits.
+func WithUnenforcedDenialHook(fn UnenforcedDenialHook) Option {
+	return func(o *options) {
+		o.withUnenforcedDenialHook = fn
+	}
+}
+
+// WithGlobalMultiplierHook is used to provide a function that is called each
+// time the Limiter's global multiplier is changed via SetGlobalMultiplier.
+func WithGlobalMultiplierHook(fn GlobalMultiplierHook) Option {
+	return func(o *options) {
+		o.withGlobalMultiplierHook = fn
+	}
+}
+
+// WithProfiles is used to provide Profiles whose limits replace the Limiter's
+// limits during the windows of their schedules, using the Limiter's Clock. If
+// the windows of multiple profiles overlap, the profile provided first is
+// used. The profiles are copied.
+func WithProfiles(profiles ...Profile) Option {
+	return func(o *options) {
+		o.withProfiles = append([]Profile(nil), profiles...)
+	}
+}
+
+// WithProfileHook is used to provide a function that is called each time the
+// Limiter switches to or from the limits of a Profile.
+func WithProfileHook(fn ProfileHook) Option {
+	return func(o *options) {
+		o.withProfileHook = fn
+	}
+}
+
+// WithCohorts is used to assign the IP addresses or auth tokens of requests,
+// depending on per, to n cohorts using a consistent hash of each identity,
+// and to use the limits for the LimitPer for each cohort rather than for each
+// identity. This caps the aggregate load from a long tail of identities
+// without storing a quota for every identity. The per must be
+// LimitPerIPAddress or LimitPerAuthToken, and n must be greater than zero.
+func WithCohorts(per LimitPer, n int) Option {
+	return func(o *options) {
+		if o.withCohorts == nil {
+			o.withCohorts = make(map[LimitPer]int, len(requiredLimitPer))
+		}
+		o.withCohorts[per] = n
+	}
+}
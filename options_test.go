@@ -5,6 +5,7 @@ package rate
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -17,6 +18,14 @@ func (t *testGauge) Set(f float64) {
 	t.v = f
 }
 
+type testCounter struct {
+	v float64
+}
+
+func (t *testCounter) Add(f float64) {
+	t.v += f
+}
+
 func TestGetOpts(t *testing.T) {
 	t.Parallel()
 
@@ -26,8 +35,20 @@ func TestGetOpts(t *testing.T) {
 			withNumberBuckets:              DefaultNumberBuckets,
 			withPolicyHeader:               DefaultPolicyHeader,
 			withUsageHeader:                DefaultUsageHeader,
+			withGraceHeader:                DefaultGraceHeader,
 			withQuotaStorageCapacityMetric: &nilGauge{},
 			withQuotaStorageUsageMetric:    &nilGauge{},
+			withClock:                      realClock{},
+			withCleanupBatchSize:           DefaultCleanupBatchSize,
+			withQuotaPoolHitMetric:         &nilCounter{},
+			withQuotaPoolMissMetric:        &nilCounter{},
+			withUnknownPolicyMetric:        &nilCounter{},
+			withStoreBreakerStateMetric:    &nilGauge{},
+			withStoreBreakerOpenMetric:     &nilCounter{},
+			withStoreFallbackDropMetric:    &nilCounter{},
+			withPolicyTotalQuotas:          true,
+			withAlertMinRequests:           DefaultAlertMinRequests,
+			withAlertMaxPolicies:           DefaultAlertMaxPolicies,
 		}
 		assert.Equal(t, opts, testOpts)
 	})
@@ -37,8 +58,20 @@ func TestGetOpts(t *testing.T) {
 			withNumberBuckets:              40,
 			withPolicyHeader:               DefaultPolicyHeader,
 			withUsageHeader:                DefaultUsageHeader,
+			withGraceHeader:                DefaultGraceHeader,
 			withQuotaStorageCapacityMetric: &nilGauge{},
 			withQuotaStorageUsageMetric:    &nilGauge{},
+			withClock:                      realClock{},
+			withCleanupBatchSize:           DefaultCleanupBatchSize,
+			withQuotaPoolHitMetric:         &nilCounter{},
+			withQuotaPoolMissMetric:        &nilCounter{},
+			withUnknownPolicyMetric:        &nilCounter{},
+			withStoreBreakerStateMetric:    &nilGauge{},
+			withStoreBreakerOpenMetric:     &nilCounter{},
+			withStoreFallbackDropMetric:    &nilCounter{},
+			withPolicyTotalQuotas:          true,
+			withAlertMinRequests:           DefaultAlertMinRequests,
+			withAlertMaxPolicies:           DefaultAlertMaxPolicies,
 		}
 		assert.Equal(t, opts, testOpts)
 	})
@@ -48,8 +81,20 @@ func TestGetOpts(t *testing.T) {
 			withNumberBuckets:              DefaultNumberBuckets,
 			withPolicyHeader:               "Limit-Policy",
 			withUsageHeader:                DefaultUsageHeader,
+			withGraceHeader:                DefaultGraceHeader,
 			withQuotaStorageCapacityMetric: &nilGauge{},
 			withQuotaStorageUsageMetric:    &nilGauge{},
+			withClock:                      realClock{},
+			withCleanupBatchSize:           DefaultCleanupBatchSize,
+			withQuotaPoolHitMetric:         &nilCounter{},
+			withQuotaPoolMissMetric:        &nilCounter{},
+			withUnknownPolicyMetric:        &nilCounter{},
+			withStoreBreakerStateMetric:    &nilGauge{},
+			withStoreBreakerOpenMetric:     &nilCounter{},
+			withStoreFallbackDropMetric:    &nilCounter{},
+			withPolicyTotalQuotas:          true,
+			withAlertMinRequests:           DefaultAlertMinRequests,
+			withAlertMaxPolicies:           DefaultAlertMaxPolicies,
 		}
 		assert.Equal(t, opts, testOpts)
 	})
@@ -59,8 +104,20 @@ func TestGetOpts(t *testing.T) {
 			withNumberBuckets:              DefaultNumberBuckets,
 			withPolicyHeader:               DefaultPolicyHeader,
 			withUsageHeader:                "Quota-Usage",
+			withGraceHeader:                DefaultGraceHeader,
 			withQuotaStorageCapacityMetric: &nilGauge{},
 			withQuotaStorageUsageMetric:    &nilGauge{},
+			withClock:                      realClock{},
+			withCleanupBatchSize:           DefaultCleanupBatchSize,
+			withQuotaPoolHitMetric:         &nilCounter{},
+			withQuotaPoolMissMetric:        &nilCounter{},
+			withUnknownPolicyMetric:        &nilCounter{},
+			withStoreBreakerStateMetric:    &nilGauge{},
+			withStoreBreakerOpenMetric:     &nilCounter{},
+			withStoreFallbackDropMetric:    &nilCounter{},
+			withPolicyTotalQuotas:          true,
+			withAlertMinRequests:           DefaultAlertMinRequests,
+			withAlertMaxPolicies:           DefaultAlertMaxPolicies,
 		}
 		assert.Equal(t, opts, testOpts)
 	})
@@ -72,8 +129,20 @@ func TestGetOpts(t *testing.T) {
 			withNumberBuckets:              DefaultNumberBuckets,
 			withPolicyHeader:               DefaultPolicyHeader,
 			withUsageHeader:                DefaultUsageHeader,
+			withGraceHeader:                DefaultGraceHeader,
 			withQuotaStorageCapacityMetric: g,
 			withQuotaStorageUsageMetric:    &nilGauge{},
+			withClock:                      realClock{},
+			withCleanupBatchSize:           DefaultCleanupBatchSize,
+			withQuotaPoolHitMetric:         &nilCounter{},
+			withQuotaPoolMissMetric:        &nilCounter{},
+			withUnknownPolicyMetric:        &nilCounter{},
+			withStoreBreakerStateMetric:    &nilGauge{},
+			withStoreBreakerOpenMetric:     &nilCounter{},
+			withStoreFallbackDropMetric:    &nilCounter{},
+			withPolicyTotalQuotas:          true,
+			withAlertMinRequests:           DefaultAlertMinRequests,
+			withAlertMaxPolicies:           DefaultAlertMaxPolicies,
 		}
 		assert.Equal(t, opts, testOpts)
 	})
@@ -83,8 +152,20 @@ func TestGetOpts(t *testing.T) {
 			withNumberBuckets:              DefaultNumberBuckets,
 			withPolicyHeader:               DefaultPolicyHeader,
 			withUsageHeader:                DefaultUsageHeader,
+			withGraceHeader:                DefaultGraceHeader,
 			withQuotaStorageCapacityMetric: &nilGauge{},
 			withQuotaStorageUsageMetric:    &nilGauge{},
+			withClock:                      realClock{},
+			withCleanupBatchSize:           DefaultCleanupBatchSize,
+			withQuotaPoolHitMetric:         &nilCounter{},
+			withQuotaPoolMissMetric:        &nilCounter{},
+			withUnknownPolicyMetric:        &nilCounter{},
+			withStoreBreakerStateMetric:    &nilGauge{},
+			withStoreBreakerOpenMetric:     &nilCounter{},
+			withStoreFallbackDropMetric:    &nilCounter{},
+			withPolicyTotalQuotas:          true,
+			withAlertMinRequests:           DefaultAlertMinRequests,
+			withAlertMaxPolicies:           DefaultAlertMaxPolicies,
 		}
 		assert.Equal(t, opts, testOpts)
 	})
@@ -96,19 +177,466 @@ func TestGetOpts(t *testing.T) {
 			withNumberBuckets:              DefaultNumberBuckets,
 			withPolicyHeader:               DefaultPolicyHeader,
 			withUsageHeader:                DefaultUsageHeader,
+			withGraceHeader:                DefaultGraceHeader,
 			withQuotaStorageCapacityMetric: &nilGauge{},
 			withQuotaStorageUsageMetric:    g,
+			withClock:                      realClock{},
+			withCleanupBatchSize:           DefaultCleanupBatchSize,
+			withQuotaPoolHitMetric:         &nilCounter{},
+			withQuotaPoolMissMetric:        &nilCounter{},
+			withUnknownPolicyMetric:        &nilCounter{},
+			withStoreBreakerStateMetric:    &nilGauge{},
+			withStoreBreakerOpenMetric:     &nilCounter{},
+			withStoreFallbackDropMetric:    &nilCounter{},
+			withPolicyTotalQuotas:          true,
+			withAlertMinRequests:           DefaultAlertMinRequests,
+			withAlertMaxPolicies:           DefaultAlertMaxPolicies,
+		}
+		assert.Equal(t, opts, testOpts)
+	})
+	t.Run("WithProjectedExhaustion", func(t *testing.T) {
+		opts := getOpts(WithProjectedExhaustion(true))
+		testOpts := options{
+			withNumberBuckets:              DefaultNumberBuckets,
+			withPolicyHeader:               DefaultPolicyHeader,
+			withUsageHeader:                DefaultUsageHeader,
+			withGraceHeader:                DefaultGraceHeader,
+			withQuotaStorageCapacityMetric: &nilGauge{},
+			withQuotaStorageUsageMetric:    &nilGauge{},
+			withClock:                      realClock{},
+			withCleanupBatchSize:           DefaultCleanupBatchSize,
+			withQuotaPoolHitMetric:         &nilCounter{},
+			withQuotaPoolMissMetric:        &nilCounter{},
+			withUnknownPolicyMetric:        &nilCounter{},
+			withStoreBreakerStateMetric:    &nilGauge{},
+			withStoreBreakerOpenMetric:     &nilCounter{},
+			withStoreFallbackDropMetric:    &nilCounter{},
+			withPolicyTotalQuotas:          true,
+			withAlertMinRequests:           DefaultAlertMinRequests,
+			withAlertMaxPolicies:           DefaultAlertMaxPolicies,
+			withProjectedExhaustion:        true,
 		}
 		assert.Equal(t, opts, testOpts)
 	})
+	t.Run("WithResetFormat", func(t *testing.T) {
+		opts := getOpts(WithResetFormat(ResetEpochSeconds))
+		testOpts := options{
+			withNumberBuckets:              DefaultNumberBuckets,
+			withPolicyHeader:               DefaultPolicyHeader,
+			withUsageHeader:                DefaultUsageHeader,
+			withGraceHeader:                DefaultGraceHeader,
+			withQuotaStorageCapacityMetric: &nilGauge{},
+			withQuotaStorageUsageMetric:    &nilGauge{},
+			withClock:                      realClock{},
+			withCleanupBatchSize:           DefaultCleanupBatchSize,
+			withQuotaPoolHitMetric:         &nilCounter{},
+			withQuotaPoolMissMetric:        &nilCounter{},
+			withUnknownPolicyMetric:        &nilCounter{},
+			withStoreBreakerStateMetric:    &nilGauge{},
+			withStoreBreakerOpenMetric:     &nilCounter{},
+			withStoreFallbackDropMetric:    &nilCounter{},
+			withPolicyTotalQuotas:          true,
+			withAlertMinRequests:           DefaultAlertMinRequests,
+			withAlertMaxPolicies:           DefaultAlertMaxPolicies,
+			withResetFormat:                ResetEpochSeconds,
+		}
+		assert.Equal(t, opts, testOpts)
+	})
+	t.Run("WithUsageDimension", func(t *testing.T) {
+		opts := getOpts(WithUsageDimension(true))
+		testOpts := options{
+			withNumberBuckets:              DefaultNumberBuckets,
+			withPolicyHeader:               DefaultPolicyHeader,
+			withUsageHeader:                DefaultUsageHeader,
+			withGraceHeader:                DefaultGraceHeader,
+			withQuotaStorageCapacityMetric: &nilGauge{},
+			withQuotaStorageUsageMetric:    &nilGauge{},
+			withClock:                      realClock{},
+			withCleanupBatchSize:           DefaultCleanupBatchSize,
+			withQuotaPoolHitMetric:         &nilCounter{},
+			withQuotaPoolMissMetric:        &nilCounter{},
+			withUnknownPolicyMetric:        &nilCounter{},
+			withStoreBreakerStateMetric:    &nilGauge{},
+			withStoreBreakerOpenMetric:     &nilCounter{},
+			withStoreFallbackDropMetric:    &nilCounter{},
+			withPolicyTotalQuotas:          true,
+			withAlertMinRequests:           DefaultAlertMinRequests,
+			withAlertMaxPolicies:           DefaultAlertMaxPolicies,
+			withUsageDimension:             true,
+		}
+		assert.Equal(t, opts, testOpts)
+	})
+	t.Run("WithLegacyHeaders", func(t *testing.T) {
+		opts := getOpts(WithLegacyHeaders(true))
+		testOpts := options{
+			withNumberBuckets:              DefaultNumberBuckets,
+			withPolicyHeader:               DefaultPolicyHeader,
+			withUsageHeader:                DefaultUsageHeader,
+			withGraceHeader:                DefaultGraceHeader,
+			withQuotaStorageCapacityMetric: &nilGauge{},
+			withQuotaStorageUsageMetric:    &nilGauge{},
+			withClock:                      realClock{},
+			withCleanupBatchSize:           DefaultCleanupBatchSize,
+			withQuotaPoolHitMetric:         &nilCounter{},
+			withQuotaPoolMissMetric:        &nilCounter{},
+			withUnknownPolicyMetric:        &nilCounter{},
+			withStoreBreakerStateMetric:    &nilGauge{},
+			withStoreBreakerOpenMetric:     &nilCounter{},
+			withStoreFallbackDropMetric:    &nilCounter{},
+			withPolicyTotalQuotas:          true,
+			withAlertMinRequests:           DefaultAlertMinRequests,
+			withAlertMaxPolicies:           DefaultAlertMaxPolicies,
+			withLegacyHeaders:              true,
+		}
+		assert.Equal(t, opts, testOpts)
+	})
+	t.Run("WithEntrySlabSize", func(t *testing.T) {
+		opts := getOpts(WithEntrySlabSize(1024))
+		testOpts := options{
+			withNumberBuckets:              DefaultNumberBuckets,
+			withPolicyHeader:               DefaultPolicyHeader,
+			withUsageHeader:                DefaultUsageHeader,
+			withGraceHeader:                DefaultGraceHeader,
+			withQuotaStorageCapacityMetric: &nilGauge{},
+			withQuotaStorageUsageMetric:    &nilGauge{},
+			withClock:                      realClock{},
+			withCleanupBatchSize:           DefaultCleanupBatchSize,
+			withQuotaPoolHitMetric:         &nilCounter{},
+			withQuotaPoolMissMetric:        &nilCounter{},
+			withUnknownPolicyMetric:        &nilCounter{},
+			withStoreBreakerStateMetric:    &nilGauge{},
+			withStoreBreakerOpenMetric:     &nilCounter{},
+			withStoreFallbackDropMetric:    &nilCounter{},
+			withPolicyTotalQuotas:          true,
+			withAlertMinRequests:           DefaultAlertMinRequests,
+			withAlertMaxPolicies:           DefaultAlertMaxPolicies,
+			withEntrySlabSize:              1024,
+		}
+		assert.Equal(t, opts, testOpts)
+	})
+	t.Run("WithPreallocation", func(t *testing.T) {
+		opts := getOpts(WithPreallocation(PreallocateBuckets))
+		testOpts := options{
+			withNumberBuckets:              DefaultNumberBuckets,
+			withPolicyHeader:               DefaultPolicyHeader,
+			withUsageHeader:                DefaultUsageHeader,
+			withGraceHeader:                DefaultGraceHeader,
+			withQuotaStorageCapacityMetric: &nilGauge{},
+			withQuotaStorageUsageMetric:    &nilGauge{},
+			withClock:                      realClock{},
+			withCleanupBatchSize:           DefaultCleanupBatchSize,
+			withQuotaPoolHitMetric:         &nilCounter{},
+			withQuotaPoolMissMetric:        &nilCounter{},
+			withUnknownPolicyMetric:        &nilCounter{},
+			withStoreBreakerStateMetric:    &nilGauge{},
+			withStoreBreakerOpenMetric:     &nilCounter{},
+			withStoreFallbackDropMetric:    &nilCounter{},
+			withPolicyTotalQuotas:          true,
+			withAlertMinRequests:           DefaultAlertMinRequests,
+			withAlertMaxPolicies:           DefaultAlertMaxPolicies,
+			withPreallocation:              PreallocateBuckets,
+		}
+		assert.Equal(t, opts, testOpts)
+	})
+	t.Run("WithWarmUp", func(t *testing.T) {
+		opts := getOpts(WithWarmUp(0.5, 3))
+		testOpts := options{
+			withNumberBuckets:              DefaultNumberBuckets,
+			withPolicyHeader:               DefaultPolicyHeader,
+			withUsageHeader:                DefaultUsageHeader,
+			withGraceHeader:                DefaultGraceHeader,
+			withQuotaStorageCapacityMetric: &nilGauge{},
+			withQuotaStorageUsageMetric:    &nilGauge{},
+			withClock:                      realClock{},
+			withCleanupBatchSize:           DefaultCleanupBatchSize,
+			withQuotaPoolHitMetric:         &nilCounter{},
+			withQuotaPoolMissMetric:        &nilCounter{},
+			withUnknownPolicyMetric:        &nilCounter{},
+			withStoreBreakerStateMetric:    &nilGauge{},
+			withStoreBreakerOpenMetric:     &nilCounter{},
+			withStoreFallbackDropMetric:    &nilCounter{},
+			withPolicyTotalQuotas:          true,
+			withAlertMinRequests:           DefaultAlertMinRequests,
+			withAlertMaxPolicies:           DefaultAlertMaxPolicies,
+			withWarmUpFraction:             0.5,
+			withWarmUpWindows:              3,
+		}
+		assert.Equal(t, opts, testOpts)
+	})
+	t.Run("WithClock", func(t *testing.T) {
+		c := newFakeClock()
+		opts := getOpts(WithClock(c))
+		testOpts := options{
+			withNumberBuckets:              DefaultNumberBuckets,
+			withPolicyHeader:               DefaultPolicyHeader,
+			withUsageHeader:                DefaultUsageHeader,
+			withGraceHeader:                DefaultGraceHeader,
+			withQuotaStorageCapacityMetric: &nilGauge{},
+			withQuotaStorageUsageMetric:    &nilGauge{},
+			withClock:                      c,
+			withCleanupBatchSize:           DefaultCleanupBatchSize,
+			withQuotaPoolHitMetric:         &nilCounter{},
+			withQuotaPoolMissMetric:        &nilCounter{},
+			withUnknownPolicyMetric:        &nilCounter{},
+			withStoreBreakerStateMetric:    &nilGauge{},
+			withStoreBreakerOpenMetric:     &nilCounter{},
+			withStoreFallbackDropMetric:    &nilCounter{},
+			withPolicyTotalQuotas:          true,
+			withAlertMinRequests:           DefaultAlertMinRequests,
+			withAlertMaxPolicies:           DefaultAlertMaxPolicies,
+		}
+		assert.Equal(t, opts, testOpts)
+	})
+	t.Run("WithClockNil", func(t *testing.T) {
+		opts := getOpts(WithClock(nil))
+		assert.Equal(t, realClock{}, opts.withClock)
+	})
+	t.Run("WithCleanupBatchSize", func(t *testing.T) {
+		opts := getOpts(WithCleanupBatchSize(100))
+		testOpts := options{
+			withNumberBuckets:              DefaultNumberBuckets,
+			withPolicyHeader:               DefaultPolicyHeader,
+			withUsageHeader:                DefaultUsageHeader,
+			withGraceHeader:                DefaultGraceHeader,
+			withQuotaStorageCapacityMetric: &nilGauge{},
+			withQuotaStorageUsageMetric:    &nilGauge{},
+			withClock:                      realClock{},
+			withCleanupBatchSize:           100,
+			withQuotaPoolHitMetric:         &nilCounter{},
+			withQuotaPoolMissMetric:        &nilCounter{},
+			withUnknownPolicyMetric:        &nilCounter{},
+			withStoreBreakerStateMetric:    &nilGauge{},
+			withStoreBreakerOpenMetric:     &nilCounter{},
+			withStoreFallbackDropMetric:    &nilCounter{},
+			withPolicyTotalQuotas:          true,
+			withAlertMinRequests:           DefaultAlertMinRequests,
+			withAlertMaxPolicies:           DefaultAlertMaxPolicies,
+		}
+		assert.Equal(t, opts, testOpts)
+	})
+	t.Run("WithQuotaPoolMetrics", func(t *testing.T) {
+		hits, misses := &testCounter{}, &testCounter{}
+		opts := getOpts(WithQuotaPoolHitMetric(hits), WithQuotaPoolMissMetric(misses))
+		testOpts := options{
+			withNumberBuckets:              DefaultNumberBuckets,
+			withPolicyHeader:               DefaultPolicyHeader,
+			withUsageHeader:                DefaultUsageHeader,
+			withGraceHeader:                DefaultGraceHeader,
+			withQuotaStorageCapacityMetric: &nilGauge{},
+			withQuotaStorageUsageMetric:    &nilGauge{},
+			withClock:                      realClock{},
+			withCleanupBatchSize:           DefaultCleanupBatchSize,
+			withQuotaPoolHitMetric:         hits,
+			withQuotaPoolMissMetric:        misses,
+			withUnknownPolicyMetric:        &nilCounter{},
+			withStoreBreakerStateMetric:    &nilGauge{},
+			withStoreBreakerOpenMetric:     &nilCounter{},
+			withStoreFallbackDropMetric:    &nilCounter{},
+			withPolicyTotalQuotas:          true,
+			withAlertMinRequests:           DefaultAlertMinRequests,
+			withAlertMaxPolicies:           DefaultAlertMaxPolicies,
+		}
+		assert.Equal(t, opts, testOpts)
+	})
+	t.Run("WithQuotaPoolMetricsNil", func(t *testing.T) {
+		opts := getOpts(WithQuotaPoolHitMetric(nil), WithQuotaPoolMissMetric(nil))
+		assert.Equal(t, &nilCounter{}, opts.withQuotaPoolHitMetric)
+		assert.Equal(t, &nilCounter{}, opts.withQuotaPoolMissMetric)
+	})
+	t.Run("WithMaxSizePer", func(t *testing.T) {
+		opts := getOpts(WithMaxSizePer(LimitPerTotal, 10), WithMaxSizePer(LimitPerIPAddress, 20))
+		assert.Equal(t, map[LimitPer]int{LimitPerTotal: 10, LimitPerIPAddress: 20}, opts.withMaxSizePer)
+	})
+	t.Run("WithPolicyTotalQuotas", func(t *testing.T) {
+		opts := getOpts(WithPolicyTotalQuotas(false))
+		assert.False(t, opts.withPolicyTotalQuotas)
+	})
+	t.Run("WithAlertMinRequests", func(t *testing.T) {
+		opts := getOpts(WithAlertMinRequests(1))
+		assert.Equal(t, uint64(1), opts.withAlertMinRequests)
+	})
+	t.Run("WithAlertMaxPolicies", func(t *testing.T) {
+		opts := getOpts(WithAlertMaxPolicies(10))
+		assert.Equal(t, 10, opts.withAlertMaxPolicies)
+	})
+	t.Run("WithStrictIPAddress", func(t *testing.T) {
+		opts := getOpts(WithStrictIPAddress(true))
+		assert.True(t, opts.withStrictIPAddress)
+	})
+	t.Run("WithAuthTokenNormalizer", func(t *testing.T) {
+		opts := getOpts(WithAuthTokenNormalizer(NormalizeBearerToken))
+		assert.NotNil(t, opts.withAuthTokenNormalizer)
+	})
+	t.Run("WithUsageSink", func(t *testing.T) {
+		opts := getOpts(WithUsageSink(func(UsageRecord) {}))
+		assert.NotNil(t, opts.withUsageSink)
+	})
+	t.Run("WithDenialAlerter", func(t *testing.T) {
+		a := &DenialAlerter{}
+		opts := getOpts(WithDenialAlerter(a))
+		assert.Same(t, a, opts.withDenialAlerter)
+	})
+	t.Run("WithStoreEventHook", func(t *testing.T) {
+		opts := getOpts(WithStoreEventHook(func(StoreEvent) {}))
+		assert.NotNil(t, opts.withStoreEventHook)
+	})
+	t.Run("WithGaugePublishInterval", func(t *testing.T) {
+		opts := getOpts(WithGaugePublishInterval(time.Second))
+		assert.Equal(t, time.Second, opts.withGaugePublishInterval)
+	})
+	t.Run("WithDurableFile", func(t *testing.T) {
+		opts := getOpts(WithDurableFile("quotas.json", time.Minute))
+		assert.Equal(t, "quotas.json", opts.withDurableFile)
+		assert.Equal(t, time.Minute, opts.withDurableFileInterval)
+	})
+	t.Run("WithDurableFileErrorHook", func(t *testing.T) {
+		opts := getOpts(WithDurableFileErrorHook(func(error) {}))
+		assert.NotNil(t, opts.withDurableFileErrorHook)
+	})
+	t.Run("WithGraceHeader", func(t *testing.T) {
+		opts := getOpts(WithGraceHeader("X-Grace"))
+		assert.Equal(t, "X-Grace", opts.withGraceHeader)
+	})
+	t.Run("WithGraceHook", func(t *testing.T) {
+		opts := getOpts(WithGraceHook(func(GraceRequest) {}))
+		assert.NotNil(t, opts.withGraceHook)
+	})
+	t.Run("WithMaxClockSkew", func(t *testing.T) {
+		opts := getOpts(WithMaxClockSkew(time.Second))
+		assert.Equal(t, time.Second, opts.withMaxClockSkew)
+	})
+	t.Run("WithUnknownPolicyBehavior", func(t *testing.T) {
+		opts := getOpts(WithUnknownPolicyBehavior(UnknownPolicyAllow))
+		assert.Equal(t, UnknownPolicyAllow, opts.withUnknownPolicyBehavior)
+	})
+	t.Run("WithUnknownPolicyMetric", func(t *testing.T) {
+		c := &testCounter{}
+		opts := getOpts(WithUnknownPolicyMetric(c))
+		assert.Equal(t, c, opts.withUnknownPolicyMetric)
+
+		opts = getOpts(WithUnknownPolicyMetric(nil))
+		assert.Equal(t, &nilCounter{}, opts.withUnknownPolicyMetric)
+	})
+	t.Run("WithDefaultPolicy", func(t *testing.T) {
+		opts := getOpts(WithDefaultPolicy("resource", "action"))
+		assert.Equal(t, &policyKey{resource: "resource", action: "action"}, opts.withDefaultPolicy)
+	})
+	t.Run("WithTemplateVariables", func(t *testing.T) {
+		vars := map[string]float64{"nodes": 3}
+		opts := getOpts(WithTemplateVariables(vars))
+		assert.Equal(t, vars, opts.withTemplateVariables)
+		vars["nodes"] = 5
+		assert.Equal(t, float64(3), opts.withTemplateVariables["nodes"])
+	})
+	t.Run("WithDenialCache", func(t *testing.T) {
+		opts := getOpts(WithDenialCache(time.Minute, 100))
+		assert.Equal(t, time.Minute, opts.withDenialCacheMinResetsIn)
+		assert.Equal(t, 100, opts.withDenialCacheMaxSize)
+	})
+	t.Run("WithStore", func(t *testing.T) {
+		s := newTestStore(realClock{})
+		opts := getOpts(WithStore(s))
+		assert.Same(t, s, opts.withStore)
+	})
+	t.Run("WithStorePer", func(t *testing.T) {
+		s := newTestStore(realClock{})
+		opts := getOpts(WithStorePer(LimitPerIPAddress, s))
+		assert.Equal(t, map[LimitPer]Store{LimitPerIPAddress: s}, opts.withStorePer)
+	})
+	t.Run("WithStoreFailureMode", func(t *testing.T) {
+		opts := getOpts(WithStoreFailureMode(StoreFallbackLocal))
+		assert.Equal(t, StoreFallbackLocal, opts.withStoreFailureMode)
+	})
+	t.Run("WithStoreBreaker", func(t *testing.T) {
+		b := StoreBreaker{ErrorRate: 0.5, MinRequests: 10, Interval: time.Minute, OpenDuration: time.Second, Probes: 1}
+		opts := getOpts(WithStoreBreaker(b))
+		assert.Equal(t, &b, opts.withStoreBreaker)
+	})
+	t.Run("WithStoreBreakerStateMetric", func(t *testing.T) {
+		g := &testGauge{}
+		opts := getOpts(WithStoreBreakerStateMetric(g))
+		assert.Equal(t, g, opts.withStoreBreakerStateMetric)
+
+		opts = getOpts(WithStoreBreakerStateMetric(nil))
+		assert.Equal(t, &nilGauge{}, opts.withStoreBreakerStateMetric)
+	})
+	t.Run("WithStoreBreakerOpenMetric", func(t *testing.T) {
+		c := &testCounter{}
+		opts := getOpts(WithStoreBreakerOpenMetric(c))
+		assert.Equal(t, c, opts.withStoreBreakerOpenMetric)
+
+		opts = getOpts(WithStoreBreakerOpenMetric(nil))
+		assert.Equal(t, &nilCounter{}, opts.withStoreBreakerOpenMetric)
+	})
+	t.Run("WithStoreFallbackDropMetric", func(t *testing.T) {
+		c := &testCounter{}
+		opts := getOpts(WithStoreFallbackDropMetric(c))
+		assert.Equal(t, c, opts.withStoreFallbackDropMetric)
+
+		opts = getOpts(WithStoreFallbackDropMetric(nil))
+		assert.Equal(t, &nilCounter{}, opts.withStoreFallbackDropMetric)
+	})
+	t.Run("WithTraceHook", func(t *testing.T) {
+		opts := getOpts(WithTraceHook(func(TraceEvent) {}), WithTraceSampling(10))
+		assert.NotNil(t, opts.withTraceHook)
+		assert.Equal(t, uint64(10), opts.withTraceSampling)
+	})
+	t.Run("WithRollout", func(t *testing.T) {
+		r := Rollout{Percent: 10, Ramp: time.Hour}
+		opts := getOpts(WithRollout("resource", "action", r), WithRollout("other", "action", Rollout{}))
+		assert.Equal(t, map[policyKey]Rollout{
+			limitPolicyKey("resource", "action"): r,
+			limitPolicyKey("other", "action"):    {},
+		}, opts.withRollouts)
+	})
+	t.Run("WithGlobalMultiplierHook", func(t *testing.T) {
+		opts := getOpts(WithGlobalMultiplierHook(func(float64, float64) {}))
+		assert.NotNil(t, opts.withGlobalMultiplierHook)
+	})
+	t.Run("WithProfiles", func(t *testing.T) {
+		profiles := []Profile{{Name: "maintenance"}}
+		opts := getOpts(WithProfiles(profiles...), WithProfileHook(func(string, error) {}))
+		assert.Equal(t, profiles, opts.withProfiles)
+		assert.NotNil(t, opts.withProfileHook)
+	})
+	t.Run("WithCohorts", func(t *testing.T) {
+		opts := getOpts(WithCohorts(LimitPerAuthToken, 16))
+		assert.Equal(t, map[LimitPer]int{LimitPerAuthToken: 16}, opts.withCohorts)
+	})
+	t.Run("WithUnenforcedDenialHook", func(t *testing.T) {
+		opts := getOpts(WithUnenforcedDenialHook(func(UnenforcedDenial) {}))
+		assert.NotNil(t, opts.withUnenforcedDenialHook)
+	})
+	t.Run("WithWriteAheadLog", func(t *testing.T) {
+		opts := getOpts(WithWriteAheadLog("quotas.wal", time.Hour, time.Minute))
+		assert.Equal(t, "quotas.wal", opts.withWriteAheadLog)
+		assert.Equal(t, time.Hour, opts.withWriteAheadLogMinPeriod)
+		assert.Equal(t, time.Minute, opts.withWriteAheadLogCompact)
+	})
+	t.Run("WithRiskMultiplier", func(t *testing.T) {
+		opts := getOpts(WithRiskMultiplier(func(LimitPer, string) float64 { return 1 }))
+		assert.NotNil(t, opts.withRiskMultiplier)
+	})
 	t.Run("WithQuotaStorageUsageMetricNil", func(t *testing.T) {
 		opts := getOpts(WithQuotaStorageUsageMetric(nil))
 		testOpts := options{
 			withNumberBuckets:              DefaultNumberBuckets,
 			withPolicyHeader:               DefaultPolicyHeader,
 			withUsageHeader:                DefaultUsageHeader,
+			withGraceHeader:                DefaultGraceHeader,
 			withQuotaStorageCapacityMetric: &nilGauge{},
 			withQuotaStorageUsageMetric:    &nilGauge{},
+			withClock:                      realClock{},
+			withCleanupBatchSize:           DefaultCleanupBatchSize,
+			withQuotaPoolHitMetric:         &nilCounter{},
+			withQuotaPoolMissMetric:        &nilCounter{},
+			withUnknownPolicyMetric:        &nilCounter{},
+			withStoreBreakerStateMetric:    &nilGauge{},
+			withStoreBreakerOpenMetric:     &nilCounter{},
+			withStoreFallbackDropMetric:    &nilCounter{},
+			withPolicyTotalQuotas:          true,
+			withAlertMinRequests:           DefaultAlertMinRequests,
+			withAlertMaxPolicies:           DefaultAlertMaxPolicies,
 		}
 		assert.Equal(t, opts, testOpts)
 	})
@@ -0,0 +1,236 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-rate/metric"
+)
+
+// perStore is a quotaFetcher that stores quotas in a separate quotaFetcher
+// for each LimitPer. This allows each LimitPer to have its own max size, since
+// the number of quotas needed for the total is much smaller than the number
+// needed for IP addresses or auth tokens.
+type perStore struct {
+	stores map[LimitPer]quotaFetcher
+	// all contains each distinct quotaFetcher in stores.
+	all []quotaFetcher
+}
+
+// newPerStore creates a perStore. A separate expirableStore is created for
+// each LimitPer in sizes, using the corresponding size as its max size. Any
+// LimitPer not in sizes shares a single expirableStore with a max size of
+// maxSize. The capacity and usage metrics report the total across all of the
+// stores.
+func newPerStore(maxSize int, maxEntryTTL time.Duration, sizes map[LimitPer]int, o ...Option) (*perStore, error) {
+	const op = "rate.newPerStore"
+
+	opts := getOpts(o...)
+	capacity := newSumGauge(opts.withQuotaStorageCapacityMetric)
+	usage := newSumGauge(opts.withQuotaStorageUsageMetric)
+
+	p := &perStore{
+		stores: make(map[LimitPer]quotaFetcher, len(requiredLimitPer)),
+	}
+	newStore := func(size int) (quotaFetcher, error) {
+		storeOpts := append(o[:len(o):len(o)],
+			WithQuotaStorageCapacityMetric(capacity.child()),
+			WithQuotaStorageUsageMetric(usage.child()),
+		)
+		s, err := newExpirableStore(size, maxEntryTTL, storeOpts...)
+		if err != nil {
+			return nil, err
+		}
+		p.all = append(p.all, s)
+		return s, nil
+	}
+
+	var shared quotaFetcher
+	for _, per := range requiredLimitPer {
+		var err error
+		size, ok := sizes[per]
+		switch {
+		case ok:
+			p.stores[per], err = newStore(size)
+		case shared == nil:
+			shared, err = newStore(maxSize)
+			p.stores[per] = shared
+		default:
+			p.stores[per] = shared
+		}
+		if err != nil {
+			p.shutdown()
+			return nil, fmt.Errorf("%s: %q: %w", op, per, err)
+		}
+	}
+	return p, nil
+}
+
+func (p *perStore) fetch(ctx context.Context, key string, limit *Limited) (*Quota, error) {
+	s, ok := p.stores[limit.Per]
+	if !ok {
+		return nil, ErrInvalidLimitPer
+	}
+	return s.fetch(ctx, key, limit)
+}
+
+func (p *perStore) rekey(limit *Limited, oldID, newID string) error {
+	s, ok := p.stores[limit.Per]
+	if !ok {
+		return ErrInvalidLimitPer
+	}
+	return s.rekey(limit, oldID, newID)
+}
+
+// stats returns the total capacity and usage of the stores. The entries in
+// each bucket are summed across the stores.
+func (p *perStore) stats() storeStats {
+	var st storeStats
+	for _, s := range p.all {
+		ss := s.stats()
+		st.capacity += ss.capacity
+		st.usage += ss.usage
+		for i, n := range ss.bucketEntries {
+			if i >= len(st.bucketEntries) {
+				st.bucketEntries = append(st.bucketEntries, 0)
+			}
+			st.bucketEntries[i] += n
+		}
+	}
+	return st
+}
+
+func (p *perStore) snapshot() []SnapshotQuota {
+	var quotas []SnapshotQuota
+	for _, s := range p.all {
+		quotas = append(quotas, s.snapshot()...)
+	}
+	return quotas
+}
+
+func (p *perStore) restore(limit *Limited, sq SnapshotQuota) error {
+	s, ok := p.stores[limit.Per]
+	if !ok {
+		return ErrInvalidLimitPer
+	}
+	return s.restore(limit, sq)
+}
+
+func (p *perStore) lookup(id string, limit *Limited) *Quota {
+	s, ok := p.stores[limit.Per]
+	if !ok {
+		return nil
+	}
+	return s.lookup(id, limit)
+}
+
+func (p *perStore) extend(id string, limit *Limited, d time.Duration) error {
+	s, ok := p.stores[limit.Per]
+	if !ok {
+		return ErrInvalidLimitPer
+	}
+	return s.extend(id, limit, d)
+}
+
+func (p *perStore) shorten(limits map[string]*Limited) error {
+	for _, s := range p.all {
+		if err := s.shorten(limits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *perStore) compact() error {
+	for _, s := range p.all {
+		if err := s.compact(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *perStore) shutdown() error {
+	for _, s := range p.all {
+		if err := s.shutdown(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *perStore) resize(maxEntryTTL time.Duration) error {
+	for _, s := range p.all {
+		if err := s.resize(maxEntryTTL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *perStore) maxEntryTTL() time.Duration {
+	return p.stores[LimitPerTotal].maxEntryTTL()
+}
+
+// sumGauge sets a parent gauge to the sum of the values of its children. The
+// value of each child is stored atomically, and summed each time a child is
+// set, so that setting a child does not acquire a lock. When children are set
+// concurrently, the parent may briefly be set to a sum that does not include
+// the latest value of one of them, until a child is next set.
+type sumGauge struct {
+	parent metric.Gauge
+
+	// mu is held while adding a child. The children are replaced, rather
+	// than modified, when a child is added so that they can be read without
+	// acquiring mu.
+	mu       sync.Mutex
+	children atomic.Pointer[[]*sumGaugeChild]
+}
+
+func newSumGauge(parent metric.Gauge) *sumGauge {
+	g := &sumGauge{parent: parent}
+	g.children.Store(&[]*sumGaugeChild{})
+	return g
+}
+
+// child returns a new gauge whose value is included in the sum.
+func (g *sumGauge) child() metric.Gauge {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	c := &sumGaugeChild{sum: g}
+	cur := *g.children.Load()
+	children := append(cur[:len(cur):len(cur)], c)
+	g.children.Store(&children)
+	return c
+}
+
+// publish sets the parent to the sum of the values of the children.
+func (g *sumGauge) publish() {
+	var total float64
+	for _, c := range *g.children.Load() {
+		total += math.Float64frombits(c.bits.Load())
+	}
+	g.parent.Set(total)
+}
+
+type sumGaugeChild struct {
+	sum *sumGauge
+	// bits are the bits of the child's value, as returned by
+	// math.Float64bits.
+	bits atomic.Uint64
+}
+
+func (c *sumGaugeChild) Set(f float64) {
+	c.bits.Store(math.Float64bits(f))
+	c.sum.publish()
+}
+
+// ensure perStore can be used as a quotaFetcher
+var _ quotaFetcher = (*perStore)(nil)
@@ -0,0 +1,226 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_perStore(t *testing.T) {
+	capacity, usage := &testGauge{}, &testGauge{}
+	s, err := newPerStore(
+		5,
+		time.Minute,
+		map[LimitPer]int{
+			LimitPerTotal:     1,
+			LimitPerIPAddress: 2,
+		},
+		WithQuotaStorageCapacityMetric(capacity),
+		WithQuotaStorageUsageMetric(usage),
+	)
+	require.NoError(t, err)
+	defer s.shutdown()
+
+	assert.Len(t, s.all, 3)
+	assert.Same(t, s.stores[LimitPerAuthToken], s.all[2])
+	assert.Equal(t, float64(8), capacity.v)
+	assert.Equal(t, time.Minute, s.maxEntryTTL())
+
+	limit := func(per LimitPer) *Limited {
+		return &Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         per,
+			MaxRequests: 10,
+			Period:      time.Minute,
+		}
+	}
+
+	_, err = s.fetch(context.Background(), "total", limit(LimitPerTotal))
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = s.fetch(context.Background(), fmt.Sprintf("ip-%d", i), limit(LimitPerIPAddress))
+		require.NoError(t, err)
+	}
+	_, err = s.fetch(context.Background(), "ip-2", limit(LimitPerIPAddress))
+	var full *ErrLimiterFull
+	require.ErrorAs(t, err, &full)
+
+	// The auth token store should not be affected by the full IP address store.
+	for i := 0; i < 5; i++ {
+		_, err = s.fetch(context.Background(), fmt.Sprintf("token-%d", i), limit(LimitPerAuthToken))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, float64(8), usage.v)
+}
+
+func TestLimiterMaxSizePer(t *testing.T) {
+	limits := []Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerAuthToken,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+	}
+
+	cases := []struct {
+		name      string
+		options   []Option
+		expectErr error
+	}{
+		{
+			"Valid",
+			[]Option{WithMaxSizePer(LimitPerTotal, 1)},
+			nil,
+		},
+		{
+			"InvalidPer",
+			[]Option{WithMaxSizePer(LimitPer("invalid"), 1)},
+			ErrInvalidLimitPer,
+		},
+		{
+			"InvalidSize",
+			[]Option{WithMaxSizePer(LimitPerIPAddress, 0)},
+			ErrInvalidMaxSize,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			l, err := NewLimiter(limits, 10, tc.options...)
+			if tc.expectErr != nil {
+				require.ErrorIs(t, err, tc.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			defer l.Shutdown()
+			require.IsType(t, &perStore{}, l.quotaFetcher)
+
+			allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "token")
+			require.NoError(t, err)
+			assert.True(t, allowed)
+		})
+	}
+}
+
+func Test_perStoreRekey(t *testing.T) {
+	s, err := newPerStore(10, time.Minute, map[LimitPer]int{LimitPerAuthToken: 5})
+	require.NoError(t, err)
+	defer s.shutdown()
+
+	limit := &Limited{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerAuthToken,
+		MaxRequests: 10,
+		Period:      time.Minute,
+	}
+	q, err := s.fetch(context.Background(), "old", limit)
+	require.NoError(t, err)
+	q.Consume()
+
+	require.NoError(t, s.rekey(limit, "old", "new"))
+	got, err := s.fetch(context.Background(), "new", limit)
+	require.NoError(t, err)
+	assert.Same(t, q, got)
+
+	require.ErrorIs(t, s.rekey(&Limited{Per: "invalid"}, "old", "new"), ErrInvalidLimitPer)
+}
+
+func Test_perStoreExtend(t *testing.T) {
+	c := newFakeClock()
+	s, err := newPerStore(10, 2*time.Minute, map[LimitPer]int{LimitPerAuthToken: 5}, WithClock(c))
+	require.NoError(t, err)
+	defer s.shutdown()
+
+	limit := &Limited{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerAuthToken,
+		MaxRequests: 10,
+		Period:      time.Minute,
+	}
+	q, err := s.fetch(context.Background(), "id", limit)
+	require.NoError(t, err)
+
+	require.NoError(t, s.extend("id", limit, 30*time.Second))
+	assert.Equal(t, c.Now().Add(90*time.Second), q.Expiration())
+
+	require.ErrorIs(t, s.extend("id", &Limited{Per: "invalid"}, time.Minute), ErrInvalidLimitPer)
+}
+
+func Test_perStoreShorten(t *testing.T) {
+	c := newFakeClock()
+	s, err := newPerStore(10, 2*time.Minute, map[LimitPer]int{LimitPerAuthToken: 5}, WithClock(c))
+	require.NoError(t, err)
+	defer s.shutdown()
+
+	limit := func(per LimitPer, period time.Duration) *Limited {
+		return &Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         per,
+			MaxRequests: 10,
+			Period:      period,
+		}
+	}
+	ipQuota, err := s.fetch(context.Background(), "id", limit(LimitPerIPAddress, time.Minute))
+	require.NoError(t, err)
+	tokenQuota, err := s.fetch(context.Background(), "id", limit(LimitPerAuthToken, time.Minute))
+	require.NoError(t, err)
+
+	limits := make(map[string]*Limited)
+	for _, ll := range []*Limited{limit(LimitPerIPAddress, 30*time.Second), limit(LimitPerAuthToken, 20*time.Second)} {
+		limits[quotaKey(ll, "")] = ll
+	}
+	require.NoError(t, s.shorten(limits))
+	assert.Equal(t, c.Now().Add(30*time.Second), ipQuota.Expiration())
+	assert.Equal(t, c.Now().Add(20*time.Second), tokenQuota.Expiration())
+}
+
+func Test_perStoreResize(t *testing.T) {
+	s, err := newPerStore(10, time.Minute, map[LimitPer]int{LimitPerAuthToken: 5}, WithClock(newFakeClock()))
+	require.NoError(t, err)
+	defer s.shutdown()
+
+	require.NoError(t, s.resize(time.Hour))
+	assert.Equal(t, time.Hour, s.maxEntryTTL())
+	for _, st := range s.all {
+		assert.Equal(t, time.Hour, st.maxEntryTTL())
+	}
+}
+
+func Test_sumGauge(t *testing.T) {
+	parent := &testGauge{}
+	g := newSumGauge(parent)
+	a, b := g.child(), g.child()
+
+	a.Set(2)
+	assert.Equal(t, float64(2), parent.v)
+	b.Set(3)
+	assert.Equal(t, float64(5), parent.v)
+	a.Set(1)
+	assert.Equal(t, float64(4), parent.v)
+}
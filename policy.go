@@ -5,12 +5,45 @@ package rate
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
+// UnknownPolicyBehavior determines how a Limiter handles requests for a
+// resource and action without a limit policy.
+type UnknownPolicyBehavior int
+
+const (
+	// UnknownPolicyDeny indicates that requests without a limit policy are
+	// not allowed, and ErrLimitPolicyNotFound is returned. This is the
+	// default.
+	UnknownPolicyDeny UnknownPolicyBehavior = iota
+	// UnknownPolicyAllow indicates that requests without a limit policy are
+	// allowed without being limited, which can be safer when new resources
+	// and actions may be deployed before their limits. A nil Quota is
+	// returned for these requests.
+	UnknownPolicyAllow
+	// UnknownPolicyUseDefault indicates that requests without a limit policy
+	// are limited by the limit policy provided by WithDefaultPolicy. Since
+	// quotas are keyed by the resource and action of their limit, these
+	// requests share the quotas of the default limit policy.
+	UnknownPolicyUseDefault
+)
+
+// IsValid checks if the given UnknownPolicyBehavior is valid.
+func (b UnknownPolicyBehavior) IsValid() bool {
+	switch b {
+	case UnknownPolicyDeny, UnknownPolicyAllow, UnknownPolicyUseDefault:
+		return true
+	}
+	return false
+}
+
 // limitPolicy is a collection of Limits for the same resource and action. A limitPolicy
-// should contain one Limit for each valid LimitPer.
+// contains at most one Limit for each valid LimitPer. Requests are not limited
+// for a LimitPer that does not have a Limit.
 type limitPolicy struct {
 	resource string
 	action   string
@@ -18,15 +51,55 @@ type limitPolicy struct {
 	m map[LimitPer]Limit
 
 	policy string
+	// policyHeader is policy as an HTTP header value, so that it can be set
+	// on a http.Header without allocating.
+	policyHeader []string
+
+	// multiplier is the Limiter's global multiplier, which is applied to
+	// the MaxRequests of the policy's limits in its policy header.
+	multiplier float64
+
+	// total is the quota for the LimitPerTotal limit, if it is Limited. It
+	// is created the first time it is needed.
+	total atomic.Pointer[Quota]
+}
+
+// Policy is a limit policy, which is the limits for a resource and action, as
+// returned by Limiter.Policies.
+type Policy struct {
+	Resource string
+	Action   string
+	// Limits are copies of the policy's limits, ordered by LimitPerTotal,
+	// LimitPerIPAddress, and then LimitPerAuthToken. A Limited has the
+	// MaxRequests and Period of its RateClass or template, if it has one,
+	// without the Limiter's global multiplier applied.
+	Limits []Limit
+}
+
+// export returns the policy as a Policy, with copies of its limits.
+func (p *limitPolicy) export() Policy {
+	pol := Policy{Resource: p.resource, Action: p.action}
+	for _, per := range requiredLimitPer {
+		switch l := p.m[per].(type) {
+		case *Limited:
+			c := *l
+			pol.Limits = append(pol.Limits, &c)
+		case *Unlimited:
+			c := *l
+			pol.Limits = append(pol.Limits, &c)
+		}
+	}
+	return pol
 }
 
 var requiredLimitPer = []LimitPer{LimitPerTotal, LimitPerIPAddress, LimitPerAuthToken}
 
 func newLimitPolicy(resource, action string) *limitPolicy {
 	return &limitPolicy{
-		resource: resource,
-		action:   action,
-		m:        make(map[LimitPer]Limit, 3),
+		resource:   resource,
+		action:     action,
+		m:          make(map[LimitPer]Limit, 3),
+		multiplier: 1,
 	}
 }
 
@@ -47,6 +120,55 @@ func (p *limitPolicy) limit(per LimitPer) (Limit, error) {
 	return l, nil
 }
 
+// totalQuota returns the quota for the policy's LimitPerTotal limit, which
+// must be the provided limit. The quota is shared by every request for the
+// policy, so it is not locked to consume from it. It is created if it does
+// not exist, and replaced with a new quota if it has expired. When the quota
+// is replaced, the usage for the window that ended is sent to the sink, if
+// there is one.
+func (p *limitPolicy) totalQuota(l *Limited, c Clock, sink UsageSink) *Quota {
+	q := p.total.Load()
+	if q != nil && !q.Expired() {
+		return q
+	}
+	next := newTotalQuota(l, c)
+	if !p.total.CompareAndSwap(q, next) {
+		// Another request replaced the quota first.
+		return p.total.Load()
+	}
+	if q != nil && sink != nil {
+		sink(q.usage(string(LimitPerTotal)))
+	}
+	return next
+}
+
+// resetTotal replaces the policy's total quota with a new quota that uses the
+// provided limit, unless the policy does not have a total quota.
+func (p *limitPolicy) resetTotal(l *Limited, c Clock) {
+	if p.total.Load() == nil {
+		return
+	}
+	p.total.Store(newTotalQuota(l, c))
+}
+
+// restoreTotal sets the policy's total quota using the state from a
+// snapshot, unless the policy already has a total quota.
+func (p *limitPolicy) restoreTotal(l *Limited, c Clock, sq SnapshotQuota) {
+	if p.total.Load() != nil {
+		return
+	}
+	q := &Quota{clock: c, shared: true}
+	q.restore(l, sq)
+	p.total.CompareAndSwap(nil, q)
+}
+
+// newTotalQuota returns a shared quota for a LimitPerTotal limit.
+func newTotalQuota(l *Limited, c Clock) *Quota {
+	q := &Quota{clock: c, shared: true}
+	q.reset(l)
+	return q
+}
+
 func (p *limitPolicy) add(l Limit) error {
 	if err := l.validate(); err != nil {
 		return err
@@ -77,44 +199,120 @@ func (p *limitPolicy) buildStr() {
 		}
 		switch ll := l.(type) {
 		case *Limited:
-			s = append(s, fmt.Sprintf("%d;w=%d;comment=%q", ll.MaxRequests, uint64(ll.Period.Seconds()), ll.Per.String()))
+			maxReq := ll.MaxRequests
+			if p.multiplier != 1 {
+				maxReq = scaleMaxRequests(maxReq, p.multiplier)
+			}
+			s = append(s, fmt.Sprintf("%d;w=%d;comment=%q", maxReq, uint64(ll.Period.Seconds()), ll.Per.String()))
 		}
 
 	}
 
 	p.policy = strings.Join(s, ", ")
+	p.policyHeader = nil
+	if p.policy != "" {
+		p.policyHeader = []string{p.policy}[:1:1]
+	}
+}
+
+// fallback returns the Fallback of the policy's limit for per, if it is a
+// Limited limit.
+func (p *limitPolicy) fallback(per LimitPer) LimitPer {
+	ll, ok := p.m[per].(*Limited)
+	if !ok {
+		return ""
+	}
+	return ll.Fallback
 }
 
-func (p *limitPolicy) validate() error {
+// fallbackSkips returns the LimitPers of the policy's limits that are not
+// evaluated for a request with the provided identities, because of the
+// Fallback of the policy's limits. A nil map is returned if none of the limits
+// are skipped.
+func (p *limitPolicy) fallbackSkips(keys map[LimitPer]string) map[LimitPer]bool {
+	var skip map[LimitPer]bool
+	for _, per := range []LimitPer{LimitPerAuthToken, LimitPerIPAddress} {
+		f := p.fallback(per)
+		if f == "" {
+			continue
+		}
+		if skip == nil {
+			skip = make(map[LimitPer]bool, len(requiredLimitPer))
+		}
+		if keys[per] == "" {
+			// The request falls back to f.
+			skip[per] = true
+			continue
+		}
+		// The request is limited by per instead of the limits in its chain.
+		for ; f != ""; f = p.fallback(f) {
+			skip[f] = true
+		}
+	}
+	return skip
+}
+
+// validate checks if p is valid. If strict is true, p must have a limit for
+// each LimitPer.
+func (p *limitPolicy) validate(strict bool) error {
+	for _, per := range requiredLimitPer {
+		// Since there are only as many limits as LimitPers, a chain of
+		// fallbacks that is longer than that must contain a cycle.
+		f := p.fallback(per)
+		for i := 0; f != ""; i++ {
+			if i == len(requiredLimitPer) {
+				return fmt.Errorf("fallback cycle for %q: %w", per, ErrInvalidLimitPolicy)
+			}
+			f = p.fallback(f)
+		}
+	}
+
 	switch {
 	case p.resource == "":
 		return fmt.Errorf("missing resource: %w", ErrInvalidLimitPolicy)
 	case p.action == "":
 		return fmt.Errorf("missing action: %w", ErrInvalidLimitPolicy)
-	case len(p.m) != 3:
+	case strict && len(p.m) != 3:
 		for _, per := range requiredLimitPer {
 			if _, ok := p.m[per]; !ok {
-				return fmt.Errorf("mising limit for %q: %w", per, ErrInvalidLimitPolicy)
+				return fmt.Errorf("missing limit for %q: %w", per, ErrInvalidLimitPolicy)
 			}
 		}
 	}
 	return nil
 }
 
-func limitPolicyKey(resource, action string) string {
-	return join(resource, action)
+// policyKey identifies a limitPolicy. A struct is used, rather than joining
+// the resource and action, so that policies can be looked up without
+// allocating.
+type policyKey struct {
+	resource string
+	action   string
+}
+
+func limitPolicyKey(resource, action string) policyKey {
+	return policyKey{resource: resource, action: action}
+}
+
+// poolKey identifies the limits in a Pool for a LimitPer.
+type poolKey struct {
+	pool string
+	per  LimitPer
 }
 
 type limitPolicies struct {
-	m map[string]*limitPolicy
+	m map[policyKey]*limitPolicy
 
 	maxPeriod time.Duration
 }
 
-func newLimitPolicies(limits []Limit) (*limitPolicies, error) {
-	policies := make(map[string]*limitPolicy, len(limits)/3)
+// newLimitPolicies creates the limit policies for the provided limits. If
+// strict is true, each policy must have a limit for each LimitPer.
+func newLimitPolicies(limits []Limit, strict bool) (*limitPolicies, error) {
+	policies := make(map[policyKey]*limitPolicy, len(limits)/3)
 
 	var maxPeriod time.Duration
+	pools := make(map[poolKey]*Limited)
 	for _, l := range limits {
 
 		if err := l.validate(); err != nil {
@@ -133,14 +331,22 @@ func newLimitPolicies(limits []Limit) (*limitPolicies, error) {
 
 		switch ll := l.(type) {
 		case *Limited:
-			if ll.Period > maxPeriod {
-				maxPeriod = ll.Period
+			if ll.maxPeriod() > maxPeriod {
+				maxPeriod = ll.maxPeriod()
 			}
+			if ll.Pool == "" {
+				continue
+			}
+			k := poolKey{pool: ll.Pool, per: ll.Per}
+			if first, ok := pools[k]; ok && !first.sameWindow(ll) {
+				return nil, fmt.Errorf("limits in pool %q for %q have different windows: %w", ll.Pool, ll.Per, ErrInvalidLimit)
+			}
+			pools[k] = ll
 		}
 	}
 
 	for _, p := range policies {
-		if err := p.validate(); err != nil {
+		if err := p.validate(strict); err != nil {
 			return nil, err
 		}
 	}
@@ -151,11 +357,113 @@ func newLimitPolicies(limits []Limit) (*limitPolicies, error) {
 	}, nil
 }
 
+// inheritTotals copies the total quotas from the previous policies for any
+// resource and action that exists in both, so that reloading limits does not
+// reset the total quotas. The quotas will use the new limit once they expire.
+func (p *limitPolicies) inheritTotals(prev *limitPolicies) {
+	for k, pol := range p.m {
+		prevPol, ok := prev.m[k]
+		if !ok {
+			continue
+		}
+		if q := prevPol.total.Load(); q != nil {
+			pol.total.Store(q)
+		}
+	}
+}
+
+// setMultiplier sets the global multiplier of each policy, rebuilding its
+// policy header. It must be called before the policies are used by the
+// Limiter, since the policies are read without acquiring a lock.
+func (p *limitPolicies) setMultiplier(m float64) {
+	for _, pol := range p.m {
+		pol.multiplier = m
+		pol.buildStr()
+	}
+}
+
+// limitedByKey returns the Limited limits of the policies, keyed by quotaKey
+// with an empty id, so that a quota's limit can be matched to its new limit.
+func (p *limitPolicies) limitedByKey() map[string]*Limited {
+	limits := make(map[string]*Limited)
+	for _, pol := range p.m {
+		for _, l := range pol.m {
+			if ll, ok := l.(*Limited); ok {
+				limits[quotaKey(ll, "")] = ll
+			}
+		}
+	}
+	return limits
+}
+
+// shortenTotals ends the window of each policy's total quota early if its
+// limit's Period has been shortened in limits.
+func (p *limitPolicies) shortenTotals(limits map[string]*Limited) {
+	for _, pol := range p.m {
+		if q := pol.total.Load(); q != nil {
+			q.shorten(limits)
+		}
+	}
+}
+
+// export returns each of the policies as a Policy, sorted by resource and
+// then action.
+func (p *limitPolicies) export() []Policy {
+	policies := make([]Policy, 0, len(p.m))
+	for _, pol := range p.m {
+		policies = append(policies, pol.export())
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		if policies[i].Resource != policies[j].Resource {
+			return policies[i].Resource < policies[j].Resource
+		}
+		return policies[i].Action < policies[j].Action
+	})
+	return policies
+}
+
 func (p *limitPolicies) get(resource, action string) (*limitPolicy, error) {
-	polKey := limitPolicyKey(resource, action)
-	pol, ok := p.m[polKey]
+	pol, ok := p.lookup(resource, action)
 	if !ok {
 		return nil, ErrLimitPolicyNotFound
 	}
 	return pol, nil
 }
+
+// lookup returns the policy for the resource and action. If there is no such
+// policy, the policy for the resource and any action, any resource and the
+// action, or any resource and any action is returned, in that order. The
+// returned bool is false if none of these policies exist.
+func (p *limitPolicies) lookup(resource, action string) (*limitPolicy, bool) {
+	keys := [...]policyKey{
+		limitPolicyKey(resource, action),
+		limitPolicyKey(resource, Wildcard),
+		limitPolicyKey(Wildcard, action),
+		limitPolicyKey(Wildcard, Wildcard),
+	}
+	for _, k := range keys {
+		if pol, ok := p.m[k]; ok {
+			return pol, true
+		}
+	}
+	return nil, false
+}
+
+// limited returns the policy and Limited limit for the provided resource,
+// action, and LimitPer. The returned bool is false if there is no such policy,
+// or if its limit is Unlimited.
+func (p *limitPolicies) limited(resource, action string, per LimitPer) (*limitPolicy, *Limited, bool) {
+	pol, err := p.get(resource, action)
+	if err != nil {
+		return nil, nil, false
+	}
+	limit, err := pol.limit(per)
+	if err != nil {
+		return nil, nil, false
+	}
+	ll, ok := limit.(*Limited)
+	if !ok {
+		return nil, nil, false
+	}
+	return pol, ll, true
+}
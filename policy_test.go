@@ -535,11 +535,34 @@ func TestLimitPolicy_validate(t *testing.T) {
 			}(),
 			ErrInvalidLimitPolicy,
 		},
+		{
+			"FallbackCycle",
+			func() *limitPolicy {
+				lp := newLimitPolicy("resource", "action")
+				fallbacks := map[LimitPer]LimitPer{
+					LimitPerIPAddress: LimitPerAuthToken,
+					LimitPerAuthToken: LimitPerIPAddress,
+				}
+				for _, per := range []LimitPer{LimitPerTotal, LimitPerIPAddress, LimitPerAuthToken} {
+					err := lp.add(&Limited{
+						Resource:    "resource",
+						Action:      "action",
+						Per:         per,
+						MaxRequests: 10,
+						Period:      time.Minute,
+						Fallback:    fallbacks[per],
+					})
+					require.NoError(t, err)
+				}
+				return lp
+			}(),
+			ErrInvalidLimitPolicy,
+		},
 	}
 
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
-			got := tc.limitPolicy.validate()
+			got := tc.limitPolicy.validate(true)
 			if tc.expectErr != nil {
 				require.ErrorIs(t, got, tc.expectErr)
 				return
@@ -548,3 +571,78 @@ func TestLimitPolicy_validate(t *testing.T) {
 		})
 	}
 }
+
+func TestLimitPolicy_validateNotStrict(t *testing.T) {
+	for _, pers := range [][]LimitPer{
+		{LimitPerTotal},
+		{LimitPerIPAddress},
+		{LimitPerAuthToken, LimitPerIPAddress},
+	} {
+		lp := newLimitPolicy("resource", "action")
+		for _, per := range pers {
+			err := lp.add(&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         per,
+				MaxRequests: 10,
+				Period:      time.Minute,
+			})
+			require.NoError(t, err)
+		}
+		assert.NoError(t, lp.validate(false))
+		assert.ErrorIs(t, lp.validate(true), ErrInvalidLimitPolicy)
+	}
+}
+
+func TestLimitPolicy_fallbackSkips(t *testing.T) {
+	newPolicy := func(t *testing.T, fallbacks map[LimitPer]LimitPer) *limitPolicy {
+		t.Helper()
+		lp := newLimitPolicy("resource", "action")
+		for _, per := range requiredLimitPer {
+			err := lp.add(&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         per,
+				MaxRequests: 10,
+				Period:      time.Minute,
+				Fallback:    fallbacks[per],
+			})
+			require.NoError(t, err)
+		}
+		require.NoError(t, lp.validate(true))
+		return lp
+	}
+	chain := map[LimitPer]LimitPer{
+		LimitPerAuthToken: LimitPerIPAddress,
+		LimitPerIPAddress: LimitPerTotal,
+	}
+	tokenOnly := map[LimitPer]LimitPer{
+		LimitPerAuthToken: LimitPerIPAddress,
+	}
+
+	cases := []struct {
+		name      string
+		fallbacks map[LimitPer]LimitPer
+		ip        string
+		authToken string
+		want      map[LimitPer]bool
+	}{
+		{"none", nil, "127.0.0.1", "token", nil},
+		{"chain-token", chain, "127.0.0.1", "token", map[LimitPer]bool{LimitPerIPAddress: true, LimitPerTotal: true}},
+		{"chain-ip", chain, "127.0.0.1", "", map[LimitPer]bool{LimitPerAuthToken: true, LimitPerTotal: true}},
+		{"chain-empty", chain, "", "", map[LimitPer]bool{LimitPerAuthToken: true, LimitPerIPAddress: true}},
+		{"token-only-token", tokenOnly, "127.0.0.1", "token", map[LimitPer]bool{LimitPerIPAddress: true}},
+		{"token-only-ip", tokenOnly, "127.0.0.1", "", map[LimitPer]bool{LimitPerAuthToken: true}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			lp := newPolicy(t, tc.fallbacks)
+			got := lp.fallbackSkips(map[LimitPer]string{
+				LimitPerTotal:     string(LimitPerTotal),
+				LimitPerIPAddress: tc.ip,
+				LimitPerAuthToken: tc.authToken,
+			})
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// week is the length of a week without a daylight saving time transition.
+const week = 7 * day
+
+// Profile is a set of limits that replaces the Limiter's limits during the
+// windows of its Schedule, such as lower limits during a nightly maintenance
+// window. Outside of the windows of every Profile, the limits provided to
+// NewLimiter, or the last call to Reload, are used.
+type Profile struct {
+	// Name identifies the profile. It must be unique and not empty.
+	Name string
+	// Limits are the limits used during the profile's windows. They must
+	// meet the same requirements as the limits provided to NewLimiter.
+	Limits []Limit
+	// Schedule are the recurring windows during which the profile is used.
+	// At least one window is required.
+	Schedule []ProfileWindow
+}
+
+// ProfileWindow is a window of time that recurs on certain days of the week.
+type ProfileWindow struct {
+	// Days are the days of the week that the window starts on. If empty,
+	// the window starts on every day.
+	Days []time.Weekday
+	// Start is when the window starts, as the time since midnight. It must
+	// be less than 24 hours.
+	Start time.Duration
+	// Duration is how long the window lasts, which may extend past midnight.
+	// It must be greater than zero and at most a week.
+	Duration time.Duration
+	// Location is the time zone of the window. If nil, UTC is used.
+	Location *time.Location
+}
+
+func (w ProfileWindow) validate() error {
+	switch {
+	case w.Start < 0 || w.Start >= day:
+		return fmt.Errorf("window start must be within a day: %w", ErrInvalidParameter)
+	case w.Duration <= 0 || w.Duration > week:
+		return fmt.Errorf("window duration must be greater than zero and at most a week: %w", ErrInvalidParameter)
+	}
+	for _, d := range w.Days {
+		if d < time.Sunday || d > time.Saturday {
+			return fmt.Errorf("invalid window day %d: %w", d, ErrInvalidParameter)
+		}
+	}
+	return nil
+}
+
+// startsOn reports whether the window starts on the day of the week.
+func (w ProfileWindow) startsOn(d time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, wd := range w.Days {
+		if wd == d {
+			return true
+		}
+	}
+	return false
+}
+
+// at reports whether the window is active at now, and returns the first time
+// after now that the window starts or ends.
+func (w ProfileWindow) at(now time.Time) (active bool, next time.Time) {
+	loc := w.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	y, m, d := now.In(loc).Date()
+	// A window that started up to a week ago may still be active, and the
+	// next window starts within a week.
+	for i := -7; i <= 8; i++ {
+		midnight := time.Date(y, m, d+i, 0, 0, 0, 0, loc)
+		if !w.startsOn(midnight.Weekday()) {
+			continue
+		}
+		start := midnight.Add(w.Start)
+		end := start.Add(w.Duration)
+		if !now.Before(start) && now.Before(end) {
+			active = true
+		}
+		for _, t := range []time.Time{start, end} {
+			if t.After(now) && (next.IsZero() || t.Before(next)) {
+				next = t
+			}
+		}
+	}
+	return active, next
+}
+
+func (p Profile) validate() error {
+	switch {
+	case p.Name == "":
+		return fmt.Errorf("missing profile name: %w", ErrInvalidParameter)
+	case len(p.Schedule) == 0:
+		return fmt.Errorf("profile %q: missing schedule: %w", p.Name, ErrInvalidParameter)
+	case len(p.Limits) == 0:
+		return fmt.Errorf("profile %q: %w", p.Name, ErrEmptyLimits)
+	case allUnlimited(p.Limits):
+		return fmt.Errorf("profile %q: %w", p.Name, ErrAllUnlimited)
+	}
+	for _, w := range p.Schedule {
+		if err := w.validate(); err != nil {
+			return fmt.Errorf("profile %q: %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+// activeProfile returns the first of the profiles that is active at now, or
+// nil if none are, along with the first time after now that any of the
+// profiles' windows starts or ends.
+func activeProfile(profiles []Profile, now time.Time) (*Profile, time.Time) {
+	var active *Profile
+	var next time.Time
+	for i := range profiles {
+		for _, w := range profiles[i].Schedule {
+			ok, t := w.at(now)
+			if ok && active == nil {
+				active = &profiles[i]
+			}
+			if next.IsZero() || t.Before(next) {
+				next = t
+			}
+		}
+	}
+	return active, next
+}
+
+// ProfileHook is called each time the Limiter switches to the limits of a
+// Profile, or back to its default limits, in which case name is empty. If the
+// limits could not be used, err is set and the Limiter continues to use its
+// previous limits until the next time that a profile starts or ends. It is
+// called synchronously by the go routine that switches profiles.
+type ProfileHook func(name string, err error)
+
+// profileSwitcher switches the Limiter's limits to those of the active
+// Profile whenever a profile starts or ends.
+type profileSwitcher struct {
+	limiter  *Limiter
+	profiles []Profile
+	hook     ProfileHook
+
+	// base are the limits used when no profile is active, and active is the
+	// name of the profile whose limits are in use. They are guarded by the
+	// Limiter's reloadMu.
+	base   []Limit
+	active string
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// newProfileSwitcher switches the Limiter's limits to those of the profile
+// that is active now, if any, and then starts switching them as profiles
+// start and end.
+func newProfileSwitcher(l *Limiter, profiles []Profile, hook ProfileHook) (*profileSwitcher, error) {
+	const op = "rate.newProfileSwitcher"
+
+	p := &profileSwitcher{
+		limiter:  l,
+		profiles: profiles,
+		hook:     hook,
+		base:     l.limits,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if _, err := p.apply(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	go p.run()
+	return p, nil
+}
+
+// run switches profiles each time a profile starts or ends, until the
+// profileSwitcher is stopped.
+func (p *profileSwitcher) run() {
+	defer close(p.done)
+	for {
+		next, _ := p.apply()
+		select {
+		case <-p.stop:
+			return
+		case <-p.limiter.clock.After(next.Sub(p.limiter.clock.Now())):
+		}
+	}
+}
+
+// apply switches the Limiter's limits to those of the active profile, or to
+// the base limits, if they are not already in use. It returns when a profile
+// next starts or ends.
+func (p *profileSwitcher) apply() (time.Time, error) {
+	l := p.limiter
+	profile, next := activeProfile(p.profiles, l.clock.Now())
+
+	l.reloadMu.Lock()
+	name, limits := "", p.base
+	if profile != nil {
+		name, limits = profile.Name, profile.Limits
+	}
+	if name == p.active {
+		l.reloadMu.Unlock()
+		return next, nil
+	}
+	err := l.reload(limits, l.classes)
+	if err == nil {
+		p.active = name
+	}
+	l.reloadMu.Unlock()
+
+	if p.hook != nil {
+		p.hook(name, err)
+	}
+	return next, err
+}
+
+// shutdown stops switching profiles.
+func (p *profileSwitcher) shutdown() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+		<-p.done
+	})
+}
+
+// ActiveProfile returns the name of the Profile whose limits are in use, or an
+// empty string if the Limiter's default limits are in use.
+func (l *Limiter) ActiveProfile() string {
+	if l.profiles == nil {
+		return ""
+	}
+	l.reloadMu.Lock()
+	defer l.reloadMu.Unlock()
+	return l.profiles.active
+}
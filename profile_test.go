@@ -0,0 +1,195 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfileWindowAt(t *testing.T) {
+	// 2023-01-01 is a Sunday.
+	at := func(day, hour int) time.Time {
+		return time.Date(2023, 1, day, hour, 0, 0, 0, time.UTC)
+	}
+	nightly := ProfileWindow{Start: 22 * time.Hour, Duration: 4 * time.Hour}
+	weekdays := ProfileWindow{
+		Days:     []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+		Start:    9 * time.Hour,
+		Duration: 8 * time.Hour,
+	}
+
+	cases := []struct {
+		name         string
+		window       ProfileWindow
+		now          time.Time
+		expectActive bool
+		expectNext   time.Time
+	}{
+		{"BeforeStart", nightly, at(1, 12), false, at(1, 22)},
+		{"Start", nightly, at(1, 22), true, at(2, 2)},
+		{"AfterMidnight", nightly, at(2, 1), true, at(2, 2)},
+		{"End", nightly, at(2, 2), false, at(2, 22)},
+		{"Weekend", weekdays, at(1, 10), false, at(2, 9)},
+		{"Weekday", weekdays, at(2, 10), true, at(2, 17)},
+		{"Friday", weekdays, at(6, 17), false, at(9, 9)},
+		{
+			"Location",
+			ProfileWindow{Start: 22 * time.Hour, Duration: time.Hour, Location: time.FixedZone("UTC+2", 2*60*60)},
+			at(1, 20),
+			true,
+			at(1, 21),
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			active, next := tc.window.at(tc.now)
+			assert.Equal(t, tc.expectActive, active)
+			assert.True(t, tc.expectNext.Equal(next), "expected %s, got %s", tc.expectNext, next)
+		})
+	}
+}
+
+func TestProfileValidate(t *testing.T) {
+	limits := []Limit{
+		&Limited{Resource: "resource", Action: "action", Per: LimitPerTotal, MaxRequests: 1, Period: time.Minute},
+	}
+	window := ProfileWindow{Start: time.Hour, Duration: time.Hour}
+
+	assert.NoError(t, Profile{Name: "p", Limits: limits, Schedule: []ProfileWindow{window}}.validate())
+	assert.ErrorIs(t, Profile{Limits: limits, Schedule: []ProfileWindow{window}}.validate(), ErrInvalidParameter)
+	assert.ErrorIs(t, Profile{Name: "p", Limits: limits}.validate(), ErrInvalidParameter)
+	assert.ErrorIs(t, Profile{Name: "p", Schedule: []ProfileWindow{window}}.validate(), ErrEmptyLimits)
+	assert.ErrorIs(t, Profile{
+		Name:     "p",
+		Limits:   []Limit{&Unlimited{Resource: "resource", Action: "action", Per: LimitPerTotal}},
+		Schedule: []ProfileWindow{window},
+	}.validate(), ErrAllUnlimited)
+
+	for _, w := range []ProfileWindow{
+		{Start: -time.Hour, Duration: time.Hour},
+		{Start: 24 * time.Hour, Duration: time.Hour},
+		{Start: time.Hour},
+		{Start: time.Hour, Duration: 8 * 24 * time.Hour},
+		{Start: time.Hour, Duration: time.Hour, Days: []time.Weekday{7}},
+	} {
+		assert.ErrorIs(t, Profile{Name: "p", Limits: limits, Schedule: []ProfileWindow{w}}.validate(), ErrInvalidParameter)
+	}
+}
+
+func TestActiveProfile(t *testing.T) {
+	now := time.Date(2023, 1, 1, 3, 0, 0, 0, time.UTC)
+	profiles := []Profile{
+		{Name: "first", Schedule: []ProfileWindow{{Start: 2 * time.Hour, Duration: 4 * time.Hour}}},
+		{Name: "second", Schedule: []ProfileWindow{{Start: time.Hour, Duration: 3 * time.Hour}}},
+	}
+
+	p, next := activeProfile(profiles, now)
+	require.NotNil(t, p)
+	assert.Equal(t, "first", p.Name)
+	assert.Equal(t, now.Add(time.Hour), next)
+
+	p, next = activeProfile(profiles, now.Add(4*time.Hour))
+	assert.Nil(t, p)
+	assert.Equal(t, now.Add(22*time.Hour), next)
+}
+
+func TestLimiterProfiles(t *testing.T) {
+	limits := func(maxRequests uint64) []Limit {
+		return []Limit{
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 100,
+				Period:      time.Minute,
+			},
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerIPAddress,
+				MaxRequests: maxRequests,
+				Period:      time.Minute,
+			},
+			&Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      LimitPerAuthToken,
+			},
+		}
+	}
+	maintenance := Profile{
+		Name:     "maintenance",
+		Limits:   limits(1),
+		Schedule: []ProfileWindow{{Start: 2 * time.Hour, Duration: 2 * time.Hour}},
+	}
+	maxRequests := func(t *testing.T, l *Limiter) uint64 {
+		t.Helper()
+		_, ll, ok := l.policies.Load().limited("resource", "action", LimitPerIPAddress)
+		require.True(t, ok)
+		return ll.MaxRequests
+	}
+
+	t.Run("Switch", func(t *testing.T) {
+		c := newFakeClock()
+		var mu sync.Mutex
+		var switched []string
+		l, err := NewLimiter(limits(10), 10,
+			WithClock(c),
+			WithProfiles(maintenance),
+			WithProfileHook(func(name string, err error) {
+				assert.NoError(t, err)
+				mu.Lock()
+				defer mu.Unlock()
+				switched = append(switched, name)
+			}),
+		)
+		require.NoError(t, err)
+		defer l.Shutdown()
+		assert.Equal(t, "", l.ActiveProfile())
+		assert.Equal(t, uint64(10), maxRequests(t, l))
+
+		c.Advance(2 * time.Hour)
+		require.Eventually(t, func() bool { return l.ActiveProfile() == "maintenance" }, time.Second, time.Millisecond)
+		assert.Equal(t, uint64(1), maxRequests(t, l))
+
+		// Reloading while the profile is active replaces the default limits,
+		// which are used once it ends.
+		require.NoError(t, l.Reload(limits(20)))
+		assert.Equal(t, uint64(1), maxRequests(t, l))
+		require.ErrorIs(t, l.Reload([]Limit{}), ErrEmptyLimits)
+
+		c.Advance(2 * time.Hour)
+		require.Eventually(t, func() bool { return l.ActiveProfile() == "" }, time.Second, time.Millisecond)
+		assert.Equal(t, uint64(20), maxRequests(t, l))
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, []string{"maintenance", ""}, switched)
+	})
+
+	t.Run("ActiveAtCreation", func(t *testing.T) {
+		c := newFakeClock()
+		c.Advance(3 * time.Hour)
+		l, err := NewLimiter(limits(10), 10, WithClock(c), WithProfiles(maintenance))
+		require.NoError(t, err)
+		defer l.Shutdown()
+		assert.Equal(t, "maintenance", l.ActiveProfile())
+		assert.Equal(t, uint64(1), maxRequests(t, l))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := NewLimiter(limits(10), 10, WithProfiles(maintenance, maintenance))
+		assert.ErrorIs(t, err, ErrInvalidParameter)
+
+		invalid := maintenance
+		invalid.Limits = limits(10)[:2]
+		_, err = NewLimiter(limits(10), 10, WithStrictPolicies(true), WithProfiles(invalid))
+		assert.ErrorIs(t, err, ErrInvalidLimitPolicy)
+	})
+}
@@ -4,75 +4,575 @@
 package rate
 
 import (
+	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// QuotaSnapshot is a point-in-time copy of the state of a quota, as returned
+// by Limiter.QuotaFor.
+type QuotaSnapshot struct {
+	Resource string
+	Action   string
+	Per      LimitPer
+	// ID is the IP address or auth token for LimitPerIPAddress and
+	// LimitPerAuthToken, and "total" for LimitPerTotal.
+	ID          string
+	MaxRequests uint64
+	Remaining   uint64
+	GraceUsed   uint64
+	// ExpiresAt is the time that the quota resets, without a monotonic clock
+	// reading.
+	ExpiresAt time.Time
+}
+
+// quotaEpoch is the time that the expiration of each Quota is measured from.
+// Since it has a monotonic clock reading, so do the expirations of quotas
+// that use the system time, and they are not affected by changes to the wall
+// clock.
+var quotaEpoch = time.Now()
+
+// quotaTime returns t as the number of nanoseconds since quotaEpoch, which is
+// how the expiration of a Quota is stored. If t does not have a monotonic
+// clock reading, it is measured using the wall clock instead. The zero time
+// is returned as zero, so that the expiration of the zero Quota is the zero
+// time.
+func quotaTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return int64(t.Sub(quotaEpoch))
+}
+
 // Quota tracks the remaining number of requests that can be made within a time
 // period.
+//
+// The fields are ordered so that a Quota has no padding between them, and it
+// uses 96 bytes on 64-bit platforms, which is a size class of the Go
+// allocator. Storing expiresAt as an int64 rather than a time.Time saves 16
+// bytes per Quota. A Quota is stored along with an entry, which uses 48
+// bytes, and a key, so that each quota stored by the Limiter uses 144 bytes
+// plus the length of its key rounded up to a size class, and 16 bytes per
+// slot of the tables used to look it up. used and expiresAt are first so that
+// they are 64-bit aligned for atomic operations on 32-bit platforms.
 type Quota struct {
-	limit     *Limited
-	used      uint64
-	expiresAt time.Time
+	// used is the number of requests made during the current window. It is
+	// only accessed atomically, so that requests can be consumed and refunded
+	// without acquiring the lock.
+	used uint64
+	// expiresAt is when the quota expires, as returned by quotaTime. It is
+	// only accessed atomically, so that it can be read without acquiring the
+	// lock when the quota is shared.
+	expiresAt int64
 
 	mu sync.RWMutex
+
+	limit *Limited
+	// clock is used to get the current time. If nil, the system time is used.
+	clock Clock
+	// jitter is the additional time added to the limit's Period when the
+	// quota was last reset.
+	jitter time.Duration
+	// warmUp is the fraction of the limit's MaxRequests that is available
+	// for the current window. A value of zero indicates that the full
+	// MaxRequests is available.
+	warmUp float64
+	// risk is the bits of a multiplier applied to the limit's MaxRequests
+	// that is provided by a RiskMultiplier and the Limiter's global
+	// multiplier. It is only applied if it is not zero.
+	risk atomic.Uint64
+	// shared is whether the quota is shared by every request for a policy, as
+	// with the quota for a LimitPerTotal limit. The limit, jitter, and warmUp
+	// of a shared quota are not modified once it is in use, and used,
+	// expiresAt, and risk are modified atomically, so that its state can be
+	// read without acquiring the lock. A shared quota is replaced rather
+	// than reset.
+	shared bool
+}
+
+// rlock acquires a read lock on the quota, unless it is shared.
+func (q *Quota) rlock() {
+	if !q.shared {
+		q.mu.RLock()
+	}
+}
+
+// runlock releases the read lock acquired by rlock.
+func (q *Quota) runlock() {
+	if !q.shared {
+		q.mu.RUnlock()
+	}
+}
+
+// loadUsed returns the number of requests made during the current window.
+func (q *Quota) loadUsed() uint64 {
+	return atomic.LoadUint64(&q.used)
+}
+
+// loadExpiresAt returns when the quota expires, as returned by quotaTime.
+func (q *Quota) loadExpiresAt() int64 {
+	return atomic.LoadInt64(&q.expiresAt)
 }
 
 func (q *Quota) reset(l *Limited) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
+	q.resetLocked(l)
+}
 
-	q.used = 0
-	q.expiresAt = time.Now().Add(l.Period)
+// resetLocked resets the quota using the provided limit.
+//
+// resetLocked should always be called by a function that first acquires a lock
+func (q *Quota) resetLocked(l *Limited) {
+	atomic.StoreUint64(&q.used, 0)
+	q.warmUp = 0
+	q.risk.Store(0)
+	q.jitter = l.jitter()
+	atomic.StoreInt64(&q.expiresAt, quotaTime(l.expiration(q.now(), q.jitter)))
 	q.limit = l
 }
 
+// resetIfExpired resets the quota using the provided limit if it has
+// expired. Unlike checking Expired and then calling reset, this ensures that
+// a quota is only reset once if it is shared by concurrent requests. If the
+// quota was reset, the usage for the window that ended is returned.
+func (q *Quota) resetIfExpired(l *Limited, id string) (UsageRecord, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.expiredLocked(q.now()) {
+		return UsageRecord{}, false
+	}
+	r := q.usageLocked(id)
+	q.resetLocked(l)
+	return r, true
+}
+
+// snapshot returns the state of the quota for a snapshot.
+func (q *Quota) snapshot(id string) SnapshotQuota {
+	q.rlock()
+	defer q.runlock()
+	return SnapshotQuota{
+		Resource:  q.limit.Resource,
+		Action:    q.limit.Action,
+		Per:       q.limit.Per,
+		ID:        id,
+		Used:      q.loadUsed(),
+		ExpiresAt: q.expirationLocked(q.now()),
+		Jitter:    q.jitter,
+	}
+}
+
+// quotaSnapshot returns a point-in-time copy of the state of the quota.
+func (q *Quota) quotaSnapshot(id string) QuotaSnapshot {
+	q.rlock()
+	defer q.runlock()
+	s := QuotaSnapshot{
+		Resource:    q.limit.Resource,
+		Action:      q.limit.Action,
+		Per:         q.limit.Per,
+		ID:          id,
+		MaxRequests: q.maxRequests(),
+		ExpiresAt:   q.expirationLocked(q.now()).Round(0),
+	}
+	if used := q.loadUsed(); used > s.MaxRequests {
+		s.GraceUsed = used - s.MaxRequests
+	} else {
+		s.Remaining = s.MaxRequests - used
+	}
+	return s
+}
+
+// headerState is the state of a quota that is reported by the rate limit
+// usage headers.
+type headerState struct {
+	per                LimitPer
+	maxRequests        uint64
+	remaining          uint64
+	remainingWithGrace uint64
+	graceUsed          uint64
+	expiresAt          time.Time
+	now                time.Time
+}
+
+// headerState returns the state of the quota that is reported by the rate
+// limit usage headers, acquiring the quota's lock once rather than for each
+// value.
+func (q *Quota) headerState() headerState {
+	q.rlock()
+	defer q.runlock()
+	now := q.now()
+	s := headerState{
+		per:                q.limit.Per,
+		maxRequests:        q.maxRequests(),
+		remainingWithGrace: q.remainingWithGraceLocked(),
+		expiresAt:          q.expirationLocked(now),
+		now:                now,
+	}
+	if used := q.loadUsed(); used > s.maxRequests {
+		s.graceUsed = used - s.maxRequests
+	} else {
+		s.remaining = s.maxRequests - used
+	}
+	return s
+}
+
+// period returns the Period of the quota's limit.
+func (q *Quota) period() time.Duration {
+	q.rlock()
+	defer q.runlock()
+	return q.limit.Period
+}
+
+// restore resets the quota using the provided limit, and then sets its state
+// from the snapshot. The expiration is limited to the max period of the limit
+// from now, in case the limit's period has been reduced.
+func (q *Quota) restore(l *Limited, sq SnapshotQuota) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.resetLocked(l)
+	atomic.StoreUint64(&q.used, sq.Used)
+	q.jitter = sq.Jitter
+
+	// The snapshot's expiration only has a wall clock reading, so it is
+	// converted to a time relative to now. This allows the expiration to use
+	// now's monotonic clock reading, if it has one.
+	now := q.now()
+	ttl := sq.ExpiresAt.Sub(now)
+	if maxTTL := l.maxPeriod(); ttl > maxTTL {
+		ttl = maxTTL
+	}
+	atomic.StoreInt64(&q.expiresAt, quotaTime(now.Add(ttl)))
+}
+
+// extendTo sets the quota to expire at t.
+func (q *Quota) extendTo(t time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	atomic.StoreInt64(&q.expiresAt, quotaTime(t))
+}
+
+// shorten ends the quota's current window early if the limit with the same
+// key in limits has a shorter Period than the quota's limit, so that the
+// window ends when it would have if it had started with the new limit. The
+// quota keeps using its limit for the rest of the window. It returns the new
+// expiration, and whether it was changed.
+func (q *Quota) shorten(limits map[string]*Limited) (time.Time, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	l, ok := limits[quotaKey(q.limit, "")]
+	now := q.now()
+	if !ok || l.Period >= q.limit.Period || q.expiredLocked(now) {
+		return time.Time{}, false
+	}
+	current := q.expirationLocked(now)
+	start := current.Add(-(q.limit.Period + q.jitter))
+	expiresAt := l.expiration(start, q.jitter)
+	if maxExpiresAt := start.Add(l.maxPeriod()); expiresAt.After(maxExpiresAt) {
+		expiresAt = maxExpiresAt
+	}
+	if !expiresAt.Before(current) {
+		return time.Time{}, false
+	}
+	atomic.StoreInt64(&q.expiresAt, quotaTime(expiresAt))
+	return expiresAt, true
+}
+
+// clone returns a copy of the quota that is not modified when q is.
+func (q *Quota) clone() *Quota {
+	q.rlock()
+	defer q.runlock()
+	c := &Quota{
+		used:      q.loadUsed(),
+		expiresAt: q.loadExpiresAt(),
+		limit:     q.limit,
+		clock:     q.clock,
+		jitter:    q.jitter,
+		warmUp:    q.warmUp,
+	}
+	c.risk.Store(q.risk.Load())
+	return c
+}
+
+// usage returns the usage of the quota for its current window.
+func (q *Quota) usage(id string) UsageRecord {
+	q.rlock()
+	defer q.runlock()
+	return q.usageLocked(id)
+}
+
+// usageLocked returns the usage of the quota for its current window.
+//
+// usageLocked should always be called by a function that first acquires a lock
+func (q *Quota) usageLocked(id string) UsageRecord {
+	expiresAt := q.expirationLocked(q.now())
+	return UsageRecord{
+		Resource:    q.limit.Resource,
+		Action:      q.limit.Action,
+		Per:         q.limit.Per,
+		ID:          id,
+		Used:        q.loadUsed(),
+		WindowStart: expiresAt.Add(-(q.limit.Period + q.jitter)),
+		WindowEnd:   expiresAt,
+	}
+}
+
+// now returns the current time using the quota's clock.
+func (q *Quota) now() time.Time {
+	if q.clock == nil {
+		return time.Now()
+	}
+	return q.clock.Now()
+}
+
+// expiredLocked checks if the quota has expired as of now. The zero Quota
+// has always expired.
+//
+// expiredLocked should always be called by a function that first acquires a lock
+func (q *Quota) expiredLocked(now time.Time) bool {
+	expiresAt := q.loadExpiresAt()
+	return expiresAt == 0 || quotaTime(now) > expiresAt
+}
+
+// expirationLocked returns the time that the quota will expire, relative to
+// now so that it has now's location and monotonic clock reading, if it has
+// one.
+//
+// expirationLocked should always be called by a function that first acquires a lock
+func (q *Quota) expirationLocked(now time.Time) time.Time {
+	expiresAt := q.loadExpiresAt()
+	if expiresAt == 0 {
+		return time.Time{}
+	}
+	return now.Add(time.Duration(expiresAt - quotaTime(now)))
+}
+
 // Expired checks if the quota has expired.
 func (q *Quota) Expired() bool {
-	q.mu.RLock()
-	defer q.mu.RUnlock()
-	return time.Now().After(q.expiresAt)
+	q.rlock()
+	defer q.runlock()
+	return q.expiredLocked(q.now())
 }
 
 // Remaining is the number of requests that can be made prior to the quota
 // expiring. If this returns zero, the request should not be allowed.
 func (q *Quota) Remaining() uint64 {
-	q.mu.RLock()
-	defer q.mu.RUnlock()
+	q.rlock()
+	defer q.runlock()
 
-	used := q.used
-	if used > q.limit.MaxRequests {
+	used := q.loadUsed()
+	maxReq := q.maxRequests()
+	if used > maxReq {
 		return 0
 	}
-	return q.limit.MaxRequests - used
+	return maxReq - used
+}
+
+// GraceUsed returns the number of requests that have been made beyond
+// MaxRequests during the current window, using the limit's GraceRequests.
+func (q *Quota) GraceUsed() uint64 {
+	q.rlock()
+	defer q.runlock()
+
+	maxReq := q.maxRequests()
+	if used := q.loadUsed(); used > maxReq {
+		return used - maxReq
+	}
+	return 0
+}
+
+// remainingWithGrace is the number of requests that can be made prior to the
+// quota expiring, including the limit's GraceRequests.
+func (q *Quota) remainingWithGrace() uint64 {
+	q.rlock()
+	defer q.runlock()
+	return q.remainingWithGraceLocked()
+}
+
+// remainingWithGraceLocked is the number of requests that can be made prior
+// to the quota expiring, including the limit's GraceRequests.
+//
+// remainingWithGraceLocked should always be called by a function that first acquires a lock
+func (q *Quota) remainingWithGraceLocked() uint64 {
+	maxReq := q.maxRequests()
+	grace := q.limit.GraceRequests
+	if maxReq+grace < maxReq {
+		// overflow
+		grace = math.MaxUint64 - maxReq
+	}
+	used := q.loadUsed()
+	if used >= maxReq+grace {
+		return 0
+	}
+	return maxReq + grace - used
+}
+
+// graceRequest returns a GraceRequest describing the grace requests used by
+// the quota with the provided id.
+func (q *Quota) graceRequest(id string) GraceRequest {
+	q.rlock()
+	defer q.runlock()
+
+	r := GraceRequest{
+		Resource:       q.limit.Resource,
+		Action:         q.limit.Action,
+		Per:            q.limit.Per,
+		ID:             id,
+		GraceRemaining: q.remainingWithGraceLocked(),
+	}
+	if maxReq, used := q.maxRequests(), q.loadUsed(); used > maxReq {
+		r.GraceUsed = used - maxReq
+	}
+	return r
 }
 
 // MaxRequests returns the maximum number of requests that can be made for
 // this Quota.
 func (q *Quota) MaxRequests() uint64 {
-	q.mu.RLock()
-	defer q.mu.RUnlock()
+	q.rlock()
+	defer q.runlock()
 
-	return q.limit.MaxRequests
+	return q.maxRequests()
+}
+
+// maxRequests returns the effective maximum number of requests for the
+// current window.
+//
+// maxRequests should always be called by a function that first acquires a lock
+func (q *Quota) maxRequests() uint64 {
+	maxReq := q.limit.MaxRequests
+	if q.warmUp > 0 && q.warmUp < 1 {
+		maxReq = uint64(float64(maxReq) * q.warmUp)
+		if maxReq == 0 {
+			maxReq = 1
+		}
+	}
+	if risk := q.risk.Load(); risk != 0 {
+		maxReq = scaleMaxRequests(maxReq, math.Float64frombits(risk))
+	}
+	return maxReq
+}
+
+// scaleMaxRequests returns maxReq multiplied by m, rounded down. If the result
+// is not a number, maxReq is returned unscaled.
+func scaleMaxRequests(maxReq uint64, m float64) uint64 {
+	switch v := float64(maxReq) * m; {
+	case math.IsNaN(v):
+		return maxReq
+	case v <= 0:
+		return 0
+	case v >= math.MaxUint64:
+		return math.MaxUint64
+	default:
+		return uint64(v)
+	}
+}
+
+// setRisk sets the multiplier applied to the limit's MaxRequests, which is the
+// product of the RiskMultiplier and the global multiplier.
+func (q *Quota) setRisk(m float64) {
+	q.risk.Store(math.Float64bits(m))
+}
+
+// setWarmUp sets the fraction of MaxRequests that is available for the
+// current window.
+func (q *Quota) setWarmUp(f float64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.warmUp = f
 }
 
 // ResetsIn returns the amount of time before the quota will expire.
 func (q *Quota) ResetsIn() time.Duration {
-	q.mu.RLock()
-	defer q.mu.RUnlock()
-	return q.expiresAt.Sub(time.Now())
+	q.rlock()
+	defer q.runlock()
+	now := q.now()
+	return q.expirationLocked(now).Sub(now)
 }
 
-// Expiration returns the time that the quota will expire.
+// Expiration returns the time that the quota will expire. The returned time
+// does not include a monotonic clock reading, so it is suitable for reporting
+// the wall clock time that the quota resets. Use ResetsIn to determine how long
+// until the quota expires.
 func (q *Quota) Expiration() time.Time {
-	q.mu.RLock()
-	defer q.mu.RUnlock()
-	return q.expiresAt
+	q.rlock()
+	defer q.runlock()
+	return q.expirationLocked(q.now()).Round(0)
+}
+
+// windowEnd returns when the quota's current window ends, as returned by
+// quotaTime, which identifies the window since it changes whenever the quota
+// is reset.
+func (q *Quota) windowEnd() int64 {
+	q.rlock()
+	defer q.runlock()
+	return q.loadExpiresAt()
+}
+
+// expiration returns the time that the quota will expire, including the
+// monotonic clock reading if it has one.
+func (q *Quota) expiration() time.Time {
+	q.rlock()
+	defer q.runlock()
+	return q.expirationLocked(q.now())
+}
+
+// ProjectedExhaustion estimates when the quota will be exhausted if requests
+// continue to be made at the same rate as they have been since the quota was
+// last reset. A zero time is returned if no requests have been made, or if
+// the quota is not projected to be exhausted before it expires.
+func (q *Quota) ProjectedExhaustion() time.Time {
+	q.rlock()
+	defer q.runlock()
+
+	now := q.now()
+	maxReq, used := q.maxRequests(), q.loadUsed()
+	if used >= maxReq {
+		return now
+	}
+	if used == 0 {
+		return time.Time{}
+	}
+
+	expiresIn := time.Duration(q.loadExpiresAt() - quotaTime(now))
+	elapsed := q.limit.Period + q.jitter - expiresIn
+	if elapsed <= 0 {
+		return time.Time{}
+	}
+	remaining := maxReq - used
+	exhaustIn := float64(elapsed) / float64(used) * float64(remaining)
+	if exhaustIn > float64(expiresIn) {
+		return time.Time{}
+	}
+	return now.Add(time.Duration(exhaustIn))
+}
+
+// merge adds the requests used by the provided Quota to this Quota.
+func (q *Quota) merge(o *Quota) {
+	atomic.AddUint64(&q.used, o.loadUsed())
 }
 
 // Consume reduces the quota's remaining requests by one.
 func (q *Quota) Consume() {
-	q.mu.Lock()
-	defer q.mu.Unlock()
-	q.used++
+	q.consumeN(1)
+}
+
+// refund increases the quota's remaining requests by n, up to the number of
+// requests that have been used.
+func (q *Quota) refund(n uint64) {
+	for {
+		used := q.loadUsed()
+		r := n
+		if r > used {
+			r = used
+		}
+		if atomic.CompareAndSwapUint64(&q.used, used, used-r) {
+			return
+		}
+	}
+}
+
+// consumeN reduces the quota's remaining requests by n.
+func (q *Quota) consumeN(n uint64) {
+	atomic.AddUint64(&q.used, n)
 }
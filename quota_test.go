@@ -4,9 +4,12 @@
 package rate
 
 import (
+	"fmt"
 	"math"
+	"strings"
 	"testing"
 	"time"
+	"unsafe"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -44,6 +47,24 @@ func TestQuota_reset(t *testing.T) {
 	assert.Equal(t, uint64(50), q.MaxRequests())
 }
 
+func TestQuota_resetJitter(t *testing.T) {
+	l := &Limited{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerTotal,
+		MaxRequests: 10,
+		Period:      time.Minute,
+		Jitter:      0.5,
+	}
+	q := &Quota{}
+	for i := 0; i < 100; i++ {
+		q.reset(l)
+		assert.GreaterOrEqual(t, q.jitter, time.Duration(0))
+		assert.Less(t, q.jitter, 30*time.Second)
+		assert.WithinDuration(t, time.Now().Add(l.Period+q.jitter), q.Expiration(), time.Second)
+	}
+}
+
 func TestQuotaConsume(t *testing.T) {
 	l := &Limited{
 		Resource:    "resource",
@@ -162,3 +183,177 @@ func TestQuotaRemaining(t *testing.T) {
 		})
 	}
 }
+
+func TestQuotaProjectedExhaustion(t *testing.T) {
+	cases := []struct {
+		name      string
+		used      uint64
+		resetsIn  time.Duration
+		wantZero  bool
+		wantAbout time.Duration
+	}{
+		{
+			"noneUsed",
+			0,
+			30 * time.Second,
+			true,
+			0,
+		},
+		{
+			"afterReset",
+			10,
+			30 * time.Second,
+			true,
+			0,
+		},
+		{
+			"beforeReset",
+			40,
+			30 * time.Second,
+			false,
+			7500 * time.Millisecond,
+		},
+		{
+			"exhausted",
+			50,
+			30 * time.Second,
+			false,
+			0,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := &Quota{
+				limit: &Limited{
+					Resource:    "resource",
+					Action:      "action",
+					Per:         LimitPerTotal,
+					MaxRequests: 50,
+					Period:      time.Minute,
+				},
+				used:      tc.used,
+				expiresAt: quotaTime(time.Now().Add(tc.resetsIn)),
+			}
+			got := q.ProjectedExhaustion()
+			if tc.wantZero {
+				assert.True(t, got.IsZero())
+				return
+			}
+			assert.WithinDuration(t, time.Now().Add(tc.wantAbout), got, 100*time.Millisecond)
+		})
+	}
+}
+
+// hasMonotonic reports whether t includes a monotonic clock reading.
+func hasMonotonic(t time.Time) bool {
+	return strings.Contains(t.String(), " m=")
+}
+
+func TestQuotaMonotonicExpiration(t *testing.T) {
+	l := &Limited{
+		Resource:    "resource",
+		Action:      "action",
+		Per:         LimitPerTotal,
+		MaxRequests: 10,
+		Period:      time.Minute,
+	}
+
+	t.Run("reset", func(t *testing.T) {
+		q := &Quota{}
+		q.reset(l)
+		assert.True(t, hasMonotonic(q.expiration()))
+		assert.False(t, hasMonotonic(q.Expiration()))
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		// A snapshot only includes the wall clock time.
+		expiresAt := time.Now().Add(30 * time.Second).Round(0)
+		require.False(t, hasMonotonic(expiresAt))
+
+		q := &Quota{}
+		q.restore(l, SnapshotQuota{Used: 5, ExpiresAt: expiresAt})
+		assert.True(t, hasMonotonic(q.expiration()))
+		assert.WithinDuration(t, expiresAt, q.Expiration(), time.Second)
+		assert.Equal(t, uint64(5), q.Remaining())
+	})
+
+	t.Run("restore-capped", func(t *testing.T) {
+		c := newFakeClock()
+		q := &Quota{clock: c}
+		q.restore(l, SnapshotQuota{ExpiresAt: c.Now().Add(time.Hour)})
+		assert.Equal(t, c.Now().Add(time.Minute), q.Expiration())
+	})
+}
+
+func TestQuotaGrace(t *testing.T) {
+	l := &Limited{
+		Resource:      "resource",
+		Action:        "action",
+		Per:           LimitPerIPAddress,
+		MaxRequests:   2,
+		Period:        time.Minute,
+		GraceRequests: 2,
+	}
+	q := &Quota{}
+	q.reset(l)
+
+	cases := []struct {
+		used              uint64
+		wantRemaining     uint64
+		wantWithGrace     uint64
+		wantGraceUsed     uint64
+		wantGraceRequests GraceRequest
+	}{
+		{0, 2, 4, 0, GraceRequest{GraceRemaining: 4}},
+		{2, 0, 2, 0, GraceRequest{GraceRemaining: 2}},
+		{3, 0, 1, 1, GraceRequest{GraceUsed: 1, GraceRemaining: 1}},
+		{4, 0, 0, 2, GraceRequest{GraceUsed: 2, GraceRemaining: 0}},
+		{5, 0, 0, 3, GraceRequest{GraceUsed: 3, GraceRemaining: 0}},
+	}
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("used-%d", tc.used), func(t *testing.T) {
+			q.used = tc.used
+			assert.Equal(t, tc.wantRemaining, q.Remaining())
+			assert.Equal(t, tc.wantWithGrace, q.remainingWithGrace())
+			assert.Equal(t, tc.wantGraceUsed, q.GraceUsed())
+
+			want := tc.wantGraceRequests
+			want.Resource = "resource"
+			want.Action = "action"
+			want.Per = LimitPerIPAddress
+			want.ID = "127.0.0.1"
+			assert.Equal(t, want, q.graceRequest("127.0.0.1"))
+		})
+	}
+
+	t.Run("overflow", func(t *testing.T) {
+		q := &Quota{}
+		q.reset(&Limited{MaxRequests: math.MaxUint64 - 1, Period: time.Minute, GraceRequests: 10})
+		assert.Equal(t, uint64(math.MaxUint64), q.remainingWithGrace())
+	})
+}
+
+// TestQuotaSize checks the documented sizes of a Quota and entry, which
+// determine the memory used for each quota that is stored.
+func TestQuotaSize(t *testing.T) {
+	if unsafe.Sizeof(uintptr(0)) != 8 {
+		t.Skip("sizes are documented for 64-bit platforms")
+	}
+	assert.Equal(t, uintptr(96), unsafe.Sizeof(Quota{}))
+	assert.Equal(t, uintptr(48), unsafe.Sizeof(entry{}))
+}
+
+func TestQuotaZeroExpiration(t *testing.T) {
+	c := newFakeClock()
+	q := &Quota{clock: c}
+	assert.True(t, q.Expiration().IsZero())
+	assert.True(t, q.Expired())
+
+	q.extendTo(c.Now().Add(time.Minute))
+	assert.Equal(t, c.Now().Add(time.Minute), q.Expiration())
+	assert.Equal(t, time.Minute, q.ResetsIn())
+	assert.False(t, q.Expired())
+	c.Advance(time.Minute + time.Nanosecond)
+	assert.True(t, q.Expired())
+}
@@ -0,0 +1,326 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratecli
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-rate"
+	"github.com/hashicorp/go-rate/rateconfig"
+)
+
+// ValidateCommand returns a command that checks that documents of limits can
+// be used by a Limiter.
+func ValidateCommand() *Command {
+	return &Command{
+		Use:   "validate FILE...",
+		Short: "Validate documents of limits",
+		Long: "Validate checks that each document of limits can be read and used " +
+			"by a Limiter, and reports each document that cannot.",
+		Flags: newFlagSet("validate"),
+		RunE: func(w io.Writer, args []string) error {
+			const op = "ratecli.validate"
+			if err := checkArgs(args, 1, -1); err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+
+			var errs []error
+			for _, path := range args {
+				l, _, err := newLimiter(path)
+				if err != nil {
+					fmt.Fprintf(w, "%s: %s\n", path, err)
+					errs = append(errs, fmt.Errorf("%s: %w", path, err))
+					continue
+				}
+				if err := l.Shutdown(); err != nil {
+					return fmt.Errorf("%s: %w", op, err)
+				}
+				fmt.Fprintf(w, "%s: ok\n", path)
+			}
+			if len(errs) > 0 {
+				return fmt.Errorf("%s: %w", op, errors.Join(errs...))
+			}
+			return nil
+		},
+	}
+}
+
+// DiffCommand returns a command that reports the limits and rate classes that
+// differ between two documents of limits.
+func DiffCommand() *Command {
+	return &Command{
+		Use:   "diff OLD NEW",
+		Short: "Show the differences between two documents of limits",
+		Long: "Diff reports each limit and rate class that was added (+), " +
+			"removed (-), or changed (~) between two documents of limits. " +
+			"Limits are matched by their resource, action, and per.",
+		Flags: newFlagSet("diff"),
+		RunE: func(w io.Writer, args []string) error {
+			const op = "ratecli.diff"
+			if err := checkArgs(args, 2, 2); err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+			older, err := readDocument(args[0])
+			if err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+			newer, err := readDocument(args[1])
+			if err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+
+			diff(w, limitEntries(older), limitEntries(newer))
+			diff(w, classEntries(older), classEntries(newer))
+			return nil
+		},
+	}
+}
+
+// entries are descriptions of the limits or rate classes of a document, keyed
+// by name, along with the names in the order they are reported.
+type entries struct {
+	descs map[string]string
+	names []string
+}
+
+func (e *entries) add(name, desc string) {
+	if e.descs == nil {
+		e.descs = make(map[string]string)
+	}
+	if _, ok := e.descs[name]; !ok {
+		e.names = append(e.names, name)
+	}
+	e.descs[name] = desc
+}
+
+// limitEntries returns the limits of the document keyed by their resource,
+// action, and per, in the order of the document.
+func limitEntries(doc *rateconfig.Document) entries {
+	var e entries
+	for _, l := range doc.Limits {
+		e.add(fmt.Sprintf("%s %s %s", l.Resource, l.Action, l.Per), describe(l, "resource", "action", "per"))
+	}
+	return e
+}
+
+// classEntries returns the rate classes of the document keyed by their name,
+// in sorted order.
+func classEntries(doc *rateconfig.Document) entries {
+	names := make([]string, 0, len(doc.Classes))
+	for name := range doc.Classes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var e entries
+	for _, name := range names {
+		e.add("class "+name, describe(doc.Classes[name]))
+	}
+	return e
+}
+
+// describe returns the fields of v that are set, as name=value pairs using
+// the names of the document format, except for the fields named in omit.
+func describe(v any, omit ...string) string {
+	rv := reflect.ValueOf(v)
+	var fields []string
+Fields:
+	for i := 0; i < rv.NumField(); i++ {
+		name, _, _ := strings.Cut(rv.Type().Field(i).Tag.Get("yaml"), ",")
+		for _, o := range omit {
+			if name == o {
+				continue Fields
+			}
+		}
+		if f := rv.Field(i); !f.IsZero() {
+			fields = append(fields, fmt.Sprintf("%s=%v", name, f.Interface()))
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// diff writes the entries that were removed from, added to, or changed
+// between older and newer.
+func diff(w io.Writer, older, newer entries) {
+	for _, name := range older.names {
+		if _, ok := newer.descs[name]; !ok {
+			fmt.Fprintf(w, "- %s: %s\n", name, older.descs[name])
+		}
+	}
+	for _, name := range newer.names {
+		o, ok := older.descs[name]
+		switch {
+		case !ok:
+			fmt.Fprintf(w, "+ %s: %s\n", name, newer.descs[name])
+		case o != newer.descs[name]:
+			fmt.Fprintf(w, "~ %s: %s -> %s\n", name, o, newer.descs[name])
+		}
+	}
+}
+
+// traceRequest is a request in a trace read by the simulate command.
+type traceRequest struct {
+	Resource  string    `json:"resource"`
+	Action    string    `json:"action"`
+	IP        string    `json:"ip"`
+	AuthToken string    `json:"auth_token"`
+	Time      time.Time `json:"time"`
+}
+
+// SimulateCommand returns a command that replays a trace of requests against
+// a document of limits, via Limiter.Simulate.
+func SimulateCommand() *Command {
+	fs := newFlagSet("simulate")
+	asJSON := fs.Bool("json", false, "write the report as JSON")
+	return &Command{
+		Use:   "simulate [-json] FILE TRACE",
+		Short: "Simulate a document of limits against a trace of requests",
+		Long: "Simulate replays a trace of requests against a document of limits, " +
+			"and reports how many requests would have been allowed or denied. " +
+			"The trace has a JSON object per line, with the fields resource, " +
+			"action, ip, auth_token, and time, which is in RFC 3339 format.",
+		Flags: fs,
+		RunE: func(w io.Writer, args []string) error {
+			const op = "ratecli.simulate"
+			if err := checkArgs(args, 2, 2); err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+			l, _, err := newLimiter(args[0])
+			if err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+			defer l.Shutdown()
+
+			trace, err := readTrace(args[1])
+			if err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+			// The Limiter was created from the document, so its limits
+			// are the candidate limits.
+			report, err := l.Simulate(nil, trace)
+			if err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+			if *asJSON {
+				enc := json.NewEncoder(w)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(report); err != nil {
+					return fmt.Errorf("%s: %w", op, err)
+				}
+				return nil
+			}
+			writeReport(w, report)
+			return nil
+		},
+	}
+}
+
+// readTrace reads the trace of requests at path.
+func readTrace(path string) ([]rate.Request, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var trace []rate.Request
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		if strings.TrimSpace(s.Text()) == "" {
+			continue
+		}
+		var r traceRequest
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		trace = append(trace, rate.Request(r))
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return trace, nil
+}
+
+// writeReport writes the report, with a line for each resource and action in
+// sorted order.
+func writeReport(w io.Writer, report rate.SimulationReport) {
+	fmt.Fprintf(w, "total=%d allowed=%d denied=%d not_found=%d\n",
+		report.Total, report.Allowed, report.Denied, report.NotFound)
+
+	policies := make([]*rate.PolicySimulation, 0, len(report.Policies))
+	for _, ps := range report.Policies {
+		policies = append(policies, ps)
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		if policies[i].Resource != policies[j].Resource {
+			return policies[i].Resource < policies[j].Resource
+		}
+		return policies[i].Action < policies[j].Action
+	})
+	for _, ps := range policies {
+		fmt.Fprintf(w, "%s %s: allowed=%d denied=%d", ps.Resource, ps.Action, ps.Allowed, ps.Denied)
+		for _, per := range []rate.LimitPer{rate.LimitPerTotal, rate.LimitPerIPAddress, rate.LimitPerAuthToken} {
+			if n := ps.DeniedPer[per]; n > 0 {
+				fmt.Fprintf(w, " denied_%s=%d", per, n)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// RenderHeadersCommand returns a command that writes the rate limit policy
+// HTTP header of each resource and action in a document of limits.
+func RenderHeadersCommand() *Command {
+	fs := newFlagSet("render-headers")
+	header := fs.String("header", rate.DefaultPolicyHeader, "the name of the policy header")
+	return &Command{
+		Use:   "render-headers [-header NAME] FILE",
+		Short: "Render the policy headers of a document of limits",
+		Long: "Render-headers writes the rate limit policy HTTP header that is " +
+			"set for each resource and action in a document of limits, in the " +
+			"order they first appear in the document. Policies whose limits are " +
+			"all unlimited have no header.",
+		Flags: fs,
+		RunE: func(w io.Writer, args []string) error {
+			const op = "ratecli.render-headers"
+			if err := checkArgs(args, 1, 1); err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+			l, doc, err := newLimiter(args[0], rate.WithPolicyHeader(*header))
+			if err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+			defer l.Shutdown()
+
+			seen := make(map[[2]string]bool)
+			for _, ll := range doc.Limits {
+				key := [2]string{ll.Resource, ll.Action}
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				h := make(http.Header)
+				if err := l.SetPolicyHeader(ll.Resource, ll.Action, h); err != nil {
+					return fmt.Errorf("%s: %w", op, err)
+				}
+				fmt.Fprintf(w, "%s %s:", ll.Resource, ll.Action)
+				if v := h.Get(*header); v != "" {
+					fmt.Fprintf(w, " %s: %s", *header, v)
+				}
+				fmt.Fprintln(w)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratecli
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/hashicorp/go-rate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDocument = `
+limits:
+  - resource: users
+    action: list
+    per: ip-address
+    max_requests: 2
+    period: 1m
+  - resource: users
+    action: list
+    per: auth-token
+    unlimited: true
+  - resource: users
+    action: list
+    per: total
+    class: standard
+  - resource: users
+    action: delete
+    per: ip-address
+    unlimited: true
+  - resource: users
+    action: delete
+    per: auth-token
+    unlimited: true
+  - resource: users
+    action: delete
+    per: total
+    unlimited: true
+classes:
+  standard:
+    max_requests: 100
+    period: 1m
+`
+
+const testDocumentChanged = `
+limits:
+  - resource: users
+    action: list
+    per: ip-address
+    max_requests: 5
+    period: 1m
+  - resource: users
+    action: list
+    per: auth-token
+    unlimited: true
+  - resource: users
+    action: list
+    per: total
+    max_requests: 100
+    period: 1m
+  - resource: users
+    action: create
+    per: total
+    unlimited: true
+classes: {}
+`
+
+func TestValidateCommand(t *testing.T) {
+	valid := writeFile(t, "valid.yaml", testDocument)
+	invalid := writeFile(t, "invalid.yaml", "limits:\n  - resource: users\n    action: list\n    per: total\n    period: 1m\n")
+
+	var out bytes.Buffer
+	require.NoError(t, ValidateCommand().Execute(&out, []string{valid}))
+	assert.Equal(t, valid+": ok\n", out.String())
+
+	out.Reset()
+	err := ValidateCommand().Execute(&out, []string{valid, invalid, "missing.yaml"})
+	require.Error(t, err)
+	assert.ErrorIs(t, err, rate.ErrInvalidLimit)
+	assert.Contains(t, out.String(), valid+": ok\n")
+	assert.Contains(t, out.String(), invalid+": ")
+	assert.Contains(t, out.String(), "missing.yaml: ")
+
+	assert.ErrorIs(t, ValidateCommand().Execute(&out, nil), rate.ErrInvalidParameter)
+}
+
+func TestDiffCommand(t *testing.T) {
+	older := writeFile(t, "old.yaml", testDocument)
+	newer := writeFile(t, "new.yaml", testDocumentChanged)
+
+	var out bytes.Buffer
+	require.NoError(t, DiffCommand().Execute(&out, []string{older, newer}))
+	assert.Equal(t, `- users delete ip-address: unlimited=true
+- users delete auth-token: unlimited=true
+- users delete total: unlimited=true
+~ users list ip-address: max_requests=2 period=1m -> max_requests=5 period=1m
+~ users list total: class=standard -> max_requests=100 period=1m
++ users create total: unlimited=true
+- class standard: max_requests=100 period=1m
+`, out.String())
+
+	out.Reset()
+	require.NoError(t, DiffCommand().Execute(&out, []string{older, older}))
+	assert.Empty(t, out.String())
+
+	assert.ErrorIs(t, DiffCommand().Execute(&out, []string{older}), rate.ErrInvalidParameter)
+	assert.Error(t, DiffCommand().Execute(&out, []string{older, "missing.yaml"}))
+}
+
+func TestSimulateCommand(t *testing.T) {
+	limits := writeFile(t, "limits.yaml", testDocument)
+	trace := writeFile(t, "trace.jsonl", `{"resource": "users", "action": "list", "ip": "10.0.0.1", "time": "2023-01-01T00:00:00Z"}
+{"resource": "users", "action": "list", "ip": "10.0.0.1", "time": "2023-01-01T00:00:01Z"}
+
+{"resource": "users", "action": "list", "ip": "10.0.0.1", "time": "2023-01-01T00:00:02Z"}
+{"resource": "users", "action": "delete", "ip": "10.0.0.1", "time": "2023-01-01T00:00:03Z"}
+{"resource": "groups", "action": "list", "ip": "10.0.0.1", "time": "2023-01-01T00:00:04Z"}
+`)
+
+	// The groups list request has no limit policy, so it is both denied and
+	// not found.
+	var out bytes.Buffer
+	require.NoError(t, SimulateCommand().Execute(&out, []string{limits, trace}))
+	assert.Equal(t, `total=5 allowed=3 denied=2 not_found=1
+users delete: allowed=1 denied=0
+users list: allowed=2 denied=1 denied_ip-address=1
+`, out.String())
+
+	out.Reset()
+	require.NoError(t, SimulateCommand().Execute(&out, []string{"-json", limits, trace}))
+	var report rate.SimulationReport
+	require.NoError(t, json.Unmarshal(out.Bytes(), &report))
+	assert.Equal(t, uint64(2), report.Denied)
+	assert.Equal(t, uint64(1), report.Policies["users:list"].DeniedPer[rate.LimitPerIPAddress])
+
+	invalid := writeFile(t, "invalid.jsonl", "{\n")
+	assert.Error(t, SimulateCommand().Execute(&out, []string{limits, invalid}))
+	assert.ErrorIs(t, SimulateCommand().Execute(&out, []string{limits}), rate.ErrInvalidParameter)
+}
+
+func TestRenderHeadersCommand(t *testing.T) {
+	limits := writeFile(t, "limits.yaml", testDocument)
+
+	var out bytes.Buffer
+	require.NoError(t, RenderHeadersCommand().Execute(&out, []string{limits}))
+	assert.Equal(t, `users list: RateLimit-Policy: 100;w=60;comment="total", 2;w=60;comment="ip-address"
+users delete:
+`, out.String())
+
+	out.Reset()
+	require.NoError(t, RenderHeadersCommand().Execute(&out, []string{"-header", "X-Policy", limits}))
+	assert.Contains(t, out.String(), "users list: X-Policy: ")
+
+	assert.ErrorIs(t, RenderHeadersCommand().Execute(&out, nil), rate.ErrInvalidParameter)
+}
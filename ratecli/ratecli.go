@@ -0,0 +1,131 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package ratecli provides commands for managing the limits of a service,
+// which read documents of limits in the format of the rateconfig package, so
+// that operators have the same tooling across the products that embed them.
+//
+// The package does not depend on a CLI framework. The fields of a Command
+// mirror those of a cobra.Command, so that each command can be mounted under
+// an existing cobra CLI:
+//
+//	for _, c := range ratecli.Commands() {
+//		c := c
+//		cmd := &cobra.Command{
+//			Use:   c.Use,
+//			Short: c.Short,
+//			Long:  c.Long,
+//			RunE: func(cmd *cobra.Command, args []string) error {
+//				return c.RunE(cmd.OutOrStdout(), args)
+//			},
+//		}
+//		cmd.Flags().AddGoFlagSet(c.Flags)
+//		limits.AddCommand(cmd)
+//	}
+//
+// Commands can also be run directly via Command.Execute.
+package ratecli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/go-rate"
+	"github.com/hashicorp/go-rate/rateconfig"
+)
+
+// Command is a command that manages limits.
+type Command struct {
+	// Use is the one-line usage message, which starts with the name of the
+	// command.
+	Use string
+	// Short is a short description of the command.
+	Short string
+	// Long is a longer description of the command.
+	Long string
+	// Flags are the flags of the command, which are set before RunE is
+	// called.
+	Flags *flag.FlagSet
+	// RunE runs the command with the arguments that remain after the flags
+	// are parsed, and writes its output to w.
+	RunE func(w io.Writer, args []string) error
+}
+
+// Name returns the name of the command.
+func (c *Command) Name() string {
+	name, _, _ := strings.Cut(c.Use, " ")
+	return name
+}
+
+// Execute parses the flags in args and then runs the command with the
+// remaining arguments.
+func (c *Command) Execute(w io.Writer, args []string) error {
+	if err := c.Flags.Parse(args); err != nil {
+		return err
+	}
+	return c.RunE(w, c.Flags.Args())
+}
+
+// Commands returns the validate, diff, simulate, and render-headers commands.
+func Commands() []*Command {
+	return []*Command{
+		ValidateCommand(),
+		DiffCommand(),
+		SimulateCommand(),
+		RenderHeadersCommand(),
+	}
+}
+
+// newFlagSet returns the flags for the command with the name.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// checkArgs returns an error unless there are between min and max arguments,
+// where a max of -1 allows any number of arguments.
+func checkArgs(args []string, min, max int) error {
+	switch {
+	case len(args) < min:
+		return fmt.Errorf("expected at least %d arguments, got %d: %w", min, len(args), rate.ErrInvalidParameter)
+	case max >= 0 && len(args) > max:
+		return fmt.Errorf("expected at most %d arguments, got %d: %w", max, len(args), rate.ErrInvalidParameter)
+	}
+	return nil
+}
+
+// readDocument reads the document of limits at path.
+func readDocument(path string) (*rateconfig.Document, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return rateconfig.ReadDocument(f)
+}
+
+// newLimiter returns a Limiter for the limits and rate classes of the
+// document at path, which validates them. It must be shut down by the caller.
+func newLimiter(path string, o ...rate.Option) (*rate.Limiter, *rateconfig.Document, error) {
+	doc, err := readDocument(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	limits, err := doc.RateLimits()
+	if err != nil {
+		return nil, nil, err
+	}
+	classes, err := doc.RateClasses()
+	if err != nil {
+		return nil, nil, err
+	}
+	l, err := rate.NewLimiter(limits, 1, append(o, rate.WithRateClasses(classes))...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return l, doc, nil
+}
@@ -0,0 +1,82 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratecli
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-rate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFile writes the content to a file in a temporary directory and returns
+// its path.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestCommands(t *testing.T) {
+	var names []string
+	for _, c := range Commands() {
+		names = append(names, c.Name())
+		assert.NotEmpty(t, c.Short)
+		assert.NotEmpty(t, c.Long)
+		assert.NotNil(t, c.Flags)
+		assert.NotNil(t, c.RunE)
+	}
+	assert.Equal(t, []string{"validate", "diff", "simulate", "render-headers"}, names)
+}
+
+func TestCommandExecute(t *testing.T) {
+	var got []string
+	fs := newFlagSet("test")
+	verbose := fs.Bool("v", false, "")
+	c := &Command{
+		Use:   "test ARG...",
+		Flags: fs,
+		RunE: func(w io.Writer, args []string) error {
+			got = args
+			return nil
+		},
+	}
+	assert.Equal(t, "test", c.Name())
+
+	require.NoError(t, c.Execute(&bytes.Buffer{}, []string{"-v", "a", "b"}))
+	assert.True(t, *verbose)
+	assert.Equal(t, []string{"a", "b"}, got)
+
+	assert.Error(t, c.Execute(&bytes.Buffer{}, []string{"-unknown"}))
+}
+
+func TestCheckArgs(t *testing.T) {
+	cases := []struct {
+		name     string
+		args     []string
+		min, max int
+		wantErr  bool
+	}{
+		{"exact", []string{"a"}, 1, 1, false},
+		{"any", []string{"a", "b", "c"}, 1, -1, false},
+		{"too-few", nil, 1, -1, true},
+		{"too-many", []string{"a", "b"}, 1, 1, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkArgs(tc.args, tc.min, tc.max)
+			if tc.wantErr {
+				assert.ErrorIs(t, err, rate.ErrInvalidParameter)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package rateconfig reads limits and rate classes from a document, so that
+// operators can provide them as configuration. A document can be written in
+// either JSON or YAML, and can be validated by external tooling using the
+// JSON Schema returned by Schema.
+package rateconfig
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hashicorp/go-rate"
+	"gopkg.in/yaml.v3"
+)
+
+// Document is a document of limits and the rate classes that they use.
+type Document struct {
+	Limits  []Limit          `yaml:"limits"`
+	Classes map[string]Class `yaml:"classes,omitempty"`
+}
+
+// Limit is a limit in a Document. It is a rate.Unlimited if Unlimited is
+// true, and a rate.Limited otherwise. Period is a duration such as "1m", and
+// the other fields correspond to the fields of rate.Limited.
+type Limit struct {
+	Resource  string `yaml:"resource"`
+	Action    string `yaml:"action"`
+	Per       string `yaml:"per"`
+	Unlimited bool   `yaml:"unlimited,omitempty"`
+
+	MaxRequests     uint64  `yaml:"max_requests,omitempty"`
+	MaxRequestsExpr string  `yaml:"max_requests_expr,omitempty"`
+	Period          string  `yaml:"period,omitempty"`
+	Jitter          float64 `yaml:"jitter,omitempty"`
+	GraceRequests   uint64  `yaml:"grace_requests,omitempty"`
+	// EmptyIdentity is one of "shared", "skip", or "deny".
+	EmptyIdentity string `yaml:"empty_identity,omitempty"`
+	// Anchor is one of "first-request" or "epoch".
+	Anchor string `yaml:"anchor,omitempty"`
+	// Location is the name of a time zone, such as "America/New_York".
+	Location string `yaml:"location,omitempty"`
+	Fallback string `yaml:"fallback,omitempty"`
+	Pool     string `yaml:"pool,omitempty"`
+	Class    string `yaml:"class,omitempty"`
+}
+
+// Class is a rate class in a Document. Period is a duration such as "1m".
+type Class struct {
+	MaxRequests   uint64 `yaml:"max_requests"`
+	Period        string `yaml:"period"`
+	GraceRequests uint64 `yaml:"grace_requests,omitempty"`
+}
+
+// emptyIdentities and anchors map the values used in a Document to their
+// rate equivalents.
+var (
+	emptyIdentities = map[string]rate.EmptyIdentity{
+		"":       rate.EmptyIdentityShared,
+		"shared": rate.EmptyIdentityShared,
+		"skip":   rate.EmptyIdentitySkip,
+		"deny":   rate.EmptyIdentityDeny,
+	}
+	anchors = map[string]rate.WindowAnchor{
+		"":              rate.WindowAnchorFirstRequest,
+		"first-request": rate.WindowAnchorFirstRequest,
+		"epoch":         rate.WindowAnchorEpoch,
+	}
+)
+
+// ReadDocument reads a Document, in either JSON or YAML. Fields that are not
+// part of the format are rejected, so that typos are not silently ignored.
+// The limits are not validated until they are used by a Limiter.
+func ReadDocument(r io.Reader) (*Document, error) {
+	const op = "rateconfig.ReadDocument"
+
+	var doc Document
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&doc); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s: %w", op, rate.ErrEmptyLimits)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return &doc, nil
+}
+
+// RateLimits returns the limits of the document, in the same order.
+func (d *Document) RateLimits() ([]rate.Limit, error) {
+	const op = "rateconfig.(Document).RateLimits"
+
+	limits := make([]rate.Limit, 0, len(d.Limits))
+	for i, l := range d.Limits {
+		ll, err := l.rateLimit()
+		if err != nil {
+			return nil, fmt.Errorf("%s: limit %d: %w", op, i, err)
+		}
+		limits = append(limits, ll)
+	}
+	return limits, nil
+}
+
+// RateClasses returns the rate classes of the document.
+func (d *Document) RateClasses() (map[string]rate.RateClass, error) {
+	const op = "rateconfig.(Document).RateClasses"
+
+	classes := make(map[string]rate.RateClass, len(d.Classes))
+	for name, c := range d.Classes {
+		period, err := parsePeriod(c.Period)
+		if err != nil {
+			return nil, fmt.Errorf("%s: rate class %q: %w", op, name, err)
+		}
+		classes[name] = rate.RateClass{
+			MaxRequests:   c.MaxRequests,
+			Period:        period,
+			GraceRequests: c.GraceRequests,
+		}
+	}
+	return classes, nil
+}
+
+// rateLimit returns the limit as a rate.Limit.
+func (l Limit) rateLimit() (rate.Limit, error) {
+	if l.Unlimited {
+		return &rate.Unlimited{
+			Resource: l.Resource,
+			Action:   l.Action,
+			Per:      rate.LimitPer(l.Per),
+		}, nil
+	}
+
+	period, err := parsePeriod(l.Period)
+	if err != nil {
+		return nil, err
+	}
+	emptyIdentity, ok := emptyIdentities[l.EmptyIdentity]
+	if !ok {
+		return nil, fmt.Errorf("invalid empty_identity %q: %w", l.EmptyIdentity, rate.ErrInvalidLimit)
+	}
+	anchor, ok := anchors[l.Anchor]
+	if !ok {
+		return nil, fmt.Errorf("invalid anchor %q: %w", l.Anchor, rate.ErrInvalidLimit)
+	}
+	var loc *time.Location
+	if l.Location != "" {
+		if loc, err = time.LoadLocation(l.Location); err != nil {
+			return nil, fmt.Errorf("invalid location %q: %w", l.Location, rate.ErrInvalidLimit)
+		}
+	}
+	return &rate.Limited{
+		Resource:        l.Resource,
+		Action:          l.Action,
+		Per:             rate.LimitPer(l.Per),
+		MaxRequests:     l.MaxRequests,
+		MaxRequestsExpr: l.MaxRequestsExpr,
+		Period:          period,
+		Jitter:          l.Jitter,
+		GraceRequests:   l.GraceRequests,
+		EmptyIdentity:   emptyIdentity,
+		Anchor:          anchor,
+		Location:        loc,
+		Fallback:        rate.LimitPer(l.Fallback),
+		Pool:            l.Pool,
+		Class:           l.Class,
+	}, nil
+}
+
+// parsePeriod parses a period, which is zero if it is empty, such as for a
+// limit that uses a rate class.
+func parsePeriod(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid period %q: %w", s, rate.ErrInvalidLimit)
+	}
+	return d, nil
+}
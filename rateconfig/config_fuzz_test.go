@@ -0,0 +1,61 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rateconfig
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-rate"
+)
+
+// FuzzParse ensures that reading a hostile document, and creating a Limiter
+// from its limits and rate classes, does not panic, and that invalid limits
+// and rate classes are reported as rate.ErrInvalidLimit.
+func FuzzParse(f *testing.F) {
+	f.Add(testDocumentYAML)
+	f.Add(testDocumentJSON)
+	f.Add("")
+	f.Add("{")
+	f.Add("limits:\n  - resource: users\n    max_request: 10\n")
+	f.Add("limits:\n  - resource: users\n    period: 1 minute\n")
+	f.Add("limits:\n  - resource: users\n    period: 1m\n    empty_identity: none\n")
+	f.Add("limits:\n  - resource: users\n    period: 1m\n    anchor: midnight\n")
+	f.Add("limits:\n  - resource: users\n    period: 1m\n    location: Nowhere/Nothing\n")
+	f.Add("classes:\n  standard:\n    max_requests: 1\n    period: soon\n")
+	f.Add("limits: &a [*a]\n")
+
+	f.Fuzz(func(t *testing.T, in string) {
+		doc, err := ReadDocument(strings.NewReader(in))
+		if err != nil {
+			return
+		}
+		limits, err := doc.RateLimits()
+		if err != nil {
+			if !errors.Is(err, rate.ErrInvalidLimit) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			return
+		}
+		classes, err := doc.RateClasses()
+		if err != nil {
+			if !errors.Is(err, rate.ErrInvalidLimit) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			return
+		}
+		if len(limits) != len(doc.Limits) {
+			t.Fatalf("expected %d limits, got %d", len(doc.Limits), len(limits))
+		}
+
+		l, err := rate.NewLimiter(limits, 10, rate.WithRateClasses(classes))
+		if err != nil {
+			return
+		}
+		if err := l.Shutdown(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,130 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rateconfig
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-rate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDocumentYAML = `
+limits:
+  - resource: users
+    action: list
+    per: ip-address
+    max_requests: 10
+    period: 1m
+    jitter: 0.1
+    empty_identity: deny
+  - resource: users
+    action: list
+    per: total
+    class: standard
+  - resource: users
+    action: list
+    per: auth-token
+    max_requests: 1000
+    period: 24h
+    anchor: epoch
+    location: UTC
+    fallback: ip-address
+  - resource: users
+    action: delete
+    per: total
+    unlimited: true
+classes:
+  standard:
+    max_requests: 100
+    period: 1m
+    grace_requests: 5
+`
+
+const testDocumentJSON = `{
+  "limits": [
+    {"resource": "users", "action": "list", "per": "ip-address", "max_requests": 10, "period": "1m", "jitter": 0.1, "empty_identity": "deny"},
+    {"resource": "users", "action": "list", "per": "total", "class": "standard"},
+    {"resource": "users", "action": "list", "per": "auth-token", "max_requests": 1000, "period": "24h", "anchor": "epoch", "location": "UTC", "fallback": "ip-address"},
+    {"resource": "users", "action": "delete", "per": "total", "unlimited": true}
+  ],
+  "classes": {
+    "standard": {"max_requests": 100, "period": "1m", "grace_requests": 5}
+  }
+}`
+
+var testLimits = []rate.Limit{
+	&rate.Limited{Resource: "users", Action: "list", Per: rate.LimitPerIPAddress, MaxRequests: 10, Period: time.Minute, Jitter: 0.1, EmptyIdentity: rate.EmptyIdentityDeny},
+	&rate.Limited{Resource: "users", Action: "list", Per: rate.LimitPerTotal, Class: "standard"},
+	&rate.Limited{Resource: "users", Action: "list", Per: rate.LimitPerAuthToken, MaxRequests: 1000, Period: 24 * time.Hour, Anchor: rate.WindowAnchorEpoch, Location: time.UTC, Fallback: rate.LimitPerIPAddress},
+	&rate.Unlimited{Resource: "users", Action: "delete", Per: rate.LimitPerTotal},
+}
+
+var testClasses = map[string]rate.RateClass{
+	"standard": {MaxRequests: 100, Period: time.Minute, GraceRequests: 5},
+}
+
+func TestReadDocument(t *testing.T) {
+	for name, in := range map[string]string{"yaml": testDocumentYAML, "json": testDocumentJSON} {
+		t.Run(name, func(t *testing.T) {
+			doc, err := ReadDocument(strings.NewReader(in))
+			require.NoError(t, err)
+
+			limits, err := doc.RateLimits()
+			require.NoError(t, err)
+			assert.Equal(t, testLimits, limits)
+
+			classes, err := doc.RateClasses()
+			require.NoError(t, err)
+			assert.Equal(t, testClasses, classes)
+
+			l, err := rate.NewLimiter(limits, 100, rate.WithRateClasses(classes))
+			require.NoError(t, err)
+			require.NoError(t, l.Shutdown())
+		})
+	}
+
+	cases := []struct {
+		name string
+		in   string
+	}{
+		{"empty", ""},
+		{"invalid", "{"},
+		{"unknown-field", "limits:\n  - resource: users\n    max_request: 10\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ReadDocument(strings.NewReader(tc.in))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestDocumentRateLimits(t *testing.T) {
+	cases := []struct {
+		name  string
+		limit Limit
+	}{
+		{"invalid-period", Limit{Period: "1 minute"}},
+		{"invalid-empty-identity", Limit{Period: "1m", EmptyIdentity: "none"}},
+		{"invalid-anchor", Limit{Period: "1m", Anchor: "midnight"}},
+		{"invalid-location", Limit{Period: "1m", Location: "Nowhere/Nothing"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			doc := &Document{Limits: []Limit{tc.limit}}
+			_, err := doc.RateLimits()
+			assert.ErrorIs(t, err, rate.ErrInvalidLimit)
+		})
+	}
+}
+
+func TestDocumentRateClasses(t *testing.T) {
+	doc := &Document{Classes: map[string]Class{"standard": {MaxRequests: 1, Period: "soon"}}}
+	_, err := doc.RateClasses()
+	assert.ErrorIs(t, err, rate.ErrInvalidLimit)
+}
@@ -0,0 +1,23 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rateconfig
+
+import (
+	_ "embed"
+)
+
+//go:embed schema.json
+var schema []byte
+
+// Schema returns a JSON Schema (draft 2020-12) that describes a Document, so
+// that tooling and user interfaces can validate documents of limits before
+// they are read by ReadDocument. The schema describes the format of a
+// document, but not every requirement of a Limiter, such as that a
+// LimitPerTotal limit cannot use a Fallback. A new slice is returned by each
+// call.
+func Schema() []byte {
+	s := make([]byte, len(schema))
+	copy(s, schema)
+	return s
+}
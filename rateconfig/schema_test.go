@@ -0,0 +1,79 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rateconfig
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fieldNames returns the names of the fields of t in a Document.
+func fieldNames(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestSchema(t *testing.T) {
+	type object struct {
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	var s struct {
+		object
+		Defs map[string]object `json:"$defs"`
+	}
+	require.NoError(t, json.Unmarshal(Schema(), &s))
+
+	propertyNames := func(o object) []string {
+		var names []string
+		for name := range o.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+	assert.Equal(t, fieldNames(reflect.TypeOf(Document{})), propertyNames(s.object))
+	assert.Equal(t, fieldNames(reflect.TypeOf(Limit{})), propertyNames(s.Defs["limit"]))
+	assert.Equal(t, fieldNames(reflect.TypeOf(Class{})), propertyNames(s.Defs["class"]))
+
+	// The enums include every value except the empty default.
+	for _, tc := range []struct {
+		property string
+		values   map[string]bool
+	}{
+		{"empty_identity", keys(emptyIdentities)},
+		{"anchor", keys(anchors)},
+	} {
+		var p struct {
+			Enum []string `json:"enum"`
+		}
+		require.NoError(t, json.Unmarshal(s.Defs["limit"].Properties[tc.property], &p))
+		for _, v := range p.Enum {
+			assert.True(t, tc.values[v], "%s: %q", tc.property, v)
+		}
+		assert.Len(t, p.Enum, len(tc.values)-1, tc.property)
+	}
+
+	// Each call returns a new slice.
+	Schema()[0] = 'x'
+	assert.True(t, json.Valid(Schema()))
+}
+
+func keys[V any](m map[string]V) map[string]bool {
+	k := make(map[string]bool, len(m))
+	for v := range m {
+		k[v] = true
+	}
+	return k
+}
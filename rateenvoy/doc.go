@@ -0,0 +1,13 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package rateenvoy implements the Envoy rate limit service
+// (envoy.service.ratelimit.v3.RateLimitService) using a rate.Limiter, so that
+// the same limit policies can be enforced by Envoy at the edge.
+//
+// Service uses messages defined by this package that mirror those of the
+// Envoy API, and is served over gRPC by the separate
+// github.com/hashicorp/go-rate/rateenvoy/envoygrpc module.
+//
+// The Code values are the same as those of the Envoy API.
+package rateenvoy
@@ -0,0 +1,12 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package envoygrpc serves a rateenvoy.Service as the Envoy rate limit
+// service (envoy.service.ratelimit.v3.RateLimitService) over gRPC:
+//
+//	svc, err := rateenvoy.NewService(limiter, nil)
+//	...
+//	srv, err := envoygrpc.NewServer(svc)
+//	...
+//	rlsv3.RegisterRateLimitServiceServer(s, srv)
+package envoygrpc
@@ -0,0 +1,77 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package envoygrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"github.com/hashicorp/go-rate"
+	"github.com/hashicorp/go-rate/rateenvoy"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Server implements rlsv3.RateLimitServiceServer using a rateenvoy.Service.
+type Server struct {
+	rlsv3.UnimplementedRateLimitServiceServer
+	svc *rateenvoy.Service
+}
+
+// NewServer creates a Server that checks requests using svc.
+func NewServer(svc *rateenvoy.Service) (*Server, error) {
+	const op = "envoygrpc.NewServer"
+	if svc == nil {
+		return nil, fmt.Errorf("%s: missing service: %w", op, rate.ErrInvalidParameter)
+	}
+	return &Server{svc: svc}, nil
+}
+
+// ShouldRateLimit checks a request using rateenvoy.Service.ShouldRateLimit.
+// An error returned by the Service is sent as an Unavailable status if the
+// Limiter is stopped or its Store is unavailable, and as an Internal status
+// otherwise, so that Envoy applies its failure mode.
+func (s *Server) ShouldRateLimit(ctx context.Context, req *rlsv3.RateLimitRequest) (*rlsv3.RateLimitResponse, error) {
+	r := &rateenvoy.RateLimitRequest{
+		Domain:      req.GetDomain(),
+		Descriptors: make([]rateenvoy.Descriptor, 0, len(req.GetDescriptors())),
+		HitsAddend:  req.GetHitsAddend(),
+	}
+	for _, d := range req.GetDescriptors() {
+		desc := rateenvoy.Descriptor{Entries: make([]rateenvoy.DescriptorEntry, 0, len(d.GetEntries()))}
+		for _, e := range d.GetEntries() {
+			desc.Entries = append(desc.Entries, rateenvoy.DescriptorEntry{Key: e.GetKey(), Value: e.GetValue()})
+		}
+		r.Descriptors = append(r.Descriptors, desc)
+	}
+
+	resp, err := s.svc.ShouldRateLimit(ctx, r)
+	switch {
+	case errors.Is(err, rate.ErrStopped), errors.Is(err, rate.ErrStoreUnavailable):
+		return nil, status.Error(codes.Unavailable, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	out := &rlsv3.RateLimitResponse{
+		OverallCode: rlsv3.RateLimitResponse_Code(resp.OverallCode),
+		Statuses:    make([]*rlsv3.RateLimitResponse_DescriptorStatus, 0, len(resp.Statuses)),
+	}
+	for _, st := range resp.Statuses {
+		ds := &rlsv3.RateLimitResponse_DescriptorStatus{
+			Code:           rlsv3.RateLimitResponse_Code(st.Code),
+			LimitRemaining: st.LimitRemaining,
+		}
+		if st.DurationUntilReset > 0 {
+			ds.DurationUntilReset = durationpb.New(st.DurationUntilReset)
+		}
+		out.Statuses = append(out.Statuses, ds)
+	}
+	return out, nil
+}
+
+var _ rlsv3.RateLimitServiceServer = (*Server)(nil)
@@ -0,0 +1,127 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package envoygrpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	ratelimitv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"github.com/hashicorp/go-rate"
+	"github.com/hashicorp/go-rate/rateenvoy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testClient serves a Server using the limiter over an in-memory connection,
+// and returns a client connected to it.
+func testClient(t *testing.T, limiter *rate.Limiter) rlsv3.RateLimitServiceClient {
+	t.Helper()
+	svc, err := rateenvoy.NewService(limiter, nil)
+	require.NoError(t, err)
+	srv, err := NewServer(svc)
+	require.NoError(t, err)
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	rlsv3.RegisterRateLimitServiceServer(s, srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return rlsv3.NewRateLimitServiceClient(conn)
+}
+
+func testLimiter(t *testing.T) *rate.Limiter {
+	t.Helper()
+	l, err := rate.NewLimiter(
+		[]rate.Limit{
+			&rate.Limited{
+				Resource:    "edge",
+				Action:      "login",
+				Per:         rate.LimitPerTotal,
+				MaxRequests: 100,
+				Period:      time.Minute,
+			},
+			&rate.Limited{
+				Resource:    "edge",
+				Action:      "login",
+				Per:         rate.LimitPerIPAddress,
+				MaxRequests: 2,
+				Period:      time.Minute,
+			},
+			&rate.Unlimited{
+				Resource: "edge",
+				Action:   "login",
+				Per:      rate.LimitPerAuthToken,
+			},
+		},
+		10,
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Shutdown() })
+	return l
+}
+
+func descriptor(kv ...string) *ratelimitv3.RateLimitDescriptor {
+	d := &ratelimitv3.RateLimitDescriptor{}
+	for i := 0; i < len(kv); i += 2 {
+		d.Entries = append(d.Entries, &ratelimitv3.RateLimitDescriptor_Entry{Key: kv[i], Value: kv[i+1]})
+	}
+	return d
+}
+
+func TestNewServer(t *testing.T) {
+	_, err := NewServer(nil)
+	assert.ErrorIs(t, err, rate.ErrInvalidParameter)
+}
+
+func TestServerShouldRateLimit(t *testing.T) {
+	ctx := context.Background()
+	l := testLimiter(t)
+	c := testClient(t, l)
+
+	req := &rlsv3.RateLimitRequest{
+		Domain: "edge",
+		Descriptors: []*ratelimitv3.RateLimitDescriptor{
+			descriptor(rateenvoy.ActionKey, "login", rateenvoy.RemoteAddressKey, "127.0.0.1"),
+			descriptor(rateenvoy.RemoteAddressKey, "127.0.0.1"),
+		},
+	}
+	resp, err := c.ShouldRateLimit(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, rlsv3.RateLimitResponse_OK, resp.GetOverallCode())
+	require.Len(t, resp.GetStatuses(), 2)
+	assert.Equal(t, rlsv3.RateLimitResponse_OK, resp.GetStatuses()[0].GetCode())
+	assert.Equal(t, uint32(1), resp.GetStatuses()[0].GetLimitRemaining())
+	assert.InDelta(t, time.Minute, resp.GetStatuses()[0].GetDurationUntilReset().AsDuration(), float64(time.Second))
+	// The descriptor without an action is not limited.
+	assert.Equal(t, rlsv3.RateLimitResponse_OK, resp.GetStatuses()[1].GetCode())
+	assert.Nil(t, resp.GetStatuses()[1].GetDurationUntilReset())
+
+	req.HitsAddend = 2
+	resp, err = c.ShouldRateLimit(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, rlsv3.RateLimitResponse_OVER_LIMIT, resp.GetOverallCode())
+	assert.Equal(t, rlsv3.RateLimitResponse_OVER_LIMIT, resp.GetStatuses()[0].GetCode())
+	assert.Equal(t, rlsv3.RateLimitResponse_OK, resp.GetStatuses()[1].GetCode())
+
+	t.Run("Stopped", func(t *testing.T) {
+		require.NoError(t, l.Shutdown())
+		_, err := c.ShouldRateLimit(ctx, req)
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	})
+}
@@ -0,0 +1,202 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rateenvoy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/hashicorp/go-rate"
+)
+
+// Code is the result of checking a request or a descriptor, as with the
+// RateLimitResponse.Code of the Envoy API.
+type Code int32
+
+const (
+	// CodeUnknown is not returned by Service.
+	CodeUnknown Code = 0
+	// CodeOK indicates that the request is allowed.
+	CodeOK Code = 1
+	// CodeOverLimit indicates that the request should be denied.
+	CodeOverLimit Code = 2
+)
+
+// DescriptorEntry is a key and value of a Descriptor, such as
+// "remote_address" and the IP address of the client.
+type DescriptorEntry struct {
+	Key   string
+	Value string
+}
+
+// Descriptor is a rate limit descriptor generated by Envoy for a request.
+type Descriptor struct {
+	Entries []DescriptorEntry
+}
+
+// value returns the value of the entry with the key, and whether there is
+// such an entry.
+func (d Descriptor) value(key string) (string, bool) {
+	for _, e := range d.Entries {
+		if e.Key == key {
+			return e.Value, true
+		}
+	}
+	return "", false
+}
+
+// RateLimitRequest is a request to check the descriptors of a request made
+// to Envoy.
+type RateLimitRequest struct {
+	Domain      string
+	Descriptors []Descriptor
+	// HitsAddend is the number of requests that the request costs. If it is
+	// zero, the request costs 1.
+	HitsAddend uint32
+}
+
+// RateLimitResponse is the result of a RateLimitRequest.
+type RateLimitResponse struct {
+	// OverallCode is CodeOverLimit if any of the descriptors are over their
+	// limit, and CodeOK otherwise.
+	OverallCode Code
+	// Statuses are the results for each descriptor, in the same order as the
+	// request's descriptors.
+	Statuses []DescriptorStatus
+}
+
+// DescriptorStatus is the result of checking a single descriptor.
+type DescriptorStatus struct {
+	Code Code
+	// LimitRemaining and DurationUntilReset describe the quota returned by
+	// the Limiter. They are zero if there is no quota, such as when the
+	// descriptor does not have a limit policy.
+	LimitRemaining     uint32
+	DurationUntilReset time.Duration
+}
+
+// DescriptorMapper maps a descriptor of the domain to a request checked by
+// the Limiter. The request's Time is ignored. If false is returned, the
+// descriptor is not limited.
+type DescriptorMapper func(domain string, d Descriptor) (rate.Request, bool)
+
+// Entry keys used by DefaultDescriptorMapper.
+const (
+	ActionKey        = "action"
+	RemoteAddressKey = "remote_address"
+	AuthTokenKey     = "auth_token"
+)
+
+// DefaultDescriptorMapper maps a descriptor to a request whose resource is
+// the domain, and whose action, IP address, and auth token are the values of
+// the ActionKey, RemoteAddressKey, and AuthTokenKey entries. Descriptors
+// without an ActionKey entry are not limited.
+func DefaultDescriptorMapper(domain string, d Descriptor) (rate.Request, bool) {
+	action, ok := d.value(ActionKey)
+	if !ok {
+		return rate.Request{}, false
+	}
+	ip, _ := d.value(RemoteAddressKey)
+	authToken, _ := d.value(AuthTokenKey)
+	return rate.Request{
+		Resource:  domain,
+		Action:    action,
+		IP:        ip,
+		AuthToken: authToken,
+	}, true
+}
+
+// Limiter is used by a Service to check requests. It is implemented by
+// rate.Limiter and rate.NopLimiter.
+type Limiter interface {
+	AllowN(resource, action, ip, authToken string, n uint64) (bool, *rate.Quota, error)
+}
+
+// Service implements the ShouldRateLimit method of the Envoy rate limit
+// service using a Limiter. It is served over gRPC by envoygrpc.Server.
+type Service struct {
+	limiter Limiter
+	mapper  DescriptorMapper
+}
+
+// NewService creates a Service that checks each descriptor using the
+// limiter, after mapping it to a request using mapper. If mapper is nil,
+// DefaultDescriptorMapper is used.
+func NewService(limiter Limiter, mapper DescriptorMapper) (*Service, error) {
+	const op = "rateenvoy.NewService"
+	if limiter == nil {
+		return nil, fmt.Errorf("%s: missing limiter: %w", op, rate.ErrInvalidParameter)
+	}
+	if mapper == nil {
+		mapper = DefaultDescriptorMapper
+	}
+	return &Service{limiter: limiter, mapper: mapper}, nil
+}
+
+// ShouldRateLimit checks each of the request's descriptors. As with the
+// reference implementation of the Envoy rate limit service, each descriptor
+// is checked independently, so the quotas of descriptors that are allowed are
+// consumed from even if another descriptor is over its limit. Descriptors
+// that are not mapped to a request, or whose request does not have a limit
+// policy, are allowed.
+//
+// If the Limiter is full, the descriptor is reported as over its limit, with
+// a DurationUntilReset of when the Limiter expects to have space. Any other
+// error returned by the Limiter is returned, so that Envoy applies its
+// failure mode.
+func (s *Service) ShouldRateLimit(_ context.Context, req *RateLimitRequest) (*RateLimitResponse, error) {
+	const op = "rateenvoy.(Service).ShouldRateLimit"
+
+	n := uint64(req.HitsAddend)
+	if n == 0 {
+		n = 1
+	}
+	resp := &RateLimitResponse{
+		OverallCode: CodeOK,
+		Statuses:    make([]DescriptorStatus, len(req.Descriptors)),
+	}
+	for i, d := range req.Descriptors {
+		status := DescriptorStatus{Code: CodeOK}
+		r, ok := s.mapper(req.Domain, d)
+		if !ok {
+			resp.Statuses[i] = status
+			continue
+		}
+
+		allowed, quota, err := s.limiter.AllowN(r.Resource, r.Action, r.IP, r.AuthToken, n)
+		var full *rate.ErrLimiterFull
+		switch {
+		case errors.Is(err, rate.ErrLimitPolicyNotFound):
+		case errors.As(err, &full):
+			status.Code = CodeOverLimit
+			status.DurationUntilReset = full.RetryIn
+		case err != nil:
+			return nil, fmt.Errorf("%s: %w", op, err)
+		default:
+			if !allowed {
+				status.Code = CodeOverLimit
+			}
+			if quota != nil {
+				status.LimitRemaining = clampUint32(quota.Remaining())
+				status.DurationUntilReset = quota.ResetsIn()
+			}
+		}
+		if status.Code == CodeOverLimit {
+			resp.OverallCode = CodeOverLimit
+		}
+		resp.Statuses[i] = status
+	}
+	return resp, nil
+}
+
+// clampUint32 returns n, or math.MaxUint32 if n is larger.
+func clampUint32(n uint64) uint32 {
+	if n > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(n)
+}
@@ -0,0 +1,146 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rateenvoy
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-rate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testLimiter(t *testing.T, maxSize int) *rate.Limiter {
+	t.Helper()
+	l, err := rate.NewLimiter(
+		[]rate.Limit{
+			&rate.Limited{
+				Resource:    "edge",
+				Action:      "login",
+				Per:         rate.LimitPerTotal,
+				MaxRequests: 100,
+				Period:      time.Minute,
+			},
+			&rate.Limited{
+				Resource:    "edge",
+				Action:      "login",
+				Per:         rate.LimitPerIPAddress,
+				MaxRequests: 2,
+				Period:      time.Minute,
+			},
+			&rate.Unlimited{
+				Resource: "edge",
+				Action:   "login",
+				Per:      rate.LimitPerAuthToken,
+			},
+		},
+		maxSize,
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Shutdown() })
+	return l
+}
+
+func descriptor(kv ...string) Descriptor {
+	var d Descriptor
+	for i := 0; i < len(kv); i += 2 {
+		d.Entries = append(d.Entries, DescriptorEntry{Key: kv[i], Value: kv[i+1]})
+	}
+	return d
+}
+
+func TestNewService(t *testing.T) {
+	_, err := NewService(nil, nil)
+	assert.ErrorIs(t, err, rate.ErrInvalidParameter)
+
+	s, err := NewService(rate.NopLimiter, nil)
+	require.NoError(t, err)
+	assert.NotNil(t, s.mapper)
+}
+
+func TestDefaultDescriptorMapper(t *testing.T) {
+	r, ok := DefaultDescriptorMapper("edge", descriptor(ActionKey, "login", RemoteAddressKey, "127.0.0.1", AuthTokenKey, "token"))
+	require.True(t, ok)
+	assert.Equal(t, rate.Request{Resource: "edge", Action: "login", IP: "127.0.0.1", AuthToken: "token"}, r)
+
+	_, ok = DefaultDescriptorMapper("edge", descriptor(RemoteAddressKey, "127.0.0.1"))
+	assert.False(t, ok)
+}
+
+func TestServiceShouldRateLimit(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewService(testLimiter(t, 10), nil)
+	require.NoError(t, err)
+
+	req := &RateLimitRequest{
+		Domain: "edge",
+		Descriptors: []Descriptor{
+			descriptor(ActionKey, "login", RemoteAddressKey, "127.0.0.1"),
+			descriptor(RemoteAddressKey, "127.0.0.1"),
+			descriptor(ActionKey, "unknown", RemoteAddressKey, "127.0.0.1"),
+		},
+	}
+	resp, err := s.ShouldRateLimit(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, CodeOK, resp.OverallCode)
+	require.Len(t, resp.Statuses, 3)
+	assert.Equal(t, CodeOK, resp.Statuses[0].Code)
+	assert.Equal(t, uint32(1), resp.Statuses[0].LimitRemaining)
+	assert.InDelta(t, time.Minute, resp.Statuses[0].DurationUntilReset, float64(time.Second))
+	assert.Equal(t, DescriptorStatus{Code: CodeOK}, resp.Statuses[1])
+	assert.Equal(t, DescriptorStatus{Code: CodeOK}, resp.Statuses[2])
+
+	// The request costs 2, which exceeds the remaining quota.
+	req.HitsAddend = 2
+	resp, err = s.ShouldRateLimit(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, CodeOverLimit, resp.OverallCode)
+	assert.Equal(t, CodeOverLimit, resp.Statuses[0].Code)
+	assert.Equal(t, uint32(1), resp.Statuses[0].LimitRemaining)
+	assert.Equal(t, CodeOK, resp.Statuses[1].Code)
+}
+
+func TestServiceShouldRateLimitLimiterFull(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewService(testLimiter(t, 1), nil)
+	require.NoError(t, err)
+
+	resp, err := s.ShouldRateLimit(ctx, &RateLimitRequest{
+		Domain: "edge",
+		Descriptors: []Descriptor{
+			descriptor(ActionKey, "login", RemoteAddressKey, "127.0.0.1"),
+			descriptor(ActionKey, "login", RemoteAddressKey, "127.0.0.2"),
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, CodeOverLimit, resp.OverallCode)
+	assert.Equal(t, CodeOK, resp.Statuses[0].Code)
+	assert.Equal(t, CodeOverLimit, resp.Statuses[1].Code)
+	assert.Greater(t, resp.Statuses[1].DurationUntilReset, time.Duration(0))
+}
+
+// errLimiter is a Limiter that returns an error for each request.
+type errLimiter struct{ err error }
+
+func (l errLimiter) AllowN(_, _, _, _ string, _ uint64) (bool, *rate.Quota, error) {
+	return false, nil, l.err
+}
+
+func TestServiceShouldRateLimitError(t *testing.T) {
+	s, err := NewService(errLimiter{err: rate.ErrStopped}, func(domain string, _ Descriptor) (rate.Request, bool) {
+		return rate.Request{Resource: domain, Action: "action"}, true
+	})
+	require.NoError(t, err)
+
+	_, err = s.ShouldRateLimit(context.Background(), &RateLimitRequest{Domain: "edge", Descriptors: []Descriptor{{}}})
+	assert.ErrorIs(t, err, rate.ErrStopped)
+}
+
+func TestClampUint32(t *testing.T) {
+	assert.Equal(t, uint32(5), clampUint32(5))
+	assert.Equal(t, uint32(math.MaxUint32), clampUint32(math.MaxUint64))
+}
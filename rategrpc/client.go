@@ -0,0 +1,109 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rategrpc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/hashicorp/go-rate"
+)
+
+// HeaderSetter is used by a Client to set the rate limit HTTP headers. It is
+// implemented by rate.Limiter and rate.NopLimiter.
+type HeaderSetter interface {
+	SetPolicyHeader(resource, action string, header http.Header) error
+	SetUsageHeader(quota *rate.Quota, header http.Header)
+	SetHeaders(resource, action string, quota *rate.Quota, header http.Header) error
+}
+
+// Client checks requests using a LimiterService, typically a remote one that
+// is run as a dedicated rate limit service. It has the same methods for
+// checking requests and setting the rate limit HTTP headers as rate.Limiter,
+// so that it can be used in its place, such as by a ratehttp.Middleware.
+type Client struct {
+	client  LimiterServiceClient
+	headers HeaderSetter
+}
+
+// NewClient creates a Client that checks requests using the client, and sets
+// the rate limit HTTP headers using headers. Since the headers are set without
+// calling the LimiterService, headers is typically a rate.Limiter created with
+// the same limits and header options as the LimiterService's Limiter, which is
+// only used to set headers. If headers is nil, rate.NopLimiter is used, so no
+// headers are set.
+func NewClient(client LimiterServiceClient, headers HeaderSetter) (*Client, error) {
+	const op = "rategrpc.NewClient"
+	if client == nil {
+		return nil, fmt.Errorf("%s: missing client: %w", op, rate.ErrInvalidParameter)
+	}
+	if headers == nil {
+		headers = rate.NopLimiter
+	}
+	return &Client{client: client, headers: headers}, nil
+}
+
+// SetPolicyHeader sets the rate limit policy HTTP header for the provided
+// resource and action using the Client's HeaderSetter.
+func (c *Client) SetPolicyHeader(resource, action string, header http.Header) error {
+	return c.headers.SetPolicyHeader(resource, action, header)
+}
+
+// SetUsageHeader sets the rate limit usage HTTP header using the provided
+// Quota, such as one returned by AllowN, using the Client's HeaderSetter.
+func (c *Client) SetUsageHeader(quota *rate.Quota, header http.Header) {
+	c.headers.SetUsageHeader(quota, header)
+}
+
+// SetHeaders sets both the rate limit policy and usage HTTP headers using the
+// Client's HeaderSetter.
+func (c *Client) SetHeaders(resource, action string, quota *rate.Quota, header http.Header) error {
+	return c.headers.SetHeaders(resource, action, quota, header)
+}
+
+// Allow checks a request, as with rate.Limiter.Allow.
+func (c *Client) Allow(resource, action, ip, authToken string) (bool, *rate.Quota, error) {
+	return c.AllowN(resource, action, ip, authToken, 1)
+}
+
+// AllowN checks a request that costs n requests, as with rate.Limiter.AllowN.
+// The returned Quota is a copy of the state of the LimiterService's quota, so
+// consuming from it does not affect the LimiterService.
+func (c *Client) AllowN(resource, action, ip, authToken string, n uint64) (bool, *rate.Quota, error) {
+	return c.AllowNContext(context.Background(), resource, action, ip, authToken, n)
+}
+
+// AllowNContext is like AllowN, but uses ctx for the call to the
+// LimiterService.
+func (c *Client) AllowNContext(ctx context.Context, resource, action, ip, authToken string, n uint64) (bool, *rate.Quota, error) {
+	const op = "rategrpc.(Client).AllowNContext"
+
+	resp, err := c.client.Allow(ctx, &AllowRequest{
+		Resource:  resource,
+		Action:    action,
+		IPAddress: ip,
+		AuthToken: authToken,
+		Cost:      n,
+	})
+	if err != nil {
+		return false, nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return resp.Allowed, quotaFromInfo(resource, action, resp.Quota), nil
+}
+
+// quotaFromInfo returns a Quota with the state of info, or nil if info is
+// nil.
+func quotaFromInfo(resource, action string, info *QuotaInfo) *rate.Quota {
+	if info == nil {
+		return nil
+	}
+	limit := &rate.Limited{
+		Resource:    resource,
+		Action:      action,
+		Per:         info.Per,
+		MaxRequests: info.MaxRequests,
+	}
+	return rate.QuotaState{Used: info.Used, ExpiresAt: info.ExpiresAt}.Quota(limit)
+}
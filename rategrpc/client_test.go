@@ -0,0 +1,59 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rategrpc
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-rate"
+	"github.com/hashicorp/go-rate/ratehttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	_, err := NewClient(nil, nil)
+	assert.ErrorIs(t, err, rate.ErrInvalidParameter)
+
+	s, err := NewLimiterService(testLimiter(t))
+	require.NoError(t, err)
+	c, err := NewClient(s, testLimiter(t))
+	require.NoError(t, err)
+
+	allowed, quota, err := c.Allow("resource", "action", "127.0.0.1", "")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	require.NotNil(t, quota)
+	assert.Equal(t, uint64(2), quota.MaxRequests())
+	assert.Equal(t, uint64(1), quota.Remaining())
+	assert.InDelta(t, time.Minute, quota.ResetsIn(), float64(time.Second))
+
+	allowed, quota, err = c.AllowN("resource", "action", "127.0.0.1", "", 2)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, uint64(1), quota.Remaining())
+
+	_, _, err = c.Allow("unknown", "action", "127.0.0.1", "")
+	assert.ErrorIs(t, err, rate.ErrLimitPolicyNotFound)
+
+	t.Run("Headers", func(t *testing.T) {
+		_, quota, err := c.Allow("resource", "action", "127.0.0.2", "")
+		require.NoError(t, err)
+		header := http.Header{}
+		require.NoError(t, c.SetHeaders("resource", "action", quota, header))
+		assert.Equal(t, "100;w=60;comment=\"total\", 2;w=60;comment=\"ip-address\"", header.Get(rate.DefaultPolicyHeader))
+		assert.Equal(t, "limit=2, remaining=1, reset=60", header.Get(rate.DefaultUsageHeader))
+
+		// Without a HeaderSetter, no headers are set.
+		c, err := NewClient(s, nil)
+		require.NoError(t, err)
+		header = http.Header{}
+		require.NoError(t, c.SetHeaders("resource", "action", quota, header))
+		assert.Empty(t, header)
+	})
+}
+
+var _ ratehttp.Limiter = (*Client)(nil)
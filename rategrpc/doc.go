@@ -0,0 +1,22 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package rategrpc provides helpers for using a rate.Limiter with gRPC
+// services, where the resource of a request is the full name of its service,
+// and the action is the name of its method.
+//
+// This package does not depend on the gRPC and protobuf modules. They are only
+// required by the github.com/hashicorp/go-rate/rategrpc/proto module, which
+// provides the gRPC transport of LimiterService. Services are instead
+// described using Service, which can be created from a
+// protoreflect.ServiceDescriptor with:
+//
+//	svc := rategrpc.Service{Name: string(sd.FullName())}
+//	for i := 0; i < sd.Methods().Len(); i++ {
+//		svc.Methods = append(svc.Methods, string(sd.Methods().Get(i).Name()))
+//	}
+//
+// A Limiter can also be run as a dedicated rate limit service using
+// LimiterService, which implements the service defined in
+// proto/limiter.proto, and called using a Client.
+package rategrpc
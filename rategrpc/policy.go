@@ -0,0 +1,100 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rategrpc
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/go-rate"
+)
+
+// Service describes a gRPC service.
+type Service struct {
+	// Name is the full name of the service, such as "example.v1.Greeter".
+	Name string
+	// Methods are the names of the service's methods, such as "SayHello".
+	Methods []string
+}
+
+// Limits returns a skeleton of limits for each method of the services, which
+// can be used to bootstrap the limits of a Limiter. For each method, a copy of
+// each of the template limits is made with its Resource set to the service's
+// name and its Action set to the method's name. Any Resource and Action of the
+// template limits are ignored.
+func Limits(services []Service, template ...rate.Limit) []rate.Limit {
+	var n int
+	for _, svc := range services {
+		n += len(svc.Methods) * len(template)
+	}
+
+	limits := make([]rate.Limit, 0, n)
+	for _, svc := range services {
+		for _, method := range svc.Methods {
+			for _, t := range template {
+				switch tl := t.(type) {
+				case *rate.Limited:
+					l := *tl
+					l.Resource, l.Action = svc.Name, method
+					limits = append(limits, &l)
+				case *rate.Unlimited:
+					l := *tl
+					l.Resource, l.Action = svc.Name, method
+					limits = append(limits, &l)
+				}
+			}
+		}
+	}
+	return limits
+}
+
+// PolicySetter is used by CheckPolicies to check if a Limiter has a limit
+// policy. It is implemented by rate.Limiter.
+type PolicySetter interface {
+	SetPolicyHeader(resource, action string, header http.Header) error
+}
+
+// CheckPolicies checks that the Limiter has a limit policy for each method of
+// the services, so that a service can fail fast on startup if any of its
+// methods are not mapped to limits, rather than allowing requests to them
+// without limiting them. If there are methods without a policy, the returned
+// error wraps rate.ErrLimitPolicyNotFound for each of them.
+func CheckPolicies(l PolicySetter, services []Service) error {
+	const op = "rategrpc.CheckPolicies"
+
+	var errs []error
+	header := http.Header{}
+	for _, svc := range services {
+		for _, method := range svc.Methods {
+			err := l.SetPolicyHeader(svc.Name, method, header)
+			if errors.Is(err, rate.ErrLimitPolicyNotFound) {
+				errs = append(errs, fmt.Errorf("%s: %w", FullMethod(svc.Name, method), err))
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// FullMethod returns the full method name of a service's method, in the form
+// used by gRPC, such as "/example.v1.Greeter/SayHello".
+func FullMethod(service, method string) string {
+	return "/" + service + "/" + method
+}
+
+// Policy returns the resource and action of a request from its full method
+// name, such as the FullMethod of a grpc.UnaryServerInfo. The resource is the
+// full name of the service and the action is the name of the method. If the
+// full method name is not valid, empty strings are returned.
+func Policy(fullMethod string) (resource, action string) {
+	service, method, ok := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !ok || service == "" || method == "" || strings.Contains(method, "/") {
+		return "", ""
+	}
+	return service, method
+}
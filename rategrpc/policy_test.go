@@ -0,0 +1,80 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rategrpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-rate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testServices = []Service{
+	{Name: "example.v1.Greeter", Methods: []string{"SayHello", "SayGoodbye"}},
+	{Name: "example.v1.Admin", Methods: []string{"Reset"}},
+}
+
+func TestLimits(t *testing.T) {
+	got := Limits(testServices,
+		&rate.Limited{Resource: "ignored", Per: rate.LimitPerTotal, MaxRequests: 100, Period: time.Minute},
+		&rate.Unlimited{Per: rate.LimitPerIPAddress},
+	)
+	want := []rate.Limit{
+		&rate.Limited{Resource: "example.v1.Greeter", Action: "SayHello", Per: rate.LimitPerTotal, MaxRequests: 100, Period: time.Minute},
+		&rate.Unlimited{Resource: "example.v1.Greeter", Action: "SayHello", Per: rate.LimitPerIPAddress},
+		&rate.Limited{Resource: "example.v1.Greeter", Action: "SayGoodbye", Per: rate.LimitPerTotal, MaxRequests: 100, Period: time.Minute},
+		&rate.Unlimited{Resource: "example.v1.Greeter", Action: "SayGoodbye", Per: rate.LimitPerIPAddress},
+		&rate.Limited{Resource: "example.v1.Admin", Action: "Reset", Per: rate.LimitPerTotal, MaxRequests: 100, Period: time.Minute},
+		&rate.Unlimited{Resource: "example.v1.Admin", Action: "Reset", Per: rate.LimitPerIPAddress},
+	}
+	assert.Equal(t, want, got)
+
+	l, err := rate.NewLimiter(got, 10)
+	require.NoError(t, err)
+	defer l.Shutdown()
+	assert.NoError(t, CheckPolicies(l, testServices))
+}
+
+func TestCheckPolicies(t *testing.T) {
+	l, err := rate.NewLimiter(Limits(testServices[:1],
+		&rate.Limited{Per: rate.LimitPerTotal, MaxRequests: 100, Period: time.Minute},
+	), 10)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	services := append(testServices, Service{Name: "example.v1.Greeter", Methods: []string{"SayHi"}})
+	err = CheckPolicies(l, services)
+	require.ErrorIs(t, err, rate.ErrLimitPolicyNotFound)
+	assert.Contains(t, err.Error(), "/example.v1.Admin/Reset")
+	assert.Contains(t, err.Error(), "/example.v1.Greeter/SayHi")
+	assert.NotContains(t, err.Error(), "SayHello")
+
+	assert.NoError(t, CheckPolicies(rate.NopLimiter, services))
+}
+
+func TestPolicy(t *testing.T) {
+	cases := []struct {
+		in           string
+		wantResource string
+		wantAction   string
+	}{
+		{"/example.v1.Greeter/SayHello", "example.v1.Greeter", "SayHello"},
+		{"example.v1.Greeter/SayHello", "example.v1.Greeter", "SayHello"},
+		{"/example.v1.Greeter", "", ""},
+		{"/example.v1.Greeter/", "", ""},
+		{"//SayHello", "", ""},
+		{"/a/b/c", "", ""},
+		{"", "", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.in, func(t *testing.T) {
+			resource, action := Policy(tc.in)
+			assert.Equal(t, tc.wantResource, resource)
+			assert.Equal(t, tc.wantAction, action)
+		})
+	}
+	assert.Equal(t, "/example.v1.Greeter/SayHello", FullMethod("example.v1.Greeter", "SayHello"))
+}
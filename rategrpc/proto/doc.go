@@ -0,0 +1,23 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package ratepb is the gRPC transport of the LimiterService defined in
+// limiter.proto, which is implemented by rategrpc.LimiterService and called
+// using a rategrpc.Client.
+//
+// A LimiterService is served by registering a Server with a gRPC server:
+//
+//	svc, err := rategrpc.NewLimiterService(limiter)
+//	...
+//	srv, err := ratepb.NewServer(svc)
+//	...
+//	ratepb.RegisterLimiterServiceServer(s, srv)
+//
+// and is called over a gRPC connection using a Remote:
+//
+//	client, err := rategrpc.NewClient(ratepb.NewRemote(conn), headers)
+//
+// The errors returned by the rate.Limiter are sent as gRPC statuses, and
+// Remote returns the same errors, so that they can be checked with errors.Is
+// and errors.As.
+package ratepb
@@ -0,0 +1,254 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: limiter.proto
+
+package ratepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	LimiterService_Allow_FullMethodName      = "/hashicorp.rate.v1.LimiterService/Allow"
+	LimiterService_Check_FullMethodName      = "/hashicorp.rate.v1.LimiterService/Check"
+	LimiterService_ListQuotas_FullMethodName = "/hashicorp.rate.v1.LimiterService/ListQuotas"
+	LimiterService_ResetQuota_FullMethodName = "/hashicorp.rate.v1.LimiterService/ResetQuota"
+)
+
+// LimiterServiceClient is the client API for LimiterService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// LimiterService exposes a Limiter over the network, so that it can be run as
+// a dedicated rate limit service. It is implemented by rategrpc.LimiterService.
+type LimiterServiceClient interface {
+	// Allow checks a request, and consumes from its quotas if it is allowed.
+	Allow(ctx context.Context, in *AllowRequest, opts ...grpc.CallOption) (*AllowResponse, error)
+	// Check checks whether a request would be allowed, without consuming from
+	// its quotas.
+	Check(ctx context.Context, in *AllowRequest, opts ...grpc.CallOption) (*AllowResponse, error)
+	// ListQuotas lists the quotas that a request would use.
+	ListQuotas(ctx context.Context, in *ListQuotasRequest, opts ...grpc.CallOption) (*ListQuotasResponse, error)
+	// ResetQuota resets a quota, so that it has all of its requests available.
+	ResetQuota(ctx context.Context, in *ResetQuotaRequest, opts ...grpc.CallOption) (*ResetQuotaResponse, error)
+}
+
+type limiterServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLimiterServiceClient(cc grpc.ClientConnInterface) LimiterServiceClient {
+	return &limiterServiceClient{cc}
+}
+
+func (c *limiterServiceClient) Allow(ctx context.Context, in *AllowRequest, opts ...grpc.CallOption) (*AllowResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AllowResponse)
+	err := c.cc.Invoke(ctx, LimiterService_Allow_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *limiterServiceClient) Check(ctx context.Context, in *AllowRequest, opts ...grpc.CallOption) (*AllowResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AllowResponse)
+	err := c.cc.Invoke(ctx, LimiterService_Check_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *limiterServiceClient) ListQuotas(ctx context.Context, in *ListQuotasRequest, opts ...grpc.CallOption) (*ListQuotasResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListQuotasResponse)
+	err := c.cc.Invoke(ctx, LimiterService_ListQuotas_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *limiterServiceClient) ResetQuota(ctx context.Context, in *ResetQuotaRequest, opts ...grpc.CallOption) (*ResetQuotaResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ResetQuotaResponse)
+	err := c.cc.Invoke(ctx, LimiterService_ResetQuota_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// LimiterServiceServer is the server API for LimiterService service.
+// All implementations must embed UnimplementedLimiterServiceServer
+// for forward compatibility.
+//
+// LimiterService exposes a Limiter over the network, so that it can be run as
+// a dedicated rate limit service. It is implemented by rategrpc.LimiterService.
+type LimiterServiceServer interface {
+	// Allow checks a request, and consumes from its quotas if it is allowed.
+	Allow(context.Context, *AllowRequest) (*AllowResponse, error)
+	// Check checks whether a request would be allowed, without consuming from
+	// its quotas.
+	Check(context.Context, *AllowRequest) (*AllowResponse, error)
+	// ListQuotas lists the quotas that a request would use.
+	ListQuotas(context.Context, *ListQuotasRequest) (*ListQuotasResponse, error)
+	// ResetQuota resets a quota, so that it has all of its requests available.
+	ResetQuota(context.Context, *ResetQuotaRequest) (*ResetQuotaResponse, error)
+	mustEmbedUnimplementedLimiterServiceServer()
+}
+
+// UnimplementedLimiterServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedLimiterServiceServer struct{}
+
+func (UnimplementedLimiterServiceServer) Allow(context.Context, *AllowRequest) (*AllowResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Allow not implemented")
+}
+func (UnimplementedLimiterServiceServer) Check(context.Context, *AllowRequest) (*AllowResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Check not implemented")
+}
+func (UnimplementedLimiterServiceServer) ListQuotas(context.Context, *ListQuotasRequest) (*ListQuotasResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListQuotas not implemented")
+}
+func (UnimplementedLimiterServiceServer) ResetQuota(context.Context, *ResetQuotaRequest) (*ResetQuotaResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ResetQuota not implemented")
+}
+func (UnimplementedLimiterServiceServer) mustEmbedUnimplementedLimiterServiceServer() {}
+func (UnimplementedLimiterServiceServer) testEmbeddedByValue()                        {}
+
+// UnsafeLimiterServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LimiterServiceServer will
+// result in compilation errors.
+type UnsafeLimiterServiceServer interface {
+	mustEmbedUnimplementedLimiterServiceServer()
+}
+
+func RegisterLimiterServiceServer(s grpc.ServiceRegistrar, srv LimiterServiceServer) {
+	// If the following call panics, it indicates UnimplementedLimiterServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&LimiterService_ServiceDesc, srv)
+}
+
+func _LimiterService_Allow_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AllowRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LimiterServiceServer).Allow(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LimiterService_Allow_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LimiterServiceServer).Allow(ctx, req.(*AllowRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LimiterService_Check_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AllowRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LimiterServiceServer).Check(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LimiterService_Check_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LimiterServiceServer).Check(ctx, req.(*AllowRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LimiterService_ListQuotas_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListQuotasRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LimiterServiceServer).ListQuotas(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LimiterService_ListQuotas_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LimiterServiceServer).ListQuotas(ctx, req.(*ListQuotasRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LimiterService_ResetQuota_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResetQuotaRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LimiterServiceServer).ResetQuota(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LimiterService_ResetQuota_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LimiterServiceServer).ResetQuota(ctx, req.(*ResetQuotaRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// LimiterService_ServiceDesc is the grpc.ServiceDesc for LimiterService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var LimiterService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "hashicorp.rate.v1.LimiterService",
+	HandlerType: (*LimiterServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Allow",
+			Handler:    _LimiterService_Allow_Handler,
+		},
+		{
+			MethodName: "Check",
+			Handler:    _LimiterService_Check_Handler,
+		},
+		{
+			MethodName: "ListQuotas",
+			Handler:    _LimiterService_ListQuotas_Handler,
+		},
+		{
+			MethodName: "ResetQuota",
+			Handler:    _LimiterService_ResetQuota_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "limiter.proto",
+}
@@ -0,0 +1,99 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratepb
+
+import (
+	"context"
+
+	"github.com/hashicorp/go-rate"
+	"github.com/hashicorp/go-rate/rategrpc"
+	"google.golang.org/grpc"
+)
+
+// Remote implements rategrpc.LimiterServiceClient by calling a LimiterService
+// over a gRPC connection, so that it can be used by a rategrpc.Client.
+type Remote struct {
+	client LimiterServiceClient
+}
+
+// NewRemote creates a Remote that calls the LimiterService served on cc.
+func NewRemote(cc grpc.ClientConnInterface) *Remote {
+	return &Remote{client: NewLimiterServiceClient(cc)}
+}
+
+// Allow calls the LimiterService's Allow method.
+func (r *Remote) Allow(ctx context.Context, req *rategrpc.AllowRequest) (*rategrpc.AllowResponse, error) {
+	resp, err := r.client.Allow(ctx, allowRequestPB(req))
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	return &rategrpc.AllowResponse{Allowed: resp.GetAllowed(), Quota: quotaInfoPB(resp.GetQuota())}, nil
+}
+
+// Check calls the LimiterService's Check method.
+func (r *Remote) Check(ctx context.Context, req *rategrpc.AllowRequest) (*rategrpc.AllowResponse, error) {
+	resp, err := r.client.Check(ctx, allowRequestPB(req))
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	return &rategrpc.AllowResponse{Allowed: resp.GetAllowed(), Quota: quotaInfoPB(resp.GetQuota())}, nil
+}
+
+// ListQuotas calls the LimiterService's ListQuotas method.
+func (r *Remote) ListQuotas(ctx context.Context, req *rategrpc.ListQuotasRequest) (*rategrpc.ListQuotasResponse, error) {
+	resp, err := r.client.ListQuotas(ctx, &ListQuotasRequest{
+		Resource:  req.Resource,
+		Action:    req.Action,
+		IpAddress: req.IPAddress,
+		AuthToken: req.AuthToken,
+	})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	quotas := make([]*rategrpc.QuotaInfo, 0, len(resp.GetQuotas()))
+	for _, q := range resp.GetQuotas() {
+		quotas = append(quotas, quotaInfoPB(q))
+	}
+	return &rategrpc.ListQuotasResponse{Quotas: quotas}, nil
+}
+
+// ResetQuota calls the LimiterService's ResetQuota method.
+func (r *Remote) ResetQuota(ctx context.Context, req *rategrpc.ResetQuotaRequest) (*rategrpc.ResetQuotaResponse, error) {
+	_, err := r.client.ResetQuota(ctx, &ResetQuotaRequest{
+		Resource: req.Resource,
+		Action:   req.Action,
+		Per:      string(req.Per),
+		Id:       req.ID,
+	})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	return &rategrpc.ResetQuotaResponse{}, nil
+}
+
+// allowRequestPB returns req as an AllowRequest.
+func allowRequestPB(req *rategrpc.AllowRequest) *AllowRequest {
+	return &AllowRequest{
+		Resource:  req.Resource,
+		Action:    req.Action,
+		IpAddress: req.IPAddress,
+		AuthToken: req.AuthToken,
+		Cost:      req.Cost,
+	}
+}
+
+// quotaInfoPB returns info as a rategrpc.QuotaInfo, or nil if info is nil.
+func quotaInfoPB(info *QuotaInfo) *rategrpc.QuotaInfo {
+	if info == nil {
+		return nil
+	}
+	return &rategrpc.QuotaInfo{
+		Per:         rate.LimitPer(info.GetPer()),
+		MaxRequests: info.GetMaxRequests(),
+		Used:        info.GetUsed(),
+		ExpiresAt:   info.GetExpiresAt().AsTime(),
+	}
+}
+
+var _ rategrpc.LimiterServiceClient = (*Remote)(nil)
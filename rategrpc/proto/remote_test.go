@@ -0,0 +1,134 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratepb
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-rate"
+	"github.com/hashicorp/go-rate/rategrpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testRemote serves a LimiterService using the limiter over an in-memory
+// connection, and returns a Remote connected to it.
+func testRemote(t *testing.T, limiter *rate.Limiter) *Remote {
+	t.Helper()
+	svc, err := rategrpc.NewLimiterService(limiter)
+	require.NoError(t, err)
+	srv, err := NewServer(svc)
+	require.NoError(t, err)
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	RegisterLimiterServiceServer(s, srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return NewRemote(conn)
+}
+
+func testLimiter(t *testing.T, maxSize int) *rate.Limiter {
+	t.Helper()
+	l, err := rate.NewLimiter(
+		[]rate.Limit{
+			&rate.Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         rate.LimitPerTotal,
+				MaxRequests: 100,
+				Period:      time.Minute,
+			},
+			&rate.Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         rate.LimitPerIPAddress,
+				MaxRequests: 2,
+				Period:      time.Minute,
+			},
+			&rate.Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      rate.LimitPerAuthToken,
+			},
+		},
+		maxSize,
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Shutdown() })
+	return l
+}
+
+func TestNewServer(t *testing.T) {
+	_, err := NewServer(nil)
+	assert.ErrorIs(t, err, rate.ErrInvalidParameter)
+}
+
+func TestRemote(t *testing.T) {
+	ctx := context.Background()
+	r := testRemote(t, testLimiter(t, 10))
+	c, err := rategrpc.NewClient(r, testLimiter(t, 10))
+	require.NoError(t, err)
+
+	allowed, quota, err := c.Allow("resource", "action", "127.0.0.1", "")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	require.NotNil(t, quota)
+	assert.Equal(t, uint64(2), quota.MaxRequests())
+	assert.Equal(t, uint64(1), quota.Remaining())
+	assert.InDelta(t, time.Minute, quota.ResetsIn(), float64(time.Second))
+
+	allowed, _, err = c.AllowN("resource", "action", "127.0.0.1", "", 2)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	resp, err := r.Check(ctx, &rategrpc.AllowRequest{Resource: "resource", Action: "action", IPAddress: "127.0.0.1"})
+	require.NoError(t, err)
+	assert.True(t, resp.Allowed)
+	assert.Equal(t, uint64(1), resp.Quota.Used)
+
+	list, err := r.ListQuotas(ctx, &rategrpc.ListQuotasRequest{Resource: "resource", Action: "action", IPAddress: "127.0.0.1"})
+	require.NoError(t, err)
+	require.Len(t, list.Quotas, 2)
+	assert.Equal(t, rate.LimitPerIPAddress, list.Quotas[0].Per)
+	assert.Equal(t, uint64(1), list.Quotas[0].Used)
+	assert.Equal(t, rate.LimitPerTotal, list.Quotas[1].Per)
+
+	_, err = r.ResetQuota(ctx, &rategrpc.ResetQuotaRequest{Resource: "resource", Action: "action", Per: rate.LimitPerIPAddress, ID: "127.0.0.1"})
+	require.NoError(t, err)
+	list, err = r.ListQuotas(ctx, &rategrpc.ListQuotasRequest{Resource: "resource", Action: "action", IPAddress: "127.0.0.1"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), list.Quotas[0].Used)
+
+	t.Run("Errors", func(t *testing.T) {
+		_, _, err := c.Allow("unknown", "action", "127.0.0.1", "")
+		assert.ErrorIs(t, err, rate.ErrLimitPolicyNotFound)
+		_, err = r.ResetQuota(ctx, &rategrpc.ResetQuotaRequest{Resource: "resource", Action: "action", Per: rate.LimitPerAuthToken, ID: "token"})
+		assert.ErrorIs(t, err, rate.ErrLimitNotFound)
+		_, err = r.ResetQuota(ctx, &rategrpc.ResetQuotaRequest{Resource: "resource", Action: "action", Per: "everyone"})
+		assert.ErrorIs(t, err, rate.ErrInvalidLimitPer)
+	})
+
+	t.Run("LimiterFull", func(t *testing.T) {
+		r := testRemote(t, testLimiter(t, 1))
+		_, err := r.Allow(ctx, &rategrpc.AllowRequest{Resource: "resource", Action: "action", IPAddress: "127.0.0.1"})
+		require.NoError(t, err)
+		_, err = r.Allow(ctx, &rategrpc.AllowRequest{Resource: "resource", Action: "action", IPAddress: "127.0.0.2"})
+		var full *rate.ErrLimiterFull
+		require.ErrorAs(t, err, &full)
+		assert.Greater(t, full.RetryIn, time.Duration(0))
+	})
+}
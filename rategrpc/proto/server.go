@@ -0,0 +1,105 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratepb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/go-rate"
+	"github.com/hashicorp/go-rate/rategrpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Server implements LimiterServiceServer using a rategrpc.LimiterService.
+type Server struct {
+	UnimplementedLimiterServiceServer
+	svc *rategrpc.LimiterService
+}
+
+// NewServer creates a Server that serves requests using svc.
+func NewServer(svc *rategrpc.LimiterService) (*Server, error) {
+	const op = "ratepb.NewServer"
+	if svc == nil {
+		return nil, fmt.Errorf("%s: missing limiter service: %w", op, rate.ErrInvalidParameter)
+	}
+	return &Server{svc: svc}, nil
+}
+
+// Allow checks a request using rategrpc.LimiterService.Allow.
+func (s *Server) Allow(ctx context.Context, req *AllowRequest) (*AllowResponse, error) {
+	resp, err := s.svc.Allow(ctx, allowRequest(req))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &AllowResponse{Allowed: resp.Allowed, Quota: quotaInfo(resp.Quota)}, nil
+}
+
+// Check checks a request using rategrpc.LimiterService.Check.
+func (s *Server) Check(ctx context.Context, req *AllowRequest) (*AllowResponse, error) {
+	resp, err := s.svc.Check(ctx, allowRequest(req))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &AllowResponse{Allowed: resp.Allowed, Quota: quotaInfo(resp.Quota)}, nil
+}
+
+// ListQuotas lists the quotas of a request using
+// rategrpc.LimiterService.ListQuotas.
+func (s *Server) ListQuotas(ctx context.Context, req *ListQuotasRequest) (*ListQuotasResponse, error) {
+	resp, err := s.svc.ListQuotas(ctx, &rategrpc.ListQuotasRequest{
+		Resource:  req.GetResource(),
+		Action:    req.GetAction(),
+		IPAddress: req.GetIpAddress(),
+		AuthToken: req.GetAuthToken(),
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	quotas := make([]*QuotaInfo, 0, len(resp.Quotas))
+	for _, q := range resp.Quotas {
+		quotas = append(quotas, quotaInfo(q))
+	}
+	return &ListQuotasResponse{Quotas: quotas}, nil
+}
+
+// ResetQuota resets a quota using rategrpc.LimiterService.ResetQuota.
+func (s *Server) ResetQuota(ctx context.Context, req *ResetQuotaRequest) (*ResetQuotaResponse, error) {
+	_, err := s.svc.ResetQuota(ctx, &rategrpc.ResetQuotaRequest{
+		Resource: req.GetResource(),
+		Action:   req.GetAction(),
+		Per:      rate.LimitPer(req.GetPer()),
+		ID:       req.GetId(),
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &ResetQuotaResponse{}, nil
+}
+
+// allowRequest returns req as a rategrpc.AllowRequest.
+func allowRequest(req *AllowRequest) *rategrpc.AllowRequest {
+	return &rategrpc.AllowRequest{
+		Resource:  req.GetResource(),
+		Action:    req.GetAction(),
+		IPAddress: req.GetIpAddress(),
+		AuthToken: req.GetAuthToken(),
+		Cost:      req.GetCost(),
+	}
+}
+
+// quotaInfo returns info as a QuotaInfo, or nil if info is nil.
+func quotaInfo(info *rategrpc.QuotaInfo) *QuotaInfo {
+	if info == nil {
+		return nil
+	}
+	return &QuotaInfo{
+		Per:         string(info.Per),
+		MaxRequests: info.MaxRequests,
+		Used:        info.Used,
+		ExpiresAt:   timestamppb.New(info.ExpiresAt),
+	}
+}
+
+var _ LimiterServiceServer = (*Server)(nil)
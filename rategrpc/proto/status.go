@@ -0,0 +1,80 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratepb
+
+import (
+	"errors"
+	"time"
+
+	"github.com/hashicorp/go-rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// remoteErrors are the errors that are returned by a Remote as is when they
+// are returned by the Server's LimiterService, along with the code of their
+// status.
+var remoteErrors = []struct {
+	err  error
+	code codes.Code
+}{
+	{rate.ErrLimitPolicyNotFound, codes.NotFound},
+	{rate.ErrLimitNotFound, codes.NotFound},
+	{rate.ErrEmptyIdentity, codes.InvalidArgument},
+	{rate.ErrInvalidIPAddress, codes.InvalidArgument},
+	{rate.ErrInvalidLimitPer, codes.InvalidArgument},
+	{rate.ErrInvalidParameter, codes.InvalidArgument},
+	{rate.ErrNotSupported, codes.Unimplemented},
+	{rate.ErrStopped, codes.Unavailable},
+	{rate.ErrStoreUnavailable, codes.Unavailable},
+}
+
+// toStatus returns the status sent by a Server for err. The message of the
+// status is the message of the remoteError that err wraps, so that a Remote
+// can return it. If err wraps rate.ErrLimiterFull, a ResourceExhausted status
+// is returned, with a RetryInfo detail for its RetryIn.
+func toStatus(err error) error {
+	var full *rate.ErrLimiterFull
+	if errors.As(err, &full) {
+		st := status.New(codes.ResourceExhausted, full.Error())
+		if d, derr := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(full.RetryIn)}); derr == nil {
+			st = d
+		}
+		return st.Err()
+	}
+	for _, e := range remoteErrors {
+		if errors.Is(err, e.err) {
+			return status.Error(e.code, e.err.Error())
+		}
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// fromStatus returns the error for a status received by a Remote. Statuses
+// sent for remoteErrors and rate.ErrLimiterFull are returned as those errors,
+// and any other status is returned as is.
+func fromStatus(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	if st.Code() == codes.ResourceExhausted && st.Message() == (&rate.ErrLimiterFull{}).Error() {
+		full := &rate.ErrLimiterFull{}
+		for _, d := range st.Details() {
+			if ri, ok := d.(*errdetails.RetryInfo); ok {
+				full.RetryIn = ri.GetRetryDelay().AsDuration()
+			}
+		}
+		full.RetryAt = time.Now().Add(full.RetryIn)
+		return full
+	}
+	for _, e := range remoteErrors {
+		if st.Code() == e.code && st.Message() == e.err.Error() {
+			return e.err
+		}
+	}
+	return err
+}
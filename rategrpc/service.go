@@ -0,0 +1,169 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rategrpc
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/go-rate"
+)
+
+// AllowRequest is the request of LimiterService.Allow and
+// LimiterService.Check.
+type AllowRequest struct {
+	Resource  string
+	Action    string
+	IPAddress string
+	AuthToken string
+	// Cost is the number of requests that the request costs. If it is zero,
+	// the request costs 1. It is ignored by Check.
+	Cost uint64
+}
+
+// AllowResponse is the response of LimiterService.Allow and
+// LimiterService.Check.
+type AllowResponse struct {
+	Allowed bool
+	// Quota is the quota that denied the request, or the quota with the
+	// fewest remaining requests if it was allowed. It is nil if all of the
+	// limits for the request are Unlimited.
+	Quota *QuotaInfo
+}
+
+// ListQuotasRequest is the request of LimiterService.ListQuotas.
+type ListQuotasRequest struct {
+	Resource  string
+	Action    string
+	IPAddress string
+	AuthToken string
+}
+
+// ListQuotasResponse is the response of LimiterService.ListQuotas.
+type ListQuotasResponse struct {
+	Quotas []*QuotaInfo
+}
+
+// ResetQuotaRequest is the request of LimiterService.ResetQuota.
+type ResetQuotaRequest struct {
+	Resource string
+	Action   string
+	Per      rate.LimitPer
+	// ID is the IP address or auth token, and is ignored for
+	// rate.LimitPerTotal.
+	ID string
+}
+
+// ResetQuotaResponse is the response of LimiterService.ResetQuota.
+type ResetQuotaResponse struct{}
+
+// QuotaInfo is the state of a quota.
+type QuotaInfo struct {
+	// Per is only set by ListQuotas.
+	Per         rate.LimitPer
+	MaxRequests uint64
+	Used        uint64
+	ExpiresAt   time.Time
+}
+
+// newQuotaInfo returns the state of q, or nil if q is nil.
+func newQuotaInfo(per rate.LimitPer, q *rate.Quota) *QuotaInfo {
+	if q == nil {
+		return nil
+	}
+	maxReq, remaining := q.MaxRequests(), q.Remaining()
+	return &QuotaInfo{
+		Per:         per,
+		MaxRequests: maxReq,
+		Used:        maxReq - remaining + q.GraceUsed(),
+		ExpiresAt:   q.Expiration(),
+	}
+}
+
+// LimiterServiceClient is the client of the LimiterService gRPC service
+// defined in proto/limiter.proto. It is implemented by LimiterService, and by
+// ratepb.Remote for a LimiterService that is called over a gRPC connection.
+type LimiterServiceClient interface {
+	Allow(ctx context.Context, req *AllowRequest) (*AllowResponse, error)
+	Check(ctx context.Context, req *AllowRequest) (*AllowResponse, error)
+	ListQuotas(ctx context.Context, req *ListQuotasRequest) (*ListQuotasResponse, error)
+	ResetQuota(ctx context.Context, req *ResetQuotaRequest) (*ResetQuotaResponse, error)
+}
+
+// LimiterService implements the LimiterService gRPC service defined in
+// proto/limiter.proto using a rate.Limiter, so that the Limiter can be run as
+// a dedicated rate limit service. It is registered with a gRPC server using
+// ratepb.RegisterServer. Errors are returned as is, and are converted to gRPC
+// statuses by ratepb.Server.
+type LimiterService struct {
+	limiter *rate.Limiter
+}
+
+// NewLimiterService creates a LimiterService that uses the limiter.
+func NewLimiterService(limiter *rate.Limiter) (*LimiterService, error) {
+	const op = "rategrpc.NewLimiterService"
+	if limiter == nil {
+		return nil, fmt.Errorf("%s: missing limiter: %w", op, rate.ErrInvalidParameter)
+	}
+	return &LimiterService{limiter: limiter}, nil
+}
+
+// Allow checks a request using rate.Limiter.AllowN, consuming its Cost from
+// each of its quotas if it is allowed.
+func (s *LimiterService) Allow(_ context.Context, req *AllowRequest) (*AllowResponse, error) {
+	const op = "rategrpc.(LimiterService).Allow"
+
+	n := req.Cost
+	if n == 0 {
+		n = 1
+	}
+	allowed, quota, err := s.limiter.AllowN(req.Resource, req.Action, req.IPAddress, req.AuthToken, n)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return &AllowResponse{Allowed: allowed, Quota: newQuotaInfo("", quota)}, nil
+}
+
+// Check checks whether a request would be allowed, without consuming from
+// its quotas, using rate.Limiter.AllowN with a cost of zero.
+func (s *LimiterService) Check(_ context.Context, req *AllowRequest) (*AllowResponse, error) {
+	const op = "rategrpc.(LimiterService).Check"
+
+	allowed, quota, err := s.limiter.AllowN(req.Resource, req.Action, req.IPAddress, req.AuthToken, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return &AllowResponse{Allowed: allowed, Quota: newQuotaInfo("", quota)}, nil
+}
+
+// ListQuotas lists the quotas that a request would use, as with
+// rate.ReadOnlyLimiter.Quotas, sorted by Per.
+func (s *LimiterService) ListQuotas(_ context.Context, req *ListQuotasRequest) (*ListQuotasResponse, error) {
+	const op = "rategrpc.(LimiterService).ListQuotas"
+
+	quotas, err := s.limiter.ReadOnly().Quotas(req.Resource, req.Action, req.IPAddress, req.AuthToken)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	resp := &ListQuotasResponse{Quotas: make([]*QuotaInfo, 0, len(quotas))}
+	for per, q := range quotas {
+		resp.Quotas = append(resp.Quotas, newQuotaInfo(per, q))
+	}
+	sort.Slice(resp.Quotas, func(i, j int) bool { return resp.Quotas[i].Per < resp.Quotas[j].Per })
+	return resp, nil
+}
+
+// ResetQuota resets a quota using rate.Limiter.ResetQuota.
+func (s *LimiterService) ResetQuota(_ context.Context, req *ResetQuotaRequest) (*ResetQuotaResponse, error) {
+	const op = "rategrpc.(LimiterService).ResetQuota"
+
+	if err := s.limiter.ResetQuota(req.Resource, req.Action, req.Per, req.ID); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return &ResetQuotaResponse{}, nil
+}
+
+var _ LimiterServiceClient = (*LimiterService)(nil)
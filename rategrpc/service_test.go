@@ -0,0 +1,115 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rategrpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-rate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testLimiter(t *testing.T) *rate.Limiter {
+	t.Helper()
+	l, err := rate.NewLimiter(
+		[]rate.Limit{
+			&rate.Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         rate.LimitPerTotal,
+				MaxRequests: 100,
+				Period:      time.Minute,
+			},
+			&rate.Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         rate.LimitPerIPAddress,
+				MaxRequests: 2,
+				Period:      time.Minute,
+			},
+			&rate.Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      rate.LimitPerAuthToken,
+			},
+		},
+		10,
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Shutdown() })
+	return l
+}
+
+func TestNewLimiterService(t *testing.T) {
+	_, err := NewLimiterService(nil)
+	assert.ErrorIs(t, err, rate.ErrInvalidParameter)
+}
+
+func TestLimiterService(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewLimiterService(testLimiter(t))
+	require.NoError(t, err)
+	req := &AllowRequest{Resource: "resource", Action: "action", IPAddress: "127.0.0.1", Cost: 1}
+
+	// Check does not consume from the quotas.
+	for i := 0; i < 3; i++ {
+		resp, err := s.Check(ctx, req)
+		require.NoError(t, err)
+		assert.True(t, resp.Allowed)
+	}
+
+	resp, err := s.Allow(ctx, req)
+	require.NoError(t, err)
+	assert.True(t, resp.Allowed)
+	require.NotNil(t, resp.Quota)
+	assert.Equal(t, uint64(2), resp.Quota.MaxRequests)
+	assert.Equal(t, uint64(1), resp.Quota.Used)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), resp.Quota.ExpiresAt, time.Second)
+
+	resp, err = s.Allow(ctx, req)
+	require.NoError(t, err)
+	assert.True(t, resp.Allowed)
+	resp, err = s.Allow(ctx, req)
+	require.NoError(t, err)
+	assert.False(t, resp.Allowed)
+	assert.Equal(t, uint64(2), resp.Quota.Used)
+
+	list, err := s.ListQuotas(ctx, &ListQuotasRequest{Resource: "resource", Action: "action", IPAddress: "127.0.0.1"})
+	require.NoError(t, err)
+	require.Len(t, list.Quotas, 2)
+	assert.Equal(t, rate.LimitPerIPAddress, list.Quotas[0].Per)
+	assert.Equal(t, uint64(2), list.Quotas[0].Used)
+	assert.Equal(t, rate.LimitPerTotal, list.Quotas[1].Per)
+	assert.Equal(t, uint64(2), list.Quotas[1].Used)
+
+	_, err = s.ResetQuota(ctx, &ResetQuotaRequest{Resource: "resource", Action: "action", Per: rate.LimitPerIPAddress, ID: "127.0.0.1"})
+	require.NoError(t, err)
+	resp, err = s.Allow(ctx, req)
+	require.NoError(t, err)
+	assert.True(t, resp.Allowed)
+
+	t.Run("ZeroCost", func(t *testing.T) {
+		// A request without a cost costs 1, rather than being checked
+		// without consuming from its quotas.
+		resp, err := s.Allow(ctx, &AllowRequest{Resource: "resource", Action: "action", IPAddress: "127.0.0.2"})
+		require.NoError(t, err)
+		assert.True(t, resp.Allowed)
+		assert.Equal(t, uint64(1), resp.Quota.Used)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		unknown := &AllowRequest{Resource: "unknown", Action: "action", IPAddress: "127.0.0.1"}
+		_, err := s.Allow(ctx, unknown)
+		assert.ErrorIs(t, err, rate.ErrLimitPolicyNotFound)
+		_, err = s.Check(ctx, unknown)
+		assert.ErrorIs(t, err, rate.ErrLimitPolicyNotFound)
+		_, err = s.ListQuotas(ctx, &ListQuotasRequest{Resource: "unknown", Action: "action"})
+		assert.ErrorIs(t, err, rate.ErrLimitPolicyNotFound)
+		_, err = s.ResetQuota(ctx, &ResetQuotaRequest{Resource: "resource", Action: "action", Per: rate.LimitPerAuthToken, ID: "token"})
+		assert.ErrorIs(t, err, rate.ErrLimitNotFound)
+	})
+}
@@ -0,0 +1,135 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratehttp
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-rate"
+)
+
+// DefaultDedupMaxReplays is the default number of retries of each request
+// that are not charged when using WithDeduplication.
+const DefaultDedupMaxReplays = 3
+
+// IdempotencyKeyHeader returns the value of the request's Idempotency-Key
+// header.
+func IdempotencyKeyHeader(r *http.Request) string {
+	return r.Header.Get("Idempotency-Key")
+}
+
+// dedupKey identifies an allowed request. The client's identity is included
+// so that clients cannot use each other's idempotency keys.
+type dedupKey struct {
+	resource  string
+	action    string
+	ip        string
+	authToken string
+	key       string
+}
+
+// dedupEntry is a request that was allowed within the deduplication window.
+type dedupEntry struct {
+	// quota is the Quota returned when the request was allowed, which is used
+	// to set the rate limit usage header for retries.
+	quota     *rate.Quota
+	expiresAt time.Time
+	// replays is the number of retries of the request that were not charged.
+	replays int
+}
+
+// deduplicator records the idempotency keys of allowed requests, so that
+// up to maxReplays retries of each within the window are not charged again.
+type deduplicator struct {
+	window     time.Duration
+	maxKeys    int
+	maxReplays int
+	// clock is used to get the current time. If nil, the system time is
+	// used.
+	clock rate.Clock
+
+	mu   sync.Mutex
+	seen map[dedupKey]dedupEntry
+}
+
+func newDeduplicator(window time.Duration, maxKeys, maxReplays int, clock rate.Clock) *deduplicator {
+	return &deduplicator{
+		window:     window,
+		maxKeys:    maxKeys,
+		maxReplays: maxReplays,
+		clock:      clock,
+		seen:       make(map[dedupKey]dedupEntry),
+	}
+}
+
+func (d *deduplicator) currentTime() time.Time {
+	if d.clock == nil {
+		return time.Now()
+	}
+	return d.clock.Now()
+}
+
+// lookup records a retry of the allowed request with the provided key, and
+// returns its Quota. The returned bool is false if there is no such request
+// within the window, or if it has already been retried maxReplays times, in
+// which case the retry should be charged.
+func (d *deduplicator) lookup(k dedupKey) (*rate.Quota, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	e, ok := d.seen[k]
+	if !ok {
+		return nil, false
+	}
+	if d.currentTime().After(e.expiresAt) || e.replays >= d.maxReplays {
+		delete(d.seen, k)
+		return nil, false
+	}
+	e.replays++
+	d.seen[k] = e
+	return e.quota, true
+}
+
+// add records an allowed request with the provided key. If the deduplicator
+// has maxKeys keys, expired keys are removed first. If it is still full, the
+// request is not recorded, and so retries of it will be charged.
+func (d *deduplicator) add(k dedupKey, quota *rate.Quota) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.currentTime()
+	if _, ok := d.seen[k]; !ok && len(d.seen) >= d.maxKeys {
+		d.removeExpiredLocked(now)
+		if len(d.seen) >= d.maxKeys {
+			return
+		}
+	}
+	d.seen[k] = dedupEntry{quota: quota, expiresAt: now.Add(d.window)}
+}
+
+// remove removes the request with the provided key, so that retries of it
+// will be charged.
+func (d *deduplicator) remove(k dedupKey) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.seen, k)
+}
+
+// removeExpiredLocked removes all of the keys whose window has ended.
+//
+// removeExpiredLocked should always be called by a function that first acquires a lock
+func (d *deduplicator) removeExpiredLocked(now time.Time) {
+	const op = "ratehttp.(deduplicator).removeExpiredLocked"
+	if d.mu.TryLock() {
+		panic(fmt.Sprintf("%s: called without lock", op))
+	}
+	for k, e := range d.seen {
+		if now.After(e.expiresAt) {
+			delete(d.seen, k)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratehttp
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-rate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyKeyHeader(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", nil)
+	assert.Empty(t, IdempotencyKeyHeader(r))
+	r.Header.Set("Idempotency-Key", "key")
+	assert.Equal(t, "key", IdempotencyKeyHeader(r))
+}
+
+// testClock is a rate.Clock whose time only changes when it is advanced.
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) After(time.Duration) <-chan time.Time { return nil }
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func Test_deduplicator(t *testing.T) {
+	c := &testClock{now: time.Now()}
+	d := newDeduplicator(time.Minute, 2, 10, c)
+
+	k1 := dedupKey{resource: "resource", action: "action", ip: "127.0.0.1", key: "1"}
+	k2 := dedupKey{resource: "resource", action: "action", ip: "127.0.0.1", key: "2"}
+	k3 := dedupKey{resource: "resource", action: "action", ip: "127.0.0.1", key: "3"}
+	q := &rate.Quota{}
+
+	_, ok := d.lookup(k1)
+	assert.False(t, ok)
+
+	d.add(k1, q)
+	got, ok := d.lookup(k1)
+	assert.True(t, ok)
+	assert.Same(t, q, got)
+
+	// The same key for another client is not a retry.
+	_, ok = d.lookup(dedupKey{resource: "resource", action: "action", ip: "127.0.0.2", key: "1"})
+	assert.False(t, ok)
+
+	// Once full, new keys are not recorded until others expire.
+	c.Advance(30 * time.Second)
+	d.add(k2, nil)
+	d.add(k3, nil)
+	_, ok = d.lookup(k3)
+	assert.False(t, ok)
+
+	c.Advance(31 * time.Second)
+	_, ok = d.lookup(k1)
+	assert.False(t, ok)
+	_, ok = d.lookup(k2)
+	assert.True(t, ok)
+	d.add(k3, nil)
+	_, ok = d.lookup(k3)
+	assert.True(t, ok)
+
+	d.remove(k3)
+	_, ok = d.lookup(k3)
+	assert.False(t, ok)
+
+	d.mu.Lock()
+	assert.Len(t, d.seen, 1)
+	d.mu.Unlock()
+	assert.Panics(t, func() { d.removeExpiredLocked(c.Now()) })
+}
+
+func Test_deduplicatorMaxReplays(t *testing.T) {
+	d := newDeduplicator(time.Minute, 10, 2, nil)
+	k := dedupKey{resource: "resource", action: "action", ip: "127.0.0.1", key: "1"}
+
+	// Once the request has been retried maxReplays times, it is removed, so
+	// that its next retry is charged.
+	d.add(k, nil)
+	for i := 0; i < 2; i++ {
+		_, ok := d.lookup(k)
+		assert.True(t, ok)
+	}
+	_, ok := d.lookup(k)
+	assert.False(t, ok)
+	_, ok = d.lookup(k)
+	assert.False(t, ok)
+
+	// Once the retry is charged, it is recorded again.
+	d.add(k, nil)
+	_, ok = d.lookup(k)
+	assert.True(t, ok)
+}
@@ -0,0 +1,5 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package ratehttp provides helpers for using a rate.Limiter with net/http.
+package ratehttp
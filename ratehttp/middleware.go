@@ -0,0 +1,310 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratehttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/hashicorp/go-rate"
+)
+
+// Limiter is used by a Middleware to limit requests. It is implemented by
+// rate.Limiter and rate.NopLimiter.
+type Limiter interface {
+	AllowNContext(ctx context.Context, resource, action, ip, authToken string, n uint64) (bool, *rate.Quota, error)
+	SetPolicyHeader(resource, action string, header http.Header) error
+	SetUsageHeader(quota *rate.Quota, header http.Header)
+	SetHeaders(resource, action string, quota *rate.Quota, header http.Header) error
+}
+
+// Refunder is used by a Middleware to refund requests. It is implemented by
+// rate.Limiter and rate.NopLimiter.
+type Refunder interface {
+	Refund(resource, action, ip, authToken string, n uint64) error
+}
+
+// Clocker is used by a Middleware to get the current time, so that it uses
+// the same Clock as its Limiter. It is implemented by rate.Limiter and
+// rate.NopLimiter.
+type Clocker interface {
+	Clock() rate.Clock
+}
+
+// Charger is used by a Middleware to charge requests for additional requests
+// after they have been allowed. It is implemented by rate.Limiter and
+// rate.NopLimiter.
+type Charger interface {
+	Charge(resource, action, ip, authToken string, n uint64) error
+}
+
+// PolicyFunc returns the resource and action of a request. RESTPolicy is a
+// PolicyFunc for typical REST services.
+type PolicyFunc func(r *http.Request) (resource, action string)
+
+// Middleware limits the requests made to an http.Handler.
+type Middleware struct {
+	limiter  Limiter
+	refunder Refunder
+	charger  Charger
+	// clock is the Limiter's Clock, or nil if it does not implement Clocker.
+	clock    rate.Clock
+	policyFn PolicyFunc
+	opts     options
+}
+
+// NewMiddleware creates a Middleware that uses the limiter to check each
+// request, using the resource and action returned by policyFn. Allowed
+// requests have the rate limit policy and usage headers set, and are passed
+// to the next handler. Denied requests are written a 429 Too Many Requests
+// response, as described by WriteTooManyRequests. Requests whose context was
+// created by rate.WithBypass are passed to the next handler without being
+// limited. Requests for which the limiter has no policy are handled according
+// to the limiter's UnknownPolicyBehavior, so with rate.UnknownPolicyDeny they
+// are handled by the ErrorHandler, since the route is misconfigured.
+// Requests that are denied because the limiter is full have the Retry-After
+// header set to when the limiter expects to have capacity. Requests whose IP
+// address is invalid, or that have an empty identity that the limiter denies,
+// are written a 400 Bad Request response. Requests for which the limiter
+// returns any other error are handled by the ErrorHandler.
+//
+// The limiter is always used to set the rate limit usage header, so
+// WithUsageHeaderSetter is ignored.
+//
+// Supported options are:
+//   - WithIPAddressFunc: Provides the function used to get the IP address of
+//     the client. The default is RemoteIPAddress.
+//   - WithAuthTokenFunc: Provides the function used to get the auth token of
+//     the client. The default is AuthorizationHeader.
+//   - WithBodyTemplate, WithJSONBody, and WithProblemDetails: Writes a body
+//     for denied requests.
+//   - WithLimiterFullStatus: Provides the status code used when a request is
+//     denied because the limiter is full. The default is 429.
+//   - WithLimiterFullHeader: Provides the name of a header that is set when a
+//     request is denied because the limiter is full. The default is to not set
+//     a header.
+//   - WithCost: Provides the number of requests that each request costs. The
+//     default is 1.
+//   - WithRefundOnServerError: Refunds the cost of requests for which the next
+//     handler responds with a 5xx status code. The limiter must implement
+//     Refunder. The default is to not refund requests.
+//   - WithResponseSizeCharge: Charges requests for additional requests based
+//     on the size of the response body. The limiter must implement Charger.
+//     The default is to not charge requests based on the size of the response.
+//   - WithDeduplication: Charges requests with the same idempotency key only
+//     once within a window. The default is to not deduplicate requests.
+//   - WithDedupMaxReplays: Provides the most retries of each request that are
+//     not charged. The default is DefaultDedupMaxReplays.
+//   - WithIdempotencyKeyFunc: Provides the function used to get the
+//     idempotency key of a request. The default is IdempotencyKeyHeader.
+//   - WithErrorHandler: Provides the ErrorHandler for requests for which the
+//     limiter returns an error other than a denial. The default is
+//     DefaultErrorHandler.
+func NewMiddleware(limiter Limiter, policyFn PolicyFunc, opt ...Option) (*Middleware, error) {
+	const op = "ratehttp.NewMiddleware"
+
+	switch {
+	case limiter == nil:
+		return nil, fmt.Errorf("%s: missing limiter: %w", op, rate.ErrInvalidParameter)
+	case policyFn == nil:
+		return nil, fmt.Errorf("%s: missing policy func: %w", op, rate.ErrInvalidParameter)
+	}
+
+	opts := getOpts(opt...)
+	opts.withUsageHeaderSetter = limiter
+
+	refunder, _ := limiter.(Refunder)
+	if opts.withRefundServerError && refunder == nil {
+		return nil, fmt.Errorf("%s: limiter does not implement Refunder: %w", op, rate.ErrInvalidParameter)
+	}
+	charger, _ := limiter.(Charger)
+	if len(opts.withResponseSizeTiers) > 0 && charger == nil {
+		return nil, fmt.Errorf("%s: limiter does not implement Charger: %w", op, rate.ErrInvalidParameter)
+	}
+
+	var clock rate.Clock
+	if c, ok := limiter.(Clocker); ok {
+		clock = c.Clock()
+	}
+
+	return &Middleware{
+		limiter:  limiter,
+		refunder: refunder,
+		charger:  charger,
+		clock:    clock,
+		policyFn: policyFn,
+		opts:     opts,
+	}, nil
+}
+
+// Handler returns an http.Handler that limits requests before passing them
+// to next.
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return m.Route(next)
+}
+
+// Route is like Handler, but the provided options are applied on top of the
+// Middleware's options for requests to next. This allows a single Middleware
+// to be used for routes that are limited differently.
+//
+// In addition to the options supported by NewMiddleware, supported options
+// are:
+//   - WithSkip: Requests are passed to next without being limited.
+//   - WithPolicy: Provides the resource and action of requests, rather than
+//     using the Middleware's PolicyFunc.
+//   - WithCost: Provides the number of requests that each request costs.
+//
+// WithRefundOnServerError is ignored if the Middleware's Limiter does not
+// implement Refunder, and WithResponseSizeCharge is ignored if it does not
+// implement Charger.
+func (m *Middleware) Route(next http.Handler, opt ...Option) http.Handler {
+	opts := m.opts
+	for _, o := range opt {
+		o(&opts)
+	}
+	opts.withUsageHeaderSetter = m.limiter
+
+	if opts.withSkip {
+		return next
+	}
+
+	policyFn := m.policyFn
+	if opts.withPolicy != nil {
+		resource, action := opts.withPolicy.resource, opts.withPolicy.action
+		policyFn = func(*http.Request) (string, string) { return resource, action }
+	}
+	refund := opts.withRefundServerError && m.refunder != nil
+	charge := len(opts.withResponseSizeTiers) > 0 && m.charger != nil
+	var dedup *deduplicator
+	if opts.withDedupWindow > 0 && opts.withDedupMaxKeys > 0 && opts.withDedupMaxReplays > 0 {
+		dedup = newDeduplicator(opts.withDedupWindow, opts.withDedupMaxKeys, opts.withDedupMaxReplays, m.clock)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rate.IsBypassed(r.Context()) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		resource, action := policyFn(r)
+		ip, authToken := opts.withIPAddress(r), opts.withAuthToken(r)
+
+		var dk dedupKey
+		var quota *rate.Quota
+		// replay is true if the request is a retry of a request that was
+		// allowed, in which case it is not charged by the limiter.
+		var replay bool
+		if dedup != nil {
+			if key := opts.withIdempotencyKey(r); key != "" {
+				dk = dedupKey{resource: resource, action: action, ip: ip, authToken: authToken, key: key}
+				quota, replay = dedup.lookup(dk)
+			}
+		}
+
+		allowed := replay
+		var err error
+		if !replay {
+			allowed, quota, err = m.limiter.AllowNContext(r.Context(), resource, action, ip, authToken, opts.withCost)
+		}
+
+		if allowed {
+			_ = m.limiter.SetHeaders(resource, action, quota, w.Header())
+			if dk.key != "" && !replay {
+				dedup.add(dk, quota)
+			}
+			// A retry is not refunded, since it was not charged.
+			refund := refund && !replay
+			if !refund && !charge {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			rec := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			switch {
+			case refund && rec.Status() >= http.StatusInternalServerError:
+				// Requests that are refunded are not charged for their
+				// response.
+				_ = m.refunder.Refund(resource, action, ip, authToken, opts.withCost)
+				if dk.key != "" {
+					dedup.remove(dk)
+				}
+			case charge:
+				if n := responseSizeCost(opts.withResponseSizeTiers, rec.written); n > 0 {
+					_ = m.charger.Charge(resource, action, ip, authToken, n)
+				}
+			}
+			return
+		}
+
+		// Only requests that were denied by a quota, or because the limiter
+		// is full, are written a 429 Too Many Requests response.
+		var full *rate.ErrLimiterFull
+		switch {
+		case err == nil, errors.Is(err, rate.ErrDenied), errors.As(err, &full):
+		case errors.Is(err, rate.ErrInvalidIPAddress), errors.Is(err, rate.ErrEmptyIdentity):
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		default:
+			opts.withErrorHandler(w, r, next, err)
+			return
+		}
+
+		_ = m.limiter.SetPolicyHeader(resource, action, w.Header())
+
+		resp := Response{
+			Status:   http.StatusTooManyRequests,
+			Resource: resource,
+			Action:   action,
+		}
+		if full != nil {
+			resp.Status = opts.withLimiterFullStatus
+			resp.RetryAfter = retryAfter(full.RetryIn)
+			if resp.RetryAfter <= 0 {
+				// Space is expected to be available imminently, but
+				// clients should still wait before retrying.
+				resp.RetryAfter = 1
+			}
+			resp.LimiterFull = true
+		}
+		_ = writeDenied(w, resp, quota, opts)
+	})
+}
+
+// Handle registers handler for pattern with mux, using Route to limit
+// requests with the provided options.
+func (m *Middleware) Handle(mux *http.ServeMux, pattern string, handler http.Handler, opt ...Option) {
+	mux.Handle(pattern, m.Route(handler, opt...))
+}
+
+// HandleFunc registers handler for pattern with mux, using Route to limit
+// requests with the provided options.
+func (m *Middleware) HandleFunc(mux *http.ServeMux, pattern string, handler func(http.ResponseWriter, *http.Request), opt ...Option) {
+	mux.Handle(pattern, m.Route(http.HandlerFunc(handler), opt...))
+}
+
+// DefaultErrorHandler is the ErrorHandler used by a Middleware by default. It
+// writes a 500 Internal Server Error response, so that requests are not
+// allowed without being limited.
+func DefaultErrorHandler(w http.ResponseWriter, _ *http.Request, _ http.Handler, _ error) {
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// RemoteIPAddress returns the IP address from the request's RemoteAddr.
+func RemoteIPAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// AuthorizationHeader returns the value of the request's Authorization
+// header.
+func AuthorizationHeader(r *http.Request) string {
+	return r.Header.Get("Authorization")
+}
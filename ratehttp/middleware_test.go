@@ -0,0 +1,744 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-rate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testLimiter(t *testing.T, maxRequests uint64, o ...rate.Option) *rate.Limiter {
+	t.Helper()
+	l, err := rate.NewLimiter(
+		[]rate.Limit{
+			&rate.Limited{
+				Resource:    "resource",
+				Action:      "GET",
+				Per:         rate.LimitPerTotal,
+				MaxRequests: 100,
+				Period:      time.Minute,
+			},
+			&rate.Limited{
+				Resource:    "resource",
+				Action:      "GET",
+				Per:         rate.LimitPerIPAddress,
+				MaxRequests: maxRequests,
+				Period:      time.Minute,
+			},
+			&rate.Unlimited{
+				Resource: "resource",
+				Action:   "GET",
+				Per:      rate.LimitPerAuthToken,
+			},
+		},
+		10,
+		o...,
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Shutdown() })
+	return l
+}
+
+func testPolicyFn(r *http.Request) (string, string) {
+	return r.URL.Path[1:], r.Method
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func TestNewMiddleware(t *testing.T) {
+	l := testLimiter(t, 1)
+
+	_, err := NewMiddleware(nil, testPolicyFn)
+	assert.ErrorIs(t, err, rate.ErrInvalidParameter)
+
+	_, err = NewMiddleware(l, nil)
+	assert.ErrorIs(t, err, rate.ErrInvalidParameter)
+
+	m, err := NewMiddleware(l, testPolicyFn)
+	require.NoError(t, err)
+	assert.NotNil(t, m)
+}
+
+func TestMiddleware(t *testing.T) {
+	l := testLimiter(t, 1)
+	m, err := NewMiddleware(l, testPolicyFn)
+	require.NoError(t, err)
+	h := m.Handler(okHandler)
+
+	// Allowed.
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/resource", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Header().Get(rate.DefaultPolicyHeader))
+	assert.Equal(t, "limit=1, remaining=0, reset=60", w.Header().Get(rate.DefaultUsageHeader))
+
+	// Denied.
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/resource", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get(rate.DefaultPolicyHeader))
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	assert.Equal(t, "limit=1, remaining=0, reset=60", w.Header().Get(rate.DefaultUsageHeader))
+
+	// Other clients are not denied.
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/resource", nil)
+	r.RemoteAddr = "192.0.2.2:1234"
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// No policy, which the Limiter denies by default, so the request is
+	// handled by the ErrorHandler rather than being allowed.
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/missing", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Empty(t, w.Header().Get(rate.DefaultPolicyHeader))
+	assert.Empty(t, w.Header().Get(rate.DefaultUsageHeader))
+
+	// No policy, which the Limiter allows.
+	l = testLimiter(t, 1, rate.WithUnknownPolicyBehavior(rate.UnknownPolicyAllow))
+	m, err = NewMiddleware(l, testPolicyFn)
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	m.Handler(okHandler).ServeHTTP(w, httptest.NewRequest("GET", "/missing", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestMiddlewareProblemDetails(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		l := testLimiter(t, 1)
+		m, err := NewMiddleware(l, testPolicyFn, WithProblemDetails(nil))
+		require.NoError(t, err)
+		h := m.Handler(okHandler)
+
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/resource", nil))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/resource", nil))
+		require.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+
+		var got ProblemDetails
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+		assert.Equal(t, "Too Many Requests", got.Title)
+		assert.Equal(t, http.StatusTooManyRequests, got.Status)
+		assert.Equal(t, "resource", got.Resource)
+		assert.Equal(t, "GET", got.Action)
+		require.NotNil(t, got.Reset)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), *got.Reset, 5*time.Second)
+		assert.Contains(t, got.Detail, "GET resource")
+	})
+	t.Run("func", func(t *testing.T) {
+		l := testLimiter(t, 1)
+		m, err := NewMiddleware(l, testPolicyFn, WithProblemDetails(func(resp Response) ProblemDetails {
+			return ProblemDetails{
+				Type:   "https://example.com/problems/rate-limited",
+				Title:  "Slow down",
+				Status: resp.Status,
+				Detail: resp.Resource,
+			}
+		}))
+		require.NoError(t, err)
+		h := m.Handler(okHandler)
+
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/resource", nil))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/resource", nil))
+		require.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.JSONEq(t,
+			`{"type":"https://example.com/problems/rate-limited","title":"Slow down","status":429,"detail":"resource"}`,
+			w.Body.String(),
+		)
+	})
+}
+
+func TestRemoteIPAddress(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "[::1]:1234"
+	assert.Equal(t, "::1", RemoteIPAddress(r))
+
+	r.RemoteAddr = "127.0.0.1"
+	assert.Equal(t, "127.0.0.1", RemoteIPAddress(r))
+}
+
+func TestMiddlewareLimiterFull(t *testing.T) {
+	cases := []struct {
+		name   string
+		opts   []Option
+		status int
+	}{
+		{"default", nil, http.StatusTooManyRequests},
+		{"service-unavailable", []Option{WithLimiterFullStatus(http.StatusServiceUnavailable)}, http.StatusServiceUnavailable},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			l, err := rate.NewLimiter(
+				[]rate.Limit{
+					&rate.Limited{
+						Resource:    "resource",
+						Action:      "GET",
+						Per:         rate.LimitPerTotal,
+						MaxRequests: 100,
+						Period:      time.Minute,
+					},
+					&rate.Limited{
+						Resource:    "resource",
+						Action:      "GET",
+						Per:         rate.LimitPerIPAddress,
+						MaxRequests: 100,
+						Period:      time.Minute,
+					},
+					&rate.Unlimited{
+						Resource: "resource",
+						Action:   "GET",
+						Per:      rate.LimitPerAuthToken,
+					},
+				},
+				1,
+			)
+			require.NoError(t, err)
+			defer l.Shutdown()
+
+			m, err := NewMiddleware(l, testPolicyFn, append(tc.opts, WithProblemDetails(nil))...)
+			require.NoError(t, err)
+			h := m.Handler(okHandler)
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/resource", nil))
+			require.Equal(t, http.StatusOK, w.Code)
+
+			// The limiter is full, so a new client is denied.
+			w = httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/resource", nil)
+			r.RemoteAddr = "192.0.2.2:1234"
+			h.ServeHTTP(w, r)
+			assert.Equal(t, tc.status, w.Code)
+			assert.NotEmpty(t, w.Header().Get("Retry-After"))
+			assert.Empty(t, w.Header().Get(rate.DefaultUsageHeader))
+
+			var got ProblemDetails
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+			assert.Equal(t, tc.status, got.Status)
+			assert.Equal(t, http.StatusText(tc.status), got.Title)
+			assert.Contains(t, got.Detail, "capacity")
+		})
+	}
+}
+
+// fullLimiter is a Limiter that denies each request with an ErrLimiterFull.
+type fullLimiter struct {
+	Limiter
+	full *rate.ErrLimiterFull
+}
+
+func (l fullLimiter) AllowNContext(_ context.Context, _, _, _, _ string, _ uint64) (bool, *rate.Quota, error) {
+	return false, nil, l.full
+}
+
+// ctxLimiter is a Limiter that records the context of each request.
+type ctxLimiter struct {
+	Limiter
+	ctx context.Context
+}
+
+func (l *ctxLimiter) AllowNContext(ctx context.Context, resource, action, ip, authToken string, n uint64) (bool, *rate.Quota, error) {
+	l.ctx = ctx
+	return l.Limiter.AllowNContext(ctx, resource, action, ip, authToken, n)
+}
+
+func TestMiddlewareContext(t *testing.T) {
+	l := &ctxLimiter{Limiter: rate.NopLimiter}
+	m, err := NewMiddleware(l, testPolicyFn)
+	require.NoError(t, err)
+
+	// The request's context is passed to the Limiter, so that a Store can
+	// stop waiting once the request is canceled.
+	type ctxKey struct{}
+	r := httptest.NewRequest("GET", "/resource", nil)
+	r = r.WithContext(context.WithValue(r.Context(), ctxKey{}, "value"))
+	w := httptest.NewRecorder()
+	m.Handler(okHandler).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	require.NotNil(t, l.ctx)
+	assert.Equal(t, "value", l.ctx.Value(ctxKey{}))
+}
+
+type errLimiter struct {
+	Limiter
+	err error
+}
+
+func (l *errLimiter) AllowNContext(context.Context, string, string, string, string, uint64) (bool, *rate.Quota, error) {
+	return false, nil, l.err
+}
+
+func TestMiddlewareErrors(t *testing.T) {
+	passThrough := func(w http.ResponseWriter, r *http.Request, next http.Handler, _ error) {
+		next.ServeHTTP(w, r)
+	}
+	cases := []struct {
+		name         string
+		err          error
+		opts         []Option
+		expectStatus int
+	}{
+		{"denied", &rate.ErrRateLimited{}, nil, http.StatusTooManyRequests},
+		{"limiter-full", &rate.ErrLimiterFull{}, nil, http.StatusTooManyRequests},
+		{"invalid-ip-address", rate.ErrInvalidIPAddress, nil, http.StatusBadRequest},
+		{"empty-identity", rate.ErrEmptyIdentity, nil, http.StatusBadRequest},
+		{"stopped", rate.ErrStopped, nil, http.StatusInternalServerError},
+		{"store", errors.New("store error"), nil, http.StatusInternalServerError},
+		{"canceled", context.Canceled, nil, http.StatusInternalServerError},
+		{"policy-not-found", rate.ErrLimitPolicyNotFound, nil, http.StatusInternalServerError},
+		{"pass-through", rate.ErrStopped, []Option{WithErrorHandler(passThrough)}, http.StatusOK},
+		{"pass-through-invalid-ip-address", rate.ErrInvalidIPAddress, []Option{WithErrorHandler(passThrough)}, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := NewMiddleware(&errLimiter{Limiter: rate.NopLimiter, err: tc.err}, testPolicyFn, tc.opts...)
+			require.NoError(t, err)
+
+			w := httptest.NewRecorder()
+			m.Handler(okHandler).ServeHTTP(w, httptest.NewRequest("GET", "/resource", nil))
+			assert.Equal(t, tc.expectStatus, w.Code)
+		})
+	}
+
+	t.Run("strict-ip-address", func(t *testing.T) {
+		l := testLimiter(t, 1, rate.WithStrictIPAddress(true))
+		m, err := NewMiddleware(l, testPolicyFn)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/resource", nil)
+		r.RemoteAddr = "invalid"
+		m.Handler(okHandler).ServeHTTP(w, r)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Empty(t, w.Header().Get("Retry-After"))
+	})
+}
+
+func TestMiddlewareLimiterFullRetryAfter(t *testing.T) {
+	cases := []struct {
+		name    string
+		retryIn time.Duration
+		want    string
+	}{
+		{"rounded-up", 1500 * time.Millisecond, "2"},
+		{"imminent", 0, "1"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := fullLimiter{Limiter: rate.NopLimiter, full: &rate.ErrLimiterFull{RetryIn: tc.retryIn}}
+			m, err := NewMiddleware(l, testPolicyFn)
+			require.NoError(t, err)
+
+			w := httptest.NewRecorder()
+			m.Handler(okHandler).ServeHTTP(w, httptest.NewRequest("GET", "/resource", nil))
+			assert.Equal(t, http.StatusTooManyRequests, w.Code)
+			assert.Equal(t, tc.want, w.Header().Get("Retry-After"))
+		})
+	}
+}
+
+func TestMiddlewareLimiterFullHeader(t *testing.T) {
+	l := fullLimiter{Limiter: rate.NopLimiter, full: &rate.ErrLimiterFull{RetryIn: 5 * time.Second}}
+	m, err := NewMiddleware(l, testPolicyFn, WithLimiterFullHeader(DefaultLimiterFullHeader))
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	m.Handler(okHandler).ServeHTTP(w, httptest.NewRequest("GET", "/resource", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "5", w.Header().Get("Retry-After"))
+	assert.Equal(t, `retry=5, comment="capacity"`, w.Header().Get(DefaultLimiterFullHeader))
+
+	// The header is not set for requests that exceed a quota.
+	m, err = NewMiddleware(testLimiter(t, 1), testPolicyFn, WithLimiterFullHeader(DefaultLimiterFullHeader))
+	require.NoError(t, err)
+	h := m.Handler(okHandler)
+	for i := 0; i < 2; i++ {
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/resource", nil))
+	}
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Empty(t, w.Header().Get(DefaultLimiterFullHeader))
+}
+
+func TestMiddlewareBypass(t *testing.T) {
+	l := testLimiter(t, 1)
+	m, err := NewMiddleware(l, testPolicyFn)
+	require.NoError(t, err)
+
+	// An outer handler marks trusted requests as bypassed.
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Internal") != "" {
+			r = r.WithContext(rate.WithBypass(r.Context()))
+		}
+		m.Handler(okHandler).ServeHTTP(w, r)
+	})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/resource", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/resource", nil))
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	for i := 0; i < 3; i++ {
+		w = httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/resource", nil)
+		r.Header.Set("X-Internal", "true")
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get(rate.DefaultUsageHeader))
+	}
+}
+
+func TestMiddlewareRoute(t *testing.T) {
+	l := testLimiter(t, 3)
+	m, err := NewMiddleware(l, testPolicyFn)
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	m.Handle(mux, "/resource", okHandler)
+	m.Handle(mux, "/health", okHandler, WithSkip())
+	m.HandleFunc(mux, "/alias", okHandler, WithPolicy("resource", "GET"))
+	m.Handle(mux, "/expensive", okHandler, WithPolicy("resource", "GET"), WithCost(2))
+
+	do := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	// Skipped routes are never limited and have no headers.
+	for i := 0; i < 5; i++ {
+		w := do("/health")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get(rate.DefaultUsageHeader))
+	}
+
+	w := do("/alias")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "limit=3, remaining=2, reset=60", w.Header().Get(rate.DefaultUsageHeader))
+
+	w = do("/expensive")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "limit=3, remaining=0, reset=60", w.Header().Get(rate.DefaultUsageHeader))
+
+	w = do("/resource")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+}
+
+func TestMiddlewareRouteCost(t *testing.T) {
+	l := testLimiter(t, 3)
+	m, err := NewMiddleware(l, testPolicyFn)
+	require.NoError(t, err)
+	h := m.Route(okHandler, WithCost(2))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/resource", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Only one request remains, which is not enough.
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/resource", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "limit=3, remaining=1, reset=60", w.Header().Get(rate.DefaultUsageHeader))
+
+	// A cheaper route can still use it.
+	w = httptest.NewRecorder()
+	m.Handler(okHandler).ServeHTTP(w, httptest.NewRequest("GET", "/resource", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// allowOnlyLimiter is a Limiter that does not implement Refunder or Charger.
+type allowOnlyLimiter struct {
+	Limiter
+}
+
+func TestMiddlewareRefundOnServerError(t *testing.T) {
+	statusHandler := func(status int) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(status)
+		})
+	}
+
+	t.Run("refunded", func(t *testing.T) {
+		l := testLimiter(t, 1)
+		m, err := NewMiddleware(l, testPolicyFn, WithRefundOnServerError(true))
+		require.NoError(t, err)
+
+		// Requests that fail with a server error are refunded, so the single
+		// request can be used again.
+		for i := 0; i < 3; i++ {
+			w := httptest.NewRecorder()
+			m.Handler(statusHandler(http.StatusServiceUnavailable)).ServeHTTP(w, httptest.NewRequest("GET", "/resource", nil))
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		}
+
+		// Other responses are not refunded.
+		w := httptest.NewRecorder()
+		m.Handler(statusHandler(http.StatusNotFound)).ServeHTTP(w, httptest.NewRequest("GET", "/resource", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		w = httptest.NewRecorder()
+		m.Handler(okHandler).ServeHTTP(w, httptest.NewRequest("GET", "/resource", nil))
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	})
+
+	t.Run("route", func(t *testing.T) {
+		l := testLimiter(t, 1)
+		m, err := NewMiddleware(l, testPolicyFn)
+		require.NoError(t, err)
+		h := m.Route(statusHandler(http.StatusInternalServerError), WithRefundOnServerError(true))
+
+		for i := 0; i < 2; i++ {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/resource", nil))
+			assert.Equal(t, http.StatusInternalServerError, w.Code)
+		}
+
+		// Without refunds, the second request is denied.
+		h = m.Handler(statusHandler(http.StatusInternalServerError))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/resource", nil))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/resource", nil))
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	})
+
+	t.Run("not-refunder", func(t *testing.T) {
+		l := allowOnlyLimiter{testLimiter(t, 1)}
+		_, err := NewMiddleware(l, testPolicyFn, WithRefundOnServerError(true))
+		assert.ErrorIs(t, err, rate.ErrInvalidParameter)
+	})
+}
+
+func TestMiddlewareResponseSizeCharge(t *testing.T) {
+	bodyHandler := func(status, size int) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(status)
+			w.Write(make([]byte, size))
+		})
+	}
+	tiers := []ResponseSizeTier{{MinBytes: 10, Cost: 1}, {MinBytes: 100, Cost: 3}}
+
+	cases := []struct {
+		name          string
+		handler       http.Handler
+		opts          []Option
+		wantRemaining string
+	}{
+		{"small", bodyHandler(http.StatusOK, 9), nil, "remaining=8"},
+		{"tier-1", bodyHandler(http.StatusOK, 10), nil, "remaining=7"},
+		{"tier-2", bodyHandler(http.StatusOK, 500), nil, "remaining=5"},
+		{"server-error", bodyHandler(http.StatusInternalServerError, 500), nil, "remaining=5"},
+		{"server-error-refund", bodyHandler(http.StatusInternalServerError, 500), []Option{WithRefundOnServerError(true)}, "remaining=9"},
+		{"cost", bodyHandler(http.StatusOK, 500), []Option{WithCost(2)}, "remaining=4"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := testLimiter(t, 10)
+			m, err := NewMiddleware(l, testPolicyFn, WithResponseSizeCharge(tiers...))
+			require.NoError(t, err)
+
+			w := httptest.NewRecorder()
+			m.Route(tc.handler, tc.opts...).ServeHTTP(w, httptest.NewRequest("GET", "/resource", nil))
+
+			// The charge is reflected by the next request, which costs 1.
+			w = httptest.NewRecorder()
+			m.Handler(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(w, httptest.NewRequest("GET", "/resource", nil))
+			assert.Contains(t, w.Header().Get(rate.DefaultUsageHeader), tc.wantRemaining)
+		})
+	}
+
+	t.Run("exhausted", func(t *testing.T) {
+		l := testLimiter(t, 2)
+		m, err := NewMiddleware(l, testPolicyFn, WithResponseSizeCharge(tiers...))
+		require.NoError(t, err)
+		h := m.Handler(bodyHandler(http.StatusOK, 100))
+
+		// The request is allowed, since the charge is only known after the
+		// response, but subsequent requests are denied.
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/resource", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/resource", nil))
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	})
+
+	t.Run("not-charger", func(t *testing.T) {
+		l := allowOnlyLimiter{testLimiter(t, 1)}
+		_, err := NewMiddleware(l, testPolicyFn, WithResponseSizeCharge(tiers...))
+		assert.ErrorIs(t, err, rate.ErrInvalidParameter)
+	})
+}
+
+func TestMiddlewareDeduplication(t *testing.T) {
+	request := func(key string) *http.Request {
+		r := httptest.NewRequest("GET", "/resource", nil)
+		if key != "" {
+			r.Header.Set("Idempotency-Key", key)
+		}
+		return r
+	}
+
+	t.Run("retries", func(t *testing.T) {
+		l := testLimiter(t, 2)
+		m, err := NewMiddleware(l, testPolicyFn, WithDeduplication(time.Minute, 10))
+		require.NoError(t, err)
+		h := m.Handler(okHandler)
+
+		// Retries of the first request are only charged once.
+		for i := 0; i < 3; i++ {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, request("1"))
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "limit=2, remaining=1, reset=60", w.Header().Get(rate.DefaultUsageHeader))
+		}
+
+		// Requests without a key are always charged.
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request(""))
+		assert.Equal(t, http.StatusOK, w.Code)
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, request("2"))
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+		// Retries are allowed, even though the quota is exhausted.
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, request("1"))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "limit=2, remaining=0, reset=60", w.Header().Get(rate.DefaultUsageHeader))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		l := testLimiter(t, 2)
+		m, err := NewMiddleware(l, testPolicyFn)
+		require.NoError(t, err)
+		h := m.Handler(okHandler)
+
+		for _, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, request("1"))
+			assert.Equal(t, want, w.Code)
+		}
+	})
+
+	t.Run("refunded", func(t *testing.T) {
+		l := testLimiter(t, 1)
+		m, err := NewMiddleware(l, testPolicyFn, WithDeduplication(time.Minute, 10), WithRefundOnServerError(true))
+		require.NoError(t, err)
+
+		status := http.StatusInternalServerError
+		h := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(status)
+		}))
+
+		// The retry of a refunded request is charged, so the request after
+		// it is denied.
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request("1"))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		status = http.StatusOK
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, request("1"))
+		assert.Equal(t, http.StatusOK, w.Code)
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, request("2"))
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	})
+
+	t.Run("max-replays", func(t *testing.T) {
+		l := testLimiter(t, 2)
+		m, err := NewMiddleware(l, testPolicyFn, WithDeduplication(time.Minute, 10), WithDedupMaxReplays(1))
+		require.NoError(t, err)
+		h := m.Handler(okHandler)
+
+		// Once a request has been retried WithDedupMaxReplays times, its
+		// next retry is charged, so retries cannot be used to make
+		// unlimited requests.
+		for _, want := range []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, request("1"))
+			assert.Equal(t, want, w.Code)
+		}
+	})
+
+	t.Run("clock", func(t *testing.T) {
+		c := &testClock{now: time.Now()}
+		l := testLimiter(t, 3, rate.WithClock(c))
+		m, err := NewMiddleware(l, testPolicyFn, WithDeduplication(30*time.Second, 10))
+		require.NoError(t, err)
+		h := m.Handler(okHandler)
+
+		// The window is measured using the Limiter's Clock.
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request("1"))
+		assert.Equal(t, "limit=3, remaining=2, reset=60", w.Header().Get(rate.DefaultUsageHeader))
+		c.Advance(31 * time.Second)
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, request("1"))
+		assert.Equal(t, "limit=3, remaining=1, reset=29", w.Header().Get(rate.DefaultUsageHeader))
+	})
+
+	t.Run("replay-charges", func(t *testing.T) {
+		l := testLimiter(t, 3)
+		m, err := NewMiddleware(l, testPolicyFn,
+			WithDeduplication(time.Minute, 10),
+			WithRefundOnServerError(true),
+			WithResponseSizeCharge(ResponseSizeTier{MinBytes: 1, Cost: 1}),
+		)
+		require.NoError(t, err)
+
+		status := http.StatusOK
+		h := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte("body"))
+		}))
+
+		// Retries are still charged for their response, but are not
+		// refunded, since they were not charged by the Limiter.
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request("1"))
+		assert.Equal(t, http.StatusOK, w.Code)
+		status = http.StatusInternalServerError
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, request("1"))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+		// The request was charged 1, plus 1 for each response.
+		q, ok := l.QuotaFor("resource", "GET", rate.LimitPerIPAddress, "192.0.2.1")
+		require.True(t, ok)
+		assert.Equal(t, uint64(0), q.Remaining)
+	})
+
+	t.Run("idempotency-key-func", func(t *testing.T) {
+		l := testLimiter(t, 1)
+		m, err := NewMiddleware(l, testPolicyFn,
+			WithDeduplication(time.Minute, 10),
+			WithIdempotencyKeyFunc(func(r *http.Request) string { return r.URL.Query().Get("key") }),
+		)
+		require.NoError(t, err)
+		h := m.Handler(okHandler)
+
+		for i := 0; i < 2; i++ {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/resource?key=1", nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+		}
+	})
+}
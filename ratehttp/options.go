@@ -0,0 +1,302 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratehttp
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/go-rate"
+)
+
+// UsageHeaderSetter sets the rate limit usage HTTP header for a Quota. It is
+// implemented by rate.Limiter and rate.NopLimiter.
+type UsageHeaderSetter interface {
+	SetUsageHeader(*rate.Quota, http.Header)
+}
+
+// RequestValueFunc returns a value from a request, such as the IP address or
+// auth token used to identify the client.
+type RequestValueFunc func(r *http.Request) string
+
+// ProblemDetailsFunc returns the problem details for a denied request.
+type ProblemDetailsFunc func(Response) ProblemDetails
+
+// ErrorHandler handles a request for which a Middleware's Limiter returned an
+// error that is neither a denial nor caused by the client, such as a Store
+// error, rate.ErrStopped, or the request's context being canceled. It may
+// write a response, or pass the request to next.
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, next http.Handler, err error)
+
+// bodyFunc writes the body of a response.
+type bodyFunc func(io.Writer, Response) error
+
+// Option provides a way to pass optional arguments.
+type Option func(*options)
+
+func getOpts(opt ...Option) options {
+	opts := getDefaultOptions()
+	for _, o := range opt {
+		o(&opts)
+	}
+	return opts
+}
+
+type options struct {
+	withUsageHeaderSetter UsageHeaderSetter
+	withBodyContentType   string
+	withBody              bodyFunc
+	withIPAddress         RequestValueFunc
+	withAuthToken         RequestValueFunc
+	withLimiterFullStatus int
+	withLimiterFullHeader string
+	withSkip              bool
+	withPolicy            *policy
+	withCost              uint64
+	withRefundServerError bool
+	withResponseSizeTiers []ResponseSizeTier
+	withIdempotencyKey    RequestValueFunc
+	withDedupWindow       time.Duration
+	withDedupMaxKeys      int
+	withDedupMaxReplays   int
+	withErrorHandler      ErrorHandler
+}
+
+// ResponseSizeTier is the additional cost of a request whose response body
+// is at least MinBytes long.
+type ResponseSizeTier struct {
+	MinBytes int64
+	Cost     uint64
+}
+
+// responseSizeCost returns the Cost of the tier with the largest MinBytes
+// that is less than or equal to written. The tiers must be sorted by MinBytes.
+// If there is no such tier, zero is returned.
+func responseSizeCost(tiers []ResponseSizeTier, written int64) uint64 {
+	var cost uint64
+	for _, t := range tiers {
+		if t.MinBytes > written {
+			break
+		}
+		cost = t.Cost
+	}
+	return cost
+}
+
+// policy is the resource and action provided via WithPolicy.
+type policy struct {
+	resource string
+	action   string
+}
+
+func getDefaultOptions() options {
+	return options{
+		withIPAddress:         RemoteIPAddress,
+		withAuthToken:         AuthorizationHeader,
+		withLimiterFullStatus: http.StatusTooManyRequests,
+		withCost:              1,
+		withIdempotencyKey:    IdempotencyKeyHeader,
+		withDedupMaxReplays:   DefaultDedupMaxReplays,
+		withErrorHandler:      DefaultErrorHandler,
+	}
+}
+
+// WithUsageHeaderSetter is used to provide the UsageHeaderSetter, typically
+// the rate.Limiter that returned the Quota, that sets the rate limit usage
+// header. This ensures that the header name and value match those set by the
+// Limiter for allowed requests. By default, the header is set using
+// rate.DefaultUsageHeader.
+func WithUsageHeaderSetter(s UsageHeaderSetter) Option {
+	return func(o *options) {
+		o.withUsageHeaderSetter = s
+	}
+}
+
+// WithBodyTemplate is used to provide a template that is executed with a
+// Response to write the body of the response, along with the content type of
+// the body. By default, no body is written.
+func WithBodyTemplate(contentType string, t *template.Template) Option {
+	return func(o *options) {
+		o.withBodyContentType = contentType
+		o.withBody = func(w io.Writer, resp Response) error {
+			return t.Execute(w, resp)
+		}
+	}
+}
+
+// WithJSONBody is used to write a JSON body, using DefaultJSONBodyTemplate.
+func WithJSONBody() Option {
+	return WithBodyTemplate(jsonContentType, DefaultJSONBodyTemplate)
+}
+
+// WithProblemDetails is used to write an RFC 9457 application/problem+json
+// body, using the ProblemDetails returned by the provided function. If fn is
+// nil, DefaultProblemDetails is used. To use a template instead, use
+// WithBodyTemplate with a content type of "application/problem+json".
+func WithProblemDetails(fn ProblemDetailsFunc) Option {
+	if fn == nil {
+		fn = DefaultProblemDetails
+	}
+	return func(o *options) {
+		o.withBodyContentType = problemJSONContentType
+		o.withBody = func(w io.Writer, resp Response) error {
+			return json.NewEncoder(w).Encode(fn(resp))
+		}
+	}
+}
+
+// WithIPAddressFunc is used to provide the function that returns the IP
+// address of the client making a request. By default, RemoteIPAddress is
+// used.
+func WithIPAddressFunc(fn RequestValueFunc) Option {
+	return func(o *options) {
+		if fn != nil {
+			o.withIPAddress = fn
+		}
+	}
+}
+
+// WithAuthTokenFunc is used to provide the function that returns the auth
+// token of the client making a request. By default, AuthorizationHeader is
+// used.
+func WithAuthTokenFunc(fn RequestValueFunc) Option {
+	return func(o *options) {
+		if fn != nil {
+			o.withAuthToken = fn
+		}
+	}
+}
+
+// WithLimiterFullStatus is used to provide the HTTP status code of the
+// response written by a Middleware when a request is denied because the
+// Limiter is full, such as http.StatusServiceUnavailable, since the client
+// did not exceed a quota. By default, http.StatusTooManyRequests is used.
+func WithLimiterFullStatus(status int) Option {
+	return func(o *options) {
+		o.withLimiterFullStatus = status
+	}
+}
+
+// WithLimiterFullHeader is used to provide the name of a header, such as
+// DefaultLimiterFullHeader, that a Middleware sets when a request is denied
+// because the Limiter is full. Its value is the Retry-After delay with a
+// "capacity" comment, such as `retry=5, comment="capacity"`, so that clients
+// can distinguish the server's limiter being saturated from exceeding their
+// own quota. By default, the header is not set.
+func WithLimiterFullHeader(name string) Option {
+	return func(o *options) {
+		o.withLimiterFullHeader = name
+	}
+}
+
+// WithSkip is used with Middleware.Route to pass requests for the route to
+// the next handler without limiting them.
+func WithSkip() Option {
+	return func(o *options) {
+		o.withSkip = true
+	}
+}
+
+// WithPolicy is used with Middleware.Route to provide the resource and action
+// of requests for the route, rather than using the Middleware's PolicyFunc.
+func WithPolicy(resource, action string) Option {
+	return func(o *options) {
+		o.withPolicy = &policy{resource: resource, action: action}
+	}
+}
+
+// WithCost is used to provide the number of requests that each request costs.
+// By default, each request costs 1.
+func WithCost(n uint64) Option {
+	return func(o *options) {
+		o.withCost = n
+	}
+}
+
+// WithRefundOnServerError is used to refund the cost of a request that was
+// allowed if the next handler responds with a 5xx status code, so that
+// clients are not charged for requests that failed due to a server error. The
+// Middleware's Limiter must implement Refunder.
+func WithRefundOnServerError(b bool) Option {
+	return func(o *options) {
+		o.withRefundServerError = b
+	}
+}
+
+// WithResponseSizeCharge is used to charge requests that were allowed for
+// additional requests based on the number of bytes written in the body of the
+// response, which is useful for limiting the bandwidth used by endpoints with
+// large responses, such as exports. After the next handler returns, the
+// request is charged the Cost of the tier with the largest MinBytes that does
+// not exceed the size of the body. The Middleware's Limiter must implement
+// Charger. Since the cost is only known after the response, a request is
+// never denied because of its charge, but subsequent requests may be. By
+// default, requests are not charged based on the size of their response.
+func WithResponseSizeCharge(tiers ...ResponseSizeTier) Option {
+	sorted := make([]ResponseSizeTier, len(tiers))
+	copy(sorted, tiers)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].MinBytes < sorted[j].MinBytes })
+	return func(o *options) {
+		o.withResponseSizeTiers = sorted
+	}
+}
+
+// WithDeduplication is used to charge requests that have the same idempotency
+// key only once within the window, so that clients that retry requests, such
+// as after a timeout, are not charged for each attempt. Retries of a request
+// that was allowed are not charged by the Limiter, but are otherwise handled
+// like the original request, such as being charged by WithResponseSizeCharge.
+// Up to WithDedupMaxReplays retries of each request are not charged; once a
+// request has been retried that many times, its next retry is charged as a new
+// request. Requests are only deduplicated if they are made by the same
+// client, as identified by its IP address and auth token, for the same
+// resource and action. Retries that are made while the original request is
+// still being handled may be charged. If the original request was refunded by
+// WithRefundOnServerError, its retries are charged. Up to maxKeys idempotency
+// keys are remembered for each route; once there are maxKeys keys within the
+// window, new requests are not deduplicated. By default, requests are not
+// deduplicated.
+func WithDeduplication(window time.Duration, maxKeys int) Option {
+	return func(o *options) {
+		o.withDedupWindow = window
+		o.withDedupMaxKeys = maxKeys
+	}
+}
+
+// WithDedupMaxReplays is used to provide the most retries of each request
+// that are not charged when using WithDeduplication. By default,
+// DefaultDedupMaxReplays is used.
+func WithDedupMaxReplays(n int) Option {
+	return func(o *options) {
+		o.withDedupMaxReplays = n
+	}
+}
+
+// WithIdempotencyKeyFunc is used to provide the function that returns the
+// idempotency key of a request for WithDeduplication. Requests without an
+// idempotency key are not deduplicated. By default, IdempotencyKeyHeader is
+// used.
+func WithIdempotencyKeyFunc(fn RequestValueFunc) Option {
+	return func(o *options) {
+		if fn != nil {
+			o.withIdempotencyKey = fn
+		}
+	}
+}
+
+// WithErrorHandler is used to provide the ErrorHandler for requests for which
+// the Limiter returned an error other than a denial, such as a Store error. To
+// allow such requests, provide an ErrorHandler that passes them to next. By
+// default, DefaultErrorHandler is used.
+func WithErrorHandler(fn ErrorHandler) Option {
+	return func(o *options) {
+		if fn != nil {
+			o.withErrorHandler = fn
+		}
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratehttp
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/go-rate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_getOpts(t *testing.T) {
+	t.Parallel()
+	t.Run("default", func(t *testing.T) {
+		opts := getOpts()
+		assert.Nil(t, opts.withUsageHeaderSetter)
+		assert.Empty(t, opts.withBodyContentType)
+		assert.Nil(t, opts.withBody)
+		assert.Equal(t, http.StatusTooManyRequests, opts.withLimiterFullStatus)
+		assert.Empty(t, opts.withLimiterFullHeader)
+		assert.False(t, opts.withSkip)
+		assert.Nil(t, opts.withPolicy)
+		assert.Equal(t, uint64(1), opts.withCost)
+		assert.Zero(t, opts.withDedupWindow)
+		assert.Equal(t, DefaultDedupMaxReplays, opts.withDedupMaxReplays)
+		assert.NotNil(t, opts.withErrorHandler)
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "127.0.0.1:1234"
+		r.Header.Set("Authorization", "Bearer token")
+		assert.Equal(t, "127.0.0.1", opts.withIPAddress(r))
+		assert.Equal(t, "Bearer token", opts.withAuthToken(r))
+		r.Header.Set("Idempotency-Key", "key")
+		assert.Equal(t, "key", opts.withIdempotencyKey(r))
+	})
+	t.Run("WithUsageHeaderSetter", func(t *testing.T) {
+		opts := getOpts(WithUsageHeaderSetter(rate.NopLimiter))
+		assert.Equal(t, rate.NopLimiter, opts.withUsageHeaderSetter)
+	})
+	t.Run("WithBodyTemplate", func(t *testing.T) {
+		tmpl := template.Must(template.New("").Parse("{{.Status}}"))
+		opts := getOpts(WithBodyTemplate("text/plain", tmpl))
+		assert.Equal(t, "text/plain", opts.withBodyContentType)
+
+		var buf bytes.Buffer
+		require.NoError(t, opts.withBody(&buf, Response{Status: 429}))
+		assert.Equal(t, "429", buf.String())
+	})
+	t.Run("WithJSONBody", func(t *testing.T) {
+		opts := getOpts(WithJSONBody())
+		assert.Equal(t, "application/json", opts.withBodyContentType)
+		assert.NotNil(t, opts.withBody)
+	})
+	t.Run("WithProblemDetails", func(t *testing.T) {
+		opts := getOpts(WithProblemDetails(func(resp Response) ProblemDetails {
+			return ProblemDetails{Title: "custom", Status: resp.Status}
+		}))
+		assert.Equal(t, "application/problem+json", opts.withBodyContentType)
+
+		var buf bytes.Buffer
+		require.NoError(t, opts.withBody(&buf, Response{Status: 429}))
+		assert.JSONEq(t, `{"title":"custom","status":429}`, buf.String())
+	})
+	t.Run("WithIPAddressFunc", func(t *testing.T) {
+		opts := getOpts(WithIPAddressFunc(func(*http.Request) string { return "ip" }))
+		assert.Equal(t, "ip", opts.withIPAddress(nil))
+
+		opts = getOpts(WithIPAddressFunc(nil))
+		assert.NotNil(t, opts.withIPAddress)
+	})
+	t.Run("WithAuthTokenFunc", func(t *testing.T) {
+		opts := getOpts(WithAuthTokenFunc(func(*http.Request) string { return "token" }))
+		assert.Equal(t, "token", opts.withAuthToken(nil))
+
+		opts = getOpts(WithAuthTokenFunc(nil))
+		assert.NotNil(t, opts.withAuthToken)
+	})
+	t.Run("WithLimiterFullStatus", func(t *testing.T) {
+		opts := getOpts(WithLimiterFullStatus(http.StatusServiceUnavailable))
+		assert.Equal(t, http.StatusServiceUnavailable, opts.withLimiterFullStatus)
+	})
+	t.Run("WithLimiterFullHeader", func(t *testing.T) {
+		opts := getOpts(WithLimiterFullHeader(DefaultLimiterFullHeader))
+		assert.Equal(t, DefaultLimiterFullHeader, opts.withLimiterFullHeader)
+	})
+	t.Run("WithSkip", func(t *testing.T) {
+		opts := getOpts(WithSkip())
+		assert.True(t, opts.withSkip)
+	})
+	t.Run("WithPolicy", func(t *testing.T) {
+		opts := getOpts(WithPolicy("resource", "action"))
+		assert.Equal(t, &policy{resource: "resource", action: "action"}, opts.withPolicy)
+	})
+	t.Run("WithCost", func(t *testing.T) {
+		opts := getOpts(WithCost(5))
+		assert.Equal(t, uint64(5), opts.withCost)
+	})
+	t.Run("WithRefundOnServerError", func(t *testing.T) {
+		opts := getOpts(WithRefundOnServerError(true))
+		assert.True(t, opts.withRefundServerError)
+	})
+	t.Run("WithDeduplication", func(t *testing.T) {
+		opts := getOpts(WithDeduplication(time.Minute, 100))
+		assert.Equal(t, time.Minute, opts.withDedupWindow)
+		assert.Equal(t, 100, opts.withDedupMaxKeys)
+	})
+	t.Run("WithDedupMaxReplays", func(t *testing.T) {
+		opts := getOpts(WithDedupMaxReplays(5))
+		assert.Equal(t, 5, opts.withDedupMaxReplays)
+	})
+	t.Run("WithIdempotencyKeyFunc", func(t *testing.T) {
+		opts := getOpts(WithIdempotencyKeyFunc(func(*http.Request) string { return "key" }))
+		assert.Equal(t, "key", opts.withIdempotencyKey(nil))
+
+		opts = getOpts(WithIdempotencyKeyFunc(nil))
+		assert.NotNil(t, opts.withIdempotencyKey)
+	})
+	t.Run("WithErrorHandler", func(t *testing.T) {
+		var called bool
+		opts := getOpts(WithErrorHandler(func(http.ResponseWriter, *http.Request, http.Handler, error) { called = true }))
+		opts.withErrorHandler(nil, nil, nil, nil)
+		assert.True(t, called)
+
+		opts = getOpts(WithErrorHandler(nil))
+		assert.NotNil(t, opts.withErrorHandler)
+	})
+	t.Run("WithResponseSizeCharge", func(t *testing.T) {
+		tiers := []ResponseSizeTier{{MinBytes: 1024, Cost: 2}, {MinBytes: 0, Cost: 0}, {MinBytes: 512, Cost: 1}}
+		opts := getOpts(WithResponseSizeCharge(tiers...))
+		assert.Equal(t, []ResponseSizeTier{{MinBytes: 0, Cost: 0}, {MinBytes: 512, Cost: 1}, {MinBytes: 1024, Cost: 2}}, opts.withResponseSizeTiers)
+		// The provided tiers are not modified.
+		assert.Equal(t, int64(1024), tiers[0].MinBytes)
+	})
+}
+
+func Test_responseSizeCost(t *testing.T) {
+	tiers := []ResponseSizeTier{{MinBytes: 512, Cost: 1}, {MinBytes: 1024, Cost: 5}}
+	cases := []struct {
+		written int64
+		want    uint64
+	}{
+		{0, 0},
+		{511, 0},
+		{512, 1},
+		{1023, 1},
+		{1024, 5},
+		{1 << 30, 5},
+	}
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%d", tc.written), func(t *testing.T) {
+			assert.Equal(t, tc.want, responseSizeCost(tiers, tc.written))
+		})
+	}
+	assert.Equal(t, uint64(0), responseSizeCost(nil, 1024))
+}
@@ -0,0 +1,107 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratehttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/hashicorp/go-rate"
+)
+
+// Snapshotter is used by PeerHandler to get the state of a limiter's quotas.
+// It is implemented by rate.Limiter and rate.ReadOnlyLimiter.
+type Snapshotter interface {
+	Snapshot(w io.Writer) error
+}
+
+// Restorer is used by ImportFromPeer to restore the state of a limiter's
+// quotas. It is implemented by rate.Limiter.
+type Restorer interface {
+	Restore(r io.Reader) error
+}
+
+// PeerHandler returns an http.Handler that responds to GET requests with a
+// snapshot of the limiter's quotas, in the format described by
+// rate.SnapshotVersion, so that a newly started instance can import them via
+// ImportFromPeer rather than starting with new quotas. Only the quotas that
+// have used at least minUsed requests are included, so that a peer can be
+// sent only the quotas of active IP addresses and auth tokens.
+//
+// Since the snapshot includes the IP addresses and auth tokens of the
+// quotas, the handler should only be reachable by peers, such as by serving
+// it on an internal listener.
+func PeerHandler(l Snapshotter, minUsed uint64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := l.Snapshot(&buf); err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if minUsed > 0 {
+			var snap rate.Snapshot
+			if err := json.Unmarshal(buf.Bytes(), &snap); err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			quotas := snap.Quotas[:0]
+			for _, q := range snap.Quotas {
+				if q.Used >= minUsed {
+					quotas = append(quotas, q)
+				}
+			}
+			snap.Quotas = quotas
+			buf.Reset()
+			if err := json.NewEncoder(&buf).Encode(snap); err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		_, _ = w.Write(buf.Bytes())
+	})
+}
+
+// ImportFromPeer gets a snapshot of the quotas of a peer from the url of its
+// PeerHandler, and restores them using l, so that a newly started instance
+// continues to limit the IP addresses and auth tokens that were limited by its
+// peers. Quotas that already exist in l are not modified. If client is nil,
+// http.DefaultClient is used.
+func ImportFromPeer(ctx context.Context, client *http.Client, url string, l Restorer) error {
+	const op = "ratehttp.ImportFromPeer"
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: unexpected status from peer: %s", op, resp.Status)
+	}
+	if err := l.Restore(resp.Body); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
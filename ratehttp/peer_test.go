@@ -0,0 +1,114 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/go-rate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerHandler(t *testing.T) {
+	l := testLimiter(t, 10)
+	for i := 0; i < 3; i++ {
+		_, _, err := l.Allow("resource", "GET", "127.0.0.1", "")
+		require.NoError(t, err)
+	}
+	_, _, err := l.Allow("resource", "GET", "10.0.0.1", "")
+	require.NoError(t, err)
+
+	cases := []struct {
+		name    string
+		minUsed uint64
+		wantIDs []string
+	}{
+		{"All", 0, []string{"10.0.0.1", "127.0.0.1", "total"}},
+		{"MinUsed", 2, []string{"127.0.0.1", "total"}},
+		{"None", 100, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			PeerHandler(l, tc.minUsed).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			require.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+			var snap rate.Snapshot
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &snap))
+			assert.Equal(t, rate.SnapshotVersion, snap.Version)
+			var ids []string
+			for _, q := range snap.Quotas {
+				ids = append(ids, q.ID)
+			}
+			assert.Equal(t, tc.wantIDs, ids)
+		})
+	}
+
+	t.Run("MethodNotAllowed", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		PeerHandler(l, 0).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+		assert.Equal(t, http.MethodGet, rec.Header().Get("Allow"))
+	})
+	t.Run("SnapshotError", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		PeerHandler(snapshotterFunc(func(io.Writer) error { return errors.New("error") }), 0).
+			ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+}
+
+type snapshotterFunc func(io.Writer) error
+
+func (f snapshotterFunc) Snapshot(w io.Writer) error { return f(w) }
+
+func TestImportFromPeer(t *testing.T) {
+	peer := testLimiter(t, 3)
+	for i := 0; i < 3; i++ {
+		_, _, err := peer.Allow("resource", "GET", "127.0.0.1", "")
+		require.NoError(t, err)
+	}
+	srv := httptest.NewServer(PeerHandler(peer.ReadOnly(), 1))
+	defer srv.Close()
+
+	l := testLimiter(t, 3)
+	require.NoError(t, ImportFromPeer(context.Background(), nil, srv.URL, l))
+
+	// The quota for the IP address was exhausted by the peer.
+	allowed, _, err := l.Allow("resource", "GET", "127.0.0.1", "")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	allowed, _, err = l.Allow("resource", "GET", "10.0.0.1", "")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	t.Run("Status", func(t *testing.T) {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		defer srv.Close()
+		err := ImportFromPeer(context.Background(), srv.Client(), srv.URL, l)
+		assert.ErrorContains(t, err, "404")
+	})
+	t.Run("InvalidSnapshot", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("not json"))
+		}))
+		defer srv.Close()
+		err := ImportFromPeer(context.Background(), srv.Client(), srv.URL, l)
+		assert.ErrorIs(t, err, rate.ErrInvalidSnapshot)
+	})
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := ImportFromPeer(ctx, nil, srv.URL, l)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
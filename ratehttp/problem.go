@@ -0,0 +1,55 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratehttp
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const problemJSONContentType = "application/problem+json"
+
+// ProblemDetails is an RFC 9457 problem details object that describes why a
+// request was denied. In addition to the standard members, it includes the
+// resource and action that were limited, and when the limit resets.
+type ProblemDetails struct {
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+
+	Resource string     `json:"resource,omitempty"`
+	Action   string     `json:"action,omitempty"`
+	Reset    *time.Time `json:"reset,omitempty"`
+}
+
+// DefaultProblemDetails returns the ProblemDetails used by
+// WithProblemDetails when no ProblemDetailsFunc is provided.
+func DefaultProblemDetails(resp Response) ProblemDetails {
+	p := ProblemDetails{
+		Type:     "about:blank",
+		Title:    http.StatusText(resp.Status),
+		Status:   resp.Status,
+		Resource: resp.Resource,
+		Action:   resp.Action,
+	}
+	switch {
+	case resp.LimiterFull:
+		p.Detail = "rate limiter is at capacity"
+	case resp.Resource != "" && resp.Action != "":
+		p.Detail = fmt.Sprintf("rate limit exceeded for %s %s", resp.Action, resp.Resource)
+	default:
+		p.Detail = "rate limit exceeded"
+	}
+	if !resp.Reset.IsZero() {
+		reset := resp.Reset.UTC()
+		p.Reset = &reset
+	}
+	if resp.RetryAfter > 0 {
+		p.Detail = fmt.Sprintf("%s, retry after %d seconds", p.Detail, resp.RetryAfter)
+	}
+	return p
+}
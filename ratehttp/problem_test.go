@@ -0,0 +1,73 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratehttp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultProblemDetails(t *testing.T) {
+	reset := time.Date(2023, 1, 1, 0, 1, 0, 0, time.UTC)
+	cases := []struct {
+		name string
+		resp Response
+		want ProblemDetails
+	}{
+		{
+			"no-quota",
+			Response{Status: 429},
+			ProblemDetails{
+				Type:   "about:blank",
+				Title:  "Too Many Requests",
+				Status: 429,
+				Detail: "rate limit exceeded",
+			},
+		},
+		{
+			"quota",
+			Response{
+				Status:     429,
+				Resource:   "resource",
+				Action:     "action",
+				Reset:      reset,
+				RetryAfter: 60,
+			},
+			ProblemDetails{
+				Type:     "about:blank",
+				Title:    "Too Many Requests",
+				Status:   429,
+				Detail:   "rate limit exceeded for action resource, retry after 60 seconds",
+				Resource: "resource",
+				Action:   "action",
+				Reset:    &reset,
+			},
+		},
+		{
+			"limiter-full",
+			Response{
+				Status:      503,
+				Resource:    "resource",
+				Action:      "action",
+				RetryAfter:  10,
+				LimiterFull: true,
+			},
+			ProblemDetails{
+				Type:     "about:blank",
+				Title:    "Service Unavailable",
+				Status:   503,
+				Detail:   "rate limiter is at capacity, retry after 10 seconds",
+				Resource: "resource",
+				Action:   "action",
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, DefaultProblemDetails(tc.resp))
+		})
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratehttp
+
+import "net/http"
+
+// responseRecorder wraps an http.ResponseWriter to record the status code and
+// the number of bytes written in the body of the response.
+type responseRecorder struct {
+	http.ResponseWriter
+
+	status  int
+	written int64
+}
+
+// WriteHeader records the status code, unless it is informational, and then
+// writes it.
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 && status >= 200 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the number of bytes written, and then writes them.
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.written += int64(n)
+	return n, err
+}
+
+// Flush flushes the wrapped http.ResponseWriter, if it supports flushing.
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		if r.status == 0 {
+			r.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped http.ResponseWriter, so that it can be used by
+// http.ResponseController.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Status returns the status code of the response. If the handler did not
+// write a status code or body, http.StatusOK is returned, since that is the
+// status code that will be written by the server.
+func (r *responseRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
@@ -0,0 +1,75 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseRecorder(t *testing.T) {
+	cases := []struct {
+		name        string
+		handler     http.HandlerFunc
+		wantStatus  int
+		wantWritten int64
+	}{
+		{
+			"nothing-written",
+			func(http.ResponseWriter, *http.Request) {},
+			http.StatusOK,
+			0,
+		},
+		{
+			"status",
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+				w.WriteHeader(http.StatusOK)
+			},
+			http.StatusBadGateway,
+			0,
+		},
+		{
+			"informational",
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusEarlyHints)
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			http.StatusInternalServerError,
+			0,
+		},
+		{
+			"body",
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.Write([]byte("hello"))
+				w.Write([]byte(" world"))
+			},
+			http.StatusOK,
+			11,
+		},
+		{
+			"flush",
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.(http.Flusher).Flush()
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			http.StatusOK,
+			0,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			rec := &responseRecorder{ResponseWriter: w}
+			tc.handler(rec, httptest.NewRequest("GET", "/", nil))
+			assert.Equal(t, tc.wantStatus, rec.Status())
+			assert.Equal(t, tc.wantWritten, rec.written)
+			assert.Equal(t, w, rec.Unwrap())
+		})
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratehttp
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/go-rate"
+)
+
+const jsonContentType = "application/json"
+
+// DefaultLimiterFullHeader is a header name that can be provided to
+// WithLimiterFullHeader.
+const DefaultLimiterFullHeader = "RateLimit-Capacity"
+
+// DefaultJSONBodyTemplate is the template used by WithJSONBody.
+var DefaultJSONBodyTemplate = template.Must(template.New("json").Parse(
+	`{"error":"too many requests","retry_after":{{.RetryAfter}}}` + "\n",
+))
+
+// Response contains the details of a response written for a denied request.
+// It is provided to body templates and ProblemDetailsFuncs.
+type Response struct {
+	// Status is the HTTP status code of the response.
+	Status int
+
+	// Resource and Action are the resource and action of the request. They
+	// are only set for responses written by a Middleware.
+	Resource string
+	Action   string
+
+	// Limit and Remaining are the maximum and remaining requests for the
+	// quota, and Reset is when the quota resets. These are zero if there is
+	// no quota.
+	Limit     uint64
+	Remaining uint64
+	Reset     time.Time
+
+	// RetryAfter is the number of seconds the client should wait before
+	// making another request. It is zero if there is no quota, unless the
+	// request was denied because the Limiter was full.
+	RetryAfter int64
+
+	// LimiterFull is true if the request was denied because the Limiter had
+	// reached its capacity, rather than because the client exceeded a quota.
+	LimiterFull bool
+}
+
+// WriteTooManyRequests writes a 429 Too Many Requests response for a request
+// that was denied because of the provided Quota. The Retry-After header is set
+// to the number of seconds until the quota resets, and the rate limit usage
+// header is set for the quota. If the quota is nil, neither header is set.
+//
+// If a body fails to be created, the response is written without a body and
+// the error is returned.
+//
+// Supported options are:
+//   - WithUsageHeaderSetter: Sets the rate limit usage header using the
+//     provided UsageHeaderSetter, typically the rate.Limiter.
+//   - WithBodyTemplate: Writes a body using the provided template.
+//   - WithJSONBody: Writes a body using DefaultJSONBodyTemplate.
+//   - WithProblemDetails: Writes an application/problem+json body.
+func WriteTooManyRequests(w http.ResponseWriter, quota *rate.Quota, opt ...Option) error {
+	const op = "ratehttp.WriteTooManyRequests"
+
+	opts := getOpts(opt...)
+	if err := writeDenied(w, Response{Status: http.StatusTooManyRequests}, quota, opts); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// writeDenied writes a response for a denied request, filling in the details
+// of resp from the quota. If there is no quota, the Retry-After header is only
+// set if resp.RetryAfter is greater than zero.
+func writeDenied(w http.ResponseWriter, resp Response, quota *rate.Quota, opts options) error {
+	header := w.Header()
+	switch {
+	case quota != nil:
+		resp.Limit = quota.MaxRequests()
+		resp.Remaining = quota.Remaining()
+		resp.Reset = quota.Expiration()
+		resp.RetryAfter = retryAfter(quota.ResetsIn())
+		header.Set("Retry-After", strconv.FormatInt(resp.RetryAfter, 10))
+	case resp.RetryAfter > 0:
+		header.Set("Retry-After", strconv.FormatInt(resp.RetryAfter, 10))
+	}
+	if resp.LimiterFull && opts.withLimiterFullHeader != "" {
+		header.Set(opts.withLimiterFullHeader, limiterFullHeaderValue(resp))
+	}
+
+	if quota != nil {
+		switch opts.withUsageHeaderSetter {
+		case nil:
+			header.Set(rate.DefaultUsageHeader, usageHeaderValue(resp))
+		default:
+			opts.withUsageHeaderSetter.SetUsageHeader(quota, header)
+		}
+	}
+
+	if opts.withBody == nil {
+		w.WriteHeader(resp.Status)
+		return nil
+	}
+
+	var body bytes.Buffer
+	if err := opts.withBody(&body, resp); err != nil {
+		w.WriteHeader(resp.Status)
+		return err
+	}
+	w.Header().Set("Content-Type", opts.withBodyContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+	w.WriteHeader(resp.Status)
+	_, err := w.Write(body.Bytes())
+	return err
+}
+
+// retryAfter returns the number of seconds in d, rounded up. Negative
+// durations are treated as zero.
+func retryAfter(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+// limiterFullHeaderValue returns the value of the header set by
+// WithLimiterFullHeader.
+func limiterFullHeaderValue(resp Response) string {
+	return fmt.Sprintf(`retry=%d, comment="capacity"`, resp.RetryAfter)
+}
+
+// usageHeaderValue returns the value of the rate limit usage header.
+func usageHeaderValue(resp Response) string {
+	return fmt.Sprintf("limit=%d, remaining=%d, reset=%d", resp.Limit, resp.Remaining, resp.RetryAfter)
+}
@@ -0,0 +1,122 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratehttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/go-rate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deniedQuota returns a quota that has been exhausted.
+func deniedQuota(t *testing.T, o ...rate.Option) (*rate.Limiter, *rate.Quota) {
+	t.Helper()
+	l, err := rate.NewLimiter(
+		[]rate.Limit{
+			&rate.Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         rate.LimitPerTotal,
+				MaxRequests: 1,
+				Period:      time.Minute,
+			},
+			&rate.Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      rate.LimitPerIPAddress,
+			},
+			&rate.Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      rate.LimitPerAuthToken,
+			},
+		},
+		10,
+		o...,
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Shutdown() })
+
+	allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+	require.True(t, allowed)
+	allowed, quota, err := l.Allow("resource", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+	require.False(t, allowed)
+	return l, quota
+}
+
+func TestWriteTooManyRequests(t *testing.T) {
+	t.Run("headers", func(t *testing.T) {
+		_, quota := deniedQuota(t)
+		w := httptest.NewRecorder()
+		require.NoError(t, WriteTooManyRequests(w, quota))
+
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "60", w.Header().Get("Retry-After"))
+		assert.Equal(t, "limit=1, remaining=0, reset=60", w.Header().Get(rate.DefaultUsageHeader))
+		assert.Empty(t, w.Header().Get("Content-Type"))
+		assert.Empty(t, w.Body.String())
+	})
+	t.Run("nil-quota", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		require.NoError(t, WriteTooManyRequests(w, nil))
+
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Empty(t, w.Header().Get("Retry-After"))
+		assert.Empty(t, w.Header().Get(rate.DefaultUsageHeader))
+	})
+	t.Run("usage-header-setter", func(t *testing.T) {
+		l, quota := deniedQuota(t, rate.WithUsageHeader("X-RateLimit"))
+		w := httptest.NewRecorder()
+		require.NoError(t, WriteTooManyRequests(w, quota, WithUsageHeaderSetter(l)))
+
+		assert.Equal(t, "limit=1, remaining=0, reset=60", w.Header().Get("X-RateLimit"))
+		assert.Empty(t, w.Header().Get(rate.DefaultUsageHeader))
+	})
+	t.Run("json-body", func(t *testing.T) {
+		_, quota := deniedQuota(t)
+		w := httptest.NewRecorder()
+		require.NoError(t, WriteTooManyRequests(w, quota, WithJSONBody()))
+
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, map[string]any{"error": "too many requests", "retry_after": float64(60)}, body)
+	})
+	t.Run("body-template", func(t *testing.T) {
+		_, quota := deniedQuota(t)
+		tmpl := template.Must(template.New("").Parse("{{.Status}} {{.Limit}} {{.Remaining}} {{.RetryAfter}}"))
+		w := httptest.NewRecorder()
+		require.NoError(t, WriteTooManyRequests(w, quota, WithBodyTemplate("text/plain", tmpl)))
+
+		assert.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+		assert.Equal(t, "429 1 0 60", w.Body.String())
+	})
+	t.Run("body-template-error", func(t *testing.T) {
+		_, quota := deniedQuota(t)
+		tmpl := template.Must(template.New("").Parse("{{.Missing}}"))
+		w := httptest.NewRecorder()
+		require.Error(t, WriteTooManyRequests(w, quota, WithBodyTemplate("text/plain", tmpl)))
+
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Empty(t, w.Header().Get("Content-Type"))
+		assert.Empty(t, w.Body.String())
+	})
+}
+
+func Test_retryAfter(t *testing.T) {
+	assert.Equal(t, int64(0), retryAfter(-time.Second))
+	assert.Equal(t, int64(0), retryAfter(0))
+	assert.Equal(t, int64(1), retryAfter(time.Millisecond))
+	assert.Equal(t, int64(1), retryAfter(time.Second))
+	assert.Equal(t, int64(2), retryAfter(time.Second+time.Nanosecond))
+}
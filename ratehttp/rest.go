@@ -0,0 +1,61 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratehttp
+
+import (
+	"net/http"
+	"strings"
+)
+
+// The actions returned by MethodAction.
+const (
+	ActionRead   = "read"
+	ActionList   = "list"
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// RESTPolicy is a PolicyFunc for typical REST services, where paths alternate
+// between the names of collections and the IDs of items in them, such as
+// "/users/123/posts/456". The resource is the names of the collections in the
+// path, joined by "/", such as "users/posts", and the action is returned by
+// MethodAction. Requests for "/" have an empty resource.
+func RESTPolicy(r *http.Request) (resource, action string) {
+	segments := pathSegments(r.URL.Path)
+	collections := make([]string, 0, (len(segments)+1)/2)
+	for i := 0; i < len(segments); i += 2 {
+		collections = append(collections, segments[i])
+	}
+	return strings.Join(collections, "/"), MethodAction(r)
+}
+
+// MethodAction returns the action of a request based on its HTTP method, for
+// use by a PolicyFunc. GET and HEAD requests are ActionList for a collection
+// and ActionRead for an item, POST requests are ActionCreate, PUT and PATCH
+// requests are ActionUpdate, and DELETE requests are ActionDelete. A path is
+// for a collection if it has an odd number of segments, such as "/users" or
+// "/users/123/posts", or no segments. Other methods are returned in lowercase.
+func MethodAction(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, "":
+		if n := len(pathSegments(r.URL.Path)); n > 0 && n%2 == 0 {
+			return ActionRead
+		}
+		return ActionList
+	case http.MethodPost:
+		return ActionCreate
+	case http.MethodPut, http.MethodPatch:
+		return ActionUpdate
+	case http.MethodDelete:
+		return ActionDelete
+	default:
+		return strings.ToLower(r.Method)
+	}
+}
+
+// pathSegments returns the non-empty segments of a path.
+func pathSegments(path string) []string {
+	return strings.FieldsFunc(path, func(c rune) bool { return c == '/' })
+}
@@ -0,0 +1,80 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-rate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRESTPolicy(t *testing.T) {
+	cases := []struct {
+		method       string
+		path         string
+		wantResource string
+		wantAction   string
+	}{
+		{http.MethodGet, "/", "", ActionList},
+		{http.MethodGet, "/users", "users", ActionList},
+		{http.MethodGet, "/users/", "users", ActionList},
+		{http.MethodHead, "/users/123", "users", ActionRead},
+		{http.MethodGet, "/users/123", "users", ActionRead},
+		{http.MethodGet, "/users/123/posts", "users/posts", ActionList},
+		{http.MethodGet, "/users/123/posts/456", "users/posts", ActionRead},
+		{http.MethodPost, "/users", "users", ActionCreate},
+		{http.MethodPut, "/users/123", "users", ActionUpdate},
+		{http.MethodPatch, "/users/123", "users", ActionUpdate},
+		{http.MethodDelete, "/users/123", "users", ActionDelete},
+		{http.MethodOptions, "/users", "users", "options"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.method+tc.path, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.path, nil)
+			resource, action := RESTPolicy(r)
+			assert.Equal(t, tc.wantResource, resource)
+			assert.Equal(t, tc.wantAction, action)
+			assert.Equal(t, tc.wantAction, MethodAction(r))
+		})
+	}
+}
+
+func TestMiddlewareRESTPolicy(t *testing.T) {
+	l, err := rate.NewLimiter([]rate.Limit{
+		&rate.Limited{
+			Resource:    "users",
+			Action:      ActionRead,
+			Per:         rate.LimitPerIPAddress,
+			MaxRequests: 1,
+			Period:      time.Minute,
+		},
+	}, 10, rate.WithUnknownPolicyBehavior(rate.UnknownPolicyAllow))
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Shutdown() })
+
+	m, err := NewMiddleware(l, RESTPolicy)
+	require.NoError(t, err)
+	h := m.Handler(okHandler)
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{http.MethodGet, "/users/1", http.StatusOK},
+		{http.MethodGet, "/users/2", http.StatusTooManyRequests},
+		// Other actions do not have limits, and are allowed by the Limiter.
+		{http.MethodGet, "/users", http.StatusOK},
+		{http.MethodDelete, "/users/1", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		assert.Equal(t, tc.want, w.Code, "%s %s", tc.method, tc.path)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratehttp
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/go-rate"
+)
+
+// Route maps requests with a method and path to a resource and action.
+type Route struct {
+	// Method is the HTTP method of the route, such as "GET".
+	Method string
+	// Path is the path of the route, where a segment in braces, such as
+	// "{id}" in "/users/{id}", matches any single segment of a request's
+	// path, as in an OpenAPI document.
+	Path string
+
+	Resource string
+	Action   string
+}
+
+// RouteMapper maps requests to a resource and action using a table of
+// Routes.
+type RouteMapper struct {
+	// routes are the routes for each method.
+	routes map[string][]parsedRoute
+}
+
+// parsedRoute is a Route with its path split into segments.
+type parsedRoute struct {
+	segments []string
+	// params reports whether each of the segments is a parameter.
+	params   []bool
+	resource string
+	action   string
+}
+
+// NewRouteMapper creates a RouteMapper for the routes. The routes must have a
+// method, path, resource, and action, and must not have the same method and
+// path.
+func NewRouteMapper(routes []Route) (*RouteMapper, error) {
+	const op = "ratehttp.NewRouteMapper"
+
+	m := &RouteMapper{routes: make(map[string][]parsedRoute)}
+	seen := make(map[string]bool, len(routes))
+	for _, r := range routes {
+		switch {
+		case r.Method == "", r.Path == "":
+			return nil, fmt.Errorf("%s: missing method or path: %w", op, rate.ErrInvalidParameter)
+		case r.Resource == "", r.Action == "":
+			return nil, fmt.Errorf("%s: missing resource or action for %s %s: %w", op, r.Method, r.Path, rate.ErrInvalidParameter)
+		}
+
+		pr := parsedRoute{
+			segments: pathSegments(r.Path),
+			resource: r.Resource,
+			action:   r.Action,
+		}
+		pr.params = make([]bool, len(pr.segments))
+		for i, s := range pr.segments {
+			if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+				pr.params[i] = true
+				// Parameters with different names match the same requests.
+				pr.segments[i] = "{}"
+			}
+		}
+
+		method := strings.ToUpper(r.Method)
+		key := method + " /" + strings.Join(pr.segments, "/")
+		if seen[key] {
+			return nil, fmt.Errorf("%s: duplicate route for %s %s: %w", op, r.Method, r.Path, rate.ErrInvalidParameter)
+		}
+		seen[key] = true
+		m.routes[method] = append(m.routes[method], pr)
+	}
+	return m, nil
+}
+
+// Policy is a PolicyFunc that returns the resource and action of the route
+// that matches the request. If more than one route matches, the route whose
+// first differing segment is not a parameter is used, so that "/users/me"
+// is preferred over "/users/{id}". If no route matches, empty strings are
+// returned, which a Limiter will not have a policy for.
+func (m *RouteMapper) Policy(r *http.Request) (resource, action string) {
+	segments := pathSegments(r.URL.Path)
+
+	var best *parsedRoute
+	routes := m.routes[r.Method]
+	for i := range routes {
+		pr := &routes[i]
+		if !pr.matches(segments) {
+			continue
+		}
+		if best == nil || pr.moreSpecific(best) {
+			best = pr
+		}
+	}
+	if best == nil {
+		return "", ""
+	}
+	return best.resource, best.action
+}
+
+// matches checks if the route matches the segments of a request's path.
+func (pr *parsedRoute) matches(segments []string) bool {
+	if len(segments) != len(pr.segments) {
+		return false
+	}
+	for i, s := range segments {
+		if !pr.params[i] && s != pr.segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// moreSpecific checks if the route is more specific than o, which has the same
+// number of segments.
+func (pr *parsedRoute) moreSpecific(o *parsedRoute) bool {
+	for i := range pr.params {
+		if pr.params[i] != o.params[i] {
+			return !pr.params[i]
+		}
+	}
+	return false
+}
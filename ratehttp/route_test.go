@@ -0,0 +1,77 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratehttp
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/go-rate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRouteMapper(t *testing.T) {
+	cases := []struct {
+		name   string
+		routes []Route
+	}{
+		{"missing-method", []Route{{Path: "/users", Resource: "users", Action: "list"}}},
+		{"missing-path", []Route{{Method: "GET", Resource: "users", Action: "list"}}},
+		{"missing-resource", []Route{{Method: "GET", Path: "/users", Action: "list"}}},
+		{"missing-action", []Route{{Method: "GET", Path: "/users", Resource: "users"}}},
+		{
+			"duplicate",
+			[]Route{
+				{Method: "GET", Path: "/users/{id}", Resource: "users", Action: "read"},
+				{Method: "get", Path: "/users/{name}/", Resource: "users", Action: "read"},
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewRouteMapper(tc.routes)
+			assert.ErrorIs(t, err, rate.ErrInvalidParameter)
+		})
+	}
+}
+
+func TestRouteMapperPolicy(t *testing.T) {
+	m, err := NewRouteMapper([]Route{
+		{Method: "GET", Path: "/users", Resource: "listUsers", Action: "get"},
+		{Method: "POST", Path: "/users", Resource: "createUser", Action: "post"},
+		{Method: "GET", Path: "/users/{id}", Resource: "getUser", Action: "get"},
+		{Method: "GET", Path: "/users/me", Resource: "getCurrentUser", Action: "get"},
+		{Method: "GET", Path: "/users/{id}/posts/{postID}", Resource: "getPost", Action: "get"},
+		{Method: "GET", Path: "/users/{id}/posts/latest", Resource: "getLatestPost", Action: "get"},
+		{Method: "GET", Path: "/", Resource: "root", Action: "get"},
+	})
+	require.NoError(t, err)
+
+	cases := []struct {
+		method       string
+		path         string
+		wantResource string
+		wantAction   string
+	}{
+		{"GET", "/users", "listUsers", "get"},
+		{"GET", "/users/", "listUsers", "get"},
+		{"POST", "/users", "createUser", "post"},
+		{"GET", "/users/123", "getUser", "get"},
+		{"GET", "/users/me", "getCurrentUser", "get"},
+		{"GET", "/users/123/posts/456", "getPost", "get"},
+		{"GET", "/users/123/posts/latest", "getLatestPost", "get"},
+		{"GET", "/", "root", "get"},
+		{"DELETE", "/users/123", "", ""},
+		{"GET", "/users/123/posts", "", ""},
+		{"GET", "/groups", "", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.method+tc.path, func(t *testing.T) {
+			resource, action := m.Policy(httptest.NewRequest(tc.method, tc.path, nil))
+			assert.Equal(t, tc.wantResource, resource)
+			assert.Equal(t, tc.wantAction, action)
+		})
+	}
+}
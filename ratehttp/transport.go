@@ -0,0 +1,99 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratehttp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/go-rate"
+)
+
+// TransportLimiter is used by a Transport to check outbound requests. It is
+// implemented by rate.Limiter and rate.NopLimiter.
+type TransportLimiter interface {
+	AllowNErr(resource, action, ip, authToken string, n uint64) (*rate.Quota, error)
+}
+
+// Transport is an http.RoundTripper that limits the outbound requests made by
+// an http.Client, so that the APIs it calls are protected from being sent too
+// many requests.
+type Transport struct {
+	limiter  TransportLimiter
+	policyFn PolicyFunc
+	next     http.RoundTripper
+	opts     options
+}
+
+// NewTransport creates a Transport that uses the limiter to check each
+// outbound request, using the resource and action returned by policyFn.
+// HostPolicy can be used to limit requests per destination host. Allowed
+// requests are sent using next, or http.DefaultTransport if next is nil.
+// Denied requests are not sent, and the error returned by the limiter is
+// returned wrapped, so that a *rate.ErrRateLimited or *rate.ErrLimiterFull
+// can be checked using errors.As. Requests for which the limiter has no
+// policy, or whose context was created by rate.WithBypass, are sent without
+// being limited.
+//
+// Supported options are:
+//   - WithIPAddressFunc: Provides the function used to get the IP address
+//     used by the limiter. The default is RemoteIPAddress, which is empty for
+//     outbound requests.
+//   - WithAuthTokenFunc: Provides the function used to get the auth token
+//     used by the limiter. The default is AuthorizationHeader, so that
+//     requests made using different credentials can be limited separately.
+//   - WithCost: Provides the number of requests that each request costs. The
+//     default is 1.
+func NewTransport(limiter TransportLimiter, policyFn PolicyFunc, next http.RoundTripper, opt ...Option) (*Transport, error) {
+	const op = "ratehttp.NewTransport"
+
+	switch {
+	case limiter == nil:
+		return nil, fmt.Errorf("%s: missing limiter: %w", op, rate.ErrInvalidParameter)
+	case policyFn == nil:
+		return nil, fmt.Errorf("%s: missing policy func: %w", op, rate.ErrInvalidParameter)
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &Transport{
+		limiter:  limiter,
+		policyFn: policyFn,
+		next:     next,
+		opts:     getOpts(opt...),
+	}, nil
+}
+
+// RoundTrip implements http.RoundTripper. It checks the request using the
+// limiter before sending it.
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	const op = "ratehttp.(Transport).RoundTrip"
+
+	if rate.IsBypassed(r.Context()) {
+		return t.next.RoundTrip(r)
+	}
+
+	resource, action := t.policyFn(r)
+	_, err := t.limiter.AllowNErr(resource, action, t.opts.withIPAddress(r), t.opts.withAuthToken(r), t.opts.withCost)
+	if err != nil && !errors.Is(err, rate.ErrLimitPolicyNotFound) {
+		// A RoundTripper must always close the request body, even if the
+		// request is not sent.
+		if r.Body != nil {
+			_ = r.Body.Close()
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return t.next.RoundTrip(r)
+}
+
+// HostPolicy is a PolicyFunc for outbound requests, where the resource is the
+// lowercase host of the request's URL, without a port, such as
+// "api.example.com", and the action is returned by MethodAction. This allows
+// limits to be defined for each destination host.
+func HostPolicy(r *http.Request) (resource, action string) {
+	return strings.ToLower(r.URL.Hostname()), MethodAction(r)
+}
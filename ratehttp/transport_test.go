@@ -0,0 +1,138 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ratehttp
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-rate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// roundTripperFunc is an http.RoundTripper that calls itself.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// closeRecorder is a request body that records whether it was closed.
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestNewTransport(t *testing.T) {
+	l := testLimiter(t, 1)
+
+	_, err := NewTransport(nil, HostPolicy, nil)
+	assert.ErrorIs(t, err, rate.ErrInvalidParameter)
+	_, err = NewTransport(l, nil, nil)
+	assert.ErrorIs(t, err, rate.ErrInvalidParameter)
+
+	tr, err := NewTransport(l, HostPolicy, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.DefaultTransport, tr.next)
+}
+
+func TestTransport(t *testing.T) {
+	l, err := rate.NewLimiter(
+		[]rate.Limit{
+			&rate.Limited{
+				Resource:    "api.example.com",
+				Action:      ActionList,
+				Per:         rate.LimitPerTotal,
+				MaxRequests: 2,
+				Period:      time.Minute,
+			},
+			&rate.Unlimited{
+				Resource: "api.example.com",
+				Action:   ActionList,
+				Per:      rate.LimitPerIPAddress,
+			},
+			&rate.Unlimited{
+				Resource: "api.example.com",
+				Action:   ActionList,
+				Per:      rate.LimitPerAuthToken,
+			},
+		},
+		10,
+	)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	var sent int
+	next := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		sent++
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: r}, nil
+	})
+	tr, err := NewTransport(l, HostPolicy, next)
+	require.NoError(t, err)
+	client := &http.Client{Transport: tr}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get("https://API.example.com:8443/users")
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	assert.Equal(t, 2, sent)
+
+	// The quota for the host is exhausted, so the request is not sent.
+	body := &closeRecorder{Reader: strings.NewReader("body")}
+	r := httptest.NewRequest("GET", "https://api.example.com/users", body)
+	resp, err := tr.RoundTrip(r)
+	assert.Nil(t, resp)
+	var limited *rate.ErrRateLimited
+	require.True(t, errors.As(err, &limited))
+	assert.Equal(t, "api.example.com", limited.Resource)
+	assert.Equal(t, ActionList, limited.Action)
+	assert.Greater(t, limited.RetryAfter(), time.Duration(0))
+	assert.True(t, body.closed)
+	assert.Equal(t, 2, sent)
+
+	// Bypassed requests are sent without being limited.
+	r = httptest.NewRequest("GET", "https://api.example.com/users", nil)
+	r = r.WithContext(rate.WithBypass(r.Context()))
+	_, err = tr.RoundTrip(r)
+	require.NoError(t, err)
+	assert.Equal(t, 3, sent)
+
+	// Requests to hosts without a policy are sent without being limited.
+	_, err = tr.RoundTrip(httptest.NewRequest("GET", "https://other.example.com/users", nil))
+	require.NoError(t, err)
+	assert.Equal(t, 4, sent)
+}
+
+func TestHostPolicy(t *testing.T) {
+	cases := []struct {
+		method       string
+		url          string
+		wantResource string
+		wantAction   string
+	}{
+		{"GET", "https://api.example.com/users", "api.example.com", ActionList},
+		{"GET", "https://API.Example.com:8443/users/123", "api.example.com", ActionRead},
+		{"POST", "http://127.0.0.1:8080/users", "127.0.0.1", ActionCreate},
+		{"DELETE", "http://[::1]/users/123", "::1", ActionDelete},
+	}
+	for _, tc := range cases {
+		t.Run(tc.method+" "+tc.url, func(t *testing.T) {
+			resource, action := HostPolicy(httptest.NewRequest(tc.method, tc.url, nil))
+			assert.Equal(t, tc.wantResource, resource)
+			assert.Equal(t, tc.wantAction, action)
+		})
+	}
+}
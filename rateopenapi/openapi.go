@@ -0,0 +1,158 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package rateopenapi generates limits and ratehttp routes from an OpenAPI
+// document, so that the limits of a service can be kept in sync with its API
+// as operations are added.
+//
+// Each operation in the document is limited as its own resource, using its
+// operationId, with an action of its lowercase HTTP method.
+package rateopenapi
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-rate"
+	"github.com/hashicorp/go-rate/ratehttp"
+	"gopkg.in/yaml.v3"
+)
+
+// Operation is an operation in an OpenAPI document.
+type Operation struct {
+	// ID is the operationId of the operation.
+	ID     string
+	Method string
+	Path   string
+}
+
+// Resource returns the resource of the operation's limits, which is its ID.
+func (o Operation) Resource() string {
+	return o.ID
+}
+
+// Action returns the action of the operation's limits, which is its
+// lowercase HTTP method.
+func (o Operation) Action() string {
+	return strings.ToLower(o.Method)
+}
+
+// document is the part of an OpenAPI document that is used to read its
+// operations. Since JSON is a subset of YAML, it can be decoded from either.
+type document struct {
+	OpenAPI string              `yaml:"openapi"`
+	Swagger string              `yaml:"swagger"`
+	Paths   map[string]pathItem `yaml:"paths"`
+}
+
+// pathItem is an OpenAPI Path Item Object.
+type pathItem struct {
+	Get     *operation `yaml:"get"`
+	Put     *operation `yaml:"put"`
+	Post    *operation `yaml:"post"`
+	Delete  *operation `yaml:"delete"`
+	Options *operation `yaml:"options"`
+	Head    *operation `yaml:"head"`
+	Patch   *operation `yaml:"patch"`
+	Trace   *operation `yaml:"trace"`
+}
+
+// operation is an OpenAPI Operation Object.
+type operation struct {
+	OperationID string `yaml:"operationId"`
+}
+
+// ReadOperations reads the operations of an OpenAPI document, in either JSON
+// or YAML. Both OpenAPI 3 and Swagger 2 documents are supported. Each
+// operation must have an operationId, so that it can be used as the resource
+// of its limits. The operations are returned sorted by path and method.
+func ReadOperations(r io.Reader) ([]Operation, error) {
+	const op = "rateopenapi.ReadOperations"
+
+	var doc document
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if doc.OpenAPI == "" && doc.Swagger == "" {
+		return nil, fmt.Errorf("%s: missing openapi version: %w", op, rate.ErrInvalidParameter)
+	}
+
+	var ops []Operation
+	ids := make(map[string]bool)
+	for path, item := range doc.Paths {
+		for _, m := range []struct {
+			method string
+			op     *operation
+		}{
+			{"GET", item.Get},
+			{"PUT", item.Put},
+			{"POST", item.Post},
+			{"DELETE", item.Delete},
+			{"OPTIONS", item.Options},
+			{"HEAD", item.Head},
+			{"PATCH", item.Patch},
+			{"TRACE", item.Trace},
+		} {
+			switch {
+			case m.op == nil:
+				continue
+			case m.op.OperationID == "":
+				return nil, fmt.Errorf("%s: missing operationId for %s %s: %w", op, m.method, path, rate.ErrInvalidParameter)
+			case ids[m.op.OperationID]:
+				return nil, fmt.Errorf("%s: duplicate operationId %q: %w", op, m.op.OperationID, rate.ErrInvalidParameter)
+			}
+			ids[m.op.OperationID] = true
+			ops = append(ops, Operation{ID: m.op.OperationID, Method: m.method, Path: path})
+		}
+	}
+
+	sort.Slice(ops, func(i, j int) bool {
+		if ops[i].Path != ops[j].Path {
+			return ops[i].Path < ops[j].Path
+		}
+		return ops[i].Method < ops[j].Method
+	})
+	return ops, nil
+}
+
+// Limits returns the limits for each of the operations. For each operation, a
+// copy of each of the template limits is made with its Resource and Action set
+// to those of the operation. Any Resource and Action of the template limits
+// are ignored. The limits for specific operations can then be adjusted before
+// they are used to create a Limiter.
+func Limits(ops []Operation, template ...rate.Limit) []rate.Limit {
+	limits := make([]rate.Limit, 0, len(ops)*len(template))
+	for _, o := range ops {
+		for _, t := range template {
+			switch tl := t.(type) {
+			case *rate.Limited:
+				l := *tl
+				l.Resource, l.Action = o.Resource(), o.Action()
+				limits = append(limits, &l)
+			case *rate.Unlimited:
+				l := *tl
+				l.Resource, l.Action = o.Resource(), o.Action()
+				limits = append(limits, &l)
+			}
+		}
+	}
+	return limits
+}
+
+// Routes returns the ratehttp routes for the operations, which can be used
+// with ratehttp.NewRouteMapper to map requests to the resource and action of
+// their operation.
+func Routes(ops []Operation) []ratehttp.Route {
+	routes := make([]ratehttp.Route, 0, len(ops))
+	for _, o := range ops {
+		routes = append(routes, ratehttp.Route{
+			Method:   o.Method,
+			Path:     o.Path,
+			Resource: o.Resource(),
+			Action:   o.Action(),
+		})
+	}
+	return routes
+}
@@ -0,0 +1,155 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rateopenapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-rate"
+	"github.com/hashicorp/go-rate/ratehttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDocumentYAML = `
+openapi: 3.0.3
+info:
+  title: Users
+  version: 1.0.0
+paths:
+  /users:
+    summary: Users
+    get:
+      operationId: listUsers
+    post:
+      operationId: createUser
+  /users/{id}:
+    parameters:
+      - name: id
+        in: path
+    get:
+      operationId: getUser
+    delete:
+      operationId: deleteUser
+`
+
+const testDocumentJSON = `{
+  "swagger": "2.0",
+  "paths": {
+    "/users/{id}": {
+      "get": {"operationId": "getUser"},
+      "delete": {"operationId": "deleteUser"}
+    },
+    "/users": {
+      "post": {"operationId": "createUser"},
+      "get": {"operationId": "listUsers"}
+    }
+  }
+}`
+
+var testOperations = []Operation{
+	{ID: "listUsers", Method: "GET", Path: "/users"},
+	{ID: "createUser", Method: "POST", Path: "/users"},
+	{ID: "deleteUser", Method: "DELETE", Path: "/users/{id}"},
+	{ID: "getUser", Method: "GET", Path: "/users/{id}"},
+}
+
+func TestReadOperations(t *testing.T) {
+	cases := []struct {
+		name    string
+		in      string
+		want    []Operation
+		wantErr bool
+	}{
+		{"yaml", testDocumentYAML, testOperations, false},
+		{"json", testDocumentJSON, testOperations, false},
+		{"no-paths", "openapi: 3.1.0\n", nil, false},
+		{"missing-version", "paths: {}\n", nil, true},
+		{"invalid", "{", nil, true},
+		{
+			"missing-operation-id",
+			"openapi: 3.1.0\npaths:\n  /users:\n    get:\n      summary: List users\n",
+			nil,
+			true,
+		},
+		{
+			"duplicate-operation-id",
+			"openapi: 3.1.0\npaths:\n  /users:\n    get:\n      operationId: users\n    post:\n      operationId: users\n",
+			nil,
+			true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ReadOperations(strings.NewReader(tc.in))
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	_, err := ReadOperations(strings.NewReader("openapi: 3.1.0\npaths:\n  /users:\n    get: {}\n"))
+	assert.ErrorIs(t, err, rate.ErrInvalidParameter)
+}
+
+func TestLimits(t *testing.T) {
+	got := Limits(testOperations[:2],
+		&rate.Limited{Resource: "ignored", Per: rate.LimitPerIPAddress, MaxRequests: 10, Period: time.Minute},
+		&rate.Unlimited{Per: rate.LimitPerTotal},
+	)
+	assert.Equal(t, []rate.Limit{
+		&rate.Limited{Resource: "listUsers", Action: "get", Per: rate.LimitPerIPAddress, MaxRequests: 10, Period: time.Minute},
+		&rate.Unlimited{Resource: "listUsers", Action: "get", Per: rate.LimitPerTotal},
+		&rate.Limited{Resource: "createUser", Action: "post", Per: rate.LimitPerIPAddress, MaxRequests: 10, Period: time.Minute},
+		&rate.Unlimited{Resource: "createUser", Action: "post", Per: rate.LimitPerTotal},
+	}, got)
+}
+
+func TestRoutes(t *testing.T) {
+	assert.Equal(t, []ratehttp.Route{
+		{Method: "GET", Path: "/users", Resource: "listUsers", Action: "get"},
+		{Method: "POST", Path: "/users", Resource: "createUser", Action: "post"},
+	}, Routes(testOperations[:2]))
+}
+
+func TestMiddleware(t *testing.T) {
+	ops, err := ReadOperations(strings.NewReader(testDocumentYAML))
+	require.NoError(t, err)
+
+	// Routes that are not in the document, such as /groups, do not have a
+	// limit policy, and are allowed.
+	l, err := rate.NewLimiter(Limits(ops, &rate.Limited{Per: rate.LimitPerIPAddress, MaxRequests: 1, Period: time.Minute}), 10,
+		rate.WithUnknownPolicyBehavior(rate.UnknownPolicyAllow))
+	require.NoError(t, err)
+	defer l.Shutdown()
+	rm, err := ratehttp.NewRouteMapper(Routes(ops))
+	require.NoError(t, err)
+	m, err := ratehttp.NewMiddleware(l, rm.Policy)
+	require.NoError(t, err)
+	h := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{"GET", "/users/1", http.StatusOK},
+		{"GET", "/users/2", http.StatusTooManyRequests},
+		{"DELETE", "/users/1", http.StatusOK},
+		{"GET", "/users", http.StatusOK},
+		{"GET", "/users", http.StatusTooManyRequests},
+		{"GET", "/groups", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		assert.Equal(t, tc.want, w.Code, "%s %s", tc.method, tc.path)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rateunix
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-rate"
+)
+
+// Result is the result of a request checked by a Server.
+type Result struct {
+	// Allowed is true if the request was allowed.
+	Allowed bool
+	// Limit and Remaining are the maximum and remaining requests for the
+	// quota returned by the Server's Limiter, and ResetsIn is how long until
+	// it resets. These are zero if there is no quota, such as when all of the
+	// limits for the request are Unlimited.
+	Limit     uint64
+	Remaining uint64
+	ResetsIn  time.Duration
+}
+
+// Client checks requests using the Limiter of a Server. It is safe for
+// concurrent use, but only sends one request to the Server at a time.
+type Client struct {
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Dial connects to the Server listening on the Unix domain socket at path.
+func Dial(path string) (*Client, error) {
+	const op = "rateunix.Dial"
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient creates a Client that sends requests to a Server using conn.
+func NewClient(conn net.Conn) *Client {
+	return &Client{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+	}
+}
+
+// Allow checks a request using the Server's Limiter, as with
+// rate.Limiter.Allow.
+func (c *Client) Allow(resource, action, ip, authToken string) (Result, error) {
+	return c.AllowN(resource, action, ip, authToken, 1)
+}
+
+// AllowN checks a request that costs n requests using the Server's Limiter,
+// as with rate.Limiter.AllowN. If the Server's Limiter returns one of the
+// rate package's sentinel errors, such as rate.ErrLimitPolicyNotFound, it is
+// returned wrapped, so that it can be checked using errors.Is. If the
+// Server's Limiter is full, a *rate.ErrLimiterFull is returned wrapped.
+//
+// If the connection to the Server fails, the Client cannot be used for
+// further requests, and should be closed.
+func (c *Client) AllowN(resource, action, ip, authToken string, n uint64) (Result, error) {
+	const op = "rateunix.(Client).AllowN"
+
+	req := request{
+		Resource:  resource,
+		Action:    action,
+		IP:        ip,
+		AuthToken: authToken,
+		N:         n,
+	}
+	var resp response
+
+	c.mu.Lock()
+	err := writeFrame(c.conn, req)
+	if err == nil {
+		err = readFrame(c.r, &resp)
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return Result{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	switch {
+	case resp.Error == "":
+	case resp.Error == (&rate.ErrLimiterFull{}).Error():
+		full := &rate.ErrLimiterFull{
+			RetryIn: resp.RetryIn,
+			RetryAt: time.Now().Add(resp.RetryIn),
+		}
+		return Result{}, fmt.Errorf("%s: %w", op, full)
+	default:
+		return Result{}, fmt.Errorf("%s: %w", op, remoteError(resp.Error))
+	}
+	return Result{
+		Allowed:   resp.Allowed,
+		Limit:     resp.Limit,
+		Remaining: resp.Remaining,
+		ResetsIn:  resp.ResetsIn,
+	}, nil
+}
+
+// Close closes the connection to the Server.
+func (c *Client) Close() error {
+	const op = "rateunix.(Client).Close"
+
+	if err := c.conn.Close(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
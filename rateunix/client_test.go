@@ -0,0 +1,69 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rateunix
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-rate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDial(t *testing.T) {
+	_, err := Dial(filepath.Join(t.TempDir(), "missing.sock"))
+	assert.Error(t, err)
+}
+
+func TestClient(t *testing.T) {
+	cases := []struct {
+		name    string
+		resp    response
+		want    Result
+		wantErr error
+	}{
+		{
+			"allowed",
+			response{Allowed: true, Limit: 10, Remaining: 9, ResetsIn: time.Minute},
+			Result{Allowed: true, Limit: 10, Remaining: 9, ResetsIn: time.Minute},
+			nil,
+		},
+		{"denied", response{Limit: 10, ResetsIn: time.Minute}, Result{Limit: 10, ResetsIn: time.Minute}, nil},
+		{"sentinel", response{Error: rate.ErrEmptyIdentity.Error()}, Result{}, rate.ErrEmptyIdentity},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			c := NewClient(client)
+			defer c.Close()
+			go func() {
+				var req request
+				if err := readFrame(server, &req); err == nil {
+					_ = writeFrame(server, tc.resp)
+				}
+				server.Close()
+			}()
+
+			got, err := c.AllowN("resource", "action", "127.0.0.1", "token", 3)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	t.Run("ConnectionClosed", func(t *testing.T) {
+		client, server := net.Pipe()
+		server.Close()
+		c := NewClient(client)
+		defer c.Close()
+		_, err := c.Allow("resource", "action", "127.0.0.1", "")
+		assert.Error(t, err)
+	})
+}
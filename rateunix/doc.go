@@ -0,0 +1,26 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+// Package rateunix allows multiple processes on the same host, such as an
+// application and its sidecars, to share a single rate.Limiter via a Unix
+// domain socket, so that each process does not have its own quotas.
+//
+// A Server is run by the process that owns the Limiter, and each of the other
+// processes checks requests using a Client:
+//
+//	ln, err := net.Listen("unix", "/run/app/rate.sock")
+//	if err != nil {
+//		return err
+//	}
+//	srv := rateunix.NewServer(limiter)
+//	go srv.Serve(ln)
+//
+//	c, err := rateunix.Dial("/run/app/rate.sock")
+//	if err != nil {
+//		return err
+//	}
+//	res, err := c.Allow("resource", "action", ip, authToken)
+//
+// Requests and responses are sent as frames, each of which is a 4 byte
+// big-endian length followed by a JSON object of that length.
+package rateunix
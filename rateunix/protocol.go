@@ -0,0 +1,124 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rateunix
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hashicorp/go-rate"
+)
+
+// maxFrameSize is the maximum length of a frame's JSON object, so that a
+// misbehaving peer cannot cause a large allocation.
+const maxFrameSize = 64 << 10
+
+// request is the frame sent by a Client to check a request.
+type request struct {
+	Resource  string `json:"resource"`
+	Action    string `json:"action"`
+	IP        string `json:"ip,omitempty"`
+	AuthToken string `json:"auth_token,omitempty"`
+	N         uint64 `json:"n"`
+}
+
+// response is the frame sent by a Server for a request.
+type response struct {
+	Allowed   bool   `json:"allowed"`
+	Limit     uint64 `json:"limit,omitempty"`
+	Remaining uint64 `json:"remaining,omitempty"`
+	// ResetsIn and RetryIn are in nanoseconds.
+	ResetsIn time.Duration `json:"resets_in,omitempty"`
+	RetryIn  time.Duration `json:"retry_in,omitempty"`
+	// Error is the message of the error returned by the Limiter. It is one
+	// of the messages of the remoteErrors, or of rate.ErrLimiterFull, if the
+	// error wraps them.
+	Error string `json:"error,omitempty"`
+}
+
+// remoteErrors are the errors that are returned by a Client as is when they
+// are returned by the Server's Limiter.
+var remoteErrors = []error{
+	rate.ErrLimitPolicyNotFound,
+	rate.ErrLimitNotFound,
+	rate.ErrEmptyIdentity,
+	rate.ErrInvalidIPAddress,
+	rate.ErrInvalidParameter,
+	rate.ErrNotSupported,
+	rate.ErrStopped,
+}
+
+// errorMessage returns the message sent in a response for err.
+func errorMessage(err error) string {
+	var full *rate.ErrLimiterFull
+	if errors.As(err, &full) {
+		return full.Error()
+	}
+	for _, e := range remoteErrors {
+		if errors.Is(err, e) {
+			return e.Error()
+		}
+	}
+	return err.Error()
+}
+
+// remoteError returns the error for a message sent in a response.
+func remoteError(msg string) error {
+	for _, e := range remoteErrors {
+		if msg == e.Error() {
+			return e
+		}
+	}
+	return errors.New(msg)
+}
+
+// writeFrame writes v to w as a frame.
+func writeFrame(w io.Writer, v any) error {
+	const op = "rateunix.writeFrame"
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if len(b) > maxFrameSize {
+		return fmt.Errorf("%s: frame of %d bytes exceeds max of %d: %w", op, len(b), maxFrameSize, rate.ErrInvalidParameter)
+	}
+	buf := make([]byte, 4+len(b))
+	binary.BigEndian.PutUint32(buf, uint32(len(b)))
+	copy(buf[4:], b)
+	if _, err := w.Write(buf); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// readFrame reads a frame from r into v. If r is at EOF before the frame,
+// io.EOF is returned unwrapped.
+func readFrame(r io.Reader, v any) error {
+	const op = "rateunix.readFrame"
+
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		if err == io.EOF {
+			return err
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxFrameSize {
+		return fmt.Errorf("%s: frame of %d bytes exceeds max of %d: %w", op, n, maxFrameSize, rate.ErrInvalidParameter)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
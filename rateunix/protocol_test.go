@@ -0,0 +1,61 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rateunix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/hashicorp/go-rate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrame(t *testing.T) {
+	var buf bytes.Buffer
+	want := request{Resource: "resource", Action: "action", IP: "127.0.0.1", N: 2}
+	require.NoError(t, writeFrame(&buf, want))
+	require.NoError(t, writeFrame(&buf, want))
+
+	for i := 0; i < 2; i++ {
+		var got request
+		require.NoError(t, readFrame(&buf, &got))
+		assert.Equal(t, want, got)
+	}
+	var got request
+	assert.Equal(t, io.EOF, readFrame(&buf, &got))
+
+	t.Run("TooLarge", func(t *testing.T) {
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], maxFrameSize+1)
+		err := readFrame(bytes.NewReader(size[:]), &got)
+		assert.ErrorIs(t, err, rate.ErrInvalidParameter)
+
+		err = writeFrame(io.Discard, request{Resource: string(make([]byte, maxFrameSize))})
+		assert.ErrorIs(t, err, rate.ErrInvalidParameter)
+	})
+	t.Run("Truncated", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, writeFrame(&buf, want))
+		err := readFrame(bytes.NewReader(buf.Bytes()[:buf.Len()-1]), &got)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+}
+
+func TestRemoteError(t *testing.T) {
+	for _, e := range remoteErrors {
+		err := fmt.Errorf("op: %w", e)
+		assert.Equal(t, e, remoteError(errorMessage(err)))
+	}
+
+	full := fmt.Errorf("op: %w", &rate.ErrLimiterFull{})
+	assert.Equal(t, "limiter full", errorMessage(full))
+
+	err := remoteError(errorMessage(errors.New("unknown")))
+	assert.EqualError(t, err, "unknown")
+}
@@ -0,0 +1,154 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rateunix
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/hashicorp/go-rate"
+)
+
+// Limiter is used by a Server to check requests. It is implemented by
+// rate.Limiter and rate.NopLimiter.
+type Limiter interface {
+	AllowN(resource, action, ip, authToken string, n uint64) (bool, *rate.Quota, error)
+}
+
+// Server checks the requests sent by Clients using a Limiter.
+type Server struct {
+	limiter Limiter
+
+	mu       sync.Mutex
+	closed   bool
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+// NewServer creates a Server that checks requests using the limiter.
+func NewServer(limiter Limiter) *Server {
+	return &Server{
+		limiter: limiter,
+		conns:   make(map[net.Conn]struct{}),
+	}
+}
+
+// Serve accepts connections from Clients on ln, typically a listener for a
+// Unix domain socket, and handles the requests sent on each of them until
+// Close is called. Serve always closes ln. After Close is called, Serve
+// returns nil.
+func (s *Server) Serve(ln net.Listener) error {
+	const op = "rateunix.(Server).Serve"
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return nil
+	}
+	s.listener = ln
+	s.mu.Unlock()
+	defer ln.Close()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn handles the requests sent on conn until it is closed.
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+		s.wg.Done()
+	}()
+
+	r := bufio.NewReader(conn)
+	for {
+		var req request
+		if err := readFrame(r, &req); err != nil {
+			// The connection was closed, or the Client sent an invalid
+			// frame, after which the connection cannot be used.
+			return
+		}
+		if err := writeFrame(conn, s.check(req)); err != nil {
+			return
+		}
+	}
+}
+
+// check checks the request using the Server's Limiter.
+func (s *Server) check(req request) response {
+	allowed, quota, err := s.limiter.AllowN(req.Resource, req.Action, req.IP, req.AuthToken, req.N)
+	if err != nil {
+		resp := response{Error: errorMessage(err)}
+		var full *rate.ErrLimiterFull
+		if errors.As(err, &full) {
+			resp.RetryIn = full.RetryIn
+		}
+		return resp
+	}
+
+	resp := response{Allowed: allowed}
+	if quota != nil {
+		resp.Limit = quota.MaxRequests()
+		resp.Remaining = quota.Remaining()
+		resp.ResetsIn = quota.ResetsIn()
+	}
+	return resp
+}
+
+// Close stops the Server from accepting connections, closes its existing
+// connections, and waits for them to be handled.
+func (s *Server) Close() error {
+	const op = "rateunix.(Server).Close"
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
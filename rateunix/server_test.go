@@ -0,0 +1,143 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rateunix
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-rate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testLimiter(t *testing.T, maxSize int) *rate.Limiter {
+	t.Helper()
+	l, err := rate.NewLimiter(
+		[]rate.Limit{
+			&rate.Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         rate.LimitPerTotal,
+				MaxRequests: 100,
+				Period:      time.Minute,
+			},
+			&rate.Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         rate.LimitPerIPAddress,
+				MaxRequests: 2,
+				Period:      time.Minute,
+			},
+			&rate.Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      rate.LimitPerAuthToken,
+			},
+		},
+		maxSize,
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Shutdown() })
+	return l
+}
+
+// testServer starts a Server for the limiter, and returns the path of its
+// socket.
+func testServer(t *testing.T, l Limiter) (*Server, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rate.sock")
+	ln, err := net.Listen("unix", path)
+	require.NoError(t, err)
+
+	s := NewServer(l)
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ln) }()
+	t.Cleanup(func() {
+		require.NoError(t, s.Close())
+		require.NoError(t, <-done)
+	})
+	return s, path
+}
+
+func TestServer(t *testing.T) {
+	_, path := testServer(t, testLimiter(t, 10))
+
+	// Quotas are shared by each of the Clients.
+	c1, err := Dial(path)
+	require.NoError(t, err)
+	defer c1.Close()
+	c2, err := Dial(path)
+	require.NoError(t, err)
+	defer c2.Close()
+
+	res, err := c1.Allow("resource", "action", "127.0.0.1", "")
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, uint64(2), res.Limit)
+	assert.Equal(t, uint64(1), res.Remaining)
+	assert.InDelta(t, time.Minute, res.ResetsIn, float64(time.Second))
+
+	res, err = c2.Allow("resource", "action", "127.0.0.1", "")
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, uint64(0), res.Remaining)
+
+	res, err = c1.AllowN("resource", "action", "127.0.0.1", "", 1)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+
+	_, err = c2.Allow("unknown", "action", "127.0.0.1", "")
+	assert.ErrorIs(t, err, rate.ErrLimitPolicyNotFound)
+
+	t.Run("Unlimited", func(t *testing.T) {
+		_, path := testServer(t, rate.NopLimiter)
+		c, err := Dial(path)
+		require.NoError(t, err)
+		defer c.Close()
+
+		res, err := c.Allow("resource", "action", "127.0.0.1", "")
+		require.NoError(t, err)
+		assert.Equal(t, Result{Allowed: true}, res)
+	})
+}
+
+func TestServerLimiterFull(t *testing.T) {
+	_, path := testServer(t, testLimiter(t, 1))
+	c, err := Dial(path)
+	require.NoError(t, err)
+	defer c.Close()
+
+	_, err = c.Allow("resource", "action", "127.0.0.1", "")
+	require.NoError(t, err)
+
+	_, err = c.Allow("resource", "action", "127.0.0.2", "")
+	var full *rate.ErrLimiterFull
+	require.ErrorAs(t, err, &full)
+	assert.Greater(t, full.RetryIn, time.Duration(0))
+	assert.WithinDuration(t, time.Now().Add(full.RetryIn), full.RetryAt, time.Second)
+}
+
+func TestServerClose(t *testing.T) {
+	s, path := testServer(t, testLimiter(t, 10))
+	c, err := Dial(path)
+	require.NoError(t, err)
+	defer c.Close()
+	_, err = c.Allow("resource", "action", "127.0.0.1", "")
+	require.NoError(t, err)
+
+	require.NoError(t, s.Close())
+	// Closing the Server closes the connections of its Clients.
+	_, err = c.Allow("resource", "action", "127.0.0.1", "")
+	assert.Error(t, err)
+	_, err = Dial(path)
+	assert.Error(t, err)
+
+	// Serve returns immediately once the Server is closed.
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "rate.sock"))
+	require.NoError(t, err)
+	assert.NoError(t, s.Serve(ln))
+}
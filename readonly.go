@@ -0,0 +1,127 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"io"
+	"net/http"
+)
+
+// ReadOnlyLimiter is a read-only view of a Limiter, which can be used to
+// inspect its limits and quotas without consuming from or otherwise modifying
+// them. It is safe to provide to code that should not be able to affect the
+// requests that are allowed, such as an admin API. A ReadOnlyLimiter reflects
+// the current state of its Limiter, including limits that have been reloaded.
+type ReadOnlyLimiter struct {
+	l *Limiter
+}
+
+// ReadOnly returns a read-only view of the Limiter.
+func (l *Limiter) ReadOnly() *ReadOnlyLimiter {
+	return &ReadOnlyLimiter{l: l}
+}
+
+// Config returns the effective configuration of the Limiter.
+func (r *ReadOnlyLimiter) Config() Config {
+	return r.l.Config()
+}
+
+// PolicyHeaderValue returns the value of the rate limit policy HTTP header for
+// the provided resource and action, as with Limiter.PolicyHeaderValue.
+func (r *ReadOnlyLimiter) PolicyHeaderValue(resource, action string) (string, bool) {
+	return r.l.PolicyHeaderValue(resource, action)
+}
+
+// Policies returns the Limiter's current limit policies, as with
+// Limiter.Policies.
+func (r *ReadOnlyLimiter) Policies() []Policy {
+	return r.l.Policies()
+}
+
+// Quotas returns copies of the quotas that a request for the resource and
+// action with the IP address and auth token would use, by their LimitPer.
+// Quotas are not created, so a LimitPer is not included if its quota does not
+// exist or has expired, or if its limit is Unlimited. Since copies are
+// returned, consuming from them does not affect the Limiter. When the Limiter
+// uses a Store, only the quotas stored in the Limiter are returned.
+func (r *ReadOnlyLimiter) Quotas(resource, action, ip, authToken string) (map[LimitPer]*Quota, error) {
+	const op = "rate.(ReadOnlyLimiter).Quotas"
+
+	l := r.l
+	policy, err := l.policyFor(resource, action)
+	if err != nil {
+		return nil, wrapOp(op, err)
+	}
+	quotas := make(map[LimitPer]*Quota, len(requiredLimitPer))
+	if policy == nil {
+		return quotas, nil
+	}
+	ip, authToken, err = l.normalizeIdentity(ip, authToken)
+	if err != nil {
+		return nil, wrapOp(op, err)
+	}
+	keys := map[LimitPer]string{
+		LimitPerTotal:     string(LimitPerTotal),
+		LimitPerIPAddress: ip,
+		LimitPerAuthToken: authToken,
+	}
+	skip := policy.fallbackSkips(keys)
+
+	for per, id := range keys {
+		if skip[per] {
+			continue
+		}
+		ll, ok := policy.m[per].(*Limited)
+		if !ok {
+			continue
+		}
+		if id == "" && per != LimitPerTotal && ll.EmptyIdentity != EmptyIdentityShared {
+			continue
+		}
+
+		var q *Quota
+		switch {
+		case l.usesPolicyTotal(ll):
+			q = policy.total.Load()
+		default:
+			q = l.quotaFetcher.lookup(id, ll)
+		}
+		if q == nil || q.Expired() {
+			continue
+		}
+		quotas[per] = q.clone()
+	}
+	return quotas, nil
+}
+
+// QuotaFor returns the current state of the quota for the resource, action,
+// and LimitPer with the provided id, as with Limiter.QuotaFor.
+func (r *ReadOnlyLimiter) QuotaFor(resource, action string, per LimitPer, id string) (QuotaSnapshot, bool) {
+	return r.l.QuotaFor(resource, action, per, id)
+}
+
+// Snapshot writes the state of each of the Limiter's quotas that has not
+// expired to w, as with Limiter.Snapshot.
+func (r *ReadOnlyLimiter) Snapshot(w io.Writer) error {
+	return r.l.Snapshot(w)
+}
+
+// Simulate replays the provided trace against a candidate set of limits, or
+// the Limiter's limits if limits is empty, as with Limiter.Simulate, which
+// does not read or modify the Limiter's quotas.
+func (r *ReadOnlyLimiter) Simulate(limits []Limit, trace []Request) (SimulationReport, error) {
+	return r.l.Simulate(limits, trace)
+}
+
+// MetricsHandler returns an http.Handler that exposes the Limiter's metrics,
+// as with Limiter.MetricsHandler.
+func (r *ReadOnlyLimiter) MetricsHandler() http.Handler {
+	return r.l.MetricsHandler()
+}
+
+// DiscoveryHandler returns an http.Handler that serves the Limiter's
+// DiscoveryDocument, as with Limiter.DiscoveryHandler.
+func (r *ReadOnlyLimiter) DiscoveryHandler() http.Handler {
+	return r.l.DiscoveryHandler()
+}
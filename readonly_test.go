@@ -0,0 +1,128 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadOnlyLimiter(t *testing.T) {
+	limits := []Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			MaxRequests: 10,
+			Period:      time.Minute,
+		},
+		&Unlimited{
+			Resource: "resource",
+			Action:   "action",
+			Per:      LimitPerAuthToken,
+		},
+	}
+
+	for _, policyTotals := range []bool{false, true} {
+		t.Run(fmt.Sprintf("policy-totals-%t", policyTotals), func(t *testing.T) {
+			l, err := NewLimiter(limits, 10, WithPolicyTotalQuotas(policyTotals))
+			require.NoError(t, err)
+			defer l.Shutdown()
+			r := l.ReadOnly()
+
+			// Quotas are not created.
+			quotas, err := r.Quotas("resource", "action", "127.0.0.1", "token")
+			require.NoError(t, err)
+			assert.Empty(t, quotas)
+			quotas, err = r.Quotas("resource", "action", "127.0.0.1", "token")
+			require.NoError(t, err)
+			assert.Empty(t, quotas)
+
+			for i := 0; i < 3; i++ {
+				allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "token")
+				require.NoError(t, err)
+				require.True(t, allowed)
+			}
+
+			quotas, err = r.Quotas("resource", "action", "127.0.0.1", "token")
+			require.NoError(t, err)
+			require.Len(t, quotas, 2)
+			assert.Equal(t, uint64(97), quotas[LimitPerTotal].Remaining())
+			assert.Equal(t, uint64(7), quotas[LimitPerIPAddress].Remaining())
+
+			// Consuming from the returned quotas does not affect the Limiter.
+			quotas[LimitPerIPAddress].Consume()
+			quotas, err = r.Quotas("resource", "action", "127.0.0.1", "token")
+			require.NoError(t, err)
+			assert.Equal(t, uint64(7), quotas[LimitPerIPAddress].Remaining())
+
+			quotas, err = r.Quotas("resource", "action", "10.0.0.1", "token")
+			require.NoError(t, err)
+			assert.Len(t, quotas, 1)
+			assert.Contains(t, quotas, LimitPerTotal)
+
+			_, err = r.Quotas("unknown", "action", "127.0.0.1", "token")
+			assert.ErrorIs(t, err, ErrLimitPolicyNotFound)
+		})
+	}
+
+	t.Run("views", func(t *testing.T) {
+		l, err := NewLimiter(limits, 10)
+		require.NoError(t, err)
+		defer l.Shutdown()
+		r := l.ReadOnly()
+
+		_, _, err = l.Allow("resource", "action", "127.0.0.1", "token")
+		require.NoError(t, err)
+
+		assert.Equal(t, l.Config(), r.Config())
+
+		want, ok := l.PolicyHeaderValue("resource", "action")
+		require.True(t, ok)
+		got, ok := r.PolicyHeaderValue("resource", "action")
+		assert.True(t, ok)
+		assert.Equal(t, want, got)
+
+		var buf bytes.Buffer
+		require.NoError(t, r.Snapshot(&buf))
+		assert.Contains(t, buf.String(), `"id":"127.0.0.1"`)
+
+		report, err := r.Simulate(nil, []Request{{Resource: "resource", Action: "action", IP: "127.0.0.1", Time: time.Now()}})
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), report.Allowed)
+
+		rec := httptest.NewRecorder()
+		r.MetricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		assert.Contains(t, rec.Body.String(), `rate_limiter_requests_total{result="allowed"} 1`)
+
+		// The view reflects reloaded limits.
+		require.NoError(t, l.Reload([]Limit{
+			&Limited{
+				Resource:    "other",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 100,
+				Period:      time.Minute,
+			},
+		}))
+		_, err = r.Quotas("resource", "action", "127.0.0.1", "token")
+		assert.ErrorIs(t, err, ErrLimitPolicyNotFound)
+		_, ok = r.PolicyHeaderValue("other", "action")
+		assert.True(t, ok)
+	})
+}
@@ -0,0 +1,78 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Rollout gradually enforces the limits of a limit policy, so that a new,
+// stricter limit can be introduced without immediately denying every request
+// of clients that exceed it. Requests that exceed the policy's limits are
+// denied with a probability of the rollout's current percentage, and the
+// rest are allowed and reported to the Limiter's UnenforcedDenialHook.
+type Rollout struct {
+	// Percent is the percentage of over-limit requests that are denied at
+	// Start. It must be between 0 and 100.
+	Percent float64
+	// Start is when the rollout starts. If it is zero, the rollout starts
+	// when the Limiter is created. Before Start, Percent is used.
+	Start time.Time
+	// Ramp is how long after Start it takes for the percentage to increase
+	// linearly from Percent to 100. If it is zero, the percentage remains
+	// Percent.
+	Ramp time.Duration
+}
+
+func (r Rollout) validate() error {
+	switch {
+	case r.Percent < 0 || r.Percent > 100:
+		return fmt.Errorf("rollout percent must be between 0 and 100: %w", ErrInvalidParameter)
+	case r.Ramp < 0:
+		return fmt.Errorf("rollout ramp must not be negative: %w", ErrInvalidParameter)
+	}
+	return nil
+}
+
+// percentAt returns the percentage of over-limit requests that are denied at
+// now.
+func (r Rollout) percentAt(now time.Time) float64 {
+	elapsed := now.Sub(r.Start)
+	switch {
+	case r.Ramp == 0, elapsed <= 0:
+		return r.Percent
+	case elapsed >= r.Ramp:
+		return 100
+	}
+	return r.Percent + (100-r.Percent)*float64(elapsed)/float64(r.Ramp)
+}
+
+// enforcePercent reports whether an over-limit request should be denied,
+// given the percentage of over-limit requests that are denied.
+func enforcePercent(p float64) bool {
+	return p >= 100 || rand.Float64()*100 < p
+}
+
+// UnenforcedDenial describes a request that exceeded a quota, but was allowed
+// since the limit policy's Rollout did not enforce its limits for the
+// request, or since the Limiter was in shadow mode.
+type UnenforcedDenial struct {
+	Resource string
+	Action   string
+	Per      LimitPer
+	// ID is the IP address or auth token for LimitPerIPAddress and
+	// LimitPerAuthToken, and "total" for LimitPerTotal.
+	ID string
+	// Percent is the Rollout's percentage of over-limit requests that were
+	// denied when the request was checked. It is zero in shadow mode.
+	Percent float64
+}
+
+// UnenforcedDenialHook is called for each request that was allowed by a
+// limit policy's Rollout or in shadow mode. It is called synchronously by Allow, without
+// holding any of the Limiter's locks, so an UnenforcedDenialHook that blocks
+// will delay the request.
+type UnenforcedDenialHook func(UnenforcedDenial)
@@ -0,0 +1,155 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRolloutPercentAt(t *testing.T) {
+	start := time.Unix(1000, 0)
+	cases := []struct {
+		name    string
+		rollout Rollout
+		now     time.Time
+		expect  float64
+	}{
+		{"BeforeStart", Rollout{Percent: 10, Start: start, Ramp: time.Hour}, start.Add(-time.Minute), 10},
+		{"Start", Rollout{Percent: 10, Start: start, Ramp: time.Hour}, start, 10},
+		{"Ramping", Rollout{Percent: 10, Start: start, Ramp: time.Hour}, start.Add(30 * time.Minute), 55},
+		{"Ramped", Rollout{Percent: 10, Start: start, Ramp: time.Hour}, start.Add(time.Hour), 100},
+		{"AfterRamp", Rollout{Percent: 10, Start: start, Ramp: time.Hour}, start.Add(2 * time.Hour), 100},
+		{"NoRamp", Rollout{Percent: 25, Start: start}, start.Add(24 * time.Hour), 25},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.InDelta(t, tc.expect, tc.rollout.percentAt(tc.now), 1e-9)
+		})
+	}
+}
+
+func TestRolloutValidate(t *testing.T) {
+	assert.NoError(t, Rollout{Percent: 0}.validate())
+	assert.NoError(t, Rollout{Percent: 100, Ramp: time.Hour}.validate())
+	assert.ErrorIs(t, Rollout{Percent: -1}.validate(), ErrInvalidParameter)
+	assert.ErrorIs(t, Rollout{Percent: 101}.validate(), ErrInvalidParameter)
+	assert.ErrorIs(t, Rollout{Ramp: -time.Second}.validate(), ErrInvalidParameter)
+}
+
+func TestLimiterRollout(t *testing.T) {
+	limits := []Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			MaxRequests: 1,
+			Period:      time.Minute,
+		},
+		&Unlimited{
+			Resource: "resource",
+			Action:   "action",
+			Per:      LimitPerAuthToken,
+		},
+	}
+
+	t.Run("Ramp", func(t *testing.T) {
+		c := newFakeClock()
+		var denials []UnenforcedDenial
+		l, err := NewLimiter(limits, 10,
+			WithClock(c),
+			WithDenialCache(time.Second, 10),
+			WithRollout("resource", "action", Rollout{Percent: 0, Ramp: time.Hour}),
+			WithUnenforcedDenialHook(func(d UnenforcedDenial) { denials = append(denials, d) }),
+		)
+		require.NoError(t, err)
+		defer l.Shutdown()
+
+		allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "")
+		require.NoError(t, err)
+		require.True(t, allowed)
+
+		// Over-limit requests are allowed, and reported, while no requests
+		// are denied.
+		for i := 0; i < 3; i++ {
+			allowed, _, err = l.Allow("resource", "action", "127.0.0.1", "")
+			require.NoError(t, err)
+			assert.True(t, allowed)
+		}
+		require.Len(t, denials, 3)
+		assert.Equal(t, UnenforcedDenial{
+			Resource: "resource",
+			Action:   "action",
+			Per:      LimitPerIPAddress,
+			ID:       "127.0.0.1",
+			Percent:  0,
+		}, denials[0])
+
+		// The over-limit quota is not consumed, but the others are.
+		quotas, err := l.ReadOnly().Quotas("resource", "action", "127.0.0.1", "")
+		require.NoError(t, err)
+		assert.Equal(t, uint64(0), quotas[LimitPerIPAddress].Remaining())
+		assert.Equal(t, uint64(96), quotas[LimitPerTotal].Remaining())
+
+		// Once the rollout is complete, over-limit requests are denied.
+		c.Advance(time.Hour)
+		allowed, _, err = l.Allow("resource", "action", "127.0.0.1", "")
+		require.NoError(t, err)
+		require.True(t, allowed)
+		allowed, _, err = l.Allow("resource", "action", "127.0.0.1", "")
+		require.NoError(t, err)
+		assert.False(t, allowed)
+		assert.Len(t, denials, 3)
+	})
+
+	t.Run("OtherPolicies", func(t *testing.T) {
+		l, err := NewLimiter(limits, 10, WithRollout("other", "action", Rollout{Percent: 0}))
+		require.NoError(t, err)
+		defer l.Shutdown()
+
+		allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "")
+		require.NoError(t, err)
+		require.True(t, allowed)
+		allowed, _, err = l.Allow("resource", "action", "127.0.0.1", "")
+		require.NoError(t, err)
+		assert.False(t, allowed)
+	})
+
+	t.Run("Store", func(t *testing.T) {
+		c := newFakeClock()
+		var denials []UnenforcedDenial
+		l, err := NewLimiter(limits, 10,
+			WithClock(c),
+			WithStore(newTestStore(c)),
+			WithRollout("resource", "action", Rollout{Percent: 0}),
+			WithUnenforcedDenialHook(func(d UnenforcedDenial) { denials = append(denials, d) }),
+		)
+		require.NoError(t, err)
+		defer l.Shutdown()
+
+		for i := 0; i < 2; i++ {
+			allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "")
+			require.NoError(t, err)
+			assert.True(t, allowed)
+		}
+		require.Len(t, denials, 1)
+		assert.Equal(t, LimitPerIPAddress, denials[0].Per)
+		assert.Equal(t, "127.0.0.1", denials[0].ID)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := NewLimiter(limits, 10, WithRollout("resource", "action", Rollout{Percent: 150}))
+		assert.ErrorIs(t, err, ErrInvalidParameter)
+	})
+}
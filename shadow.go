@@ -0,0 +1,20 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+// SetShadowMode sets whether the Limiter is in shadow mode. In shadow mode,
+// requests that exceed a quota are allowed rather than denied, as if every
+// limit policy had a Rollout with a Percent of zero, and are reported to the
+// Limiter's UnenforcedDenialHook. This can be used to observe the effect of
+// the limits without enforcing them, such as while investigating requests
+// that were denied in error. Requests still consume from the quotas that they
+// do not exceed, and cached denials are not used.
+func (l *Limiter) SetShadowMode(b bool) {
+	l.shadow.Store(b)
+}
+
+// ShadowMode reports whether the Limiter is in shadow mode.
+func (l *Limiter) ShadowMode() bool {
+	return l.shadow.Load()
+}
@@ -0,0 +1,68 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterShadowMode(t *testing.T) {
+	limits := []Limit{
+		&Limited{Resource: "resource", Action: "action", Per: LimitPerTotal, MaxRequests: 100, Period: time.Minute},
+		&Limited{Resource: "resource", Action: "action", Per: LimitPerIPAddress, MaxRequests: 1, Period: time.Minute},
+		&Unlimited{Resource: "resource", Action: "action", Per: LimitPerAuthToken},
+	}
+
+	c := newFakeClock()
+	var denials []UnenforcedDenial
+	l, err := NewLimiter(limits, 10,
+		WithClock(c),
+		WithDenialCache(time.Second, 10),
+		WithUnenforcedDenialHook(func(d UnenforcedDenial) { denials = append(denials, d) }),
+	)
+	require.NoError(t, err)
+	defer l.Shutdown()
+	assert.False(t, l.ShadowMode())
+
+	allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "")
+	require.NoError(t, err)
+	require.True(t, allowed)
+	// The denial is cached.
+	allowed, _, err = l.Allow("resource", "action", "127.0.0.1", "")
+	require.NoError(t, err)
+	require.False(t, allowed)
+
+	// In shadow mode, over-limit requests are allowed and reported, despite
+	// the cached denial.
+	l.SetShadowMode(true)
+	assert.True(t, l.ShadowMode())
+	for i := 0; i < 2; i++ {
+		allowed, _, err = l.Allow("resource", "action", "127.0.0.1", "")
+		require.NoError(t, err)
+		assert.True(t, allowed)
+	}
+	require.Len(t, denials, 2)
+	assert.Equal(t, UnenforcedDenial{
+		Resource: "resource",
+		Action:   "action",
+		Per:      LimitPerIPAddress,
+		ID:       "127.0.0.1",
+		Percent:  0,
+	}, denials[0])
+
+	// The quotas that were not exceeded are still consumed.
+	quotas, err := l.ReadOnly().Quotas("resource", "action", "127.0.0.1", "")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(97), quotas[LimitPerTotal].Remaining())
+
+	l.SetShadowMode(false)
+	allowed, _, err = l.Allow("resource", "action", "127.0.0.1", "")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Len(t, denials, 2)
+}
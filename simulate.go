@@ -0,0 +1,214 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"fmt"
+	"time"
+)
+
+// Request is a single recorded request that can be replayed via
+// Limiter.Simulate.
+type Request struct {
+	Resource  string
+	Action    string
+	IP        string
+	AuthToken string
+
+	// Time is when the request was made. Requests in a trace should be in
+	// chronological order.
+	Time time.Time
+}
+
+// PolicySimulation reports the outcome of a simulation for a single
+// resource and action.
+type PolicySimulation struct {
+	Resource string
+	Action   string
+
+	Allowed uint64
+	Denied  uint64
+
+	// DeniedPer is the number of denied requests, grouped by the LimitPer of
+	// the limit that was exhausted.
+	DeniedPer map[LimitPer]uint64
+}
+
+// SimulationReport is the result of replaying a trace via Limiter.Simulate.
+type SimulationReport struct {
+	Total   uint64
+	Allowed uint64
+	Denied  uint64
+
+	// Invalid is the number of requests that were denied because their IP
+	// address is invalid, when the Limiter was created with
+	// WithStrictIPAddress. They are included in Denied.
+	Invalid uint64
+
+	// NotFound is the number of requests in the trace for which there was no
+	// corresponding limit policy. If the Limiter was created with
+	// UnknownPolicyAllow or UnknownPolicyUseDefault, these requests are also
+	// reported as allowed, or as limited by the default policy. Otherwise,
+	// they are included in Denied.
+	NotFound uint64
+
+	// Policies contains the results for each resource and action that was
+	// present in the trace, keyed by "resource:action".
+	Policies map[string]*PolicySimulation
+}
+
+// simulatedQuota tracks usage for a single key during a simulation.
+type simulatedQuota struct {
+	used      uint64
+	expiresAt time.Time
+}
+
+// Simulate replays the provided trace against a candidate set of limits and
+// reports how many requests would have been allowed or denied, so that the
+// effect of changing the Limiter's limits can be evaluated before they are
+// reloaded. The limits are resolved using the Limiter's rate classes and
+// template variables. If limits is empty, the Limiter's current limits are
+// used. An error is returned if the limits are invalid.
+//
+// The requests are evaluated using the Limiter's identity normalization
+// (WithStrictIPAddress, WithAuthTokenNormalizer, and WithCohorts), its
+// UnknownPolicyBehavior and WithDefaultPolicy, and each limit's Fallback,
+// EmptyIdentity, GraceRequests, and Anchor. Requests that would have been
+// allowed using grace requests are reported as allowed. The trace is
+// evaluated using the Time of each Request rather than the current time, and
+// the Limiter's stored quotas are not read or modified.
+//
+// The following are not simulated, so their effect is not reflected in the
+// report:
+//   - The max size of the Limiter, so requests that would have resulted in an
+//     ErrLimiterFull are reported as allowed.
+//   - The Jitter of each limit, so each quota's window is its Period.
+//   - WithWarmUp, so new identities are allowed their full limit.
+//   - WithRiskMultiplier and WithGlobalMultiplierHook, so each limit's
+//     MaxRequests is used as is.
+//   - WithPolicyTotalQuotas, WithDenialCache, WithStore, and WithStorePer,
+//     which only change how quotas are stored.
+//   - WithRollout and SetShadowMode, so every limit is enforced.
+func (l *Limiter) Simulate(limits []Limit, trace []Request) (SimulationReport, error) {
+	const op = "rate.(Limiter).Simulate"
+
+	policies := l.policies.Load()
+	if len(limits) > 0 {
+		var err error
+		if policies, err = l.newPolicies(limits, l.classes); err != nil {
+			return SimulationReport{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	report := SimulationReport{
+		Policies: make(map[string]*PolicySimulation),
+	}
+	quotas := make(map[string]*simulatedQuota)
+
+	for _, r := range trace {
+		report.Total++
+
+		// The identity is normalized before the policy is looked up, as it
+		// is by Allow, so that invalid IP addresses are always denied.
+		ip, authToken, err := l.normalizeIdentity(r.IP, r.AuthToken)
+		if err != nil {
+			report.Invalid++
+			report.Denied++
+			continue
+		}
+
+		policy, ok := policies.lookup(r.Resource, r.Action)
+		if !ok {
+			report.NotFound++
+			if l.unknownPolicy == UnknownPolicyAllow {
+				report.Allowed++
+				continue
+			}
+			if policy, ok = l.unknownPolicyDefault(policies); !ok {
+				// The request is denied with ErrLimitPolicyNotFound.
+				report.Denied++
+				continue
+			}
+		}
+
+		polKey := join(r.Resource, r.Action)
+		ps, ok := report.Policies[polKey]
+		if !ok {
+			ps = &PolicySimulation{
+				Resource:  r.Resource,
+				Action:    r.Action,
+				DeniedPer: make(map[LimitPer]uint64),
+			}
+			report.Policies[polKey] = ps
+		}
+
+		keys := map[LimitPer]string{
+			LimitPerTotal:     string(LimitPerTotal),
+			LimitPerIPAddress: ip,
+			LimitPerAuthToken: authToken,
+		}
+
+		skip := policy.fallbackSkips(keys)
+
+		toConsume := make([]*simulatedQuota, 0, len(requiredLimitPer))
+		denied := false
+		for _, per := range requiredLimitPer {
+			if skip[per] {
+				continue
+			}
+			limit, err := policy.limit(per)
+			if err != nil {
+				continue
+			}
+			ll, ok := limit.(*Limited)
+			if !ok {
+				continue
+			}
+
+			if keys[per] == "" && per != LimitPerTotal {
+				switch ll.EmptyIdentity {
+				case EmptyIdentitySkip:
+					continue
+				case EmptyIdentityDeny:
+					ps.DeniedPer[per]++
+					denied = true
+				}
+				if denied {
+					break
+				}
+			}
+
+			key := quotaKey(ll, keys[per])
+			q, ok := quotas[key]
+			switch {
+			case !ok:
+				q = &simulatedQuota{expiresAt: ll.expiration(r.Time, 0)}
+				quotas[key] = q
+			case r.Time.After(q.expiresAt):
+				q.used = 0
+				q.expiresAt = ll.expiration(r.Time, 0)
+			}
+
+			if q.used >= ll.MaxRequests+ll.GraceRequests {
+				ps.DeniedPer[per]++
+				denied = true
+				break
+			}
+			toConsume = append(toConsume, q)
+		}
+
+		if denied {
+			ps.Denied++
+			report.Denied++
+			continue
+		}
+		for _, q := range toConsume {
+			q.used++
+		}
+		ps.Allowed++
+		report.Allowed++
+	}
+
+	return report, nil
+}
@@ -0,0 +1,124 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterSimulate(t *testing.T) {
+	l, err := NewLimiter(
+		[]Limit{
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 100,
+				Period:      time.Minute,
+			},
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerIPAddress,
+				MaxRequests: 2,
+				Period:      time.Minute,
+			},
+			&Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      LimitPerAuthToken,
+			},
+		},
+		10,
+	)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	start := time.Now()
+	trace := []Request{
+		{Resource: "resource", Action: "action", IP: "127.0.0.1", Time: start},
+		{Resource: "resource", Action: "action", IP: "127.0.0.1", Time: start.Add(time.Second)},
+		{Resource: "resource", Action: "action", IP: "127.0.0.1", Time: start.Add(2 * time.Second)},
+		{Resource: "resource", Action: "action", IP: "127.0.0.2", Time: start.Add(3 * time.Second)},
+		// quota for 127.0.0.1 has reset
+		{Resource: "resource", Action: "action", IP: "127.0.0.1", Time: start.Add(2 * time.Minute)},
+		{Resource: "missing", Action: "action", IP: "127.0.0.1", Time: start.Add(2 * time.Minute)},
+	}
+
+	got, err := l.Simulate(nil, trace)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(6), got.Total)
+	assert.Equal(t, uint64(4), got.Allowed)
+	// The request without a policy is denied, since the Limiter uses
+	// UnknownPolicyDeny.
+	assert.Equal(t, uint64(2), got.Denied)
+	assert.Equal(t, uint64(1), got.NotFound)
+	assert.Equal(t, got.Total, got.Allowed+got.Denied)
+	require.Contains(t, got.Policies, "resource:action")
+	ps := got.Policies["resource:action"]
+	assert.Equal(t, uint64(4), ps.Allowed)
+	assert.Equal(t, uint64(1), ps.Denied)
+	assert.Equal(t, map[LimitPer]uint64{LimitPerIPAddress: 1}, ps.DeniedPer)
+
+	// The live state of the limiter should not be modified.
+	s := l.quotaFetcher.(*expirableStore)
+	s.mu.Lock()
+	assert.Zero(t, s.items.len())
+	s.mu.Unlock()
+}
+
+func TestLimiterSimulateCandidateLimits(t *testing.T) {
+	limit := func(maxRequests uint64) []Limit {
+		return []Limit{
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerIPAddress,
+				MaxRequests: maxRequests,
+				Period:      time.Minute,
+			},
+		}
+	}
+	l, err := NewLimiter(limit(1), 10, WithStrictIPAddress(true))
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	start := time.Now()
+	trace := []Request{
+		{Resource: "resource", Action: "action", IP: "127.0.0.1", Time: start},
+		{Resource: "resource", Action: "action", IP: "127.0.0.1", Time: start.Add(time.Second)},
+		{Resource: "resource", Action: "action", IP: "::ffff:127.0.0.1", Time: start.Add(2 * time.Second)},
+		{Resource: "resource", Action: "action", IP: "not-an-ip", Time: start.Add(3 * time.Second)},
+	}
+
+	got, err := l.Simulate(nil, trace)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), got.Allowed)
+	assert.Equal(t, uint64(3), got.Denied)
+	assert.Equal(t, uint64(1), got.Invalid)
+
+	// The candidate limits are used instead of the Limiter's limits, and
+	// the IP addresses are normalized and validated as they are by Allow.
+	got, err = l.Simulate(limit(3), trace)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), got.Allowed)
+	assert.Equal(t, uint64(1), got.Denied)
+	assert.Equal(t, uint64(1), got.Invalid)
+	assert.Equal(t, uint64(3), got.Policies["resource:action"].Allowed)
+
+	// The Limiter's limits are not changed.
+	allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	allowed, _, err = l.Allow("resource", "action", "127.0.0.1", "")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	_, err = l.Simulate([]Limit{&Limited{Resource: "resource", Action: "action", Per: LimitPerIPAddress}}, trace)
+	assert.ErrorIs(t, err, ErrInvalidLimit)
+}
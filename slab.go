@@ -0,0 +1,50 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+// entrySlab allocates a store's entries from preallocated arrays of entries,
+// rather than allocating each entry individually, and reuses entries that are
+// removed from the store via a free list. Since each array is a single
+// allocation, this greatly reduces the number of objects that the garbage
+// collector must track for a large store. An array is never released, so the
+// memory used for entries does not shrink once the store has grown.
+//
+// An entrySlab is not safe for concurrent use, and is guarded by the store's
+// lock.
+type entrySlab struct {
+	// size is the number of entries in each array.
+	size int
+	// fresh are the entries of the most recently allocated array that have
+	// not been used.
+	fresh []entry
+	// free are the entries that have been removed from the store.
+	free []*entry
+}
+
+// newEntrySlab returns an entrySlab that allocates arrays of size entries.
+func newEntrySlab(size int) *entrySlab {
+	return &entrySlab{size: size}
+}
+
+// get returns an entry, and reports whether it was reused from the free list.
+func (s *entrySlab) get() (*entry, bool) {
+	if n := len(s.free); n > 0 {
+		e := s.free[n-1]
+		s.free[n-1] = nil
+		s.free = s.free[:n-1]
+		return e, true
+	}
+	if len(s.fresh) == 0 {
+		s.fresh = make([]entry, s.size)
+	}
+	e := &s.fresh[0]
+	s.fresh = s.fresh[1:]
+	return e, false
+}
+
+// put adds the entry to the free list, so that it is returned by a later
+// call to get. The entry must already have been cleared.
+func (s *entrySlab) put(e *entry) {
+	s.free = append(s.free, e)
+}
@@ -0,0 +1,72 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntrySlab(t *testing.T) {
+	s := newEntrySlab(2)
+
+	a, reused := s.get()
+	assert.False(t, reused)
+	b, reused := s.get()
+	assert.False(t, reused)
+	assert.NotSame(t, a, b)
+	assert.Empty(t, s.fresh)
+
+	// A new array is allocated once the previous one is used.
+	c, reused := s.get()
+	assert.False(t, reused)
+	assert.Len(t, s.fresh, 1)
+
+	s.put(b)
+	got, reused := s.get()
+	assert.True(t, reused)
+	assert.Same(t, b, got)
+	assert.Empty(t, s.free)
+
+	got, reused = s.get()
+	assert.False(t, reused)
+	assert.NotSame(t, c, got)
+}
+
+func Test_storeEntrySlab(t *testing.T) {
+	hits, misses := &testCounter{}, &testCounter{}
+	c := newFakeClock()
+	s, err := newExpirableStore(10, time.Minute,
+		WithEntrySlabSize(4),
+		WithClock(c),
+		WithQuotaPoolHitMetric(hits),
+		WithQuotaPoolMissMetric(misses),
+	)
+	require.NoError(t, err)
+	defer s.shutdown()
+
+	limit := &Limited{Resource: "resource", Action: "action", Per: LimitPerIPAddress, MaxRequests: 10, Period: time.Minute}
+	q, err := s.fetch(context.Background(), "127.0.0.1", limit)
+	require.NoError(t, err)
+	q.Consume()
+	assert.Equal(t, float64(1), misses.v)
+
+	// The removed entry is reused, but its Quota is not.
+	s.mu.Lock()
+	s.removeEntry(s.items.get(quotaKey(limit, "127.0.0.1")))
+	s.mu.Unlock()
+	got, err := s.fetch(context.Background(), "127.0.0.2", limit)
+	require.NoError(t, err)
+	assert.NotSame(t, q, got)
+	assert.Equal(t, uint64(10), got.Remaining())
+	assert.Equal(t, uint64(9), q.Remaining())
+	assert.Equal(t, float64(1), hits.v)
+
+	_, err = newExpirableStore(10, time.Minute, WithEntrySlabSize(-1))
+	assert.ErrorIs(t, err, ErrInvalidParameter)
+}
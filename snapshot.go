@@ -0,0 +1,186 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// SnapshotVersion is the version of the snapshot format written by
+// Limiter.Snapshot.
+//
+// A snapshot is a single JSON object:
+//
+//	{
+//	  "version": 1,
+//	  "created_at": "2023-01-01T00:00:00Z",
+//	  "quotas": [
+//	    {
+//	      "resource": "resource",
+//	      "action": "action",
+//	      "per": "ip-address",
+//	      "id": "127.0.0.1",
+//	      "used": 5,
+//	      "expires_at": "2023-01-01T00:01:00Z",
+//	      "jitter": 0
+//	    }
+//	  ]
+//	}
+//
+// The quotas are sorted by resource, action, per, and id, so that the same
+// state always results in the same snapshot. The jitter is in nanoseconds.
+//
+// The following rules allow a snapshot written by one release to be restored
+// by the next:
+//   - New fields may be added without changing the version. Restore ignores
+//     fields that it does not know about.
+//   - Fields are never removed or given a new meaning without incrementing
+//     the version.
+//   - Restore supports every version up to and including SnapshotVersion, and
+//     returns ErrUnsupportedSnapshotVersion for newer versions.
+const SnapshotVersion = 1
+
+// Snapshot is the state of a Limiter's quotas written by Limiter.Snapshot.
+type Snapshot struct {
+	Version   int             `json:"version"`
+	CreatedAt time.Time       `json:"created_at"`
+	Quotas    []SnapshotQuota `json:"quotas"`
+}
+
+// SnapshotQuota is the state of a single quota in a Snapshot.
+type SnapshotQuota struct {
+	Resource string   `json:"resource"`
+	Action   string   `json:"action"`
+	Per      LimitPer `json:"per"`
+	// ID is the IP address or auth token for LimitPerIPAddress and
+	// LimitPerAuthToken, and "total" for LimitPerTotal.
+	ID        string        `json:"id"`
+	Used      uint64        `json:"used"`
+	ExpiresAt time.Time     `json:"expires_at"`
+	Jitter    time.Duration `json:"jitter"`
+}
+
+// Snapshot writes the state of each of the Limiter's quotas that has not
+// expired to w, using the format described by SnapshotVersion. The snapshot
+// can be restored using Restore, such as after a restart.
+//
+// Quotas that are modified while the snapshot is being taken may or may not
+// reflect those modifications.
+func (l *Limiter) Snapshot(w io.Writer) error {
+	const op = "rate.(Limiter).Snapshot"
+
+	snap := Snapshot{
+		Version:   SnapshotVersion,
+		CreatedAt: l.clock.Now().UTC(),
+		Quotas:    l.snapshotQuotas(),
+	}
+	if err := json.NewEncoder(w).Encode(snap); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// snapshotQuotas returns the state of each of the Limiter's quotas that has
+// not expired, sorted by resource, action, per, and id.
+func (l *Limiter) snapshotQuotas() []SnapshotQuota {
+	quotas := l.quotaFetcher.snapshot()
+	if l.policyTotals {
+		for _, p := range l.policies.Load().m {
+			if q := p.total.Load(); q != nil && !q.Expired() {
+				quotas = append(quotas, q.snapshot(string(LimitPerTotal)))
+			}
+		}
+	}
+	for i := range quotas {
+		quotas[i].ExpiresAt = quotas[i].ExpiresAt.UTC()
+	}
+	sort.Slice(quotas, func(i, j int) bool {
+		a, b := quotas[i], quotas[j]
+		switch {
+		case a.Resource != b.Resource:
+			return a.Resource < b.Resource
+		case a.Action != b.Action:
+			return a.Action < b.Action
+		case a.Per != b.Per:
+			return a.Per < b.Per
+		}
+		return a.ID < b.ID
+	})
+	return quotas
+}
+
+// Restore reads a snapshot written by Snapshot from r, and restores the
+// quotas that it contains. Quotas are skipped if they have expired, or if the
+// Limiter no longer has a corresponding Limited limit. Restored quotas use the
+// Limiter's current limits, but keep the number of requests used and when
+// they expire. Quotas that already exist in the Limiter are not modified.
+//
+// If the Limiter was created with WithMaxClockSkew, the quotas are adjusted
+// when the snapshot was created by a node with a clock that is ahead.
+//
+// If the Limiter becomes full while restoring, an ErrLimiterFull is returned,
+// and the remaining quotas are not restored.
+func (l *Limiter) Restore(r io.Reader) error {
+	const op = "rate.(Limiter).Restore"
+
+	var snap Snapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return fmt.Errorf("%s: %w: %s", op, ErrInvalidSnapshot, err)
+	}
+	switch {
+	case snap.Version <= 0:
+		return fmt.Errorf("%s: missing version: %w", op, ErrInvalidSnapshot)
+	case snap.Version > SnapshotVersion:
+		return fmt.Errorf("%s: version %d: %w", op, snap.Version, ErrUnsupportedSnapshotVersion)
+	}
+
+	if l.maxClockSkew > 0 {
+		if skew := snap.CreatedAt.Sub(l.clock.Now()); skew > 0 {
+			if skew > l.maxClockSkew {
+				return fmt.Errorf("%s: snapshot created %s in the future: %w", op, skew, ErrClockSkew)
+			}
+			for i := range snap.Quotas {
+				snap.Quotas[i].ExpiresAt = snap.Quotas[i].ExpiresAt.Add(-skew)
+			}
+		}
+	}
+
+	if err := l.restoreQuotas(snap.Quotas); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// restoreQuotas restores each of the quotas that has not expired and has a
+// corresponding Limited limit.
+func (l *Limiter) restoreQuotas(quotas []SnapshotQuota) error {
+	policies := l.policies.Load()
+	now := l.clock.Now()
+	for _, sq := range quotas {
+		if !now.Before(sq.ExpiresAt) {
+			continue
+		}
+		policy, ll, ok := policies.limited(sq.Resource, sq.Action, sq.Per)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case l.usesPolicyTotal(ll):
+			policy.restoreTotal(ll, l.clock, sq)
+		default:
+			if err := l.quotaFetcher.restore(ll, sq); err != nil {
+				return err
+			}
+		}
+	}
+	if l.denials != nil {
+		l.denials.clear()
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func snapshotTestLimits() []Limit {
+	return []Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			MaxRequests: 10,
+			Period:      time.Minute,
+		},
+		&Unlimited{
+			Resource: "resource",
+			Action:   "action",
+			Per:      LimitPerAuthToken,
+		},
+	}
+}
+
+func TestLimiterSnapshotRestore(t *testing.T) {
+	cases := []struct {
+		name string
+		opts []Option
+	}{
+		{
+			"default",
+			nil,
+		},
+		{
+			"policy-totals",
+			[]Option{WithPolicyTotalQuotas(true)},
+		},
+		{
+			"max-size-per",
+			[]Option{WithMaxSizePer(LimitPerIPAddress, 10)},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newFakeClock()
+			opts := append([]Option{WithClock(c)}, tc.opts...)
+
+			l, err := NewLimiter(snapshotTestLimits(), 10, opts...)
+			require.NoError(t, err)
+			t.Cleanup(func() { l.Shutdown() })
+
+			for _, ip := range []string{"127.0.0.2", "127.0.0.1", "127.0.0.1"} {
+				allowed, _, err := l.Allow("resource", "action", ip, "")
+				require.NoError(t, err)
+				require.True(t, allowed)
+			}
+
+			var buf bytes.Buffer
+			require.NoError(t, l.Snapshot(&buf))
+
+			var snap Snapshot
+			require.NoError(t, json.Unmarshal(buf.Bytes(), &snap))
+			assert.Equal(t, SnapshotVersion, snap.Version)
+			assert.Equal(t, c.Now().UTC(), snap.CreatedAt)
+			expiresAt := c.Now().Add(time.Minute).UTC()
+			assert.Equal(t, []SnapshotQuota{
+				{Resource: "resource", Action: "action", Per: LimitPerIPAddress, ID: "127.0.0.1", Used: 2, ExpiresAt: expiresAt},
+				{Resource: "resource", Action: "action", Per: LimitPerIPAddress, ID: "127.0.0.2", Used: 1, ExpiresAt: expiresAt},
+				{Resource: "resource", Action: "action", Per: LimitPerTotal, ID: "total", Used: 3, ExpiresAt: expiresAt},
+			}, snap.Quotas)
+
+			// Taking another snapshot of the same state produces the same output.
+			var again bytes.Buffer
+			require.NoError(t, l.Snapshot(&again))
+			assert.Equal(t, buf.String(), again.String())
+
+			c.Advance(30 * time.Second)
+
+			restored, err := NewLimiter(snapshotTestLimits(), 10, opts...)
+			require.NoError(t, err)
+			t.Cleanup(func() { restored.Shutdown() })
+			require.NoError(t, restored.Restore(&buf))
+
+			allowed, quota, err := restored.Allow("resource", "action", "127.0.0.1", "")
+			require.NoError(t, err)
+			require.True(t, allowed)
+			assert.Equal(t, uint64(7), quota.Remaining())
+			assert.Equal(t, 30*time.Second, quota.ResetsIn())
+
+			var after bytes.Buffer
+			require.NoError(t, restored.Snapshot(&after))
+			require.NoError(t, json.Unmarshal(after.Bytes(), &snap))
+			require.Len(t, snap.Quotas, 3)
+			assert.Equal(t, uint64(4), snap.Quotas[2].Used)
+		})
+	}
+}
+
+func TestLimiterRestore(t *testing.T) {
+	c := newFakeClock()
+	now := c.Now().UTC()
+
+	encode := func(t *testing.T, v any) string {
+		t.Helper()
+		b, err := json.Marshal(v)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	cases := []struct {
+		name          string
+		snapshot      string
+		wantErr       error
+		wantRemaining map[string]uint64
+	}{
+		{
+			"invalid-json",
+			"{",
+			ErrInvalidSnapshot,
+			nil,
+		},
+		{
+			"missing-version",
+			`{"quotas":[]}`,
+			ErrInvalidSnapshot,
+			nil,
+		},
+		{
+			"newer-version",
+			encode(t, Snapshot{Version: SnapshotVersion + 1}),
+			ErrUnsupportedSnapshotVersion,
+			nil,
+		},
+		{
+			"unknown-fields",
+			`{"version":1,"future":true,"quotas":[{"resource":"resource","action":"action","per":"ip-address","id":"127.0.0.1","used":4,"expires_at":"` +
+				now.Add(time.Minute).Format(time.RFC3339Nano) + `","future":1}]}`,
+			nil,
+			map[string]uint64{"127.0.0.1": 5},
+		},
+		{
+			"skipped",
+			encode(t, Snapshot{
+				Version: SnapshotVersion,
+				Quotas: []SnapshotQuota{
+					{Resource: "resource", Action: "action", Per: LimitPerIPAddress, ID: "expired", Used: 4, ExpiresAt: now},
+					{Resource: "missing", Action: "action", Per: LimitPerIPAddress, ID: "missing", Used: 4, ExpiresAt: now.Add(time.Minute)},
+					{Resource: "resource", Action: "action", Per: LimitPerAuthToken, ID: "unlimited", Used: 4, ExpiresAt: now.Add(time.Minute)},
+				},
+			}),
+			nil,
+			map[string]uint64{"expired": 9},
+		},
+		{
+			"capped-expiration",
+			encode(t, Snapshot{
+				Version: SnapshotVersion,
+				Quotas: []SnapshotQuota{
+					{Resource: "resource", Action: "action", Per: LimitPerIPAddress, ID: "127.0.0.1", Used: 9, ExpiresAt: now.Add(time.Hour)},
+				},
+			}),
+			nil,
+			map[string]uint64{"127.0.0.1": 0},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			l, err := NewLimiter(snapshotTestLimits(), 10, WithClock(c))
+			require.NoError(t, err)
+			t.Cleanup(func() { l.Shutdown() })
+
+			err = l.Restore(strings.NewReader(tc.snapshot))
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			for ip, want := range tc.wantRemaining {
+				_, quota, err := l.Allow("resource", "action", ip, "")
+				require.NoError(t, err)
+				assert.Equal(t, want, quota.Remaining())
+				assert.LessOrEqual(t, quota.ResetsIn(), time.Minute)
+			}
+		})
+	}
+}
+
+func TestLimiterRestoreExisting(t *testing.T) {
+	c := newFakeClock()
+	l, err := NewLimiter(snapshotTestLimits(), 10, WithClock(c))
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Shutdown() })
+
+	_, _, err = l.Allow("resource", "action", "127.0.0.1", "")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, json.NewEncoder(&buf).Encode(Snapshot{
+		Version: SnapshotVersion,
+		Quotas: []SnapshotQuota{
+			{Resource: "resource", Action: "action", Per: LimitPerIPAddress, ID: "127.0.0.1", Used: 8, ExpiresAt: c.Now().Add(time.Minute)},
+		},
+	}))
+	require.NoError(t, l.Restore(&buf))
+
+	_, quota, err := l.Allow("resource", "action", "127.0.0.1", "")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(8), quota.Remaining())
+}
+
+func TestLimiterRestoreFull(t *testing.T) {
+	c := newFakeClock()
+	l, err := NewLimiter(snapshotTestLimits(), 2, WithClock(c))
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Shutdown() })
+
+	expiresAt := c.Now().Add(time.Minute)
+	var buf bytes.Buffer
+	require.NoError(t, json.NewEncoder(&buf).Encode(Snapshot{
+		Version: SnapshotVersion,
+		Quotas: []SnapshotQuota{
+			{Resource: "resource", Action: "action", Per: LimitPerIPAddress, ID: "127.0.0.1", Used: 1, ExpiresAt: expiresAt},
+			{Resource: "resource", Action: "action", Per: LimitPerIPAddress, ID: "127.0.0.2", Used: 1, ExpiresAt: expiresAt},
+			{Resource: "resource", Action: "action", Per: LimitPerIPAddress, ID: "127.0.0.3", Used: 1, ExpiresAt: expiresAt},
+		},
+	}))
+	err = l.Restore(&buf)
+	var full *ErrLimiterFull
+	require.ErrorAs(t, err, &full)
+}
+
+func TestLimiterRestoreClockSkew(t *testing.T) {
+	c := newFakeClock()
+	now := c.Now()
+
+	snapshot := func(t *testing.T, createdAt time.Time) *bytes.Buffer {
+		t.Helper()
+		var buf bytes.Buffer
+		require.NoError(t, json.NewEncoder(&buf).Encode(Snapshot{
+			Version:   SnapshotVersion,
+			CreatedAt: createdAt,
+			Quotas: []SnapshotQuota{
+				{Resource: "resource", Action: "action", Per: LimitPerIPAddress, ID: "127.0.0.1", Used: 1, ExpiresAt: createdAt.Add(30 * time.Second)},
+			},
+		}))
+		return &buf
+	}
+
+	cases := []struct {
+		name         string
+		maxSkew      time.Duration
+		createdAt    time.Time
+		wantErr      error
+		wantResetsIn time.Duration
+	}{
+		{"ahead-without-max", 0, now.Add(10 * time.Second), nil, 40 * time.Second},
+		{"ahead-within-max", 15 * time.Second, now.Add(10 * time.Second), nil, 30 * time.Second},
+		{"ahead-beyond-max", 5 * time.Second, now.Add(10 * time.Second), ErrClockSkew, 0},
+		{"behind", 5 * time.Second, now.Add(-10 * time.Second), nil, 20 * time.Second},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			l, err := NewLimiter(snapshotTestLimits(), 10, WithClock(c), WithMaxClockSkew(tc.maxSkew))
+			require.NoError(t, err)
+			t.Cleanup(func() { l.Shutdown() })
+
+			err = l.Restore(snapshot(t, tc.createdAt))
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			_, quota, err := l.Allow("resource", "action", "127.0.0.1", "")
+			require.NoError(t, err)
+			assert.Equal(t, uint64(8), quota.Remaining())
+			assert.Equal(t, tc.wantResetsIn, quota.ResetsIn())
+		})
+	}
+
+	t.Run("negative-max", func(t *testing.T) {
+		_, err := NewLimiter(snapshotTestLimits(), 10, WithMaxClockSkew(-time.Second))
+		assert.ErrorIs(t, err, ErrInvalidParameter)
+	})
+}
@@ -0,0 +1,282 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Store stores quotas outside of the Limiter, such as in Redis or a SQL
+// database, so that they can be shared by multiple Limiters. A Store is
+// provided via WithStore.
+//
+// Since a request can be limited by more than one limit, such as by both its
+// IP address and auth token, a Store must check and consume the quotas for a
+// request atomically, rather than fetching each quota and then consuming
+// from them, which could allow requests in excess of the limits when
+// requests are made concurrently via multiple Limiters. For example, a Redis
+// Store could use a Lua script, and a SQL Store could use a transaction.
+type Store interface {
+	// CheckAndConsume checks if each of the quotas for the keys has at least
+	// n requests remaining, including the GraceRequests of its limit, and if
+	// so, consumes n requests from each of them. Either all of the quotas
+	// are consumed from, or none of them are. The quota for keys[i] uses
+	// limits[i]. A quota that does not exist, or whose window has expired,
+	// is reset using the Period of its limit prior to being checked. The ctx
+	// is the one passed to AllowNContext, so a Store should stop waiting for
+	// its backend and return ctx's error once ctx is done.
+	CheckAndConsume(ctx context.Context, keys []Key, limits []*Limited, n uint64) (Decision, error)
+}
+
+// StoreFailureMode is how a Limiter handles requests when its Store returns an
+// error, such as when the Store's backend is unavailable.
+type StoreFailureMode int
+
+const (
+	// StoreFailClosed indicates that requests are not allowed when the Store
+	// returns an error, and the error is returned. This is the default.
+	StoreFailClosed StoreFailureMode = iota
+	// StoreFailOpen indicates that requests are allowed without being
+	// limited when the Store returns an error. A nil Quota is returned for
+	// these requests.
+	StoreFailOpen
+	// StoreFallbackLocal indicates that requests are limited using quotas
+	// stored in the Limiter when the Store returns an error, which are
+	// limited to the Limiter's max size. Once the Store recovers, the
+	// requests consumed from the Limiter's quotas are consumed from the
+	// Store's quotas, so that they are counted by other Limiters. The usage
+	// of at most the Limiter's max size quotas is recorded; requests
+	// consumed from other quotas are not resynced, so their quotas in the
+	// Store may allow more requests than their limit. These are recorded by
+	// WithStoreFallbackDropMetric.
+	StoreFallbackLocal
+)
+
+// IsValid checks if the given StoreFailureMode is valid.
+func (m StoreFailureMode) IsValid() bool {
+	switch m {
+	case StoreFailClosed, StoreFailOpen, StoreFallbackLocal:
+		return true
+	}
+	return false
+}
+
+// Key identifies a quota in a Store.
+type Key struct {
+	// Name is unique for each quota, and is the same for each Limiter that
+	// has the same limits, so it can be used as the key of the quota in a
+	// Store. The quotas for limits with the same Pool have the same Name.
+	Name string
+	Per  LimitPer
+	// ID is the IP address or auth token for LimitPerIPAddress and
+	// LimitPerAuthToken, and "total" for LimitPerTotal.
+	ID string
+}
+
+// Decision is the result of Store.CheckAndConsume.
+type Decision struct {
+	// Allowed is true if the quotas were consumed from.
+	Allowed bool
+	// Quotas is the state of the quota for each of the keys, in the same
+	// order as the keys, after they were checked and consumed from.
+	Quotas []QuotaState
+}
+
+// QuotaState is the state of a quota in a Store.
+type QuotaState struct {
+	// Used is the number of requests that have been used from the quota in
+	// its current window.
+	Used uint64
+	// ExpiresAt is when the current window of the quota ends.
+	ExpiresAt time.Time
+}
+
+// Quota returns a Quota with the state, for the provided limit. The Quota is
+// not stored by a Limiter, so consuming from it does not affect any other
+// quotas. It can be used to report the state of a quota that is stored
+// elsewhere, such as by a Store or by a Limiter in another process.
+func (s QuotaState) Quota(limit *Limited) *Quota {
+	return &Quota{
+		limit:     limit,
+		used:      s.Used,
+		expiresAt: quotaTime(s.ExpiresAt),
+	}
+}
+
+// limiterStore is a Store used by a Limiter to check and consume the quotas
+// for one or more LimitPers.
+type limiterStore struct {
+	// store is the Store provided via WithStore or WithStorePer, wrapped by
+	// a storeBreaker if WithStoreBreaker is used.
+	store Store
+	// fallback limits requests using the Limiter's quotaFetcher while the
+	// Store is failing. It is nil unless StoreFallbackLocal is used.
+	fallback *storeFallback
+}
+
+// newLimiterStores returns the limiterStore used for each LimitPer whose
+// quotas are stored in a Store, or nil if none of them are. The LimitPers
+// without a Store provided via WithStorePer share the Store provided via
+// WithStore, if there is one, so that their quotas are checked and consumed
+// together.
+func newLimiterStores(opts options, fetcher quotaFetcher, maxSize int) map[LimitPer]*limiterStore {
+	newStore := func(s Store) *limiterStore {
+		if opts.withStoreBreaker != nil {
+			s = newStoreBreaker(s, *opts.withStoreBreaker, opts.withClock, opts.withStoreBreakerStateMetric, opts.withStoreBreakerOpenMetric)
+		}
+		ls := &limiterStore{store: s}
+		if opts.withStoreFailureMode == StoreFallbackLocal {
+			ls.fallback = newStoreFallback(fetcher, maxSize, opts.withStoreFallbackDropMetric)
+		}
+		return ls
+	}
+
+	var stores map[LimitPer]*limiterStore
+	var shared *limiterStore
+	for _, per := range requiredLimitPer {
+		var ls *limiterStore
+		s, ok := opts.withStorePer[per]
+		switch {
+		case ok:
+			ls = newStore(s)
+		case opts.withStore == nil:
+			continue
+		case shared == nil:
+			shared = newStore(opts.withStore)
+			ls = shared
+		default:
+			ls = shared
+		}
+		if stores == nil {
+			stores = make(map[LimitPer]*limiterStore, len(requiredLimitPer))
+		}
+		stores[per] = ls
+	}
+	return stores
+}
+
+// storeCheck is the keys and limits of the quotas for a request that are
+// checked and consumed via the same limiterStore.
+type storeCheck struct {
+	store  *limiterStore
+	keys   []Key
+	limits []*Limited
+}
+
+// addStoreCheck adds the key and limit to the storeCheck for the
+// limiterStore, adding one if needed.
+func addStoreCheck(checks []storeCheck, s *limiterStore, key Key, limit *Limited) []storeCheck {
+	for i := range checks {
+		if checks[i].store == s {
+			checks[i].keys = append(checks[i].keys, key)
+			checks[i].limits = append(checks[i].limits, limit)
+			return checks
+		}
+	}
+	return append(checks, storeCheck{store: s, keys: []Key{key}, limits: []*Limited{limit}})
+}
+
+// sortStoreChecks sorts the keys of each storeCheck, and then sorts the
+// storeChecks by the LimitPer of their first key, so that the Stores are
+// called in a consistent order.
+func sortStoreChecks(checks []storeCheck) {
+	for _, c := range checks {
+		sortKeys(c.keys, c.limits)
+	}
+	sort.Slice(checks, func(i, j int) bool {
+		return perOrder(checks[i].keys[0].Per) < perOrder(checks[j].keys[0].Per)
+	})
+}
+
+// checkAndConsume checks and consumes the quotas for the keys via the
+// limiterStore. The returned quota is the quota that denied the request, or
+// the quota with the fewest remaining requests if the request is allowed.
+// The denial is only cached if cacheDenial is true. If ctx is done, its error
+// is returned without calling the Store. If the Store returns an error, the
+// request is handled using the Limiter's StoreFailureMode.
+func (l *Limiter) checkAndConsume(ctx context.Context, s *limiterStore, keys []Key, limits []*Limited, n uint64, cacheDenial bool) (allowed bool, quota *Quota, err error) {
+	if l.stopped.Load() {
+		return false, nil, ErrStopped
+	}
+	if err := ctx.Err(); err != nil {
+		return false, nil, err
+	}
+	d, err := s.store.CheckAndConsume(ctx, keys, limits, n)
+	if err != nil {
+		switch l.storeFailureMode {
+		case StoreFailOpen:
+			return true, nil, nil
+		case StoreFallbackLocal:
+			return s.fallback.checkAndConsume(ctx, keys, limits, n)
+		default:
+			return false, nil, err
+		}
+	}
+	if s.fallback != nil {
+		s.fallback.startResync(s.store)
+	}
+	if len(d.Quotas) != len(keys) {
+		return false, nil, fmt.Errorf("store returned %d quotas for %d keys: %w", len(d.Quotas), len(keys), ErrInvalidParameter)
+	}
+
+	// denied is the index of the key whose quota denied the request.
+	denied := -1
+	for i, s := range d.Quotas {
+		q := s.Quota(limits[i])
+		q.clock = l.clock
+		switch {
+		case !d.Allowed:
+			if remaining := q.remainingWithGrace(); remaining < n || remaining == 0 {
+				if quota == nil || remaining < quota.remainingWithGrace() {
+					quota = q
+					denied = i
+				}
+			}
+		case quota == nil, q.Remaining() < quota.Remaining():
+			quota = q
+		}
+		if d.Allowed && l.graceHook != nil && q.GraceUsed() > 0 {
+			l.graceHook(q.graceRequest(keys[i].ID))
+		}
+	}
+	if denied >= 0 && l.denials != nil && cacheDenial {
+		l.denials.add(keys[denied].Name, quota)
+	}
+	return d.Allowed, quota, nil
+}
+
+// sortKeys sorts the keys, along with their limits, by their LimitPer, in the
+// order total, IP address, and auth token, so that a Store receives them in a
+// consistent order.
+func sortKeys(keys []Key, limits []*Limited) {
+	sort.Sort(keysByPer{keys: keys, limits: limits})
+}
+
+type keysByPer struct {
+	keys   []Key
+	limits []*Limited
+}
+
+func (k keysByPer) Len() int           { return len(k.keys) }
+func (k keysByPer) Less(i, j int) bool { return perOrder(k.keys[i].Per) < perOrder(k.keys[j].Per) }
+func (k keysByPer) Swap(i, j int) {
+	k.keys[i], k.keys[j] = k.keys[j], k.keys[i]
+	k.limits[i], k.limits[j] = k.limits[j], k.limits[i]
+}
+
+// perOrder returns the position of per in the order total, IP address, and
+// auth token.
+func perOrder(per LimitPer) int {
+	switch per {
+	case LimitPerTotal:
+		return 0
+	case LimitPerIPAddress:
+		return 1
+	default:
+		return 2
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-rate/metric"
+)
+
+// StoreBreaker configures a circuit breaker around the calls to a Limiter's
+// Store, so that requests do not wait for a Store that is failing. Once the
+// breaker opens, the Store is not called, and ErrStoreUnavailable is handled
+// using the Limiter's StoreFailureMode instead. A StoreBreaker is provided via
+// WithStoreBreaker.
+type StoreBreaker struct {
+	// ErrorRate is the fraction of the calls to the Store in an Interval
+	// that must return an error for the breaker to open. It must be greater
+	// than zero and at most one.
+	ErrorRate float64
+	// MinRequests is the number of calls to the Store that must be made in
+	// an Interval before the breaker can open. It must be greater than zero.
+	MinRequests int
+	// Interval is how long the calls to the Store are counted for before
+	// the counts are reset. It must be greater than zero.
+	Interval time.Duration
+	// OpenDuration is how long the breaker remains open before it becomes
+	// half-open. It must be greater than zero.
+	OpenDuration time.Duration
+	// Probes is the number of calls to the Store that are made while the
+	// breaker is half-open. If each of them succeeds, the breaker closes,
+	// and if any of them returns an error, the breaker opens again. Other
+	// calls are not made while the probes are in progress. It must be
+	// greater than zero.
+	Probes int
+}
+
+func (b StoreBreaker) validate() error {
+	switch {
+	case b.ErrorRate <= 0 || b.ErrorRate > 1:
+		return fmt.Errorf("store breaker error rate must be greater than zero and at most one: %w", ErrInvalidParameter)
+	case b.MinRequests <= 0:
+		return fmt.Errorf("store breaker min requests must be greater than zero: %w", ErrInvalidParameter)
+	case b.Interval <= 0:
+		return fmt.Errorf("store breaker interval must be greater than zero: %w", ErrInvalidParameter)
+	case b.OpenDuration <= 0:
+		return fmt.Errorf("store breaker open duration must be greater than zero: %w", ErrInvalidParameter)
+	case b.Probes <= 0:
+		return fmt.Errorf("store breaker probes must be greater than zero: %w", ErrInvalidParameter)
+	}
+	return nil
+}
+
+// BreakerState is the state of a StoreBreaker.
+type BreakerState int
+
+const (
+	// BreakerClosed indicates that the Store is called for each request.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen indicates that the Store is not called.
+	BreakerOpen
+	// BreakerHalfOpen indicates that the Store is called for the breaker's
+	// probes, to determine if it has recovered.
+	BreakerHalfOpen
+)
+
+// String returns the name of the state.
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// storeBreaker is a Store that calls another Store using a StoreBreaker.
+type storeBreaker struct {
+	store       Store
+	config      StoreBreaker
+	clock       Clock
+	stateMetric metric.Gauge
+	openMetric  metric.Counter
+
+	mu    sync.Mutex
+	state BreakerState
+	// since is when the current Interval started while the breaker is
+	// closed, or when the breaker opened while it is open.
+	since time.Time
+	// calls and failures are the number of calls to the Store in the
+	// current Interval, and the number that returned an error.
+	calls    int
+	failures int
+	// probes and succeeded are the number of probes that have been made
+	// while the breaker is half-open, and the number that succeeded.
+	probes    int
+	succeeded int
+}
+
+func newStoreBreaker(s Store, config StoreBreaker, c Clock, stateMetric metric.Gauge, openMetric metric.Counter) *storeBreaker {
+	b := &storeBreaker{
+		store:       s,
+		config:      config,
+		clock:       c,
+		stateMetric: stateMetric,
+		openMetric:  openMetric,
+		since:       c.Now(),
+	}
+	b.stateMetric.Set(float64(BreakerClosed))
+	return b
+}
+
+// CheckAndConsume calls the Store's CheckAndConsume, unless the breaker is
+// open, or is half-open and its probes are in progress, in which case
+// ErrStoreUnavailable is returned.
+func (b *storeBreaker) CheckAndConsume(ctx context.Context, keys []Key, limits []*Limited, n uint64) (Decision, error) {
+	probe, ok := b.allow()
+	if !ok {
+		return Decision{}, ErrStoreUnavailable
+	}
+	d, err := b.store.CheckAndConsume(ctx, keys, limits, n)
+	b.record(probe, err != nil)
+	return d, err
+}
+
+// allow reports whether the Store can be called, and whether the call is a
+// probe.
+func (b *storeBreaker) allow() (probe, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && b.clock.Now().Sub(b.since) >= b.config.OpenDuration {
+		b.setStateLocked(BreakerHalfOpen)
+		b.probes, b.succeeded = 0, 0
+	}
+	switch b.state {
+	case BreakerOpen:
+		return false, false
+	case BreakerHalfOpen:
+		if b.probes >= b.config.Probes {
+			return false, false
+		}
+		b.probes++
+		return true, true
+	}
+	return false, true
+}
+
+// record records the result of a call to the Store, which changes the state
+// of the breaker if needed. The results of calls that are not probes are
+// ignored unless the breaker is closed, since they were made before it
+// opened.
+func (b *storeBreaker) record(probe, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.clock.Now()
+	switch {
+	case probe && b.state == BreakerHalfOpen:
+		if failed {
+			b.openLocked(now)
+			return
+		}
+		b.succeeded++
+		if b.succeeded >= b.config.Probes {
+			b.setStateLocked(BreakerClosed)
+			b.since, b.calls, b.failures = now, 0, 0
+		}
+	case !probe && b.state == BreakerClosed:
+		if now.Sub(b.since) >= b.config.Interval {
+			b.since, b.calls, b.failures = now, 0, 0
+		}
+		b.calls++
+		if failed {
+			b.failures++
+		}
+		if b.calls >= b.config.MinRequests && float64(b.failures) >= b.config.ErrorRate*float64(b.calls) {
+			b.openLocked(now)
+		}
+	}
+}
+
+// openLocked opens the breaker.
+//
+// openLocked should always be called by a function that first acquires a lock
+func (b *storeBreaker) openLocked(now time.Time) {
+	const op = "rate.(storeBreaker).openLocked"
+	if b.mu.TryLock() {
+		panic(fmt.Sprintf("%s: called without lock", op))
+	}
+	b.setStateLocked(BreakerOpen)
+	b.since = now
+	b.openMetric.Add(1)
+}
+
+// setStateLocked changes the state of the breaker, and records it using the
+// state metric.
+//
+// setStateLocked should always be called by a function that first acquires a lock
+func (b *storeBreaker) setStateLocked(s BreakerState) {
+	const op = "rate.(storeBreaker).setStateLocked"
+	if b.mu.TryLock() {
+		panic(fmt.Sprintf("%s: called without lock", op))
+	}
+	b.state = s
+	b.stateMetric.Set(float64(s))
+}
@@ -0,0 +1,199 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreBreakerValidate(t *testing.T) {
+	valid := StoreBreaker{ErrorRate: 0.5, MinRequests: 10, Interval: time.Minute, OpenDuration: time.Second, Probes: 1}
+	require.NoError(t, valid.validate())
+
+	cases := []struct {
+		name   string
+		modify func(b *StoreBreaker)
+	}{
+		{"zero-error-rate", func(b *StoreBreaker) { b.ErrorRate = 0 }},
+		{"large-error-rate", func(b *StoreBreaker) { b.ErrorRate = 1.5 }},
+		{"zero-min-requests", func(b *StoreBreaker) { b.MinRequests = 0 }},
+		{"zero-interval", func(b *StoreBreaker) { b.Interval = 0 }},
+		{"zero-open-duration", func(b *StoreBreaker) { b.OpenDuration = 0 }},
+		{"zero-probes", func(b *StoreBreaker) { b.Probes = 0 }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := valid
+			tc.modify(&b)
+			assert.ErrorIs(t, b.validate(), ErrInvalidParameter)
+
+			_, err := NewLimiter([]Limit{
+				&Limited{Resource: "resource", Action: "action", Per: LimitPerTotal, MaxRequests: 10, Period: time.Minute},
+			}, 10, WithStoreBreaker(b))
+			assert.ErrorIs(t, err, ErrInvalidParameter)
+		})
+	}
+}
+
+func TestBreakerStateString(t *testing.T) {
+	assert.Equal(t, "closed", BreakerClosed.String())
+	assert.Equal(t, "open", BreakerOpen.String())
+	assert.Equal(t, "half-open", BreakerHalfOpen.String())
+	assert.Equal(t, "unknown", BreakerState(-1).String())
+}
+
+func Test_storeBreaker(t *testing.T) {
+	c := newFakeClock()
+	state, opens := &testGauge{}, &testCounter{}
+	unavailable := errors.New("unavailable")
+	// fail is whether the Store returns an error, and calls is the number of
+	// times that it is called.
+	var fail bool
+	var calls int
+	s := storeFunc(func(_ context.Context, keys []Key, _ []*Limited, _ uint64) (Decision, error) {
+		calls++
+		if fail {
+			return Decision{}, unavailable
+		}
+		return Decision{Allowed: true, Quotas: make([]QuotaState, len(keys))}, nil
+	})
+	b := newStoreBreaker(s, StoreBreaker{
+		ErrorRate:    0.5,
+		MinRequests:  4,
+		Interval:     time.Minute,
+		OpenDuration: 10 * time.Second,
+		Probes:       2,
+	}, c, state, opens)
+
+	call := func(failing bool) error {
+		fail = failing
+		_, err := b.CheckAndConsume(context.Background(), []Key{{Name: "key"}}, []*Limited{{}}, 1)
+		return err
+	}
+
+	// The breaker does not open until MinRequests calls are made.
+	require.NoError(t, call(false))
+	require.Error(t, call(true))
+	require.NoError(t, call(false))
+	assert.Equal(t, BreakerClosed, b.state)
+	assert.ErrorIs(t, call(true), unavailable)
+	assert.Equal(t, BreakerOpen, b.state)
+	assert.Equal(t, float64(BreakerOpen), state.v)
+	assert.Equal(t, float64(1), opens.v)
+
+	// The Store is not called while the breaker is open.
+	assert.ErrorIs(t, call(false), ErrStoreUnavailable)
+	assert.Equal(t, 4, calls)
+
+	// The breaker opens again if a probe fails.
+	c.Advance(10 * time.Second)
+	require.NoError(t, call(false))
+	assert.Equal(t, BreakerHalfOpen, b.state)
+	assert.Equal(t, float64(BreakerHalfOpen), state.v)
+	assert.ErrorIs(t, call(true), unavailable)
+	assert.Equal(t, BreakerOpen, b.state)
+	assert.Equal(t, float64(2), opens.v)
+	assert.Equal(t, 6, calls)
+
+	// The breaker closes once each of the probes succeed.
+	c.Advance(10 * time.Second)
+	require.NoError(t, call(false))
+	require.NoError(t, call(false))
+	assert.Equal(t, BreakerClosed, b.state)
+	assert.Equal(t, float64(BreakerClosed), state.v)
+
+	// The calls are only counted for an Interval.
+	require.Error(t, call(true))
+	require.Error(t, call(true))
+	require.NoError(t, call(false))
+	c.Advance(time.Minute)
+	require.Error(t, call(true))
+	assert.Equal(t, BreakerClosed, b.state)
+	assert.Equal(t, float64(2), opens.v)
+}
+
+func Test_storeBreakerProbes(t *testing.T) {
+	c := newFakeClock()
+	b := newStoreBreaker(nil, StoreBreaker{
+		ErrorRate:    1,
+		MinRequests:  1,
+		Interval:     time.Minute,
+		OpenDuration: time.Second,
+		Probes:       2,
+	}, c, &nilGauge{}, &nilCounter{})
+
+	b.record(false, true)
+	require.Equal(t, BreakerOpen, b.state)
+	c.Advance(time.Second)
+
+	// Only Probes calls are made while the probes are in progress.
+	for i := 0; i < 2; i++ {
+		probe, ok := b.allow()
+		assert.True(t, probe)
+		assert.True(t, ok)
+	}
+	_, ok := b.allow()
+	assert.False(t, ok)
+
+	// The result of a call made before the breaker opened is ignored.
+	b.record(false, false)
+	b.record(true, false)
+	assert.Equal(t, BreakerHalfOpen, b.state)
+	b.record(true, false)
+	assert.Equal(t, BreakerClosed, b.state)
+}
+
+func TestLimiterStoreBreaker(t *testing.T) {
+	c := newFakeClock()
+	s := newTestStore(c)
+	s.err = errors.New("unavailable")
+	state := &testGauge{}
+	l, err := NewLimiter([]Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			MaxRequests: 10,
+			Period:      time.Minute,
+		},
+	}, 10,
+		WithClock(c),
+		WithStore(s),
+		WithStoreFailureMode(StoreFallbackLocal),
+		WithStoreBreaker(StoreBreaker{ErrorRate: 1, MinRequests: 2, Interval: time.Minute, OpenDuration: time.Second, Probes: 1}),
+		WithStoreBreakerStateMetric(state),
+	)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	// Once the breaker opens, the requests fall back to the Limiter's quotas
+	// without calling the Store.
+	for i := 0; i < 5; i++ {
+		allowed, q, err := l.Allow("resource", "action", "127.0.0.1", "token")
+		require.NoError(t, err)
+		assert.True(t, allowed)
+		assert.Equal(t, uint64(10-i-1), q.Remaining())
+	}
+	assert.Equal(t, float64(BreakerOpen), state.v)
+	assert.Empty(t, s.keys)
+
+	// The breaker closes once its probe succeeds, and the requests that
+	// were consumed from the Limiter's quota are resynced.
+	s.mu.Lock()
+	s.err = nil
+	s.mu.Unlock()
+	c.Advance(time.Second)
+	allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, float64(BreakerClosed), state.v)
+	waitResync(l)
+	assert.Equal(t, uint64(6), s.quotas[quotaKey(&Limited{Resource: "resource", Action: "action", Per: LimitPerIPAddress}, "127.0.0.1")].Used)
+}
@@ -0,0 +1,64 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import "time"
+
+// StoreEventType is the type of a StoreEvent.
+type StoreEventType uint8
+
+const (
+	// StoreEventBucketReallocated indicates that the entries table of an
+	// expired bucket was replaced with a new table, rather than being emptied
+	// in place, releasing its memory to be garbage collected.
+	StoreEventBucketReallocated StoreEventType = iota + 1
+	// StoreEventFull indicates that the store has reached its max size, and
+	// new quotas cannot be stored.
+	StoreEventFull
+	// StoreEventNotFull indicates that quotas were removed from a store that
+	// was full, so new quotas can be stored again.
+	StoreEventNotFull
+	// StoreEventItemsReallocated indicates that the table used to look up
+	// the quotas in the store was replaced with a smaller table after most of
+	// its quotas were removed, releasing its memory to be garbage collected.
+	StoreEventItemsReallocated
+)
+
+// String returns the name of the event type.
+func (t StoreEventType) String() string {
+	switch t {
+	case StoreEventBucketReallocated:
+		return "bucket-reallocated"
+	case StoreEventFull:
+		return "full"
+	case StoreEventNotFull:
+		return "not-full"
+	case StoreEventItemsReallocated:
+		return "items-reallocated"
+	}
+	return "unknown"
+}
+
+// StoreEvent describes a change to the internal state of the store used to
+// hold quotas.
+type StoreEvent struct {
+	Type StoreEventType
+	Time time.Time
+
+	// Bucket is the index of the bucket that was reallocated, and Entries is
+	// the number of entries it contained. They are only set for
+	// StoreEventBucketReallocated.
+	Bucket  int
+	Entries int
+
+	// Usage and Capacity are the number of quotas in the store and its max
+	// size when the event occurred.
+	Usage    int
+	Capacity int
+}
+
+// StoreEventHook is called when a StoreEvent occurs. It is called while the
+// store's lock is held, so it must return quickly and must not call the
+// Limiter.
+type StoreEventHook func(StoreEvent)
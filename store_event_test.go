@@ -0,0 +1,18 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreEventType_String(t *testing.T) {
+	assert.Equal(t, "bucket-reallocated", StoreEventBucketReallocated.String())
+	assert.Equal(t, "full", StoreEventFull.String())
+	assert.Equal(t, "not-full", StoreEventNotFull.String())
+	assert.Equal(t, "items-reallocated", StoreEventItemsReallocated.String())
+	assert.Equal(t, "unknown", StoreEventType(0).String())
+}
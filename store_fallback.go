@@ -0,0 +1,216 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-rate/metric"
+)
+
+// storeResyncBudget is the most time spent resyncing the requests consumed
+// while a Store was failing once it recovers. The requests that are not
+// resynced within it are resynced after the next successful call to the
+// Store.
+const storeResyncBudget = 5 * time.Second
+
+// storeFallback limits requests using the quotas in a quotaFetcher while a
+// Limiter's Store is returning errors, for StoreFallbackLocal. It records the
+// number of requests consumed from each quota, so that they can be consumed
+// from the Store's quotas once it recovers.
+type storeFallback struct {
+	fetcher quotaFetcher
+	// maxSize is the most quotas whose consumed requests are recorded. The
+	// requests consumed from other quotas are not resynced, and are counted
+	// by dropMetric.
+	maxSize    int
+	dropMetric metric.Counter
+
+	// failing is true from when the Store returns an error until it is
+	// resynced.
+	failing atomic.Bool
+	// ctx is canceled when the Limiter is shut down, to stop resyncing, and
+	// wg tracks the goroutines that are resyncing.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu sync.Mutex
+	// consumed are the quotas that requests were consumed from while the
+	// Store was failing, keyed by their Key's Name.
+	consumed map[string]*fallbackUsage
+}
+
+// fallbackUsage is the number of requests consumed from a quota in the
+// current window of the quotaFetcher's quota while the Store was failing.
+type fallbackUsage struct {
+	key   Key
+	limit *Limited
+	quota *Quota
+	// windowEnd is when the window of the quota that n requests were
+	// consumed from ends.
+	windowEnd int64
+	n         uint64
+}
+
+// add records n requests consumed from q, discarding the requests recorded
+// for a previous window of the quota.
+func (u *fallbackUsage) add(q *Quota, n uint64) {
+	if end := q.windowEnd(); u.quota != q || u.windowEnd != end {
+		u.quota, u.windowEnd, u.n = q, end, 0
+	}
+	u.n += n
+}
+
+// current reports whether the window that the requests were consumed in has
+// not ended.
+func (u *fallbackUsage) current() bool {
+	return !u.quota.Expired() && u.windowEnd == u.quota.windowEnd()
+}
+
+func newStoreFallback(fetcher quotaFetcher, maxSize int, dropMetric metric.Counter) *storeFallback {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &storeFallback{
+		fetcher:    fetcher,
+		maxSize:    maxSize,
+		dropMetric: dropMetric,
+		ctx:        ctx,
+		cancel:     cancel,
+		consumed:   make(map[string]*fallbackUsage),
+	}
+}
+
+// checkAndConsume checks and consumes the quotas for the keys using the
+// quotaFetcher instead of the Store. As with a Store, either all of the
+// quotas are consumed from, or none of them are. The returned quota is the
+// quota that denied the request, or the quota with the fewest remaining
+// requests if the request is allowed.
+func (f *storeFallback) checkAndConsume(ctx context.Context, keys []Key, limits []*Limited, n uint64) (allowed bool, quota *Quota, err error) {
+	f.failing.Store(true)
+
+	quotas := make([]*Quota, len(keys))
+	for i, k := range keys {
+		q, err := f.fetcher.fetch(ctx, k.ID, limits[i])
+		if err != nil {
+			return false, nil, err
+		}
+		quotas[i] = q
+	}
+
+	// The quotas are checked and consumed while holding the lock, so that
+	// concurrent requests cannot each be allowed by a quota that only has
+	// enough requests remaining for one of them.
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, q := range quotas {
+		if remaining := q.remainingWithGrace(); remaining <= 0 || remaining < n {
+			return false, q, nil
+		}
+	}
+	for i, q := range quotas {
+		q.consumeN(n)
+		if quota == nil || q.Remaining() < quota.Remaining() {
+			quota = q
+		}
+
+		u, ok := f.consumed[keys[i].Name]
+		if !ok {
+			if len(f.consumed) >= f.maxSize {
+				f.dropMetric.Add(1)
+				continue
+			}
+			u = &fallbackUsage{key: keys[i], limit: limits[i]}
+			f.consumed[keys[i].Name] = u
+		}
+		u.add(q, n)
+	}
+	return true, quota, nil
+}
+
+// startResync resyncs the requests that were consumed while the Store was
+// failing in the background, if it was failing, so that the request whose
+// call to the Store succeeded does not wait for them. The resync is limited
+// to storeResyncBudget.
+func (f *storeFallback) startResync(s Store) {
+	if !f.failing.Load() || !f.failing.CompareAndSwap(true, false) {
+		return
+	}
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		ctx, cancel := context.WithTimeout(f.ctx, storeResyncBudget)
+		defer cancel()
+		f.resync(ctx, s)
+	}()
+}
+
+// resync consumes the requests that were consumed from the quotaFetcher's
+// quotas while the Store was failing from the Store's quotas. The requests
+// for quotas whose window has since ended are not consumed, and the requests
+// for a quota are not consumed if they would exceed it. If the Store returns
+// an error, or ctx is done, it is considered to be failing again, and the
+// remaining requests are resynced once it recovers. If the storeFallback was
+// shut down, the remaining requests are discarded instead.
+func (f *storeFallback) resync(ctx context.Context, s Store) {
+	f.mu.Lock()
+	consumed := f.consumed
+	f.consumed = make(map[string]*fallbackUsage)
+	f.mu.Unlock()
+
+	for name, u := range consumed {
+		if !u.current() {
+			delete(consumed, name)
+			continue
+		}
+		if ctx.Err() != nil {
+			f.fail(consumed)
+			return
+		}
+		if _, err := s.CheckAndConsume(ctx, []Key{u.key}, []*Limited{u.limit}, u.n); err != nil {
+			f.fail(consumed)
+			return
+		}
+		delete(consumed, name)
+	}
+}
+
+// fail records that the Store is failing, and restores the requests in
+// consumed that were not resynced, unless the storeFallback was shut down, in
+// which case the Store did not necessarily fail.
+func (f *storeFallback) fail(consumed map[string]*fallbackUsage) {
+	if f.ctx.Err() != nil {
+		return
+	}
+	f.failing.Store(true)
+	f.restore(consumed)
+}
+
+// restore records the requests in consumed that were not resynced, along
+// with any that were recorded since.
+func (f *storeFallback) restore(consumed map[string]*fallbackUsage) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for name, u := range consumed {
+		switch cur, ok := f.consumed[name]; {
+		case !ok:
+			if len(f.consumed) >= f.maxSize {
+				f.dropMetric.Add(1)
+				continue
+			}
+			f.consumed[name] = u
+		case cur.quota == u.quota && cur.windowEnd == u.windowEnd:
+			cur.n += u.n
+		}
+	}
+}
+
+// shutdown stops resyncing, and waits for the goroutines that are resyncing
+// to return.
+func (f *storeFallback) shutdown() {
+	f.cancel()
+	f.wg.Wait()
+}
@@ -0,0 +1,621 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStore is a Store that stores quotas in a map.
+type testStore struct {
+	clock Clock
+
+	mu     sync.Mutex
+	quotas map[string]QuotaState
+	// keys are the keys of each call to CheckAndConsume.
+	keys [][]Key
+	err  error
+}
+
+func newTestStore(c Clock) *testStore {
+	return &testStore{clock: c, quotas: make(map[string]QuotaState)}
+}
+
+func (s *testStore) CheckAndConsume(_ context.Context, keys []Key, limits []*Limited, n uint64) (Decision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return Decision{}, s.err
+	}
+	s.keys = append(s.keys, append([]Key(nil), keys...))
+
+	now := s.clock.Now()
+	d := Decision{Allowed: true, Quotas: make([]QuotaState, len(keys))}
+	for i, k := range keys {
+		q, ok := s.quotas[k.Name]
+		if !ok || now.After(q.ExpiresAt) {
+			q = QuotaState{ExpiresAt: now.Add(limits[i].Period)}
+		}
+		if max := limits[i].MaxRequests + limits[i].GraceRequests; q.Used+n > max {
+			d.Allowed = false
+		}
+		d.Quotas[i] = q
+	}
+	if d.Allowed {
+		for i, k := range keys {
+			d.Quotas[i].Used += n
+			s.quotas[k.Name] = d.Quotas[i]
+		}
+	}
+	return d, nil
+}
+
+func TestLimiterStore(t *testing.T) {
+	limits := []Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			MaxRequests: 3,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerAuthToken,
+			MaxRequests: 2,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "other",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 10,
+			Period:      time.Minute,
+		},
+		&Unlimited{
+			Resource: "other",
+			Action:   "action",
+			Per:      LimitPerIPAddress,
+		},
+		&Unlimited{
+			Resource: "other",
+			Action:   "action",
+			Per:      LimitPerAuthToken,
+		},
+	}
+	c := newFakeClock()
+	s := newTestStore(c)
+
+	// The Limiters share the quotas in the Store.
+	l1, err := NewLimiter(limits, 10, WithClock(c), WithStore(s))
+	require.NoError(t, err)
+	defer l1.Shutdown()
+	l2, err := NewLimiter(limits, 10, WithClock(c), WithStore(s))
+	require.NoError(t, err)
+	defer l2.Shutdown()
+
+	allowed, q, err := l1.Allow("resource", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	require.NotNil(t, q)
+	assert.Equal(t, LimitPerAuthToken, q.limit.Per)
+	assert.Equal(t, uint64(1), q.Remaining())
+	assert.Equal(t, time.Minute, q.ResetsIn())
+
+	require.Len(t, s.keys, 1)
+	assert.Equal(t, []Key{
+		{Name: quotaKey(limits[0].(*Limited), "total"), Per: LimitPerTotal, ID: "total"},
+		{Name: quotaKey(limits[1].(*Limited), "127.0.0.1"), Per: LimitPerIPAddress, ID: "127.0.0.1"},
+		{Name: quotaKey(limits[2].(*Limited), "token"), Per: LimitPerAuthToken, ID: "token"},
+	}, s.keys[0])
+
+	allowed, _, err = l2.Allow("resource", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	// The auth token's quota is exhausted, so no quotas are consumed from.
+	allowed, q, err = l1.Allow("resource", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	require.NotNil(t, q)
+	assert.Equal(t, LimitPerAuthToken, q.limit.Per)
+	assert.Equal(t, uint64(2), s.quotas[quotaKey(limits[1].(*Limited), "127.0.0.1")].Used)
+
+	allowed, q, err = l2.Allow("resource", "action", "127.0.0.1", "other-token")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, LimitPerIPAddress, q.limit.Per)
+	assert.Equal(t, uint64(0), q.Remaining())
+
+	// Unlimited limits are not checked via the Store.
+	allowed, _, err = l1.Allow("other", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, []Key{
+		{Name: quotaKey(limits[3].(*Limited), "total"), Per: LimitPerTotal, ID: "total"},
+	}, s.keys[len(s.keys)-1])
+
+	t.Run("refund-and-charge", func(t *testing.T) {
+		err := l1.Refund("resource", "action", "127.0.0.1", "token", 1)
+		assert.ErrorIs(t, err, ErrNotSupported)
+		err = l1.Charge("resource", "action", "127.0.0.1", "token", 1)
+		assert.ErrorIs(t, err, ErrNotSupported)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		storeErr := errors.New("store error")
+		s.err = storeErr
+		defer func() { s.err = nil }()
+		allowed, q, err := l1.Allow("resource", "action", "127.0.0.1", "token")
+		assert.ErrorIs(t, err, storeErr)
+		assert.False(t, allowed)
+		assert.Nil(t, q)
+	})
+}
+
+func TestLimiterStoreShutdown(t *testing.T) {
+	s := newTestStore(newFakeClock())
+	l, err := NewLimiter([]Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 10,
+			Period:      time.Minute,
+		},
+	}, 10, WithStore(s))
+	require.NoError(t, err)
+
+	allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	// The Store is not called once the Limiter is shut down.
+	require.NoError(t, l.Shutdown())
+	allowed, _, err = l.Allow("resource", "action", "127.0.0.1", "token")
+	assert.ErrorIs(t, err, ErrStopped)
+	assert.False(t, allowed)
+	assert.Len(t, s.keys, 1)
+}
+
+func TestLimiterStoreInvalidDecision(t *testing.T) {
+	l, err := NewLimiter([]Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 10,
+			Period:      time.Minute,
+		},
+	}, 10, WithStore(storeFunc(func(context.Context, []Key, []*Limited, uint64) (Decision, error) {
+		return Decision{Allowed: true}, nil
+	})))
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "token")
+	assert.ErrorIs(t, err, ErrInvalidParameter)
+	assert.False(t, allowed)
+}
+
+func TestLimiterStoreContext(t *testing.T) {
+	var calls int
+	l, err := NewLimiter([]Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 10,
+			Period:      time.Minute,
+		},
+	}, 10, WithStore(storeFunc(func(ctx context.Context, keys []Key, _ []*Limited, _ uint64) (Decision, error) {
+		calls++
+		// The Store is passed the ctx of the request.
+		assert.Equal(t, "value", ctx.Value(ctxKey{}))
+		return Decision{Allowed: true, Quotas: make([]QuotaState, len(keys))}, nil
+	})))
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	allowed, _, err := l.AllowContext(ctx, "resource", "action", "127.0.0.1", "token")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, allowed)
+	assert.Zero(t, calls)
+
+	ctx = context.WithValue(context.Background(), ctxKey{}, "value")
+	allowed, _, err = l.AllowContext(ctx, "resource", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, 1, calls)
+}
+
+// ctxKey is the key of a context value passed to a Store.
+type ctxKey struct{}
+
+func TestLimiterStorePer(t *testing.T) {
+	limits := []Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			MaxRequests: 100,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			MaxRequests: 3,
+			Period:      time.Minute,
+		},
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerAuthToken,
+			MaxRequests: 2,
+			Period:      time.Minute,
+		},
+	}
+	c := newFakeClock()
+	ipStore, tokenStore := newTestStore(c), newTestStore(c)
+
+	// The quotas for the total are stored in the Limiter, and the quotas for
+	// IP addresses and auth tokens are stored in separate Stores.
+	l, err := NewLimiter(limits, 10, WithClock(c), WithStorePer(LimitPerIPAddress, ipStore), WithStorePer(LimitPerAuthToken, tokenStore))
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	allowed, q, err := l.Allow("resource", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, LimitPerAuthToken, q.limit.Per)
+	assert.Equal(t, uint64(1), q.Remaining())
+	assert.Equal(t, [][]Key{{{Name: quotaKey(limits[1].(*Limited), "127.0.0.1"), Per: LimitPerIPAddress, ID: "127.0.0.1"}}}, ipStore.keys)
+	assert.Equal(t, [][]Key{{{Name: quotaKey(limits[2].(*Limited), "token"), Per: LimitPerAuthToken, ID: "token"}}}, tokenStore.keys)
+	total := l.policies.Load().m[policyKey{"resource", "action"}].total.Load()
+	require.NotNil(t, total)
+	assert.Equal(t, uint64(99), total.Remaining())
+
+	allowed, _, err = l.Allow("resource", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	// The auth token's Store denies the request, so the total is not
+	// consumed from, but the IP address's Store has already consumed from
+	// its quota.
+	allowed, q, err = l.Allow("resource", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, LimitPerAuthToken, q.limit.Per)
+	assert.Equal(t, uint64(98), total.Remaining())
+	assert.Equal(t, uint64(3), ipStore.quotas[quotaKey(limits[1].(*Limited), "127.0.0.1")].Used)
+
+	// The quotas stored in the Limiter can be modified, unlike those in a
+	// Store.
+	assert.ErrorIs(t, l.ExtendQuota(LimitPerIPAddress, "127.0.0.1", time.Minute), ErrNotSupported)
+	assert.ErrorIs(t, l.ResetQuota("resource", "action", LimitPerAuthToken, "token"), ErrNotSupported)
+	require.NoError(t, l.ResetQuota("resource", "action", LimitPerTotal, ""))
+	assert.ErrorIs(t, l.Refund("resource", "action", "127.0.0.1", "token", 1), ErrNotSupported)
+
+	// A Store provided via WithStorePer is used instead of the one provided
+	// via WithStore for its LimitPer.
+	shared := newTestStore(c)
+	l, err = NewLimiter(limits, 10, WithClock(c), WithStore(shared), WithStorePer(LimitPerTotal, newTestStore(c)))
+	require.NoError(t, err)
+	defer l.Shutdown()
+	allowed, _, err = l.Allow("resource", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	require.Len(t, shared.keys, 1)
+	assert.Len(t, shared.keys[0], 2)
+
+	_, err = NewLimiter(limits, 10, WithStorePer(LimitPer("invalid"), shared))
+	assert.ErrorIs(t, err, ErrInvalidLimitPer)
+	_, err = NewLimiter(limits, 10, WithStorePer(LimitPerTotal, nil))
+	assert.ErrorIs(t, err, ErrInvalidParameter)
+}
+
+func TestLimiterStoreFailureMode(t *testing.T) {
+	limits := []Limit{
+		&Limited{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			MaxRequests: 3,
+			Period:      time.Minute,
+		},
+	}
+	key := quotaKey(limits[0].(*Limited), "127.0.0.1")
+	unavailable := errors.New("unavailable")
+
+	t.Run("closed", func(t *testing.T) {
+		s := newTestStore(newFakeClock())
+		s.err = unavailable
+		l, err := NewLimiter(limits, 10, WithStore(s))
+		require.NoError(t, err)
+		defer l.Shutdown()
+
+		allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "token")
+		assert.ErrorIs(t, err, unavailable)
+		assert.False(t, allowed)
+	})
+
+	t.Run("open", func(t *testing.T) {
+		s := newTestStore(newFakeClock())
+		s.err = unavailable
+		l, err := NewLimiter(limits, 10, WithStore(s), WithStoreFailureMode(StoreFailOpen))
+		require.NoError(t, err)
+		defer l.Shutdown()
+
+		for i := 0; i < 5; i++ {
+			allowed, q, err := l.Allow("resource", "action", "127.0.0.1", "token")
+			require.NoError(t, err)
+			assert.True(t, allowed)
+			assert.Nil(t, q)
+		}
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		c := newFakeClock()
+		s := newTestStore(c)
+		s.err = unavailable
+		l, err := NewLimiter(limits, 10, WithClock(c), WithStore(s), WithStoreFailureMode(StoreFallbackLocal))
+		require.NoError(t, err)
+		defer l.Shutdown()
+
+		// The requests are limited by the Limiter's quotas while the Store
+		// is failing.
+		for i := 0; i < 2; i++ {
+			allowed, q, err := l.Allow("resource", "action", "127.0.0.1", "token")
+			require.NoError(t, err)
+			assert.True(t, allowed)
+			assert.Equal(t, uint64(3-i-1), q.Remaining())
+		}
+
+		// Once the Store recovers, the requests consumed while it was
+		// failing are consumed from its quota.
+		s.mu.Lock()
+		s.err = nil
+		s.mu.Unlock()
+		allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "token")
+		require.NoError(t, err)
+		assert.True(t, allowed)
+		waitResync(l)
+		assert.Equal(t, uint64(3), s.quotas[key].Used)
+		assert.Len(t, s.keys, 2)
+
+		allowed, _, err = l.Allow("resource", "action", "127.0.0.1", "token")
+		require.NoError(t, err)
+		assert.False(t, allowed)
+		assert.Len(t, s.keys, 3)
+
+		// The Limiter's quota only includes the requests consumed while
+		// the Store was failing, and the requests consumed while it fails
+		// again are not consumed from its quota if they would exceed it.
+		s.mu.Lock()
+		s.err = unavailable
+		s.mu.Unlock()
+		allowed, _, err = l.Allow("resource", "action", "127.0.0.1", "token")
+		require.NoError(t, err)
+		assert.True(t, allowed)
+		s.mu.Lock()
+		s.err = nil
+		s.mu.Unlock()
+		allowed, _, err = l.Allow("resource", "action", "127.0.0.1", "token")
+		require.NoError(t, err)
+		assert.False(t, allowed)
+		waitResync(l)
+		assert.Equal(t, uint64(3), s.quotas[key].Used)
+		assert.Len(t, s.keys, 5)
+	})
+
+	t.Run("fallback-expired", func(t *testing.T) {
+		c := newFakeClock()
+		s := newTestStore(c)
+		s.err = unavailable
+		l, err := NewLimiter(limits, 10, WithClock(c), WithStore(s), WithStoreFailureMode(StoreFallbackLocal))
+		require.NoError(t, err)
+		defer l.Shutdown()
+
+		allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "token")
+		require.NoError(t, err)
+		assert.True(t, allowed)
+
+		// The requests consumed in a window that has ended are not resynced.
+		c.Advance(time.Minute + time.Second)
+		s.mu.Lock()
+		s.err = nil
+		s.mu.Unlock()
+		allowed, _, err = l.Allow("resource", "action", "127.0.0.1", "token")
+		require.NoError(t, err)
+		assert.True(t, allowed)
+		waitResync(l)
+		assert.Equal(t, uint64(1), s.quotas[key].Used)
+		assert.Len(t, s.keys, 1)
+	})
+
+	t.Run("fallback-resync-error", func(t *testing.T) {
+		// calls is the number of calls to the Store, and consumed are the
+		// number of requests consumed by each successful call.
+		var calls int
+		var consumed []uint64
+		s := storeFunc(func(_ context.Context, keys []Key, _ []*Limited, n uint64) (Decision, error) {
+			calls++
+			switch calls {
+			case 1, 3:
+				return Decision{}, unavailable
+			}
+			consumed = append(consumed, n)
+			return Decision{Allowed: true, Quotas: make([]QuotaState, len(keys))}, nil
+		})
+		l, err := NewLimiter(limits, 10, WithStore(s), WithStoreFailureMode(StoreFallbackLocal))
+		require.NoError(t, err)
+		defer l.Shutdown()
+
+		for i := 0; i < 2; i++ {
+			allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "token")
+			require.NoError(t, err)
+			assert.True(t, allowed)
+			waitResync(l)
+		}
+		// The Store failed while resyncing, so the requests are resynced
+		// after the next call that succeeds.
+		assert.Equal(t, []uint64{1}, consumed)
+		assert.Equal(t, 3, calls)
+
+		allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "token")
+		require.NoError(t, err)
+		assert.True(t, allowed)
+		waitResync(l)
+		assert.Equal(t, []uint64{1, 1, 1}, consumed)
+	})
+
+	t.Run("fallback-concurrent", func(t *testing.T) {
+		s := newTestStore(newFakeClock())
+		s.err = unavailable
+		l, err := NewLimiter(limits, 10, WithStore(s), WithStoreFailureMode(StoreFallbackLocal))
+		require.NoError(t, err)
+		defer l.Shutdown()
+
+		// The Limiter's quotas are checked and consumed atomically, so
+		// concurrent requests are not allowed in excess of the limit.
+		var allowed atomic.Int64
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ok, _, err := l.Allow("resource", "action", "127.0.0.1", "token")
+				assert.NoError(t, err)
+				if ok {
+					allowed.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int64(3), allowed.Load())
+	})
+
+	t.Run("fallback-resync-shutdown", func(t *testing.T) {
+		// calls is the number of calls to the Store, and resyncing is
+		// closed once it is called to resync.
+		var calls atomic.Int64
+		resyncing := make(chan struct{})
+		s := storeFunc(func(ctx context.Context, keys []Key, _ []*Limited, _ uint64) (Decision, error) {
+			switch calls.Add(1) {
+			case 1:
+				return Decision{}, unavailable
+			case 2:
+				return Decision{Allowed: true, Quotas: make([]QuotaState, len(keys))}, nil
+			}
+			// The resync waits until its ctx is done.
+			close(resyncing)
+			<-ctx.Done()
+			return Decision{}, ctx.Err()
+		})
+		l, err := NewLimiter(limits, 10, WithStore(s), WithStoreFailureMode(StoreFallbackLocal))
+		require.NoError(t, err)
+
+		for i := 0; i < 2; i++ {
+			allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "token")
+			require.NoError(t, err)
+			assert.True(t, allowed)
+		}
+
+		// The request that succeeded does not wait for the resync, which
+		// is stopped once the Limiter is shut down. The Store is not
+		// considered to be failing because the resync was stopped.
+		select {
+		case <-resyncing:
+		case <-time.After(time.Second):
+			require.FailNow(t, "timed out waiting for resync")
+		}
+		require.NoError(t, l.Shutdown())
+		f := l.stores[LimitPerIPAddress].fallback
+		assert.False(t, f.failing.Load())
+		assert.Empty(t, f.consumed)
+	})
+
+	t.Run("fallback-drop-metric", func(t *testing.T) {
+		s := newTestStore(newFakeClock())
+		s.err = unavailable
+		dropped := &testCounter{}
+		l, err := NewLimiter(limits, 10, WithStore(s), WithStoreFailureMode(StoreFallbackLocal), WithStoreFallbackDropMetric(dropped))
+		require.NoError(t, err)
+		defer l.Shutdown()
+		f := l.stores[LimitPerIPAddress].fallback
+		f.maxSize = 1
+
+		// Only the usage of maxSize quotas is recorded to be resynced, and
+		// the requests for other quotas are counted as dropped.
+		for _, ip := range []string{"127.0.0.1", "127.0.0.2", "127.0.0.2"} {
+			allowed, _, err := l.Allow("resource", "action", ip, "token")
+			require.NoError(t, err)
+			assert.True(t, allowed)
+		}
+		assert.Len(t, f.consumed, 1)
+		assert.Equal(t, float64(2), dropped.v)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewLimiter(limits, 10, WithStoreFailureMode(StoreFailureMode(-1)))
+		assert.ErrorIs(t, err, ErrInvalidParameter)
+	})
+}
+
+// waitResync waits for the Limiter to finish resyncing the requests that were
+// consumed while its Stores were failing.
+func waitResync(l *Limiter) {
+	for _, s := range l.stores {
+		if s.fallback != nil {
+			s.fallback.wg.Wait()
+		}
+	}
+}
+
+// storeFunc is a Store that calls the function.
+type storeFunc func(context.Context, []Key, []*Limited, uint64) (Decision, error)
+
+func (f storeFunc) CheckAndConsume(ctx context.Context, keys []Key, limits []*Limited, n uint64) (Decision, error) {
+	return f(ctx, keys, limits, n)
+}
+
+func TestQuotaStateQuota(t *testing.T) {
+	limit := &Limited{
+		Resource:      "resource",
+		Action:        "action",
+		Per:           LimitPerIPAddress,
+		MaxRequests:   10,
+		Period:        time.Minute,
+		GraceRequests: 2,
+	}
+	expiresAt := time.Now().Add(30 * time.Second)
+	q := QuotaState{Used: 11, ExpiresAt: expiresAt}.Quota(limit)
+
+	assert.Equal(t, uint64(10), q.MaxRequests())
+	assert.Equal(t, uint64(0), q.Remaining())
+	assert.Equal(t, uint64(1), q.GraceUsed())
+	// The expiration is measured using the monotonic clock, so its wall
+	// clock time can differ from expiresAt by the drift between the clocks.
+	assert.WithinDuration(t, expiresAt, q.Expiration(), time.Millisecond)
+	assert.False(t, q.Expired())
+}
@@ -0,0 +1,194 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// resolveTemplates returns the limits with the MaxRequests of each Limited
+// with a MaxRequestsExpr set to the result of evaluating the expression with
+// the provided variables, rounded down. The provided limits are not modified.
+// A Limited with a MaxRequestsExpr must not set MaxRequests itself, and its
+// expression must be valid and evaluate to at least one, otherwise
+// ErrInvalidLimit is returned.
+func resolveTemplates(limits []Limit, vars map[string]float64) ([]Limit, error) {
+	resolved := make([]Limit, 0, len(limits))
+	for _, l := range limits {
+		ll, ok := l.(*Limited)
+		if !ok || ll.MaxRequestsExpr == "" {
+			resolved = append(resolved, l)
+			continue
+		}
+		if ll.MaxRequests != 0 {
+			return nil, fmt.Errorf("%w: limit with max requests expression cannot set max requests", ErrInvalidLimit)
+		}
+		v, err := evalExpr(ll.MaxRequestsExpr, vars)
+		if err != nil {
+			return nil, fmt.Errorf("%w: max requests expression %q: %s", ErrInvalidLimit, ll.MaxRequestsExpr, err)
+		}
+		v = math.Floor(v)
+		switch {
+		case math.IsNaN(v), v < 1:
+			return nil, fmt.Errorf("%w: max requests expression %q must be at least one, got %v", ErrInvalidLimit, ll.MaxRequestsExpr, v)
+		case v >= math.MaxUint64:
+			return nil, fmt.Errorf("%w: max requests expression %q is too large", ErrInvalidLimit, ll.MaxRequestsExpr)
+		}
+		r := *ll
+		r.MaxRequests = uint64(v)
+		resolved = append(resolved, &r)
+	}
+	return resolved, nil
+}
+
+// evalExpr evaluates an arithmetic expression of numbers and variables, such
+// as "per_node_rps * nodes / 2". The operators +, -, *, and / are supported,
+// along with parentheses and unary minus, using the usual precedence.
+func evalExpr(expr string, vars map[string]float64) (float64, error) {
+	p := &exprParser{s: expr, vars: vars}
+	v, err := p.parseSum()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.s) {
+		return 0, fmt.Errorf("unexpected %q at offset %d", p.s[p.pos], p.pos)
+	}
+	return v, nil
+}
+
+// exprParser is a recursive descent parser for the expressions evaluated by
+// evalExpr.
+type exprParser struct {
+	s    string
+	pos  int
+	vars map[string]float64
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// peek returns the next non-space byte, or zero at the end of the
+// expression.
+func (p *exprParser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+// parseSum parses terms separated by + and -.
+func (p *exprParser) parseSum() (float64, error) {
+	v, err := p.parseProduct()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		switch c := p.peek(); c {
+		case '+', '-':
+			p.pos++
+			r, err := p.parseProduct()
+			if err != nil {
+				return 0, err
+			}
+			if c == '+' {
+				v += r
+			} else {
+				v -= r
+			}
+		default:
+			return v, nil
+		}
+	}
+}
+
+// parseProduct parses factors separated by * and /.
+func (p *exprParser) parseProduct() (float64, error) {
+	v, err := p.parseFactor()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		switch c := p.peek(); c {
+		case '*', '/':
+			p.pos++
+			r, err := p.parseFactor()
+			if err != nil {
+				return 0, err
+			}
+			if c == '*' {
+				v *= r
+				continue
+			}
+			if r == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			v /= r
+		default:
+			return v, nil
+		}
+	}
+}
+
+// parseFactor parses a number, a variable, a parenthesized expression, or a
+// negated factor.
+func (p *exprParser) parseFactor() (float64, error) {
+	c := p.peek()
+	switch {
+	case c == 0:
+		return 0, fmt.Errorf("unexpected end of expression")
+	case c == '-':
+		p.pos++
+		v, err := p.parseFactor()
+		return -v, err
+	case c == '(':
+		p.pos++
+		v, err := p.parseSum()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, fmt.Errorf("missing ) at offset %d", p.pos)
+		}
+		p.pos++
+		return v, nil
+	case isDigit(c) || c == '.':
+		start := p.pos
+		for p.pos < len(p.s) && (isDigit(p.s[p.pos]) || p.s[p.pos] == '.') {
+			p.pos++
+		}
+		v, err := strconv.ParseFloat(p.s[start:p.pos], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number %q", p.s[start:p.pos])
+		}
+		return v, nil
+	case isIdentStart(c):
+		start := p.pos
+		for p.pos < len(p.s) && (isIdentStart(p.s[p.pos]) || isDigit(p.s[p.pos])) {
+			p.pos++
+		}
+		name := p.s[start:p.pos]
+		v, ok := p.vars[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown variable %q", name)
+		}
+		return v, nil
+	default:
+		return 0, fmt.Errorf("unexpected %q at offset %d", c, p.pos)
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
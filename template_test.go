@@ -0,0 +1,187 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvalExpr(t *testing.T) {
+	vars := map[string]float64{
+		"per_node_rps": 50,
+		"nodes":        3,
+		"fraction":     0.5,
+		"zero":         0,
+	}
+
+	cases := []struct {
+		expr    string
+		want    float64
+		wantErr bool
+	}{
+		{"10", 10, false},
+		{"1.5", 1.5, false},
+		{"nodes", 3, false},
+		{"per_node_rps * nodes", 150, false},
+		{"  per_node_rps*nodes  ", 150, false},
+		{"per_node_rps * nodes * fraction", 75, false},
+		{"1 + 2 * 3", 7, false},
+		{"(1 + 2) * 3", 9, false},
+		{"10 - 2 - 3", 5, false},
+		{"12 / 2 / 3", 2, false},
+		{"-nodes + 10", 7, false},
+		{"-(nodes - 10)", 7, false},
+		{"", 0, true},
+		{"nodes *", 0, true},
+		{"(nodes", 0, true},
+		{"nodes)", 0, true},
+		{"nodes nodes", 0, true},
+		{"unknown * 2", 0, true},
+		{"1.2.3", 0, true},
+		{"nodes / zero", 0, true},
+		{"nodes % 2", 0, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.expr, func(t *testing.T) {
+			got, err := evalExpr(tc.expr, vars)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestResolveTemplates(t *testing.T) {
+	vars := map[string]float64{"per_node_rps": 2.5, "nodes": 3}
+
+	cases := []struct {
+		name    string
+		limits  []Limit
+		want    []Limit
+		wantErr error
+	}{
+		{
+			"NoExpr",
+			[]Limit{
+				&Limited{Resource: "r", Action: "a", Per: LimitPerTotal, MaxRequests: 5, Period: time.Second},
+				&Unlimited{Resource: "r", Action: "a", Per: LimitPerIPAddress},
+			},
+			[]Limit{
+				&Limited{Resource: "r", Action: "a", Per: LimitPerTotal, MaxRequests: 5, Period: time.Second},
+				&Unlimited{Resource: "r", Action: "a", Per: LimitPerIPAddress},
+			},
+			nil,
+		},
+		{
+			"RoundedDown",
+			[]Limit{
+				&Limited{Resource: "r", Action: "a", Per: LimitPerTotal, MaxRequestsExpr: "per_node_rps * nodes", Period: time.Second},
+			},
+			[]Limit{
+				&Limited{Resource: "r", Action: "a", Per: LimitPerTotal, MaxRequestsExpr: "per_node_rps * nodes", MaxRequests: 7, Period: time.Second},
+			},
+			nil,
+		},
+		{
+			"WithMaxRequests",
+			[]Limit{
+				&Limited{Resource: "r", Action: "a", Per: LimitPerTotal, MaxRequestsExpr: "nodes", MaxRequests: 3, Period: time.Second},
+			},
+			nil,
+			ErrInvalidLimit,
+		},
+		{
+			"InvalidExpr",
+			[]Limit{
+				&Limited{Resource: "r", Action: "a", Per: LimitPerTotal, MaxRequestsExpr: "nodes *", Period: time.Second},
+			},
+			nil,
+			ErrInvalidLimit,
+		},
+		{
+			"UnknownVariable",
+			[]Limit{
+				&Limited{Resource: "r", Action: "a", Per: LimitPerTotal, MaxRequestsExpr: "regions", Period: time.Second},
+			},
+			nil,
+			ErrInvalidLimit,
+		},
+		{
+			"LessThanOne",
+			[]Limit{
+				&Limited{Resource: "r", Action: "a", Per: LimitPerTotal, MaxRequestsExpr: "nodes / 4", Period: time.Second},
+			},
+			nil,
+			ErrInvalidLimit,
+		},
+		{
+			"Negative",
+			[]Limit{
+				&Limited{Resource: "r", Action: "a", Per: LimitPerTotal, MaxRequestsExpr: "1 - nodes", Period: time.Second},
+			},
+			nil,
+			ErrInvalidLimit,
+		},
+		{
+			"TooLarge",
+			[]Limit{
+				&Limited{Resource: "r", Action: "a", Per: LimitPerTotal, MaxRequestsExpr: "100000000000 * 100000000000", Period: time.Second},
+			},
+			nil,
+			ErrInvalidLimit,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := resolveTemplates(tc.limits, vars)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestLimiterTemplateVariables(t *testing.T) {
+	limits := []Limit{
+		&Limited{Resource: "resource", Action: "action", Per: LimitPerTotal, MaxRequestsExpr: "per_node_rps * nodes", Period: time.Minute},
+		&Limited{Resource: "resource", Action: "action", Per: LimitPerIPAddress, MaxRequests: 100, Period: time.Minute},
+		&Limited{Resource: "resource", Action: "action", Per: LimitPerAuthToken, MaxRequests: 100, Period: time.Minute},
+	}
+
+	t.Run("MissingVariables", func(t *testing.T) {
+		_, err := NewLimiter(limits, 10)
+		assert.ErrorIs(t, err, ErrInvalidLimit)
+	})
+
+	l, err := NewLimiter(limits, 10, WithTemplateVariables(map[string]float64{"per_node_rps": 1, "nodes": 2}))
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	for i := 0; i < 2; i++ {
+		allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "token")
+		require.NoError(t, err)
+		assert.True(t, allowed)
+	}
+	allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "token")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	// The variables are used for the limits provided to Reload.
+	require.NoError(t, l.Reload([]Limit{
+		&Limited{Resource: "resource", Action: "action", Per: LimitPerTotal, MaxRequestsExpr: "per_node_rps * nodes * 3", Period: time.Minute},
+	}))
+	_, ll, ok := l.policies.Load().limited("resource", "action", LimitPerTotal)
+	require.True(t, ok)
+	assert.Equal(t, uint64(6), ll.MaxRequests)
+}
@@ -0,0 +1,61 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// throttleMinWait is the minimum time that Throttle waits before checking a
+// request again, so that it does not spin while a quota is about to reset.
+const throttleMinWait = time.Millisecond
+
+// Throttle waits until a request for the resource and action is allowed for
+// the tenant with the provided id, and consumes a request from each of the
+// associated quotas. It is intended for background processors, such as
+// message queue consumers, so that they can share the same limits as the
+// services that handle API requests, but wait for capacity rather than deny
+// work.
+//
+// The id is used as the request's auth token, so LimitPerAuthToken limits are
+// applied per tenant, and the request does not have an IP address.
+//
+// When the request is denied, Throttle waits until the exhausted quota
+// resets, or until the Limiter expects to have space if it is full, and then
+// checks the request again. If ctx is done first, its error is returned. Any
+// other error returned by AllowContext, such as ErrLimitPolicyNotFound or
+// ErrStopped, is returned without waiting.
+func (l *Limiter) Throttle(ctx context.Context, resource, action, id string) error {
+	const op = "rate.(Limiter).Throttle"
+
+	for {
+		var wait time.Duration
+		_, err := l.allowNErr(ctx, resource, action, "", id, 1)
+		var limited *ErrRateLimited
+		var full *ErrLimiterFull
+		switch {
+		case err == nil:
+			return nil
+		case errors.As(err, &limited):
+			wait = limited.RetryAfter()
+			limited.Release()
+		case errors.As(err, &full):
+			wait = full.RetryIn
+		default:
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if wait < throttleMinWait {
+			wait = throttleMinWait
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: %w", op, ctx.Err())
+		case <-l.clock.After(wait):
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterThrottle(t *testing.T) {
+	c := newFakeClock()
+	l, err := NewLimiter(
+		[]Limit{
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerTotal,
+				MaxRequests: 10,
+				Period:      time.Minute,
+			},
+			&Unlimited{
+				Resource: "resource",
+				Action:   "action",
+				Per:      LimitPerIPAddress,
+			},
+			&Limited{
+				Resource:    "resource",
+				Action:      "action",
+				Per:         LimitPerAuthToken,
+				MaxRequests: 1,
+				Period:      time.Minute,
+			},
+		},
+		10,
+		WithClock(c),
+	)
+	require.NoError(t, err)
+	defer l.Shutdown()
+
+	ctx := context.Background()
+	require.NoError(t, l.Throttle(ctx, "resource", "action", "tenant-a"))
+	// Each tenant has its own quota.
+	require.NoError(t, l.Throttle(ctx, "resource", "action", "tenant-b"))
+
+	// The tenant's quota is exhausted, so Throttle waits until it resets.
+	waiters := c.Waiters()
+	done := make(chan error, 1)
+	go func() { done <- l.Throttle(ctx, "resource", "action", "tenant-a") }()
+	require.Eventually(t, func() bool { return c.Waiters() > waiters }, time.Second, time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("Throttle returned before the quota reset: %v", err)
+	default:
+	}
+
+	c.Advance(time.Minute + throttleMinWait)
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Throttle did not return after the quota reset")
+	}
+
+	t.Run("ContextDone", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := l.Throttle(ctx, "resource", "action", "tenant-a")
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+	t.Run("PolicyNotFound", func(t *testing.T) {
+		err := l.Throttle(ctx, "unknown", "action", "tenant-a")
+		assert.ErrorIs(t, err, ErrLimitPolicyNotFound)
+	})
+}
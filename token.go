@@ -0,0 +1,29 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import "strings"
+
+// AuthTokenNormalizer returns the identity that is used for the quota of the
+// given auth token. It can be used to key quotas by the principal that a
+// token belongs to rather than by the raw token, so that quotas are not reset
+// when a token is rotated. If an empty string is returned, the request is
+// treated as not having an auth token.
+type AuthTokenNormalizer func(authToken string) string
+
+// bearerPrefix is the authentication scheme that is removed by
+// NormalizeBearerToken.
+const bearerPrefix = "bearer "
+
+// NormalizeBearerToken removes surrounding whitespace, a case-insensitive
+// "Bearer " authentication scheme prefix, and any trailing base64 padding
+// from the provided auth token. It can be used as an AuthTokenNormalizer, or
+// as the first step of one that maps tokens to principals.
+func NormalizeBearerToken(authToken string) string {
+	authToken = strings.TrimSpace(authToken)
+	if len(authToken) >= len(bearerPrefix) && strings.EqualFold(authToken[:len(bearerPrefix)], bearerPrefix) {
+		authToken = strings.TrimSpace(authToken[len(bearerPrefix):])
+	}
+	return strings.TrimRight(authToken, "=")
+}
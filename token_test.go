@@ -0,0 +1,33 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeBearerToken(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"", ""},
+		{"token", "token"},
+		{"Bearer token", "token"},
+		{"bearer token", "token"},
+		{"BEARER   token  ", "token"},
+		{"  Bearer token==", "token"},
+		{"dG9rZW4=", "dG9rZW4"},
+		{"Bearer", "Bearer"},
+		{"Bearertoken", "Bearertoken"},
+		{"Basic dG9rZW4=", "Basic dG9rZW4"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.in, func(t *testing.T) {
+			assert.Equal(t, tc.want, NormalizeBearerToken(tc.in))
+		})
+	}
+}
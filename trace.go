@@ -0,0 +1,128 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// TraceEvent describes how a request was evaluated by Allow, for debugging
+// why a request was allowed or denied.
+type TraceEvent struct {
+	Resource string
+	Action   string
+	// IP and AuthToken are the identity of the request, after they were
+	// normalized.
+	IP        string
+	AuthToken string
+	// Cost is the number of requests that the request cost.
+	Cost uint64
+
+	Allowed bool
+	// Err is the error returned by Allow, if any.
+	Err error
+
+	// Start is when the request started to be evaluated, and Duration is how
+	// long it took, using the Limiter's Clock.
+	Start    time.Time
+	Duration time.Duration
+
+	// Dimensions are the quotas that were checked, in the order they were
+	// checked. Limits that are Unlimited, or that were skipped for the
+	// request, are not included.
+	Dimensions []TraceDimension
+}
+
+// TraceDimension describes how a single quota was checked for a TraceEvent.
+type TraceDimension struct {
+	Per LimitPer
+	// ID is the IP address or auth token for LimitPerIPAddress and
+	// LimitPerAuthToken, and "total" for LimitPerTotal.
+	ID string
+	// Key is the key of the quota in the Limiter.
+	Key string
+
+	// MaxRequests and Period are the effective limit of the quota.
+	MaxRequests uint64
+	Period      time.Duration
+	// RemainingBefore and RemainingAfter are the remaining requests of the
+	// quota before it was checked, and after the request was evaluated.
+	// They are zero when the Limiter uses a Store, or the request was denied
+	// via the Limiter's denial cache.
+	RemainingBefore uint64
+	RemainingAfter  uint64
+
+	// Denied is true if the quota caused the request to be denied, and
+	// Cached is true if it was denied via the Limiter's denial cache.
+	Denied bool
+	Cached bool
+}
+
+// TraceHook is called with a TraceEvent for each request evaluated by Allow
+// that is sampled. It is called synchronously by Allow, without holding any
+// of the Limiter's locks, so a TraceHook that blocks will delay the request.
+type TraceHook func(TraceEvent)
+
+// tracer samples the requests that are traced for a TraceHook.
+type tracer struct {
+	hook  TraceHook
+	every uint64
+	count atomic.Uint64
+}
+
+// newTracer returns a tracer that traces one in every requests. If every is
+// zero, each request is traced.
+func newTracer(hook TraceHook, every uint64) *tracer {
+	if every == 0 {
+		every = 1
+	}
+	return &tracer{hook: hook, every: every}
+}
+
+// sample reports whether the next request should be traced.
+func (t *tracer) sample() bool {
+	return (t.count.Add(1)-1)%t.every == 0
+}
+
+// trace records how a request is evaluated for a TraceEvent. The quotas of
+// its dimensions are kept, so that their remaining requests can be read
+// after the request is evaluated.
+type trace struct {
+	event  TraceEvent
+	quotas []*Quota
+}
+
+// add records that the quota for the limit was checked, and returns the
+// index of its dimension. The quota may be nil.
+func (t *trace) add(per LimitPer, id, key string, ll *Limited, q *Quota) int {
+	d := TraceDimension{
+		Per:         per,
+		ID:          id,
+		Key:         key,
+		MaxRequests: ll.MaxRequests,
+		Period:      ll.Period,
+	}
+	if q != nil {
+		d.MaxRequests = q.MaxRequests()
+		d.RemainingBefore = q.Remaining()
+	}
+	t.event.Dimensions = append(t.event.Dimensions, d)
+	t.quotas = append(t.quotas, q)
+	return len(t.event.Dimensions) - 1
+}
+
+// finish sets the result of the request and the remaining requests of each
+// dimension's quota.
+func (t *trace) finish(now time.Time, allowed bool, err error) TraceEvent {
+	t.event.Allowed = allowed
+	t.event.Err = err
+	t.event.Duration = now.Sub(t.event.Start)
+	for i, q := range t.quotas {
+		if q != nil {
+			t.event.Dimensions[i].RemainingAfter = q.Remaining()
+		}
+	}
+	return t.event
+}
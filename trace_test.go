@@ -0,0 +1,157 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracer_sample(t *testing.T) {
+	cases := []struct {
+		name  string
+		every uint64
+		want  []bool
+	}{
+		{"zero", 0, []bool{true, true, true}},
+		{"one", 1, []bool{true, true, true}},
+		{"three", 3, []bool{true, false, false, true, false, false, true}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tr := newTracer(func(TraceEvent) {}, tc.every)
+			for i, want := range tc.want {
+				assert.Equal(t, want, tr.sample(), "request %d", i)
+			}
+		})
+	}
+}
+
+func TestLimiterTraceHook(t *testing.T) {
+	newLimiter := func(t *testing.T, opt ...Option) (*Limiter, *[]TraceEvent) {
+		t.Helper()
+		var events []TraceEvent
+		l, err := NewLimiter(
+			[]Limit{
+				&Limited{
+					Resource:    "resource",
+					Action:      "action",
+					Per:         LimitPerTotal,
+					MaxRequests: 10,
+					Period:      time.Minute,
+				},
+				&Limited{
+					Resource:    "resource",
+					Action:      "action",
+					Per:         LimitPerIPAddress,
+					MaxRequests: 2,
+					Period:      time.Minute,
+				},
+				&Unlimited{
+					Resource: "resource",
+					Action:   "action",
+					Per:      LimitPerAuthToken,
+				},
+			},
+			10,
+			append([]Option{WithClock(newFakeClock()), WithTraceHook(func(e TraceEvent) { events = append(events, e) })}, opt...)...,
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { l.Shutdown() })
+		return l, &events
+	}
+	dimension := func(t *testing.T, e TraceEvent, per LimitPer) TraceDimension {
+		t.Helper()
+		for _, d := range e.Dimensions {
+			if d.Per == per {
+				return d
+			}
+		}
+		require.Failf(t, "missing dimension", "%s", per)
+		return TraceDimension{}
+	}
+
+	t.Run("allowed", func(t *testing.T) {
+		l, events := newLimiter(t)
+		allowed, _, err := l.AllowN("resource", "action", "127.0.0.1", "token", 2)
+		require.NoError(t, err)
+		require.True(t, allowed)
+
+		require.Len(t, *events, 1)
+		e := (*events)[0]
+		assert.Equal(t, "resource", e.Resource)
+		assert.Equal(t, "action", e.Action)
+		assert.Equal(t, "127.0.0.1", e.IP)
+		assert.Equal(t, "token", e.AuthToken)
+		assert.Equal(t, uint64(2), e.Cost)
+		assert.True(t, e.Allowed)
+		assert.NoError(t, e.Err)
+		require.Len(t, e.Dimensions, 2)
+
+		total := dimension(t, e, LimitPerTotal)
+		assert.Equal(t, string(LimitPerTotal), total.ID)
+		assert.Equal(t, uint64(10), total.MaxRequests)
+		assert.Equal(t, time.Minute, total.Period)
+		assert.Equal(t, uint64(10), total.RemainingBefore)
+		assert.Equal(t, uint64(8), total.RemainingAfter)
+		assert.False(t, total.Denied)
+
+		ip := dimension(t, e, LimitPerIPAddress)
+		assert.Equal(t, "127.0.0.1", ip.ID)
+		assert.NotEmpty(t, ip.Key)
+		assert.Equal(t, uint64(2), ip.RemainingBefore)
+		assert.Equal(t, uint64(0), ip.RemainingAfter)
+	})
+	t.Run("denied", func(t *testing.T) {
+		l, events := newLimiter(t)
+		_, _, err := l.AllowN("resource", "action", "127.0.0.1", "", 2)
+		require.NoError(t, err)
+		allowed, _, err := l.Allow("resource", "action", "127.0.0.1", "")
+		require.NoError(t, err)
+		require.False(t, allowed)
+
+		require.Len(t, *events, 2)
+		e := (*events)[1]
+		assert.False(t, e.Allowed)
+		ip := dimension(t, e, LimitPerIPAddress)
+		assert.True(t, ip.Denied)
+		assert.False(t, ip.Cached)
+		assert.Equal(t, uint64(0), ip.RemainingBefore)
+		assert.Equal(t, uint64(0), ip.RemainingAfter)
+	})
+	t.Run("denial-cache", func(t *testing.T) {
+		l, events := newLimiter(t, WithDenialCache(time.Second, 10))
+		for i := 0; i < 4; i++ {
+			_, _, err := l.Allow("resource", "action", "127.0.0.1", "")
+			require.NoError(t, err)
+		}
+
+		require.Len(t, *events, 4)
+		e := (*events)[3]
+		assert.False(t, e.Allowed)
+		ip := dimension(t, e, LimitPerIPAddress)
+		assert.True(t, ip.Denied)
+		assert.True(t, ip.Cached)
+	})
+	t.Run("error", func(t *testing.T) {
+		l, events := newLimiter(t)
+		_, _, err := l.Allow("unknown", "action", "127.0.0.1", "")
+		require.ErrorIs(t, err, ErrLimitPolicyNotFound)
+
+		require.Len(t, *events, 1)
+		assert.ErrorIs(t, (*events)[0].Err, ErrLimitPolicyNotFound)
+		assert.Empty(t, (*events)[0].Dimensions)
+	})
+	t.Run("sampling", func(t *testing.T) {
+		l, events := newLimiter(t, WithTraceSampling(2))
+		for i := 0; i < 4; i++ {
+			_, _, err := l.Allow("resource", "action", "127.0.0.1", "")
+			require.NoError(t, err)
+		}
+		assert.Len(t, *events, 2)
+	})
+}
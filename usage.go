@@ -0,0 +1,67 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// UsageRecord reports the number of requests that were used by a quota
+// during a single window. A record is exported once the window has ended, so
+// each window is only reported once.
+type UsageRecord struct {
+	Resource string   `json:"resource"`
+	Action   string   `json:"action"`
+	Per      LimitPer `json:"per"`
+	// ID is the IP address or auth token for LimitPerIPAddress and
+	// LimitPerAuthToken, and "total" for LimitPerTotal.
+	ID   string `json:"id"`
+	Used uint64 `json:"used"`
+
+	WindowStart time.Time `json:"window_start"`
+	WindowEnd   time.Time `json:"window_end"`
+}
+
+// UsageSink receives a UsageRecord each time a quota's window ends. It is
+// called synchronously, without holding any of the Limiter's locks, by either
+// the request that observes that the quota has expired or by the go routine
+// that removes expired quotas. A UsageSink that blocks will therefore delay
+// requests or the removal of expired quotas.
+type UsageSink func(UsageRecord)
+
+// ChannelUsageSink returns a UsageSink that sends each record to the provided
+// channel. Sends block until the record is received.
+func ChannelUsageSink(ch chan<- UsageRecord) UsageSink {
+	return func(r UsageRecord) {
+		ch <- r
+	}
+}
+
+// WriterUsageSink returns a UsageSink that writes each record to the provided
+// writer as a line of JSON. If a record cannot be written, onErr is called
+// with the error, if it is not nil.
+func WriterUsageSink(w io.Writer, onErr func(error)) UsageSink {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	return func(r UsageRecord) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := enc.Encode(r); err != nil && onErr != nil {
+			onErr(err)
+		}
+	}
+}
+
+// exportUsage sends each of the records to the sink, if there is one.
+func exportUsage(sink UsageSink, records []UsageRecord) {
+	if sink == nil {
+		return
+	}
+	for _, r := range records {
+		sink(r)
+	}
+}
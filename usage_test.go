@@ -0,0 +1,71 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChannelUsageSink(t *testing.T) {
+	ch := make(chan UsageRecord, 1)
+	sink := ChannelUsageSink(ch)
+
+	want := UsageRecord{Resource: "resource", Action: "action", Per: LimitPerTotal, ID: "total", Used: 1}
+	sink(want)
+	assert.Equal(t, want, <-ch)
+}
+
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) { return 0, errors.New("write failed") }
+
+func TestWriterUsageSink(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	want := []UsageRecord{
+		{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerIPAddress,
+			ID:          "127.0.0.1",
+			Used:        5,
+			WindowStart: start,
+			WindowEnd:   start.Add(time.Minute),
+		},
+		{
+			Resource:    "resource",
+			Action:      "action",
+			Per:         LimitPerTotal,
+			ID:          "total",
+			Used:        10,
+			WindowStart: start,
+			WindowEnd:   start.Add(time.Minute),
+		},
+	}
+
+	var buf bytes.Buffer
+	sink := WriterUsageSink(&buf, nil)
+	for _, r := range want {
+		sink(r)
+	}
+
+	dec := json.NewDecoder(&buf)
+	for _, w := range want {
+		var got UsageRecord
+		require.NoError(t, dec.Decode(&got))
+		assert.Equal(t, w, got)
+	}
+	assert.False(t, dec.More())
+
+	var gotErr error
+	sink = WriterUsageSink(errWriter{}, func(err error) { gotErr = err })
+	sink(want[0])
+	assert.Error(t, gotErr)
+}
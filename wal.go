@@ -0,0 +1,335 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// walOp is the type of a record in a write-ahead log.
+type walOp string
+
+const (
+	// walOpVersion is the first record in a log, and contains the
+	// SnapshotVersion that the log was written with.
+	walOpVersion walOp = "version"
+	// walOpSet sets the state of a quota. Set records are written when the
+	// log is compacted.
+	walOpSet walOp = "set"
+	// walOpConsume records that requests were consumed from a quota. The
+	// record's Used is the number of requests consumed.
+	walOpConsume walOp = "consume"
+	// walOpRefund records that requests were refunded to a quota. The
+	// record's Used is the number of requests refunded.
+	walOpRefund walOp = "refund"
+)
+
+// walRecord is a single line of a write-ahead log. The quota fields use the
+// same format as a Snapshot, and are omitted from version records.
+type walRecord struct {
+	Op      walOp `json:"op"`
+	Version int   `json:"version,omitempty"`
+	*SnapshotQuota
+}
+
+// writeAheadLog appends a record to a file each time requests are consumed
+// from a quota with a long period, so that the quotas can be rebuilt when the
+// process restarts. The log is periodically compacted by replacing it with
+// the current state of each quota.
+type writeAheadLog struct {
+	path            string
+	minPeriod       time.Duration
+	compactInterval time.Duration
+	limiter         *Limiter
+
+	// mu guards f, and is held while compacting so that records are not
+	// appended to a file that is being replaced.
+	mu sync.Mutex
+	f  *os.File
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// newWriteAheadLog replays the log at path, if it exists, to restore the
+// Limiter's quotas. The log is then compacted, and compacted again every
+// compactInterval.
+func newWriteAheadLog(l *Limiter, path string, minPeriod, compactInterval time.Duration) (*writeAheadLog, error) {
+	const op = "rate.newWriteAheadLog"
+
+	switch {
+	case minPeriod < 0:
+		return nil, fmt.Errorf("%s: min period must not be negative: %w", op, ErrInvalidParameter)
+	case compactInterval <= 0:
+		return nil, fmt.Errorf("%s: compact interval must be greater than zero: %w", op, ErrInvalidParameter)
+	}
+
+	w := &writeAheadLog{
+		path:            path,
+		minPeriod:       minPeriod,
+		compactInterval: compactInterval,
+		limiter:         l,
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+
+	f, err := os.Open(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		// nothing to replay
+	case err != nil:
+		return nil, fmt.Errorf("%s: %w", op, err)
+	default:
+		quotas, err := replayWriteAheadLog(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		var full *ErrLimiterFull
+		if err := l.restoreQuotas(quotas); err != nil && !errors.As(err, &full) {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	// Compacting opens the log for appending, and removes any partially
+	// written record that was left by a crash.
+	if err := w.compact(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	go w.run()
+	return w, nil
+}
+
+// replayWriteAheadLog reads the records from r and returns the resulting
+// state of each quota. Reading stops at the first record that cannot be
+// decoded, since it was only partially written.
+func replayWriteAheadLog(r io.Reader) ([]SnapshotQuota, error) {
+	type quotaID struct {
+		resource, action string
+		per              LimitPer
+		id               string
+	}
+
+	state := make(map[quotaID]SnapshotQuota)
+	var order []quotaID
+
+	scanner := bufio.NewScanner(r)
+	first := true
+	for scanner.Scan() {
+		var rec walRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			break
+		}
+		if first {
+			first = false
+			switch {
+			case rec.Op != walOpVersion, rec.Version <= 0:
+				return nil, fmt.Errorf("missing version: %w", ErrInvalidSnapshot)
+			case rec.Version > SnapshotVersion:
+				return nil, fmt.Errorf("version %d: %w", rec.Version, ErrUnsupportedSnapshotVersion)
+			}
+			continue
+		}
+
+		switch {
+		case rec.Op == walOpSet, rec.Op == walOpConsume, rec.Op == walOpRefund:
+			if rec.SnapshotQuota == nil {
+				continue
+			}
+		default:
+			// Unknown records are skipped so that logs written by newer
+			// releases can still be replayed.
+			continue
+		}
+
+		k := quotaID{rec.Resource, rec.Action, rec.Per, rec.ID}
+		cur, ok := state[k]
+		if rec.Op == walOpRefund {
+			// Refunds only apply to the window that the requests were
+			// consumed from.
+			if ok && cur.ExpiresAt.Equal(rec.ExpiresAt) {
+				if rec.Used > cur.Used {
+					rec.Used = cur.Used
+				}
+				cur.Used -= rec.Used
+				state[k] = cur
+			}
+			continue
+		}
+		if !ok {
+			order = append(order, k)
+		}
+		switch {
+		case rec.Op == walOpSet:
+			state[k] = *rec.SnapshotQuota
+		case ok && cur.ExpiresAt.Equal(rec.ExpiresAt):
+			cur.Used += rec.Used
+			state[k] = cur
+		case !ok, rec.ExpiresAt.After(cur.ExpiresAt):
+			// The quota was reset, so the requests were consumed from a
+			// new window.
+			state[k] = *rec.SnapshotQuota
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	quotas := make([]SnapshotQuota, 0, len(order))
+	for _, k := range order {
+		quotas = append(quotas, state[k])
+	}
+	return quotas, nil
+}
+
+// run compacts the log every w.compactInterval until the log is stopped.
+// Errors are ignored, since the log will be compacted again on the next
+// interval, and when the log is stopped.
+func (w *writeAheadLog) run() {
+	defer close(w.done)
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-w.limiter.clock.After(w.compactInterval):
+			_ = w.compact()
+		}
+	}
+}
+
+// consume appends a record for n requests consumed from the quota with the
+// provided id.
+func (w *writeAheadLog) consume(q *Quota, id string, n uint64) {
+	w.append(walOpConsume, q, id, n)
+}
+
+// refund appends a record for n requests refunded to the quota with the
+// provided id.
+func (w *writeAheadLog) refund(q *Quota, id string, n uint64) {
+	w.append(walOpRefund, q, id, n)
+}
+
+// append appends a record for n requests to the quota with the provided id,
+// if the period of the quota's limit is at least w.minPeriod. An error
+// writing the record is ignored, since the quota will be written the next
+// time the log is compacted.
+func (w *writeAheadLog) append(op walOp, q *Quota, id string, n uint64) {
+	if n == 0 || q.period() < w.minPeriod {
+		return
+	}
+	sq := q.snapshot(id)
+	sq.Used = n
+	sq.ExpiresAt = sq.ExpiresAt.UTC()
+	b, err := json.Marshal(walRecord{Op: op, SnapshotQuota: &sq})
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f != nil {
+		_, _ = w.f.Write(b)
+	}
+}
+
+// compact atomically replaces the log with a version record followed by a set
+// record for each quota, and then reopens it for appending. Requests that are
+// consumed while the log is being compacted may be counted twice when the log
+// is replayed.
+func (w *writeAheadLog) compact() error {
+	const op = "rate.(writeAheadLog).compact"
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(w.path), filepath.Base(w.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := w.writeState(tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := os.Rename(tmp.Name(), w.path); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if w.f != nil {
+		w.f.Close()
+	}
+	w.f = f
+	return nil
+}
+
+// writeState writes a version record followed by a set record for each quota
+// with a period of at least w.minPeriod.
+//
+// writeState should always be called by a function that first acquires a lock
+func (w *writeAheadLog) writeState(out io.Writer) error {
+	const op = "rate.(writeAheadLog).writeState"
+	if w.mu.TryLock() {
+		panic(fmt.Sprintf("%s: called without lock", op))
+	}
+
+	bw := bufio.NewWriter(out)
+	enc := json.NewEncoder(bw)
+	if err := enc.Encode(walRecord{Op: walOpVersion, Version: SnapshotVersion}); err != nil {
+		return err
+	}
+	policies := w.limiter.policies.Load()
+	for _, sq := range w.limiter.snapshotQuotas() {
+		sq := sq
+		_, ll, ok := policies.limited(sq.Resource, sq.Action, sq.Per)
+		if !ok || ll.Period < w.minPeriod {
+			continue
+		}
+		if err := enc.Encode(walRecord{Op: walOpSet, SnapshotQuota: &sq}); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// shutdown stops compacting the log periodically, compacts it a final time,
+// and closes it. Only the first call compacts the log.
+func (w *writeAheadLog) shutdown() error {
+	var err error
+	w.stopOnce.Do(func() {
+		close(w.stop)
+		<-w.done
+		err = w.compact()
+
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if w.f != nil {
+			w.f.Close()
+			w.f = nil
+		}
+	})
+	return err
+}
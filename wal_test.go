@@ -0,0 +1,220 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package rate
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_replayWriteAheadLog(t *testing.T) {
+	expiresAt := time.Date(2023, 1, 1, 0, 1, 0, 0, time.UTC)
+	later := expiresAt.Add(time.Minute)
+
+	cases := []struct {
+		name    string
+		log     string
+		want    []SnapshotQuota
+		wantErr error
+	}{
+		{
+			"empty",
+			"",
+			[]SnapshotQuota{},
+			nil,
+		},
+		{
+			"missing-version",
+			`{"op":"consume","resource":"resource","action":"action","per":"ip-address","id":"1","used":1,"expires_at":"2023-01-01T00:01:00Z"}`,
+			nil,
+			ErrInvalidSnapshot,
+		},
+		{
+			"newer-version",
+			`{"op":"version","version":2}`,
+			nil,
+			ErrUnsupportedSnapshotVersion,
+		},
+		{
+			"set-and-consume",
+			`{"op":"version","version":1}
+{"op":"set","resource":"resource","action":"action","per":"ip-address","id":"1","used":5,"expires_at":"2023-01-01T00:01:00Z"}
+{"op":"consume","resource":"resource","action":"action","per":"ip-address","id":"1","used":2,"expires_at":"2023-01-01T00:01:00Z"}
+{"op":"consume","resource":"resource","action":"action","per":"ip-address","id":"2","used":1,"expires_at":"2023-01-01T00:01:00Z"}
+`,
+			[]SnapshotQuota{
+				{Resource: "resource", Action: "action", Per: LimitPerIPAddress, ID: "1", Used: 7, ExpiresAt: expiresAt},
+				{Resource: "resource", Action: "action", Per: LimitPerIPAddress, ID: "2", Used: 1, ExpiresAt: expiresAt},
+			},
+			nil,
+		},
+		{
+			"new-window",
+			`{"op":"version","version":1}
+{"op":"consume","resource":"resource","action":"action","per":"ip-address","id":"1","used":5,"expires_at":"2023-01-01T00:01:00Z"}
+{"op":"consume","resource":"resource","action":"action","per":"ip-address","id":"1","used":1,"expires_at":"2023-01-01T00:02:00Z"}
+`,
+			[]SnapshotQuota{
+				{Resource: "resource", Action: "action", Per: LimitPerIPAddress, ID: "1", Used: 1, ExpiresAt: later},
+			},
+			nil,
+		},
+		{
+			"refund",
+			`{"op":"version","version":1}
+{"op":"consume","resource":"resource","action":"action","per":"ip-address","id":"1","used":5,"expires_at":"2023-01-01T00:01:00Z"}
+{"op":"refund","resource":"resource","action":"action","per":"ip-address","id":"1","used":2,"expires_at":"2023-01-01T00:01:00Z"}
+{"op":"refund","resource":"resource","action":"action","per":"ip-address","id":"1","used":1,"expires_at":"2023-01-01T00:02:00Z"}
+{"op":"refund","resource":"resource","action":"action","per":"ip-address","id":"2","used":1,"expires_at":"2023-01-01T00:01:00Z"}
+{"op":"refund","resource":"resource","action":"action","per":"ip-address","id":"1","used":9,"expires_at":"2023-01-01T00:01:00Z"}
+{"op":"consume","resource":"resource","action":"action","per":"ip-address","id":"1","used":1,"expires_at":"2023-01-01T00:01:00Z"}
+`,
+			[]SnapshotQuota{
+				{Resource: "resource", Action: "action", Per: LimitPerIPAddress, ID: "1", Used: 1, ExpiresAt: expiresAt},
+			},
+			nil,
+		},
+		{
+			"unknown-op",
+			`{"op":"version","version":1,"future":true}
+{"op":"future","resource":"resource","action":"action","per":"ip-address","id":"2","used":1}
+{"op":"consume","resource":"resource","action":"action","per":"ip-address","id":"1","used":1,"expires_at":"2023-01-01T00:01:00Z"}
+`,
+			[]SnapshotQuota{
+				{Resource: "resource", Action: "action", Per: LimitPerIPAddress, ID: "1", Used: 1, ExpiresAt: expiresAt},
+			},
+			nil,
+		},
+		{
+			"partial-record",
+			`{"op":"version","version":1}
+{"op":"consume","resource":"resource","action":"action","per":"ip-address","id":"1","used":1,"expires_at":"2023-01-01T00:01:00Z"}
+{"op":"consume","resource":"reso`,
+			[]SnapshotQuota{
+				{Resource: "resource", Action: "action", Per: LimitPerIPAddress, ID: "1", Used: 1, ExpiresAt: expiresAt},
+			},
+			nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := replayWriteAheadLog(strings.NewReader(tc.log))
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestLimiterWriteAheadLog(t *testing.T) {
+	readLines := func(t *testing.T, path string) []string {
+		t.Helper()
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		defer f.Close()
+		var lines []string
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		require.NoError(t, scanner.Err())
+		return lines
+	}
+
+	t.Run("crash", func(t *testing.T) {
+		c := newFakeClock()
+		dir := t.TempDir()
+		path := filepath.Join(dir, "quotas.wal")
+
+		l, err := NewLimiter(snapshotTestLimits(), 10, WithClock(c), WithWriteAheadLog(path, 0, time.Hour))
+		require.NoError(t, err)
+		t.Cleanup(func() { l.Shutdown() })
+		for i := 0; i < 3; i++ {
+			_, _, err := l.Allow("resource", "action", "127.0.0.1", "")
+			require.NoError(t, err)
+		}
+
+		// Copy the log without shutting down the Limiter, as if the process
+		// had exited.
+		lines := readLines(t, path)
+		assert.Len(t, lines, 7, "expected a version record and a consume record for each quota of each request")
+		crashed := filepath.Join(dir, "crashed.wal")
+		require.NoError(t, os.WriteFile(crashed, []byte(strings.Join(lines, "\n")+"\n"), 0o600))
+
+		c.Advance(10 * time.Second)
+
+		restored, err := NewLimiter(snapshotTestLimits(), 10, WithClock(c), WithWriteAheadLog(crashed, 0, time.Hour))
+		require.NoError(t, err)
+		t.Cleanup(func() { restored.Shutdown() })
+
+		_, quota, err := restored.Allow("resource", "action", "127.0.0.1", "")
+		require.NoError(t, err)
+		assert.Equal(t, uint64(6), quota.Remaining())
+		assert.Equal(t, 50*time.Second, quota.ResetsIn())
+
+		// The log was compacted when it was replayed.
+		lines = readLines(t, crashed)
+		require.Len(t, lines, 5)
+		assert.Contains(t, lines[1], `"op":"set"`)
+	})
+
+	t.Run("compact", func(t *testing.T) {
+		c := newFakeClock()
+		path := filepath.Join(t.TempDir(), "quotas.wal")
+
+		l, err := NewLimiter(snapshotTestLimits(), 10, WithClock(c), WithWriteAheadLog(path, 0, 10*time.Second))
+		require.NoError(t, err)
+		t.Cleanup(func() { l.Shutdown() })
+		for i := 0; i < 3; i++ {
+			_, _, err := l.Allow("resource", "action", "127.0.0.1", "")
+			require.NoError(t, err)
+		}
+		require.Len(t, readLines(t, path), 7)
+
+		// Wait for both the delete and compaction go routines.
+		require.Eventually(t, func() bool { return c.Waiters() == 2 }, time.Second, time.Millisecond)
+		c.Advance(10 * time.Second)
+		require.Eventually(t, func() bool { return len(readLines(t, path)) == 3 }, time.Second, time.Millisecond)
+
+		// Records are appended to the compacted log.
+		_, _, err = l.Allow("resource", "action", "127.0.0.1", "")
+		require.NoError(t, err)
+		assert.Len(t, readLines(t, path), 5)
+
+		require.NoError(t, l.Shutdown())
+		require.NoError(t, l.Shutdown(), "shutdown should be idempotent")
+		assert.Len(t, readLines(t, path), 3)
+	})
+
+	t.Run("min-period", func(t *testing.T) {
+		c := newFakeClock()
+		path := filepath.Join(t.TempDir(), "quotas.wal")
+
+		l, err := NewLimiter(snapshotTestLimits(), 10, WithClock(c), WithWriteAheadLog(path, 2*time.Minute, time.Hour))
+		require.NoError(t, err)
+		_, _, err = l.Allow("resource", "action", "127.0.0.1", "")
+		require.NoError(t, err)
+		require.NoError(t, l.Shutdown())
+		assert.Equal(t, []string{`{"op":"version","version":1}`}, readLines(t, path))
+	})
+
+	t.Run("invalid-parameters", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "quotas.wal")
+		_, err := NewLimiter(snapshotTestLimits(), 10, WithWriteAheadLog(path, -time.Minute, time.Hour))
+		assert.ErrorIs(t, err, ErrInvalidParameter)
+		_, err = NewLimiter(snapshotTestLimits(), 10, WithWriteAheadLog(path, 0, 0))
+		assert.ErrorIs(t, err, ErrInvalidParameter)
+	})
+}